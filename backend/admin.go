@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -31,11 +33,17 @@ func AdminProviderEnableHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := providerRegistry.EnableProvider(providerName)
+	wasEnabled, err := providerRegistry.IsProviderEnabled(providerName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if err := providerRegistry.EnableProvider(providerName); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	recordAudit(r, "enable_provider", providerName,
+		map[string]interface{}{"enabled": wasEnabled}, map[string]interface{}{"enabled": true})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -58,11 +66,17 @@ func AdminProviderDisableHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := providerRegistry.DisableProvider(providerName)
+	wasEnabled, err := providerRegistry.IsProviderEnabled(providerName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if err := providerRegistry.DisableProvider(providerName); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	recordAudit(r, "disable_provider", providerName,
+		map[string]interface{}{"enabled": wasEnabled}, map[string]interface{}{"enabled": false})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -92,19 +106,67 @@ func AdminCircuitBreakerResetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if config.CircuitBreaker != nil {
+		before := map[string]interface{}{"state": config.CircuitBreaker.GetState().String()}
 		config.CircuitBreaker.Reset()
+		recordAudit(r, "reset_circuit_breaker", providerName,
+			before, map[string]interface{}{"state": config.CircuitBreaker.GetState().String()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "Circuit breaker reset successfully",
+		"provider": providerName,
+	})
+}
+
+// maxForcedOpenDuration bounds how long an operator can hold a circuit open
+const maxForcedOpenDuration = 24 * time.Hour
+
+// AdminCircuitBreakerOpenHandler forces a provider's circuit breaker OPEN for a duration,
+// taking it out of rotation: POST /admin/circuit-breaker/open?provider=X&duration=30m
+func AdminCircuitBreakerOpenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providerName := r.URL.Query().Get("provider")
+	if providerName == "" {
+		http.Error(w, "Provider name required", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 || duration > maxForcedOpenDuration {
+		http.Error(w, fmt.Sprintf("duration must be a positive duration up to %v, e.g. 30m", maxForcedOpenDuration), http.StatusBadRequest)
+		return
 	}
 
-	appLogger.Info("Circuit breaker reset", map[string]interface{}{
-		"provider":     providerName,
-		"admin_action": "reset_circuit_breaker",
+	config, err := providerRegistry.GetPaymentProvider(providerName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if config.CircuitBreaker == nil {
+		http.Error(w, "Provider has no circuit breaker", http.StatusConflict)
+		return
+	}
+
+	before := map[string]interface{}{"state": config.CircuitBreaker.GetState().String()}
+	until := config.CircuitBreaker.ForceOpen(duration)
+	recordAudit(r, "force_open_circuit_breaker", providerName, before, map[string]interface{}{
+		"state":    config.CircuitBreaker.GetState().String(),
+		"duration": duration.String(),
+		"until":    until.Format(time.RFC3339),
 	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":  true,
-		"message":  "Circuit breaker reset successfully",
+		"message":  "Circuit breaker forced open",
 		"provider": providerName,
+		"until":    until.Format(time.RFC3339),
 	})
 }
 
@@ -173,3 +235,446 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 func getCurrentTimeString() string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
+
+// AdminRoutingStrategyHandler returns the active routing strategy (GET) or switches it (PUT)
+func AdminRoutingStrategyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(providerSelector.Parameters())
+
+	case http.MethodPut:
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		strategy, err := ParseRoutingStrategy(req.Strategy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		before := providerSelector.Strategy()
+		providerSelector.SetStrategy(strategy)
+		recordAudit(r, "update_routing_strategy", "routing_strategy", before, strategy)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"message":    "Routing strategy updated",
+			"parameters": providerSelector.Parameters(),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminRoutingSimulateHandler previews how a hypothetical payment would be routed
+// under the current rules and strategy, without sending anything to a provider
+func AdminRoutingSimulateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "A positive amount is required", http.StatusBadRequest)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = req.ID
+	}
+
+	ranked, rule, err := providerSelector.RankProviders(r.Context(), &req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"strategy":  providerSelector.Strategy(),
+			"providers": []interface{}{},
+			"selected":  nil,
+			"error":     err.Error(),
+		})
+		return
+	}
+
+	providers := make([]map[string]interface{}, 0, len(ranked))
+	for i, config := range ranked {
+		providers = append(providers, map[string]interface{}{
+			"rank":           i + 1,
+			"name":           config.Provider.Name(),
+			"priority":       config.Priority,
+			"health_score":   providerSelector.calculateHealthScore(config),
+			"success_rate":   providerSelector.getProviderSuccessRate(config),
+			"latency_p95_ms": providerSelector.getProviderLatencyP95(config),
+			"expected_cost":  providerSelector.ExpectedCost(config, &req),
+			"circuit_state":  config.CircuitBreaker.GetState().String(),
+			"rate_limited":   providerSelector.IsRateLimited(config.Provider.Name()),
+		})
+	}
+
+	result := map[string]interface{}{
+		"strategy":     providerSelector.Strategy(),
+		"matched_rule": rule,
+		"providers":    providers,
+		"selected":     ranked[0].Provider.Name(),
+		"reason":       providerSelector.GetRoutingReason(ranked[0], &req),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// retryPolicyPayload is the JSON form of a RetryConfig
+type retryPolicyPayload struct {
+	MaxAttempts       int     `json:"max_attempts"`
+	BaseDelayMs       int64   `json:"base_delay_ms"`
+	MaxDelayMs        int64   `json:"max_delay_ms"`
+	JitterFactor      float64 `json:"jitter_factor"`
+	RetryableStatuses []int   `json:"retryable_statuses"`
+}
+
+func newRetryPolicyPayload(config RetryConfig) retryPolicyPayload {
+	return retryPolicyPayload{
+		MaxAttempts:       config.MaxAttempts,
+		BaseDelayMs:       config.BaseDelay.Milliseconds(),
+		MaxDelayMs:        config.MaxDelay.Milliseconds(),
+		JitterFactor:      config.JitterFactor,
+		RetryableStatuses: config.RetryableStatuses,
+	}
+}
+
+// AdminRetryPolicyHandler returns (GET) or replaces (PUT) a provider's retry policy
+func AdminRetryPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !providerRegistry.HasPaymentProvider(name) {
+		http.Error(w, fmt.Sprintf("provider '%s' not found", name), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"provider":     name,
+			"retry_policy": newRetryPolicyPayload(providerRegistry.GetRetryPolicy(name)),
+		})
+
+	case http.MethodPut:
+		var payload retryPolicyPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if payload.MaxAttempts < 1 || payload.MaxAttempts > 10 {
+			http.Error(w, "max_attempts must be between 1 and 10", http.StatusBadRequest)
+			return
+		}
+		if payload.BaseDelayMs < 0 || payload.MaxDelayMs < payload.BaseDelayMs {
+			http.Error(w, "delays must satisfy 0 <= base_delay_ms <= max_delay_ms", http.StatusBadRequest)
+			return
+		}
+		if payload.JitterFactor < 0 || payload.JitterFactor > 1 {
+			http.Error(w, "jitter_factor must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		for _, status := range payload.RetryableStatuses {
+			if status < 400 || status > 599 {
+				http.Error(w, fmt.Sprintf("invalid retryable status %d", status), http.StatusBadRequest)
+				return
+			}
+		}
+
+		policy := RetryConfig{
+			MaxAttempts:       payload.MaxAttempts,
+			BaseDelay:         time.Duration(payload.BaseDelayMs) * time.Millisecond,
+			MaxDelay:          time.Duration(payload.MaxDelayMs) * time.Millisecond,
+			JitterFactor:      payload.JitterFactor,
+			RetryableStatuses: payload.RetryableStatuses,
+		}
+		before := newRetryPolicyPayload(providerRegistry.GetRetryPolicy(name))
+		if err := providerRegistry.SetRetryPolicy(name, policy); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		recordAudit(r, "update_retry_policy", name, before, newRetryPolicyPayload(policy))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"message":      "Retry policy updated",
+			"provider":     name,
+			"retry_policy": newRetryPolicyPayload(policy),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminProviderEgressHandler returns (GET) or replaces (PUT) a provider's egress proxy
+// and TLS settings
+func AdminProviderEgressHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		egress, err := providerRegistry.GetEgress(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"provider": name,
+			"egress":   egress,
+		})
+
+	case http.MethodPut:
+		if !providerRegistry.HasPaymentProvider(name) {
+			http.Error(w, fmt.Sprintf("provider '%s' not found", name), http.StatusNotFound)
+			return
+		}
+
+		var egress EgressConfig
+		if err := json.NewDecoder(r.Body).Decode(&egress); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		before, _ := providerRegistry.GetEgress(name)
+		if err := providerRegistry.SetEgress(name, egress); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recordAudit(r, "update_provider_egress", name, before, egress)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"message":  "Egress settings updated",
+			"provider": name,
+			"egress":   egress,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// scoringConfigPayload is the JSON form of a ScoringConfig, durations in milliseconds
+type scoringConfigPayload struct {
+	BaseScore              float64 `json:"base_score"`
+	LatencyThresholdLowMs  int64   `json:"latency_threshold_low_ms"`
+	LatencyThresholdMedMs  int64   `json:"latency_threshold_med_ms"`
+	LatencyThresholdHighMs int64   `json:"latency_threshold_high_ms"`
+	LatencyPenaltyLow      float64 `json:"latency_penalty_low"`
+	LatencyPenaltyMed      float64 `json:"latency_penalty_med"`
+	LatencyPenaltyHigh     float64 `json:"latency_penalty_high"`
+	LatencyDecayShort      float64 `json:"latency_decay_short"`
+	LatencyDecayLong       float64 `json:"latency_decay_long"`
+	GatewayErrorPenalty    float64 `json:"gateway_error_penalty"`
+	BankErrorPenalty       float64 `json:"bank_error_penalty"`
+	NetworkErrorPenalty    float64 `json:"network_error_penalty"`
+	ClientErrorPenalty     float64 `json:"client_error_penalty"`
+	HighLoadThreshold      int     `json:"high_load_threshold"`
+	LoadPenalty            float64 `json:"load_penalty"`
+	ErrorDecayWindowMs     int64   `json:"error_decay_window_ms"`
+	RecoveryRate           float64 `json:"recovery_rate"`
+	MinScore               float64 `json:"min_score"`
+	MaxScore               float64 `json:"max_score"`
+	ScoreUpdatePeriodMs    int64   `json:"score_update_period_ms"`
+	SlowStartWindowMs      int64   `json:"slow_start_window_ms"`
+	SlowStartRequests      int     `json:"slow_start_requests"`
+	SlowStartMinWeight     float64 `json:"slow_start_min_weight"`
+	SelectionMode          string  `json:"selection_mode"`
+	MinSelectionScore      float64 `json:"min_selection_score"`
+}
+
+func newScoringConfigPayload(config *ScoringConfig) scoringConfigPayload {
+	return scoringConfigPayload{
+		BaseScore:              config.BaseScore,
+		LatencyThresholdLowMs:  config.LatencyThresholdLow.Milliseconds(),
+		LatencyThresholdMedMs:  config.LatencyThresholdMed.Milliseconds(),
+		LatencyThresholdHighMs: config.LatencyThresholdHigh.Milliseconds(),
+		LatencyPenaltyLow:      config.LatencyPenaltyLow,
+		LatencyPenaltyMed:      config.LatencyPenaltyMed,
+		LatencyPenaltyHigh:     config.LatencyPenaltyHigh,
+		LatencyDecayShort:      config.LatencyDecayShort,
+		LatencyDecayLong:       config.LatencyDecayLong,
+		GatewayErrorPenalty:    config.GatewayErrorPenalty,
+		BankErrorPenalty:       config.BankErrorPenalty,
+		NetworkErrorPenalty:    config.NetworkErrorPenalty,
+		ClientErrorPenalty:     config.ClientErrorPenalty,
+		HighLoadThreshold:      config.HighLoadThreshold,
+		LoadPenalty:            config.LoadPenalty,
+		ErrorDecayWindowMs:     config.ErrorDecayWindow.Milliseconds(),
+		RecoveryRate:           config.RecoveryRate,
+		MinScore:               config.MinScore,
+		MaxScore:               config.MaxScore,
+		ScoreUpdatePeriodMs:    config.ScoreUpdatePeriod.Milliseconds(),
+		SlowStartWindowMs:      config.SlowStartWindow.Milliseconds(),
+		SlowStartRequests:      config.SlowStartRequests,
+		SlowStartMinWeight:     config.SlowStartMinWeight,
+		SelectionMode:          string(config.SelectionMode),
+		MinSelectionScore:      config.MinSelectionScore,
+	}
+}
+
+// validate checks the payload describes a usable scoring config
+func (p scoringConfigPayload) validate() error {
+	if p.LatencyThresholdLowMs <= 0 || p.LatencyThresholdLowMs >= p.LatencyThresholdMedMs || p.LatencyThresholdMedMs >= p.LatencyThresholdHighMs {
+		return errors.New("latency thresholds must satisfy 0 < low < med < high")
+	}
+	for name, penalty := range map[string]float64{
+		"latency_penalty_low":   p.LatencyPenaltyLow,
+		"latency_penalty_med":   p.LatencyPenaltyMed,
+		"latency_penalty_high":  p.LatencyPenaltyHigh,
+		"gateway_error_penalty": p.GatewayErrorPenalty,
+		"bank_error_penalty":    p.BankErrorPenalty,
+		"network_error_penalty": p.NetworkErrorPenalty,
+		"client_error_penalty":  p.ClientErrorPenalty,
+		"load_penalty":          p.LoadPenalty,
+		"recovery_rate":         p.RecoveryRate,
+	} {
+		if penalty < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if p.LatencyDecayShort <= 0 || p.LatencyDecayShort > 1 || p.LatencyDecayLong <= 0 || p.LatencyDecayLong > 1 {
+		return errors.New("latency decays must be in (0, 1]")
+	}
+	if p.MinScore >= p.MaxScore {
+		return errors.New("min_score must be below max_score")
+	}
+	if p.BaseScore < p.MinScore || p.BaseScore > p.MaxScore {
+		return errors.New("base_score must be between min_score and max_score")
+	}
+	if p.HighLoadThreshold < 1 {
+		return errors.New("high_load_threshold must be at least 1")
+	}
+	if p.ErrorDecayWindowMs <= 0 {
+		return errors.New("error_decay_window_ms must be positive")
+	}
+	if p.ScoreUpdatePeriodMs < 1000 {
+		return errors.New("score_update_period_ms must be at least 1000")
+	}
+	if p.SlowStartWindowMs < 0 || p.SlowStartRequests < 0 {
+		return errors.New("slow_start_window_ms and slow_start_requests must not be negative")
+	}
+	if p.SlowStartMinWeight <= 0 || p.SlowStartMinWeight > 1 {
+		return errors.New("slow_start_min_weight must be in (0, 1]")
+	}
+	if _, err := ParseSelectionMode(p.SelectionMode); err != nil {
+		return err
+	}
+	if p.MinSelectionScore < p.MinScore || p.MinSelectionScore > p.MaxScore {
+		return errors.New("min_selection_score must be between min_score and max_score")
+	}
+	return nil
+}
+
+func (p scoringConfigPayload) toConfig() *ScoringConfig {
+	return &ScoringConfig{
+		BaseScore:            p.BaseScore,
+		LatencyThresholdLow:  time.Duration(p.LatencyThresholdLowMs) * time.Millisecond,
+		LatencyThresholdMed:  time.Duration(p.LatencyThresholdMedMs) * time.Millisecond,
+		LatencyThresholdHigh: time.Duration(p.LatencyThresholdHighMs) * time.Millisecond,
+		LatencyPenaltyLow:    p.LatencyPenaltyLow,
+		LatencyPenaltyMed:    p.LatencyPenaltyMed,
+		LatencyPenaltyHigh:   p.LatencyPenaltyHigh,
+		LatencyDecayShort:    p.LatencyDecayShort,
+		LatencyDecayLong:     p.LatencyDecayLong,
+		GatewayErrorPenalty:  p.GatewayErrorPenalty,
+		BankErrorPenalty:     p.BankErrorPenalty,
+		NetworkErrorPenalty:  p.NetworkErrorPenalty,
+		ClientErrorPenalty:   p.ClientErrorPenalty,
+		HighLoadThreshold:    p.HighLoadThreshold,
+		LoadPenalty:          p.LoadPenalty,
+		ErrorDecayWindow:     time.Duration(p.ErrorDecayWindowMs) * time.Millisecond,
+		RecoveryRate:         p.RecoveryRate,
+		MinScore:             p.MinScore,
+		MaxScore:             p.MaxScore,
+		ScoreUpdatePeriod:    time.Duration(p.ScoreUpdatePeriodMs) * time.Millisecond,
+		SlowStartWindow:      time.Duration(p.SlowStartWindowMs) * time.Millisecond,
+		SlowStartRequests:    p.SlowStartRequests,
+		SlowStartMinWeight:   p.SlowStartMinWeight,
+		SelectionMode:        SelectionMode(p.SelectionMode),
+		MinSelectionScore:    p.MinSelectionScore,
+	}
+}
+
+// diffScoringConfig returns the fields that differ between two payloads, keyed by
+// JSON name with their old and new values
+func diffScoringConfig(from, to scoringConfigPayload) map[string]interface{} {
+	var fromFields, toFields map[string]interface{}
+	fromJSON, _ := json.Marshal(from)
+	toJSON, _ := json.Marshal(to)
+	json.Unmarshal(fromJSON, &fromFields)
+	json.Unmarshal(toJSON, &toFields)
+
+	changes := make(map[string]interface{})
+	for field, value := range toFields {
+		if fromFields[field] != value {
+			changes[field] = map[string]interface{}{"from": fromFields[field], "to": value}
+		}
+	}
+	return changes
+}
+
+// AdminScoringConfigHandler returns (GET) or updates (PUT) the server scoring config.
+// PUT bodies may be partial, omitted fields keep their current value
+func AdminScoringConfigHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"scoring_config": newScoringConfigPayload(serverPool.Config()),
+			"changes":        serverPool.ConfigChanges(),
+		})
+
+	case http.MethodPut:
+		current := newScoringConfigPayload(serverPool.Config())
+		payload := current
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := payload.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		change := ScoringConfigChange{
+			ChangedBy: adminActor(r),
+			ChangedAt: time.Now(),
+			Changes:   diffScoringConfig(current, payload),
+		}
+		if len(change.Changes) > 0 {
+			serverPool.SetConfig(payload.toConfig(), change)
+			recordAudit(r, "update_scoring_config", "scoring_config", current, payload)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":        true,
+			"message":        "Scoring config updated",
+			"scoring_config": payload,
+			"changes":        change.Changes,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
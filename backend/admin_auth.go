@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AdminRole is an admin user's access level. Each role includes everything the lower
+// roles can do
+type AdminRole int
+
+const (
+	AdminRoleNone AdminRole = iota
+	AdminRoleViewer
+	AdminRoleOperator
+	AdminRoleAdmin
+)
+
+func (r AdminRole) String() string {
+	switch r {
+	case AdminRoleViewer:
+		return "viewer"
+	case AdminRoleOperator:
+		return "operator"
+	case AdminRoleAdmin:
+		return "admin"
+	default:
+		return ""
+	}
+}
+
+// ParseAdminRole parses a role name
+func ParseAdminRole(s string) (AdminRole, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return AdminRoleViewer, nil
+	case "operator":
+		return AdminRoleOperator, nil
+	case "admin":
+		return AdminRoleAdmin, nil
+	default:
+		return AdminRoleNone, fmt.Errorf("unknown admin role %q (want viewer, operator or admin)", s)
+	}
+}
+
+// adminRoles maps user names to their admin role, from ADMIN_ROLES. Users not
+// listed get no admin access
+var adminRoles = map[string]AdminRole{}
+
+// AdminRolesFromEnv parses ADMIN_ROLES, a comma separated list of user:role pairs,
+// e.g. "alice:admin,bob:operator"
+func AdminRolesFromEnv() (map[string]AdminRole, error) {
+	roles := make(map[string]AdminRole)
+	v := os.Getenv("ADMIN_ROLES")
+	if v == "" {
+		return roles, nil
+	}
+
+	for _, entry := range strings.Split(v, ",") {
+		name, roleName, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("ADMIN_ROLES entry %q must be user:role", entry)
+		}
+		role, err := ParseAdminRole(roleName)
+		if err != nil {
+			return nil, err
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+// adminRoleForUser returns the admin role assigned to a user, AdminRoleNone if any
+func adminRoleForUser(name string) AdminRole {
+	return adminRoles[name]
+}
+
+// destructiveAdminRoutes are the admin mutations reserved for the admin role: they
+// take providers out of rotation or discard breaker state
+var destructiveAdminRoutes = map[string]bool{
+	"/admin/providers/disable":     true,
+	"/admin/circuit-breaker/reset": true,
+	"/admin/circuit-breaker/open":  true,
+	"/admin/vault/rotate":          true,
+}
+
+// readOnlyAdminRoutes are admin POSTs that don't change anything
+var readOnlyAdminRoutes = map[string]bool{
+	"/admin/routing/simulate": true,
+}
+
+// requiredAdminRole returns the role needed for an admin request: viewers may read,
+// operators may change settings, and destructive actions need admin
+func requiredAdminRole(r *http.Request) AdminRole {
+	if destructiveAdminRoutes[r.URL.Path] || r.Method == http.MethodDelete {
+		return AdminRoleAdmin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || readOnlyAdminRoutes[r.URL.Path] {
+		return AdminRoleViewer
+	}
+	return AdminRoleOperator
+}
+
+var (
+	ErrMissingAdminToken = errors.New("missing bearer token")
+	ErrInvalidAdminToken = errors.New("invalid bearer token")
+)
+
+// parseAdminToken validates a bearer token issued by GenerateToken and returns its claims
+func parseAdminToken(r *http.Request) (*Claims, error) {
+	header := r.Header.Get("Authorization")
+	tokenString, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || tokenString == "" {
+		return nil, ErrMissingAdminToken
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidAdminToken
+	}
+	return claims, nil
+}
+
+// AdminAuthMiddleware protects /admin/* with JWT bearer tokens. The token's role
+// must be at least the one requiredAdminRole asks for; other paths pass through
+func AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin" && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(jwtSecret) == 0 {
+			http.Error(w, "Admin authentication not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		claims, err := parseAdminToken(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		role, _ := ParseAdminRole(claims.Role)
+		required := requiredAdminRole(r)
+		if role < required {
+			log.Printf("[AdminAuth] Denied %s %s for %s (role %q, needs %s)", r.Method, r.URL.Path, claims.Subject, claims.Role, required)
+			http.Error(w, fmt.Sprintf("Forbidden: requires %s role", required), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "admin_subject", claims.Subject)
+		ctx = context.WithValue(ctx, "admin_role", role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AMLConfig holds the triggers that send a transaction to AML screening. A
+// transaction is screened when any trigger matches
+type AMLConfig struct {
+	AmountThreshold   int64           // Screen payments at or above this amount, in cents
+	VelocityCount     int             // Screen a user's payments beyond this many per window, 0 disables
+	VelocityWindow    time.Duration   // Window the velocity count applies to
+	HighRiskCountries map[string]bool // Screen payments from these ISO country codes
+	Timeout           time.Duration   // How long a screening may take before the payment is held
+}
+
+// DefaultAMLConfig returns sensible defaults
+func DefaultAMLConfig() AMLConfig {
+	return AMLConfig{
+		AmountThreshold:   500000, // $5,000
+		VelocityCount:     5,
+		VelocityWindow:    time.Hour,
+		HighRiskCountries: make(map[string]bool),
+		Timeout:           10 * time.Second,
+	}
+}
+
+// AMLConfigFromEnv builds the AML trigger config from AML_* environment variables,
+// falling back to the defaults
+func AMLConfigFromEnv() (AMLConfig, error) {
+	config := DefaultAMLConfig()
+
+	if v := os.Getenv("AML_AMOUNT_THRESHOLD"); v != "" {
+		amount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || amount <= 0 {
+			return config, fmt.Errorf("AML_AMOUNT_THRESHOLD must be a positive integer, got %q", v)
+		}
+		config.AmountThreshold = amount
+	}
+	if v := os.Getenv("AML_VELOCITY_COUNT"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 0 {
+			return config, fmt.Errorf("AML_VELOCITY_COUNT must be a non-negative integer, got %q", v)
+		}
+		config.VelocityCount = count
+	}
+	if v := os.Getenv("AML_VELOCITY_WINDOW_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			return config, fmt.Errorf("AML_VELOCITY_WINDOW_MINUTES must be a positive integer, got %q", v)
+		}
+		config.VelocityWindow = time.Duration(minutes) * time.Minute
+	}
+	if v := os.Getenv("AML_HIGH_RISK_COUNTRIES"); v != "" {
+		for _, country := range strings.Split(v, ",") {
+			country = strings.ToUpper(strings.TrimSpace(country))
+			if len(country) != 2 {
+				return config, fmt.Errorf("AML_HIGH_RISK_COUNTRIES entries must be ISO 3166 alpha-2 codes, got %q", country)
+			}
+			config.HighRiskCountries[country] = true
+		}
+	}
+	if v := os.Getenv("AML_TIMEOUT_MS"); v != "" {
+		timeoutMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || timeoutMs <= 0 {
+			return config, fmt.Errorf("AML_TIMEOUT_MS must be a positive integer, got %q", v)
+		}
+		config.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	return config, nil
+}
+
+// amlScreening is one in-flight transaction screening
+type amlScreening struct {
+	done     chan struct{}
+	resp     *ComplianceCheckResponse
+	err      error
+	triggers []string
+}
+
+// AMLScreener screens transactions alongside their authorization. Screening starts
+// when the payment is accepted and the result is collected once the gateway has
+// approved it, so the check adds no latency unless it is slower than the gateway
+type AMLScreener struct {
+	config  AMLConfig
+	pending map[string]*amlScreening
+	mu      sync.Mutex
+}
+
+// amlScreener screens payments that match the AML triggers
+var amlScreener *AMLScreener
+
+// NewAMLScreener creates a screener with the given triggers
+func NewAMLScreener(config AMLConfig) *AMLScreener {
+	return &AMLScreener{config: config, pending: make(map[string]*amlScreening)}
+}
+
+func amlVelocityKey(namespace, userID string) string {
+	return namespace + "aml_velocity:" + userID
+}
+
+// Triggers counts the payment towards the user's velocity, kept apart for test-mode
+// payments, and returns the triggers it matches, empty when it doesn't need screening
+func (s *AMLScreener) Triggers(ctx context.Context, paymentID, userID string, amount int64, country string) []string {
+	var triggers []string
+	if amount >= s.config.AmountThreshold {
+		triggers = append(triggers, "amount")
+	}
+	if country != "" && s.config.HighRiskCountries[strings.ToUpper(country)] {
+		triggers = append(triggers, "country")
+	}
+	if userID != "" && s.config.VelocityCount > 0 {
+		key := amlVelocityKey(paymentNamespace(paymentID), userID)
+		count, err := rdb.Incr(ctx, key).Result()
+		if err != nil {
+			log.Printf("[AML] Failed to count velocity for %s: %v", userID, err)
+		} else {
+			if count == 1 {
+				rdb.Expire(ctx, key, s.config.VelocityWindow)
+			}
+			if count > int64(s.config.VelocityCount) {
+				triggers = append(triggers, "velocity")
+			}
+		}
+	}
+	return triggers
+}
+
+// Start screens a payment in the background
+func (s *AMLScreener) Start(paymentID, correlationID string, req *PaymentRequest, triggers []string) {
+	screening := &amlScreening{done: make(chan struct{}), triggers: triggers}
+	s.mu.Lock()
+	s.pending[paymentID] = screening
+	s.mu.Unlock()
+
+	go func() {
+		defer close(screening.done)
+
+		screenCtx, cancel := context.WithTimeout(withTraceIDs(context.Background(), correlationID, paymentID), s.config.Timeout)
+		defer cancel()
+		screening.resp, screening.err = providerRegistry.PerformComplianceCheck(screenCtx, &ComplianceCheckRequest{
+			UserID:    req.UserID,
+			CheckType: ComplianceCheckAML,
+			DocumentData: map[string]interface{}{
+				"payment_id": paymentID,
+				"amount":     req.Amount,
+				"currency":   req.Currency,
+				"country":    req.Country,
+				"triggers":   triggers,
+			},
+			IdempotencyKey: paymentID + "_aml",
+		})
+	}()
+}
+
+// Verdict waits for a payment's screening and reports whether the payment must be
+// held for review, and why. Payments that weren't screened are never held. A
+// screening that fails or times out holds the payment rather than letting it through
+func (s *AMLScreener) Verdict(paymentID string) (bool, string) {
+	s.mu.Lock()
+	screening, exists := s.pending[paymentID]
+	delete(s.pending, paymentID)
+	s.mu.Unlock()
+	if !exists {
+		return false, ""
+	}
+
+	<-screening.done
+	triggers := strings.Join(screening.triggers, ", ")
+	switch {
+	case screening.err != nil:
+		return true, fmt.Sprintf("AML screening (%s) failed: %v", triggers, screening.err)
+	case screening.resp.Status == ComplianceStatusApproved:
+		return false, ""
+	case screening.resp.Status == ComplianceStatusReview:
+		return true, fmt.Sprintf("AML screening (%s) requires review, check %s", triggers, screening.resp.CheckID)
+	default:
+		return true, fmt.Sprintf("AML screening (%s) returned %s, check %s", triggers, screening.resp.Status, screening.resp.CheckID)
+	}
+}
+
+// Discard forgets a payment's screening, e.g. because authorization failed
+func (s *AMLScreener) Discard(paymentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, paymentID)
+}
+
+// AdminPaymentReviewHandler handles POST /admin/payments/{payment_id}/review,
+// releasing (approve) or failing (reject) a payment held in REVIEW by AML screening
+// or a fraud rule
+func AdminPaymentReviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paymentID := r.PathValue("payment_id")
+	var body struct {
+		Decision string `json:"decision"` // approve or reject
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var to State
+	var decided string
+	switch body.Decision {
+	case "approve":
+		to, decided = SUCCESS, "approved"
+	case "reject":
+		to, decided = FAILED, "rejected"
+	default:
+		http.Error(w, "decision must be approve or reject", http.StatusBadRequest)
+		return
+	}
+	if GetState(paymentID) != REVIEW {
+		http.Error(w, "Payment is not held for review", http.StatusConflict)
+		return
+	}
+
+	reason := "Review " + decided + " by " + adminActor(r)
+	if body.Reason != "" {
+		reason += ": " + body.Reason
+	}
+	if _, err := SetStateWithReason(paymentID, to, reason, adminActor(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	result := NewSuccessResponse(to.String(), paymentID, map[string]interface{}{"review_reason": reason})
+	record := CompletePayment(paymentID, result, func(record *PaymentRecord) {
+		record.Status = to.String()
+		record.ErrorCode = ""
+		record.ErrorMessage = ""
+		if to == FAILED {
+			record.ErrorCode = string(ErrComplianceFailed)
+			record.ErrorMessage = reason
+		}
+	})
+	if record != nil && to == SUCCESS {
+		recordCapturedPayment(record)
+	}
+	recordAudit(r, "review_payment", paymentID, map[string]string{"status": REVIEW.String()}, map[string]string{"status": to.String(), "reason": reason})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Payment %s moved to %s", paymentID, to),
+	})
+}
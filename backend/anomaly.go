@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Anomaly types
+const (
+	AnomalySuccessRateDrop = "success_rate_drop"
+	AnomalyErrorCodeSpike  = "error_code_spike"
+)
+
+// AnomalyConfig holds configuration for success rate anomaly detection
+type AnomalyConfig struct {
+	Interval        time.Duration // Length of one observation window
+	BaselineWindows int           // Trailing windows that form the baseline
+	Threshold       float64       // Relative success rate drop, or error share rise, that counts as anomalous
+	WebhookURL      string        // Optional endpoint that receives anomaly events
+}
+
+// DefaultAnomalyConfig returns sensible defaults: one-minute windows compared against
+// the trailing half hour
+func DefaultAnomalyConfig() AnomalyConfig {
+	return AnomalyConfig{
+		Interval:        time.Minute,
+		BaselineWindows: 30,
+		Threshold:       0.2,
+	}
+}
+
+// AnomalyConfigFromEnv builds the detector config from ANOMALY_* environment
+// variables, falling back to the defaults
+func AnomalyConfigFromEnv() (AnomalyConfig, error) {
+	config := DefaultAnomalyConfig()
+
+	if v := os.Getenv("ANOMALY_INTERVAL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return config, fmt.Errorf("ANOMALY_INTERVAL_SECONDS must be a positive integer, got %q", v)
+		}
+		config.Interval = time.Duration(seconds) * time.Second
+	}
+	if v := os.Getenv("ANOMALY_BASELINE_WINDOWS"); v != "" {
+		windows, err := strconv.Atoi(v)
+		if err != nil || windows <= 0 {
+			return config, fmt.Errorf("ANOMALY_BASELINE_WINDOWS must be a positive integer, got %q", v)
+		}
+		config.BaselineWindows = windows
+	}
+	if v := os.Getenv("ANOMALY_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold <= 0 || threshold >= 1 {
+			return config, fmt.Errorf("ANOMALY_THRESHOLD must be between 0 and 1, got %q", v)
+		}
+		config.Threshold = threshold
+	}
+	config.WebhookURL = os.Getenv("ANOMALY_WEBHOOK_URL")
+	return config, nil
+}
+
+// Anomaly is a detected deviation from a provider's trailing baseline. Baseline and
+// Current are success rates for a drop, and the error code's share of requests for
+// a spike
+type Anomaly struct {
+	Type       string    `json:"type"`
+	Provider   string    `json:"provider"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+	Baseline   float64   `json:"baseline"`
+	Current    float64   `json:"current"`
+	Requests   int64     `json:"requests"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// anomalyWindow counts one provider's request outcomes over one interval
+type anomalyWindow struct {
+	total   int64
+	success int64
+	codes   map[string]int64
+}
+
+func newAnomalyWindow() *anomalyWindow {
+	return &anomalyWindow{codes: make(map[string]int64)}
+}
+
+// providerAnomalyState is the detector's history for one provider
+type providerAnomalyState struct {
+	current  *anomalyWindow
+	baseline []*anomalyWindow // oldest first
+	active   map[string]*Anomaly
+}
+
+// AnomalyDetector compares each provider's latest window of traffic against its
+// trailing baseline. A success rate that drops by more than the threshold relative
+// to the baseline, or an error code whose share of requests rises by more than the
+// threshold, raises a WARN event and an optional webhook call. Anomalies stay active
+// until a later window looks normal again
+type AnomalyDetector struct {
+	config    AnomalyConfig
+	providers map[string]*providerAnomalyState
+	client    *http.Client
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// anomalyDetector watches provider success rates
+var anomalyDetector *AnomalyDetector
+
+// NewAnomalyDetector creates a detector with the given config
+func NewAnomalyDetector(config AnomalyConfig) *AnomalyDetector {
+	return &AnomalyDetector{
+		config:    config,
+		providers: make(map[string]*providerAnomalyState),
+		client:    &http.Client{Timeout: 5 * time.Second},
+		stopChan:  make(chan bool),
+	}
+}
+
+// Start launches the evaluation goroutine
+func (ad *AnomalyDetector) Start() {
+	ad.mu.Lock()
+	if ad.isRunning {
+		ad.mu.Unlock()
+		return
+	}
+	ad.isRunning = true
+	ad.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ad.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ad.evaluateAll()
+			case <-ad.stopChan:
+				log.Println("[AnomalyDetector] Stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the evaluation goroutine
+func (ad *AnomalyDetector) Stop() {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	if ad.isRunning {
+		ad.stopChan <- true
+		ad.isRunning = false
+	}
+}
+
+// errorCodePattern matches canonical error codes such as CARD_DECLINED
+var errorCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// anomalyErrorCode picks the code a failed request is counted under: the gateway's
+// own error code when it sent one, otherwise the error type
+func anomalyErrorCode(errorType, errorMsg string) string {
+	if errorCodePattern.MatchString(errorMsg) {
+		return errorMsg
+	}
+	if errorType != "" {
+		return errorType
+	}
+	return "UNKNOWN"
+}
+
+// Observe records one request outcome for a provider
+func (ad *AnomalyDetector) Observe(provider string, success bool, errorCode string) {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	state, exists := ad.providers[provider]
+	if !exists {
+		state = &providerAnomalyState{current: newAnomalyWindow(), active: make(map[string]*Anomaly)}
+		ad.providers[provider] = state
+	}
+	state.current.total++
+	if success {
+		state.current.success++
+	} else {
+		state.current.codes[errorCode]++
+	}
+}
+
+// evaluateAll closes every provider's current window and compares it to the baseline
+func (ad *AnomalyDetector) evaluateAll() {
+	var raised, resolved []Anomaly
+
+	ad.mu.Lock()
+	for provider, state := range ad.providers {
+		r, s := ad.evaluate(provider, state)
+		raised = append(raised, r...)
+		resolved = append(resolved, s...)
+	}
+	ad.mu.Unlock()
+
+	for _, anomaly := range raised {
+		appLogger.Warn("Provider anomaly detected", anomalyFields(anomaly))
+		ad.notify("anomaly.detected", anomaly)
+	}
+	for _, anomaly := range resolved {
+		appLogger.Info("Provider anomaly resolved", anomalyFields(anomaly))
+		ad.notify("anomaly.resolved", anomaly)
+	}
+}
+
+// evaluate checks one provider's closed window and rolls it into the baseline.
+// Windows with too few requests to judge leave active anomalies as they are.
+// Callers must hold ad.mu
+func (ad *AnomalyDetector) evaluate(provider string, state *providerAnomalyState) (raised, resolved []Anomaly) {
+	window := state.current
+	state.current = newAnomalyWindow()
+
+	baseline := newAnomalyWindow()
+	for _, past := range state.baseline {
+		baseline.total += past.total
+		baseline.success += past.success
+		for code, count := range past.codes {
+			baseline.codes[code] += count
+		}
+	}
+
+	state.baseline = append(state.baseline, window)
+	if len(state.baseline) > ad.config.BaselineWindows {
+		state.baseline = state.baseline[len(state.baseline)-ad.config.BaselineWindows:]
+	}
+
+	if window.total < minRoutingSamples || baseline.total < minRoutingSamples {
+		return nil, nil
+	}
+
+	found := make(map[string]Anomaly)
+	baselineRate := float64(baseline.success) / float64(baseline.total)
+	currentRate := float64(window.success) / float64(window.total)
+	if baselineRate > 0 && (baselineRate-currentRate)/baselineRate > ad.config.Threshold {
+		found[AnomalySuccessRateDrop] = Anomaly{
+			Type:     AnomalySuccessRateDrop,
+			Baseline: baselineRate,
+			Current:  currentRate,
+		}
+	}
+	for code, count := range window.codes {
+		baselineShare := float64(baseline.codes[code]) / float64(baseline.total)
+		currentShare := float64(count) / float64(window.total)
+		if currentShare-baselineShare > ad.config.Threshold {
+			found[AnomalyErrorCodeSpike+":"+code] = Anomaly{
+				Type:      AnomalyErrorCodeSpike,
+				ErrorCode: code,
+				Baseline:  baselineShare,
+				Current:   currentShare,
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	for key, anomaly := range found {
+		anomaly.Provider = provider
+		anomaly.Requests = window.total
+		if active, exists := state.active[key]; exists {
+			anomaly.DetectedAt = active.DetectedAt
+			*active = anomaly
+			continue
+		}
+		anomaly.DetectedAt = now
+		state.active[key] = &anomaly
+		raised = append(raised, anomaly)
+	}
+	for key, anomaly := range state.active {
+		if _, still := found[key]; !still {
+			resolved = append(resolved, *anomaly)
+			delete(state.active, key)
+		}
+	}
+	return raised, resolved
+}
+
+func anomalyFields(anomaly Anomaly) map[string]interface{} {
+	fields := map[string]interface{}{
+		"operation": anomaly.Type,
+		"provider":  anomaly.Provider,
+		"baseline":  anomaly.Baseline,
+		"current":   anomaly.Current,
+		"requests":  anomaly.Requests,
+	}
+	if anomaly.ErrorCode != "" {
+		fields["error_code"] = anomaly.ErrorCode
+	}
+	return fields
+}
+
+// notify posts an anomaly event to the configured webhook, if any
+func (ad *AnomalyDetector) notify(event string, anomaly Anomaly) {
+	if ad.config.WebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"event":   event,
+		"anomaly": anomaly,
+		"sent_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ad.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[AnomalyDetector] Invalid webhook URL: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ad.client.Do(req)
+	if err != nil {
+		log.Printf("[AnomalyDetector] Webhook delivery failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[AnomalyDetector] Webhook returned %d", resp.StatusCode)
+	}
+}
+
+// Active returns a provider's active anomalies, oldest first
+func (ad *AnomalyDetector) Active(provider string) []Anomaly {
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+
+	anomalies := make([]Anomaly, 0)
+	if state, exists := ad.providers[provider]; exists {
+		for _, anomaly := range state.active {
+			anomalies = append(anomalies, *anomaly)
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		return anomalies[i].DetectedAt.Before(anomalies[j].DetectedAt)
+	})
+	return anomalies
+}
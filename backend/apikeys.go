@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiKeySecretView describes a secret version without revealing it
+type apiKeySecretView struct {
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func newAPIKeySecretViews(secrets []APIKeySecret) []apiKeySecretView {
+	views := make([]apiKeySecretView, len(secrets))
+	for i, s := range secrets {
+		views[i] = apiKeySecretView{Version: s.Version, CreatedAt: s.CreatedAt, ExpiresAt: s.ExpiresAt}
+	}
+	return views
+}
+
+// apiKeyView is the admin API's view of a key. Secrets are only ever returned once,
+// when they are created
+type apiKeyView struct {
+	Key            string               `json:"key"`
+	Name           string               `json:"name"`
+	Enabled        bool                 `json:"enabled"`
+	Scopes         []Scope              `json:"scopes"`
+	Secrets        []apiKeySecretView   `json:"secrets"`
+	IPAllow        []string             `json:"ip_allow"`
+	IPDeny         []string             `json:"ip_deny"`
+	HedgingEnabled bool                 `json:"hedging_enabled"`
+	Providers      *ProviderPreferences `json:"providers,omitempty"`
+	Mode           string               `json:"mode"`
+	CreatedAt      time.Time            `json:"created_at"`
+	ExpiresAt      *time.Time           `json:"expires_at,omitempty"`
+}
+
+func newAPIKeyView(key *APIKey) apiKeyView {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []Scope{}
+	}
+	allow, deny := apiKeyStore.GetIPRules(key).Strings()
+	return apiKeyView{
+		Key:            key.Key,
+		Name:           key.Name,
+		Enabled:        key.Enabled,
+		Scopes:         scopes,
+		Secrets:        newAPIKeySecretViews(apiKeyStore.ActiveSecrets(key)),
+		IPAllow:        allow,
+		IPDeny:         deny,
+		HedgingEnabled: key.HedgingEnabled,
+		Providers:      apiKeyStore.GetProviderPreferences(key),
+		Mode:           apiKeyMode(key),
+		CreatedAt:      key.CreatedAt,
+		ExpiresAt:      key.ExpiresAt,
+	}
+}
+
+// API key modes
+const (
+	APIKeyModeLive = "live"
+	APIKeyModeTest = "test"
+)
+
+func apiKeyMode(key *APIKey) string {
+	if key.TestMode {
+		return APIKeyModeTest
+	}
+	return APIKeyModeLive
+}
+
+// randomCredential returns a prefixed random hex string
+func randomCredential(prefix string, size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate credential: %v", err)
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// createAPIKeyRequest is the body of POST /admin/apikeys
+type createAPIKeyRequest struct {
+	Name           string     `json:"name"`
+	Scopes         []string   `json:"scopes"`
+	HedgingEnabled bool       `json:"hedging_enabled"`
+	Mode           string     `json:"mode"` // live (default) or test
+	ExpiresAt      *time.Time `json:"expires_at"`
+	IPAllow        []string   `json:"ip_allow"`
+	IPDeny         []string   `json:"ip_deny"`
+	// Providers are the key's provider preferences, see merchant_providers.go
+	Providers *ProviderPreferences `json:"providers"`
+}
+
+// AdminAPIKeysHandler lists API keys with their granted scopes (GET) or mints a new
+// key (POST). Minting a key with the admin scope needs the admin role
+func AdminAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys := apiKeyStore.ListKeys()
+		views := make([]apiKeyView, len(keys))
+		for i, key := range keys {
+			views[i] = newAPIKeyView(key)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys":  views,
+			"total": len(views),
+		})
+
+	case http.MethodPost:
+		var req createAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		scopes, err := ParseScopes(strings.Join(req.Scopes, " "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(scopes) == 0 {
+			http.Error(w, fmt.Sprintf("at least one scope is required (%s)", formatScopes(AllScopes)), http.StatusBadRequest)
+			return
+		}
+		ipRules, err := ParseIPRules(req.IPAllow, req.IPDeny)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var providers *ProviderPreferences
+		if req.Providers != nil {
+			if providers, err = ParseProviderPreferences(*req.Providers); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Mode == "" {
+			req.Mode = APIKeyModeLive
+		}
+		if req.Mode != APIKeyModeLive && req.Mode != APIKeyModeTest {
+			http.Error(w, "mode must be live or test", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		if role, _ := r.Context().Value("admin_role").(AdminRole); hasScope(scopes, ScopeAdmin) && role < AdminRoleAdmin {
+			http.Error(w, "Forbidden: granting the admin scope requires admin role", http.StatusForbidden)
+			return
+		}
+
+		// Test keys are recognisable at a glance, like pk_test_...
+		prefix := ""
+		if req.Mode == APIKeyModeTest {
+			prefix = "test_"
+		}
+		keyID, err := randomCredential("pk_"+prefix, 12)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		secret, err := randomCredential("sk_"+prefix, 24)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		key := &APIKey{
+			Key:                 keyID,
+			Secrets:             []APIKeySecret{{Version: 1, Secret: secret, CreatedAt: time.Now()}},
+			Name:                req.Name,
+			Enabled:             true,
+			CreatedAt:           time.Now(),
+			ExpiresAt:           req.ExpiresAt,
+			HedgingEnabled:      req.HedgingEnabled,
+			TestMode:            req.Mode == APIKeyModeTest,
+			Scopes:              scopes,
+			IPRules:             ipRules,
+			ProviderPreferences: providers,
+		}
+		apiKeyStore.AddKey(key)
+		recordAudit(r, "create_api_key", key.Key, nil, newAPIKeyView(key))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "API key created, store the secret now as it will not be shown again",
+			"key":     newAPIKeyView(key),
+			"secret":  secret,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Bounds on how long a rotated-out secret stays valid
+const (
+	defaultSecretOverlap = 24 * time.Hour
+	maxSecretOverlap     = 30 * 24 * time.Hour
+)
+
+// AdminAPIKeyRotateHandler issues a new secret for a key: POST /admin/apikeys/{key}/rotate?overlap=24h.
+// Previous secrets keep working for the overlap, 0 revokes them immediately
+func AdminAPIKeyRotateHandler(w http.ResponseWriter, r *http.Request) {
+	keyID := r.PathValue("key")
+
+	overlap := defaultSecretOverlap
+	if v := r.URL.Query().Get("overlap"); v != "" {
+		var err error
+		overlap, err = time.ParseDuration(v)
+		if err != nil || overlap < 0 || overlap > maxSecretOverlap {
+			http.Error(w, fmt.Sprintf("overlap must be a duration between 0 and %v, e.g. 24h", maxSecretOverlap), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, exists := apiKeyStore.FindKey(keyID)
+	if !exists {
+		http.Error(w, fmt.Sprintf("API key '%s' not found", keyID), http.StatusNotFound)
+		return
+	}
+	before := newAPIKeySecretViews(apiKeyStore.ActiveSecrets(key))
+
+	current, err := apiKeyStore.RotateSecret(keyID, overlap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	after := newAPIKeySecretViews(apiKeyStore.ActiveSecrets(key))
+	recordAudit(r, "rotate_api_key_secret", keyID, before, after)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"message":        "Secret rotated, store the new secret now as it will not be shown again",
+		"key":            keyID,
+		"secret_version": current.Version,
+		"secret":         current.Secret,
+		"secrets":        after,
+	})
+}
+
+// ipRulesPayload is the JSON form of a key's IP rules
+type ipRulesPayload struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// AdminAPIKeyIPRulesHandler returns (GET) or replaces (PUT) the client IP allow and deny
+// lists of a key. Empty lists remove the restriction
+func AdminAPIKeyIPRulesHandler(w http.ResponseWriter, r *http.Request) {
+	keyID := r.PathValue("key")
+	key, exists := apiKeyStore.FindKey(keyID)
+	if !exists {
+		http.Error(w, fmt.Sprintf("API key '%s' not found", keyID), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		allow, deny := apiKeyStore.GetIPRules(key).Strings()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":      keyID,
+			"ip_rules": ipRulesPayload{Allow: allow, Deny: deny},
+		})
+
+	case http.MethodPut:
+		var payload ipRulesPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		rules, err := ParseIPRules(payload.Allow, payload.Deny)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		beforeAllow, beforeDeny := apiKeyStore.GetIPRules(key).Strings()
+		if err := apiKeyStore.SetIPRules(keyID, rules); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		allow, deny := rules.Strings()
+		after := ipRulesPayload{Allow: allow, Deny: deny}
+		recordAudit(r, "update_api_key_ip_rules", keyID, ipRulesPayload{Allow: beforeAllow, Deny: beforeDeny}, after)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"message":  "IP rules updated",
+			"key":      keyID,
+			"ip_rules": after,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
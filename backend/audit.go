@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AuditEntry records one admin mutation: who did what to which target, and the
+// target's value before and after
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RemoteAddr string          `json:"remote_addr"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows an audit log query
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditStore persists the admin audit log
+type AuditStore interface {
+	RecordAudit(entry *AuditEntry) error
+	// QueryAudit returns matching entries, newest first, with the total match count
+	QueryAudit(filter AuditFilter) ([]AuditEntry, int, error)
+}
+
+// adminActor identifies who made an admin request: the JWT subject set by
+// AdminAuthMiddleware, else the API key's name when a valid key is presented, then the
+// X-Admin-User header, then the caller's address
+func adminActor(r *http.Request) string {
+	if subject, ok := r.Context().Value("admin_subject").(string); ok && subject != "" {
+		return "jwt:" + subject
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" && apiKeyStore != nil {
+		if apiKey, err := apiKeyStore.GetKey(key); err == nil {
+			return "api_key:" + apiKey.Name
+		}
+	}
+	if user := r.Header.Get("X-Admin-User"); user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// recordAudit writes an admin mutation to the audit log. before and after are the
+// target's values around the change, nil when it didn't exist before or after. The
+// entry is always logged; it is persisted when a database is configured
+func recordAudit(r *http.Request, action, target string, before, after interface{}) {
+	entry := &AuditEntry{
+		Actor:      adminActor(r),
+		Action:     action,
+		Target:     target,
+		Before:     auditValue(before),
+		After:      auditValue(after),
+		RemoteAddr: r.RemoteAddr,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	appLogger.Info("Admin action", map[string]interface{}{
+		"admin_action": action,
+		"actor":        entry.Actor,
+		"target":       target,
+		"before":       entry.Before,
+		"after":        entry.After,
+	})
+
+	if dataStore == nil {
+		return
+	}
+	if err := dataStore.RecordAudit(entry); err != nil {
+		log.Printf("[Audit] Failed to record %s on %s by %s: %v", action, target, entry.Actor, err)
+	}
+}
+
+// auditValue encodes a before/after value, nil stays nil
+func auditValue(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return json.RawMessage(strconv.Quote(fmt.Sprintf("%v", value)))
+	}
+	return data
+}
+
+// parseAuditFilter builds an AuditFilter from query parameters
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	q := r.URL.Query()
+	filter := AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+		Limit:  50,
+	}
+
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %v", err)
+		}
+		filter.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %v", err)
+		}
+		filter.To = &t
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		if limit > 500 {
+			limit = 500
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", v)
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// AdminAuditHandler handles GET /admin/audit with filtering and pagination
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dataStore == nil {
+		http.Error(w, "Audit log not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, total, err := dataStore.QueryAudit(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	})
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"pulseberry/signing"
 )
 
 var (
@@ -22,14 +31,51 @@ var (
 	ErrMissingTimestamp = errors.New("missing request timestamp")
 )
 
+// APIKeySecret is one version of an API key's signing secret. Several can be valid at
+// once so clients can move to a rotated secret without downtime
+type APIKeySecret struct {
+	Version   int
+	Secret    string
+	CreatedAt time.Time
+	ExpiresAt *time.Time // nil for the current secret
+}
+
+// expired reports whether the secret can no longer be used
+func (s APIKeySecret) expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
 // APIKey represents an API key configuration
 type APIKey struct {
-	Key       string
-	Secret    string
+	Key string
+	// Secrets are the key's signing secrets, oldest first. Read them through
+	// APIKeyStore.ActiveSecrets, rotation replaces the slice under the store's lock
+	Secrets   []APIKeySecret
 	Name      string
 	Enabled   bool
 	CreatedAt time.Time
 	ExpiresAt *time.Time
+	// HedgingEnabled lets payments on this key be hedged to a second provider
+	HedgingEnabled bool
+	// TestMode keys only reach simulator gateways and their payments are kept apart
+	// from live data and metrics
+	TestMode bool
+	// Scopes are the permissions granted to the key, see requiredScope
+	Scopes []Scope
+	// IPRules limit the client addresses the key works from. Read them through
+	// APIKeyStore.ClientIPAllowed, they can be replaced at runtime
+	IPRules IPRules
+	// ProviderPreferences are the providers the key's payments prefer or avoid, nil
+	// for none. Read them through APIKeyStore.GetProviderPreferences
+	ProviderPreferences *ProviderPreferences
+	// DeclinePolicy overrides the default decline policy for the key's payments. Read it
+	// through APIKeyStore.GetDeclinePolicy
+	DeclinePolicy DeclinePolicy
+}
+
+// HasScope reports whether the key has been granted a scope
+func (k *APIKey) HasScope(scope Scope) bool {
+	return hasScope(k.Scopes, scope)
 }
 
 // APIKeyStore manages API keys
@@ -52,6 +98,144 @@ func (aks *APIKeyStore) AddKey(key *APIKey) {
 	aks.keys[key.Key] = key
 }
 
+// ActiveSecrets returns a key's unexpired secrets, newest first
+func (aks *APIKeyStore) ActiveSecrets(key *APIKey) []APIKeySecret {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+
+	now := time.Now()
+	active := make([]APIKeySecret, 0, len(key.Secrets))
+	for i := len(key.Secrets) - 1; i >= 0; i-- {
+		if !key.Secrets[i].expired(now) {
+			active = append(active, key.Secrets[i])
+		}
+	}
+	return active
+}
+
+// RotateSecret adds a new current secret to a key. Older secrets stay valid for at most
+// overlap so clients can switch over; expired ones are dropped
+func (aks *APIKeyStore) RotateSecret(keyID string, overlap time.Duration) (APIKeySecret, error) {
+	secret, err := randomCredential("sk_", 24)
+	if err != nil {
+		return APIKeySecret{}, err
+	}
+
+	aks.mu.Lock()
+	defer aks.mu.Unlock()
+
+	key, exists := aks.keys[keyID]
+	if !exists {
+		return APIKeySecret{}, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	retireAt := now.Add(overlap)
+	secrets := make([]APIKeySecret, 0, len(key.Secrets)+1)
+	version := 0
+	for _, s := range key.Secrets {
+		if s.Version > version {
+			version = s.Version
+		}
+		if s.expired(now) {
+			continue
+		}
+		if s.ExpiresAt == nil || s.ExpiresAt.After(retireAt) {
+			s.ExpiresAt = &retireAt
+		}
+		secrets = append(secrets, s)
+	}
+	current := APIKeySecret{Version: version + 1, Secret: secret, CreatedAt: now}
+	key.Secrets = append(secrets, current)
+	return current, nil
+}
+
+// SetIPRules replaces a key's client IP rules
+func (aks *APIKeyStore) SetIPRules(keyID string, rules IPRules) error {
+	aks.mu.Lock()
+	defer aks.mu.Unlock()
+
+	key, exists := aks.keys[keyID]
+	if !exists {
+		return ErrInvalidAPIKey
+	}
+	key.IPRules = rules
+	return nil
+}
+
+// GetIPRules returns a key's client IP rules
+func (aks *APIKeyStore) GetIPRules(key *APIKey) IPRules {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+	return key.IPRules
+}
+
+// SetProviderPreferences replaces a key's provider preferences, nil clears them
+func (aks *APIKeyStore) SetProviderPreferences(keyID string, prefs *ProviderPreferences) error {
+	aks.mu.Lock()
+	defer aks.mu.Unlock()
+
+	key, exists := aks.keys[keyID]
+	if !exists {
+		return ErrInvalidAPIKey
+	}
+	key.ProviderPreferences = prefs
+	return nil
+}
+
+// GetProviderPreferences returns a key's provider preferences
+func (aks *APIKeyStore) GetProviderPreferences(key *APIKey) *ProviderPreferences {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+	return key.ProviderPreferences
+}
+
+// SetDeclinePolicy replaces a key's decline policy overrides, nil clears them
+func (aks *APIKeyStore) SetDeclinePolicy(keyID string, policy DeclinePolicy) error {
+	aks.mu.Lock()
+	defer aks.mu.Unlock()
+
+	key, exists := aks.keys[keyID]
+	if !exists {
+		return ErrInvalidAPIKey
+	}
+	key.DeclinePolicy = policy
+	return nil
+}
+
+// GetDeclinePolicy returns a key's decline policy overrides
+func (aks *APIKeyStore) GetDeclinePolicy(key *APIKey) DeclinePolicy {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+	return key.DeclinePolicy
+}
+
+// ClientIPAllowed reports whether a request's client address passes the key's IP rules
+func (aks *APIKeyStore) ClientIPAllowed(key *APIKey, r *http.Request) bool {
+	return aks.GetIPRules(key).Allows(getClientIP(r))
+}
+
+// FindKey looks up a key whether or not it is currently usable, for administration
+func (aks *APIKeyStore) FindKey(keyID string) (*APIKey, bool) {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+	key, exists := aks.keys[keyID]
+	return key, exists
+}
+
+// ListKeys returns every key, enabled or not, ordered by creation time
+func (aks *APIKeyStore) ListKeys() []*APIKey {
+	aks.mu.RLock()
+	defer aks.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(aks.keys))
+	for _, key := range aks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
 // GetKey retrieves an API key
 func (aks *APIKeyStore) GetKey(key string) (*APIKey, error) {
 	aks.mu.RLock()
@@ -74,11 +258,74 @@ func (aks *APIKeyStore) GetKey(key string) (*APIKey, error) {
 	return apiKey, nil
 }
 
-// AuthMiddleware provides API key authentication
-func AuthMiddleware(keyStore *APIKeyStore) func(http.Handler) http.Handler {
+// publicRoutes are served without an API key: probes, metrics, the spec, the token
+// endpoint clients call before they have a token, provider webhooks that carry their
+// own signatures, and WebSocket upgrades, which browsers can't add headers to.
+// openapi.json marks the documented ones with an empty security requirement
+var publicRoutes = map[string]bool{
+	"/health":           true,
+	"/livez":            true,
+	"/readyz":           true,
+	"/metrics":          true,
+	"/openapi.json":     true,
+	"/docs":             true,
+	"/oauth/token":      true,
+	"/disputes/webhook": true,
+	"/ws":               true,
+}
+
+// unauthenticatedRoute reports whether a request skips API key authentication. Besides
+// publicRoutes that is provider callbacks, BNPL provider events, and /admin/*, which
+// AdminAuthMiddleware protects with JWTs instead
+func unauthenticatedRoute(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case publicRoutes[path]:
+		return true
+	case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return true
+	case strings.HasPrefix(path, "/callbacks/"):
+		return true
+	case strings.HasPrefix(path, "/bnpl/") && strings.HasSuffix(path, "/events"):
+		return true
+	}
+	return false
+}
+
+// anonymousRoutes may also be called with no credentials at all, as the "default"
+// merchant. They are the routes the browser clients use (the bpay checkout demo and
+// the dashboard's log view), which have nowhere to keep an API secret. A request that
+// does present credentials is still authenticated. openapi.json marks these with an
+// empty alternative in their security requirements
+var anonymousRoutes = map[string]bool{
+	"/payment":    true,
+	"/paymentKey": true,
+	"/logs":       true,
+}
+
+// anonymousRoute reports whether a request may proceed without credentials
+func anonymousRoute(r *http.Request) bool {
+	return anonymousRoutes[r.URL.Path] && r.Header.Get("X-API-Key") == "" && r.Header.Get("Authorization") == ""
+}
+
+// AuthMiddleware provides API key authentication. Each signed request is accepted
+// once; nonces may be nil to skip replay detection. Without an X-API-Key header an
+// OAuth2 Bearer access token is accepted instead, limited to its granted scopes.
+// Routes for which unauthenticatedRoute holds pass through, as do anonymousRoutes
+// called without credentials
+func AuthMiddleware(keyStore *APIKeyStore, nonces *NonceStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if unauthenticatedRoute(r) || anonymousRoute(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			apiKey := r.Header.Get("X-API-Key")
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && apiKey == "" {
+				authenticateAccessToken(w, r, next, keyStore, bearer)
+				return
+			}
 			if apiKey == "" {
 				http.Error(w, "Missing API key", http.StatusUnauthorized)
 				return
@@ -90,6 +337,10 @@ func AuthMiddleware(keyStore *APIKeyStore) func(http.Handler) http.Handler {
 				http.Error(w, "Invalid API key", http.StatusUnauthorized)
 				return
 			}
+			if !keyStore.ClientIPAllowed(key, r) {
+				http.Error(w, "Client IP not allowed for this API key", http.StatusForbidden)
+				return
+			}
 
 			// Verify request signature (HMAC-SHA256)
 			signature := r.Header.Get("X-Signature")
@@ -112,37 +363,198 @@ func AuthMiddleware(keyStore *APIKeyStore) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Allow 5 minute clock skew
-			if time.Since(reqTime) > 5*time.Minute || time.Until(reqTime) > 5*time.Minute {
+			// Allow for clock skew either way
+			if time.Since(reqTime) > signatureMaxSkew || time.Until(reqTime) > signatureMaxSkew {
 				http.Error(w, "Request timestamp expired", http.StatusUnauthorized)
 				return
 			}
 
-			// Verify signature
-			// Signature is HMAC-SHA256(secret, method + path + timestamp + body)
-			// For now, we'll skip body verification for simplicity in GET requests
-			expectedSig := computeSignature(key.Secret, r.Method, r.URL.Path, timestamp)
-
-			if !hmac.Equal([]byte(signature), []byte(expectedSig)) {
-				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			// Verify signature, see the signing package for the versions. The body is
+			// read for the digest and put back for the handler
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			if len(body) > maxSignedBodyBytes {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// Any unexpired secret may sign, so clients keep working through a rotation
+			secrets := keyStore.ActiveSecrets(key)
+			var version int
+			var matched APIKeySecret
+			err = signing.ErrSignatureMismatch
+			for _, secret := range secrets {
+				version, err = signing.Verify(signature, secret.Secret, r.Method, r.URL.Path, timestamp, body, minSignatureVersion)
+				if !errors.Is(err, signing.ErrSignatureMismatch) {
+					matched = secret
+					break
+				}
+			}
+			if err != nil {
+				switch {
+				case errors.Is(err, signing.ErrVersionNotPermitted), errors.Is(err, signing.ErrUnsupportedVersion):
+					http.Error(w, fmt.Sprintf("Signature version not accepted, sign with v%d or later", minSignatureVersion), http.StatusUnauthorized)
+				default:
+					http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				}
+				return
+			}
+			if required := requiredScope(r); !key.HasScope(required) {
+				http.Error(w, fmt.Sprintf("API key lacks the %s scope", required), http.StatusForbidden)
 				return
 			}
 
+			// Only a correctly signed request is recorded, so forgeries can't burn nonces
+			if nonces != nil {
+				if err := nonces.Consume(r.Context(), apiKey, signature, timestamp); err != nil {
+					if errors.Is(err, ErrRequestReplayed) {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusUnauthorized)
+						json.NewEncoder(w).Encode(NewErrorResponse(
+							ErrReplayDetected,
+							"Request has already been processed",
+							"FAILED",
+							"each signed request can only be sent once, sign a new request with a fresh timestamp",
+						))
+						return
+					}
+					log.Printf("[Auth] Replay check failed for key %s: %v", key.Name, err)
+					http.Error(w, "Unable to verify request uniqueness", http.StatusServiceUnavailable)
+					return
+				}
+			}
+
+			if matched.ExpiresAt != nil {
+				w.Header().Set("X-Secret-Deprecated", fmt.Sprintf("secret v%d expires at %s, switch to v%d", matched.Version, matched.ExpiresAt.UTC().Format(time.RFC3339), secrets[0].Version))
+			}
+			if version < signing.Latest {
+				w.Header().Set("X-Signature-Deprecated", fmt.Sprintf("v%d signatures do not cover the body, migrate to v%d", version, signing.Latest))
+			}
+
 			// Add API key to context
 			ctx := context.WithValue(r.Context(), "api_key", apiKey)
 			ctx = context.WithValue(ctx, "api_key_name", key.Name)
+			ctx = context.WithValue(ctx, "scopes", key.Scopes)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// computeSignature generates HMAC-SHA256 signature
-func computeSignature(secret, method, path, timestamp string) string {
-	message := strings.Join([]string{method, path, timestamp}, "|")
+// authenticateAccessToken serves a request authenticated by an OAuth2 access token,
+// checking the token's scopes against the route. The key it was issued to must still
+// be valid and hold the scope, so disabling a key or narrowing its scopes applies to
+// tokens already issued
+func authenticateAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, keyStore *APIKeyStore, bearer string) {
+	claims, err := parseAccessToken(bearer)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid access token", http.StatusUnauthorized)
+		return
+	}
+	key, err := keyStore.GetKey(claims.Subject)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid access token", http.StatusUnauthorized)
+		return
+	}
+	if !keyStore.ClientIPAllowed(key, r) {
+		http.Error(w, "Client IP not allowed for this API key", http.StatusForbidden)
+		return
+	}
+
+	scopes := claims.Scopes()
+	if required := requiredScope(r); !hasScope(scopes, required) || !key.HasScope(required) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+		http.Error(w, fmt.Sprintf("Token lacks the %s scope", required), http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), "api_key", key.Key)
+	ctx = context.WithValue(ctx, "api_key_name", key.Name)
+	ctx = context.WithValue(ctx, "scopes", scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// merchantIDFromContext returns the authenticated API key, or "default" when auth is disabled
+func merchantIDFromContext(ctx context.Context) string {
+	if apiKey, ok := ctx.Value("api_key").(string); ok && apiKey != "" {
+		return apiKey
+	}
+	return "default"
+}
+
+// scopedMerchantID returns the merchant whose data a request may read: the caller's
+// own, or the merchant_id query parameter for keys with the admin scope
+func scopedMerchantID(r *http.Request) string {
+	scopes, _ := r.Context().Value("scopes").([]Scope)
+	if requested := r.URL.Query().Get("merchant_id"); requested != "" && hasScope(scopes, ScopeAdmin) {
+		return requested
+	}
+	return merchantIDFromContext(r.Context())
+}
+
+// ownedByCaller reports whether a resource with the given merchant belongs to the
+// request's merchant. Records saved before they carried a merchant belong to "default"
+func ownedByCaller(ctx context.Context, merchantID string) bool {
+	if merchantID == "" {
+		merchantID = "default"
+	}
+	return merchantID == merchantIDFromContext(ctx)
+}
+
+// signatureMaxSkew is how far a signed request's timestamp may be from the server clock
+const signatureMaxSkew = 5 * time.Minute
+
+// maxSignedBodyBytes bounds the body read for signature verification, matching the
+// limit in RequestValidationMiddleware
+const maxSignedBodyBytes = 10 * 1024
+
+// minSignatureVersion is the oldest request signature version accepted, from
+// SIGNATURE_MIN_VERSION. It defaults to v1 while integrators migrate to v2
+var minSignatureVersion = signing.V1
+
+// SignatureMinVersionFromEnv parses SIGNATURE_MIN_VERSION ("1" or "2", with or without a
+// leading "v")
+func SignatureMinVersionFromEnv() (int, error) {
+	v := os.Getenv("SIGNATURE_MIN_VERSION")
+	if v == "" {
+		return signing.V1, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(v), "v"))
+	if err != nil || version < signing.V1 || version > signing.Latest {
+		return 0, fmt.Errorf("SIGNATURE_MIN_VERSION must be between %d and %d, got %q", signing.V1, signing.Latest, v)
+	}
+	return version, nil
+}
+
+// verifyWebhookSignature checks the X-Webhook-Signature header, a hex HMAC-SHA256 of
+// the raw body. Nothing verifies against an empty secret
+func verifyWebhookSignature(r *http.Request, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
 	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(message))
-	return hex.EncodeToString(mac.Sum(nil))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(expected))
+}
+
+// verifyTimestampedWebhookSignature checks a webhook whose X-Webhook-Signature is a hex
+// HMAC-SHA256 of its X-Webhook-Timestamp (RFC 3339), a '.', and the raw body. Like
+// signed API requests, a timestamp more than signatureMaxSkew from now is refused, so
+// a captured webhook can't be replayed later on
+func verifyTimestampedWebhookSignature(r *http.Request, body []byte, secret string) bool {
+	timestamp := r.Header.Get("X-Webhook-Timestamp")
+	sent, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil || time.Since(sent) > signatureMaxSkew || time.Until(sent) > signatureMaxSkew {
+		return false
+	}
+	return verifyWebhookSignature(r, append([]byte(timestamp+"."), body...), secret)
 }
 
 // RequestValidationMiddleware validates request size and format
@@ -156,8 +568,8 @@ func RequestValidationMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// 2. Enforce JSON content type for POST/PUT
-		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		// 2. Enforce JSON content type for POST/PUT. The OAuth2 token endpoint takes a form
+		if (r.Method == http.MethodPost || r.Method == http.MethodPut) && r.URL.Path != "/oauth/token" {
 			contentType := r.Header.Get("Content-Type")
 			if !strings.Contains(contentType, "application/json") {
 				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
@@ -187,6 +599,34 @@ func CorrelationIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// withTraceIDs returns a context carrying the correlation and payment IDs, which
+// ProviderConnectionPool.Do forwards on outbound provider calls. Background work
+// that outlives the request uses this to keep its trace
+func withTraceIDs(ctx context.Context, correlationID, paymentID string) context.Context {
+	if correlationID != "" {
+		ctx = context.WithValue(ctx, "correlation_id", correlationID)
+	}
+	if paymentID != "" {
+		ctx = context.WithValue(ctx, "payment_id", paymentID)
+	}
+	return ctx
+}
+
+// setTraceHeaders copies the trace IDs in a request's context onto its headers,
+// leaving any the caller set explicitly
+func setTraceHeaders(req *http.Request) {
+	if req.Header.Get("X-Correlation-ID") == "" {
+		if id, _ := req.Context().Value("correlation_id").(string); id != "" {
+			req.Header.Set("X-Correlation-ID", id)
+		}
+	}
+	if req.Header.Get("X-Payment-ID") == "" {
+		if id, _ := req.Context().Value("payment_id").(string); id != "" {
+			req.Header.Set("X-Payment-ID", id)
+		}
+	}
+}
+
 // generateCorrelationID generates a unique correlation ID
 func generateCorrelationID() string {
 	// Simple implementation using timestamp + random
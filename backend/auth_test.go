@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pathParam matches an OpenAPI path parameter such as {payment_id}
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestUnauthenticatedRoutesMatchSpec(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Security *[]map[string][]string `json:"security"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+
+	for path, operations := range spec.Paths {
+		for method, op := range operations {
+			if !openAPIMethods[method] {
+				continue
+			}
+			// Operations without their own security use the API key default
+			public := op.Security != nil && len(*op.Security) == 0
+			admin := op.Security != nil && len(*op.Security) > 0 && (*op.Security)[0]["AdminJWT"] != nil
+			// An empty requirement among others makes credentials optional
+			anonymous := false
+			if op.Security != nil && len(*op.Security) > 1 {
+				for _, requirement := range *op.Security {
+					anonymous = anonymous || len(requirement) == 0
+				}
+			}
+
+			r := httptest.NewRequest(strings.ToUpper(method), pathParam.ReplaceAllString(path, "x"), nil)
+			if got, want := unauthenticatedRoute(r), public || admin; got != want {
+				t.Errorf("%s %s: unauthenticatedRoute = %v, want %v", strings.ToUpper(method), path, got, want)
+			}
+			if got := anonymousRoute(r); got != anonymous {
+				t.Errorf("%s %s: anonymousRoute = %v, want %v", strings.ToUpper(method), path, got, anonymous)
+			}
+		}
+	}
+}
+
+func TestAnonymousRouteAuthenticatesPresentedCredentials(t *testing.T) {
+	r := httptest.NewRequest("POST", "/payment", nil)
+	if !anonymousRoute(r) {
+		t.Error("POST /payment without credentials should be allowed anonymously")
+	}
+
+	r.Header.Set("X-API-Key", "pk_live_1")
+	if anonymousRoute(r) {
+		t.Error("POST /payment with an API key must be authenticated")
+	}
+
+	r = httptest.NewRequest("POST", "/payment", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	if anonymousRoute(r) {
+		t.Error("POST /payment with a bearer token must be authenticated")
+	}
+}
+
+func webhookHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyTimestampedWebhookSignature(t *testing.T) {
+	const secret = "whsec_test"
+	body := `{"provider":"stripe","provider_dispute_id":"dp_1","status":"LOST"}`
+	now := time.Now().UTC().Format(time.RFC3339)
+	stale := time.Now().Add(-signatureMaxSkew - time.Minute).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      string
+		want      bool
+	}{
+		{"current", now, webhookHMAC(secret, now+"."+body), body, true},
+		{"replayed after the window", stale, webhookHMAC(secret, stale+"."+body), body, false},
+		{"body-only signature", now, webhookHMAC(secret, body), body, false},
+		{"timestamp changed", now, webhookHMAC(secret, stale+"."+body), body, false},
+		{"body changed", now, webhookHMAC(secret, now+"."+body), `{"status":"WON"}`, false},
+		{"no timestamp", "", webhookHMAC(secret, "."+body), body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/disputes/webhook", nil)
+			r.Header.Set("X-Webhook-Timestamp", tt.timestamp)
+			r.Header.Set("X-Webhook-Signature", tt.signature)
+			if got := verifyTimestampedWebhookSignature(r, []byte(tt.body), secret); got != tt.want {
+				t.Errorf("verifyTimestampedWebhookSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BNPL plan states
+const (
+	BNPLPendingApproval = "PENDING_APPROVAL"
+	BNPLApproved        = "APPROVED"
+	BNPLDeclined        = "DECLINED"
+	BNPLExpired         = "EXPIRED"
+	BNPLCompleted       = "COMPLETED"
+	BNPLDefaulted       = "DEFAULTED"
+)
+
+// PENDING_APPROVAL -> APPROVED,DECLINED,EXPIRED
+// APPROVED -> COMPLETED,DEFAULTED
+var bnplTransitions = map[string][]string{
+	BNPLPendingApproval: {BNPLApproved, BNPLDeclined, BNPLExpired},
+	BNPLApproved:        {BNPLCompleted, BNPLDefaulted},
+}
+
+// Installment states
+const (
+	InstallmentScheduled = "SCHEDULED"
+	InstallmentPaid      = "PAID"
+	InstallmentMissed    = "MISSED"
+)
+
+// Provider events accepted by POST /bnpl/{bnpl_id}/events
+const (
+	BNPLEventApproved          = "approved"
+	BNPLEventDeclined          = "declined"
+	BNPLEventExpired           = "expired"
+	BNPLEventInstallmentPaid   = "installment_paid"
+	BNPLEventInstallmentMissed = "installment_missed"
+)
+
+const (
+	defaultBNPLTerm     = 4
+	maxBNPLTerm         = 24
+	bnplInstallmentGap  = 14 * 24 * time.Hour
+	bnplProviderTimeout = 10 * time.Second
+)
+
+var (
+	ErrBNPLPlanNotFound     = errors.New("BNPL plan not found")
+	ErrInvalidBNPLEvent     = errors.New("invalid BNPL event")
+	ErrBNPLConcurrentUpdate = errors.New("BNPL plan was modified concurrently")
+)
+
+// BNPLInstallment is one scheduled repayment of a BNPL plan
+type BNPLInstallment struct {
+	Number int        `json:"number"`
+	Amount int64      `json:"amount"`
+	DueAt  *time.Time `json:"due_at,omitempty"`
+	Status string     `json:"status"`
+	PaidAt *time.Time `json:"paid_at,omitempty"`
+}
+
+// BNPLPlan tracks a BNPL purchase from approval through its installments
+type BNPLPlan struct {
+	ID                string            `json:"id"`
+	Reference         string            `json:"reference"`
+	MerchantID        string            `json:"merchant_id"`
+	IdempotencyKey    string            `json:"idempotency_key"`
+	Provider          string            `json:"provider"`
+	ProviderSessionID string            `json:"provider_session_id"`
+	Amount            int64             `json:"amount"`
+	Currency          string            `json:"currency"`
+	CustomerEmail     string            `json:"customer_email"`
+	Term              int               `json:"term"`
+	Status            string            `json:"status"`
+	ApprovalURL       string            `json:"approval_url,omitempty"`
+	Installments      []BNPLInstallment `json:"installments"`
+	Version           int               `json:"-"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+// BNPLStore persists BNPL plans
+type BNPLStore interface {
+	CreateBNPLPlan(plan *BNPLPlan) error
+	GetBNPLPlan(id string) (*BNPLPlan, error)
+	GetBNPLPlanByIdempotencyKey(merchantID, key string) (*BNPLPlan, error)
+	// UpdateBNPLPlan saves plan only if nobody else has updated it since it was read
+	UpdateBNPLPlan(plan *BNPLPlan) error
+}
+
+// splitInstallments divides amount into term installments, putting any remainder on the first
+func splitInstallments(amount int64, term int) []BNPLInstallment {
+	installments := make([]BNPLInstallment, term)
+	each := amount / int64(term)
+	for i := range installments {
+		installments[i] = BNPLInstallment{
+			Number: i + 1,
+			Amount: each,
+			Status: InstallmentScheduled,
+		}
+	}
+	installments[0].Amount += amount - each*int64(term)
+	return installments
+}
+
+func (plan *BNPLPlan) transition(to string) error {
+	for _, next := range bnplTransitions[plan.Status] {
+		if next == to {
+			plan.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrInvalidBNPLEvent, plan.Status, to)
+}
+
+func (plan *BNPLPlan) installment(number int) (*BNPLInstallment, error) {
+	if number < 1 || number > len(plan.Installments) {
+		return nil, fmt.Errorf("%w: no installment %d", ErrInvalidBNPLEvent, number)
+	}
+	return &plan.Installments[number-1], nil
+}
+
+// ApplyEvent advances the plan for a provider event
+func (plan *BNPLPlan) ApplyEvent(event string, installmentNumber int, at time.Time) error {
+	switch event {
+	case BNPLEventApproved:
+		if err := plan.transition(BNPLApproved); err != nil {
+			return err
+		}
+		// The first installment is due at approval, the rest every two weeks after
+		for i := range plan.Installments {
+			due := at.Add(time.Duration(i) * bnplInstallmentGap)
+			plan.Installments[i].DueAt = &due
+		}
+	case BNPLEventDeclined:
+		return plan.transition(BNPLDeclined)
+	case BNPLEventExpired:
+		return plan.transition(BNPLExpired)
+	case BNPLEventInstallmentPaid, BNPLEventInstallmentMissed:
+		if plan.Status != BNPLApproved {
+			return fmt.Errorf("%w: plan is %s", ErrInvalidBNPLEvent, plan.Status)
+		}
+		inst, err := plan.installment(installmentNumber)
+		if err != nil {
+			return err
+		}
+		if inst.Status != InstallmentScheduled {
+			return fmt.Errorf("%w: installment %d is already %s", ErrInvalidBNPLEvent, inst.Number, inst.Status)
+		}
+
+		if event == BNPLEventInstallmentMissed {
+			inst.Status = InstallmentMissed
+			return plan.transition(BNPLDefaulted)
+		}
+
+		inst.Status = InstallmentPaid
+		inst.PaidAt = &at
+		for _, i := range plan.Installments {
+			if i.Status != InstallmentPaid {
+				return nil
+			}
+		}
+		return plan.transition(BNPLCompleted)
+	default:
+		return fmt.Errorf("%w: unknown event %q", ErrInvalidBNPLEvent, event)
+	}
+	return nil
+}
+
+// createBNPLSession tries BNPL-capable providers in priority order until one opens a session
+func createBNPLSession(req *BNPLRequest, correlationID string) (*BNPLResponse, error) {
+	providers, err := providerRegistry.GetEligibleBNPLProviders(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, config := range providers {
+		bnplProvider := config.Provider.(BNPLProvider)
+
+		var resp *BNPLResponse
+		ctx, cancel := context.WithTimeout(withTraceIDs(context.Background(), correlationID, req.ID), bnplProviderTimeout)
+		err := config.Bulkhead.Execute(ctx, func() error {
+			return config.CircuitBreaker.Execute(ctx, func() error {
+				var err error
+				resp, err = bnplProvider.CreateBNPLSession(ctx, req)
+				return err
+			})
+		})
+		cancel()
+
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		log.Printf("[BNPL] Session for %s failed on %s: %v", req.ID, config.Provider.Name(), err)
+
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && !providerErr.Retryable {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func writeBNPLJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func bnplResponse(plan *BNPLPlan) BNPLResponse {
+	return BNPLResponse{
+		BNPLID:      plan.ID,
+		Status:      plan.Status,
+		Provider:    plan.Provider,
+		ApprovalURL: plan.ApprovalURL,
+		ProcessedAt: plan.CreatedAt,
+		Metadata: map[string]interface{}{
+			"term":         plan.Term,
+			"installments": plan.Installments,
+		},
+	}
+}
+
+// BNPLHandler handles POST /bnpl, routing only to BNPL-capable providers
+func BNPLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dataStore == nil {
+		http.Error(w, "BNPL not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req BNPLRequest
+	if err := decodeStrict(r.Body, &req); err != nil {
+		writeRequestError(w, BNPLDeclined, err)
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+	if err := validateStruct(&req); err != nil {
+		writeRequestError(w, BNPLDeclined, err)
+		return
+	}
+	if req.Term == 0 {
+		req.Term = defaultBNPLTerm
+	}
+	if req.Term < 1 || req.Term > maxBNPLTerm || int64(req.Term) > req.Amount {
+		http.Error(w, fmt.Sprintf("term must be between 1 and %d", maxBNPLTerm), http.StatusBadRequest)
+		return
+	}
+
+	merchantID := merchantIDFromContext(r.Context())
+	if existing, err := dataStore.GetBNPLPlanByIdempotencyKey(merchantID, req.IdempotencyKey); err == nil {
+		w.Header().Set("X-Idempotent-Replay", "true")
+		writeBNPLJSON(w, http.StatusOK, bnplResponse(existing))
+		return
+	}
+
+	correlationID, _ := r.Context().Value("correlation_id").(string)
+	resp, err := createBNPLSession(&req, correlationID)
+	if err != nil {
+		code := ErrCodeProviderError
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			code = providerErr.CanonicalCode
+		}
+		writeBNPLJSON(w, http.StatusBadGateway, BNPLResponse{
+			Status:       BNPLDeclined,
+			ProcessedAt:  time.Now(),
+			ErrorCode:    &code,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	plan := &BNPLPlan{
+		ID:                "bnpl_" + uuid.NewString(),
+		Reference:         req.ID,
+		MerchantID:        merchantID,
+		IdempotencyKey:    req.IdempotencyKey,
+		Provider:          resp.Provider,
+		ProviderSessionID: resp.BNPLID,
+		Amount:            req.Amount,
+		Currency:          req.Currency,
+		CustomerEmail:     req.CustomerEmail,
+		Term:              req.Term,
+		Status:            BNPLPendingApproval,
+		ApprovalURL:       resp.ApprovalURL,
+		Installments:      splitInstallments(req.Amount, req.Term),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := dataStore.CreateBNPLPlan(plan); err != nil {
+		http.Error(w, "Failed to create BNPL plan", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[BNPL] Created %s via %s (session %s)", plan.ID, plan.Provider, plan.ProviderSessionID)
+	writeBNPLJSON(w, http.StatusCreated, bnplResponse(plan))
+}
+
+// BNPLPlanHandler handles GET /bnpl/{bnpl_id}
+func BNPLPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "BNPL not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	plan, err := dataStore.GetBNPLPlan(r.PathValue("bnpl_id"))
+	if err == nil && !ownedByCaller(r.Context(), plan.MerchantID) {
+		// Another merchant's plan is reported as missing rather than forbidden
+		err = ErrBNPLPlanNotFound
+	}
+	if errors.Is(err, ErrBNPLPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch BNPL plan", http.StatusInternalServerError)
+		return
+	}
+
+	writeBNPLJSON(w, http.StatusOK, plan)
+}
+
+// BNPLEventHandler handles POST /bnpl/{bnpl_id}/events, where providers report
+// approval decisions and installment outcomes
+func BNPLEventHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "BNPL not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	// The timestamp bounds how long a captured event can be replayed; inside that window
+	// ApplyEvent refuses an event the plan has already taken
+	if !verifyTimestampedWebhookSignature(r, body, os.Getenv("BNPL_WEBHOOK_SECRET")) {
+		http.Error(w, "Invalid or expired webhook signature", http.StatusUnauthorized)
+		return
+	}
+
+	var event struct {
+		Event       string `json:"event"`
+		Installment int    `json:"installment"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := dataStore.GetBNPLPlan(r.PathValue("bnpl_id"))
+	if errors.Is(err, ErrBNPLPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch BNPL plan", http.StatusInternalServerError)
+		return
+	}
+
+	from := plan.Status
+	now := time.Now().UTC()
+	if err := plan.ApplyEvent(strings.ToLower(event.Event), event.Installment, now); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	plan.UpdatedAt = now
+
+	if err := dataStore.UpdateBNPLPlan(plan); err != nil {
+		if errors.Is(err, ErrBNPLConcurrentUpdate) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update BNPL plan", http.StatusInternalServerError)
+		return
+	}
+
+	if from != plan.Status {
+		log.Printf("[BNPL] %s moved %s -> %s", plan.ID, from, plan.Status)
+	}
+	writeBNPLJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var bnplPlanColumns = []string{"id", "reference", "merchant_id", "idempotency_key", "provider", "provider_session_id", "amount", "currency",
+	"customer_email", "term", "status", "approval_url", "installments", "version", "created_at", "updated_at"}
+
+func TestBNPLPlanHandlerHidesOtherMerchantsPlans(t *testing.T) {
+	tests := []struct {
+		merchant string
+		want     int
+	}{
+		{"merchant_a", http.StatusOK},
+		{"merchant_b", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.merchant, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			dataStore = store
+			t.Cleanup(func() { dataStore = nil })
+
+			now := time.Now()
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM bnpl_plans WHERE id = $1`)).
+				WithArgs("bnpl_1").
+				WillReturnRows(sqlmock.NewRows(bnplPlanColumns).
+					AddRow("bnpl_1", "order_1", "merchant_a", "key_1", "klarna", "sess_1", 30000, "USD",
+						"buyer@example.com", 3, "APPROVED", "", "[]", 1, now, now))
+
+			req := httptest.NewRequest(http.MethodGet, "/bnpl/bnpl_1", nil)
+			req.SetPathValue("bnpl_id", "bnpl_1")
+			req = req.WithContext(context.WithValue(req.Context(), "api_key", tt.merchant))
+			rec := httptest.NewRecorder()
+			BNPLPlanHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func bnplEventRequest(body string, sentAt time.Time) *http.Request {
+	timestamp := sentAt.UTC().Format(time.RFC3339)
+	req := httptest.NewRequest(http.MethodPost, "/bnpl/bnpl_1/events", strings.NewReader(body))
+	req.SetPathValue("bnpl_id", "bnpl_1")
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", webhookHMAC("whsec_bnpl", timestamp+"."+body))
+	return req
+}
+
+func TestBNPLEventHandlerRejectsReplays(t *testing.T) {
+	t.Setenv("BNPL_WEBHOOK_SECRET", "whsec_bnpl")
+	store, mock := newMockStore(t)
+	dataStore = store
+	t.Cleanup(func() { dataStore = nil })
+	body := `{"event":"installment_paid","installment":1}`
+
+	// Replayed after the timestamp window: refused before the plan is loaded
+	rec := httptest.NewRecorder()
+	BNPLEventHandler(rec, bnplEventRequest(body, time.Now().Add(-signatureMaxSkew-time.Minute)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale event: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// Replayed inside the window: the installment was already paid
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM bnpl_plans WHERE id = $1`)).
+		WithArgs("bnpl_1").
+		WillReturnRows(sqlmock.NewRows(bnplPlanColumns).
+			AddRow("bnpl_1", "order_1", "merchant_a", "key_1", "klarna", "sess_1", 30000, "USD",
+				"buyer@example.com", 2, BNPLApproved, "",
+				`[{"number":1,"amount":15000,"status":"PAID"},{"number":2,"amount":15000,"status":"SCHEDULED"}]`, 2, now, now))
+
+	rec = httptest.NewRecorder()
+	BNPLEventHandler(rec, bnplEventRequest(body, now))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("repeated event: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
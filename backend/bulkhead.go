@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Bulkhead defaults: in-flight requests per provider, and how long a request may wait
+// for a free slot before it is rejected so it can fail over elsewhere
+const (
+	defaultBulkheadMaxConcurrent = 50
+	defaultBulkheadQueueTimeout  = 200 * time.Millisecond
+)
+
+// Bulkhead caps the requests in flight to one provider, so a slow provider can't
+// tie up every worker goroutine
+type Bulkhead struct {
+	name         string
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	waiting  int64
+	rejected int64
+}
+
+// BulkheadStats is a snapshot of a bulkhead
+type BulkheadStats struct {
+	Provider      string `json:"provider"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	InFlight      int    `json:"in_flight"`
+	Waiting       int64  `json:"waiting"`
+	Rejected      int64  `json:"rejected"`
+}
+
+// NewBulkhead creates a bulkhead admitting maxConcurrent requests at a time
+func NewBulkhead(name string, maxConcurrent int, queueTimeout time.Duration) *Bulkhead {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultBulkheadMaxConcurrent
+	}
+	return &Bulkhead{
+		name:         name,
+		slots:        make(chan struct{}, maxConcurrent),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits up to the queue timeout for a slot. A full bulkhead is reported as
+// PROVIDER_DEGRADED, retryable on another provider
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	atomic.AddInt64(&b.waiting, 1)
+	defer atomic.AddInt64(&b.waiting, -1)
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	rejected := atomic.AddInt64(&b.rejected, 1)
+	if rejected == 1 || rejected%100 == 0 {
+		log.Printf("[Bulkhead] %s: at capacity (%d in flight), %d requests rejected", b.name, cap(b.slots), rejected)
+	}
+
+	err := NewProviderError(ErrCodeProviderDegraded, "bulkhead_full",
+		fmt.Sprintf("%s has %d requests in flight", b.name, cap(b.slots)), ctx.Err())
+	err.Retryable = true
+	return err
+}
+
+// Release frees a slot taken by Acquire
+func (b *Bulkhead) Release() {
+	<-b.slots
+}
+
+// Execute runs fn in a bulkhead slot
+func (b *Bulkhead) Execute(ctx context.Context, fn func() error) error {
+	if err := b.Acquire(ctx); err != nil {
+		return err
+	}
+	defer b.Release()
+
+	return fn()
+}
+
+// GetStats returns a snapshot of the bulkhead
+func (b *Bulkhead) GetStats() BulkheadStats {
+	return BulkheadStats{
+		Provider:      b.name,
+		MaxConcurrent: cap(b.slots),
+		InFlight:      len(b.slots),
+		Waiting:       atomic.LoadInt64(&b.waiting),
+		Rejected:      atomic.LoadInt64(&b.rejected),
+	}
+}
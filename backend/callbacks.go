@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// callbackEventTTL is how long processed provider event IDs are remembered, longer
+// than providers keep redelivering an unacknowledged event
+const callbackEventTTL = 72 * time.Hour
+
+// CallbackEvent is a provider callback normalized across the providers' payload formats
+type CallbackEvent struct {
+	Provider    string `json:"provider"`
+	EventID     string `json:"event_id"`
+	Type        string `json:"type"`
+	PaymentID   string `json:"payment_id"`
+	ProviderRef string `json:"provider_ref,omitempty"` // The provider's own charge or payment ID
+	Message     string `json:"message,omitempty"`      // Failure reason given by the provider
+}
+
+// callbackProvider verifies and parses one provider's callback format. events maps the
+// provider's event types to the state they settle a payment in; other types are ignored
+type callbackProvider struct {
+	verify func(r *http.Request, body []byte, secret string) bool
+	parse  func(r *http.Request, body []byte) (*CallbackEvent, error)
+	events map[string]State
+}
+
+var callbackProviders = map[string]callbackProvider{
+	"stripe": {
+		verify: verifyStripeSignature,
+		parse:  parseStripeEvent,
+		events: map[string]State{
+			"charge.succeeded":              SUCCESS,
+			"charge.failed":                 FAILED,
+			"payment_intent.succeeded":      SUCCESS,
+			"payment_intent.payment_failed": FAILED,
+		},
+	},
+	"razorpay": {
+		verify: verifyRazorpaySignature,
+		parse:  parseRazorpayEvent,
+		events: map[string]State{
+			"payment.captured": SUCCESS,
+			"payment.failed":   FAILED,
+		},
+	},
+}
+
+// genericCallbackProvider handles every other gateway: a flat CallbackEvent body signed
+// with X-Webhook-Signature
+var genericCallbackProvider = callbackProvider{
+	verify: verifyWebhookSignature,
+	parse:  parseGenericEvent,
+	events: map[string]State{
+		"payment.succeeded": SUCCESS,
+		"payment.failed":    FAILED,
+	},
+}
+
+func callbackProviderFor(provider string) callbackProvider {
+	if cp, ok := callbackProviders[provider]; ok {
+		return cp
+	}
+	return genericCallbackProvider
+}
+
+// callbackSecret returns a provider's webhook signing secret from <PROVIDER>_WEBHOOK_SECRET.
+// Callbacks from a provider without a secret are refused
+func callbackSecret(provider string) string {
+	return os.Getenv(strings.ToUpper(provider) + "_WEBHOOK_SECRET")
+}
+
+func hmacSHA256Hex(secret string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyStripeSignature checks a Stripe-Signature header ("t=<unix>,v1=<hex>"), an
+// HMAC-SHA256 of "<t>.<body>". Stale timestamps are rejected so captured callbacks
+// can't be replayed later
+func verifyStripeSignature(r *http.Request, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		return false
+	}
+
+	expected := hmacSHA256Hex(secret, []byte(timestamp+"."), body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRazorpaySignature checks X-Razorpay-Signature, a hex HMAC-SHA256 of the raw body
+func verifyRazorpaySignature(r *http.Request, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	expected := hmacSHA256Hex(secret, body)
+	return hmac.Equal([]byte(r.Header.Get("X-Razorpay-Signature")), []byte(expected))
+}
+
+// parseStripeEvent reads a Stripe event. Our payment ID travels in the charge's metadata
+func parseStripeEvent(r *http.Request, body []byte) (*CallbackEvent, error) {
+	var payload struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID             string            `json:"id"`
+				Metadata       map[string]string `json:"metadata"`
+				FailureMessage string            `json:"failure_message"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	object := payload.Data.Object
+	return &CallbackEvent{
+		EventID:     payload.ID,
+		Type:        payload.Type,
+		PaymentID:   object.Metadata["payment_id"],
+		ProviderRef: object.ID,
+		Message:     object.FailureMessage,
+	}, nil
+}
+
+// parseRazorpayEvent reads a Razorpay webhook. Razorpay sends the event ID in the
+// X-Razorpay-Event-Id header and our payment ID in the payment's notes
+func parseRazorpayEvent(r *http.Request, body []byte) (*CallbackEvent, error) {
+	var payload struct {
+		Event   string `json:"event"`
+		Payload struct {
+			Payment struct {
+				Entity struct {
+					ID               string            `json:"id"`
+					Notes            map[string]string `json:"notes"`
+					ErrorDescription string            `json:"error_description"`
+				} `json:"entity"`
+			} `json:"payment"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	entity := payload.Payload.Payment.Entity
+	return &CallbackEvent{
+		EventID:     r.Header.Get("X-Razorpay-Event-Id"),
+		Type:        payload.Event,
+		PaymentID:   entity.Notes["payment_id"],
+		ProviderRef: entity.ID,
+		Message:     entity.ErrorDescription,
+	}, nil
+}
+
+func parseGenericEvent(r *http.Request, body []byte) (*CallbackEvent, error) {
+	var event CallbackEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// pendingCallback is a payment a gateway accepted but will confirm with a callback
+type pendingCallback struct {
+	req           *PaymentRequest
+	correlationID string
+	provider      string
+	gateway       string
+	latency       time.Duration
+}
+
+// pendingCallbacks holds the payments awaiting a callback by payment ID. Like the state
+// store it is in-process
+var pendingCallbacks sync.Map
+
+// awaitCallback leaves a payment the gateway answered as pending in PROCESSING until
+// the provider's callback settles it
+func awaitCallback(req *PaymentRequest, paymentID, correlationID string, server *ServerMetrics, latency time.Duration) {
+	provider := gatewayName(server.ServerURL)
+	pendingCallbacks.Store(paymentID, &pendingCallback{
+		req:           req,
+		correlationID: correlationID,
+		provider:      provider,
+		gateway:       server.ServerURL,
+		latency:       latency,
+	})
+
+	if err := UpdatePaymentRecord(paymentID, func(record *PaymentRecord) {
+		record.Provider = provider
+		record.LatencyMs = latency.Milliseconds()
+	}); err != nil {
+		log.Printf("Failed to save payment record for %s: %v", paymentID, err)
+	}
+	log.Printf("[Callbacks] %s accepted by %s, awaiting callback", paymentID, provider)
+}
+
+// settleCallback moves a pending payment to the state its callback reports, holding
+// approved payments for review like synchronous ones, and publishes the result
+func settleCallback(pending *pendingCallback, event *CallbackEvent, target State) (State, error) {
+	finalState, reason := target, fmt.Sprintf("%s callback %s", event.Provider, event.Type)
+	if target == SUCCESS {
+		if hold, why := reviewHold(pending.req, event.PaymentID); hold {
+			finalState, reason = REVIEW, why
+		}
+	} else if amlScreener != nil {
+		amlScreener.Discard(event.PaymentID)
+	}
+	if _, err := SetStateWithReason(event.PaymentID, finalState, reason, "callback:"+event.Provider); err != nil {
+		return finalState, err
+	}
+
+	data := map[string]interface{}{
+		"gateway":           pending.gateway,
+		"latency_ms":        pending.latency.Milliseconds(),
+		"provider_event_id": event.EventID,
+	}
+	if len(pending.req.Splits) > 0 && finalState == SUCCESS {
+		data["splits"] = splitSettlements(pending.req.Splits)
+	}
+	paymentResponse := NewSuccessResponse(finalState.String(), event.PaymentID, data)
+
+	record := CompletePayment(event.PaymentID, paymentResponse, func(record *PaymentRecord) {
+		record.Status = finalState.String()
+		if finalState == REVIEW {
+			record.ErrorMessage = reason
+		}
+		if finalState == FAILED {
+			record.ErrorCode = string(ErrProviderError)
+			record.ErrorMessage = event.Message
+		}
+	})
+	if record != nil && finalState == SUCCESS && !pending.req.TestMode {
+		recordCapturedPayment(record)
+	}
+	return finalState, nil
+}
+
+func callbackEventKey(provider, eventID string) string {
+	return "callback_event:" + provider + ":" + eventID
+}
+
+func writeCallbackJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ProviderCallbackHandler handles POST /callbacks/{provider}, the asynchronous
+// confirmation of a payment the provider answered as pending. Each provider event is
+// processed once; redeliveries of an event ID are acknowledged without effect
+func ProviderCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := r.PathValue("provider")
+	if _, err := serverPool.GetServerByGateway(provider); err != nil {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	// A callback can settle a payment and credit the ledger, so it must be signed
+	secret := callbackSecret(provider)
+	if secret == "" {
+		log.Printf("[Callbacks] Refusing %s callback: %s_WEBHOOK_SECRET is not set", provider, strings.ToUpper(provider))
+		http.Error(w, "Callbacks not configured for this provider", http.StatusServiceUnavailable)
+		return
+	}
+	cp := callbackProviderFor(provider)
+	if !cp.verify(r, body, secret) {
+		http.Error(w, "Invalid webhook signature", http.StatusUnauthorized)
+		return
+	}
+	event, err := cp.parse(r, body)
+	if err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	event.Provider = provider
+	if event.EventID == "" || event.PaymentID == "" {
+		http.Error(w, "event ID and payment ID are required", http.StatusBadRequest)
+		return
+	}
+
+	target, known := cp.events[event.Type]
+	if !known {
+		// Acknowledged so the provider stops redelivering events we don't act on
+		writeCallbackJSON(w, http.StatusOK, map[string]interface{}{
+			"received": true,
+			"ignored":  true,
+			"event_id": event.EventID,
+		})
+		return
+	}
+
+	key := callbackEventKey(provider, event.EventID)
+	claimed, err := rdb.SetNX(ctx, key, event.PaymentID, callbackEventTTL).Result()
+	if err != nil {
+		http.Error(w, "Failed to record callback event", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		log.Printf("[Callbacks] Duplicate %s event %s for %s", provider, event.EventID, event.PaymentID)
+		writeCallbackJSON(w, http.StatusOK, map[string]interface{}{
+			"received":  true,
+			"duplicate": true,
+			"event_id":  event.EventID,
+		})
+		return
+	}
+	// Failed events are released so the provider's redelivery is processed
+	release := func() { rdb.Del(ctx, key) }
+
+	value, ok := pendingCallbacks.Load(event.PaymentID)
+	if !ok {
+		state, _, exists := paymentStates.Get(event.PaymentID)
+		if !exists {
+			release()
+			http.Error(w, "Payment not found", http.StatusNotFound)
+			return
+		}
+		// A different event already settled the payment the same way
+		if state == target || (target == SUCCESS && state == REVIEW) {
+			writeCallbackJSON(w, http.StatusOK, map[string]interface{}{
+				"received":   true,
+				"event_id":   event.EventID,
+				"payment_id": event.PaymentID,
+				"status":     state.String(),
+			})
+			return
+		}
+		release()
+		http.Error(w, fmt.Sprintf("payment %s is %s and not awaiting a callback", event.PaymentID, state), http.StatusConflict)
+		return
+	}
+	pending := value.(*pendingCallback)
+	if pending.provider != provider {
+		release()
+		http.Error(w, fmt.Sprintf("payment %s was routed to %s", event.PaymentID, pending.provider), http.StatusConflict)
+		return
+	}
+	if _, loaded := pendingCallbacks.LoadAndDelete(event.PaymentID); !loaded {
+		release()
+		http.Error(w, "Payment is already being settled", http.StatusConflict)
+		return
+	}
+
+	finalState, err := settleCallback(pending, event, target)
+	if err != nil {
+		release()
+		log.Printf("[Callbacks] Could not settle %s from %s event %s: %v", event.PaymentID, provider, event.EventID, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	log.Printf("[Callbacks] %s settled as %s by %s event %s", event.PaymentID, finalState, provider, event.EventID)
+	writeCallbackJSON(w, http.StatusOK, map[string]interface{}{
+		"received":   true,
+		"event_id":   event.EventID,
+		"payment_id": event.PaymentID,
+		"status":     finalState.String(),
+	})
+}
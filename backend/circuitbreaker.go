@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 // CircuitState represents the state of a circuit breaker
@@ -37,6 +40,8 @@ type CircuitBreakerConfig struct {
 	WindowDuration      time.Duration // Duration for error rate calculation
 	CooldownPeriod      time.Duration // How long to wait in OPEN before transitioning to HALF_OPEN
 	HalfOpenMaxRequests int           // Number of successful requests in HALF_OPEN before CLOSED
+	MaxConcurrentProbes int           // Trial requests allowed in flight at once in HALF_OPEN
+	ProbeInterval       time.Duration // Minimum gap between trial requests in HALF_OPEN
 }
 
 // DefaultCircuitBreakerConfig returns production-ready defaults
@@ -47,9 +52,26 @@ func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
 		WindowDuration:      60 * time.Second, // 1 minute window
 		CooldownPeriod:      30 * time.Second, // 30 second cooldown
 		HalfOpenMaxRequests: 5,                // 5 successful probes
+		MaxConcurrentProbes: 2,                // 2 probes in flight
+		ProbeInterval:       time.Second,      // 1 probe per second
 	}
 }
 
+// Shared breaker state in Redis. The lease means state left behind by instances that
+// stopped writing expires instead of holding a circuit open forever
+const (
+	circuitStateLease   = 2 * time.Minute
+	circuitSyncInterval = 500 * time.Millisecond
+	circuitRedisTimeout = 100 * time.Millisecond
+
+	// circuitWriteQueueSize bounds the writes waiting for Redis per breaker
+	circuitWriteQueueSize = 256
+)
+
+func circuitStateKey(name string) string {
+	return "circuit_breaker:" + name
+}
+
 // CircuitBreaker implements the circuit breaker pattern
 type CircuitBreaker struct {
 	name            string
@@ -60,9 +82,17 @@ type CircuitBreaker struct {
 	errorCount      int
 	lastStateChange time.Time
 	lastError       error
+	probesInFlight  int
+	lastProbe       time.Time
+	forcedUntil     time.Time // set while an operator holds the circuit OPEN
 	mu              sync.RWMutex
 	config          CircuitBreakerConfig
 	requestHistory  []requestRecord
+
+	// shared is set when state, counts and last state change are shared with other
+	// instances through Redis. The error-rate window stays local
+	shared       *redis.Client
+	sharedWrites chan sharedWrite
 }
 
 type requestRecord struct {
@@ -81,55 +111,267 @@ func NewCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker
 	}
 }
 
+// EnableSharedState makes the breaker share its decisions with other instances through
+// Redis. Reads and writes happen on a background goroutine, never under cb.mu, so a slow
+// Redis can't stall requests to the provider
+func (cb *CircuitBreaker) EnableSharedState(client *redis.Client) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.shared != nil {
+		return
+	}
+	cb.shared = client
+	cb.sharedWrites = make(chan sharedWrite, circuitWriteQueueSize)
+	go cb.runSharedState(client, cb.sharedWrites)
+}
+
+// sharedWrite is a snapshot of local state to write to Redis. A publish replaces the
+// shared state; otherwise it adds one request result to the shared counts
+type sharedWrite struct {
+	publish         bool
+	success         bool
+	state           CircuitState
+	failureCount    int
+	successCount    int
+	lastStateChange time.Time
+	forcedUntil     time.Time
+}
+
+// snapshot captures the state a shared write needs. Callers hold cb.mu
+func (cb *CircuitBreaker) snapshot() sharedWrite {
+	return sharedWrite{
+		state:           cb.state,
+		failureCount:    cb.failureCount,
+		successCount:    cb.successCount,
+		lastStateChange: cb.lastStateChange,
+		forcedUntil:     cb.forcedUntil,
+	}
+}
+
+// queueSharedWrite hands a write to the shared state goroutine. Callers hold cb.mu.
+// A full queue drops the write; the next publish or sync brings instances back in line
+func (cb *CircuitBreaker) queueSharedWrite(write sharedWrite) {
+	if cb.sharedWrites == nil {
+		return
+	}
+	select {
+	case cb.sharedWrites <- write:
+	default:
+		log.Printf("[CircuitBreaker:%s] Shared state queue full, dropping write", cb.name)
+	}
+}
+
+// runSharedState performs the breaker's Redis I/O: queued writes in order, and a sync
+// every circuitSyncInterval
+func (cb *CircuitBreaker) runSharedState(client *redis.Client, writes <-chan sharedWrite) {
+	cb.syncSharedState(client)
+
+	ticker := time.NewTicker(circuitSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case write := <-writes:
+			if write.publish {
+				cb.publishState(client, write)
+			} else {
+				cb.recordShared(client, write)
+			}
+		case <-ticker.C:
+			cb.syncSharedState(client)
+		}
+	}
+}
+
+// syncSharedState adopts the shared state if it is newer than ours. Redis errors leave
+// the local state in charge
+func (cb *CircuitBreaker) syncSharedState(client *redis.Client) {
+	rCtx, cancel := context.WithTimeout(context.Background(), circuitRedisTimeout)
+	defer cancel()
+
+	fields, err := client.HGetAll(rCtx, circuitStateKey(cb.name)).Result()
+	if err != nil || len(fields) == 0 {
+		return
+	}
+
+	state, err := strconv.Atoi(fields["state"])
+	if err != nil {
+		return
+	}
+	changedAt, _ := strconv.ParseInt(fields["last_state_change"], 10, 64)
+	failureCount, _ := strconv.Atoi(fields["failure_count"])
+	successCount, _ := strconv.Atoi(fields["success_count"])
+	var forcedUntil time.Time
+	if forcedMs, _ := strconv.ParseInt(fields["forced_until"], 10, 64); forcedMs > 0 {
+		forcedUntil = time.UnixMilli(forcedMs)
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failureCount = failureCount
+	cb.successCount = successCount
+	cb.forcedUntil = forcedUntil
+
+	if CircuitState(state) != cb.state && changedAt > cb.lastStateChange.UnixMilli() {
+		log.Printf("[CircuitBreaker:%s] Adopting shared state: %s -> %s", cb.name, cb.state, CircuitState(state))
+		cb.state = CircuitState(state)
+		cb.lastStateChange = time.UnixMilli(changedAt)
+	}
+}
+
+// publishState writes a full state snapshot to Redis
+func (cb *CircuitBreaker) publishState(client *redis.Client, write sharedWrite) {
+	rCtx, cancel := context.WithTimeout(context.Background(), circuitRedisTimeout)
+	defer cancel()
+
+	var forcedUntil int64
+	if !write.forcedUntil.IsZero() {
+		forcedUntil = write.forcedUntil.UnixMilli()
+	}
+
+	// A forced open has to outlive the lease
+	lease := circuitStateLease
+	if remaining := time.Until(write.forcedUntil); remaining > lease {
+		lease = remaining
+	}
+
+	key := circuitStateKey(cb.name)
+	_, err := client.TxPipelined(rCtx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(rCtx, key,
+			"state", int(write.state),
+			"failure_count", write.failureCount,
+			"success_count", write.successCount,
+			"last_state_change", write.lastStateChange.UnixMilli(),
+			"forced_until", forcedUntil,
+		)
+		pipe.Expire(rCtx, key, lease)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[CircuitBreaker:%s] Failed to publish state: %v", cb.name, err)
+	}
+}
+
+// recordShared adds a result to the shared consecutive failure and success counts, so
+// every instance counts towards tripping the breaker. The counts come back with the
+// next sync
+func (cb *CircuitBreaker) recordShared(client *redis.Client, write sharedWrite) {
+	rCtx, cancel := context.WithTimeout(context.Background(), circuitRedisTimeout)
+	defer cancel()
+
+	key := circuitStateKey(cb.name)
+	incremented, reset := "failure_count", "success_count"
+	if write.success {
+		incremented, reset = reset, incremented
+	}
+
+	_, err := client.TxPipelined(rCtx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(rCtx, key, incremented, 1)
+		pipe.HSet(rCtx, key, reset, 0)
+		pipe.HSetNX(rCtx, key, "state", int(write.state))
+		pipe.HSetNX(rCtx, key, "last_state_change", write.lastStateChange.UnixMilli())
+		if time.Until(write.forcedUntil) < circuitStateLease {
+			pipe.Expire(rCtx, key, circuitStateLease)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[CircuitBreaker:%s] Failed to record shared result: %v", cb.name, err)
+	}
+}
+
 // Execute runs the given function with circuit breaker protection
 func (cb *CircuitBreaker) Execute(ctx context.Context, fn func() error) error {
 	// Check if we can proceed
-	if err := cb.beforeRequest(); err != nil {
+	probe, err := cb.beforeRequest()
+	if err != nil {
 		return err
 	}
 
 	// Execute the function
-	err := fn()
+	err = fn()
 
 	// Record the result
-	cb.afterRequest(err)
+	cb.afterRequest(err, probe)
 
 	return err
 }
 
-// beforeRequest checks if the request should be allowed
-func (cb *CircuitBreaker) beforeRequest() error {
+// RecordResult feeds the outcome of a request made outside Execute, such as a health
+// probe, into the breaker. An OPEN circuit ignores it and waits out its cooldown
+func (cb *CircuitBreaker) RecordResult(err error) {
+	if cb.GetState() == StateOpen {
+		return
+	}
+	cb.afterRequest(err, false)
+}
+
+// beforeRequest checks if the request should be allowed. In HALF_OPEN it reports
+// whether the request was admitted as a probe, which afterRequest has to release
+func (cb *CircuitBreaker) beforeRequest() (bool, error) {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
 	switch cb.state {
 	case StateOpen:
+		// A forced open holds until it expires, then probes like a normal cooldown
+		if !cb.forcedUntil.IsZero() {
+			if time.Now().Before(cb.forcedUntil) {
+				return false, fmt.Errorf("circuit breaker is forced open until %s: %s", cb.forcedUntil.Format(time.RFC3339), cb.name)
+			}
+			cb.forcedUntil = time.Time{}
+			cb.transitionTo(StateHalfOpen)
+			log.Printf("[CircuitBreaker:%s] Forced open expired, transitioning to HALF_OPEN", cb.name)
+			return cb.admitProbe()
+		}
+
 		// Check if cooldown period has elapsed
 		if time.Since(cb.lastStateChange) > cb.config.CooldownPeriod {
 			cb.transitionTo(StateHalfOpen)
 			log.Printf("[CircuitBreaker:%s] Transitioning to HALF_OPEN after cooldown", cb.name)
-			return nil
+			return cb.admitProbe()
 		}
 		// Return a properly formatted error
-		return fmt.Errorf("circuit breaker is open: %s", cb.name)
+		return false, fmt.Errorf("circuit breaker is open: %s", cb.name)
 
 	case StateHalfOpen:
-		// Allow limited requests in half-open state
-		return nil
+		// Allow only a few, spaced out probes while the provider recovers
+		return cb.admitProbe()
 
 	case StateClosed:
-		return nil
+		return false, nil
 
 	default:
-		return nil
+		return false, nil
+	}
+}
+
+// admitProbe admits a HALF_OPEN trial request if a probe slot is free and the probe
+// interval has passed. Callers hold cb.mu
+func (cb *CircuitBreaker) admitProbe() (bool, error) {
+	if cb.config.MaxConcurrentProbes > 0 && cb.probesInFlight >= cb.config.MaxConcurrentProbes {
+		return false, fmt.Errorf("circuit breaker is half-open, %d probes in flight: %s", cb.probesInFlight, cb.name)
+	}
+	if cb.config.ProbeInterval > 0 && time.Since(cb.lastProbe) < cb.config.ProbeInterval {
+		return false, fmt.Errorf("circuit breaker is half-open, next probe in %v: %s",
+			(cb.config.ProbeInterval - time.Since(cb.lastProbe)).Round(time.Millisecond), cb.name)
 	}
+
+	cb.probesInFlight++
+	cb.lastProbe = time.Now()
+	return true, nil
 }
 
 // afterRequest records the result and potentially changes state
-func (cb *CircuitBreaker) afterRequest(err error) {
+func (cb *CircuitBreaker) afterRequest(err error, probe bool) {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
+	if probe && cb.probesInFlight > 0 {
+		cb.probesInFlight--
+	}
+
 	// Record in history
 	record := requestRecord{
 		timestamp: time.Now(),
@@ -145,6 +387,7 @@ func (cb *CircuitBreaker) afterRequest(err error) {
 		cb.failureCount++
 		cb.successCount = 0 // Reset consecutive success count
 		cb.lastError = err
+		cb.queueSharedWrite(cb.snapshot())
 
 		switch cb.state {
 		case StateClosed:
@@ -164,6 +407,9 @@ func (cb *CircuitBreaker) afterRequest(err error) {
 		// Success
 		cb.failureCount = 0 // Reset consecutive failure count
 		cb.successCount++
+		result := cb.snapshot()
+		result.success = true
+		cb.queueSharedWrite(result)
 
 		switch cb.state {
 		case StateHalfOpen:
@@ -247,15 +493,26 @@ func (cb *CircuitBreaker) transitionTo(newState CircuitState) {
 	} else if newState == StateHalfOpen {
 		cb.successCount = 0
 		cb.failureCount = 0
+		cb.probesInFlight = 0
+		cb.lastProbe = time.Time{}
 	}
+	cb.publishSnapshot()
 
 	log.Printf("[CircuitBreaker:%s] State transition: %s -> %s", cb.name, oldState, newState)
 }
 
+// publishSnapshot queues the full local state for Redis. Callers hold cb.mu
+func (cb *CircuitBreaker) publishSnapshot() {
+	write := cb.snapshot()
+	write.publish = true
+	cb.queueSharedWrite(write)
+}
+
 // GetState returns the current state (thread-safe)
 func (cb *CircuitBreaker) GetState() CircuitState {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
+
 	return cb.state
 }
 
@@ -276,6 +533,14 @@ func (cb *CircuitBreaker) GetStats() map[string]interface{} {
 		"error_rate":            fmt.Sprintf("%.2f%%", errorRate*100),
 		"last_state_change":     cb.lastStateChange.Format(time.RFC3339),
 		"time_in_current_state": time.Since(cb.lastStateChange).String(),
+		"shared":                cb.shared != nil,
+	}
+	if !cb.forcedUntil.IsZero() {
+		stats["forced_open_until"] = cb.forcedUntil.Format(time.RFC3339)
+	}
+	if cb.state == StateHalfOpen {
+		stats["probes_in_flight"] = cb.probesInFlight
+		stats["max_concurrent_probes"] = cb.config.MaxConcurrentProbes
 	}
 
 	if cb.lastError != nil {
@@ -297,7 +562,24 @@ func (cb *CircuitBreaker) Reset() {
 	cb.errorCount = 0
 	cb.lastStateChange = time.Now()
 	cb.lastError = nil
+	cb.probesInFlight = 0
+	cb.lastProbe = time.Time{}
+	cb.forcedUntil = time.Time{}
 	cb.requestHistory = make([]requestRecord, 0)
+	cb.publishSnapshot()
 
 	log.Printf("[CircuitBreaker:%s] Reset to CLOSED state", cb.name)
 }
+
+// ForceOpen holds the circuit OPEN for duration regardless of results, e.g. during a
+// known provider incident. Reset ends it early
+func (cb *CircuitBreaker) ForceOpen(duration time.Duration) time.Time {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.forcedUntil = time.Now().Add(duration)
+	cb.transitionTo(StateOpen)
+
+	log.Printf("[CircuitBreaker:%s] Forced OPEN until %s", cb.name, cb.forcedUntil.Format(time.RFC3339))
+	return cb.forcedUntil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultComplianceCacheTTL is how long an approval is reused when no TTL is configured
+const DefaultComplianceCacheTTL = 24 * time.Hour
+
+// ComplianceCacheTTLFromEnv parses COMPLIANCE_CACHE_TTL_HOURS. Zero disables caching
+func ComplianceCacheTTLFromEnv() (time.Duration, error) {
+	v := os.Getenv("COMPLIANCE_CACHE_TTL_HOURS")
+	if v == "" {
+		return DefaultComplianceCacheTTL, nil
+	}
+	hours, err := strconv.Atoi(v)
+	if err != nil || hours < 0 {
+		return 0, fmt.Errorf("COMPLIANCE_CACHE_TTL_HOURS must be a non-negative integer, got %q", v)
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+// ComplianceCache keeps approved compliance results per user in Redis, so a user
+// verified moments ago isn't sent through KYC again on every high-value payment.
+// Only approvals are cached; a rejection or review is always rechecked
+type ComplianceCache struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// complianceCache is nil when caching is disabled
+var complianceCache *ComplianceCache
+
+// NewComplianceCache creates a cache that keeps approvals for ttl
+func NewComplianceCache(client *redis.Client, ttl time.Duration) *ComplianceCache {
+	return &ComplianceCache{rdb: client, ttl: ttl}
+}
+
+// complianceCheckTypes lists every check type a user can have cached
+var complianceCheckTypes = []ComplianceCheckType{ComplianceCheckKYC, ComplianceCheckAML}
+
+// complianceCacheKey is namespaced, so an approval from a test-mode check is never
+// reused for a live payment
+func complianceCacheKey(namespace, userID string, checkType ComplianceCheckType) string {
+	return fmt.Sprintf("%scompliance_approval:%s:%s", namespace, checkType, userID)
+}
+
+// Get returns a user's cached approval for a check type
+func (cc *ComplianceCache) Get(ctx context.Context, namespace, userID string, checkType ComplianceCheckType) (*ComplianceCheckResponse, bool) {
+	data, err := cc.rdb.Get(ctx, complianceCacheKey(namespace, userID, checkType)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("[ComplianceCache] Lookup failed for %s: %v", userID, err)
+		}
+		return nil, false
+	}
+
+	var resp ComplianceCheckResponse
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// Put caches an approved result. Anything other than an approval is ignored
+func (cc *ComplianceCache) Put(ctx context.Context, namespace, userID string, checkType ComplianceCheckType, resp *ComplianceCheckResponse) {
+	if resp == nil || resp.Status != ComplianceStatusApproved {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	if err := cc.rdb.Set(ctx, complianceCacheKey(namespace, userID, checkType), data, cc.ttl).Err(); err != nil {
+		log.Printf("[ComplianceCache] Failed to cache approval for %s: %v", userID, err)
+	}
+}
+
+// Invalidate drops every cached approval for a user, live and test-mode, and returns
+// how many were removed
+func (cc *ComplianceCache) Invalidate(ctx context.Context, userID string) (int64, error) {
+	keys := make([]string, 0, 2*len(complianceCheckTypes))
+	for _, namespace := range []string{"", testRedisNamespace} {
+		for _, checkType := range complianceCheckTypes {
+			keys = append(keys, complianceCacheKey(namespace, userID, checkType))
+		}
+	}
+	return cc.rdb.Del(ctx, keys...).Result()
+}
+
+// checkCompliance runs a compliance check, reusing the user's cached approval in the
+// given Redis namespace when there is one. cached reports whether the result came from
+// the cache
+func checkCompliance(ctx context.Context, namespace string, req *ComplianceCheckRequest) (resp *ComplianceCheckResponse, cached bool, err error) {
+	if complianceCache != nil {
+		if resp, ok := complianceCache.Get(ctx, namespace, req.UserID, req.CheckType); ok {
+			return resp, true, nil
+		}
+	}
+
+	resp, err = providerRegistry.PerformComplianceCheck(ctx, req)
+	if err != nil {
+		return nil, false, err
+	}
+	if complianceCache != nil {
+		complianceCache.Put(ctx, namespace, req.UserID, req.CheckType, resp)
+	}
+	return resp, false, nil
+}
+
+// AdminComplianceApprovalHandler handles DELETE /admin/compliance/{user_id}/approval,
+// forcing the user's next high-value payment through a fresh check
+func AdminComplianceApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	if complianceCache == nil {
+		http.Error(w, "Compliance caching is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	removed, err := complianceCache.Invalidate(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to invalidate approval", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "invalidate_compliance_approval", userID, map[string]interface{}{"cached_approvals": removed}, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Removed %d cached approval(s) for %s", removed, userID),
+		"removed": removed,
+	})
+}
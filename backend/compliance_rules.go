@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ComplianceRules decides which transactions need a compliance check. Amounts are
+// in cents. A merchant threshold takes precedence over a currency threshold, which
+// takes precedence over the default
+type ComplianceRules struct {
+	DefaultThreshold   int64            `json:"default_threshold"`
+	CurrencyThresholds map[string]int64 `json:"currency_thresholds"`
+	MerchantThresholds map[string]int64 `json:"merchant_thresholds"`
+	// DailyVolumeLimit checks a user once their cumulative volume for the UTC day
+	// reaches it, 0 disables
+	DailyVolumeLimit int64     `json:"daily_volume_limit"`
+	UpdatedAt        time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultComplianceRules returns the rules used until an admin configures them
+func DefaultComplianceRules() ComplianceRules {
+	return ComplianceRules{
+		DefaultThreshold:   ComplianceThreshold,
+		CurrencyThresholds: make(map[string]int64),
+		MerchantThresholds: make(map[string]int64),
+	}
+}
+
+// ErrInvalidComplianceRules is returned when a rule set fails validation
+var ErrInvalidComplianceRules = errors.New("invalid compliance rules")
+
+// validate checks amounts and normalizes currency codes
+func (cr *ComplianceRules) validate() error {
+	if cr.DefaultThreshold <= 0 {
+		return fmt.Errorf("%w: default_threshold must be positive", ErrInvalidComplianceRules)
+	}
+	if cr.DailyVolumeLimit < 0 {
+		return fmt.Errorf("%w: daily_volume_limit must not be negative", ErrInvalidComplianceRules)
+	}
+
+	currencies := make(map[string]int64, len(cr.CurrencyThresholds))
+	for currency, threshold := range cr.CurrencyThresholds {
+		code := strings.ToUpper(strings.TrimSpace(currency))
+		if len(code) != 3 {
+			return fmt.Errorf("%w: currency %q must be an ISO 4217 code", ErrInvalidComplianceRules, currency)
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("%w: threshold for %s must be positive", ErrInvalidComplianceRules, code)
+		}
+		currencies[code] = threshold
+	}
+	cr.CurrencyThresholds = currencies
+
+	if cr.MerchantThresholds == nil {
+		cr.MerchantThresholds = make(map[string]int64)
+	}
+	for merchant, threshold := range cr.MerchantThresholds {
+		if merchant == "" {
+			return fmt.Errorf("%w: merchant ID must not be empty", ErrInvalidComplianceRules)
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("%w: threshold for merchant %s must be positive", ErrInvalidComplianceRules, merchant)
+		}
+	}
+	return nil
+}
+
+// complianceRulesKey is where the rule set is persisted in Redis
+const complianceRulesKey = "compliance_rules"
+
+// ComplianceRuleEngine holds the active compliance rules and tracks per-user daily volume
+type ComplianceRuleEngine struct {
+	rdb   *redis.Client
+	rules ComplianceRules
+	mu    sync.RWMutex
+}
+
+var complianceRules *ComplianceRuleEngine
+
+// NewComplianceRuleEngine creates an engine and loads persisted rules, falling back to the defaults
+func NewComplianceRuleEngine(client *redis.Client) *ComplianceRuleEngine {
+	engine := &ComplianceRuleEngine{rdb: client, rules: DefaultComplianceRules()}
+
+	data, err := client.Get(ctx, complianceRulesKey).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("[ComplianceRules] Failed to load rules: %v", err)
+		}
+		return engine
+	}
+
+	var rules ComplianceRules
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		log.Printf("[ComplianceRules] Ignoring unreadable rules: %v", err)
+		return engine
+	}
+	if err := rules.validate(); err != nil {
+		log.Printf("[ComplianceRules] Ignoring persisted rules: %v", err)
+		return engine
+	}
+	engine.rules = rules
+	log.Printf("[ComplianceRules] Loaded rules (default threshold %d)", rules.DefaultThreshold)
+	return engine
+}
+
+// Rules returns a copy of the active rules
+func (ce *ComplianceRuleEngine) Rules() ComplianceRules {
+	ce.mu.RLock()
+	defer ce.mu.RUnlock()
+
+	rules := ce.rules
+	rules.CurrencyThresholds = make(map[string]int64, len(ce.rules.CurrencyThresholds))
+	for currency, threshold := range ce.rules.CurrencyThresholds {
+		rules.CurrencyThresholds[currency] = threshold
+	}
+	rules.MerchantThresholds = make(map[string]int64, len(ce.rules.MerchantThresholds))
+	for merchant, threshold := range ce.rules.MerchantThresholds {
+		rules.MerchantThresholds[merchant] = threshold
+	}
+	return rules
+}
+
+// Replace validates, persists and installs a new rule set
+func (ce *ComplianceRuleEngine) Replace(rules ComplianceRules) error {
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	rules.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	if err := ce.rdb.Set(ctx, complianceRulesKey, data, 0).Err(); err != nil {
+		return err
+	}
+	ce.rules = rules
+	return nil
+}
+
+// Threshold returns the amount at or above which a merchant's transactions in a currency are checked
+func (ce *ComplianceRuleEngine) Threshold(merchantID, currency string) int64 {
+	ce.mu.RLock()
+	defer ce.mu.RUnlock()
+
+	if threshold, ok := ce.rules.MerchantThresholds[merchantID]; ok {
+		return threshold
+	}
+	if threshold, ok := ce.rules.CurrencyThresholds[strings.ToUpper(currency)]; ok {
+		return threshold
+	}
+	return ce.rules.DefaultThreshold
+}
+
+func complianceDailyVolumeKey(namespace, userID string, day time.Time) string {
+	return fmt.Sprintf("%scompliance_daily_volume:%s:%s", namespace, userID, day.Format("2006-01-02"))
+}
+
+// RequiresCheck counts the amount towards the user's daily volume in the given Redis
+// namespace and returns the rules the transaction trips, empty when no check is needed
+func (ce *ComplianceRuleEngine) RequiresCheck(ctx context.Context, namespace, merchantID, currency, userID string, amount int64) []string {
+	var reasons []string
+	if amount >= ce.Threshold(merchantID, currency) {
+		reasons = append(reasons, "amount")
+	}
+
+	ce.mu.RLock()
+	limit := ce.rules.DailyVolumeLimit
+	ce.mu.RUnlock()
+	if limit > 0 && userID != "" {
+		key := complianceDailyVolumeKey(namespace, userID, time.Now().UTC())
+		volume, err := ce.rdb.IncrBy(ctx, key, amount).Result()
+		if err != nil {
+			log.Printf("[ComplianceRules] Failed to count daily volume for %s: %v", userID, err)
+		} else {
+			if volume == amount {
+				ce.rdb.Expire(ctx, key, 48*time.Hour)
+			}
+			if volume >= limit {
+				reasons = append(reasons, "daily_volume")
+			}
+		}
+	}
+	return reasons
+}
+
+// AdminComplianceRulesHandler handles /admin/compliance/rules:
+// GET returns the active rules, PUT replaces them
+func AdminComplianceRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if complianceRules == nil {
+		http.Error(w, "Compliance rules not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(complianceRules.Rules())
+
+	case http.MethodPut:
+		var rules ComplianceRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		before := complianceRules.Rules()
+		if err := complianceRules.Replace(rules); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInvalidComplianceRules) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		after := complianceRules.Rules()
+		log.Printf("[ComplianceRules] Updated rules (default threshold %d, daily volume limit %d)", after.DefaultThreshold, after.DailyVolumeLimit)
+		recordAudit(r, "update_compliance_rules", "compliance_rules", before, after)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Compliance rules updated",
+			"rules":   after,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
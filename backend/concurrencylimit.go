@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiterConfig holds configuration for the adaptive concurrency limiter
+type AdaptiveLimiterConfig struct {
+	InitialLimit int     // Starting concurrency limit
+	MinLimit     int     // Limit never drops below this
+	MaxLimit     int     // Limit never grows above this
+	Smoothing    float64 // Weight of each new limit estimate (0.0 to 1.0)
+	BackoffRatio float64 // Multiplier applied to the limit when a request is dropped
+}
+
+// DefaultAdaptiveLimiterConfig returns sensible defaults
+func DefaultAdaptiveLimiterConfig() AdaptiveLimiterConfig {
+	return AdaptiveLimiterConfig{
+		InitialLimit: 100,
+		MinLimit:     10,
+		MaxLimit:     1000,
+		Smoothing:    0.2,
+		BackoffRatio: 0.9,
+	}
+}
+
+// Long-term RTT is a slow moving average, so it tracks the no-load latency and lets
+// the short-term RTT reveal queueing
+const (
+	longRTTAlpha  = 0.01
+	shortRTTAlpha = 0.2
+)
+
+// AdaptiveLimiter adjusts a concurrency limit from observed latency, in the style of
+// Netflix's Gradient2 limiter: while the short-term RTT stays near the long-term
+// baseline the limit grows, and when requests start queueing (short RTT rising) the
+// limit shrinks in proportion. Dropped requests back the limit off multiplicatively
+type AdaptiveLimiter struct {
+	config   AdaptiveLimiterConfig
+	limit    float64
+	longRTT  float64
+	shortRTT float64
+	samples  int64
+	drops    int64
+	mu       sync.Mutex
+}
+
+// AdaptiveLimiterStats is a snapshot of an adaptive limiter
+type AdaptiveLimiterStats struct {
+	Limit      int     `json:"limit"`
+	MinLimit   int     `json:"min_limit"`
+	MaxLimit   int     `json:"max_limit"`
+	LongRTTMs  float64 `json:"long_rtt_ms"`
+	ShortRTTMs float64 `json:"short_rtt_ms"`
+	Gradient   float64 `json:"gradient"`
+	Samples    int64   `json:"samples"`
+	Drops      int64   `json:"drops"`
+}
+
+// NewAdaptiveLimiter creates a limiter starting at config.InitialLimit
+func NewAdaptiveLimiter(config AdaptiveLimiterConfig) *AdaptiveLimiter {
+	return &AdaptiveLimiter{
+		config: config,
+		limit:  float64(config.InitialLimit),
+	}
+}
+
+// Limit returns the current concurrency limit
+func (al *AdaptiveLimiter) Limit() int {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return int(al.limit)
+}
+
+// OnSample updates the limit with a completed request's latency and the number of
+// requests in flight when it started. A dropped request (timed out, cancelled) only
+// backs the limit off
+func (al *AdaptiveLimiter) OnSample(rtt time.Duration, inFlight int, dropped bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.samples++
+	if dropped {
+		al.drops++
+		al.setLimit(al.limit * al.config.BackoffRatio)
+		return
+	}
+
+	sample := float64(rtt)
+	if al.longRTT == 0 {
+		al.longRTT, al.shortRTT = sample, sample
+		return
+	}
+	al.shortRTT += shortRTTAlpha * (sample - al.shortRTT)
+	al.longRTT += longRTTAlpha * (sample - al.longRTT)
+
+	// The baseline must not chase a sustained overload, pull it back towards the short RTT
+	if al.longRTT/al.shortRTT > 2 {
+		al.longRTT *= 0.95
+	}
+
+	// Only grow when the limit is actually being used, an idle server says nothing
+	if float64(inFlight) < al.limit/2 {
+		return
+	}
+
+	gradient := al.gradient()
+	queueSize := math.Sqrt(al.limit)
+	estimate := gradient*al.limit + queueSize
+	al.setLimit((1-al.config.Smoothing)*al.limit + al.config.Smoothing*estimate)
+}
+
+// gradient is the ratio of baseline to current latency, clamped to [0.5, 1.0]. Callers hold al.mu
+func (al *AdaptiveLimiter) gradient() float64 {
+	if al.shortRTT == 0 {
+		return 1
+	}
+	return math.Max(0.5, math.Min(1.0, al.longRTT/al.shortRTT))
+}
+
+// setLimit clamps and stores a new limit. Callers hold al.mu
+func (al *AdaptiveLimiter) setLimit(limit float64) {
+	limit = math.Max(float64(al.config.MinLimit), math.Min(float64(al.config.MaxLimit), limit))
+	al.limit = limit
+}
+
+// GetStats returns a snapshot of the limiter
+func (al *AdaptiveLimiter) GetStats() AdaptiveLimiterStats {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	return AdaptiveLimiterStats{
+		Limit:      int(al.limit),
+		MinLimit:   al.config.MinLimit,
+		MaxLimit:   al.config.MaxLimit,
+		LongRTTMs:  al.longRTT / float64(time.Millisecond),
+		ShortRTTMs: al.shortRTT / float64(time.Millisecond),
+		Gradient:   al.gradient(),
+		Samples:    al.samples,
+		Drops:      al.drops,
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -18,6 +22,9 @@ type ConnectionPoolConfig struct {
 	TLSHandshakeTimeout time.Duration // Timeout for TLS handshake
 	DialTimeout         time.Duration // Timeout for TCP connection establishment
 	KeepAlive           time.Duration // TCP keep-alive interval
+	DNSCacheTTL         time.Duration // How long DNS answers are cached, 0 disables the cache
+	DNSStaleTTL         time.Duration // How long past the TTL an answer is served if a refresh fails
+	Egress              EgressConfig  // Proxy and TLS settings for this provider
 }
 
 // DefaultPoolConfig returns sensible defaults for connection pooling
@@ -31,6 +38,8 @@ func DefaultPoolConfig() ConnectionPoolConfig {
 		TLSHandshakeTimeout: 10 * time.Second,
 		DialTimeout:         5 * time.Second,
 		KeepAlive:           30 * time.Second,
+		DNSCacheTTL:         30 * time.Second,
+		DNSStaleTTL:         5 * time.Minute,
 	}
 }
 
@@ -39,15 +48,43 @@ type ProviderConnectionPool struct {
 	providerName string
 	client       *http.Client
 	config       ConnectionPoolConfig
+	resolver     *CachingResolver // nil when DNS caching is disabled
 	activeConns  atomic.Int32
 	totalReqs    atomic.Int64
 	reuseCount   atomic.Int64
 }
 
-// NewProviderConnectionPool creates a new connection pool for a provider
-func NewProviderConnectionPool(providerName string, config ConnectionPoolConfig) *ProviderConnectionPool {
+// NewProviderConnectionPool creates a new connection pool for a provider. It fails if
+// the egress settings are invalid or their certificate files can't be loaded
+func NewProviderConnectionPool(providerName string, config ConnectionPoolConfig) (*ProviderConnectionPool, error) {
+	proxy, err := config.Egress.proxy()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: false,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if err := config.Egress.applyTLS(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	dialContext := (&net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}).DialContext
+
+	var resolver *CachingResolver
+	if config.DNSCacheTTL > 0 {
+		resolver = NewCachingResolver(config.DNSCacheTTL, config.DNSStaleTTL)
+		dialContext = resolver.DialContext(dialContext)
+	}
+
 	// Create custom transport with pooling configuration
 	transport := &http.Transport{
+		// Egress proxy, nil connects directly
+		Proxy: proxy,
+
 		// Connection pooling settings
 		MaxIdleConns:        config.MaxIdleConns,
 		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
@@ -59,17 +96,11 @@ func NewProviderConnectionPool(providerName string, config ConnectionPoolConfig)
 		ResponseHeaderTimeout: config.RequestTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 
-		// Dialer settings
-		DialContext: (&net.Dialer{
-			Timeout:   config.DialTimeout,
-			KeepAlive: config.KeepAlive,
-		}).DialContext,
+		// Dialer settings, resolving through the DNS cache when enabled
+		DialContext: dialContext,
 
 		// TLS configuration
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-			MinVersion:         tls.VersionTLS12,
-		},
+		TLSClientConfig: tlsConfig,
 
 		// Enable HTTP/2
 		ForceAttemptHTTP2: true,
@@ -94,7 +125,8 @@ func NewProviderConnectionPool(providerName string, config ConnectionPoolConfig)
 		providerName: providerName,
 		client:       client,
 		config:       config,
-	}
+		resolver:     resolver,
+	}, nil
 }
 
 // GetClient returns the HTTP client for this pool
@@ -102,6 +134,47 @@ func (pcp *ProviderConnectionPool) GetClient() *http.Client {
 	return pcp.client
 }
 
+// Do sends a request through the pool, tracing whether it reused an idle connection.
+// The request counts as active until its response body is closed
+func (pcp *ProviderConnectionPool) Do(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			pcp.RecordRequest(info.Reused)
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	setTraceHeaders(req)
+
+	pcp.IncrementActiveConns()
+	var resp *http.Response
+	var err error
+	if debugCapture != nil {
+		resp, err = debugCapture.Do(pcp.providerName, req, pcp.client.Do)
+	} else {
+		resp, err = pcp.client.Do(req)
+	}
+	if err != nil {
+		pcp.DecrementActiveConns()
+		return nil, err
+	}
+
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: pcp.DecrementActiveConns}
+	return resp, nil
+}
+
+// trackedBody calls done once when the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
 // RecordRequest increments request counters
 func (pcp *ProviderConnectionPool) RecordRequest(reuseConn bool) {
 	pcp.totalReqs.Add(1)
@@ -130,6 +203,12 @@ func (pcp *ProviderConnectionPool) GetStats() ConnectionPoolStats {
 		reuseRate = float64(reuseCount) / float64(totalReqs) * 100
 	}
 
+	var dnsStats *DNSCacheStats
+	if pcp.resolver != nil {
+		stats := pcp.resolver.GetStats()
+		dnsStats = &stats
+	}
+
 	return ConnectionPoolStats{
 		ProviderName:     pcp.providerName,
 		ActiveConns:      int(pcp.activeConns.Load()),
@@ -137,7 +216,8 @@ func (pcp *ProviderConnectionPool) GetStats() ConnectionPoolStats {
 		ConnectionReuses: reuseCount,
 		ReuseRate:        reuseRate,
 		MaxConnsPerHost:  pcp.config.MaxConnsPerHost,
-		IdleTimeout:      pcp.config.IdleConnTimeout,
+		IdleTimeout:      pcp.config.IdleConnTimeout.Seconds(),
+		DNS:              dnsStats,
 	}
 }
 
@@ -150,19 +230,22 @@ func (pcp *ProviderConnectionPool) Close() {
 
 // ConnectionPoolStats holds statistics about connection pool usage
 type ConnectionPoolStats struct {
-	ProviderName     string        `json:"provider_name"`
-	ActiveConns      int           `json:"active_connections"`
-	TotalRequests    int64         `json:"total_requests"`
-	ConnectionReuses int64         `json:"connection_reuses"`
-	ReuseRate        float64       `json:"reuse_rate_percent"`
-	MaxConnsPerHost  int           `json:"max_conns_per_host"`
-	IdleTimeout      time.Duration `json:"idle_timeout_seconds"`
+	ProviderName     string  `json:"provider_name"`
+	ActiveConns      int     `json:"active_connections"`
+	TotalRequests    int64   `json:"total_requests"`
+	ConnectionReuses int64   `json:"connection_reuses"`
+	ReuseRate        float64 `json:"reuse_rate_percent"`
+	MaxConnsPerHost  int     `json:"max_conns_per_host"`
+	IdleTimeout      float64 `json:"idle_timeout_seconds"`
+
+	DNS *DNSCacheStats `json:"dns,omitempty"`
 }
 
 // ConnectionPoolManager manages connection pools for all providers
 type ConnectionPoolManager struct {
 	pools  map[string]*ProviderConnectionPool
 	config ConnectionPoolConfig
+	mu     sync.RWMutex
 }
 
 // NewConnectionPoolManager creates a new connection pool manager
@@ -175,23 +258,62 @@ func NewConnectionPoolManager(config ConnectionPoolConfig) *ConnectionPoolManage
 
 // GetOrCreatePool retrieves or creates a connection pool for a provider
 func (cpm *ConnectionPoolManager) GetOrCreatePool(providerName string) *ProviderConnectionPool {
+	cpm.mu.RLock()
+	pool, exists := cpm.pools[providerName]
+	cpm.mu.RUnlock()
+	if exists {
+		return pool
+	}
+
+	cpm.mu.Lock()
+	defer cpm.mu.Unlock()
+
 	if pool, exists := cpm.pools[providerName]; exists {
 		return pool
 	}
 
-	pool := NewProviderConnectionPool(providerName, cpm.config)
+	// The shared config has no egress settings, so it always builds
+	pool, _ = NewProviderConnectionPool(providerName, cpm.config)
 	cpm.pools[providerName] = pool
 	return pool
 }
 
+// ConfigureEgress rebuilds a provider's pool with new egress settings. The old pool's
+// idle connections are closed; requests in flight on it finish normally
+func (cpm *ConnectionPoolManager) ConfigureEgress(providerName string, egress EgressConfig) error {
+	config := cpm.config
+	config.Egress = egress
+
+	pool, err := NewProviderConnectionPool(providerName, config)
+	if err != nil {
+		return err
+	}
+
+	cpm.mu.Lock()
+	old, exists := cpm.pools[providerName]
+	cpm.pools[providerName] = pool
+	cpm.mu.Unlock()
+
+	if exists {
+		old.Close()
+	}
+	return nil
+}
+
 // GetPool retrieves a connection pool by provider name
 func (cpm *ConnectionPoolManager) GetPool(providerName string) (*ProviderConnectionPool, bool) {
+	cpm.mu.RLock()
+	defer cpm.mu.RUnlock()
+
 	pool, exists := cpm.pools[providerName]
 	return pool, exists
 }
 
 // GetAllStats returns statistics for all connection pools
 func (cpm *ConnectionPoolManager) GetAllStats() []ConnectionPoolStats {
+	cpm.mu.RLock()
+	defer cpm.mu.RUnlock()
+
 	stats := make([]ConnectionPoolStats, 0, len(cpm.pools))
 	for _, pool := range cpm.pools {
 		stats = append(stats, pool.GetStats())
@@ -201,23 +323,53 @@ func (cpm *ConnectionPoolManager) GetAllStats() []ConnectionPoolStats {
 
 // CloseAll closes all connection pools
 func (cpm *ConnectionPoolManager) CloseAll() {
+	cpm.mu.RLock()
+	defer cpm.mu.RUnlock()
+
 	for _, pool := range cpm.pools {
 		pool.Close()
 	}
 }
 
 // Global connection pool manager
-var connectionPoolManager *ConnectionPoolManager
+var (
+	connectionPoolManager     *ConnectionPoolManager
+	connectionPoolManagerOnce sync.Once
+)
 
-// InitConnectionPoolManager initializes the global connection pool manager
+// InitConnectionPoolManager initializes the global connection pool manager. Egress
+// settings are per provider and are ignored here
 func InitConnectionPoolManager(config ConnectionPoolConfig) {
+	config.Egress = EgressConfig{}
 	connectionPoolManager = NewConnectionPoolManager(config)
 }
 
 // GetConnectionPoolManager returns the global connection pool manager
 func GetConnectionPoolManager() *ConnectionPoolManager {
-	if connectionPoolManager == nil {
-		connectionPoolManager = NewConnectionPoolManager(DefaultPoolConfig())
-	}
+	connectionPoolManagerOnce.Do(func() {
+		if connectionPoolManager == nil {
+			connectionPoolManager = NewConnectionPoolManager(DefaultPoolConfig())
+		}
+	})
 	return connectionPoolManager
 }
+
+// providerHTTPClient returns the pooled client for a provider's HTTP traffic
+func providerHTTPClient(providerName string) *ProviderConnectionPool {
+	return GetConnectionPoolManager().GetOrCreatePool(providerName)
+}
+
+// AdminConnectionPoolsHandler handles GET /admin/connection-pools
+func AdminConnectionPoolsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pools := GetConnectionPoolManager().GetAllStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pools": pools,
+		"total": len(pools),
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrCustomerNotFound      = errors.New("customer not found")
+	ErrPaymentMethodNotFound = errors.New("payment method not found")
+	ErrPaymentMethodExists   = errors.New("payment token is already attached to this customer")
+)
+
+// Customer is a merchant's customer that saved payment methods are attached to
+type Customer struct {
+	ID         string    `json:"id"`
+	MerchantID string    `json:"merchant_id"`
+	Email      string    `json:"email,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	UserID     string    `json:"user_id,omitempty"` // Merchant's own reference for the customer
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PaymentMethod is a vault token saved to a customer, so later payments can reference
+// it without the card being sent again. Only display details are copied from the token
+type PaymentMethod struct {
+	ID         string    `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	MerchantID string    `json:"merchant_id"`
+	Token      string    `json:"-"`
+	Brand      string    `json:"brand"`
+	Last4      string    `json:"last4"`
+	ExpMonth   int       `json:"exp_month"`
+	ExpYear    int       `json:"exp_year"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CustomerStore persists customers and their saved payment methods
+type CustomerStore interface {
+	CreateCustomer(c *Customer) error
+	GetCustomer(id string) (*Customer, error)
+	ListCustomers(merchantID string) ([]Customer, error)
+	AttachPaymentMethod(pm *PaymentMethod) error
+	GetPaymentMethod(id string) (*PaymentMethod, error)
+	ListPaymentMethods(customerID string) ([]PaymentMethod, error)
+	// DeletePaymentMethod removes a customer's payment method, returning
+	// ErrPaymentMethodNotFound when the customer has no such method
+	DeletePaymentMethod(customerID, id string) error
+}
+
+// merchantCustomer loads a customer, hiding other merchants' customers as not found
+func merchantCustomer(merchantID, customerID string) (*Customer, error) {
+	c, err := dataStore.GetCustomer(customerID)
+	if err != nil {
+		return nil, err
+	}
+	if c.MerchantID != merchantID {
+		return nil, ErrCustomerNotFound
+	}
+	return c, nil
+}
+
+// resolvePaymentMethod returns the vault token behind a customer's saved payment method
+func resolvePaymentMethod(merchantID, customerID, paymentMethodID string) (string, error) {
+	if _, err := merchantCustomer(merchantID, customerID); err != nil {
+		return "", err
+	}
+	pm, err := dataStore.GetPaymentMethod(paymentMethodID)
+	if err != nil {
+		return "", err
+	}
+	if pm.CustomerID != customerID {
+		return "", ErrPaymentMethodNotFound
+	}
+	return pm.Token, nil
+}
+
+// customerErrorStatus maps customer and payment method errors to HTTP status codes
+func customerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrCustomerNotFound), errors.Is(err, ErrPaymentMethodNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrPaymentMethodExists):
+		return http.StatusConflict
+	default:
+		return vaultErrorStatus(err)
+	}
+}
+
+func writeCustomerJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// CustomersHandler handles POST /customers and GET /customers
+func CustomersHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Customers not available", http.StatusServiceUnavailable)
+		return
+	}
+	merchantID := merchantIDFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Email  string `json:"email" validate:"omitempty,email,max=255"`
+			Name   string `json:"name" validate:"max=255"`
+			UserID string `json:"user_id" validate:"max=255"`
+		}
+		if err := decodeStrict(r.Body, &req); err != nil {
+			writeRequestError(w, "", err)
+			return
+		}
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, "", err)
+			return
+		}
+
+		now := time.Now().UTC()
+		c := &Customer{
+			ID:         "cus_" + uuid.NewString(),
+			MerchantID: merchantID,
+			Email:      req.Email,
+			Name:       req.Name,
+			UserID:     req.UserID,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if err := dataStore.CreateCustomer(c); err != nil {
+			log.Printf("[Customers] Failed to create customer: %v", err)
+			http.Error(w, "Failed to create customer", http.StatusInternalServerError)
+			return
+		}
+		writeCustomerJSON(w, http.StatusCreated, c)
+
+	case http.MethodGet:
+		customers, err := dataStore.ListCustomers(merchantID)
+		if err != nil {
+			http.Error(w, "Failed to fetch customers", http.StatusInternalServerError)
+			return
+		}
+		writeCustomerJSON(w, http.StatusOK, map[string]interface{}{
+			"customers": customers,
+			"total":     len(customers),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CustomerHandler handles GET /customers/{customer_id}
+func CustomerHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Customers not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	c, err := merchantCustomer(merchantIDFromContext(r.Context()), r.PathValue("customer_id"))
+	if err != nil {
+		http.Error(w, err.Error(), customerErrorStatus(err))
+		return
+	}
+	writeCustomerJSON(w, http.StatusOK, c)
+}
+
+// CustomerPaymentMethodsHandler handles POST and GET /customers/{customer_id}/payment_methods.
+// POST attaches a vault token created with POST /tokens
+func CustomerPaymentMethodsHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Customers not available", http.StatusServiceUnavailable)
+		return
+	}
+	merchantID := merchantIDFromContext(r.Context())
+	c, err := merchantCustomer(merchantID, r.PathValue("customer_id"))
+	if err != nil {
+		http.Error(w, err.Error(), customerErrorStatus(err))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		v := GetVault()
+		if v == nil {
+			http.Error(w, "Vault not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			PaymentToken string `json:"payment_token" validate:"required"`
+		}
+		if err := decodeStrict(r.Body, &req); err != nil {
+			writeRequestError(w, "", err)
+			return
+		}
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, "", err)
+			return
+		}
+
+		t, err := v.Lookup(merchantID, req.PaymentToken)
+		if err != nil {
+			http.Error(w, err.Error(), vaultErrorStatus(err))
+			return
+		}
+
+		pm := &PaymentMethod{
+			ID:         "pm_" + uuid.NewString(),
+			CustomerID: c.ID,
+			MerchantID: merchantID,
+			Token:      t.Token,
+			Brand:      t.Brand,
+			Last4:      t.Last4,
+			ExpMonth:   t.ExpMonth,
+			ExpYear:    t.ExpYear,
+			CreatedAt:  time.Now().UTC(),
+		}
+		if err := dataStore.AttachPaymentMethod(pm); err != nil {
+			if errors.Is(err, ErrPaymentMethodExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			log.Printf("[Customers] Failed to attach payment method to %s: %v", c.ID, err)
+			http.Error(w, "Failed to attach payment method", http.StatusInternalServerError)
+			return
+		}
+		writeCustomerJSON(w, http.StatusCreated, pm)
+
+	case http.MethodGet:
+		methods, err := dataStore.ListPaymentMethods(c.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch payment methods", http.StatusInternalServerError)
+			return
+		}
+		writeCustomerJSON(w, http.StatusOK, map[string]interface{}{
+			"payment_methods": methods,
+			"total":           len(methods),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CustomerPaymentMethodHandler handles DELETE /customers/{customer_id}/payment_methods/{payment_method_id}.
+// The vault token itself is left to expire
+func CustomerPaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Customers not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	c, err := merchantCustomer(merchantIDFromContext(r.Context()), r.PathValue("customer_id"))
+	if err != nil {
+		http.Error(w, err.Error(), customerErrorStatus(err))
+		return
+	}
+	id := r.PathValue("payment_method_id")
+	if err := dataStore.DeletePaymentMethod(c.ID, id); err != nil {
+		if errors.Is(err, ErrPaymentMethodNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete payment method", http.StatusInternalServerError)
+		return
+	}
+
+	writeCustomerJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Payment method " + id + " deleted",
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -15,20 +14,34 @@ var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 
 var Databaseconnection *sql.DB
 
-func ConnectDatabase() (*sql.DB, error) {
-	mysqlHost := os.Getenv("MYSQL_HOST")
-	mysqlPort := os.Getenv("MYSQL_PORT")
-	mysqlUsername := os.Getenv("MYSQL_USER")
-	mysqlPassword := os.Getenv("MYSQL_PASSWORD")
-	mysqlDatabase := os.Getenv("MYSQL_DATABASE")
+// dataStore is the active persistence backend, selected by DB_DRIVER
+var dataStore Store
 
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", mysqlUsername, mysqlPassword, mysqlHost, mysqlPort, mysqlDatabase)
+// ConnectDatabase opens the database selected by DB_DRIVER ("mysql" or "postgres", default mysql)
+func ConnectDatabase() (*sql.DB, error) {
+	driver := strings.ToLower(os.Getenv("DB_DRIVER"))
+
+	var dialect Dialect
+	var connectionString string
+	switch driver {
+	case "", "mysql":
+		driver = "mysql"
+		dialect = MySQLDialect{}
+		connectionString = mysqlDSN()
+	case "postgres", "postgresql":
+		driver = "postgres"
+		dialect = PostgresDialect{}
+		connectionString = postgresDSN()
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER: %s", driver)
+	}
 
 	var err error
-	Databaseconnection, err = sql.Open("mysql", connectionString)
+	Databaseconnection, err = sql.Open(driver, connectionString)
 	if err != nil {
 		return nil, err
 	}
+	dataStore = NewSQLStore(Databaseconnection, dialect)
 	return Databaseconnection, nil
 }
 
@@ -39,122 +52,20 @@ func DisconnectDatabase() error {
 	return nil
 }
 func CreateDatabases() {
-	Query := `CREATE TABLE IF NOT EXISTS log(
-				id INT AUTO_INCREMENT PRIMARY KEY,
-				payment_id VARCHAR(255),
-				server_url VARCHAR(255) NOT NULL,
-				latency_ms INT NOT NULL,
-				success BOOLEAN NOT NULL,
-				score FLOAT NOT NULL,
-				error_type VARCHAR(50),
-				error_message TEXT,
-				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				INDEX idx_server_url (server_url),
-				INDEX idx_created_at (created_at)
-				);`
-	var err error
-	_, err = Databaseconnection.Exec(Query)
-	if err != nil {
-		fmt.Printf("log table creation failed with error %v\n", err)
+	if err := dataStore.CreateSchema(); err != nil {
+		fmt.Printf("table creation failed with error %v\n", err)
 	}
-
-	usersQuery := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL,
-		email TEXT DEFAULT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	_, err = Databaseconnection.Exec(usersQuery)
-	if err != nil {
-		fmt.Printf("user table creation failure %v\n", err)
-	}
-
 }
 
 func LogRequestMetrics(paymentID, serverURL string, latencyMs int64, success bool, score float64, errorType, errorMessage string) error {
-	if Databaseconnection == nil {
+	if dataStore == nil {
 		return fmt.Errorf("database connection is nil")
 	}
-
-	query := `INSERT INTO log (payment_id, server_url, latency_ms, success, score, error_type, error_message) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-	_, err := Databaseconnection.Exec(query, paymentID, serverURL, latencyMs, success, score, errorType, errorMessage)
-	if err != nil {
-		return fmt.Errorf("failed to log request metrics: %v", err)
-	}
-
-	return nil
+	return dataStore.LogRequestMetrics(paymentID, serverURL, latencyMs, success, score, errorType, errorMessage)
 }
 
-type LogItem struct {
-	TransactionID int    `json:"transaction_id"`
-	Link          string `json:"link"`
-	Name          string `json:"name"`
-	Status        int    `json:"status"`
-	Latency       int    `json:"latency"`
-	CurrentTime   int64  `json:"current_time"`
-}
-
-func GetLogs() ([]LogItem, error) {
-	if Databaseconnection == nil {
-		return nil, fmt.Errorf("database connection is nil")
-	}
-
-	query := `SELECT id, server_url, success, latency_ms, created_at FROM log ORDER BY created_at DESC;`
-	rows, err := Databaseconnection.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var logs []LogItem
-	for rows.Next() {
-		var item LogItem
-		var success bool
-		var createdAt time.Time
-		var serverURL string
-
-		err := rows.Scan(&item.TransactionID, &serverURL, &success, &item.Latency, &createdAt)
-		if err != nil {
-			return nil, err
-		}
-
-		item.Link = serverURL
-		// Extract name from server_url (last segment)
-		parts := strings.Split(strings.TrimRight(serverURL, "/"), "/")
-		if len(parts) > 0 {
-			item.Name = parts[len(parts)-1]
-		} else {
-			item.Name = serverURL
-		}
-
-		if success {
-			item.Status = 1
-		} else {
-			item.Status = 0
-		}
-		item.CurrentTime = createdAt.Unix()
-
-		logs = append(logs, item)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return logs, nil
-}
 func ValidateUser(name, password string, done chan bool, token chan string) error {
-	query := "SELECT id, name, password FROM users WHERE name = ?"
-
-	var userid int64
-	var dbName, dbPassword string
-
-	err := Databaseconnection.QueryRow(query, name).Scan(&userid, &dbName, &dbPassword)
+	userid, dbPassword, err := dataStore.GetUserByName(name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			done <- false
@@ -167,7 +78,7 @@ func ValidateUser(name, password string, done chan bool, token chan string) erro
 	}
 	if VerifyPassword(password, dbPassword) {
 		done <- true
-		go GenerateToken(userid, token)
+		go GenerateToken(userid, name, token)
 		return nil
 	}
 	done <- false
@@ -177,13 +88,17 @@ func ValidateUser(name, password string, done chan bool, token chan string) erro
 
 type Claims struct {
 	UserID int64 `json:"user_id"`
+	// Role is the user's admin role from ADMIN_ROLES, empty for non-admins
+	Role string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID int64, returnToken chan string) {
+func GenerateToken(userID int64, name string, returnToken chan string) {
 	claims := Claims{
 		UserID: userID,
+		Role:   adminRoleForUser(name).String(),
 		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   name,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * 24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
@@ -199,24 +114,17 @@ func GenerateToken(userID int64, returnToken chan string) {
 }
 
 func CreateUser(name, password string, done chan bool, token chan string) error {
-	query := "INSERT INTO users (name, password) VALUES (?, ?)"
 	hashPassword, err := HashPassword(password)
 	if err != nil {
 		fmt.Printf("error : %v", err)
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	result, err := Databaseconnection.Exec(query, name, hashPassword)
+	lastID, err := dataStore.CreateUser(name, hashPassword)
 	if err != nil {
 		fmt.Printf("error : %v", err)
 		return fmt.Errorf("failed to insert server: %w", err)
 	}
-
-	lastID, err := result.LastInsertId()
-	if err != nil {
-		fmt.Printf("error : %v", err)
-		return err
-	}
 	done <- true
-	go GenerateToken(lastID, token)
+	go GenerateToken(lastID, name, token)
 	return nil
 }
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DebugCaptureConfig holds configuration for provider payload capture
+type DebugCaptureConfig struct {
+	Enabled      bool
+	TTL          time.Duration // How long captures are kept
+	MaxBodyBytes int           // Bodies are truncated beyond this
+}
+
+// DefaultDebugCaptureConfig returns sensible defaults. Capture is off unless enabled
+func DefaultDebugCaptureConfig() DebugCaptureConfig {
+	return DebugCaptureConfig{
+		Enabled:      false,
+		TTL:          time.Hour,
+		MaxBodyBytes: 16 * 1024,
+	}
+}
+
+// DebugCaptureConfigFromEnv builds the capture config from DEBUG_CAPTURE_* environment
+// variables, falling back to the defaults
+func DebugCaptureConfigFromEnv() (DebugCaptureConfig, error) {
+	config := DefaultDebugCaptureConfig()
+
+	if v := os.Getenv("DEBUG_CAPTURE_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("DEBUG_CAPTURE_ENABLED must be true or false, got %q", v)
+		}
+		config.Enabled = enabled
+	}
+	if v := os.Getenv("DEBUG_CAPTURE_TTL_MINUTES"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes <= 0 {
+			return config, fmt.Errorf("DEBUG_CAPTURE_TTL_MINUTES must be a positive integer, got %q", v)
+		}
+		config.TTL = time.Duration(minutes) * time.Minute
+	}
+	return config, nil
+}
+
+// CapturedExchange is one masked provider request and its response
+type CapturedExchange struct {
+	Provider        string            `json:"provider"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	RequestBody     interface{}       `json:"request_body,omitempty"`
+	StatusCode      int               `json:"status_code,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    interface{}       `json:"response_body,omitempty"`
+	LatencyMs       int64             `json:"latency_ms"`
+	Error           string            `json:"error,omitempty"`
+	CapturedAt      time.Time         `json:"captured_at"`
+}
+
+// DebugCapture records provider traffic for requests with a correlation ID, so a bad
+// provider response can be inspected after the fact. Card and PII fields are masked
+// before anything is stored, and captures expire after the configured TTL
+type DebugCapture struct {
+	rdb    *redis.Client
+	config DebugCaptureConfig
+}
+
+// debugCapture is nil unless capture is enabled
+var debugCapture *DebugCapture
+
+// NewDebugCapture creates a capture store backed by Redis
+func NewDebugCapture(client *redis.Client, config DebugCaptureConfig) *DebugCapture {
+	return &DebugCapture{rdb: client, config: config}
+}
+
+func debugCaptureKey(correlationID string) string {
+	return "debug_capture:" + correlationID
+}
+
+// requestCorrelationID finds the correlation ID of an outgoing provider request
+func requestCorrelationID(req *http.Request) string {
+	if id := req.Header.Get("X-Correlation-ID"); id != "" {
+		return id
+	}
+	id, _ := req.Context().Value("correlation_id").(string)
+	return id
+}
+
+// Do sends a request with client, capturing the exchange when the request has a
+// correlation ID. Bodies are buffered up to the size limit and handed back unchanged
+func (dc *DebugCapture) Do(provider string, req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	correlationID := requestCorrelationID(req)
+	if correlationID == "" {
+		return do(req)
+	}
+
+	exchange := CapturedExchange{
+		Provider:       provider,
+		Method:         req.Method,
+		URL:            req.URL.Redacted(),
+		RequestHeaders: maskHeaders(req.Header),
+		CapturedAt:     time.Now().UTC(),
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		exchange.RequestBody = dc.maskBody(body)
+	}
+
+	start := time.Now()
+	resp, err := do(req)
+	exchange.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		exchange.Error = err.Error()
+		dc.store(correlationID, exchange)
+		return nil, err
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, int64(dc.config.MaxBodyBytes)+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+
+	exchange.StatusCode = resp.StatusCode
+	exchange.ResponseHeaders = maskHeaders(resp.Header)
+	exchange.ResponseBody = dc.maskBody(body)
+	if readErr != nil {
+		exchange.Error = "reading response body: " + readErr.Error()
+	}
+	dc.store(correlationID, exchange)
+	return resp, nil
+}
+
+// store appends an exchange to the correlation ID's captures and renews their expiry
+func (dc *DebugCapture) store(correlationID string, exchange CapturedExchange) {
+	data, err := json.Marshal(exchange)
+	if err != nil {
+		log.Printf("[DebugCapture] Failed to encode capture for %s: %v", correlationID, err)
+		return
+	}
+
+	storeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := debugCaptureKey(correlationID)
+	pipe := dc.rdb.Pipeline()
+	pipe.RPush(storeCtx, key, data)
+	pipe.Expire(storeCtx, key, dc.config.TTL)
+	if _, err := pipe.Exec(storeCtx); err != nil {
+		log.Printf("[DebugCapture] Failed to store capture for %s: %v", correlationID, err)
+	}
+}
+
+// Get returns the captured exchanges for a correlation ID, oldest first
+func (dc *DebugCapture) Get(ctx context.Context, correlationID string) ([]CapturedExchange, error) {
+	items, err := dc.rdb.LRange(ctx, debugCaptureKey(correlationID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := make([]CapturedExchange, 0, len(items))
+	for _, item := range items {
+		var exchange CapturedExchange
+		if err := json.Unmarshal([]byte(item), &exchange); err != nil {
+			continue
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+// maskBody returns a body fit for storage: JSON with its sensitive fields masked, or
+// other text with card numbers and emails masked. Oversized bodies are truncated
+func (dc *DebugCapture) maskBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	truncated := len(body) > dc.config.MaxBodyBytes
+	if truncated {
+		body = body[:dc.config.MaxBodyBytes]
+	}
+
+	var parsed interface{}
+	if !truncated && json.Unmarshal(body, &parsed) == nil {
+		return maskPIIValue(parsed)
+	}
+
+	text := maskFreeText(string(body))
+	if truncated {
+		text += fmt.Sprintf("... [truncated at %d bytes]", dc.config.MaxBodyBytes)
+	}
+	return text
+}
+
+// maskPIIValue applies maskPII to every object in a decoded JSON value
+func maskPIIValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := maskPII(v)
+		for key, nested := range masked {
+			switch nested.(type) {
+			case map[string]interface{}, []interface{}:
+				masked[key] = maskPIIValue(nested)
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = maskPIIValue(item)
+		}
+		return masked
+	default:
+		return v
+	}
+}
+
+var (
+	cardNumberPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	emailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// maskFreeText masks card numbers and emails in unstructured text
+func maskFreeText(s string) string {
+	s = cardNumberPattern.ReplaceAllStringFunc(s, maskCardNumber)
+	return emailPattern.ReplaceAllStringFunc(s, maskEmail)
+}
+
+// sensitiveHeaders are never stored, whatever their value
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Signature":         true,
+}
+
+// maskHeaders flattens headers for storage, redacting credentials
+func maskHeaders(header http.Header) map[string]string {
+	masked := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			masked[name] = "[REDACTED]"
+			continue
+		}
+		masked[name] = strings.Join(values, ", ")
+	}
+	return maskStringFields(masked)
+}
+
+// maskStringFields runs maskPII over a string map
+func maskStringFields(fields map[string]string) map[string]string {
+	generic := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		generic[k] = v
+	}
+	masked := make(map[string]string, len(fields))
+	for k, v := range maskPII(generic) {
+		masked[k] = fmt.Sprint(v)
+	}
+	return masked
+}
+
+// AdminDebugCaptureHandler handles GET /admin/debug/{correlation_id}
+func AdminDebugCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	if debugCapture == nil {
+		http.Error(w, "Debug capture is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	correlationID := r.PathValue("correlation_id")
+	exchanges, err := debugCapture.Get(r.Context(), correlationID)
+	if err != nil {
+		http.Error(w, "Failed to fetch captures", http.StatusInternalServerError)
+		return
+	}
+	if len(exchanges) == 0 {
+		http.Error(w, "No captures for this correlation ID, they may have expired", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"correlation_id": correlationID,
+		"exchanges":      exchanges,
+		"total":          len(exchanges),
+	})
+}
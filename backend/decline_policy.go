@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Some bank declines are not final. A generic DO_NOT_HONOR often passes on another
+// acquirer, and an issuer that was unreachable usually answers a few seconds later.
+// The decline policy maps decline codes to what the cascade does next: fail over to the
+// next provider, retry the same provider once after a pause, or stop. Codes missing from
+// the policy are terminal, as are all declines before smart retries. An API key can
+// override the default policy for its own payments
+
+// DeclineAction is what the cascade does after a decline
+type DeclineAction string
+
+const (
+	DeclineRetryAlternate DeclineAction = "retry_alternate"
+	DeclineRetryLater     DeclineAction = "retry_later"
+	DeclineTerminal       DeclineAction = "terminal"
+)
+
+// declineRetryDelay is the pause before a retry_later decline is retried, unless the
+// gateway asked for a longer one
+const declineRetryDelay = 3 * time.Second
+
+// DeclinePolicy maps normalized decline codes to actions
+type DeclinePolicy map[string]DeclineAction
+
+// defaultDeclinePolicy applies to every payment; merchants override single codes
+var defaultDeclinePolicy = DeclinePolicy{
+	"DO_NOT_HONOR":          DeclineRetryAlternate,
+	"GENERIC_DECLINE":       DeclineRetryAlternate,
+	"PROCESSING_ERROR":      DeclineRetryAlternate,
+	"REENTER_TRANSACTION":   DeclineRetryAlternate,
+	"ISSUER_UNAVAILABLE":    DeclineRetryLater,
+	"TRY_AGAIN_LATER":       DeclineRetryLater,
+	"CARD_DECLINED":         DeclineTerminal,
+	"INSUFFICIENT_FUNDS":    DeclineTerminal,
+	"AUTHENTICATION_FAILED": DeclineTerminal,
+	"EXPIRED_CARD":          DeclineTerminal,
+	"INCORRECT_CVC":         DeclineTerminal,
+	"LOST_CARD":             DeclineTerminal,
+	"STOLEN_CARD":           DeclineTerminal,
+	"PICKUP_CARD":           DeclineTerminal,
+	"FRAUDULENT":            DeclineTerminal,
+}
+
+// normalizeDeclineCode uppercases a code and joins its words with underscores, so
+// "do_not_honor", "Do Not Honor" and "do-not-honor" are the same code
+func normalizeDeclineCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "_", " ", "_").Replace(code)
+}
+
+// Action returns the action for a decline code, falling back to the default policy. A
+// nil policy is the default policy
+func (p DeclinePolicy) Action(code string) DeclineAction {
+	code = normalizeDeclineCode(code)
+	if action, ok := p[code]; ok {
+		return action
+	}
+	if action, ok := defaultDeclinePolicy[code]; ok {
+		return action
+	}
+	return DeclineTerminal
+}
+
+// ParseDeclinePolicy validates merchant overrides and normalizes their codes. An empty
+// policy parses to nil
+func ParseDeclinePolicy(overrides map[string]DeclineAction) (DeclinePolicy, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	policy := make(DeclinePolicy, len(overrides))
+	for code, action := range overrides {
+		normalized := normalizeDeclineCode(code)
+		if normalized == "" {
+			return nil, errors.New("decline codes must not be empty")
+		}
+		switch action {
+		case DeclineRetryAlternate, DeclineRetryLater, DeclineTerminal:
+		default:
+			return nil, fmt.Errorf("%s: action must be %s, %s or %s, got %q", code, DeclineRetryAlternate, DeclineRetryLater, DeclineTerminal, action)
+		}
+		policy[normalized] = action
+	}
+	return policy, nil
+}
+
+// merchantDeclinePolicy returns the decline policy overrides of a merchant's API key
+func merchantDeclinePolicy(merchantID string) DeclinePolicy {
+	if apiKeyStore == nil {
+		return nil
+	}
+	key, exists := apiKeyStore.FindKey(merchantID)
+	if !exists {
+		return nil
+	}
+	return apiKeyStore.GetDeclinePolicy(key)
+}
+
+// declineAction returns what the policy says to do after a gateway result. Only bank
+// declines carry a decline code; other failures are left to the retry policy
+func declineAction(req *PaymentRequest, result *gatewayResult) DeclineAction {
+	if result.success || result.pending || result.retryable || result.declineCode == "" {
+		return ""
+	}
+	return req.DeclinePolicy.Action(result.declineCode)
+}
+
+// waitForDeclineRetry pauses before retrying a retry_later decline, and reports whether
+// the payment's time budget leaves room for the retry
+func waitForDeclineRetry(ctx context.Context, result *gatewayResult) bool {
+	delay := declineRetryDelay
+	if result.retryAfter > delay {
+		delay = result.retryAfter
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// declineRetryIdempotencyKey is the idempotency key of a retry_later re-attempt. Sent
+// with the payment's own key, the provider would replay the decline it just returned
+func declineRetryIdempotencyKey(paymentID string) string {
+	return paymentID + "-r1"
+}
+
+// withIdempotencyKey overrides the Idempotency-Key sent to gateways, which is the
+// payment ID otherwise
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, "idempotency_key", key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context, paymentID string) string {
+	if key, _ := ctx.Value("idempotency_key").(string); key != "" {
+		return key
+	}
+	return paymentID
+}
+
+// effectiveDeclinePolicy merges merchant overrides over the default policy
+func effectiveDeclinePolicy(overrides DeclinePolicy) DeclinePolicy {
+	effective := make(DeclinePolicy, len(defaultDeclinePolicy)+len(overrides))
+	for code, action := range defaultDeclinePolicy {
+		effective[code] = action
+	}
+	for code, action := range overrides {
+		effective[code] = action
+	}
+	return effective
+}
+
+// AdminAPIKeyDeclinePolicyHandler returns (GET) or replaces (PUT) a key's decline
+// policy overrides. GET also returns the policy in effect for the key; an empty PUT
+// body ({}) goes back to the default policy
+func AdminAPIKeyDeclinePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID := r.PathValue("key")
+	key, exists := apiKeyStore.FindKey(keyID)
+	if !exists {
+		http.Error(w, fmt.Sprintf("API key '%s' not found", keyID), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		overrides := apiKeyStore.GetDeclinePolicy(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":       keyID,
+			"overrides": overrides,
+			"effective": effectiveDeclinePolicy(overrides),
+		})
+
+	case http.MethodPut:
+		var payload map[string]DeclineAction
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		policy, err := ParseDeclinePolicy(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		before := apiKeyStore.GetDeclinePolicy(key)
+		if err := apiKeyStore.SetDeclinePolicy(keyID, policy); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		recordAudit(r, "update_api_key_decline_policy", keyID, before, policy)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"message":   "Decline policy updated",
+			"key":       keyID,
+			"overrides": policy,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// A statement descriptor is the text a payment shows on the customer's card statement.
+// Merchants set it per payment with statement_descriptor, or a descriptor_suffix added
+// to the provider's default. Requests are checked against the card networks' common
+// rules; each provider adapter then applies its own limits and field names when the
+// payment is sent, see DescriptorProvider
+
+// maxDescriptorLength is the card networks' limit on a statement descriptor
+const maxDescriptorLength = 22
+
+// minDescriptorLength keeps descriptors recognizable on a statement
+const minDescriptorLength = 5
+
+// descriptorPattern is the character set every provider accepts. '*' is left out since
+// providers put it between a descriptor and its suffix
+var descriptorPattern = regexp.MustCompile(`^[A-Za-z0-9 .,&#\-]+$`)
+
+var descriptorLetter = regexp.MustCompile(`[A-Za-z]`)
+
+// StatementDescriptor is the descriptor requested for a payment
+type StatementDescriptor struct {
+	Descriptor string `json:"statement_descriptor,omitempty"`
+	Suffix     string `json:"descriptor_suffix,omitempty"`
+}
+
+// IsZero reports whether no descriptor was requested
+func (d StatementDescriptor) IsZero() bool {
+	return d.Descriptor == "" && d.Suffix == ""
+}
+
+// validateDescriptorText checks one descriptor field against the common rules
+func validateDescriptorText(field, text string, minLength int) *FieldError {
+	switch {
+	case len(text) < minLength:
+		return &FieldError{Field: field, Rule: "min", Message: fmt.Sprintf("%s must be at least %d characters", field, minLength)}
+	case len(text) > maxDescriptorLength:
+		return &FieldError{Field: field, Rule: "max", Message: fmt.Sprintf("%s must be at most %d characters", field, maxDescriptorLength)}
+	case !descriptorPattern.MatchString(text):
+		return &FieldError{Field: field, Rule: "charset", Message: fmt.Sprintf("%s may only contain letters, digits, spaces and . , & # -", field)}
+	case !descriptorLetter.MatchString(text):
+		return &FieldError{Field: field, Rule: "letter", Message: fmt.Sprintf("%s must contain at least one letter", field)}
+	}
+	return nil
+}
+
+// validateStatementDescriptor trims a payment's descriptor fields and checks them.
+// Failures are reported as a *ValidationError
+func validateStatementDescriptor(d *StatementDescriptor) error {
+	d.Descriptor = strings.TrimSpace(d.Descriptor)
+	d.Suffix = strings.TrimSpace(d.Suffix)
+
+	var fields []FieldError
+	if d.Descriptor != "" {
+		if fe := validateDescriptorText("statement_descriptor", d.Descriptor, minDescriptorLength); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	if d.Suffix != "" {
+		if fe := validateDescriptorText("descriptor_suffix", d.Suffix, 1); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// withStatementDescriptor carries a payment's descriptor to the gateway requests
+func withStatementDescriptor(ctx context.Context, d StatementDescriptor) context.Context {
+	if d.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, "statement_descriptor", d)
+}
+
+// descriptorFields returns the payload fields carrying a descriptor to a gateway, in
+// the format of its adapter. Gateways that aren't registered providers get the fields
+// as requested; providers that don't print merchant descriptors get none
+func descriptorFields(gateway string, d StatementDescriptor) map[string]string {
+	config, err := providerRegistry.GetPaymentProvider(gateway)
+	if err != nil {
+		fields := make(map[string]string, 2)
+		if d.Descriptor != "" {
+			fields["statement_descriptor"] = d.Descriptor
+		}
+		if d.Suffix != "" {
+			fields["descriptor_suffix"] = d.Suffix
+		}
+		return fields
+	}
+	adapter, ok := config.Provider.(DescriptorProvider)
+	if !ok {
+		return nil
+	}
+	fields, err := adapter.FormatDescriptor(d)
+	if err != nil {
+		// The provider's default descriptor is printed instead; failing the payment
+		// over statement text would be worse
+		log.Printf("[Descriptors] %s rejected descriptor %q/%q, using its default: %v", gateway, d.Descriptor, d.Suffix, err)
+		return nil
+	}
+	return fields
+}
+
+// providerPayload rewrites a payment payload for one gateway with the merchant's
+// override for it and the statement descriptor in the gateway's format. The payload is
+// returned unchanged when neither applies
+func providerPayload(ctx context.Context, gateway string, payload []byte) []byte {
+	overrides, _ := ctx.Value("provider_overrides").(*ProviderPreferences)
+	override, _ := overrides.Override(gateway)
+	descriptor, _ := ctx.Value("statement_descriptor").(StatementDescriptor)
+	if override.Descriptor != "" {
+		descriptor.Descriptor = override.Descriptor
+	}
+	if override.MCC == "" && descriptor.IsZero() {
+		return payload
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		log.Printf("[Descriptors] Can't rewrite payload for %s, it is not an object: %v", gateway, err)
+		return payload
+	}
+	if override.MCC != "" {
+		fields["mcc"] = override.MCC
+	}
+	if !descriptor.IsZero() {
+		for name, value := range descriptorFields(gateway, descriptor) {
+			fields[name] = value
+		}
+	}
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return payload
+	}
+	return rewritten
+}
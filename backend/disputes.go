@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Dispute states
+const (
+	DisputeOpen              = "OPEN"
+	DisputeEvidenceSubmitted = "EVIDENCE_SUBMITTED"
+	DisputeWon               = "WON"
+	DisputeLost              = "LOST"
+)
+
+// OPEN -> EVIDENCE_SUBMITTED,WON,LOST
+// EVIDENCE_SUBMITTED -> WON,LOST
+var disputeTransitions = map[string][]string{
+	DisputeOpen:              {DisputeEvidenceSubmitted, DisputeWon, DisputeLost},
+	DisputeEvidenceSubmitted: {DisputeWon, DisputeLost},
+}
+
+var (
+	ErrDisputeNotFound          = errors.New("dispute not found")
+	ErrInvalidDisputeTransition = errors.New("invalid dispute state change")
+	ErrDisputeExceedsAmount     = errors.New("disputes would exceed the disputable amount")
+	// ErrDisputeNotStored means a valid notice could not be saved, e.g. because it lost
+	// a race with a concurrent notice; the provider should redeliver it
+	ErrDisputeNotStored = errors.New("dispute could not be stored")
+)
+
+// Dispute is a chargeback raised by a provider against a captured payment
+type Dispute struct {
+	ID                string    `json:"id"`
+	PaymentID         string    `json:"payment_id"`
+	MerchantID        string    `json:"merchant_id"`
+	Provider          string    `json:"provider"`
+	ProviderDisputeID string    `json:"provider_dispute_id"`
+	Amount            int64     `json:"amount"`
+	Currency          string    `json:"currency"`
+	Reason            string    `json:"reason,omitempty"`
+	Status            string    `json:"status"`
+	Evidence          string    `json:"evidence,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// DisputeStore persists disputes
+type DisputeStore interface {
+	// CreateDisputeWithinLimit saves d only if the payment's open and lost disputes,
+	// d included, stay within limit; otherwise it returns ErrDisputeExceedsAmount
+	CreateDisputeWithinLimit(d *Dispute, limit int64) error
+	GetDispute(merchantID, id string) (*Dispute, error)
+	GetDisputeByProviderRef(provider, providerDisputeID string) (*Dispute, error)
+	// UpdateDispute saves d only if its stored status still equals fromStatus
+	UpdateDispute(d *Dispute, fromStatus string) error
+	ListDisputes(merchantID, paymentID, status string) ([]Dispute, error)
+}
+
+// DisputeNotice is the payload providers push to the dispute webhook
+type DisputeNotice struct {
+	Provider          string `json:"provider"`
+	ProviderDisputeID string `json:"provider_dispute_id"`
+	PaymentID         string `json:"payment_id"`
+	Amount            int64  `json:"amount"`
+	Currency          string `json:"currency"`
+	Reason            string `json:"reason"`
+	Status            string `json:"status"`
+}
+
+func canTransitionDispute(from, to string) bool {
+	for _, next := range disputeTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionDispute moves a dispute to a new state and posts a chargeback on loss
+func TransitionDispute(d *Dispute, to string) error {
+	if !canTransitionDispute(d.Status, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidDisputeTransition, d.Status, to)
+	}
+
+	from := d.Status
+	d.Status = to
+	d.UpdatedAt = time.Now().UTC()
+	if err := dataStore.UpdateDispute(d, from); err != nil {
+		d.Status = from
+		return err
+	}
+
+	log.Printf("[Disputes] %s for payment %s moved %s -> %s", d.ID, d.PaymentID, from, to)
+
+	if to == DisputeLost {
+		if l := GetLedger(); l != nil {
+			if err := l.RecordChargeback(d); err != nil {
+				log.Printf("[Disputes] Failed to record chargeback for %s: %v", d.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// openDispute creates a dispute for a notice, linking it to the original payment. Only
+// a captured payment can be disputed, by the provider that captured it, in its
+// currency, and its open and lost disputes together can't exceed what was captured
+// less what was refunded. Won disputes no longer hold any of the payment
+func openDispute(notice DisputeNotice) (*Dispute, error) {
+	record, err := GetPaymentRecord(notice.PaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("payment %s not found", notice.PaymentID)
+	}
+	if record.Status != SUCCESS.String() {
+		return nil, fmt.Errorf("payment %s is %s, only captured payments can be disputed", record.PaymentID, record.Status)
+	}
+	if notice.Provider != record.Provider {
+		return nil, fmt.Errorf("payment %s was processed by %q, not %q", record.PaymentID, record.Provider, notice.Provider)
+	}
+	if notice.Currency != "" && !strings.EqualFold(notice.Currency, record.Currency) {
+		return nil, fmt.Errorf("dispute currency %s does not match payment currency %s", notice.Currency, record.Currency)
+	}
+
+	now := time.Now().UTC()
+	d := &Dispute{
+		ID:                "dp_" + uuid.NewString(),
+		PaymentID:         record.PaymentID,
+		MerchantID:        record.MerchantID,
+		Provider:          notice.Provider,
+		ProviderDisputeID: notice.ProviderDisputeID,
+		Amount:            notice.Amount,
+		Currency:          notice.Currency,
+		Reason:            notice.Reason,
+		Status:            DisputeOpen,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if d.Amount == 0 {
+		d.Amount = record.Amount
+	}
+	if d.MerchantID == "" {
+		d.MerchantID = "default"
+	}
+	d.Currency = record.Currency
+	if d.Amount <= 0 || d.Amount > record.Amount {
+		return nil, fmt.Errorf("invalid dispute amount %d for payment amount %d", d.Amount, record.Amount)
+	}
+	refunded, err := refundedAmount(ctx, record.PaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refunded amount for payment %s: %v", record.PaymentID, err)
+	}
+	refunded = max(refunded, record.RefundedAmount)
+
+	if err := dataStore.CreateDisputeWithinLimit(d, record.Amount-refunded); err != nil {
+		if errors.Is(err, ErrDisputeExceedsAmount) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrDisputeNotStored, err)
+	}
+	log.Printf("[Disputes] Opened %s for payment %s (%s %s)", d.ID, d.PaymentID, d.Provider, d.ProviderDisputeID)
+	return d, nil
+}
+
+func writeDisputeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func disputeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidDisputeTransition):
+		return http.StatusConflict
+	case errors.Is(err, ErrDisputeNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// DisputeWebhookHandler handles POST /disputes/webhook. The first notice for a
+// provider dispute opens it; later notices carrying a status advance it
+func DisputeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dataStore == nil {
+		http.Error(w, "Disputes not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	// Lost disputes post chargebacks to the ledger, so unsigned notices are never accepted
+	secret := os.Getenv("DISPUTE_WEBHOOK_SECRET")
+	if secret == "" {
+		http.Error(w, "Dispute webhook not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if !verifyTimestampedWebhookSignature(r, body, secret) {
+		http.Error(w, "Invalid or expired webhook signature", http.StatusUnauthorized)
+		return
+	}
+
+	var notice DisputeNotice
+	if err := json.Unmarshal(body, &notice); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if notice.Provider == "" || notice.ProviderDisputeID == "" {
+		http.Error(w, "provider and provider_dispute_id are required", http.StatusBadRequest)
+		return
+	}
+	notice.Status = strings.ToUpper(notice.Status)
+
+	d, err := dataStore.GetDisputeByProviderRef(notice.Provider, notice.ProviderDisputeID)
+	if errors.Is(err, ErrDisputeNotFound) {
+		if notice.PaymentID == "" {
+			http.Error(w, "payment_id is required for a new dispute", http.StatusBadRequest)
+			return
+		}
+		d, err = openDispute(notice)
+		if errors.Is(err, ErrDisputeNotStored) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if notice.Status == "" || notice.Status == DisputeOpen {
+			writeDisputeJSON(w, http.StatusCreated, d)
+			return
+		}
+	} else if err != nil {
+		http.Error(w, "Failed to fetch dispute", http.StatusInternalServerError)
+		return
+	}
+
+	// Providers may redeliver a notice; repeating the current status is a no-op
+	if notice.Status != "" && notice.Status != d.Status {
+		if err := TransitionDispute(d, notice.Status); err != nil {
+			http.Error(w, err.Error(), disputeErrorStatus(err))
+			return
+		}
+	}
+
+	writeDisputeJSON(w, http.StatusOK, d)
+}
+
+// DisputesHandler handles GET /disputes?payment_id=&status=, listing the caller's disputes
+func DisputesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dataStore == nil {
+		http.Error(w, "Disputes not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	disputes, err := dataStore.ListDisputes(merchantIDFromContext(r.Context()), q.Get("payment_id"), strings.ToUpper(q.Get("status")))
+	if err != nil {
+		http.Error(w, "Failed to fetch disputes", http.StatusInternalServerError)
+		return
+	}
+
+	writeDisputeJSON(w, http.StatusOK, map[string]interface{}{
+		"disputes": disputes,
+		"total":    len(disputes),
+	})
+}
+
+// DisputeHandler handles GET /disputes/{dispute_id}. Other merchants' disputes are not found
+func DisputeHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Disputes not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	d, err := dataStore.GetDispute(merchantIDFromContext(r.Context()), r.PathValue("dispute_id"))
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	writeDisputeJSON(w, http.StatusOK, d)
+}
+
+// DisputeEvidenceHandler handles POST /disputes/{dispute_id}/evidence for the caller's own disputes
+func DisputeEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Disputes not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Evidence string `json:"evidence"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Evidence == "" {
+		http.Error(w, "evidence is required", http.StatusBadRequest)
+		return
+	}
+
+	d, err := dataStore.GetDispute(merchantIDFromContext(r.Context()), r.PathValue("dispute_id"))
+	if err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	d.Evidence = req.Evidence
+	if err := TransitionDispute(d, DisputeEvidenceSubmitted); err != nil {
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	writeDisputeJSON(w, http.StatusOK, d)
+}
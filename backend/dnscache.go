@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dnsEntry is a cached resolution of one host
+type dnsEntry struct {
+	addrs      []string
+	resolvedAt time.Time
+}
+
+// CachingResolver caches a provider's DNS lookups for ttl. When a refresh fails, the
+// previous answer is served for up to staleTTL longer (stale-if-error), so a DNS
+// outage doesn't take down a provider whose endpoints are still reachable
+type CachingResolver struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+	staleTTL time.Duration
+	entries  map[string]dnsEntry
+	mu       sync.RWMutex
+
+	lookups      atomic.Int64
+	cacheHits    atomic.Int64
+	failures     atomic.Int64
+	staleServed  atomic.Int64
+	lookupTimeNs atomic.Int64
+}
+
+// DNSCacheStats holds statistics about a provider's DNS resolution
+type DNSCacheStats struct {
+	CachedHosts     int     `json:"cached_hosts"`
+	Lookups         int64   `json:"lookups"`
+	CacheHits       int64   `json:"cache_hits"`
+	Failures        int64   `json:"failures"`
+	StaleServed     int64   `json:"stale_served"`
+	AvgLookupTimeMs float64 `json:"avg_lookup_time_ms"`
+	TTLSeconds      float64 `json:"ttl_seconds"`
+}
+
+// NewCachingResolver creates a resolver caching answers for ttl and serving them stale
+// for up to staleTTL when a refresh fails
+func NewCachingResolver(ttl, staleTTL time.Duration) *CachingResolver {
+	return &CachingResolver{
+		resolver: net.DefaultResolver,
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		entries:  make(map[string]dnsEntry),
+	}
+}
+
+// LookupHost resolves host, from the cache while the entry is fresh
+func (cr *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	cr.mu.RLock()
+	entry, cached := cr.entries[host]
+	cr.mu.RUnlock()
+
+	if cached && time.Since(entry.resolvedAt) < cr.ttl {
+		cr.cacheHits.Add(1)
+		return entry.addrs, nil
+	}
+
+	start := time.Now()
+	addrs, err := cr.resolver.LookupHost(ctx, host)
+	cr.lookups.Add(1)
+	cr.lookupTimeNs.Add(int64(time.Since(start)))
+
+	if err != nil {
+		cr.failures.Add(1)
+		if cached && time.Since(entry.resolvedAt) < cr.ttl+cr.staleTTL {
+			cr.staleServed.Add(1)
+			log.Printf("[DNSCache] Lookup for %s failed, serving stale answer from %s: %v",
+				host, entry.resolvedAt.Format(time.RFC3339), err)
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+
+	cr.mu.Lock()
+	cr.entries[host] = dnsEntry{addrs: addrs, resolvedAt: time.Now()}
+	cr.mu.Unlock()
+	return addrs, nil
+}
+
+// DialContext wraps dial so hostnames are resolved through the cache. Each resolved
+// address is tried in turn until one connects
+func (cr *CachingResolver) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := cr.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, lastErr
+	}
+}
+
+// GetStats returns resolution statistics
+func (cr *CachingResolver) GetStats() DNSCacheStats {
+	cr.mu.RLock()
+	cachedHosts := len(cr.entries)
+	cr.mu.RUnlock()
+
+	lookups := cr.lookups.Load()
+	avgLookupMs := 0.0
+	if lookups > 0 {
+		avgLookupMs = float64(cr.lookupTimeNs.Load()) / float64(lookups) / float64(time.Millisecond)
+	}
+
+	return DNSCacheStats{
+		CachedHosts:     cachedHosts,
+		Lookups:         lookups,
+		CacheHits:       cr.cacheHits.Load(),
+		Failures:        cr.failures.Load(),
+		StaleServed:     cr.staleServed.Load(),
+		AvgLookupTimeMs: avgLookupMs,
+		TTLSeconds:      cr.ttl.Seconds(),
+	}
+}
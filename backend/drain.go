@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Draining lets an operator take an instance out of service before a deploy: new
+// payments are refused with 503 and a Retry-After, while payments already in flight
+// finish and status reads, WebSocket subscriptions and refunds keep working. Draining
+// also fails /readyz so load balancers move traffic elsewhere
+
+// defaultDrainRetryAfter is the Retry-After sent to refused payments unless the drain
+// request sets one
+const defaultDrainRetryAfter = 30 * time.Second
+
+// paymentsInFlight counts payments between startPayment and the end of their
+// processing, i.e. with a held payment lock
+var paymentsInFlight atomic.Int64
+
+// DrainController tracks whether this instance is draining
+type DrainController struct {
+	mu         sync.RWMutex
+	draining   bool
+	since      time.Time
+	reason     string
+	retryAfter time.Duration
+}
+
+var drainController = &DrainController{}
+
+// DrainStatus is the drain state reported by the admin endpoints
+type DrainStatus struct {
+	Draining          bool       `json:"draining"`
+	Since             *time.Time `json:"since,omitempty"`
+	Reason            string     `json:"reason,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"`
+	InFlight          int64      `json:"in_flight"`
+}
+
+// Drain starts refusing new payments. Draining again updates the reason and
+// Retry-After but keeps the original start time
+func (dc *DrainController) Drain(reason string, retryAfter time.Duration) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if !dc.draining {
+		dc.draining = true
+		dc.since = time.Now().UTC()
+	}
+	dc.reason = reason
+	dc.retryAfter = retryAfter
+}
+
+// Undrain resumes accepting payments
+func (dc *DrainController) Undrain() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.draining = false
+	dc.since = time.Time{}
+	dc.reason = ""
+	dc.retryAfter = 0
+}
+
+// Draining reports whether new payments are refused, and the Retry-After to send
+func (dc *DrainController) Draining() (bool, time.Duration) {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	return dc.draining, dc.retryAfter
+}
+
+func (dc *DrainController) Status() DrainStatus {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+	status := DrainStatus{Draining: dc.draining, InFlight: paymentsInFlight.Load()}
+	if dc.draining {
+		since := dc.since
+		status.Since = &since
+		status.Reason = dc.reason
+		status.RetryAfterSeconds = int(dc.retryAfter.Seconds())
+	}
+	return status
+}
+
+// rejectWhileDraining responds 503 to a new payment if the instance is draining, and
+// reports whether it did
+func rejectWhileDraining(w http.ResponseWriter) bool {
+	draining, retryAfter := drainController.Draining()
+	if !draining {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(NewErrorResponse(
+		ErrServiceUnavailable,
+		"Not accepting new payments",
+		"REJECTED",
+		"The instance is draining for maintenance, retry after the Retry-After delay",
+	))
+	return true
+}
+
+// AdminDrainHandler reports the drain state (GET) or starts draining (POST). The POST
+// body is optional: {"reason": "deploy", "retry_after_seconds": 60}
+func AdminDrainHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Reason            string `json:"reason"`
+			RetryAfterSeconds int    `json:"retry_after_seconds"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.RetryAfterSeconds < 0 {
+			http.Error(w, "retry_after_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		retryAfter := defaultDrainRetryAfter
+		if req.RetryAfterSeconds > 0 {
+			retryAfter = time.Duration(req.RetryAfterSeconds) * time.Second
+		}
+
+		before := drainController.Status()
+		drainController.Drain(req.Reason, retryAfter)
+		recordAudit(r, "drain", "payments", before, drainController.Status())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drainController.Status())
+}
+
+// AdminUndrainHandler resumes accepting payments
+func AdminUndrainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	before := drainController.Status()
+	drainController.Undrain()
+	recordAudit(r, "undrain", "payments", before, drainController.Status())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drainController.Status())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// EgressConfig routes a provider's traffic through an egress proxy and customizes its
+// TLS: a private CA bundle to verify the acquirer and a client certificate for mTLS.
+// Files are PEM encoded and read when the provider's transport is built
+type EgressConfig struct {
+	ProxyURL       string `json:"proxy_url,omitempty"`
+	CABundleFile   string `json:"ca_bundle_file,omitempty"`
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+}
+
+// IsZero reports whether no egress settings are configured
+func (e EgressConfig) IsZero() bool {
+	return e == EgressConfig{}
+}
+
+// proxy returns the transport proxy function, nil for direct connections
+func (e EgressConfig) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if e.ProxyURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(e.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy_url scheme must be http, https or socks5, got %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("proxy_url has no host")
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// applyTLS adds the CA bundle and client certificate to a TLS config
+func (e EgressConfig) applyTLS(tlsConfig *tls.Config) error {
+	if e.CABundleFile != "" {
+		pem, err := os.ReadFile(e.CABundleFile)
+		if err != nil {
+			return fmt.Errorf("reading ca_bundle_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("ca_bundle_file contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (e.ClientCertFile == "") != (e.ClientKeyFile == "") {
+		return errors.New("client_cert_file and client_key_file must be set together")
+	}
+	if e.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.ClientCertFile, e.ClientKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
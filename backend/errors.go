@@ -9,9 +9,16 @@ const (
 	ErrPaymentIDRequired  ErrorCode = "PAYMENT_ID_REQUIRED"
 	ErrPaymentKeyNotFound ErrorCode = "PAYMENT_KEY_NOT_FOUND"
 	ErrPaymentIDMismatch  ErrorCode = "PAYMENT_ID_MISMATCH"
+	ErrPaymentNotFound    ErrorCode = "PAYMENT_NOT_FOUND"
 	ErrInsufficientFunds  ErrorCode = "INSUFFICIENT_FUNDS"
 	ErrCardDeclined       ErrorCode = "CARD_DECLINED"
 	ErrAuthFailed         ErrorCode = "AUTHENTICATION_FAILED"
+	ErrReplayDetected     ErrorCode = "REPLAY_DETECTED"
+	// ErrIdempotencyKeyReused is returned when an Idempotency-Key is sent again with a different body
+	ErrIdempotencyKeyReused ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	// ErrRefundExceedsBalance is returned when a refund is larger than what is left to refund
+	ErrRefundExceedsBalance ErrorCode = "REFUND_EXCEEDS_BALANCE"
+	ErrPaymentNotRefundable ErrorCode = "PAYMENT_NOT_REFUNDABLE"
 
 	// Provider errors (retryable)
 	ErrNoHealthyServers   ErrorCode = "NO_HEALTHY_SERVERS"
@@ -38,18 +45,23 @@ const (
 	ErrDatabaseError ErrorCode = "DATABASE_ERROR"
 	ErrCircuitOpen   ErrorCode = "CIRCUIT_OPEN"
 	ErrPanic         ErrorCode = "PANIC"
+	// ErrServiceUnavailable is returned while the instance isn't ready to take traffic
+	ErrServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
 
 	// Compliance errors
 	ErrComplianceFailed ErrorCode = "COMPLIANCE_FAILED"
 	ErrKYCRequired      ErrorCode = "KYC_REQUIRED"
+	ErrFraudDeclined    ErrorCode = "FRAUD_DECLINED"
+	ErrStepUpRequired   ErrorCode = "STEP_UP_REQUIRED"
 )
 
 type ErrorResponse struct {
-	Success   bool      `json:"success"`
-	ErrorCode ErrorCode `json:"error_code"`
-	Message   string    `json:"message"`
-	Status    string    `json:"status,omitempty"`
-	Details   string    `json:"details,omitempty"`
+	Success   bool         `json:"success"`
+	ErrorCode ErrorCode    `json:"error_code"`
+	Message   string       `json:"message"`
+	Status    string       `json:"status,omitempty"`
+	Details   string       `json:"details,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"` // Field-level validation failures
 }
 
 type SuccessResponse struct {
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Fraud rule scopes: whose payments a rule counts
+const (
+	FraudScopeUser = "user"
+	FraudScopeCard = "card"
+)
+
+// Fraud rule metrics
+const (
+	FraudMetricCount  = "count"
+	FraudMetricVolume = "volume"
+)
+
+// Fraud actions, in increasing severity
+const (
+	FraudActionAllow   = "allow"
+	FraudActionReview  = "review"
+	FraudActionDecline = "decline"
+)
+
+var fraudActionSeverity = map[string]int{
+	FraudActionAllow:   0,
+	FraudActionReview:  1,
+	FraudActionDecline: 2,
+}
+
+// FraudRule fires when a user's or card's payments over a sliding window exceed a
+// threshold, e.g. more than 5 payments in 60s. Volume thresholds are in cents
+type FraudRule struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	Scope         string `json:"scope"`
+	Metric        string `json:"metric"`
+	WindowSeconds int    `json:"window_seconds"`
+	Threshold     int64  `json:"threshold"`
+	Action        string `json:"action"`
+	Disabled      bool   `json:"disabled,omitempty"`
+}
+
+// ErrInvalidFraudRule is returned when a rule fails validation
+var ErrInvalidFraudRule = errors.New("invalid fraud rule")
+
+func validateFraudRule(rule *FraudRule) error {
+	if rule.Scope != FraudScopeUser && rule.Scope != FraudScopeCard {
+		return fmt.Errorf("scope must be %s or %s", FraudScopeUser, FraudScopeCard)
+	}
+	if rule.Metric != FraudMetricCount && rule.Metric != FraudMetricVolume {
+		return fmt.Errorf("metric must be %s or %s", FraudMetricCount, FraudMetricVolume)
+	}
+	if rule.WindowSeconds <= 0 || rule.WindowSeconds > int(maxFraudWindow/time.Second) {
+		return fmt.Errorf("window_seconds must be between 1 and %d", int(maxFraudWindow/time.Second))
+	}
+	if rule.Threshold <= 0 {
+		return errors.New("threshold must be positive")
+	}
+	if rule.Action != FraudActionReview && rule.Action != FraudActionDecline {
+		return fmt.Errorf("action must be %s or %s", FraudActionReview, FraudActionDecline)
+	}
+	return nil
+}
+
+// FraudRuleHit records a rule that fired and the value that tripped it
+type FraudRuleHit struct {
+	RuleID    string `json:"rule_id"`
+	Name      string `json:"name,omitempty"`
+	Scope     string `json:"scope"`
+	Metric    string `json:"metric"`
+	Value     int64  `json:"value"`
+	Threshold int64  `json:"threshold"`
+	Action    string `json:"action"`
+}
+
+// FraudDecision is the outcome of evaluating a payment: the most severe action among
+// the rules that fired
+type FraudDecision struct {
+	Action string         `json:"action"`
+	Hits   []FraudRuleHit `json:"hits,omitempty"`
+}
+
+// Reason summarizes the rules that fired, for state transitions and error details
+func (d *FraudDecision) Reason() string {
+	names := make([]string, len(d.Hits))
+	for i, hit := range d.Hits {
+		name := hit.Name
+		if name == "" {
+			name = hit.RuleID
+		}
+		names[i] = fmt.Sprintf("%s (%s %s %d > %d)", name, hit.Scope, hit.Metric, hit.Value, hit.Threshold)
+	}
+	return "fraud rules matched: " + strings.Join(names, ", ")
+}
+
+// maxFraudWindow bounds rule windows, and with it how long velocity history is kept
+const maxFraudWindow = 24 * time.Hour
+
+// fraudRulesKey is where the rule set is persisted in Redis
+const fraudRulesKey = "fraud_rules"
+
+// FraudEngine tracks payment velocity per user and per card token in Redis sorted
+// sets and evaluates the configured rules against it
+type FraudEngine struct {
+	rdb   *redis.Client
+	rules []FraudRule
+	mu    sync.RWMutex
+}
+
+var fraudEngine *FraudEngine
+
+// NewFraudEngine creates an engine and loads persisted rules. There are no rules
+// until an admin configures them
+func NewFraudEngine(client *redis.Client) *FraudEngine {
+	engine := &FraudEngine{rdb: client, rules: make([]FraudRule, 0)}
+
+	data, err := client.Get(ctx, fraudRulesKey).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("[Fraud] Failed to load rules: %v", err)
+		}
+		return engine
+	}
+	var rules []FraudRule
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		log.Printf("[Fraud] Ignoring unreadable rules: %v", err)
+		return engine
+	}
+	engine.rules = rules
+	log.Printf("[Fraud] Loaded %d rules", len(rules))
+	return engine
+}
+
+// Rules returns a copy of the current rules
+func (fe *FraudEngine) Rules() []FraudRule {
+	fe.mu.RLock()
+	defer fe.mu.RUnlock()
+
+	rules := make([]FraudRule, len(fe.rules))
+	copy(rules, fe.rules)
+	return rules
+}
+
+// Replace validates, persists and installs a new rule set
+func (fe *FraudEngine) Replace(rules []FraudRule) error {
+	for i := range rules {
+		if err := validateFraudRule(&rules[i]); err != nil {
+			return fmt.Errorf("%w: rule %d: %v", ErrInvalidFraudRule, i, err)
+		}
+		if rules[i].ID == "" {
+			rules[i].ID = "fr_" + uuid.NewString()
+		}
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if err := fe.rdb.Set(ctx, fraudRulesKey, data, 0).Err(); err != nil {
+		return err
+	}
+	fe.rules = rules
+	return nil
+}
+
+// fraudVelocityKey is namespaced like the payment, so test-mode payments only count
+// towards test-mode velocity
+func fraudVelocityKey(namespace, scope, subject string) string {
+	return fmt.Sprintf("%sfraud_velocity:%s:%s", namespace, scope, subject)
+}
+
+// velocity records the payment against a subject's history and returns the count and
+// volume of the subject's payments inside each requested window. Members are keyed
+// by payment ID so a resubmitted payment is only counted once
+func (fe *FraudEngine) velocity(ctx context.Context, scope, subject, paymentID string, amount int64, windows []time.Duration) (map[time.Duration][2]int64, error) {
+	key := fraudVelocityKey(paymentNamespace(paymentID), scope, subject)
+	now := time.Now()
+
+	// Drop any earlier entry for this payment, in case it was resubmitted with a new amount
+	existing, err := fe.rdb.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := fe.rdb.TxPipeline()
+	for _, member := range existing {
+		if strings.HasPrefix(member, paymentID+"|") {
+			pipe.ZRem(ctx, key, member)
+		}
+	}
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: fmt.Sprintf("%s|%d", paymentID, amount)})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-maxFraudWindow).UnixMilli(), 10))
+	pipe.Expire(ctx, key, maxFraudWindow)
+	recent := pipe.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "+inf"})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[time.Duration][2]int64, len(windows))
+	for _, entry := range recent.Val() {
+		member, _ := entry.Member.(string)
+		_, amountStr, _ := strings.Cut(member, "|")
+		entryAmount, _ := strconv.ParseInt(amountStr, 10, 64)
+		at := time.UnixMilli(int64(entry.Score))
+		for _, window := range windows {
+			if now.Sub(at) <= window {
+				s := stats[window]
+				stats[window] = [2]int64{s[0] + 1, s[1] + entryAmount}
+			}
+		}
+	}
+	return stats, nil
+}
+
+// Evaluate counts the payment towards the user's and card's velocity and returns the
+// decision of the rules it trips. Velocity lookups that fail are logged and skipped,
+// so a Redis outage doesn't block payments
+func (fe *FraudEngine) Evaluate(ctx context.Context, paymentID, userID, cardToken string, amount int64) *FraudDecision {
+	decision := &FraudDecision{Action: FraudActionAllow}
+	rules := fe.Rules()
+	if len(rules) == 0 {
+		return decision
+	}
+
+	subjects := map[string]string{FraudScopeUser: userID, FraudScopeCard: cardToken}
+	for scope, subject := range subjects {
+		if subject == "" {
+			continue
+		}
+		var windows []time.Duration
+		for _, rule := range rules {
+			if rule.Scope == scope && !rule.Disabled {
+				windows = append(windows, time.Duration(rule.WindowSeconds)*time.Second)
+			}
+		}
+		if len(windows) == 0 {
+			continue
+		}
+
+		stats, err := fe.velocity(ctx, scope, subject, paymentID, amount, windows)
+		if err != nil {
+			log.Printf("[Fraud] Failed to track %s velocity for %s: %v", scope, paymentID, err)
+			continue
+		}
+		for _, rule := range rules {
+			if rule.Scope != scope || rule.Disabled {
+				continue
+			}
+			s := stats[time.Duration(rule.WindowSeconds)*time.Second]
+			value := s[0]
+			if rule.Metric == FraudMetricVolume {
+				value = s[1]
+			}
+			if value <= rule.Threshold {
+				continue
+			}
+			decision.Hits = append(decision.Hits, FraudRuleHit{
+				RuleID:    rule.ID,
+				Name:      rule.Name,
+				Scope:     rule.Scope,
+				Metric:    rule.Metric,
+				Value:     value,
+				Threshold: rule.Threshold,
+				Action:    rule.Action,
+			})
+			if fraudActionSeverity[rule.Action] > fraudActionSeverity[decision.Action] {
+				decision.Action = rule.Action
+			}
+		}
+	}
+	return decision
+}
+
+// fraudReviewReason reports whether a payment's fraud decision holds it for review once authorized
+func fraudReviewReason(req *PaymentRequest) (bool, string) {
+	decision, ok := req.Metadata["fraud"].(*FraudDecision)
+	if !ok || decision.Action != FraudActionReview {
+		return false, ""
+	}
+	return true, decision.Reason()
+}
+
+// AdminFraudRulesHandler handles /admin/fraud/rules:
+// GET lists rules, PUT replaces the whole rule set
+func AdminFraudRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if fraudEngine == nil {
+		http.Error(w, "Fraud rules not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules := fraudEngine.Rules()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rules": rules,
+			"total": len(rules),
+		})
+
+	case http.MethodPut:
+		var rules []FraudRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		before := fraudEngine.Rules()
+		if err := fraudEngine.Replace(rules); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInvalidFraudRule) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		log.Printf("[Fraud] Replaced rule set (%d rules)", len(rules))
+		recordAudit(r, "replace_fraud_rules", "fraud_rules", before, rules)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Fraud rules updated",
+			"rules":   rules,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -67,7 +67,7 @@ document.addEventListener("DOMContentLoaded", () => {
             const username = localStorage.getItem("bp_username") || "";
 
             try {
-                const response = await fetch('https://vortex.aromal.dev/paymentkey', {
+                const response = await fetch('https://vortex.aromal.dev/paymentKey', {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json'
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// gatewayResult is the outcome of a single payment request to a gateway
+type gatewayResult struct {
+	server     *ServerMetrics
+	statusCode int
+	body       map[string]interface{}
+	latency    time.Duration
+	success    bool
+	// retryable is set when another gateway may still succeed, e.g. network errors
+	// and 5xx responses. A definitive decline is not retryable
+	retryable bool
+	errorMsg  string
+	err       error
+	// rateLimited is set on a 429, with the delay the gateway asked for if it sent one
+	rateLimited bool
+	retryAfter  time.Duration
+	// pending is set when the gateway accepted the payment but confirms it later with
+	// a callback to /callbacks/{provider}
+	pending bool
+	// declineCode is set on a bank decline, normalized, see decline_policy.go
+	declineCode string
+}
+
+// attemptGateway posts a payment to one gateway and records the outcome in its metrics.
+// The payment ID is sent as the Idempotency-Key so a gateway that receives the same
+// payment twice (retries, hedging) charges it only once
+func attemptGateway(ctx context.Context, server *ServerMetrics, payload []byte, paymentID, correlationID string, attempt int) *gatewayResult {
+	result := &gatewayResult{server: server}
+	gatewayURL := server.ServerURL
+
+	// Test-mode traffic stays out of the scores and SLAs live routing depends on
+	testMode := isTestModeContext(ctx)
+	recordResult := serverPool.RecordRequestResult
+	if testMode {
+		recordResult = func(string, string, time.Duration, bool, *ErrorType, string) {}
+	}
+
+	appLogger.Info("Routing payment to gateway", map[string]interface{}{
+		"correlation_id": correlationID,
+		"payment_id":     paymentID,
+		"gateway":        gatewayURL,
+		"attempt":        attempt + 1,
+	})
+
+	// Registered providers are called through their bulkhead; a full one fails over
+	if config, err := providerRegistry.GetPaymentProvider(gatewayName(gatewayURL)); err == nil && config.Bulkhead != nil {
+		if err := config.Bulkhead.Acquire(ctx); err != nil {
+			result.err = err
+			result.errorMsg = string(ErrCodeProviderDegraded)
+			result.retryable = true
+			return result
+		}
+		defer config.Bulkhead.Release()
+	}
+
+	server.BeginRequest()
+	defer server.EndRequest()
+
+	startTime := time.Now()
+	payload = providerPayload(ctx, gatewayName(gatewayURL), payload)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, gatewayURL, bytes.NewBuffer(payload))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", idempotencyKeyFromContext(ctx, paymentID))
+	httpReq.Header.Set("X-Correlation-ID", correlationID)
+	httpReq.Header.Set("X-Payment-ID", paymentID)
+	if token := fencingTokenFromContext(ctx); token != "" {
+		httpReq.Header.Set("X-Fencing-Token", token)
+	}
+
+	response, err := providerHTTPClient(gatewayName(gatewayURL)).Do(httpReq)
+	result.latency = time.Since(startTime)
+
+	if err != nil {
+		result.err = err
+		// A request cancelled by us (hedging loser) says nothing about the gateway's health
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return result
+		}
+		result.retryable = true
+
+		errorType := ErrorTypeNetwork
+		recordResult(paymentID, gatewayURL, result.latency, false, &errorType, err.Error())
+
+		appLogger.Error("Gateway request failed", map[string]interface{}{
+			"correlation_id": correlationID,
+			"payment_id":     paymentID,
+			"gateway":        gatewayURL,
+			"error":          err.Error(),
+			"latency_ms":     result.latency.Milliseconds(),
+		})
+		return result
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	result.statusCode = response.StatusCode
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		result.rateLimited = true
+		result.retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+		defer func() {
+			if providerSelector != nil && !testMode {
+				providerSelector.MarkRateLimited(gatewayName(gatewayURL), result.retryAfter)
+			}
+		}()
+	}
+
+	if err != nil {
+		errorType := ErrorTypeGateway
+		recordResult(paymentID, gatewayURL, result.latency, false, &errorType, "Failed to read response body")
+		result.err = err
+		result.retryable = true
+		return result
+	}
+
+	result.body = make(map[string]interface{})
+	if err := json.Unmarshal(responseBody, &result.body); err != nil {
+		errorType := ErrorTypeGateway
+		recordResult(paymentID, gatewayURL, result.latency, false, &errorType, "Invalid JSON response")
+		result.err = err
+		result.retryable = true
+		return result
+	}
+
+	// Some gateways only send the delay in the body
+	if seconds, ok := result.body["retry_after"].(float64); ok && result.rateLimited && result.retryAfter == 0 {
+		result.retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var errorType *ErrorType
+	responseStatus, ok := result.body["status"].(string)
+	if ok && responseStatus == "success" {
+		result.success = true
+
+		appLogger.Info("Payment successful", map[string]interface{}{
+			"correlation_id": correlationID,
+			"payment_id":     paymentID,
+			"gateway":        gatewayURL,
+			"latency_ms":     result.latency.Milliseconds(),
+		})
+	} else if ok && responseStatus == "pending" {
+		// Accepting the payment counts as a healthy response; the outcome arrives later
+		result.pending = true
+
+		appLogger.Info("Payment pending gateway callback", map[string]interface{}{
+			"correlation_id": correlationID,
+			"payment_id":     paymentID,
+			"gateway":        gatewayURL,
+			"latency_ms":     result.latency.Milliseconds(),
+		})
+	} else {
+		et := ErrorTypeGateway
+		if ok && response.StatusCode < 500 {
+			et = ErrorTypeBank
+		}
+		errorType = &et
+
+		if errMsgVal, ok := result.body["error"].(string); ok {
+			result.errorMsg = errMsgVal
+		}
+		if result.errorMsg == "" {
+			result.err = fmt.Errorf("gateway returned HTTP %d", response.StatusCode)
+		}
+		result.retryable = result.rateLimited || (!(ok && responseStatus == "failed") && response.StatusCode >= 500)
+		if et == ErrorTypeBank && !result.rateLimited {
+			// Gateways that don't send a separate decline code put it in error
+			code, _ := result.body["decline_code"].(string)
+			if code == "" {
+				code = result.errorMsg
+			}
+			result.declineCode = normalizeDeclineCode(code)
+		}
+	}
+	recordResult(paymentID, gatewayURL, result.latency, result.success || result.pending, errorType, result.errorMsg)
+
+	return result
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HealthProberConfig holds configuration for active health probing
+type HealthProberConfig struct {
+	Interval           time.Duration // Time between probe rounds
+	Timeout            time.Duration // Per-probe timeout
+	UnhealthyThreshold int           // Consecutive failures before a server is marked unhealthy
+	HealthyThreshold   int           // Consecutive successes before it is marked healthy again
+}
+
+// DefaultHealthProberConfig returns sensible defaults
+func DefaultHealthProberConfig() HealthProberConfig {
+	return HealthProberConfig{
+		Interval:           5 * time.Second,
+		Timeout:            2 * time.Second,
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+	}
+}
+
+// HealthProberConfigFromEnv builds the prober config from HEALTH_PROBE_* environment
+// variables, falling back to the defaults
+func HealthProberConfigFromEnv() (HealthProberConfig, error) {
+	config := DefaultHealthProberConfig()
+
+	if v := os.Getenv("HEALTH_PROBE_INTERVAL_MS"); v != "" {
+		intervalMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || intervalMs <= 0 {
+			return config, fmt.Errorf("HEALTH_PROBE_INTERVAL_MS must be a positive integer, got %q", v)
+		}
+		config.Interval = time.Duration(intervalMs) * time.Millisecond
+	}
+	if v := os.Getenv("HEALTH_PROBE_TIMEOUT_MS"); v != "" {
+		timeoutMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || timeoutMs <= 0 {
+			return config, fmt.Errorf("HEALTH_PROBE_TIMEOUT_MS must be a positive integer, got %q", v)
+		}
+		config.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	if v := os.Getenv("HEALTH_PROBE_UNHEALTHY_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold <= 0 {
+			return config, fmt.Errorf("HEALTH_PROBE_UNHEALTHY_THRESHOLD must be a positive integer, got %q", v)
+		}
+		config.UnhealthyThreshold = threshold
+	}
+	return config, nil
+}
+
+// ProbeResult is the latest health probe outcome for a server
+type ProbeResult struct {
+	ServerURL            string    `json:"server_url"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	LastProbe            time.Time `json:"last_probe"`
+	LatencyMs            int64     `json:"latency_ms"`
+	LastError            string    `json:"last_error,omitempty"`
+}
+
+// HealthProber periodically checks every pool server, so outages are found by probes
+// instead of customer payments. Registered providers are checked through their
+// HealthCheck, other servers through their /health endpoint. Failed probes count as
+// network errors in scoring and as failures in the provider's circuit breaker, and
+// consecutive failures take a server out of selection until it recovers
+type HealthProber struct {
+	pool      *ServerPool
+	registry  *ProviderRegistry
+	config    HealthProberConfig
+	results   map[string]*ProbeResult
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewHealthProber creates a prober for the pool's servers
+func NewHealthProber(pool *ServerPool, registry *ProviderRegistry, config HealthProberConfig) *HealthProber {
+	return &HealthProber{
+		pool:     pool,
+		registry: registry,
+		config:   config,
+		results:  make(map[string]*ProbeResult),
+		stopChan: make(chan bool),
+	}
+}
+
+// Start launches the probing goroutine
+func (hp *HealthProber) Start() {
+	hp.mu.Lock()
+	if hp.isRunning {
+		hp.mu.Unlock()
+		return
+	}
+	hp.isRunning = true
+	hp.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(hp.config.Interval)
+		defer ticker.Stop()
+
+		hp.probeAll()
+		for {
+			select {
+			case <-ticker.C:
+				hp.probeAll()
+			case <-hp.stopChan:
+				log.Println("[HealthProber] Stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the probing goroutine
+func (hp *HealthProber) Stop() {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	if hp.isRunning {
+		hp.stopChan <- true
+		hp.isRunning = false
+	}
+}
+
+// probeAll probes every server concurrently and waits for the round to finish
+func (hp *HealthProber) probeAll() {
+	var wg sync.WaitGroup
+	for _, server := range hp.pool.GetServersByScore() {
+		wg.Add(1)
+		go func(server *ServerMetrics) {
+			defer wg.Done()
+			hp.probe(server)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// probe checks one server and applies the outcome
+func (hp *HealthProber) probe(server *ServerMetrics) {
+	probeCtx, cancel := context.WithTimeout(context.Background(), hp.config.Timeout)
+	defer cancel()
+
+	name := gatewayName(server.ServerURL)
+	provider, _ := hp.registry.GetPaymentProvider(name)
+
+	start := time.Now()
+	var status *HealthStatus
+	var err error
+	if provider != nil {
+		status, err = provider.Provider.HealthCheck(probeCtx)
+	} else {
+		status, err = checkHealthEndpoint(probeCtx, name, server.ServerURL+"/health")
+	}
+	latency := time.Since(start)
+	if err == nil && !status.Healthy {
+		message := status.Message
+		if message == "" {
+			message = "health check reported unhealthy"
+		}
+		err = errors.New(message)
+	}
+
+	if err != nil {
+		server.RecordError(ErrorTypeNetwork, "health probe failed: "+err.Error())
+	}
+	if provider != nil && provider.CircuitBreaker != nil {
+		provider.CircuitBreaker.RecordResult(err)
+	}
+
+	hp.mu.Lock()
+	result, exists := hp.results[server.ServerURL]
+	if !exists {
+		result = &ProbeResult{ServerURL: server.ServerURL, Healthy: true}
+		hp.results[server.ServerURL] = result
+	}
+	result.LastProbe = time.Now()
+	result.LatencyMs = latency.Milliseconds()
+	if err != nil {
+		result.ConsecutiveFailures++
+		result.ConsecutiveSuccesses = 0
+		result.LastError = err.Error()
+		if result.ConsecutiveFailures >= hp.config.UnhealthyThreshold {
+			result.Healthy = false
+		}
+	} else {
+		result.ConsecutiveSuccesses++
+		result.ConsecutiveFailures = 0
+		result.LastError = ""
+		if result.ConsecutiveSuccesses >= hp.config.HealthyThreshold {
+			result.Healthy = true
+		}
+	}
+	healthy := result.Healthy
+	failures := result.ConsecutiveFailures
+	hp.mu.Unlock()
+
+	if server.SetHealthy(healthy) {
+		if healthy {
+			log.Printf("[HealthProber] %s recovered, returning it to selection", server.ServerURL)
+		} else {
+			log.Printf("[HealthProber] %s failed %d consecutive probes, marking unhealthy: %v", server.ServerURL, failures, err)
+		}
+		appLogger.Warn("Server health changed", map[string]interface{}{
+			"server_url": server.ServerURL,
+			"healthy":    healthy,
+		})
+	}
+}
+
+// GetResults returns the latest probe result for every probed server
+func (hp *HealthProber) GetResults() []ProbeResult {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	results := make([]ProbeResult, 0, len(hp.results))
+	for _, result := range hp.results {
+		results = append(results, *result)
+	}
+	return results
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Bounds on how long the primary gets before a hedge is sent
+const (
+	minHedgeDelay     = 50 * time.Millisecond
+	defaultHedgeDelay = 500 * time.Millisecond
+)
+
+// hedgingEnabled reports whether the API key on the request has opted into hedged payments
+func hedgingEnabled(ctx context.Context) bool {
+	apiKey, ok := ctx.Value("api_key").(string)
+	if !ok || apiKey == "" || apiKeyStore == nil {
+		return false
+	}
+	key, err := apiKeyStore.GetKey(apiKey)
+	if err != nil {
+		return false
+	}
+	return key.HedgingEnabled
+}
+
+// hedgeDelay is how long to wait for the primary before hedging: its observed P95
+func hedgeDelay(server *ServerMetrics) time.Duration {
+	if server.LatencyTracker == nil || server.LatencyTracker.GetSampleCount() < minRoutingSamples {
+		return defaultHedgeDelay
+	}
+	delay := server.LatencyTracker.GetPercentiles().P95
+	if delay < minHedgeDelay {
+		delay = minHedgeDelay
+	}
+	return delay
+}
+
+// hedgeSecondary returns the best untried candidate that is safe to hedge to: it
+// must be a registered provider that honours idempotency keys
+func hedgeSecondary(candidates []*ServerMetrics, tried map[*ServerMetrics]bool) *ServerMetrics {
+	for _, server := range candidates {
+		if tried[server] {
+			continue
+		}
+		config, err := providerRegistry.GetPaymentProvider(gatewayName(server.ServerURL))
+		if err != nil {
+			continue
+		}
+		if config.Provider.Capabilities().SupportsIdempotency {
+			return server
+		}
+	}
+	return nil
+}
+
+// attemptHedged sends the payment to primary and, if it hasn't answered within
+// hedgeDelay, to secondary as well. The first success, or pending acceptance, wins and
+// the other request is cancelled. It reports whether the hedge was actually sent
+func attemptHedged(ctx context.Context, primary, secondary *ServerMetrics, payload []byte, paymentID, correlationID string, attempt int) (*gatewayResult, bool) {
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	secondaryCtx, cancelSecondary := context.WithCancel(ctx)
+	defer cancelPrimary()
+	defer cancelSecondary()
+
+	results := make(chan *gatewayResult, 2)
+	go func() {
+		results <- attemptGateway(primaryCtx, primary, payload, paymentID, correlationID, attempt)
+	}()
+
+	timer := time.NewTimer(hedgeDelay(primary))
+	defer timer.Stop()
+
+	inFlight := 1
+	hedged := false
+	var failed *gatewayResult
+
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			hedged = true
+			inFlight++
+			appLogger.Info("Hedging payment", map[string]interface{}{
+				"correlation_id": correlationID,
+				"payment_id":     paymentID,
+				"primary":        primary.ServerURL,
+				"secondary":      secondary.ServerURL,
+			})
+			go func() {
+				results <- attemptGateway(secondaryCtx, secondary, payload, paymentID, correlationID, attempt)
+			}()
+
+		case result := <-results:
+			inFlight--
+			if result.success || result.pending {
+				if inFlight > 0 {
+					if result.server == primary {
+						cancelSecondary()
+					} else {
+						cancelPrimary()
+					}
+					go watchHedgeLoser(results, paymentID, correlationID)
+				}
+				return result, hedged
+			}
+			if !hedged {
+				// Primary failed before the hedge was due, leave failover to the caller
+				return result, false
+			}
+			if failed == nil || !result.retryable {
+				failed = result
+			}
+		}
+	}
+	return failed, hedged
+}
+
+// watchHedgeLoser waits for the cancelled side of a hedge. If it captured anyway, the
+// payment was charged twice across providers and has to be reversed
+func watchHedgeLoser(results <-chan *gatewayResult, paymentID, correlationID string) {
+	loser := <-results
+	if !loser.success && !loser.pending {
+		return
+	}
+	appLogger.Error("Hedged payment captured by both providers, reversal required", map[string]interface{}{
+		"correlation_id": correlationID,
+		"payment_id":     paymentID,
+		"gateway":        loser.server.ServerURL,
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// idempotencyTTL is how long a stored response can be replayed
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyWait bounds how long a duplicate waits for the first request to finish
+	idempotencyWait = 10 * time.Second
+	// idempotencyPoll is how often a waiting duplicate checks for the stored response
+	idempotencyPoll = 100 * time.Millisecond
+)
+
+// idempotencyEntry is what is stored under an Idempotency-Key. Body and Status are
+// empty while the first request is still in flight
+type idempotencyEntry struct {
+	RequestHash string `json:"request_hash"`
+	Complete    bool   `json:"complete"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+func idempotencyKey(namespace, merchantID, key string) string {
+	return namespace + "idempotency:" + merchantID + ":" + key
+}
+
+// responseCapture passes a response through while keeping a copy of it
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(code int) {
+	if rc.status == 0 {
+		rc.status = code
+	}
+	rc.ResponseWriter.WriteHeader(code)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
+// IdempotencyMiddleware makes POSTs carrying an Idempotency-Key header safe to retry.
+// The first request claims the key with SETNX and its response is stored; later
+// requests with the same key and body get that response replayed, waiting for it if
+// the first is still in flight. Reusing a key with a different body is a 409. Keys
+// are scoped to the caller's API key, and server errors and panics release the key so
+// the request can be retried
+func IdempotencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRequestError(w, FAILED.String(), err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		redisKey := idempotencyKey(requestNamespace(r.Context()), merchantIDFromContext(r.Context()), key)
+		requestHash := SHA256Hash(string(body))
+		claim, _ := json.Marshal(idempotencyEntry{RequestHash: requestHash})
+
+		claimed, err := rdb.SetNX(r.Context(), redisKey, claim, idempotencyTTL).Result()
+		if err != nil {
+			log.Printf("[Idempotency] Failed to claim key %s: %v", key, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrInternalError,
+				"Idempotency store unavailable",
+				FAILED.String(),
+				"Retry the request with the same Idempotency-Key",
+			))
+			return
+		}
+		if !claimed {
+			replayIdempotent(w, r, redisKey, requestHash)
+			return
+		}
+
+		// A handler that panics leaves no response to store; release the key so retries
+		// aren't refused until it expires, then let the panic carry on to the server
+		defer func() {
+			if p := recover(); p != nil {
+				rdb.Del(ctx, redisKey)
+				panic(p)
+			}
+		}()
+
+		capture := &responseCapture{ResponseWriter: w}
+		next(capture, r)
+		if capture.status == 0 {
+			capture.status = http.StatusOK
+		}
+
+		// Use a fresh context: the request's may already be cancelled
+		if capture.status >= 500 {
+			rdb.Del(ctx, redisKey)
+			return
+		}
+		entry, _ := json.Marshal(idempotencyEntry{
+			RequestHash: requestHash,
+			Complete:    true,
+			Status:      capture.status,
+			ContentType: capture.Header().Get("Content-Type"),
+			Body:        capture.body.Bytes(),
+		})
+		if err := rdb.Set(ctx, redisKey, entry, idempotencyTTL).Err(); err != nil {
+			log.Printf("[Idempotency] Failed to store response for key %s: %v", key, err)
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key is already claimed, waiting for the
+// first request's response when it is still in flight
+func replayIdempotent(w http.ResponseWriter, r *http.Request, redisKey, requestHash string) {
+	deadline := time.Now().Add(idempotencyWait)
+	for {
+		var entry idempotencyEntry
+		data, err := rdb.Get(r.Context(), redisKey).Bytes()
+		if err == redis.Nil {
+			// The first request failed and released the key
+			writeIdempotencyConflict(w, "The original request with this Idempotency-Key failed", "Retry the request")
+			return
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err != nil {
+			log.Printf("[Idempotency] Failed to read %s: %v", redisKey, err)
+			writeIdempotencyConflict(w, "Could not read the original request", "Retry the request")
+			return
+		}
+
+		if entry.RequestHash != requestHash {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrIdempotencyKeyReused,
+				"Idempotency-Key was already used with a different request body",
+				FAILED.String(),
+				"Use a new Idempotency-Key for a different request",
+			))
+			return
+		}
+
+		if entry.Complete {
+			if entry.ContentType != "" {
+				w.Header().Set("Content-Type", entry.ContentType)
+			}
+			w.Header().Set("X-Idempotent-Replay", "true")
+			w.WriteHeader(entry.Status)
+			w.Write(entry.Body)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			writeIdempotencyConflict(w, "A request with this Idempotency-Key is being processed", "Retry once the original request has completed")
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(idempotencyPoll):
+		}
+	}
+}
+
+func writeIdempotencyConflict(w http.ResponseWriter, message, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(NewErrorResponse(
+		ErrInternalError,
+		message,
+		PROCESSING.String(),
+		details,
+	))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func idempotentRequest(t *testing.T, handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestIdempotencyMiddlewareReplaysResponse(t *testing.T) {
+	useTestRedis(t)
+	calls := 0
+	handler := IdempotencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"payment_id":"pay_1"}`))
+	})
+
+	first := idempotentRequest(t, handler, "key-1", `{"amount":1000}`)
+	second := idempotentRequest(t, handler, "key-1", `{"amount":1000}`)
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get("X-Idempotent-Replay") != "true" {
+		t.Error("replay is missing X-Idempotent-Replay")
+	}
+	if first.Header().Get("X-Idempotent-Replay") != "" {
+		t.Error("first response is marked as a replay")
+	}
+}
+
+func TestIdempotencyMiddlewareRejectsDifferentBody(t *testing.T) {
+	useTestRedis(t)
+	calls := 0
+	handler := IdempotencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	idempotentRequest(t, handler, "key-1", `{"amount":1000}`)
+	rec := idempotentRequest(t, handler, "key-1", `{"amount":2000}`)
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.ErrorCode != ErrIdempotencyKeyReused {
+		t.Errorf("error code = %s, want %s", resp.ErrorCode, ErrIdempotencyKeyReused)
+	}
+}
+
+func TestIdempotencyMiddlewareReleasesKeyOnServerError(t *testing.T) {
+	mr := useTestRedis(t)
+	status := http.StatusBadGateway
+	calls := 0
+	handler := IdempotencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	})
+
+	if rec := idempotentRequest(t, handler, "key-1", `{"amount":1000}`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if mr.Exists(idempotencyKey("", "default", "key-1")) {
+		t.Fatal("key still claimed after a server error")
+	}
+
+	status = http.StatusCreated
+	if rec := idempotentRequest(t, handler, "key-1", `{"amount":1000}`); rec.Code != http.StatusCreated {
+		t.Errorf("retry status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyMiddlewareReleasesKeyOnPanic(t *testing.T) {
+	mr := useTestRedis(t)
+	handler := IdempotencyMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		panic("provider client bug")
+	})
+
+	func() {
+		defer func() {
+			if p := recover(); p != "provider client bug" {
+				t.Errorf("recovered %v, want the handler's panic", p)
+			}
+		}()
+		idempotentRequest(t, handler, "key-1", `{"amount":1000}`)
+	}()
+
+	if mr.Exists(idempotencyKey("", "default", "key-1")) {
+		t.Error("key still claimed after the handler panicked")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// IPRules restrict where an API key may be used from. A denied address is always
+// rejected; when Allow is non-empty the address must also fall in one of its ranges
+type IPRules struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseIPRules parses CIDR lists, accepting bare addresses as single-host ranges
+func ParseIPRules(allow, deny []string) (IPRules, error) {
+	var rules IPRules
+	var err error
+	if rules.Allow, err = parsePrefixes(allow); err != nil {
+		return IPRules{}, fmt.Errorf("invalid allow entry: %v", err)
+	}
+	if rules.Deny, err = parsePrefixes(deny); err != nil {
+		return IPRules{}, fmt.Errorf("invalid deny entry: %v", err)
+	}
+	return rules, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// IsEmpty reports whether the rules allow every address
+func (rules IPRules) IsEmpty() bool {
+	return len(rules.Allow) == 0 && len(rules.Deny) == 0
+}
+
+// Allows reports whether a client address passes the rules. An address that can't be
+// parsed only passes when there are no rules
+func (rules IPRules) Allows(ip string) bool {
+	if rules.IsEmpty() {
+		return true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range rules.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(rules.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range rules.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings renders the rules as CIDR lists for the admin API
+func (rules IPRules) Strings() (allow, deny []string) {
+	allow = make([]string, len(rules.Allow))
+	for i, prefix := range rules.Allow {
+		allow[i] = prefix.String()
+	}
+	deny = make([]string, len(rules.Deny))
+	for i, prefix := range rules.Deny {
+		deny[i] = prefix.String()
+	}
+	return allow, deny
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ledger entry directions
+const (
+	LedgerDebit  = "DEBIT"
+	LedgerCredit = "CREDIT"
+)
+
+// Ledger transaction types
+const (
+	LedgerTxPayment    = "PAYMENT"
+	LedgerTxRefund     = "REFUND"
+	LedgerTxFee        = "FEE"
+	LedgerTxChargeback = "CHARGEBACK"
+	LedgerTxPayout     = "PAYOUT"
+)
+
+// ErrLedgerAlreadyPosted is returned when a payment's PAYMENT or FEE transaction is
+// already in the ledger
+var ErrLedgerAlreadyPosted = errors.New("ledger transaction already posted")
+
+// defaultFeeBasisPoints is the platform fee charged on captured payments (1%)
+const defaultFeeBasisPoints = 100
+
+// LedgerEntry is one side of a double-entry posting
+type LedgerEntry struct {
+	ID            int64     `json:"id,omitempty"`
+	TransactionID string    `json:"transaction_id"`
+	Account       string    `json:"account"`
+	Direction     string    `json:"direction"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// LedgerTransaction groups entries that must net to zero
+type LedgerTransaction struct {
+	ID        string        `json:"id"`
+	Reference string        `json:"reference"`
+	Type      string        `json:"type"`
+	Entries   []LedgerEntry `json:"entries"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// LedgerBalance is the net balance of an account in one currency (credits minus debits)
+type LedgerBalance struct {
+	Account  string `json:"account"`
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"`
+}
+
+// LedgerStore persists ledger transactions
+type LedgerStore interface {
+	// PostLedgerTransactions writes the transactions and all their entries atomically
+	PostLedgerTransactions(txs ...*LedgerTransaction) error
+	// GetLedgerBalances returns balances for all accounts starting with prefix
+	GetLedgerBalances(accountPrefix string) ([]LedgerBalance, error)
+	// GetLedgerTransactions returns the transactions recorded for a reference that
+	// post to an account starting with accountPrefix
+	GetLedgerTransactions(accountPrefix, reference string) ([]LedgerTransaction, error)
+}
+
+// Account names
+func merchantAccount(merchantID string) string {
+	return "merchant:" + merchantID + ":available"
+}
+
+func providerAccount(provider string) string {
+	return "provider:" + provider + ":receivable"
+}
+
+const platformFeesAccount = "platform:fees"
+
+// NewLedgerTransaction builds a transaction and stamps its entries
+func NewLedgerTransaction(txType, reference string, entries ...LedgerEntry) *LedgerTransaction {
+	now := time.Now().UTC()
+	tx := &LedgerTransaction{
+		ID:        "ltx_" + uuid.NewString(),
+		Reference: reference,
+		Type:      txType,
+		CreatedAt: now,
+	}
+	for _, entry := range entries {
+		entry.TransactionID = tx.ID
+		entry.CreatedAt = now
+		tx.Entries = append(tx.Entries, entry)
+	}
+	return tx
+}
+
+// Validate checks that amounts are positive and debits equal credits per currency
+func (tx *LedgerTransaction) Validate() error {
+	if len(tx.Entries) < 2 {
+		return fmt.Errorf("ledger transaction %s needs at least two entries", tx.ID)
+	}
+
+	net := make(map[string]int64)
+	for _, entry := range tx.Entries {
+		if entry.Amount <= 0 {
+			return fmt.Errorf("ledger entry for %s has non-positive amount %d", entry.Account, entry.Amount)
+		}
+		if entry.Currency == "" {
+			return fmt.Errorf("ledger entry for %s has no currency", entry.Account)
+		}
+		switch entry.Direction {
+		case LedgerDebit:
+			net[entry.Currency] += entry.Amount
+		case LedgerCredit:
+			net[entry.Currency] -= entry.Amount
+		default:
+			return fmt.Errorf("ledger entry for %s has invalid direction %q", entry.Account, entry.Direction)
+		}
+	}
+
+	for currency, sum := range net {
+		if sum != 0 {
+			return fmt.Errorf("ledger transaction %s does not balance in %s (off by %d)", tx.ID, currency, sum)
+		}
+	}
+	return nil
+}
+
+// Ledger records payment, refund and fee postings
+type Ledger struct {
+	store          LedgerStore
+	feeBasisPoints int64
+}
+
+// NewLedger creates a ledger backed by the given store
+func NewLedger(store LedgerStore, feeBasisPoints int64) *Ledger {
+	return &Ledger{
+		store:          store,
+		feeBasisPoints: feeBasisPoints,
+	}
+}
+
+var ledger *Ledger
+
+// InitLedger initializes the global ledger
+func InitLedger(store LedgerStore) {
+	ledger = NewLedger(store, defaultFeeBasisPoints)
+}
+
+// GetLedger returns the global ledger, or nil when no database is configured
+func GetLedger() *Ledger {
+	return ledger
+}
+
+func (l *Ledger) post(txs ...*LedgerTransaction) error {
+	for _, tx := range txs {
+		if err := tx.Validate(); err != nil {
+			return err
+		}
+	}
+	return l.store.PostLedgerTransactions(txs...)
+}
+
+// Fee returns the platform fee for an amount, rounded down
+func (l *Ledger) Fee(amount int64) int64 {
+	return amount * l.feeBasisPoints / 10000
+}
+
+// RecordPayment posts a captured payment and its platform fee together. A split payment
+// is credited to each recipient, and each recipient pays the fee on its own share.
+// Captures can be reported more than once (callbacks, AML release, the payment itself),
+// so a payment that is already in the ledger is not an error
+func (l *Ledger) RecordPayment(record *PaymentRecord) error {
+	if record.Amount <= 0 {
+		return fmt.Errorf("payment %s has no amount to record", record.PaymentID)
+	}
+
+	var txs []*LedgerTransaction
+	if len(record.Splits) > 0 {
+		txs = l.splitPaymentTransactions(record)
+	} else {
+		txs = append(txs, NewLedgerTransaction(LedgerTxPayment, record.PaymentID,
+			LedgerEntry{Account: providerAccount(record.Provider), Direction: LedgerDebit, Amount: record.Amount, Currency: record.Currency},
+			LedgerEntry{Account: merchantAccount(record.MerchantID), Direction: LedgerCredit, Amount: record.Amount, Currency: record.Currency},
+		))
+		if fee := l.Fee(record.Amount); fee > 0 {
+			txs = append(txs, NewLedgerTransaction(LedgerTxFee, record.PaymentID,
+				LedgerEntry{Account: merchantAccount(record.MerchantID), Direction: LedgerDebit, Amount: fee, Currency: record.Currency},
+				LedgerEntry{Account: platformFeesAccount, Direction: LedgerCredit, Amount: fee, Currency: record.Currency},
+			))
+		}
+	}
+
+	if err := l.post(txs...); err != nil && !errors.Is(err, ErrLedgerAlreadyPosted) {
+		return err
+	}
+	return nil
+}
+
+func (l *Ledger) splitPaymentTransactions(record *PaymentRecord) []*LedgerTransaction {
+	payment := []LedgerEntry{
+		{Account: providerAccount(record.Provider), Direction: LedgerDebit, Amount: record.Amount, Currency: record.Currency},
+	}
+	var fees []LedgerEntry
+	var totalFee int64
+	for _, settlement := range splitSettlements(record.Splits) {
+		payment = append(payment, LedgerEntry{Account: settlement.Account, Direction: LedgerCredit, Amount: settlement.Amount, Currency: record.Currency})
+		if settlement.Fee > 0 {
+			fees = append(fees, LedgerEntry{Account: settlement.Account, Direction: LedgerDebit, Amount: settlement.Fee, Currency: record.Currency})
+			totalFee += settlement.Fee
+		}
+	}
+	txs := []*LedgerTransaction{NewLedgerTransaction(LedgerTxPayment, record.PaymentID, payment...)}
+
+	if totalFee > 0 {
+		fees = append(fees, LedgerEntry{Account: platformFeesAccount, Direction: LedgerCredit, Amount: totalFee, Currency: record.Currency})
+		txs = append(txs, NewLedgerTransaction(LedgerTxFee, record.PaymentID, fees...))
+	}
+	return txs
+}
+
+// RecordRefund posts a refund of amount against a captured payment. A split payment's
+// refund is taken from its recipients in proportion to their shares
+func (l *Ledger) RecordRefund(record *PaymentRecord, amount int64) error {
+	if len(record.Splits) == 0 {
+		return l.post(NewLedgerTransaction(LedgerTxRefund, record.PaymentID,
+			LedgerEntry{Account: merchantAccount(record.MerchantID), Direction: LedgerDebit, Amount: amount, Currency: record.Currency},
+			LedgerEntry{Account: providerAccount(record.Provider), Direction: LedgerCredit, Amount: amount, Currency: record.Currency},
+		))
+	}
+
+	entries := []LedgerEntry{
+		{Account: providerAccount(record.Provider), Direction: LedgerCredit, Amount: amount, Currency: record.Currency},
+	}
+	for i, share := range allocateSplitRefund(record.Splits, record.Amount, amount) {
+		if share > 0 {
+			entries = append(entries, LedgerEntry{Account: merchantAccount(record.Splits[i].Recipient), Direction: LedgerDebit, Amount: share, Currency: record.Currency})
+		}
+	}
+	return l.post(NewLedgerTransaction(LedgerTxRefund, record.PaymentID, entries...))
+}
+
+// RecordChargeback posts the loss of a dispute, pulling the disputed amount back from the merchant
+func (l *Ledger) RecordChargeback(d *Dispute) error {
+	return l.post(NewLedgerTransaction(LedgerTxChargeback, d.PaymentID,
+		LedgerEntry{Account: merchantAccount(d.MerchantID), Direction: LedgerDebit, Amount: d.Amount, Currency: d.Currency},
+		LedgerEntry{Account: providerAccount(d.Provider), Direction: LedgerCredit, Amount: d.Amount, Currency: d.Currency},
+	))
+}
+
+// RecordPayout posts funds sent out of a merchant's balance through a provider
+func (l *Ledger) RecordPayout(p *Payout) error {
+	return l.post(NewLedgerTransaction(LedgerTxPayout, p.ID,
+		LedgerEntry{Account: merchantAccount(p.MerchantID), Direction: LedgerDebit, Amount: p.Amount, Currency: p.Currency},
+		LedgerEntry{Account: providerAccount(p.Provider), Direction: LedgerCredit, Amount: p.Amount, Currency: p.Currency},
+	))
+}
+
+// recordCapturedPayment posts ledger entries for a successful payment, logging failures
+func recordCapturedPayment(record *PaymentRecord) {
+	l := GetLedger()
+	if l == nil {
+		return
+	}
+	if err := l.RecordPayment(record); err != nil {
+		log.Printf("[Ledger] Failed to record payment %s: %v", record.PaymentID, err)
+	}
+}
+
+// LedgerBalancesHandler handles GET /ledger/balances. Keys with the admin scope may pass
+// ?merchant_id= to read another merchant's balances
+func LedgerBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	l := GetLedger()
+	if l == nil {
+		http.Error(w, "Ledger not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Merchants only see their own balances; merchant_id is for admin keys
+	merchantID := scopedMerchantID(r)
+	balances, err := l.store.GetLedgerBalances("merchant:" + merchantID + ":")
+	if err != nil {
+		http.Error(w, "Failed to fetch balances", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchant_id": merchantID,
+		"balances":    balances,
+	})
+}
+
+// LedgerTransactionsHandler handles GET /ledger/transactions?reference=
+func LedgerTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	l := GetLedger()
+	if l == nil {
+		http.Error(w, "Ledger not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	reference := r.URL.Query().Get("reference")
+	if reference == "" {
+		http.Error(w, "reference parameter required", http.StatusBadRequest)
+		return
+	}
+
+	// Merchants only see transactions posting to their accounts, as for balances
+	merchantID := scopedMerchantID(r)
+	transactions, err := l.store.GetLedgerTransactions("merchant:"+merchantID+":", reference)
+	if err != nil {
+		http.Error(w, "Failed to fetch ledger transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"merchant_id":  merchantID,
+		"reference":    reference,
+		"transactions": transactions,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func newMockStore(t *testing.T) (*SQLStore, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewSQLStore(db, PostgresDialect{}), mock
+}
+
+func TestPostLedgerTransactionsRejectsDoublePost(t *testing.T) {
+	store, mock := newMockStore(t)
+	ltx := NewLedgerTransaction(LedgerTxPayment, "pay_1",
+		LedgerEntry{Account: providerAccount("stripe"), Direction: LedgerDebit, Amount: 1000, Currency: "USD"},
+		LedgerEntry{Account: merchantAccount("default"), Direction: LedgerCredit, Amount: 1000, Currency: "USD"},
+	)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_transactions`)).
+		WithArgs(ltx.ID, "pay_1", LedgerTxPayment, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_entries`)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_entries`)).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_transactions`)).
+		WillReturnError(&pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"})
+	mock.ExpectRollback()
+
+	if err := store.PostLedgerTransactions(ltx); err != nil {
+		t.Fatalf("first post: %v", err)
+	}
+	if err := store.PostLedgerTransactions(ltx); !errors.Is(err, ErrLedgerAlreadyPosted) {
+		t.Fatalf("second post err = %v, want ErrLedgerAlreadyPosted", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostLedgerTransactionsWrapsOtherErrors(t *testing.T) {
+	store, mock := newMockStore(t)
+	ltx := NewLedgerTransaction(LedgerTxPayment, "pay_1",
+		LedgerEntry{Account: providerAccount("stripe"), Direction: LedgerDebit, Amount: 1000, Currency: "USD"},
+		LedgerEntry{Account: merchantAccount("default"), Direction: LedgerCredit, Amount: 1000, Currency: "USD"},
+	)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_transactions`)).
+		WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+	mock.ExpectRollback()
+
+	err := store.PostLedgerTransactions(ltx)
+	if err == nil || errors.Is(err, ErrLedgerAlreadyPosted) {
+		t.Fatalf("err = %v, want a store error other than ErrLedgerAlreadyPosted", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordPaymentIgnoresAlreadyPosted(t *testing.T) {
+	store, mock := newMockStore(t)
+	l := NewLedger(store, 0)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_transactions`)).
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	record := &PaymentRecord{PaymentID: "pay_1", MerchantID: "default", Provider: "stripe", Amount: 1000, Currency: "USD"}
+	if err := l.RecordPayment(record); err != nil {
+		t.Errorf("RecordPayment on an already-posted payment = %v, want nil", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLedgerTransactionsHandlerScopesToCaller(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []Scope
+		prefix string
+	}{
+		{"merchant key ignores merchant_id", []Scope{ScopePaymentsRead}, "merchant:merchant!_a:%"},
+		{"admin key reads another merchant", []Scope{ScopeAdmin}, "merchant:merchant!_b:%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			ledger = NewLedger(store, 0)
+			t.Cleanup(func() { ledger = nil })
+
+			mock.ExpectQuery(regexp.QuoteMeta(`WHERE t.reference = $1 AND EXISTS (`)).
+				WithArgs("pay_1", tt.prefix).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "reference", "type", "created_at",
+					"id", "account", "direction", "amount", "currency", "created_at"}))
+
+			req := httptest.NewRequest(http.MethodGet, "/ledger/transactions?reference=pay_1&merchant_id=merchant_b", nil)
+			c := context.WithValue(req.Context(), "api_key", "merchant_a")
+			c = context.WithValue(c, "scopes", tt.scopes)
+			rec := httptest.NewRecorder()
+			LedgerTransactionsHandler(rec, req.WithContext(c))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -2,12 +2,33 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SelectionMode defines how the pool picks a server
+type SelectionMode string
+
+const (
+	SelectionWeightedScore    SelectionMode = "weighted_score"    // Random, weighted by effective score
+	SelectionLeastOutstanding SelectionMode = "least_outstanding" // Fewest in-flight requests above MinSelectionScore
 )
 
+// ParseSelectionMode validates a selection mode name
+func ParseSelectionMode(name string) (SelectionMode, error) {
+	switch mode := SelectionMode(name); mode {
+	case SelectionWeightedScore, SelectionLeastOutstanding:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown selection mode %q", name)
+}
+
 type ServerPool struct {
 	servers      map[string]*ServerMetrics
 	config       *ScoringConfig
@@ -15,6 +36,19 @@ type ServerPool struct {
 	updateTicker *time.Ticker
 	stopChan     chan bool
 	isRunning    bool
+	rdb          *redis.Client // set by EnablePersistence
+
+	configChanges []ScoringConfigChange // most recent last
+}
+
+// maxScoringConfigChanges is how many scoring config changes are kept for inspection
+const maxScoringConfigChanges = 50
+
+// ScoringConfigChange records who changed the scoring config, when, and which fields
+type ScoringConfigChange struct {
+	ChangedBy string                 `json:"changed_by"`
+	ChangedAt time.Time              `json:"changed_at"`
+	Changes   map[string]interface{} `json:"changes"`
 }
 
 func NewServerPool(config *ScoringConfig) *ServerPool {
@@ -34,8 +68,11 @@ func (sp *ServerPool) AddServer(serverURL string) {
 	defer sp.mu.Unlock()
 
 	if _, exists := sp.servers[serverURL]; !exists {
-		sp.servers[serverURL] = NewServerMetrics(serverURL)
-		log.Printf("Added server to pool: %s (initial score: %.2f)", serverURL, 100.0)
+		server := NewServerMetrics(serverURL)
+		sp.loadSnapshot(server)
+		server.StartWarmup()
+		sp.servers[serverURL] = server
+		log.Printf("Added server to pool: %s (initial score: %.2f)", serverURL, server.GetScore())
 	}
 }
 
@@ -60,7 +97,33 @@ func (sp *ServerPool) GetServer(serverURL string) (*ServerMetrics, error) {
 	return server, nil
 }
 
-func (sp *ServerPool) SelectServer() (*ServerMetrics, error) {
+// GetServerByGateway returns the server whose URL ends in the given gateway name
+func (sp *ServerPool) GetServerByGateway(name string) (*ServerMetrics, error) {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+
+	for url, server := range sp.servers {
+		if gatewayName(url) == name {
+			return server, nil
+		}
+	}
+	return nil, errors.New("server not found")
+}
+
+// TagServer restricts a server to the given currencies and regions. An empty list
+// means the server accepts any
+func (sp *ServerPool) TagServer(serverURL string, currencies, regions []string) error {
+	server, err := sp.GetServer(serverURL)
+	if err != nil {
+		return err
+	}
+	server.SetTags(currencies, regions)
+	return nil
+}
+
+// SelectServer picks a server able to process the currency, preferring servers in the
+// region hint when any are. Empty currency or region don't filter
+func (sp *ServerPool) SelectServer(currency, region string) (*ServerMetrics, error) {
 	sp.mu.RLock()
 	defer sp.mu.RUnlock()
 
@@ -68,30 +131,40 @@ func (sp *ServerPool) SelectServer() (*ServerMetrics, error) {
 		return nil, errors.New("no servers available")
 	}
 
+	servers := sp.eligibleServers(currency, region)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers support currency %s", currency)
+	}
+
+	if sp.config.SelectionMode == SelectionLeastOutstanding {
+		if server := sp.leastOutstanding(servers); server != nil {
+			return server, nil
+		}
+	}
+
 	totalScore := 0.0
-	serverList := make([]*ServerMetrics, 0, len(sp.servers))
+	serverList := make([]*ServerMetrics, 0, len(servers))
+	weights := make([]float64, 0, len(servers))
 
-	for _, server := range sp.servers {
-		score := server.GetScore()
-		if score > 0 {
+	for _, server := range servers {
+		score := server.EffectiveScore(sp.config)
+		if score > 0 && server.IsHealthy() {
 			totalScore += score
 			serverList = append(serverList, server)
+			weights = append(weights, score)
 		}
 	}
 
 	if totalScore == 0 || len(serverList) == 0 {
 		log.Println("Warning: All servers have score 0, using fallback selection")
-		for _, server := range sp.servers {
-			return server, nil
-		}
-		return nil, errors.New("no healthy servers available")
+		return servers[0], nil
 	}
 
 	randomValue := rand.Float64() * totalScore
 	currentSum := 0.0
 
-	for _, server := range serverList {
-		currentSum += server.GetScore()
+	for i, server := range serverList {
+		currentSum += weights[i]
 		if currentSum >= randomValue {
 			return server, nil
 		}
@@ -99,6 +172,60 @@ func (sp *ServerPool) SelectServer() (*ServerMetrics, error) {
 	return serverList[0], nil
 }
 
+// eligibleServers returns the servers supporting the currency, narrowed to the region
+// hint unless no server is tagged for it. Callers hold sp.mu
+func (sp *ServerPool) eligibleServers(currency, region string) []*ServerMetrics {
+	servers := make([]*ServerMetrics, 0, len(sp.servers))
+	for _, server := range sp.servers {
+		servers = append(servers, server)
+	}
+	return filterServers(servers, currency, region)
+}
+
+// filterServers keeps the servers supporting the currency and, when any of them serve
+// it, the region. Order is preserved
+func filterServers(servers []*ServerMetrics, currency, region string) []*ServerMetrics {
+	byCurrency := make([]*ServerMetrics, 0, len(servers))
+	for _, server := range servers {
+		if server.SupportsCurrency(currency) {
+			byCurrency = append(byCurrency, server)
+		}
+	}
+	if region == "" {
+		return byCurrency
+	}
+
+	byRegion := make([]*ServerMetrics, 0, len(byCurrency))
+	for _, server := range byCurrency {
+		if server.SupportsRegion(region) {
+			byRegion = append(byRegion, server)
+		}
+	}
+	if len(byRegion) == 0 {
+		return byCurrency
+	}
+	return byRegion
+}
+
+// leastOutstanding returns the healthy server with the fewest in-flight requests among
+// those at or above MinSelectionScore, ties going to the higher score. Callers hold sp.mu
+func (sp *ServerPool) leastOutstanding(servers []*ServerMetrics) *ServerMetrics {
+	var best *ServerMetrics
+	bestOutstanding, bestScore := 0, 0.0
+
+	for _, server := range servers {
+		score := server.EffectiveScore(sp.config)
+		if score < sp.config.MinSelectionScore || !server.IsHealthy() {
+			continue
+		}
+		outstanding := server.Outstanding()
+		if best == nil || outstanding < bestOutstanding || (outstanding == bestOutstanding && score > bestScore) {
+			best, bestOutstanding, bestScore = server, outstanding, score
+		}
+	}
+	return best
+}
+
 func (sp *ServerPool) RecordRequestResult(paymentID, serverURL string, latency time.Duration, success bool, errorType *ErrorType, errorMsg string) {
 	server, err := sp.GetServer(serverURL)
 	if err != nil {
@@ -106,7 +233,7 @@ func (sp *ServerPool) RecordRequestResult(paymentID, serverURL string, latency t
 		return
 	}
 
-	server.RecordRequest(latency, success)
+	server.RecordRequest(latency, success, sp.Config())
 
 	if !success && errorType != nil {
 		server.RecordError(*errorType, errorMsg)
@@ -128,6 +255,10 @@ func (sp *ServerPool) RecordRequestResult(paymentID, serverURL string, latency t
 		}
 	}
 
+	if anomalyDetector != nil {
+		anomalyDetector.Observe(gatewayName(serverURL), success, anomalyErrorCode(errorTypeStr, errorMsg))
+	}
+
 	latencyMs := latency.Milliseconds()
 	if err := LogRequestMetrics(paymentID, serverURL, latencyMs, success, currentScore, errorTypeStr, errorMsg); err != nil {
 		log.Printf("Failed to log request metrics to database: %v", err)
@@ -141,11 +272,12 @@ func (sp *ServerPool) StartPeriodicScoreUpdate() {
 		return
 	}
 	sp.isRunning = true
-	sp.updateTicker = time.NewTicker(sp.config.ScoreUpdatePeriod)
+	period := sp.config.ScoreUpdatePeriod
+	sp.updateTicker = time.NewTicker(period)
 	sp.mu.Unlock()
 
 	go func() {
-		log.Printf("score update interval: %v", sp.config.ScoreUpdatePeriod)
+		log.Printf("score update interval: %v", period)
 		for {
 			select {
 			case <-sp.updateTicker.C:
@@ -170,17 +302,59 @@ func (sp *ServerPool) StopPeriodicScoreUpdate() {
 }
 func (sp *ServerPool) updateAllScores() {
 	sp.mu.RLock()
-	defer sp.mu.RUnlock()
-
+	config := sp.config
+	servers := make([]*ServerMetrics, 0, len(sp.servers))
 	for _, server := range sp.servers {
+		servers = append(servers, server)
+	}
+	sp.mu.RUnlock()
+
+	for _, server := range servers {
 		oldScore := server.GetScore()
-		server.CalculateScore(sp.config)
+		server.CalculateScore(config)
 		newScore := server.GetScore()
 
 		if oldScore != newScore {
 			log.Printf("Server %s: score changed %.2f -> %.2f", server.ServerURL, oldScore, newScore)
 		}
 	}
+
+	sp.saveSnapshots(servers)
+}
+
+// Config returns the scoring config in effect. The returned config must not be
+// modified, SetConfig installs a replacement
+func (sp *ServerPool) Config() *ScoringConfig {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	return sp.config
+}
+
+// SetConfig atomically replaces the scoring config and records the change. Scores
+// are recalculated with the new config on the next update
+func (sp *ServerPool) SetConfig(config *ScoringConfig, change ScoringConfigChange) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.isRunning && config.ScoreUpdatePeriod != sp.config.ScoreUpdatePeriod {
+		sp.updateTicker.Reset(config.ScoreUpdatePeriod)
+	}
+	sp.config = config
+
+	sp.configChanges = append(sp.configChanges, change)
+	if len(sp.configChanges) > maxScoringConfigChanges {
+		sp.configChanges = sp.configChanges[len(sp.configChanges)-maxScoringConfigChanges:]
+	}
+}
+
+// ConfigChanges returns recorded scoring config changes, most recent last
+func (sp *ServerPool) ConfigChanges() []ScoringConfigChange {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+
+	changes := make([]ScoringConfigChange, len(sp.configChanges))
+	copy(changes, sp.configChanges)
+	return changes
 }
 
 func (sp *ServerPool) GetAllServersStatus() []map[string]interface{} {
@@ -189,7 +363,20 @@ func (sp *ServerPool) GetAllServersStatus() []map[string]interface{} {
 
 	status := make([]map[string]interface{}, 0, len(sp.servers))
 	for _, server := range sp.servers {
-		status = append(status, server.GetMetricsSummary())
+		summary := server.GetMetricsSummary()
+		summary["effective_score"] = server.EffectiveScore(sp.config)
+		if anomalyDetector != nil {
+			summary["anomalies"] = anomalyDetector.Active(gatewayName(server.ServerURL))
+		}
+		summary["status"] = "healthy"
+		if !server.IsHealthy() {
+			summary["status"] = "unhealthy"
+		}
+		if window := providerMaintenance.Active(gatewayName(server.ServerURL)); window != nil {
+			summary["status"] = ProviderStatusMaintenance
+			summary["maintenance"] = window
+		}
+		status = append(status, summary)
 	}
 	return status
 }
@@ -206,8 +393,8 @@ func (sp *ServerPool) GetBestServer() (*ServerMetrics, error) {
 	bestScore := -1.0
 
 	for _, server := range sp.servers {
-		score := server.GetScore()
-		if score > bestScore {
+		score := server.EffectiveScore(sp.config)
+		if score > bestScore && server.IsHealthy() {
 			bestScore = score
 			bestServer = server
 		}
@@ -220,6 +407,56 @@ func (sp *ServerPool) GetBestServer() (*ServerMetrics, error) {
 	return bestServer, nil
 }
 
+// GetServersByScore returns all servers ordered by effective score, highest first, so
+// servers still warming up sort below proven ones
+func (sp *ServerPool) GetServersByScore() []*ServerMetrics {
+	sp.mu.RLock()
+	config := sp.config
+	servers := make([]*ServerMetrics, 0, len(sp.servers))
+	for _, server := range sp.servers {
+		servers = append(servers, server)
+	}
+	sp.mu.RUnlock()
+
+	scores := make(map[*ServerMetrics]float64, len(servers))
+	for _, server := range servers {
+		scores[server] = server.EffectiveScore(config)
+	}
+	sort.SliceStable(servers, func(i, j int) bool {
+		return scores[servers[i]] > scores[servers[j]]
+	})
+	return servers
+}
+
+// GetServersForSelection returns the servers able to process the currency, filtered to
+// the region hint like SelectServer, in the order the selection mode prefers them. In
+// least-outstanding mode servers at or above MinSelectionScore come first, fewest
+// in-flight requests first, followed by the rest by score
+func (sp *ServerPool) GetServersForSelection(currency, region string) []*ServerMetrics {
+	servers := filterServers(sp.GetServersByScore(), currency, region)
+
+	config := sp.Config()
+	if config.SelectionMode != SelectionLeastOutstanding {
+		return servers
+	}
+
+	eligible := make(map[*ServerMetrics]bool, len(servers))
+	outstanding := make(map[*ServerMetrics]int, len(servers))
+	for _, server := range servers {
+		eligible[server] = server.EffectiveScore(config) >= config.MinSelectionScore
+		outstanding[server] = server.Outstanding()
+	}
+	// Stable, so servers with equal load keep their score order
+	sort.SliceStable(servers, func(i, j int) bool {
+		a, b := servers[i], servers[j]
+		if eligible[a] != eligible[b] {
+			return eligible[a]
+		}
+		return eligible[a] && outstanding[a] < outstanding[b]
+	})
+	return servers
+}
+
 func (sp *ServerPool) GetServerCount() int {
 	sp.mu.RLock()
 	defer sp.mu.RUnlock()
@@ -1,21 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
-	"runtime"
+	"os"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// System metrics are sampled at most this often, and smoothed with an exponentially
+// weighted moving average so one busy sample doesn't trigger shedding
+const (
+	systemSampleInterval = 5 * time.Second
+	systemUsageAlpha     = 0.3
+)
+
 // LoadSheddingConfig holds configuration for load shedding
 type LoadSheddingConfig struct {
 	Enabled              bool    // Enable/disable load shedding
-	MaxActiveRequests    int32   // Maximum concurrent active requests
+	MaxActiveRequests    int32   // Maximum concurrent active requests, the ceiling when adaptive
+	AdaptiveConcurrency  bool    // Adjust the active request limit from observed latency
 	LatencyThresholdMs   int64   // P99 latency threshold in milliseconds
 	CPUThreshold         float64 // CPU usage threshold (0.0 to 1.0)
+	MemoryThreshold      float64 // Memory usage threshold (0.0 to 1.0)
 	ErrorRateThreshold   float64 // Error rate threshold (0.0 to 1.0)
 	CircuitOpenThreshold int     // Number of open circuits before shedding
+	MaxQueueSize         int32   // Requests that may wait for capacity, 0 sheds immediately
+	QueueTimeoutMs       int64   // How long a queued request waits before it is shed
 }
 
 // DefaultLoadSheddingConfig returns sensible defaults
@@ -23,32 +39,144 @@ func DefaultLoadSheddingConfig() LoadSheddingConfig {
 	return LoadSheddingConfig{
 		Enabled:              true,
 		MaxActiveRequests:    1000,
+		AdaptiveConcurrency:  true,
 		LatencyThresholdMs:   5000, // 5 seconds
 		CPUThreshold:         0.80, // 80%
+		MemoryThreshold:      0.90, // 90%
 		ErrorRateThreshold:   0.50, // 50%
 		CircuitOpenThreshold: 2,    // 2 or more circuits open
+		MaxQueueSize:         100,
+		QueueTimeoutMs:       500,
+	}
+}
+
+// LoadSheddingConfigFromEnv returns DefaultLoadSheddingConfig with any LOAD_SHEDDING_*
+// overrides applied
+func LoadSheddingConfigFromEnv() (LoadSheddingConfig, error) {
+	config := DefaultLoadSheddingConfig()
+
+	if v := os.Getenv("LOAD_SHEDDING_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("LOAD_SHEDDING_ENABLED: %w", err)
+		}
+		config.Enabled = enabled
+	}
+	if v := os.Getenv("LOAD_SHEDDING_ADAPTIVE"); v != "" {
+		adaptive, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("LOAD_SHEDDING_ADAPTIVE: %w", err)
+		}
+		config.AdaptiveConcurrency = adaptive
+	}
+	if v := os.Getenv("LOAD_SHEDDING_MAX_ACTIVE"); v != "" {
+		maxActive, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || maxActive <= 0 {
+			return config, fmt.Errorf("LOAD_SHEDDING_MAX_ACTIVE must be a positive integer, got %q", v)
+		}
+		config.MaxActiveRequests = int32(maxActive)
+	}
+	if v := os.Getenv("LOAD_SHEDDING_LATENCY_MS"); v != "" {
+		latencyMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || latencyMs <= 0 {
+			return config, fmt.Errorf("LOAD_SHEDDING_LATENCY_MS must be a positive integer, got %q", v)
+		}
+		config.LatencyThresholdMs = latencyMs
 	}
+	if v := os.Getenv("LOAD_SHEDDING_QUEUE_SIZE"); v != "" {
+		queueSize, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || queueSize < 0 {
+			return config, fmt.Errorf("LOAD_SHEDDING_QUEUE_SIZE must be a non-negative integer, got %q", v)
+		}
+		config.MaxQueueSize = int32(queueSize)
+	}
+	if v := os.Getenv("LOAD_SHEDDING_QUEUE_TIMEOUT_MS"); v != "" {
+		timeoutMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || timeoutMs <= 0 {
+			return config, fmt.Errorf("LOAD_SHEDDING_QUEUE_TIMEOUT_MS must be a positive integer, got %q", v)
+		}
+		config.QueueTimeoutMs = timeoutMs
+	}
+	for name, threshold := range map[string]*float64{
+		"LOAD_SHEDDING_CPU_THRESHOLD":    &config.CPUThreshold,
+		"LOAD_SHEDDING_MEMORY_THRESHOLD": &config.MemoryThreshold,
+	} {
+		if v := os.Getenv(name); v != "" {
+			value, err := strconv.ParseFloat(v, 64)
+			if err != nil || value <= 0 || value > 1 {
+				return config, fmt.Errorf("%s must be between 0 and 1, got %q", name, v)
+			}
+			*threshold = value
+		}
+	}
+
+	return config, nil
 }
 
 // LoadShedder monitors system health and sheds load when overloaded
 type LoadShedder struct {
 	config           LoadSheddingConfig
 	activeRequests   atomic.Int32
+	queuedRequests   atomic.Int32
+	totalQueued      atomic.Int64
 	totalRequests    atomic.Int64
 	shedRequests     atomic.Int64
 	latencyTracker   *LatencyTracker
 	providerRegistry *ProviderRegistry
-	lastCPUCheck     time.Time
+	limiter          *AdaptiveLimiter // nil unless AdaptiveConcurrency is enabled
+
+	// capacityFreed is closed and replaced whenever a request finishes while others are queued
+	capacityFreed chan struct{}
+	queueMu       sync.Mutex
+
+	// System metrics: raw values from the last sample and their rolling averages
+	sampler          *SystemSampler
+	lastSystemSample time.Time
 	lastCPUUsage     float64
+	avgCPUUsage      float64
+	lastMemoryUsage  float64
+	avgMemoryUsage   float64
+	systemMu         sync.Mutex
 }
 
 // NewLoadShedder creates a new load shedder
 func NewLoadShedder(config LoadSheddingConfig, latencyTracker *LatencyTracker, registry *ProviderRegistry) *LoadShedder {
-	return &LoadShedder{
+	ls := &LoadShedder{
 		config:           config,
 		latencyTracker:   latencyTracker,
 		providerRegistry: registry,
-		lastCPUCheck:     time.Now(),
+		sampler:          NewSystemSampler(),
+		lastSystemSample: time.Now(),
+		capacityFreed:    make(chan struct{}),
+	}
+
+	if config.AdaptiveConcurrency {
+		limiterConfig := DefaultAdaptiveLimiterConfig()
+		limiterConfig.MaxLimit = int(config.MaxActiveRequests)
+		if limiterConfig.InitialLimit > limiterConfig.MaxLimit {
+			limiterConfig.InitialLimit = limiterConfig.MaxLimit
+		}
+		if limiterConfig.MinLimit > limiterConfig.MaxLimit {
+			limiterConfig.MinLimit = limiterConfig.MaxLimit
+		}
+		ls.limiter = NewAdaptiveLimiter(limiterConfig)
+	}
+	return ls
+}
+
+// maxActiveRequests returns the current active request limit: the adaptive limit if
+// enabled, otherwise the static MaxActiveRequests
+func (ls *LoadShedder) maxActiveRequests() int32 {
+	if ls.limiter != nil {
+		return int32(ls.limiter.Limit())
+	}
+	return ls.config.MaxActiveRequests
+}
+
+// RecordCompletion feeds a finished request to the adaptive limiter
+func (ls *LoadShedder) RecordCompletion(latency time.Duration, inFlight int32, dropped bool) {
+	if ls.limiter != nil {
+		ls.limiter.OnSample(latency, int(inFlight), dropped)
 	}
 }
 
@@ -61,21 +189,101 @@ func (ls *LoadShedder) IncrementActive() {
 // DecrementActive decrements the active request counter
 func (ls *LoadShedder) DecrementActive() {
 	ls.activeRequests.Add(-1)
+	if ls.queuedRequests.Load() > 0 {
+		ls.queueMu.Lock()
+		close(ls.capacityFreed)
+		ls.capacityFreed = make(chan struct{})
+		ls.queueMu.Unlock()
+	}
 }
 
-// ShouldShed determines if incoming requests should be rejected
-func (ls *LoadShedder) ShouldShed() (bool, string) {
+// ShouldShed determines if incoming requests should be rejected. Unhealthy conditions
+// shed immediately; when only the active request limit is reached the request waits
+// in a bounded queue for capacity and is shed if the queue is full or the wait times out
+func (ls *LoadShedder) ShouldShed(ctx context.Context) (bool, string) {
 	if !ls.config.Enabled {
 		return false, ""
 	}
 
+	if shed, reason := ls.shouldShedUnhealthy(); shed {
+		return true, reason
+	}
+
 	// Check 1: Active request count
-	activeReqs := ls.activeRequests.Load()
-	if activeReqs > ls.config.MaxActiveRequests {
+	if !ls.overCapacity() {
+		return false, ""
+	}
+	return ls.waitForCapacity(ctx)
+}
+
+func (ls *LoadShedder) overCapacity() bool {
+	return ls.activeRequests.Load() > ls.maxActiveRequests()
+}
+
+// waitForCapacity queues a request until the active request count drops under the limit
+func (ls *LoadShedder) waitForCapacity(ctx context.Context) (bool, string) {
+	if ls.queuedRequests.Add(1) > ls.config.MaxQueueSize {
+		ls.queuedRequests.Add(-1)
 		ls.shedRequests.Add(1)
-		return true, "max_active_requests_exceeded"
+		return true, "queue_full"
+	}
+	defer ls.queuedRequests.Add(-1)
+	ls.totalQueued.Add(1)
+
+	timer := time.NewTimer(time.Duration(ls.config.QueueTimeoutMs) * time.Millisecond)
+	defer timer.Stop()
+
+	for {
+		ls.queueMu.Lock()
+		freed := ls.capacityFreed
+		ls.queueMu.Unlock()
+
+		if !ls.overCapacity() {
+			return false, ""
+		}
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			ls.shedRequests.Add(1)
+			return true, "queue_timeout"
+		case <-ctx.Done():
+			ls.shedRequests.Add(1)
+			return true, "queue_timeout"
+		}
 	}
+}
+
+// Saturation reports whether the shedder is turning requests away for lack of capacity:
+// the active request limit is reached and the queue behind it is full
+func (ls *LoadShedder) Saturation() (bool, map[string]interface{}) {
+	active, limit, queued := ls.activeRequests.Load(), ls.maxActiveRequests(), ls.queuedRequests.Load()
+	detail := map[string]interface{}{
+		"enabled":         ls.config.Enabled,
+		"active_requests": active,
+		"limit":           limit,
+		"queued":          queued,
+		"max_queue_size":  ls.config.MaxQueueSize,
+	}
+	return ls.config.Enabled && active >= limit && queued >= ls.config.MaxQueueSize, detail
+}
 
+// EstimatedQueueWait estimates how long a newly queued request would wait: the median
+// request latency for every batch of limit-many requests ahead of it
+func (ls *LoadShedder) EstimatedQueueWait() time.Duration {
+	if ls.latencyTracker == nil {
+		return 0
+	}
+	limit := int64(ls.maxActiveRequests())
+	if limit <= 0 {
+		limit = 1
+	}
+	batches := int64(ls.queuedRequests.Load())/limit + 1
+	return ls.latencyTracker.GetPercentiles().P50 * time.Duration(batches)
+}
+
+// shouldShedUnhealthy checks the conditions that shed without queueing
+func (ls *LoadShedder) shouldShedUnhealthy() (bool, string) {
 	// Check 2: P99 Latency
 	if ls.latencyTracker != nil {
 		percentiles := ls.latencyTracker.GetPercentiles()
@@ -85,22 +293,15 @@ func (ls *LoadShedder) ShouldShed() (bool, string) {
 		}
 	}
 
-	// Check 3: CPU Usage (check every 5 seconds to avoid overhead)
-	if time.Since(ls.lastCPUCheck) > 5*time.Second {
-		cpuUsage := ls.getCPUUsage()
-		ls.lastCPUUsage = cpuUsage
-		ls.lastCPUCheck = time.Now()
-
-		if cpuUsage > ls.config.CPUThreshold {
-			ls.shedRequests.Add(1)
-			return true, "high_cpu_usage"
-		}
-	} else {
-		// Use cached CPU value
-		if ls.lastCPUUsage > ls.config.CPUThreshold {
-			ls.shedRequests.Add(1)
-			return true, "high_cpu_usage"
-		}
+	// Check 3: CPU and memory usage, on their rolling averages
+	cpuUsage, memoryUsage := ls.systemUsage()
+	if cpuUsage > ls.config.CPUThreshold {
+		ls.shedRequests.Add(1)
+		return true, "high_cpu_usage"
+	}
+	if ls.config.MemoryThreshold > 0 && memoryUsage > ls.config.MemoryThreshold {
+		ls.shedRequests.Add(1)
+		return true, "high_memory_usage"
 	}
 
 	// Check 4: Circuit Breaker States
@@ -115,24 +316,34 @@ func (ls *LoadShedder) ShouldShed() (bool, string) {
 	return false, ""
 }
 
-// getCPUUsage estimates CPU usage as a percentage (0.0 to 1.0)
-func (ls *LoadShedder) getCPUUsage() float64 {
-	// Use Go runtime stats as a proxy for CPU usage
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
+// systemUsage returns the smoothed CPU and memory usage (0.0 to 1.0), taking a new
+// sample when the last one is older than systemSampleInterval
+func (ls *LoadShedder) systemUsage() (float64, float64) {
+	ls.systemMu.Lock()
+	defer ls.systemMu.Unlock()
 
-	// NumGoroutine as a rough indicator of system load
-	numGoroutines := float64(runtime.NumGoroutine())
-
-	// Normalize: assume 1000 goroutines = 80% load
-	// This is a rough heuristic and should be calibrated per system
-	cpuEstimate := numGoroutines / 1250.0 // 1250 goroutines = 100% CPU
+	if time.Since(ls.lastSystemSample) < systemSampleInterval {
+		return ls.avgCPUUsage, ls.avgMemoryUsage
+	}
+	ls.lastSystemSample = time.Now()
 
-	if cpuEstimate > 1.0 {
-		cpuEstimate = 1.0
+	if cpuUsage, ok := ls.sampler.CPUUsage(); ok {
+		ls.lastCPUUsage = cpuUsage
+		ls.avgCPUUsage = smoothUsage(ls.avgCPUUsage, cpuUsage)
 	}
+	if memoryUsage, ok := readMemoryUsage(); ok {
+		ls.lastMemoryUsage = memoryUsage
+		ls.avgMemoryUsage = smoothUsage(ls.avgMemoryUsage, memoryUsage)
+	}
+	return ls.avgCPUUsage, ls.avgMemoryUsage
+}
 
-	return cpuEstimate
+// smoothUsage folds a sample into a rolling average, seeding it with the first sample
+func smoothUsage(avg, sample float64) float64 {
+	if avg == 0 {
+		return sample
+	}
+	return systemUsageAlpha*sample + (1-systemUsageAlpha)*avg
 }
 
 // countOpenCircuits counts how many circuit breakers are in OPEN state
@@ -164,15 +375,34 @@ func (ls *LoadShedder) GetStats() LoadSheddingStats {
 		shedRate = float64(shedReqs) / float64(totalReqs) * 100
 	}
 
+	var limiterStats *AdaptiveLimiterStats
+	if ls.limiter != nil {
+		stats := ls.limiter.GetStats()
+		limiterStats = &stats
+	}
+
+	ls.systemMu.Lock()
+	defer ls.systemMu.Unlock()
+
 	return LoadSheddingStats{
+		QueuedRequests:   int(ls.queuedRequests.Load()),
+		TotalQueued:      ls.totalQueued.Load(),
+		MaxQueueSize:     int(ls.config.MaxQueueSize),
+		QueueTimeoutMs:   ls.config.QueueTimeoutMs,
 		Enabled:          ls.config.Enabled,
 		ActiveRequests:   int(ls.activeRequests.Load()),
 		TotalRequests:    totalReqs,
 		ShedRequests:     shedReqs,
 		ShedRate:         shedRate,
-		MaxActiveAllowed: int(ls.config.MaxActiveRequests),
+		MaxActiveAllowed: int(ls.maxActiveRequests()),
 		CPUUsage:         ls.lastCPUUsage,
+		CPUUsageAvg:      ls.avgCPUUsage,
 		CPUThreshold:     ls.config.CPUThreshold,
+		MemoryUsage:      ls.lastMemoryUsage,
+		MemoryUsageAvg:   ls.avgMemoryUsage,
+		MemoryThreshold:  ls.config.MemoryThreshold,
+		SystemSampledAt:  ls.lastSystemSample.Format(time.RFC3339),
+		AdaptiveLimiter:  limiterStats,
 	}
 }
 
@@ -185,16 +415,44 @@ type LoadSheddingStats struct {
 	ShedRate         float64 `json:"shed_rate_percent"`
 	MaxActiveAllowed int     `json:"max_active_allowed"`
 	CPUUsage         float64 `json:"cpu_usage"`
+	CPUUsageAvg      float64 `json:"cpu_usage_avg"`
 	CPUThreshold     float64 `json:"cpu_threshold"`
+	MemoryUsage      float64 `json:"memory_usage"`
+	MemoryUsageAvg   float64 `json:"memory_usage_avg"`
+	MemoryThreshold  float64 `json:"memory_threshold"`
+	SystemSampledAt  string  `json:"system_sampled_at"`
+
+	QueuedRequests int   `json:"queued_requests"`
+	TotalQueued    int64 `json:"total_queued"`
+	MaxQueueSize   int   `json:"max_queue_size"`
+	QueueTimeoutMs int64 `json:"queue_timeout_ms"`
+
+	AdaptiveLimiter *AdaptiveLimiterStats `json:"adaptive_limiter,omitempty"`
+}
+
+// loadShedResponse is the 503 body for a shed request
+type loadShedResponse struct {
+	ErrorResponse
+	QueueDepth      int   `json:"queue_depth"`
+	EstimatedWaitMs int64 `json:"estimated_wait_ms"`
 }
 
 // LoadSheddingMiddleware wraps HTTP handlers with load shedding
 func LoadSheddingMiddleware(loadShedder *LoadShedder) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Probes must see the instance's state rather than be shed with the traffic
+			if r.URL.Path == "/livez" || r.URL.Path == "/readyz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Check if we should shed this request
-			shouldShed, reason := loadShedder.ShouldShed()
+			shouldShed, reason := loadShedder.ShouldShed(r.Context())
 			if shouldShed {
+				queueDepth := loadShedder.queuedRequests.Load()
+				estimatedWait := loadShedder.EstimatedQueueWait()
+
 				// Log shedding event
 				if appLogger != nil {
 					correlationID, _ := r.Context().Value("correlation_id").(string)
@@ -202,20 +460,29 @@ func LoadSheddingMiddleware(loadShedder *LoadShedder) func(http.Handler) http.Ha
 						"correlation_id":  correlationID,
 						"reason":          reason,
 						"active_requests": loadShedder.activeRequests.Load(),
+						"queue_depth":     queueDepth,
 					})
 				}
 
-				// Return 503 Service Unavailable
-				w.Header().Set("Retry-After", "5") // Suggest retry after 5 seconds
+				// Return 503 Service Unavailable, suggesting a retry once the queue has drained
+				retryAfter := int(math.Ceil(estimatedWait.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusServiceUnavailable)
 
-				response := NewErrorResponse(
-					ErrRateLimited,
-					"System overloaded, please retry",
-					"REJECTED",
-					reason,
-				)
+				response := loadShedResponse{
+					ErrorResponse: NewErrorResponse(
+						ErrRateLimited,
+						"System overloaded, please retry",
+						"REJECTED",
+						reason,
+					),
+					QueueDepth:      int(queueDepth),
+					EstimatedWaitMs: estimatedWait.Milliseconds(),
+				}
 				json.NewEncoder(w).Encode(response)
 				return
 			}
@@ -223,13 +490,44 @@ func LoadSheddingMiddleware(loadShedder *LoadShedder) func(http.Handler) http.Ha
 			// Track active request
 			loadShedder.IncrementActive()
 			defer loadShedder.DecrementActive()
+			inFlight := loadShedder.activeRequests.Load()
+			start := time.Now()
 
 			// Continue to next handler
 			next.ServeHTTP(w, r)
+
+			// A request whose context ended (timeout, client gone) counts as dropped.
+			// Websocket connections live for minutes and say nothing about capacity
+			if !isWebSocketUpgrade(r) {
+				loadShedder.RecordCompletion(time.Since(start), inFlight, r.Context().Err() != nil)
+			}
 		})
 	}
 }
 
+// requestLatencyTracker records the latency of every HTTP request the server handles
+var requestLatencyTracker = NewLatencyTracker(defaultLatencyWindow)
+
+// RequestLatencyMiddleware records each request's latency in tracker
+func RequestLatencyMiddleware(tracker *LatencyTracker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWebSocketUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			tracker.AddSample(time.Since(start))
+		})
+	}
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != ""
+}
+
 // Global load shedder instance
 var globalLoadShedder *LoadShedder
 
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"os"
 	"regexp"
@@ -22,12 +21,14 @@ const (
 	LogLevelFatal LogLevel = "FATAL"
 )
 
-// StructuredLogger provides structured JSON logging with PII masking
+// StructuredLogger provides structured JSON logging with PII masking. Entries go to
+// every configured sink, stdout by default
 type StructuredLogger struct {
 	mu      sync.Mutex
 	level   LogLevel
-	output  *os.File
+	sinks   []LogSink
 	masking bool
+	sampler *LogSampler
 }
 
 // LogEntry represents a structured log entry
@@ -48,14 +49,51 @@ type LogEntry struct {
 func NewStructuredLogger(level LogLevel, enableMasking bool) *StructuredLogger {
 	return &StructuredLogger{
 		level:   level,
-		output:  os.Stdout,
+		sinks:   []LogSink{&writerSink{file: os.Stdout}},
 		masking: enableMasking,
+		sampler: NewLogSampler(),
 	}
 }
 
+// Level returns the minimum level written
+func (sl *StructuredLogger) Level() LogLevel {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.level
+}
+
+// SetLevel changes the minimum level written
+func (sl *StructuredLogger) SetLevel(level LogLevel) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.level = level
+}
+
+// Sampler returns the logger's sampler
+func (sl *StructuredLogger) Sampler() *LogSampler {
+	return sl.sampler
+}
+
+// SetSinks replaces the logger's sinks, closing the previous ones
+func (sl *StructuredLogger) SetSinks(sinks []LogSink) {
+	sl.mu.Lock()
+	previous := sl.sinks
+	sl.sinks = sinks
+	sl.mu.Unlock()
+
+	for _, sink := range previous {
+		sink.Close()
+	}
+}
+
+// Close flushes and closes every sink
+func (sl *StructuredLogger) Close() {
+	sl.SetSinks(nil)
+}
+
 // Log writes a structured log entry
 func (sl *StructuredLogger) Log(level LogLevel, message string, fields map[string]interface{}) {
-	if !sl.shouldLog(level) {
+	if !sl.shouldLog(level) || !sl.sampler.Keep(level, message, fields) {
 		return
 	}
 
@@ -112,7 +150,11 @@ func (sl *StructuredLogger) Log(level LogLevel, message string, fields map[strin
 		return
 	}
 
-	fmt.Fprintln(sl.output, string(jsonBytes))
+	for _, sink := range sl.sinks {
+		if err := sink.Write(entry, jsonBytes); err != nil {
+			log.Printf("Failed to write log entry to %T: %v", sink, err)
+		}
+	}
 }
 
 // Info logs an info level message
@@ -138,20 +180,13 @@ func (sl *StructuredLogger) Debug(message string, fields map[string]interface{})
 // Fatal logs a fatal level message and exits
 func (sl *StructuredLogger) Fatal(message string, fields map[string]interface{}) {
 	sl.Log(LogLevelFatal, message, fields)
+	sl.Close()
 	os.Exit(1)
 }
 
 // shouldLog determines if a message should be logged based on level
 func (sl *StructuredLogger) shouldLog(level LogLevel) bool {
-	levels := map[LogLevel]int{
-		LogLevelDebug: 0,
-		LogLevelInfo:  1,
-		LogLevelWarn:  2,
-		LogLevelError: 3,
-		LogLevelFatal: 4,
-	}
-
-	return levels[level] >= levels[sl.level]
+	return logLevelRanks[level] >= logLevelRanks[sl.Level()]
 }
 
 // maskPII masks sensitive information in log fields
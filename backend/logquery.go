@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogItem is one gateway request from the log table. Status is 1 for success and 0
+// for failure, kept alongside Success for existing dashboard clients
+type LogItem struct {
+	TransactionID int    `json:"transaction_id"`
+	PaymentID     string `json:"payment_id,omitempty"`
+	Link          string `json:"link"`
+	Name          string `json:"name"`
+	Status        int    `json:"status"`
+	Success       bool   `json:"success"`
+	Latency       int    `json:"latency"`
+	ErrorType     string `json:"error_type,omitempty"`
+	ErrorMessage  string `json:"error_message,omitempty"`
+	CurrentTime   int64  `json:"current_time"`
+}
+
+// LogSummary aggregates one provider's requests over one hour
+type LogSummary struct {
+	Provider     string  `json:"provider"`
+	Hour         string  `json:"hour"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// LogFilter holds query parameters for gateway request logs
+type LogFilter struct {
+	PaymentID    string
+	Provider     string
+	Success      *bool
+	From         *time.Time
+	To           *time.Time
+	MinLatencyMs *int64
+	SortColumn   string // created_at or latency_ms
+	SortOrder    string // ASC or DESC
+	Limit        int
+	Offset       int
+}
+
+// logSortColumns maps the sort parameter to its column, keeping user input out of SQL
+var logSortColumns = map[string]string{
+	"created_at": "created_at",
+	"time":       "created_at",
+	"latency":    "latency_ms",
+	"latency_ms": "latency_ms",
+}
+
+// parseLogFilter builds a LogFilter from query parameters
+func parseLogFilter(r *http.Request) (LogFilter, error) {
+	q := r.URL.Query()
+	filter := LogFilter{
+		PaymentID:  q.Get("payment_id"),
+		Provider:   q.Get("provider"),
+		SortColumn: "created_at",
+		SortOrder:  "DESC",
+		Limit:      50,
+	}
+
+	if v := q.Get("success"); v != "" {
+		success, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid success: %s", v)
+		}
+		filter.Success = &success
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %v", err)
+		}
+		filter.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %v", err)
+		}
+		filter.To = &t
+	}
+	if v := q.Get("min_latency"); v != "" {
+		latency, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || latency < 0 {
+			return filter, fmt.Errorf("invalid min_latency: %s", v)
+		}
+		filter.MinLatencyMs = &latency
+	}
+	if v := q.Get("sort"); v != "" {
+		column, ok := logSortColumns[strings.ToLower(v)]
+		if !ok {
+			return filter, fmt.Errorf("invalid sort: %s (want created_at or latency)", v)
+		}
+		filter.SortColumn = column
+	}
+	if v := q.Get("order"); v != "" {
+		order := strings.ToUpper(v)
+		if order != "ASC" && order != "DESC" {
+			return filter, fmt.Errorf("invalid order: %s (want asc or desc)", v)
+		}
+		filter.SortOrder = order
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		if limit > 500 {
+			limit = 500
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", v)
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// LogsHandler handles GET /logs with filtering, sorting and pagination
+func LogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if dataStore == nil {
+		http.Error(w, "Logs not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logs, total, err := dataStore.QueryLogs(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"logs":   logs,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// LogsSummaryHandler handles GET /logs/summary: request and error counts per provider
+// per hour. The window defaults to the last 24 hours
+func LogsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Logs not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.From == nil {
+		from := time.Now().Add(-24 * time.Hour)
+		filter.From = &from
+	}
+
+	summaries, err := dataStore.SummarizeLogs(filter)
+	if err != nil {
+		http.Error(w, "Failed to summarize logs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary": summaries,
+		"from":    filter.From.UTC().Format(time.RFC3339),
+	})
+}
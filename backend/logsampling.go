@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logLevelRanks orders the log levels by severity
+var logLevelRanks = map[LogLevel]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+	LogLevelFatal: 4,
+}
+
+// ParseLogLevel parses a level name such as "info" or "WARN"
+func ParseLogLevel(s string) (LogLevel, error) {
+	level := LogLevel(strings.ToUpper(strings.TrimSpace(s)))
+	if _, ok := logLevelRanks[level]; !ok {
+		return "", fmt.Errorf("unknown log level %q (want DEBUG, INFO, WARN, ERROR or FATAL)", s)
+	}
+	return level, nil
+}
+
+// LogSampler thins out routine log entries by category. An entry's category is its
+// operation field, or its message when it has none. Failures, meaning WARN and above or
+// entries with success=false, are always kept
+type LogSampler struct {
+	rates       map[string]float64 // fraction of routine entries kept, by category
+	defaultRate float64
+	suppressed  map[string]int64
+	mu          sync.Mutex
+}
+
+// NewLogSampler creates a sampler that keeps everything
+func NewLogSampler() *LogSampler {
+	return &LogSampler{
+		rates:       make(map[string]float64),
+		defaultRate: 1,
+		suppressed:  make(map[string]int64),
+	}
+}
+
+// logCategory returns the sampling category of an entry
+func logCategory(message string, fields map[string]interface{}) string {
+	if operation, ok := fields["operation"].(string); ok && operation != "" {
+		return operation
+	}
+	return message
+}
+
+// isFailureEntry reports whether an entry records a failure and so bypasses sampling
+func isFailureEntry(level LogLevel, fields map[string]interface{}) bool {
+	if logLevelRanks[level] >= logLevelRanks[LogLevelWarn] {
+		return true
+	}
+	success, ok := fields["success"].(bool)
+	return ok && !success
+}
+
+// Keep decides whether an entry is written
+func (ls *LogSampler) Keep(level LogLevel, message string, fields map[string]interface{}) bool {
+	if isFailureEntry(level, fields) {
+		return true
+	}
+	category := logCategory(message, fields)
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	rate, ok := ls.rates[category]
+	if !ok {
+		rate = ls.defaultRate
+	}
+	if rate >= 1 || (rate > 0 && rand.Float64() < rate) {
+		return true
+	}
+	ls.suppressed[category]++
+	return false
+}
+
+// SetRates replaces the per-category rates and the default rate. Rates must be
+// between 0 and 1
+func (ls *LogSampler) SetRates(rates map[string]float64, defaultRate float64) error {
+	if defaultRate < 0 || defaultRate > 1 {
+		return fmt.Errorf("default sample rate must be between 0 and 1, got %v", defaultRate)
+	}
+	copied := make(map[string]float64, len(rates))
+	for category, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("sample rate for %q must be between 0 and 1, got %v", category, rate)
+		}
+		copied[category] = rate
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.rates = copied
+	ls.defaultRate = defaultRate
+	return nil
+}
+
+// Rates returns the per-category rates and the default rate
+func (ls *LogSampler) Rates() (map[string]float64, float64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	rates := make(map[string]float64, len(ls.rates))
+	for category, rate := range ls.rates {
+		rates[category] = rate
+	}
+	return rates, ls.defaultRate
+}
+
+// Suppressed returns how many entries were sampled out, by category
+func (ls *LogSampler) Suppressed() map[string]int64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	suppressed := make(map[string]int64, len(ls.suppressed))
+	for category, count := range ls.suppressed {
+		suppressed[category] = count
+	}
+	return suppressed
+}
+
+// LogLevelFromEnv parses LOG_LEVEL, defaulting to INFO
+func LogLevelFromEnv() (LogLevel, error) {
+	v := os.Getenv("LOG_LEVEL")
+	if v == "" {
+		return LogLevelInfo, nil
+	}
+	return ParseLogLevel(v)
+}
+
+// LogSampleRatesFromEnv parses LOG_SAMPLE_RATES, comma separated category=rate pairs
+// where the category "*" sets the default, e.g. "routing=0.01,*=1"
+func LogSampleRatesFromEnv() (map[string]float64, float64, error) {
+	rates := make(map[string]float64)
+	defaultRate := 1.0
+
+	v := os.Getenv("LOG_SAMPLE_RATES")
+	if v == "" {
+		return rates, defaultRate, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		category, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || category == "" {
+			return nil, 0, fmt.Errorf("LOG_SAMPLE_RATES entry %q must be category=rate", entry)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, 0, fmt.Errorf("LOG_SAMPLE_RATES rate for %q must be between 0 and 1, got %q", category, value)
+		}
+		if category == "*" {
+			defaultRate = rate
+		} else {
+			rates[category] = rate
+		}
+	}
+	return rates, defaultRate, nil
+}
+
+// logLevelPayload is the body of GET/PUT /admin/log-level
+type logLevelPayload struct {
+	Level             LogLevel           `json:"level"`
+	SampleRates       map[string]float64 `json:"sample_rates"`
+	DefaultSampleRate float64            `json:"default_sample_rate"`
+}
+
+func currentLogLevelPayload() logLevelPayload {
+	rates, defaultRate := appLogger.Sampler().Rates()
+	return logLevelPayload{Level: appLogger.Level(), SampleRates: rates, DefaultSampleRate: defaultRate}
+}
+
+// AdminLogLevelHandler returns (GET) or changes (PUT) the log level and sampling rates
+// at runtime. PUT bodies may be partial, omitted fields keep their current value
+func AdminLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"log_level":  currentLogLevelPayload(),
+			"suppressed": appLogger.Sampler().Suppressed(),
+		})
+
+	case http.MethodPut:
+		current := currentLogLevelPayload()
+		payload := current
+		payload.SampleRates = nil
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if payload.SampleRates == nil {
+			payload.SampleRates = current.SampleRates
+		}
+
+		level, err := ParseLogLevel(string(payload.Level))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload.Level = level
+		if err := appLogger.Sampler().SetRates(payload.SampleRates, payload.DefaultSampleRate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		appLogger.SetLevel(level)
+		recordAudit(r, "update_log_level", "logger", current, payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"message":   "Log level updated",
+			"log_level": payload,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogSink is a destination for structured log entries. line is the entry's JSON
+// encoding, without a trailing newline
+type LogSink interface {
+	Write(entry LogEntry, line []byte) error
+	Close() error
+}
+
+// LogSinkConfig selects and configures the log sinks
+type LogSinkConfig struct {
+	Sinks []string // Any of stdout, file, syslog, http
+
+	FilePath       string
+	FileMaxSize    int64         // Rotate once the file reaches this many bytes
+	FileMaxAge     time.Duration // Rotate once the file is this old
+	FileMaxBackups int           // Rotated files kept, oldest removed first
+
+	SyslogAddr string // e.g. udp://localhost:514, tcp://host:601 or unixgram:///dev/log
+	SyslogTag  string
+
+	HTTPURL           string
+	HTTPFormat        string // loki or elasticsearch
+	HTTPIndex         string // Elasticsearch index
+	HTTPBatchSize     int
+	HTTPFlushInterval time.Duration
+}
+
+// DefaultLogSinkConfig returns sensible defaults: stdout only
+func DefaultLogSinkConfig() LogSinkConfig {
+	return LogSinkConfig{
+		Sinks:             []string{"stdout"},
+		FilePath:          "logs/pulseberry.log",
+		FileMaxSize:       100 * 1024 * 1024,
+		FileMaxAge:        24 * time.Hour,
+		FileMaxBackups:    7,
+		SyslogTag:         "pulseberry",
+		HTTPFormat:        "loki",
+		HTTPIndex:         "pulseberry-logs",
+		HTTPBatchSize:     100,
+		HTTPFlushInterval: 2 * time.Second,
+	}
+}
+
+// LogSinkConfigFromEnv builds the sink config from LOG_* environment variables,
+// falling back to the defaults
+func LogSinkConfigFromEnv() (LogSinkConfig, error) {
+	config := DefaultLogSinkConfig()
+
+	if v := os.Getenv("LOG_SINKS"); v != "" {
+		config.Sinks = nil
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			switch name {
+			case "stdout", "file", "syslog", "http":
+				config.Sinks = append(config.Sinks, name)
+			case "":
+			default:
+				return config, fmt.Errorf("LOG_SINKS: unknown sink %q (want stdout, file, syslog or http)", name)
+			}
+		}
+	}
+	if v := os.Getenv("LOG_FILE_PATH"); v != "" {
+		config.FilePath = v
+	}
+	if v := os.Getenv("LOG_FILE_MAX_SIZE_MB"); v != "" {
+		sizeMB, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || sizeMB <= 0 {
+			return config, fmt.Errorf("LOG_FILE_MAX_SIZE_MB must be a positive integer, got %q", v)
+		}
+		config.FileMaxSize = sizeMB * 1024 * 1024
+	}
+	if v := os.Getenv("LOG_FILE_MAX_AGE_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours <= 0 {
+			return config, fmt.Errorf("LOG_FILE_MAX_AGE_HOURS must be a positive integer, got %q", v)
+		}
+		config.FileMaxAge = time.Duration(hours) * time.Hour
+	}
+	if v := os.Getenv("LOG_FILE_MAX_BACKUPS"); v != "" {
+		backups, err := strconv.Atoi(v)
+		if err != nil || backups < 0 {
+			return config, fmt.Errorf("LOG_FILE_MAX_BACKUPS must be a non-negative integer, got %q", v)
+		}
+		config.FileMaxBackups = backups
+	}
+	if v := os.Getenv("LOG_SYSLOG_ADDR"); v != "" {
+		config.SyslogAddr = v
+	}
+	if v := os.Getenv("LOG_HTTP_URL"); v != "" {
+		config.HTTPURL = v
+	}
+	if v := os.Getenv("LOG_HTTP_FORMAT"); v != "" {
+		config.HTTPFormat = strings.ToLower(v)
+		if config.HTTPFormat != "loki" && config.HTTPFormat != "elasticsearch" {
+			return config, fmt.Errorf("LOG_HTTP_FORMAT must be loki or elasticsearch, got %q", v)
+		}
+	}
+	if v := os.Getenv("LOG_HTTP_INDEX"); v != "" {
+		config.HTTPIndex = v
+	}
+	if v := os.Getenv("LOG_HTTP_BATCH_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			return config, fmt.Errorf("LOG_HTTP_BATCH_SIZE must be a positive integer, got %q", v)
+		}
+		config.HTTPBatchSize = size
+	}
+	if v := os.Getenv("LOG_HTTP_FLUSH_INTERVAL_MS"); v != "" {
+		intervalMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || intervalMs <= 0 {
+			return config, fmt.Errorf("LOG_HTTP_FLUSH_INTERVAL_MS must be a positive integer, got %q", v)
+		}
+		config.HTTPFlushInterval = time.Duration(intervalMs) * time.Millisecond
+	}
+	return config, nil
+}
+
+// NewLogSinks opens every sink the config selects
+func NewLogSinks(config LogSinkConfig) ([]LogSink, error) {
+	sinks := make([]LogSink, 0, len(config.Sinks))
+	for _, name := range config.Sinks {
+		var sink LogSink
+		var err error
+		switch name {
+		case "stdout":
+			sink = &writerSink{file: os.Stdout}
+		case "file":
+			sink, err = NewRotatingFileSink(config.FilePath, config.FileMaxSize, config.FileMaxAge, config.FileMaxBackups)
+		case "syslog":
+			sink, err = NewSyslogSink(config.SyslogAddr, config.SyslogTag)
+		case "http":
+			sink, err = NewHTTPLogShipper(config.HTTPURL, config.HTTPFormat, config.HTTPIndex, config.HTTPBatchSize, config.HTTPFlushInterval)
+		}
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("%s sink: %v", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// writerSink writes one JSON line per entry to a file such as stdout
+type writerSink struct {
+	file *os.File
+}
+
+func (s *writerSink) Write(entry LogEntry, line []byte) error {
+	_, err := s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close leaves the file open, it isn't owned by the sink
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// RotatingFileSink writes JSON lines to a file, rotating it when it grows past a size
+// or age. Rotated files get a timestamp suffix and only the newest backups are kept
+type RotatingFileSink struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	mu       sync.Mutex
+}
+
+// NewRotatingFileSink opens (or creates) the log file, appending to existing content
+func NewRotatingFileSink(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+	sink := &RotatingFileSink{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *RotatingFileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.openedAt = info.ModTime()
+	if s.size == 0 {
+		s.openedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Write(entry LogEntry, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("log file is closed")
+	}
+	if s.size > 0 && (s.size+int64(len(line))+1 > s.maxSize || time.Since(s.openedAt) > s.maxAge) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the current file aside, opens a fresh one and prunes old backups
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	backup := fmt.Sprintf("%s.%s", s.path, time.Now().UTC().Format("20060102-150405.000"))
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	backups, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups) // timestamp suffixes sort oldest first
+	for len(backups) > s.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// SyslogSink sends entries as RFC 5424 messages over UDP, TCP or a unix socket
+type SyslogSink struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	conn     net.Conn
+	mu       sync.Mutex
+}
+
+// NewSyslogSink connects to a syslog server, e.g. udp://localhost:514 or
+// unixgram:///dev/log
+func NewSyslogSink(addr, tag string) (*SyslogSink, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid syslog address %q", addr)
+	}
+	address := u.Host
+	if strings.HasPrefix(u.Scheme, "unix") {
+		address = u.Path
+	}
+
+	hostname, _ := os.Hostname()
+	sink := &SyslogSink{network: u.Scheme, address: address, tag: tag, hostname: hostname}
+	if err := sink.connect(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *SyslogSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// syslogSeverity maps log levels to syslog severities
+func syslogSeverity(level string) int {
+	switch LogLevel(level) {
+	case LogLevelDebug:
+		return 7
+	case LogLevelInfo:
+		return 6
+	case LogLevelWarn:
+		return 4
+	case LogLevelError:
+		return 3
+	case LogLevelFatal:
+		return 2
+	default:
+		return 5
+	}
+}
+
+func (s *SyslogSink) Write(entry LogEntry, line []byte) error {
+	const facilityLocal0 = 16
+	priority := facilityLocal0*8 + syslogSeverity(entry.Level)
+	message := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priority, entry.Timestamp, s.hostname, s.tag, os.Getpid(), line)
+	if s.network == "tcp" {
+		// Octet counting framing, RFC 6587
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.conn.Write([]byte(message)); err != nil {
+		// Reconnect on the next write, the server may have restarted
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// HTTPLogShipper batches entries and posts them to Loki's push API or Elasticsearch's
+// bulk API. Entries are dropped rather than blocking the logger when the buffer is full
+type HTTPLogShipper struct {
+	url           string
+	format        string
+	index         string
+	batchSize     int
+	flushInterval time.Duration
+	client        *http.Client
+
+	entries  chan shippedEntry
+	stopChan chan bool
+	done     chan struct{}
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+type shippedEntry struct {
+	entry LogEntry
+	line  []byte
+}
+
+// NewHTTPLogShipper starts a shipper posting to baseURL in the given format
+func NewHTTPLogShipper(baseURL, format, index string, batchSize int, flushInterval time.Duration) (*HTTPLogShipper, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("LOG_HTTP_URL is required")
+	}
+	endpoint := strings.TrimRight(baseURL, "/")
+	switch format {
+	case "loki":
+		endpoint += "/loki/api/v1/push"
+	case "elasticsearch":
+		endpoint += "/_bulk"
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	shipper := &HTTPLogShipper{
+		url:           endpoint,
+		format:        format,
+		index:         index,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		entries:       make(chan shippedEntry, batchSize*10),
+		stopChan:      make(chan bool),
+		done:          make(chan struct{}),
+	}
+	go shipper.run()
+	return shipper, nil
+}
+
+func (s *HTTPLogShipper) Write(entry LogEntry, line []byte) error {
+	select {
+	case s.entries <- shippedEntry{entry: entry, line: append([]byte(nil), line...)}:
+	default:
+		s.dropped.Add(1)
+	}
+	return nil
+}
+
+// run collects entries into batches, sending one when it is full or the flush
+// interval passes
+func (s *HTTPLogShipper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]shippedEntry, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.send(batch); err != nil {
+			s.failed.Add(int64(len(batch)))
+			log.Printf("[LogShipper] Failed to ship %d entries: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e := <-s.entries:
+			batch = append(batch, e)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.stopChan:
+			for {
+				select {
+				case e := <-s.entries:
+					batch = append(batch, e)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts one batch in the configured format
+func (s *HTTPLogShipper) send(batch []shippedEntry) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+
+	switch s.format {
+	case "loki":
+		// One stream per level, so levels can be selected by label
+		streams := make(map[string][][2]string)
+		for _, e := range batch {
+			ts, err := time.Parse(time.RFC3339Nano, e.entry.Timestamp)
+			if err != nil {
+				ts = time.Now()
+			}
+			streams[e.entry.Level] = append(streams[e.entry.Level], [2]string{strconv.FormatInt(ts.UnixNano(), 10), string(e.line)})
+		}
+		payload := make([]map[string]interface{}, 0, len(streams))
+		for level, values := range streams {
+			payload = append(payload, map[string]interface{}{
+				"stream": map[string]string{"app": "pulseberry", "level": level},
+				"values": values,
+			})
+		}
+		if err := json.NewEncoder(&body).Encode(map[string]interface{}{"streams": payload}); err != nil {
+			return err
+		}
+
+	case "elasticsearch":
+		contentType = "application/x-ndjson"
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": s.index}})
+		for _, e := range batch {
+			body.Write(action)
+			body.WriteByte('\n')
+			body.Write(e.line)
+			body.WriteByte('\n')
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// Close flushes buffered entries and stops the shipper
+func (s *HTTPLogShipper) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+	s.stopChan <- true
+	<-s.done
+	if dropped, failed := s.dropped.Load(), s.failed.Load(); dropped > 0 || failed > 0 {
+		log.Printf("[LogShipper] Stopped, %d entries dropped and %d failed to ship", dropped, failed)
+	}
+	return nil
+}
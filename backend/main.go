@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,12 +24,21 @@ var (
 	rdb              *redis.Client
 	serverPool       *ServerPool       // Legacy - kept for backward compatibility
 	providerRegistry *ProviderRegistry // New provider registry
+	providerSelector *ProviderSelector
+	healthProber     *HealthProber
 	apiKeyStore      *APIKeyStore
 	rateLimiter      *RateLimiter
 	appLogger        *StructuredLogger
 )
 
-// ComplianceThreshold defines the amount above which compliance checks are required
+// Failover budget for a single payment across all providers it cascades through
+const (
+	paymentMaxAttempts = 4
+	paymentTimeBudget  = 25 * time.Second
+)
+
+// ComplianceThreshold is the default amount at or above which compliance checks are
+// required, until per-currency or per-merchant rules are configured
 const ComplianceThreshold = 1000000 // $10,000 in cents
 
 func PaymentKey(w http.ResponseWriter, r *http.Request) {
@@ -47,33 +58,29 @@ func PaymentKey(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 		type CheckRequest struct {
-			Id     string `json:"id"`
-			Amount int    `json:"amount"`
+			Id       string `json:"id" validate:"required"`
+			Amount   int    `json:"amount" validate:"required,gt=0"`
+			Currency string `json:"currency" validate:"omitempty,len=3"`
+			UserID   string `json:"user_id"`
 		}
 		var req CheckRequest
-		err = json.Unmarshal(body, &req)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		if err := decodeStrict(bytes.NewReader(body), &req); err != nil {
+			writeRequestError(w, "FAILED", err)
 			return
 		}
-
-		hashData := map[string]interface{}{
-			"id":     req.Id,
-			"amount": req.Amount,
-		}
-		hashJSON, _ := json.Marshal(hashData)
-		requestHash := SHA256Hash(string(hashJSON))
-
-		cachedPaymentID, err := rdb.Get(ctx, requestHash).Result()
-		if err == nil && cachedPaymentID != "" {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"payment_id": cachedPaymentID,
-			})
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, "FAILED", err)
 			return
 		}
-		paymentID := "pay_" + uuid.NewString()
-		err = rdb.Set(ctx, requestHash, paymentID, 0).Err()
+
+		paymentID, err := issuePaymentKey(paymentKeyFields{
+			MerchantID: merchantIDFromContext(r.Context()),
+			OrderID:    req.Id,
+			Amount:     req.Amount,
+			Currency:   req.Currency,
+			UserID:     req.UserID,
+			TestMode:   testModeEnabled(r.Context()),
+		})
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -106,21 +113,28 @@ func PaymentKey(w http.ResponseWriter, r *http.Request) {
 		}
 		defer r.Body.Close()
 		type CheckRequest struct {
-			Id     string `json:"id"`
-			Amount int    `json:"amount"`
+			Id       string `json:"id" validate:"required"`
+			Amount   int    `json:"amount" validate:"required,gt=0"`
+			Currency string `json:"currency" validate:"omitempty,len=3"`
+			UserID   string `json:"user_id"`
 		}
 		var req CheckRequest
-		err = json.Unmarshal(body, &req)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		if err := decodeStrict(bytes.NewReader(body), &req); err != nil {
+			writeRequestError(w, "FAILED", err)
 			return
 		}
-		hashData := map[string]interface{}{
-			"id":     req.Id,
-			"amount": req.Amount,
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, "FAILED", err)
+			return
 		}
-		hashJSON, _ := json.Marshal(hashData)
-		requestHash := SHA256Hash(string(hashJSON))
+		requestHash := paymentKeyHash(paymentKeyFields{
+			MerchantID: merchantIDFromContext(r.Context()),
+			OrderID:    req.Id,
+			Amount:     req.Amount,
+			Currency:   req.Currency,
+			UserID:     req.UserID,
+			TestMode:   testModeEnabled(r.Context()),
+		})
 		cachedPaymentID, err := rdb.Get(ctx, requestHash).Result()
 		if err != nil {
 			http.Error(w, "Payment key not found", http.StatusNotFound)
@@ -154,6 +168,9 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodPost:
+		if rejectWhileDraining(w) {
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -167,23 +184,35 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer r.Body.Close()
-		type PaymentRequest struct {
-			Id        string `json:"id"`
-			Amount    int    `json:"amount"`
-			PaymentID string `json:"payment_id"`
-			Currency  string `json:"currency"`
-			UserID    string `json:"user_id"`
-		}
-		var req PaymentRequest
-		err = json.Unmarshal(body, &req)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(NewErrorResponse(
-				ErrInvalidRequest,
-				"Invalid JSON format",
-				FAILED.String(),
-				err.Error(),
-			))
+		type paymentBody struct {
+			Id         string     `json:"id" validate:"required"`
+			Amount     int        `json:"amount" validate:"required,gt=0"`
+			PaymentID  string     `json:"payment_id"`
+			Currency   string     `json:"currency" validate:"len=3"`
+			UserID     string     `json:"user_id"`
+			ScheduleAt *time.Time `json:"schedule_at"`
+			Region     string     `json:"region"`
+			Country    string     `json:"country" validate:"omitempty,len=2"`
+			// PaymentToken references card data held in the vault, see POST /tokens
+			PaymentToken string `json:"payment_token"`
+			CardNumber   string `json:"card_number"`
+			// AuthenticationID references a completed customer authentication, e.g. 3-D Secure,
+			// and satisfies a step-up request from risk scoring
+			AuthenticationID string `json:"authentication_id"`
+			// CustomerName is screened against sanctions lists for cross-border payments
+			CustomerName string `json:"customer_name"`
+			// Splits divides the payment between recipients, e.g. a seller and the platform
+			Splits []PaymentSplit `json:"splits"`
+			// PaymentMethodID charges a payment method saved to CustomerID instead of payment_token
+			CustomerID      string `json:"customer_id"`
+			PaymentMethodID string `json:"payment_method_id"`
+			// StatementDescriptor and DescriptorSuffix set what the customer's statement shows
+			StatementDescriptor string `json:"statement_descriptor"`
+			DescriptorSuffix    string `json:"descriptor_suffix"`
+		}
+		var req paymentBody
+		if err := decodeStrict(bytes.NewReader(body), &req); err != nil {
+			writeRequestError(w, FAILED.String(), err)
 			return
 		}
 
@@ -191,6 +220,63 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 		if req.Currency == "" {
 			req.Currency = "USD"
 		}
+		req.Currency = strings.ToUpper(req.Currency)
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, FAILED.String(), err)
+			return
+		}
+		if err := validateAmount(int64(req.Amount), req.Currency); err != nil {
+			writeRequestError(w, FAILED.String(), err)
+			return
+		}
+		if err := validateSplits(req.Splits, int64(req.Amount)); err != nil {
+			writeRequestError(w, FAILED.String(), err)
+			return
+		}
+		if len(req.Splits) > 0 && req.ScheduleAt != nil {
+			writeRequestError(w, FAILED.String(), &ValidationError{Fields: []FieldError{{
+				Field:   "splits",
+				Rule:    "excluded_with",
+				Message: "splits cannot be combined with schedule_at",
+			}}})
+			return
+		}
+		descriptor := StatementDescriptor{Descriptor: req.StatementDescriptor, Suffix: req.DescriptorSuffix}
+		if err := validateStatementDescriptor(&descriptor); err != nil {
+			writeRequestError(w, FAILED.String(), err)
+			return
+		}
+		if !descriptor.IsZero() && req.ScheduleAt != nil {
+			writeRequestError(w, FAILED.String(), &ValidationError{Fields: []FieldError{{
+				Field:   "statement_descriptor",
+				Rule:    "excluded_with",
+				Message: "statement_descriptor and descriptor_suffix cannot be combined with schedule_at",
+			}}})
+			return
+		}
+
+		// With an Idempotency-Key the header guards retries, so the payment key is issued here
+		// rather than by a separate /paymentKey call
+		if req.PaymentID == "" && r.Header.Get("Idempotency-Key") != "" {
+			req.PaymentID, err = issuePaymentKey(paymentKeyFields{
+				MerchantID: merchantIDFromContext(r.Context()),
+				OrderID:    req.Id,
+				Amount:     req.Amount,
+				Currency:   req.Currency,
+				UserID:     req.UserID,
+				TestMode:   testModeEnabled(r.Context()),
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInternalError,
+					"Failed to issue payment ID",
+					FAILED.String(),
+					err.Error(),
+				))
+				return
+			}
+		}
 
 		if req.PaymentID == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -203,13 +289,88 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		hashData := map[string]interface{}{
-			"id":     req.Id,
-			"amount": req.Amount,
+		if req.CardNumber != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrInvalidRequest,
+				"Raw card numbers are not accepted",
+				FAILED.String(),
+				"Tokenize the card via POST /tokens and send payment_token instead",
+			))
+			return
+		}
+
+		merchantID := merchantIDFromContext(r.Context())
+		if req.PaymentMethodID != "" {
+			if req.CustomerID == "" || req.PaymentToken != "" {
+				writeRequestError(w, FAILED.String(), &ValidationError{Fields: []FieldError{{
+					Field:   "payment_method_id",
+					Rule:    "customer",
+					Message: "payment_method_id requires customer_id and cannot be combined with payment_token",
+				}}})
+				return
+			}
+			if dataStore == nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInvalidRequest,
+					"Customers not available",
+					FAILED.String(),
+					"",
+				))
+				return
+			}
+			req.PaymentToken, err = resolvePaymentMethod(merchantID, req.CustomerID, req.PaymentMethodID)
+			if err != nil {
+				w.WriteHeader(customerErrorStatus(err))
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInvalidRequest,
+					"Invalid payment method",
+					FAILED.String(),
+					err.Error(),
+				))
+				return
+			}
+		}
+
+		var card *CardData
+		if req.PaymentToken != "" {
+			if GetVault() == nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInvalidRequest,
+					"Vault not available",
+					FAILED.String(),
+					"",
+				))
+				return
+			}
+			// Scheduled payments only validate the token now and detokenize at dispatch
+			if req.ScheduleAt != nil && req.ScheduleAt.After(time.Now()) {
+				_, err = GetVault().Lookup(merchantID, req.PaymentToken)
+			} else {
+				card, err = GetVault().Detokenize(merchantID, req.PaymentToken)
+			}
+			if err != nil {
+				w.WriteHeader(vaultErrorStatus(err))
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInvalidRequest,
+					"Invalid payment token",
+					FAILED.String(),
+					err.Error(),
+				))
+				return
+			}
 		}
 
-		hashJSON, _ := json.Marshal(hashData)
-		requestHash := SHA256Hash(string(hashJSON))
+		requestHash := paymentKeyHash(paymentKeyFields{
+			MerchantID: merchantID,
+			OrderID:    req.Id,
+			Amount:     req.Amount,
+			Currency:   req.Currency,
+			UserID:     req.UserID,
+			TestMode:   testModeEnabled(r.Context()),
+		})
 
 		cachedPaymentID, err := rdb.Get(ctx, requestHash).Result()
 		if err != nil || cachedPaymentID == "" {
@@ -223,12 +384,11 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if req.PaymentID != cachedPaymentID {
-			SetState(req.PaymentID, FAILED)
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(NewErrorResponse(
 				ErrPaymentIDMismatch,
 				"Payment ID does not match",
-				GetState(req.PaymentID).String(),
+				FAILED.String(),
 				"The provided payment ID does not match the cached value",
 			))
 			return
@@ -237,22 +397,18 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 		currentState := GetState(req.PaymentID)
 
 		if currentState == SUCCESS || currentState == FAILED {
+			cachedResult, _ := rdb.Get(ctx, paymentResultKey(req.PaymentID)).Result()
+			replayPaymentResult(w, req.PaymentID, currentState.String(), cachedResult)
+			return
+		}
 
-			cachedResult, err := rdb.Get(ctx, "payment_result:"+req.PaymentID).Result()
-			if err == nil && cachedResult != "" {
-				w.Header().Set("X-Idempotent-Replay", "true")
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte(cachedResult))
-				return
-			}
-
-			w.Header().Set("X-Idempotent-Replay", "true")
-			json.NewEncoder(w).Encode(NewSuccessResponse(
+		if currentState == REVIEW {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrComplianceFailed,
+				"Payment is held for compliance review",
 				currentState.String(),
-				req.PaymentID,
-				map[string]interface{}{
-					"message": "Payment already processed",
-				},
+				"The payment was authorized and is waiting for a compliance decision",
 			))
 			return
 		}
@@ -269,12 +425,18 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check if compliance check is required
-		if int64(req.Amount) >= ComplianceThreshold && req.UserID != "" {
-			appLogger.Info("High-value transaction detected, performing compliance check", map[string]interface{}{
+		var complianceCheckID string
+		var complianceReasons []string
+		if req.UserID != "" {
+			complianceReasons = complianceRules.RequiresCheck(ctx, paymentNamespace(req.PaymentID), merchantID, req.Currency, req.UserID, int64(req.Amount))
+		}
+		if len(complianceReasons) > 0 {
+			appLogger.Info("Compliance rule triggered, performing compliance check", map[string]interface{}{
 				"correlation_id": correlationID,
 				"payment_id":     req.PaymentID,
 				"amount":         req.Amount,
 				"user_id":        req.UserID,
+				"rules":          complianceReasons,
 			})
 
 			// Perform compliance check
@@ -284,9 +446,8 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 				IdempotencyKey: req.PaymentID + "_kyc",
 			}
 
-			complianceResp, err := providerRegistry.PerformComplianceCheck(ctx, complianceReq)
+			complianceResp, cached, err := checkCompliance(ctx, paymentNamespace(req.PaymentID), complianceReq)
 			if err != nil || (complianceResp != nil && complianceResp.Status != ComplianceStatusApproved) {
-				SetState(req.PaymentID, FAILED)
 				w.WriteHeader(http.StatusForbidden)
 				json.NewEncoder(w).Encode(NewErrorResponse(
 					ErrKYCRequired,
@@ -297,32 +458,397 @@ func Payment(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			complianceCheckID = complianceResp.CheckID
 			appLogger.Info("Compliance check passed", map[string]interface{}{
 				"correlation_id": correlationID,
 				"payment_id":     req.PaymentID,
 				"check_id":       complianceResp.CheckID,
+				"cached":         cached,
 			})
 		}
 
-		SetState(req.PaymentID, INITIATED)
-		SetState(req.PaymentID, PROCESSING)
+		if req.ScheduleAt != nil && req.ScheduleAt.After(time.Now()) {
+			err := SchedulePayment(&ScheduledPayment{
+				PaymentID:    req.PaymentID,
+				OrderID:      req.Id,
+				Amount:       int64(req.Amount),
+				Currency:     req.Currency,
+				UserID:       req.UserID,
+				MerchantID:   merchantID,
+				PaymentToken: req.PaymentToken,
+				ScheduleAt:   req.ScheduleAt.UTC(),
+			})
+			if err != nil {
+				if dataStore == nil {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				} else {
+					w.WriteHeader(http.StatusConflict)
+				}
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrInvalidRequest,
+					"Failed to schedule payment",
+					FAILED.String(),
+					err.Error(),
+				))
+				return
+			}
 
-		json.NewEncoder(w).Encode(NewSuccessResponse(
-			PROCESSING.String(),
-			req.PaymentID,
-			map[string]interface{}{
-				"message": "Payment processing started",
-			},
-		))
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(NewSuccessResponse(
+				scheduledPaymentStatus,
+				req.PaymentID,
+				map[string]interface{}{
+					"message":     "Payment scheduled",
+					"schedule_at": req.ScheduleAt.UTC(),
+				},
+			))
+			return
+		}
 
-		go processPaymentAsync(req.Id, req.Amount, req.PaymentID, req.Currency, correlationID)
+		var fraudDecision *FraudDecision
+		if fraudEngine != nil {
+			fraudDecision = fraudEngine.Evaluate(ctx, req.PaymentID, req.UserID, req.PaymentToken, int64(req.Amount))
+			if len(fraudDecision.Hits) > 0 {
+				appLogger.Warn("Fraud rules matched", map[string]interface{}{
+					"operation":      "fraud_check",
+					"correlation_id": correlationID,
+					"payment_id":     req.PaymentID,
+					"action":         fraudDecision.Action,
+					"hits":           fraudDecision.Hits,
+				})
+			}
+		}
+
+		var riskScore *RiskScore
+		if riskScorer != nil {
+			riskScore, err = riskScorer.Score(ctx, &RiskInput{
+				PaymentID: req.PaymentID,
+				UserID:    req.UserID,
+				Amount:    int64(req.Amount),
+				Currency:  req.Currency,
+				Country:   req.Country,
+				IPAddress: getClientIP(r),
+			})
+			if err != nil {
+				log.Printf("[Risk] Scoring failed for %s: %v", req.PaymentID, err)
+			} else {
+				action := riskConfig.Action(riskScore.Score)
+				appLogger.Info("Payment risk scored", map[string]interface{}{
+					"operation":      "risk_score",
+					"correlation_id": correlationID,
+					"payment_id":     req.PaymentID,
+					"score":          riskScore.Score,
+					"factors":        riskScore.Factors,
+					"action":         action,
+				})
+				if action == RiskActionStepUp && req.AuthenticationID == "" {
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(NewErrorResponse(
+						ErrStepUpRequired,
+						"Customer authentication is required",
+						INITIATED.String(),
+						riskScore.Reason()+"; authenticate the customer and resubmit with authentication_id",
+					))
+					return
+				}
+			}
+		}
+
+		var sanctionsDecision *SanctionsDecision
+		if sanctionsScreener != nil && sanctionsScreener.NeedsPaymentScreening(req.Country, int64(req.Amount)) {
+			sanctionsDecision, err = sanctionsScreener.Screen(ctx, "payment", &ScreeningRequest{
+				Reference: req.PaymentID,
+				Name:      req.CustomerName,
+				Country:   req.Country,
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrComplianceFailed,
+					"Sanctions screening unavailable",
+					INITIATED.String(),
+					err.Error(),
+				))
+				return
+			}
+			if sanctionsDecision.Blocked() {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(NewErrorResponse(
+					ErrComplianceFailed,
+					"Payment blocked by sanctions screening",
+					FAILED.String(),
+					sanctionsDecision.Reason(),
+				))
+				return
+			}
+		}
+
+		lock, err := startPayment(req.Id, req.Amount, req.PaymentID, req.Currency, req.UserID, merchantID, correlationID, false)
+		var finished *PaymentFinishedError
+		if errors.As(err, &finished) {
+			replayPaymentResult(w, req.PaymentID, finished.Status, finished.Result)
+			return
+		}
+		if err != nil {
+			status, message := http.StatusConflict, "Payment is currently being processed"
+			if !errors.Is(err, ErrPaymentLocked) && !errors.Is(err, INVALID_STATE_CHANGE_REQUEST) {
+				status, message = http.StatusServiceUnavailable, "Payment could not be locked for processing"
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrInternalError,
+				message,
+				GetState(req.PaymentID).String(),
+				err.Error(),
+			))
+			return
+		}
+		if len(req.Splits) > 0 {
+			if err := UpdatePaymentRecord(req.PaymentID, func(record *PaymentRecord) {
+				record.Splits = req.Splits
+			}); err != nil {
+				log.Printf("Failed to save splits for %s: %v", req.PaymentID, err)
+			}
+		}
+
+		if fraudDecision != nil && fraudDecision.Action == FraudActionDecline {
+			reason := fraudDecision.Reason()
+			if _, err := SetStateWithReason(req.PaymentID, FAILED, "declined, "+reason, "fraud"); err != nil {
+				log.Printf("Could not record fraud decline for %s: %v", req.PaymentID, err)
+			}
+			declined := NewErrorResponse(ErrFraudDeclined, "Payment declined by fraud rules", FAILED.String(), reason)
+			CompletePayment(req.PaymentID, declined, func(record *PaymentRecord) {
+				record.Status = FAILED.String()
+				record.ErrorCode = string(ErrFraudDeclined)
+				record.ErrorMessage = reason
+			})
+			lock.Release()
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(declined)
+			return
+		}
+
+		metadata := map[string]interface{}{}
+		if complianceCheckID != "" {
+			metadata["compliance_check_id"] = complianceCheckID
+		}
+		if fraudDecision != nil && len(fraudDecision.Hits) > 0 {
+			metadata["fraud"] = fraudDecision
+		}
+		if riskScore != nil {
+			metadata["risk"] = riskScore
+		}
+		if sanctionsDecision != nil {
+			metadata["sanctions"] = sanctionsDecision
+		}
+		if req.AuthenticationID != "" {
+			metadata["authentication_id"] = req.AuthenticationID
+		}
+		if req.CustomerID != "" {
+			metadata["customer_id"] = req.CustomerID
+		}
+		if req.PaymentMethodID != "" {
+			metadata["payment_method_id"] = req.PaymentMethodID
+		}
+
+		data := map[string]interface{}{
+			"message":  "Payment processing started",
+			"metadata": metadata,
+		}
+		if len(req.Splits) > 0 {
+			data["splits"] = splitSettlements(req.Splits)
+		}
+		json.NewEncoder(w).Encode(NewSuccessResponse(PROCESSING.String(), req.PaymentID, data))
+
+		paymentReq := &PaymentRequest{
+			ID:                  req.Id,
+			Amount:              int64(req.Amount),
+			Currency:            req.Currency,
+			UserID:              req.UserID,
+			Region:              req.Region,
+			Country:             req.Country,
+			PaymentToken:        req.PaymentToken,
+			Metadata:            metadata,
+			Hedge:               hedgingEnabled(r.Context()),
+			Splits:              req.Splits,
+			TestMode:            isTestPayment(req.PaymentID),
+			Providers:           merchantProviderPreferences(merchantID),
+			DeclinePolicy:       merchantDeclinePolicy(merchantID),
+			StatementDescriptor: descriptor.Descriptor,
+			DescriptorSuffix:    descriptor.Suffix,
+		}
+		if amlScreener != nil {
+			if triggers := amlScreener.Triggers(ctx, req.PaymentID, req.UserID, paymentReq.Amount, req.Country); len(triggers) > 0 {
+				amlScreener.Start(req.PaymentID, correlationID, paymentReq, triggers)
+			}
+		}
+		go processPaymentAsync(paymentReq, req.PaymentID, correlationID, card, lock)
 		return
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func processPaymentAsync(id string, amount int, paymentID, currency, correlationID string) {
+// paymentKeyFields is the canonical input of a payment key. The struct's field order
+// is fixed, so equal requests always hash the same
+type paymentKeyFields struct {
+	MerchantID string `json:"merchant_id"`
+	OrderID    string `json:"id"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+	UserID     string `json:"user_id"`
+	// TestMode keys get their own payment IDs; omitted for live keys so their hashes are unchanged
+	TestMode bool `json:"test_mode,omitempty"`
+}
+
+// paymentKeyHash returns the Redis key holding the payment ID for a request. Keys are
+// namespaced per merchant so tenants reusing an order ID never share a payment
+func paymentKeyHash(fields paymentKeyFields) string {
+	fields.Currency = strings.ToUpper(fields.Currency)
+	if fields.Currency == "" {
+		fields.Currency = "USD"
+	}
+	data, _ := json.Marshal(fields)
+	namespace := ""
+	if fields.TestMode {
+		namespace = testRedisNamespace
+	}
+	return namespace + "payment_key:" + fields.MerchantID + ":" + SHA256Hash(string(data))
+}
+
+// issuePaymentKey returns the payment ID bound to a request, creating one if needed
+func issuePaymentKey(fields paymentKeyFields) (string, error) {
+	key := paymentKeyHash(fields)
+	paymentID := "pay_" + uuid.NewString()
+	if fields.TestMode {
+		paymentID = testPaymentPrefix + uuid.NewString()
+	}
+	created, err := rdb.SetNX(ctx, key, paymentID, 0).Result()
+	if err != nil {
+		return "", err
+	}
+	if created {
+		return paymentID, nil
+	}
+	return rdb.Get(ctx, key).Result()
+}
+
+// PaymentFinishedError is returned by startPayment for a payment that already has an
+// outcome. Result is its cached response, empty once that has expired
+type PaymentFinishedError struct {
+	PaymentID string
+	Status    string
+	Result    string
+}
+
+func (e *PaymentFinishedError) Error() string {
+	return fmt.Sprintf("payment %s is already %s", e.PaymentID, e.Status)
+}
+
+// finishedPayment looks up a payment's outcome in Redis, which every instance shares,
+// rather than in this instance's state store. A FAILED payment only counts as finished
+// when it may not be retried
+func finishedPayment(paymentID string, retryFailed bool) (*PaymentFinishedError, error) {
+	result, err := rdb.Get(ctx, paymentResultKey(paymentID)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	status := ""
+	record, err := GetPaymentRecord(paymentID)
+	switch {
+	case err == nil:
+		status = record.Status
+	case err != redis.Nil:
+		return nil, err
+	case result != "":
+		var cached struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal([]byte(result), &cached)
+		status = cached.Status
+	}
+
+	switch status {
+	case SUCCESS.String(), REVIEW.String(), CANCELLED.String():
+	case FAILED.String():
+		if retryFailed {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return &PaymentFinishedError{PaymentID: paymentID, Status: status, Result: result}, nil
+}
+
+// replayPaymentResult answers a repeated request for a finished payment with its result
+func replayPaymentResult(w http.ResponseWriter, paymentID, status, result string) {
+	w.Header().Set("X-Idempotent-Replay", "true")
+	if result != "" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(result))
+		return
+	}
+	json.NewEncoder(w).Encode(NewSuccessResponse(
+		status,
+		paymentID,
+		map[string]interface{}{
+			"message": "Payment already processed",
+		},
+	))
+}
+
+// startPayment locks a payment, moves it into PROCESSING and records it before it is
+// routed. It fails when the payment can't be (re)started, e.g. because it is already
+// processing here or on another instance, and the caller must not route it then. A
+// payment that already has an outcome fails with *PaymentFinishedError; retryFailed
+// lets a FAILED payment be routed again. Otherwise the caller owns the returned lock
+// and hands it to processPaymentAsync
+func startPayment(id string, amount int, paymentID, currency, userID, merchantID, correlationID string, retryFailed bool) (*PaymentLock, error) {
+	lock, err := acquirePaymentLock(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	// Another instance may have finished the payment between the caller's state check
+	// and taking the lock, so look again now that nobody else can route it
+	finished, err := finishedPayment(paymentID, retryFailed)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if finished != nil {
+		lock.Release()
+		return nil, finished
+	}
+	if _, _, exists := paymentStates.Get(paymentID); !exists {
+		if _, err := SetStateWithReason(paymentID, INITIATED, "payment received", correlationID); err != nil {
+			lock.Release()
+			return nil, err
+		}
+	}
+	if _, err := SetStateWithReason(paymentID, PROCESSING, "routing to gateways", correlationID); err != nil {
+		lock.Release()
+		return nil, err
+	}
+
+	if err := UpdatePaymentRecord(paymentID, func(record *PaymentRecord) {
+		record.OrderID = id
+		record.Status = PROCESSING.String()
+		record.Amount = int64(amount)
+		record.Currency = currency
+		record.UserID = userID
+		record.MerchantID = merchantID
+	}); err != nil {
+		log.Printf("Failed to save payment record for %s: %v", paymentID, err)
+	}
+	return lock, nil
+}
+
+// processPaymentAsync routes a payment to the gateways under the lock taken by
+// startPayment, and releases it when done. card is the detokenized card for
+// token-based payments and is only ever sent to the gateway, never stored
+func processPaymentAsync(req *PaymentRequest, paymentID, correlationID string, card *CardData, lock *PaymentLock) {
+	defer lock.Release()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Panic in processPaymentAsync for %s: %v", paymentID, r)
@@ -330,164 +856,254 @@ func processPaymentAsync(id string, amount int, paymentID, currency, correlation
 		}
 	}()
 
+	id, amount, currency := req.ID, int(req.Amount), req.Currency
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = paymentID
+	}
+	if card != nil && req.BIN == "" && len(card.Number) >= 6 {
+		req.BIN = card.Number[:6]
+	}
+
 	paymentData := map[string]interface{}{
 		"id":       id,
 		"amount":   amount,
 		"currency": currency,
 	}
+	if card != nil {
+		paymentData["card"] = card
+	}
 	jsonData, err := json.Marshal(paymentData)
 	if err != nil {
-		SetState(paymentID, FAILED)
+		SetStateWithReason(paymentID, FAILED, "failed to encode payment", correlationID)
 		notifyClient(paymentID, FAILED, nil)
 		return
 	}
 
-	// Try legacy serverPool first (for backward compatibility during transition)
-	maxRetries := serverPool.GetServerCount()
-	if maxRetries == 0 {
-		SetState(paymentID, FAILED)
+	candidates := paymentCandidates(req, paymentID, correlationID)
+	if len(candidates) == 0 {
+		SetStateWithReason(paymentID, FAILED, "no healthy servers", correlationID)
 		notifyClient(paymentID, FAILED, fmt.Errorf("no healthy servers"))
 		return
 	}
+	maxAttempts := len(candidates)
+	if maxAttempts > paymentMaxAttempts {
+		maxAttempts = paymentMaxAttempts
+	}
+
+	// The whole cascade shares one deadline so a slow provider can't eat the budget
+	// of the ones behind it
+	traceCtx := withFencingToken(withTraceIDs(context.Background(), correlationID, paymentID), lock)
+	traceCtx = withProviderOverrides(traceCtx, req.Providers)
+	traceCtx = withStatementDescriptor(traceCtx, StatementDescriptor{Descriptor: req.StatementDescriptor, Suffix: req.DescriptorSuffix})
+	if req.TestMode {
+		traceCtx = withTestMode(traceCtx)
+	}
+	budgetCtx, cancelBudget := context.WithTimeout(traceCtx, paymentTimeBudget)
+	defer cancelBudget()
 
 	var lastError error
+	var lastErrorMsg string
+	var lastDeclineCode string
 	var selectedServer *ServerMetrics
-	var response *http.Response
 	var latency time.Duration
-	var responseBody []byte
-	var dat map[string]interface{}
-
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		selectedServer, err = serverPool.SelectServer()
-		if err != nil {
-			lastError = err
+	succeeded := false
+	pending := false
+	hedged := false
+	retriedLater := false
+	gatewayAttempts := 0
+	tried := make(map[*ServerMetrics]bool)
+	providersTried := make([]string, 0, maxAttempts)
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if budgetCtx.Err() != nil {
+			lastError = fmt.Errorf("retry budget exhausted after %d attempts", attempt)
 			break
 		}
 
-		startTime := time.Now()
-		gatewayURL := selectedServer.ServerURL
-
-		appLogger.Info("Routing payment to gateway", map[string]interface{}{
-			"correlation_id": correlationID,
-			"payment_id":     paymentID,
-			"gateway":        gatewayURL,
-			"attempt":        attempt + 1,
-		})
-
-		response, err = http.Post(gatewayURL, "application/json", bytes.NewBuffer(jsonData))
-		latency = time.Since(startTime)
-
-		if err != nil {
-			errorType := ErrorTypeNetwork
-			serverPool.RecordRequestResult(paymentID, selectedServer.ServerURL, latency, false, &errorType, err.Error())
-
-			appLogger.Error("Gateway request failed", map[string]interface{}{
-				"correlation_id": correlationID,
-				"payment_id":     paymentID,
-				"gateway":        gatewayURL,
-				"error":          err.Error(),
-				"latency_ms":     latency.Milliseconds(),
-			})
-
-			lastError = err
-			continue
+		selectedServer = nil
+		for _, server := range candidates {
+			if !tried[server] {
+				selectedServer = server
+				break
+			}
 		}
-		responseBody, err = io.ReadAll(response.Body)
-		response.Body.Close()
-
-		if err != nil {
-			errorType := ErrorTypeGateway
-			serverPool.RecordRequestResult(paymentID, selectedServer.ServerURL, latency, false, &errorType, "Failed to read response body")
-			lastError = err
-			continue
+		if selectedServer == nil {
+			break
 		}
+		tried[selectedServer] = true
 
-		dat = make(map[string]interface{})
-		if err := json.Unmarshal(responseBody, &dat); err != nil {
-			errorType := ErrorTypeGateway
-			serverPool.RecordRequestResult(paymentID, selectedServer.ServerURL, latency, false, &errorType, "Invalid JSON response")
-			lastError = err
+		// Failing over is a retry, and retries to a provider are capped by its retry budget
+		budget := retryBudgets.Get(gatewayName(selectedServer.ServerURL))
+		if attempt > 0 && !budget.TryRetry() {
+			lastError = fmt.Errorf("retry budget exhausted for %s", gatewayName(selectedServer.ServerURL))
 			continue
 		}
+		budget.RecordRequest()
+		providersTried = append(providersTried, gatewayName(selectedServer.ServerURL))
+
+		var result *gatewayResult
+		if attempt == 0 && req.Hedge {
+			if secondary := hedgeSecondary(candidates, tried); secondary != nil {
+				result, hedged = attemptHedged(budgetCtx, selectedServer, secondary, jsonData, paymentID, correlationID, attempt)
+				if hedged {
+					retryBudgets.Get(gatewayName(secondary.ServerURL)).RecordRequest()
+					tried[secondary] = true
+					providersTried = append(providersTried, gatewayName(secondary.ServerURL))
+				}
+			}
+		}
+		if result == nil {
+			result = attemptGateway(budgetCtx, selectedServer, jsonData, paymentID, correlationID, attempt)
+		}
+		gatewayAttempts++
+
+		// Retry the same provider as far as its retry policy allows before failing over
+		name := gatewayName(result.server.ServerURL)
+		policy := retryPolicyFor(name)
+		strategy := NewBudgetedRetryStrategy(policy, retryBudgets.Get(name))
+		for providerAttempts := 1; !result.success && result.retryable; providerAttempts++ {
+			// A rate-limited provider is only retried if it asks for a short enough wait,
+			// otherwise fail over rather than hammer it
+			if result.rateLimited && result.retryAfter > policy.MaxDelay {
+				break
+			}
+			decision := strategy.ShouldRetry(result.err, result.statusCode, providerAttempts, nil)
+			if !decision.ShouldRetry {
+				break
+			}
+			if result.retryAfter > decision.Backoff {
+				decision.Backoff = result.retryAfter
+			}
+			if deadline, ok := budgetCtx.Deadline(); ok && time.Now().Add(decision.Backoff).After(deadline) {
+				break
+			}
+			select {
+			case <-time.After(decision.Backoff):
+			case <-budgetCtx.Done():
+			}
+			if budgetCtx.Err() != nil {
+				break
+			}
+			result = attemptGateway(budgetCtx, result.server, jsonData, paymentID, correlationID, attempt)
+			gatewayAttempts++
+		}
 
-		var success bool
-		var errorType *ErrorType
-
-		if responseStatus, ok := dat["status"].(string); ok {
-			if responseStatus == "success" {
-				SetState(paymentID, SUCCESS)
-				success = true
-
-				appLogger.Info("Payment successful", map[string]interface{}{
+		// Declines the decline policy says may pass later get one more try on the same
+		// provider; those that may pass elsewhere fail over, see decline_policy.go
+		action := declineAction(req, result)
+		if action == DeclineRetryLater && !retriedLater {
+			retriedLater = true
+			if waitForDeclineRetry(budgetCtx, result) {
+				appLogger.Info("Retrying declined payment on the same provider", map[string]interface{}{
 					"correlation_id": correlationID,
 					"payment_id":     paymentID,
-					"gateway":        gatewayURL,
-					"latency_ms":     latency.Milliseconds(),
+					"gateway":        result.server.ServerURL,
+					"decline_code":   result.declineCode,
 				})
-			} else {
-				SetState(paymentID, FAILED)
-				success = false
-				if response.StatusCode >= 500 {
-					et := ErrorTypeGateway
-					errorType = &et
-				} else {
-					et := ErrorTypeBank
-					errorType = &et
-				}
+				retryCtx := withIdempotencyKey(budgetCtx, declineRetryIdempotencyKey(paymentID))
+				result = attemptGateway(retryCtx, result.server, jsonData, paymentID, correlationID, attempt)
+				gatewayAttempts++
+				action = declineAction(req, result)
 			}
-		} else {
-			SetState(paymentID, FAILED)
-			success = false
-			et := ErrorTypeGateway
-			errorType = &et
 		}
-
-		errorMsg := ""
-		if !success && errorType != nil {
-			if errMsgVal, ok := dat["error"].(string); ok {
-				errorMsg = errMsgVal
-			}
+		if action == DeclineRetryAlternate {
+			appLogger.Info("Failing over declined payment", map[string]interface{}{
+				"correlation_id": correlationID,
+				"payment_id":     paymentID,
+				"gateway":        result.server.ServerURL,
+				"decline_code":   result.declineCode,
+			})
 		}
-		serverPool.RecordRequestResult(paymentID, selectedServer.ServerURL, latency, success, errorType, errorMsg)
 
-		responseStatus, ok := dat["status"].(string)
-		if success || (ok && responseStatus == "failed") {
+		selectedServer = result.server
+		latency = result.latency
+		if result.err != nil {
+			lastError = result.err
+		}
+		if result.errorMsg != "" {
+			lastErrorMsg = result.errorMsg
+		}
+		lastDeclineCode = result.declineCode
+		if result.success {
+			succeeded = true
 			break
 		}
-
-		if response != nil && response.StatusCode >= 500 {
-			continue
+		if result.pending {
+			pending = true
+			break
+		}
+		if !result.retryable && action != DeclineRetryAlternate {
+			break
 		}
-		break
 	}
 
-	if GetState(paymentID) != SUCCESS && GetState(paymentID) != FAILED {
-		SetState(paymentID, FAILED)
+	if pending {
+		awaitCallback(req, paymentID, correlationID, selectedServer, latency)
+		return
 	}
 
-	finalStatus := GetState(paymentID)
-	paymentResponse := NewSuccessResponse(
-		finalStatus.String(),
-		paymentID,
-		map[string]interface{}{
-			"gateway":    nil,
-			"latency_ms": latency.Milliseconds(),
-		},
-	)
-
-	if selectedServer != nil {
-		paymentResponse.Data = map[string]interface{}{
-			"gateway":    selectedServer.ServerURL,
-			"latency_ms": latency.Milliseconds(),
+	finalState, reason := FAILED, fmt.Sprintf("failed after %d gateway attempts", gatewayAttempts)
+	if lastErrorMsg != "" {
+		reason += ": " + lastErrorMsg
+	}
+	if succeeded {
+		finalState, reason = SUCCESS, "approved by "+gatewayName(selectedServer.ServerURL)
+		if hold, why := reviewHold(req, paymentID); hold {
+			finalState, reason = REVIEW, why
 		}
+	} else if amlScreener != nil {
+		amlScreener.Discard(paymentID)
+	}
+	// Another instance that took the payment over after our lock expired owns the outcome
+	if !lock.Held(ctx) {
+		log.Printf("Not recording %s for %s: fencing token %d was superseded", finalState, paymentID, lock.Token)
+		return
+	}
+	if _, err := SetStateWithReason(paymentID, finalState, reason, correlationID); err != nil {
+		// e.g. cancelled while routing; the state it is in now is reported instead
+		log.Printf("Could not record final state for %s: %v", paymentID, err)
 	}
 
-	responseJSON, jsonErr := json.Marshal(paymentResponse)
-	if jsonErr == nil {
-		rdb.Set(ctx, "payment_result:"+paymentID, string(responseJSON), 24*time.Hour)
+	finalStatus := GetState(paymentID)
+	data := map[string]interface{}{
+		"gateway":         nil,
+		"latency_ms":      latency.Milliseconds(),
+		"providers_tried": providersTried,
+		"attempts":        gatewayAttempts,
+		"hedged":          hedged,
+	}
+	if selectedServer != nil {
+		data["gateway"] = selectedServer.ServerURL
 	}
+	if len(req.Splits) > 0 && finalStatus == SUCCESS {
+		data["splits"] = splitSettlements(req.Splits)
+	}
+	if finalStatus == FAILED && lastDeclineCode != "" {
+		data["decline_code"] = lastDeclineCode
+	}
+	paymentResponse := NewSuccessResponse(finalStatus.String(), paymentID, data)
 
-	wsManager.Notify(paymentID, paymentResponse)
+	record := CompletePayment(paymentID, paymentResponse, func(record *PaymentRecord) {
+		record.Status = finalStatus.String()
+		record.LatencyMs = latency.Milliseconds()
+		if selectedServer != nil {
+			record.Provider = gatewayName(selectedServer.ServerURL)
+		}
+		if finalStatus == REVIEW {
+			record.ErrorMessage = reason
+		}
+		if finalStatus == FAILED {
+			record.ErrorCode = string(ErrProviderError)
+			record.ErrorMessage = lastErrorMsg
+			if record.ErrorMessage == "" && lastError != nil {
+				record.ErrorMessage = lastError.Error()
+			}
+		}
+	})
+	// Test-mode payments never move money, so they stay out of the ledger
+	if record != nil && finalStatus == SUCCESS && !req.TestMode {
+		recordCapturedPayment(record)
+	}
 
 	if finalStatus == FAILED && lastError != nil {
 		log.Printf("Background process for %s failed after all retries. Last error: %v", paymentID, lastError)
@@ -496,17 +1112,120 @@ func processPaymentAsync(id string, amount int, paymentID, currency, correlation
 	}
 }
 
+// reviewHold reports whether an approved payment must be held for review by AML,
+// fraud, risk or sanctions screening, and why
+func reviewHold(req *PaymentRequest, paymentID string) (bool, string) {
+	if amlScreener != nil {
+		if hold, why := amlScreener.Verdict(paymentID); hold {
+			return true, why
+		}
+	}
+	if hold, why := fraudReviewReason(req); hold {
+		return true, why
+	}
+	if hold, why := riskReviewReason(req); hold {
+		return true, why
+	}
+	return sanctionsReviewReason(req)
+}
+
+// retryPolicyFor returns the retry policy for a gateway. Gateways that aren't
+// registered providers get a single attempt and are failed over immediately
+func retryPolicyFor(name string) RetryConfig {
+	if !providerRegistry.HasPaymentProvider(name) {
+		return RetryConfig{MaxAttempts: 1}
+	}
+	return providerRegistry.GetRetryPolicy(name)
+}
+
+// paymentCandidates returns the gateways to try for a payment in failover order: the
+// providers ranked by routing rules and strategy first, then the remaining pool
+// servers by score
+func paymentCandidates(req *PaymentRequest, paymentID, correlationID string) []*ServerMetrics {
+	if req.TestMode {
+		return sandboxCandidates(req)
+	}
+	candidates := make([]*ServerMetrics, 0)
+	seen := make(map[*ServerMetrics]bool)
+
+	if providerSelector != nil {
+		ranked, _, err := providerSelector.RankProviders(ctx, req)
+		if err != nil {
+			log.Printf("[Routing] No provider ranked for %s, using pool order: %v", paymentID, err)
+		}
+		// SelectProvider also records affinity, so it decides the head of the cascade
+		if selected, err := providerSelector.SelectProvider(ctx, req); err == nil {
+			ranked = moveToFront(ranked, selected)
+			appLogger.Info("Provider selected", map[string]interface{}{
+				"correlation_id": correlationID,
+				"payment_id":     paymentID,
+				"provider":       selected.Provider.Name(),
+				"reason":         providerSelector.GetRoutingReason(selected, req),
+			})
+		}
+
+		for _, config := range ranked {
+			if server, err := serverPool.GetServerByGateway(config.Provider.Name()); err == nil && !seen[server] && server.IsHealthy() {
+				candidates = append(candidates, server)
+				seen[server] = true
+			}
+		}
+	}
+
+	byScore := serverPool.GetServersForSelection(req.Currency, req.Region)
+	for _, server := range byScore {
+		name := gatewayName(server.ServerURL)
+		if !seen[server] && server.IsHealthy() && !circuitOpen(name) && !inMaintenance(name) && !req.Providers.Excludes(name) {
+			candidates = append(candidates, server)
+			seen[server] = true
+		}
+	}
+
+	// Probes can be wrong, a server failing them is still better than no server at all.
+	// A server in maintenance is known to be down and one the merchant excludes must never
+	// be used, so they stay out
+	if len(candidates) == 0 {
+		for _, server := range byScore {
+			name := gatewayName(server.ServerURL)
+			if !circuitOpen(name) && !inMaintenance(name) && !req.Providers.Excludes(name) {
+				candidates = append(candidates, server)
+			}
+		}
+	}
+	return candidates
+}
+
+// circuitOpen reports whether a gateway is a registered provider whose circuit is OPEN
+func circuitOpen(name string) bool {
+	config, err := providerRegistry.GetPaymentProvider(name)
+	if err != nil || config.CircuitBreaker == nil {
+		return false
+	}
+	return config.CircuitBreaker.GetState() == StateOpen
+}
+
 func notifyClient(paymentID string, state State, err error) {
 	msg := NewErrorResponse(ErrInternalError, "Payment failed", state.String(), "")
 	if err != nil {
 		msg.Details = err.Error()
 	}
 
-	if responseJSON, jsonErr := json.Marshal(msg); jsonErr == nil {
-		rdb.Set(ctx, "payment_result:"+paymentID, string(responseJSON), 24*time.Hour)
-	}
+	CompletePayment(paymentID, msg, func(record *PaymentRecord) {
+		record.Status = state.String()
+		record.ErrorCode = string(msg.ErrorCode)
+		record.ErrorMessage = msg.Details
+	})
+}
 
-	wsManager.Notify(paymentID, msg)
+// requestLatencyMetrics returns the server-wide request latency percentiles in milliseconds
+func requestLatencyMetrics() map[string]interface{} {
+	percentiles := requestLatencyTracker.GetPercentiles()
+	return map[string]interface{}{
+		"p50_ms":  percentiles.P50.Milliseconds(),
+		"p95_ms":  percentiles.P95.Milliseconds(),
+		"p99_ms":  percentiles.P99.Milliseconds(),
+		"samples": requestLatencyTracker.GetSampleCount(),
+	}
 }
 
 func MetricsHandler(w http.ResponseWriter, r *http.Request) {
@@ -521,28 +1240,21 @@ func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 		"servers":           serverPool.GetAllServersStatus(),
 		"server_count":      serverPool.GetServerCount(),
 		"provider_registry": providerRegistry.GetAllProviderStatus(),
+		"retry_budgets":     retryBudgets.GetAllStats(),
+		"bulkheads":         providerRegistry.GetBulkheadStats(),
+		"load_shedding":     GetLoadShedder().GetStats(),
+		"request_latency":   requestLatencyMetrics(),
+		"routes":            routeMetrics.GetStats(),
+		"health_probes":     healthProber.GetResults(),
+		"websocket_clients": wsManager.ConnectionCount(),
+		"payment_states":    paymentStates.GetStats(),
+		"runtime":           runtimeMetrics(),
 		"timestamp":         time.Now().Format(time.RFC3339),
 	}
 
 	json.NewEncoder(w).Encode(metrics)
 }
 
-func LogsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	logs, err := GetLogs()
-	if err != nil {
-		http.Error(w, "Failed to fetch logs", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
-}
-
 func main() {
 	err := godotenv.Load()
 	if err != nil {
@@ -551,9 +1263,47 @@ func main() {
 
 	ctx = context.Background()
 
+	// Package-level initializers run before .env is loaded, so read the secret again
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		log.Println("[AdminAuth] JWT_SECRET is not set, admin routes will be unavailable")
+	}
+	adminRoles, err = AdminRolesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_ROLES: %v", err)
+	}
+	minSignatureVersion, err = SignatureMinVersionFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid signature config: %v", err)
+	}
+	if err := ValidateOpenAPISpec(); err != nil {
+		log.Fatalf("Invalid OpenAPI spec: %v", err)
+	}
+
 	// Initialize structured logger
-	InitLogger(LogLevelInfo, true)
+	logLevel, err := LogLevelFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid LOG_LEVEL: %v", err)
+	}
+	InitLogger(logLevel, true)
 	appLogger = GetLogger()
+	sampleRates, defaultSampleRate, err := LogSampleRatesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid log sampling config: %v", err)
+	}
+	if err := appLogger.Sampler().SetRates(sampleRates, defaultSampleRate); err != nil {
+		log.Fatalf("Invalid log sampling config: %v", err)
+	}
+	logSinkConfig, err := LogSinkConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid log sink config: %v", err)
+	}
+	logSinks, err := NewLogSinks(logSinkConfig)
+	if err != nil {
+		log.Fatalf("Failed to open log sinks: %v", err)
+	}
+	appLogger.SetSinks(logSinks)
+	defer appLogger.Close()
 	appLogger.Info("Starting FinTech Integration Mesh", map[string]interface{}{
 		"version": "2.0.0-mvp",
 	})
@@ -566,6 +1316,29 @@ func main() {
 		DB:       0,
 	})
 
+	// Fan WebSocket notifications out across instances via Redis pub/sub
+	wsManager.EnableRedisFanout(ctx, rdb)
+	defer wsManager.Close()
+
+	// Announce payment state changes in the logs and to subscribed clients
+	paymentStates.Observe(func(t StateTransition) {
+		appLogger.Info("Payment state changed", map[string]interface{}{
+			"payment_id": t.PaymentID,
+			"from":       t.FromString(),
+			"to":         t.To.String(),
+		})
+	})
+	paymentStates.Observe(recordPaymentHistory)
+	paymentStates.Observe(func(t StateTransition) {
+		wsManager.Notify(t.PaymentID, map[string]interface{}{
+			"event":      "state_changed",
+			"payment_id": t.PaymentID,
+			"from":       t.FromString(),
+			"to":         t.To.String(),
+			"at":         t.At.UTC().Format(time.RFC3339Nano),
+		})
+	})
+
 	// Initialize Database
 	_, err = ConnectDatabase()
 	if err != nil {
@@ -573,12 +1346,36 @@ func main() {
 		log.Println("Continuing without database logging...")
 	} else {
 		log.Println("Database connected successfully")
+		databaseConfigured.Store(true)
 		CreateDatabases()
 		defer DisconnectDatabase()
+
+		// Relay payment events written to the outbox
+		outboxRelay := NewOutboxRelay(dataStore, 500*time.Millisecond, 100)
+		outboxRelay.Start()
+		defer outboxRelay.Stop()
+
+		InitLedger(dataStore)
+
+		if err := InitVault(dataStore); err != nil {
+			log.Fatalf("Failed to initialize vault: %v", err)
+		}
 	}
 
 	// Initialize legacy server pool (for backward compatibility)
-	serverPool = NewServerPool(DefaultScoringConfig())
+	scoringConfig := DefaultScoringConfig()
+	if name := os.Getenv("SERVER_SELECTION_MODE"); name != "" {
+		mode, err := ParseSelectionMode(name)
+		if err != nil {
+			log.Fatalf("Invalid SERVER_SELECTION_MODE: %v", err)
+		}
+		scoringConfig.SelectionMode = mode
+	}
+	if sandboxGateways, err = SandboxGatewaysFromEnv(); err != nil {
+		log.Fatalf("Invalid sandbox config: %v", err)
+	}
+	serverPool = NewServerPool(scoringConfig)
+	serverPool.EnablePersistence(rdb)
 
 	gatewayServers := []string{
 		"http://localhost:3001/stripe",
@@ -595,46 +1392,183 @@ func main() {
 	serverPool.StartPeriodicScoreUpdate()
 	defer serverPool.StopPeriodicScoreUpdate()
 
+	// Charge recurring subscriptions and dispatch future-dated payments as they come due,
+	// once gateways are registered
+	if dataStore != nil {
+		subscriptionScheduler := NewSubscriptionScheduler(dataStore, 10*time.Second, 50)
+		subscriptionScheduler.Start()
+		defer subscriptionScheduler.Stop()
+
+		scheduledPaymentDispatcher := NewScheduledPaymentDispatcher(dataStore, time.Second, 2*time.Second, 100)
+		scheduledPaymentDispatcher.Start()
+		defer scheduledPaymentDispatcher.Stop()
+	}
+
 	// Initialize provider registry
+	InitConnectionPoolManager(DefaultPoolConfig())
 	providerRegistry = NewProviderRegistry()
 
 	// Register payment providers
 	providerRegistry.RegisterPaymentProvider(&ProviderConfig{
-		Provider: NewMockStripeProvider("http://localhost:3001/stripe"),
-		Enabled:  true,
-		Priority: PriorityPrimary,
+		Provider:      NewMockStripeProvider("http://localhost:3001/stripe"),
+		Enabled:       true,
+		Priority:      PriorityPrimary,
+		MaxConcurrent: 100,
 		SLA: SLAConfig{
 			MaxLatencyP95Ms: 500,
 			MinSuccessRate:  0.95,
 		},
+		RetryPolicy: &RetryConfig{
+			MaxAttempts:       3,
+			BaseDelay:         100 * time.Millisecond,
+			MaxDelay:          time.Second,
+			JitterFactor:      0.25,
+			RetryableStatuses: DefaultRetryConfig().RetryableStatuses,
+		},
+		Fees: FeeSchedule{
+			Default: Fee{Percentage: 2.9, FixedCents: 30},
+			ByCurrency: map[string]Fee{
+				"EUR": {Percentage: 1.5, FixedCents: 25},
+				"GBP": {Percentage: 1.5, FixedCents: 20},
+			},
+		},
 	})
 
 	providerRegistry.RegisterPaymentProvider(&ProviderConfig{
-		Provider: NewMockRazorpayProvider("http://localhost:3001/razorpay"),
-		Enabled:  true,
-		Priority: PrioritySecondary,
+		Provider:      NewMockRazorpayProvider("http://localhost:3001/razorpay"),
+		Enabled:       true,
+		Priority:      PrioritySecondary,
+		MaxConcurrent: 50,
 		SLA: SLAConfig{
 			MaxLatencyP95Ms: 600,
 			MinSuccessRate:  0.90,
 		},
+		RetryPolicy: &RetryConfig{
+			MaxAttempts:       2,
+			BaseDelay:         200 * time.Millisecond,
+			MaxDelay:          2 * time.Second,
+			JitterFactor:      0.25,
+			RetryableStatuses: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		},
+		Fees: FeeSchedule{
+			Default: Fee{Percentage: 3.0},
+			ByCurrency: map[string]Fee{
+				"INR": {Percentage: 2.0},
+			},
+		},
 	})
 
 	providerRegistry.RegisterPaymentProvider(&ProviderConfig{
-		Provider: NewMockKlarnaProvider("http://localhost:3001/klarna"),
-		Enabled:  true,
-		Priority: PriorityTertiary,
+		Provider:      NewMockKlarnaProvider("http://localhost:3001/klarna"),
+		Enabled:       true,
+		Priority:      PriorityTertiary,
+		MaxConcurrent: 20,
 		SLA: SLAConfig{
 			MaxLatencyP95Ms: 700,
 			MinSuccessRate:  0.85,
 		},
+		// BNPL sessions aren't safe to blindly resend, fail over instead
+		RetryPolicy: &RetryConfig{MaxAttempts: 1},
+		Fees: FeeSchedule{
+			Default: Fee{Percentage: 3.29, FixedCents: 30},
+		},
 	})
 
+	// Provider gateways only accept the currencies and regions their provider supports
+	for _, server := range gatewayServers {
+		if config, err := providerRegistry.GetPaymentProvider(gatewayName(server)); err == nil {
+			caps := config.Provider.Capabilities()
+			serverPool.TagServer(server, caps.SupportedCurrencies, caps.SupportedRegions)
+		}
+	}
+
 	// Register compliance provider
 	providerRegistry.RegisterComplianceProvider(&ComplianceProviderConfig{
 		Provider: NewMockOnfidoProvider("http://localhost:3001/onfido"),
 		Enabled:  true,
 	})
 
+	routingRules = NewRoutingRuleEngine(dataStore)
+	providerMaintenance = NewMaintenanceSchedule(dataStore)
+
+	strategy := RoutingStrategyPriority
+	if name := os.Getenv("ROUTING_STRATEGY"); name != "" {
+		parsed, err := ParseRoutingStrategy(name)
+		if err != nil {
+			log.Fatalf("Invalid ROUTING_STRATEGY: %v", err)
+		}
+		strategy = parsed
+	}
+	providerSelector = NewProviderSelector(providerRegistry, strategy, rdb, routingRules, serverPool)
+
+	loadSheddingConfig, err := LoadSheddingConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid load shedding config: %v", err)
+	}
+	InitLoadShedder(loadSheddingConfig, requestLatencyTracker, providerRegistry)
+
+	debugCaptureConfig, err := DebugCaptureConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid debug capture config: %v", err)
+	}
+	if debugCaptureConfig.Enabled {
+		debugCapture = NewDebugCapture(rdb, debugCaptureConfig)
+		log.Printf("[DebugCapture] Capturing masked provider payloads for %v", debugCaptureConfig.TTL)
+	}
+
+	healthProberConfig, err := HealthProberConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid health probe config: %v", err)
+	}
+	healthProber = NewHealthProber(serverPool, providerRegistry, healthProberConfig)
+	healthProber.Start()
+	defer healthProber.Stop()
+
+	amlConfig, err := AMLConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid AML config: %v", err)
+	}
+	amlScreener = NewAMLScreener(amlConfig)
+
+	complianceRules = NewComplianceRuleEngine(rdb)
+	fraudEngine = NewFraudEngine(rdb)
+
+	riskConfig, err = RiskConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid risk config: %v", err)
+	}
+	riskScorer = NewHeuristicRiskScorer(rdb)
+
+	sanctionsConfig, err := SanctionsConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sanctions config: %v", err)
+	}
+	sanctionsScreener = NewSanctionsScreener(NewMockSanctionsProvider("http://localhost:3001/sanctions"), sanctionsConfig, rdb)
+
+	complianceCacheTTL, err := ComplianceCacheTTLFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid compliance cache config: %v", err)
+	}
+	if complianceCacheTTL > 0 {
+		complianceCache = NewComplianceCache(rdb, complianceCacheTTL)
+	}
+
+	slaMonitorConfig, err := SLAMonitorConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid SLA monitor config: %v", err)
+	}
+	slaMonitor = NewSLAMonitor(serverPool, providerRegistry, slaMonitorConfig)
+	slaMonitor.Start()
+	defer slaMonitor.Stop()
+
+	anomalyConfig, err := AnomalyConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid anomaly detection config: %v", err)
+	}
+	anomalyDetector = NewAnomalyDetector(anomalyConfig)
+	anomalyDetector.Start()
+	defer anomalyDetector.Stop()
+
 	appLogger.Info("Provider registry initialized", map[string]interface{}{
 		"payment_providers":    3,
 		"compliance_providers": 1,
@@ -644,10 +1578,11 @@ func main() {
 	apiKeyStore = NewAPIKeyStore()
 	apiKeyStore.AddKey(&APIKey{
 		Key:       "demo_key_12345",
-		Secret:    "demo_secret_abcdef",
+		Secrets:   []APIKeySecret{{Version: 1, Secret: "demo_secret_abcdef", CreatedAt: time.Now()}},
 		Name:      "Demo API Key",
 		Enabled:   true,
 		CreatedAt: time.Now(),
+		Scopes:    AllScopes,
 	})
 
 	// Initialize rate limiter
@@ -659,26 +1594,21 @@ func main() {
 
 	// Setup middleware chain
 	mux := http.NewServeMux()
-	mux.HandleFunc("/payment", Payment)
-	mux.HandleFunc("/paymentKey", PaymentKey)
-	mux.HandleFunc("/metrics", MetricsHandler)
-	mux.HandleFunc("/logs", LogsHandler)
-	mux.HandleFunc("/ws", wsManager.HandleWS)
-
-	// Admin endpoints
-	mux.HandleFunc("/admin/providers", AdminProvidersHandler)
-	mux.HandleFunc("/admin/providers/enable", AdminProviderEnableHandler)
-	mux.HandleFunc("/admin/providers/disable", AdminProviderDisableHandler)
-	mux.HandleFunc("/admin/circuit-breaker/reset", AdminCircuitBreakerResetHandler)
-	mux.HandleFunc("/health", HealthCheckHandler)
+	registerRoutes(mux)
 
 	// Apply middleware (order matters!)
-	handler := CorrelationIDMiddleware(mux)        // 1. Add correlation ID
-	handler = RequestValidationMiddleware(handler) // 2. Validate request size/format
-	// Note: Auth and RateLimit middleware disabled for backward compatibility
-	// To enable: uncomment the lines below
-	// handler = RateLimitMiddleware(rateLimiter)(handler)   // 3. Rate limiting
-	// handler = AuthMiddleware(apiKeyStore)(handler)         // 4. Authentication
+	handler := RouteMetricsMiddleware(routeMetrics)(mux)               // Per-route counts and latency, must wrap the mux
+	handler = RequestLatencyMiddleware(requestLatencyTracker)(handler) // Record request latency for the load shedder
+	handler = LoadSheddingMiddleware(GetLoadShedder())(handler)        // Reject requests while overloaded
+	handler = ReadinessGateMiddleware(readiness)(handler)              // Refuse traffic until ready after startup
+	handler = AdminAuthMiddleware(handler)                             // Require an admin JWT on /admin/*
+	handler = CorrelationIDMiddleware(handler)                         // 1. Add correlation ID
+	handler = RequestValidationMiddleware(handler)                     // 2. Validate request size/format
+	handler = RateLimitMiddleware(rateLimiter)(handler)                // 3. Rate limiting, per API key once authenticated
+	// 4. Authentication. Merchant scoping, test mode and per-key settings all come from
+	// the authenticated key, so only the routes in unauthenticatedRoute skip it, and
+	// anonymousRoutes are served as the default merchant when called without credentials
+	handler = AuthMiddleware(apiKeyStore, NewNonceStore(rdb, 2*signatureMaxSkew))(handler)
 	handler = TimeoutMiddleware(30 * time.Second)(handler) // 5. Global timeout
 
 	appLogger.Info("Server starting", map[string]interface{}{
@@ -689,11 +1619,101 @@ func main() {
 			"structured_logging",
 			"latency_percentiles",
 			"compliance_checks",
+			"load_shedding",
 		},
 	})
 
+	// Traffic other than probes is refused until the dependencies are reachable
+	go readiness.WaitStarted(ctx)
+
 	log.Println("Server starting on port 3000...")
 	if err := http.ListenAndServe(":3000", handler); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// routeMux is the part of *http.ServeMux the routes are registered through, so tests
+// can list them
+type routeMux interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// registerRoutes registers every endpoint. Routes are documented in openapi.json,
+// see openapi_test.go
+func registerRoutes(mux routeMux) {
+	mux.HandleFunc("/payment", IdempotencyMiddleware(Payment))
+	mux.HandleFunc("GET /payment/{payment_id}", PaymentStatusHandler)
+	mux.HandleFunc("GET /payment/{payment_id}/history", PaymentHistoryHandler)
+	mux.HandleFunc("/payment/{payment_id}/refunds", IdempotencyMiddleware(PaymentRefundsHandler))
+	mux.HandleFunc("/payments", PaymentsHandler)
+	mux.HandleFunc("GET /payments/scheduled", ScheduledPaymentsHandler)
+	mux.HandleFunc("DELETE /payments/scheduled/{payment_id}", CancelScheduledPaymentHandler)
+	mux.HandleFunc("/paymentKey", PaymentKey)
+	mux.HandleFunc("/tokens", TokensHandler)
+	mux.HandleFunc("GET /tokens/{token}", TokenHandler)
+	mux.HandleFunc("/customers", CustomersHandler)
+	mux.HandleFunc("GET /customers/{customer_id}", CustomerHandler)
+	mux.HandleFunc("/customers/{customer_id}/payment_methods", CustomerPaymentMethodsHandler)
+	mux.HandleFunc("DELETE /customers/{customer_id}/payment_methods/{payment_method_id}", CustomerPaymentMethodHandler)
+	mux.HandleFunc("/ledger/balances", LedgerBalancesHandler)
+	mux.HandleFunc("/ledger/transactions", LedgerTransactionsHandler)
+	mux.HandleFunc("/disputes", DisputesHandler)
+	mux.HandleFunc("/disputes/webhook", DisputeWebhookHandler)
+	mux.HandleFunc("POST /callbacks/{provider}", ProviderCallbackHandler)
+	mux.HandleFunc("GET /disputes/{dispute_id}", DisputeHandler)
+	mux.HandleFunc("POST /disputes/{dispute_id}/evidence", DisputeEvidenceHandler)
+	mux.HandleFunc("/subscriptions", SubscriptionsHandler)
+	mux.HandleFunc("/subscriptions/{subscription_id}", SubscriptionHandler)
+	mux.HandleFunc("/payouts", PayoutsHandler)
+	mux.HandleFunc("GET /payouts/{payout_id}", PayoutHandler)
+	mux.HandleFunc("/bnpl", BNPLHandler)
+	mux.HandleFunc("GET /bnpl/{bnpl_id}", BNPLPlanHandler)
+	mux.HandleFunc("POST /bnpl/{bnpl_id}/events", BNPLEventHandler)
+	mux.HandleFunc("/metrics", MetricsHandler)
+	mux.HandleFunc("/logs", LogsHandler)
+	mux.HandleFunc("GET /logs/summary", LogsSummaryHandler)
+	mux.HandleFunc("/ws", wsManager.HandleWS)
+
+	// Admin endpoints
+	mux.HandleFunc("/admin/providers", AdminProvidersHandler)
+	mux.HandleFunc("/admin/providers/enable", AdminProviderEnableHandler)
+	mux.HandleFunc("/admin/providers/disable", AdminProviderDisableHandler)
+	mux.HandleFunc("/admin/circuit-breaker/reset", AdminCircuitBreakerResetHandler)
+	mux.HandleFunc("/admin/circuit-breaker/open", AdminCircuitBreakerOpenHandler)
+	mux.HandleFunc("/admin/providers/{name}/retry-policy", AdminRetryPolicyHandler)
+	mux.HandleFunc("/admin/providers/{name}/egress", AdminProviderEgressHandler)
+	mux.HandleFunc("/admin/connection-pools", AdminConnectionPoolsHandler)
+	mux.HandleFunc("/admin/scoring-config", AdminScoringConfigHandler)
+	mux.HandleFunc("/admin/vault/rotate", AdminVaultRotateHandler)
+	mux.HandleFunc("/admin/routing/strategy", AdminRoutingStrategyHandler)
+	mux.HandleFunc("/admin/routing/rules", AdminRoutingRulesHandler)
+	mux.HandleFunc("/admin/routing/simulate", AdminRoutingSimulateHandler)
+	mux.HandleFunc("/admin/audit", AdminAuditHandler)
+	mux.HandleFunc("/admin/sla", AdminSLAHandler)
+	mux.HandleFunc("DELETE /admin/compliance/{user_id}/approval", AdminComplianceApprovalHandler)
+	mux.HandleFunc("/admin/compliance/rules", AdminComplianceRulesHandler)
+	mux.HandleFunc("/admin/fraud/rules", AdminFraudRulesHandler)
+	mux.HandleFunc("GET /admin/sanctions/{reference}", AdminSanctionsDecisionHandler)
+	mux.HandleFunc("POST /admin/payments/{payment_id}/review", AdminPaymentReviewHandler)
+	mux.HandleFunc("POST /admin/payments/{payment_id}/refunds/{refund_id}/resolve", AdminRefundResolveHandler)
+	mux.HandleFunc("/admin/log-level", AdminLogLevelHandler)
+	mux.HandleFunc("/admin/drain", AdminDrainHandler)
+	mux.HandleFunc("GET /admin/outbox/dead-letters", AdminOutboxDeadLettersHandler)
+	mux.HandleFunc("POST /admin/outbox/{event_id}/redrive", AdminOutboxRedriveHandler)
+	mux.HandleFunc("/admin/undrain", AdminUndrainHandler)
+	mux.HandleFunc("GET /admin/debug/{correlation_id}", AdminDebugCaptureHandler)
+	mux.HandleFunc("/admin/apikeys", AdminAPIKeysHandler)
+	mux.HandleFunc("POST /admin/apikeys/{key}/rotate", AdminAPIKeyRotateHandler)
+	mux.HandleFunc("/admin/apikeys/{key}/ip-rules", AdminAPIKeyIPRulesHandler)
+	mux.HandleFunc("/admin/apikeys/{key}/providers", AdminAPIKeyProvidersHandler)
+	mux.HandleFunc("/admin/apikeys/{key}/decline-policy", AdminAPIKeyDeclinePolicyHandler)
+	mux.HandleFunc("DELETE /admin/routing/rules/{rule_id}", AdminRoutingRuleDeleteHandler)
+	mux.HandleFunc("/admin/maintenance-windows", AdminMaintenanceWindowsHandler)
+	mux.HandleFunc("DELETE /admin/maintenance-windows/{window_id}", AdminMaintenanceWindowDeleteHandler)
+	mux.HandleFunc("/oauth/token", OAuthTokenHandler)
+	mux.HandleFunc("/health", HealthCheckHandler)
+	mux.HandleFunc("/livez", LivezHandler)
+	mux.HandleFunc("/readyz", ReadyzHandler)
+	mux.HandleFunc("GET /openapi.json", OpenAPIHandler)
+	mux.HandleFunc("GET /docs", DocsHandler)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// useTestRedis points rdb at a fresh in-memory Redis for the duration of the test
+func useTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	prevRDB, prevCtx := rdb, ctx
+	rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx = context.Background()
+	t.Cleanup(func() {
+		rdb.Close()
+		rdb, ctx = prevRDB, prevCtx
+	})
+	return mr
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMaintenanceWindowNotFound = errors.New("maintenance window not found")
+	ErrInvalidMaintenanceWindow  = errors.New("invalid maintenance window")
+)
+
+// ProviderStatusMaintenance is the status reported in metrics for a provider inside
+// one of its maintenance windows
+const ProviderStatusMaintenance = "maintenance"
+
+// MaintenanceWindow is a period announced by a provider during which it is taken out
+// of routing. A recurring window repeats every Recurrence (daily, weekly, monthly,
+// yearly or a Go duration such as "12h"), starting at Start and lasting End-Start
+type MaintenanceWindow struct {
+	ID         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Recurrence string    `json:"recurrence,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// occurrence returns the window's latest occurrence starting at or before now, or its
+// first one if now is before Start
+func (w *MaintenanceWindow) occurrence(now time.Time) (time.Time, time.Time) {
+	length := w.End.Sub(w.Start)
+	start := w.Start
+	if w.Recurrence == "" || now.Before(start) {
+		return start, start.Add(length)
+	}
+
+	// Fixed-length periods jump straight to the last occurrence; months and years
+	// vary, so they are stepped through
+	if w.Recurrence != "monthly" && w.Recurrence != "yearly" {
+		next, _ := nextInterval(start, w.Recurrence)
+		period := next.Sub(start)
+		start = start.Add(now.Sub(start) / period * period)
+	}
+	for {
+		next, err := nextInterval(start, w.Recurrence)
+		if err != nil || next.After(now) {
+			break
+		}
+		start = next
+	}
+	return start, start.Add(length)
+}
+
+// ActiveAt reports whether the window covers t
+func (w *MaintenanceWindow) ActiveAt(t time.Time) bool {
+	start, end := w.occurrence(t)
+	return !t.Before(start) && t.Before(end)
+}
+
+// NextAfter returns the start and end of the window's first occurrence that hasn't
+// ended by t, and false if there is none
+func (w *MaintenanceWindow) NextAfter(t time.Time) (time.Time, time.Time, bool) {
+	start, end := w.occurrence(t)
+	if t.Before(end) {
+		return start, end, true
+	}
+	if w.Recurrence == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	next, err := nextInterval(start, w.Recurrence)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return next, next.Add(w.End.Sub(w.Start)), true
+}
+
+// validateMaintenanceWindow checks a window and normalizes its times to UTC
+func validateMaintenanceWindow(w *MaintenanceWindow) error {
+	if w.Provider == "" {
+		return errors.New("provider is required")
+	}
+	if !providerRegistry.HasPaymentProvider(w.Provider) {
+		return fmt.Errorf("unknown provider %q", w.Provider)
+	}
+	if w.Start.IsZero() || w.End.IsZero() {
+		return errors.New("start and end are required")
+	}
+	w.Start, w.End = w.Start.UTC(), w.End.UTC()
+	if !w.End.After(w.Start) {
+		return errors.New("end must be after start")
+	}
+	if w.Recurrence != "" {
+		next, err := nextInterval(w.Start, w.Recurrence)
+		if err != nil {
+			return fmt.Errorf("recurrence: %v", err)
+		}
+		// Overlapping occurrences would make the provider permanently unavailable
+		if w.End.After(next) {
+			return errors.New("window must be shorter than its recurrence")
+		}
+	}
+	return nil
+}
+
+// MaintenanceWindowStore persists maintenance windows
+type MaintenanceWindowStore interface {
+	ListMaintenanceWindows() ([]MaintenanceWindow, error)
+	SaveMaintenanceWindow(window *MaintenanceWindow) error
+	DeleteMaintenanceWindow(id string) error
+}
+
+// MaintenanceSchedule holds every provider's maintenance windows and answers whether
+// a provider is in maintenance now
+type MaintenanceSchedule struct {
+	store   MaintenanceWindowStore
+	windows []MaintenanceWindow
+	mu      sync.RWMutex
+}
+
+var providerMaintenance *MaintenanceSchedule
+
+// NewMaintenanceSchedule creates a schedule and loads persisted windows. A nil store
+// keeps windows in memory only
+func NewMaintenanceSchedule(store MaintenanceWindowStore) *MaintenanceSchedule {
+	schedule := &MaintenanceSchedule{store: store, windows: make([]MaintenanceWindow, 0)}
+	if store != nil {
+		windows, err := store.ListMaintenanceWindows()
+		if err != nil {
+			log.Printf("[Maintenance] Failed to load windows: %v", err)
+		} else {
+			schedule.windows = windows
+			log.Printf("[Maintenance] Loaded %d windows", len(windows))
+		}
+	}
+	return schedule
+}
+
+// Windows returns a copy of every window, ordered by provider and start
+func (ms *MaintenanceSchedule) Windows() []MaintenanceWindow {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	windows := make([]MaintenanceWindow, len(ms.windows))
+	copy(windows, ms.windows)
+	sort.Slice(windows, func(i, j int) bool {
+		if windows[i].Provider != windows[j].Provider {
+			return windows[i].Provider < windows[j].Provider
+		}
+		return windows[i].Start.Before(windows[j].Start)
+	})
+	return windows
+}
+
+// Add validates and schedules a window
+func (ms *MaintenanceSchedule) Add(window MaintenanceWindow) (*MaintenanceWindow, error) {
+	if err := validateMaintenanceWindow(&window); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMaintenanceWindow, err)
+	}
+	window.ID = "mw_" + uuid.NewString()
+	window.CreatedAt = time.Now().UTC()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.store != nil {
+		if err := ms.store.SaveMaintenanceWindow(&window); err != nil {
+			return nil, err
+		}
+	}
+	ms.windows = append(ms.windows, window)
+	return &window, nil
+}
+
+// Remove deletes a window by ID and returns it
+func (ms *MaintenanceSchedule) Remove(id string) (*MaintenanceWindow, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for i, window := range ms.windows {
+		if window.ID != id {
+			continue
+		}
+		if ms.store != nil {
+			if err := ms.store.DeleteMaintenanceWindow(id); err != nil {
+				return nil, err
+			}
+		}
+		ms.windows = append(ms.windows[:i], ms.windows[i+1:]...)
+		return &window, nil
+	}
+	return nil, ErrMaintenanceWindowNotFound
+}
+
+// Active returns the window a provider is in right now, or nil
+func (ms *MaintenanceSchedule) Active(provider string) *MaintenanceWindow {
+	if ms == nil {
+		return nil
+	}
+	now := time.Now()
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for i := range ms.windows {
+		if ms.windows[i].Provider == provider && ms.windows[i].ActiveAt(now) {
+			window := ms.windows[i]
+			return &window
+		}
+	}
+	return nil
+}
+
+// inMaintenance reports whether a provider is in a maintenance window right now
+func inMaintenance(provider string) bool {
+	return providerMaintenance.Active(provider) != nil
+}
+
+// maintenanceWindowView is a window as listed by the admin API, with its state now
+type maintenanceWindowView struct {
+	MaintenanceWindow
+	Active    bool       `json:"active"`
+	NextStart *time.Time `json:"next_start,omitempty"`
+	NextEnd   *time.Time `json:"next_end,omitempty"`
+}
+
+func viewMaintenanceWindow(window MaintenanceWindow, now time.Time) maintenanceWindowView {
+	view := maintenanceWindowView{MaintenanceWindow: window, Active: window.ActiveAt(now)}
+	if start, end, ok := window.NextAfter(now); ok {
+		view.NextStart, view.NextEnd = &start, &end
+	}
+	return view
+}
+
+func maintenanceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidMaintenanceWindow):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrMaintenanceWindowNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// AdminMaintenanceWindowsHandler handles /admin/maintenance-windows: GET lists windows
+// (?provider= to filter), POST schedules one
+func AdminMaintenanceWindowsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		provider := r.URL.Query().Get("provider")
+		now := time.Now()
+		views := make([]maintenanceWindowView, 0)
+		for _, window := range providerMaintenance.Windows() {
+			if provider == "" || window.Provider == provider {
+				views = append(views, viewMaintenanceWindow(window, now))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"windows": views,
+			"total":   len(views),
+		})
+
+	case http.MethodPost:
+		var window MaintenanceWindow
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		created, err := providerMaintenance.Add(window)
+		if err != nil {
+			http.Error(w, err.Error(), maintenanceErrorStatus(err))
+			return
+		}
+
+		log.Printf("[Maintenance] Scheduled %s for %s from %s", created.ID, created.Provider, created.Start.Format(time.RFC3339))
+		recordAudit(r, "schedule_maintenance", created.Provider, nil, created)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(viewMaintenanceWindow(*created, time.Now()))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminMaintenanceWindowDeleteHandler handles DELETE /admin/maintenance-windows/{window_id}
+func AdminMaintenanceWindowDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("window_id")
+	removed, err := providerMaintenance.Remove(id)
+	if err != nil {
+		http.Error(w, err.Error(), maintenanceErrorStatus(err))
+		return
+	}
+
+	log.Printf("[Maintenance] Removed window %s for %s", id, removed.Provider)
+	recordAudit(r, "remove_maintenance", removed.Provider, removed, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"message":   "Maintenance window removed",
+		"window_id": id,
+	})
+}
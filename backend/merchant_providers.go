@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Some merchants are contractually bound to particular providers. An API key can
+// list providers it prefers, which are routed to ahead of everyone else while any of
+// them is eligible, and providers it excludes, which never see its payments, not even
+// on failover. Per-provider overrides replace the MCC or statement descriptor sent to
+// that provider, for merchants registered differently with each acquirer
+
+var mccPattern = regexp.MustCompile(`^[0-9]{4}$`)
+
+// ProviderOverride replaces payment fields sent to one provider
+type ProviderOverride struct {
+	MCC        string `json:"mcc,omitempty"`        // Merchant category code, 4 digits
+	Descriptor string `json:"descriptor,omitempty"` // Replaces the payment's statement descriptor, see descriptors.go
+}
+
+// ProviderPreferences are an API key's provider requirements
+type ProviderPreferences struct {
+	Preferred []string                    `json:"preferred"`
+	Excluded  []string                    `json:"excluded"`
+	Overrides map[string]ProviderOverride `json:"overrides"`
+}
+
+// ParseProviderPreferences validates preferences against the registered providers and
+// normalizes them. Empty preferences parse to nil
+func ParseProviderPreferences(prefs ProviderPreferences) (*ProviderPreferences, error) {
+	parsed := ProviderPreferences{
+		Preferred: make([]string, 0, len(prefs.Preferred)),
+		Excluded:  make([]string, 0, len(prefs.Excluded)),
+		Overrides: make(map[string]ProviderOverride, len(prefs.Overrides)),
+	}
+	seen := make(map[string]string)
+	add := func(list *[]string, kind string, names []string) error {
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if !providerRegistry.HasPaymentProvider(name) {
+				return fmt.Errorf("%s: unknown provider %q", kind, name)
+			}
+			if previous, ok := seen[name]; ok {
+				if previous == kind {
+					continue
+				}
+				return fmt.Errorf("provider %q can't be both preferred and excluded", name)
+			}
+			seen[name] = kind
+			*list = append(*list, name)
+		}
+		return nil
+	}
+	if err := add(&parsed.Preferred, "preferred", prefs.Preferred); err != nil {
+		return nil, err
+	}
+	if err := add(&parsed.Excluded, "excluded", prefs.Excluded); err != nil {
+		return nil, err
+	}
+
+	for name, override := range prefs.Overrides {
+		if !providerRegistry.HasPaymentProvider(name) {
+			return nil, fmt.Errorf("overrides: unknown provider %q", name)
+		}
+		override.MCC = strings.TrimSpace(override.MCC)
+		override.Descriptor = strings.TrimSpace(override.Descriptor)
+		if override.MCC != "" && !mccPattern.MatchString(override.MCC) {
+			return nil, fmt.Errorf("overrides.%s.mcc must be 4 digits", name)
+		}
+		if override.Descriptor != "" {
+			if fe := validateDescriptorText("overrides."+name+".descriptor", override.Descriptor, minDescriptorLength); fe != nil {
+				return nil, errors.New(fe.Message)
+			}
+		}
+		if override != (ProviderOverride{}) {
+			parsed.Overrides[name] = override
+		}
+	}
+
+	if len(parsed.Preferred) == 0 && len(parsed.Excluded) == 0 && len(parsed.Overrides) == 0 {
+		return nil, nil
+	}
+	return &parsed, nil
+}
+
+// Excludes reports whether a provider must not receive the key's payments. Nil
+// preferences exclude nothing
+func (p *ProviderPreferences) Excludes(name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, excluded := range p.Excluded {
+		if excluded == name {
+			return true
+		}
+	}
+	return false
+}
+
+// preferenceRank is a provider's position in the preferred list, or -1
+func (p *ProviderPreferences) preferenceRank(name string) int {
+	if p == nil {
+		return -1
+	}
+	for i, preferred := range p.Preferred {
+		if preferred == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// prefersOther reports whether the merchant has preferred providers and name isn't one
+func (p *ProviderPreferences) prefersOther(name string) bool {
+	return p != nil && len(p.Preferred) > 0 && p.preferenceRank(name) < 0
+}
+
+// Override returns the fields to replace when sending a payment to a provider
+func (p *ProviderPreferences) Override(name string) (ProviderOverride, bool) {
+	if p == nil {
+		return ProviderOverride{}, false
+	}
+	override, ok := p.Overrides[name]
+	return override, ok
+}
+
+// filterPreferred narrows eligible providers, already stripped of excluded ones, to the
+// preferred ones in preference order. When no preferred provider is eligible the list
+// is returned unchanged, so a preference never leaves a payment unroutable
+func (p *ProviderPreferences) filterPreferred(eligible []*ProviderConfig) []*ProviderConfig {
+	if p == nil || len(p.Preferred) == 0 {
+		return eligible
+	}
+	preferred := make([]*ProviderConfig, len(p.Preferred))
+	found := false
+	for _, config := range eligible {
+		if rank := p.preferenceRank(config.Name); rank >= 0 {
+			preferred[rank] = config
+			found = true
+		}
+	}
+	if !found {
+		return eligible
+	}
+	filtered := make([]*ProviderConfig, 0, len(preferred))
+	for _, config := range preferred {
+		if config != nil {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
+// merchantProviderPreferences returns the preferences of a merchant's API key, if any.
+// Scheduled and subscription charges look them up by the merchant recorded on them
+func merchantProviderPreferences(merchantID string) *ProviderPreferences {
+	if apiKeyStore == nil {
+		return nil
+	}
+	key, exists := apiKeyStore.FindKey(merchantID)
+	if !exists {
+		return nil
+	}
+	return apiKeyStore.GetProviderPreferences(key)
+}
+
+// withProviderOverrides carries a payment's per-provider overrides to the gateway requests
+func withProviderOverrides(ctx context.Context, prefs *ProviderPreferences) context.Context {
+	if prefs == nil || len(prefs.Overrides) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, "provider_overrides", prefs)
+}
+
+// AdminAPIKeyProvidersHandler returns (GET) or replaces (PUT) a key's provider
+// preferences. An empty body clears them
+func AdminAPIKeyProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	keyID := r.PathValue("key")
+	key, exists := apiKeyStore.FindKey(keyID)
+	if !exists {
+		http.Error(w, fmt.Sprintf("API key '%s' not found", keyID), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":       keyID,
+			"providers": apiKeyStore.GetProviderPreferences(key),
+		})
+
+	case http.MethodPut:
+		var payload ProviderPreferences
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		prefs, err := ParseProviderPreferences(payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		before := apiKeyStore.GetProviderPreferences(key)
+		if err := apiKeyStore.SetProviderPreferences(keyID, prefs); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		recordAudit(r, "update_api_key_providers", keyID, before, prefs)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"message":   "Provider preferences updated",
+			"key":       keyID,
+			"providers": prefs,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
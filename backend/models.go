@@ -16,6 +16,18 @@ type PaymentRequest struct {
 	IdempotencyKey string                 `json:"idempotency_key" validate:"required"`
 	UserID         string                 `json:"user_id,omitempty"`
 	Email          string                 `json:"email,omitempty"`
+	PaymentToken   string                 `json:"payment_token,omitempty"`
+	Region         string                 `json:"region,omitempty"`  // Customer region, e.g. EU, US, APAC
+	Country        string                 `json:"country,omitempty"` // Customer country, ISO 3166 alpha-2
+	BIN            string                 `json:"bin,omitempty"`     // First six digits of the card
+	Hedge          bool                   `json:"-"`                 // Hedging allowed for the caller's API key
+	TestMode       bool                   `json:"-"`                 // Made with a test-mode API key, see testmode.go
+	Providers      *ProviderPreferences   `json:"-"`                 // The API key's provider preferences, see merchant_providers.go
+	DeclinePolicy  DeclinePolicy          `json:"-"`                 // The API key's decline policy overrides, see decline_policy.go
+	Splits         []PaymentSplit         `json:"splits,omitempty"`  // Recipients sharing the payment, summing to Amount
+	// StatementDescriptor and DescriptorSuffix set what the customer's statement shows, see descriptors.go
+	StatementDescriptor string `json:"statement_descriptor,omitempty"`
+	DescriptorSuffix    string `json:"descriptor_suffix,omitempty"`
 }
 
 // PaymentResponse represents a normalized payment response
@@ -123,6 +135,29 @@ type BNPLResponse struct {
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// PayoutRequest represents a normalized payout (disbursement) request
+type PayoutRequest struct {
+	ID              string                 `json:"id" validate:"required"`
+	Amount          int64                  `json:"amount" validate:"required,gt=0"`
+	Currency        string                 `json:"currency" validate:"required,len=3"`
+	Destination     string                 `json:"destination" validate:"required"`
+	BeneficiaryName string                 `json:"beneficiary_name,omitempty"`
+	UserID          string                 `json:"user_id,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+	IdempotencyKey  string                 `json:"idempotency_key" validate:"required"`
+}
+
+// PayoutResponse represents a normalized payout response
+type PayoutResponse struct {
+	PayoutID      string              `json:"payout_id"`
+	Status        string              `json:"status"`
+	ProviderTxnID string              `json:"provider_txn_id,omitempty"`
+	Provider      string              `json:"provider"`
+	ProcessedAt   time.Time           `json:"processed_at"`
+	ErrorCode     *CanonicalErrorCode `json:"error_code,omitempty"`
+	ErrorMessage  string              `json:"error_message,omitempty"`
+}
+
 // CanonicalErrorCode represents normalized error taxonomy
 type CanonicalErrorCode string
 
@@ -183,6 +218,8 @@ func ClassifyError(code CanonicalErrorCode) ErrorClassification {
 type ProviderCapabilities struct {
 	SupportsRefunds     bool     `json:"supports_refunds"`
 	SupportsBNPL        bool     `json:"supports_bnpl"`
+	SupportsPayouts     bool     `json:"supports_payouts"`
+	SupportsIdempotency bool     `json:"supports_idempotency"` // Deduplicates charges by Idempotency-Key
 	ComplianceReady     bool     `json:"compliance_ready"`
 	MaxAmountCents      int64    `json:"max_amount_cents"`
 	MinAmountCents      int64    `json:"min_amount_cents"`
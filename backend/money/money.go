@@ -0,0 +1,148 @@
+// Package money validates payment amounts against their currency. Amounts are
+// integers in the currency's minor unit as defined by ISO 4217: cents for USD (two
+// decimals), whole yen for JPY (none) and fils for KWD (three).
+//
+// Beyond the exponent, some currencies are only charged in whole major units even
+// though ISO 4217 lists minor units (HUF, TWD), and every currency has a smallest
+// chargeable amount so that a 1-unit payment can't be created by mistake.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxAmount is the largest amount accepted in any currency, in minor units. It keeps
+// amounts well inside int64 after scaling between exponents
+const MaxAmount = 1_000_000_000_000
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrAmountTooSmall   = errors.New("amount below the currency minimum")
+	ErrAmountTooLarge   = errors.New("amount above the maximum")
+	ErrInvalidIncrement = errors.New("amount not a valid multiple of the currency's minor unit")
+)
+
+// Currency describes how amounts in one ISO 4217 currency are expressed
+type Currency struct {
+	Code string
+	// Exponent is the number of decimal places of the minor unit
+	Exponent int
+	// MinAmount is the smallest chargeable amount, in minor units
+	MinAmount int64
+	// Increment is the step amounts must be a multiple of, in minor units; 1 unless the
+	// minor unit isn't used in practice
+	Increment int64
+}
+
+var currencies = map[string]Currency{}
+
+func register(exponent int, minAmount, increment int64, codes ...string) {
+	for _, code := range codes {
+		currencies[code] = Currency{Code: code, Exponent: exponent, MinAmount: minAmount, Increment: increment}
+	}
+}
+
+func init() {
+	// Two decimals
+	register(2, 50, 1, "USD", "EUR", "GBP", "CAD", "AUD", "NZD", "CHF", "SGD", "HKD", "NOK", "SEK", "DKK",
+		"PLN", "CZK", "RON", "BGN", "ILS", "AED", "SAR", "QAR", "MYR", "THB", "PHP", "BRL", "MXN", "ZAR",
+		"CNY", "EGP", "NGN", "KES", "TRY", "ARS", "COP", "PEN", "PKR", "BDT", "LKR", "MAD")
+	register(2, 100, 1, "INR")
+	// No minor unit
+	register(0, 50, 1, "JPY")
+	register(0, 100, 1, "KRW", "ISK", "CLP", "VND", "PYG", "UGX", "XAF", "XOF", "RWF")
+	// ISO 4217 lists two decimals but payments are made in whole units
+	register(2, 10000, 100, "HUF", "TWD")
+	// Three decimals
+	register(3, 500, 1, "KWD", "BHD", "OMR", "JOD", "TND")
+}
+
+// Lookup returns a currency by its code, in any case
+func Lookup(code string) (Currency, error) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return currency, nil
+}
+
+// Supported reports whether code is a known currency
+func Supported(code string) bool {
+	_, err := Lookup(code)
+	return err == nil
+}
+
+// Validate checks an amount in minor units against its currency's minimum, the
+// maximum and the currency's increment
+func Validate(amount int64, code string) error {
+	currency, err := Lookup(code)
+	if err != nil {
+		return err
+	}
+	return currency.Validate(amount)
+}
+
+// Validate checks an amount in this currency's minor units
+func (c Currency) Validate(amount int64) error {
+	if amount < c.MinAmount {
+		return fmt.Errorf("%w: %s is less than %s", ErrAmountTooSmall, c.Format(amount), c.Format(c.MinAmount))
+	}
+	if amount > MaxAmount {
+		return fmt.Errorf("%w: %s is more than %s", ErrAmountTooLarge, c.Format(amount), c.Format(MaxAmount))
+	}
+	if amount%c.Increment != 0 {
+		return fmt.Errorf("%w: %s must be a multiple of %s", ErrInvalidIncrement, c.Format(amount), c.Format(c.Increment))
+	}
+	return nil
+}
+
+// Format renders an amount in minor units as a decimal, e.g. "12.50 USD" or "500 JPY"
+func (c Currency) Format(amount int64) string {
+	if c.Exponent == 0 {
+		return fmt.Sprintf("%d %s", amount, c.Code)
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	unit := pow10(c.Exponent)
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, c.Exponent, amount%unit, c.Code)
+}
+
+// ScaleLimits converts amount limits given in hundredths of the major unit, the way
+// provider capabilities express them, to code's minor units. A 50-cent minimum becomes
+// ¥1 for JPY and 500 fils for KWD rather than ¥50 and 50 fils. The minimum is rounded
+// up and the maximum down, so the limits are never widened
+func ScaleLimits(code string, minCents, maxCents int64) (minAmount, maxAmount int64, err error) {
+	currency, err := Lookup(code)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case currency.Exponent > 2:
+		scale := pow10(currency.Exponent - 2)
+		return minCents * scale, maxCents * scale, nil
+	case currency.Exponent < 2:
+		scale := pow10(2 - currency.Exponent)
+		return (minCents + scale - 1) / scale, maxCents / scale, nil
+	default:
+		return minCents, maxCents, nil
+	}
+}
+
+// WithinLimits reports whether an amount in code's minor units lies within limits
+// given in hundredths of the major unit. Unknown currencies are never within limits
+func WithinLimits(amount int64, code string, minCents, maxCents int64) bool {
+	minAmount, maxAmount, err := ScaleLimits(code, minCents, maxCents)
+	return err == nil && amount >= minAmount && amount <= maxAmount
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
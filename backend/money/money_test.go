@@ -0,0 +1,130 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		currency string
+		want     error
+	}{
+		{"usd minimum", 50, "USD", nil},
+		{"usd below minimum", 49, "USD", ErrAmountTooSmall},
+		{"lowercase code", 1250, "usd", nil},
+		{"jpy minimum", 50, "JPY", nil},
+		{"jpy below minimum", 49, "JPY", ErrAmountTooSmall},
+		{"jpy any whole yen", 1001, "JPY", nil},
+		{"kwd three decimals", 1234, "KWD", nil},
+		{"kwd minimum", 500, "KWD", nil},
+		{"kwd below minimum", 499, "KWD", ErrAmountTooSmall},
+		{"huf whole forints", 10100, "HUF", nil},
+		{"huf fractional forint", 10150, "HUF", ErrInvalidIncrement},
+		{"huf below minimum", 9900, "HUF", ErrAmountTooSmall},
+		{"maximum", MaxAmount, "USD", nil},
+		{"above maximum", MaxAmount + 1, "USD", ErrAmountTooLarge},
+		{"huf above maximum", MaxAmount + 100, "HUF", ErrAmountTooLarge},
+		{"zero", 0, "USD", ErrAmountTooSmall},
+		{"negative", -100, "EUR", ErrAmountTooSmall},
+		{"unknown currency", 1000, "XYZ", ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.amount, tt.currency)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate(%d, %s) = %v, want nil", tt.amount, tt.currency, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Validate(%d, %s) = %v, want %v", tt.amount, tt.currency, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, "USD", "12.50 USD"},
+		{5, "EUR", "0.05 EUR"},
+		{-1250, "USD", "-12.50 USD"},
+		{500, "JPY", "500 JPY"},
+		{1234, "KWD", "1.234 KWD"},
+	}
+
+	for _, tt := range tests {
+		currency, err := Lookup(tt.currency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := currency.Format(tt.amount); got != tt.want {
+			t.Errorf("Format(%d) in %s = %q, want %q", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestScaleLimits(t *testing.T) {
+	tests := []struct {
+		name               string
+		currency           string
+		minCents, maxCents int64
+		wantMin, wantMax   int64
+	}{
+		{"two decimals unchanged", "USD", 50, 99999, 50, 99999},
+		{"jpy minimum rounds up", "JPY", 50, 1000000, 1, 10000},
+		{"jpy exact minimum", "JPY", 100, 1000000, 1, 10000},
+		{"jpy maximum rounds down", "JPY", 100, 1000099, 1, 10000},
+		{"jpy minimum just over a yen", "JPY", 101, 1000000, 2, 10000},
+		{"kwd scales up", "KWD", 50, 99999, 500, 999990},
+		{"huf keeps cents", "HUF", 10000, 500000, 10000, 500000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMin, gotMax, err := ScaleLimits(tt.currency, tt.minCents, tt.maxCents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMin != tt.wantMin || gotMax != tt.wantMax {
+				t.Fatalf("ScaleLimits(%s, %d, %d) = %d, %d, want %d, %d",
+					tt.currency, tt.minCents, tt.maxCents, gotMin, gotMax, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+
+	if _, _, err := ScaleLimits("XYZ", 50, 100); !errors.Is(err, ErrUnknownCurrency) {
+		t.Fatalf("ScaleLimits for an unknown currency = %v, want %v", err, ErrUnknownCurrency)
+	}
+}
+
+func TestWithinLimits(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     bool
+	}{
+		{50, "USD", true},
+		{49, "USD", false},
+		{1, "JPY", true},
+		{10000, "JPY", true},
+		{10001, "JPY", false},
+		{500, "KWD", true},
+		{499, "KWD", false},
+		{100, "XYZ", false},
+	}
+
+	for _, tt := range tests {
+		if got := WithinLimits(tt.amount, tt.currency, 50, 1000000); got != tt.want {
+			t.Errorf("WithinLimits(%d, %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrRequestReplayed means a signed request has already been seen
+var ErrRequestReplayed = errors.New("replayed request")
+
+// NonceStore remembers the signed requests it has accepted so a captured request
+// can't be replayed inside the timestamp window. Each (api key, signature, timestamp)
+// tuple is kept in Redis for as long as its timestamp could still be accepted
+type NonceStore struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// NewNonceStore creates a nonce store. ttl must cover the whole accepted timestamp
+// window, past and future skew together
+func NewNonceStore(client *redis.Client, ttl time.Duration) *NonceStore {
+	return &NonceStore{rdb: client, ttl: ttl}
+}
+
+// nonceKey hashes the tuple so keys stay short whatever the header sizes
+func nonceKey(apiKey, signature, timestamp string) string {
+	sum := sha256.Sum256([]byte(apiKey + "|" + signature + "|" + timestamp))
+	return "nonce:" + hex.EncodeToString(sum[:])
+}
+
+// Consume records a signed request, failing with ErrRequestReplayed if it was
+// recorded before
+func (ns *NonceStore) Consume(ctx context.Context, apiKey, signature, timestamp string) error {
+	stored, err := ns.rdb.SetNX(ctx, nonceKey(apiKey, signature, timestamp), 1, ns.ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to record nonce: %w", err)
+	}
+	if !stored {
+		return ErrRequestReplayed
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessTokenTTL is how long an OAuth2 access token is valid
+const accessTokenTTL = 15 * time.Minute
+
+// accessTokenAudience marks access tokens, so user login tokens signed with the same
+// secret can't be used as API credentials
+const accessTokenAudience = "pulseberry-api"
+
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// AccessTokenClaims are the claims of an OAuth2 access token. The subject is the API
+// key the token was issued to
+type AccessTokenClaims struct {
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// Scopes returns the token's granted scopes
+func (c *AccessTokenClaims) Scopes() []Scope {
+	scopes, err := ParseScopes(c.Scope)
+	if err != nil {
+		return nil
+	}
+	return scopes
+}
+
+// issueAccessToken signs an access token for an API key
+func issueAccessToken(key *APIKey, scopes []Scope) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
+	claims := AccessTokenClaims{
+		Scope: formatScopes(scopes),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   key.Key,
+			Audience:  jwt.ClaimStrings{accessTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	return token, expiresAt, err
+}
+
+// parseAccessToken validates an access token and returns its claims
+func parseAccessToken(tokenString string) (*AccessTokenClaims, error) {
+	claims := &AccessTokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(accessTokenAudience), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
+
+// matchesActiveSecret reports whether secret is one of the key's unexpired secrets
+func matchesActiveSecret(key *APIKey, secret string) bool {
+	matched := false
+	for _, s := range apiKeyStore.ActiveSecrets(key) {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(s.Secret)) == 1 {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// writeOAuthError writes an RFC 6749 error response
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// OAuthTokenHandler implements the OAuth2 client credentials grant on POST /oauth/token.
+// The client ID and secret are an API key and its secret, sent with HTTP Basic auth or
+// as form fields. Requested scopes must be a subset of the key's; omitting scope grants
+// all of them
+func OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(jwtSecret) == 0 {
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "token signing is not configured")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "body must be application/x-www-form-urlencoded")
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "client_credentials" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	key, err := apiKeyStore.GetKey(clientID)
+	if clientID == "" || err != nil || !matchesActiveSecret(key, clientSecret) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or bad secret")
+		return
+	}
+	if !apiKeyStore.ClientIPAllowed(key, r) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client IP not allowed for this client")
+		return
+	}
+
+	scopes := key.Scopes
+	if requested := r.PostForm.Get("scope"); strings.TrimSpace(requested) != "" {
+		if scopes, err = ParseScopes(requested); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+			return
+		}
+		for _, scope := range scopes {
+			if !key.HasScope(scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q is not granted to this client", scope))
+				return
+			}
+		}
+	}
+
+	token, expiresAt, err := issueAccessToken(key, scopes)
+	if err != nil {
+		log.Printf("[OAuth] Failed to sign token for %s: %v", key.Name, err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(expiresAt).Seconds()),
+		"scope":        formatScopes(scopes),
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPISpec is the hand-maintained API description, kept next to the handlers so
+// route changes and spec changes land in the same commit
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIMethods are the operation keys a path item may hold
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// ValidateOpenAPISpec checks the embedded spec is well-formed OpenAPI 3: every
+// operation declares responses and every $ref points at a defined component
+func ValidateOpenAPISpec() error {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return fmt.Errorf("parse openapi.json: %w", err)
+	}
+	if version, _ := spec["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return fmt.Errorf("openapi version must be 3.x, got %q", version)
+	}
+	paths, _ := spec["paths"].(map[string]interface{})
+	if len(paths) == 0 {
+		return fmt.Errorf("spec has no paths")
+	}
+
+	for path, item := range paths {
+		operations, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("path %s is not an object", path)
+		}
+		for method, op := range operations {
+			if !openAPIMethods[method] {
+				continue
+			}
+			operation, _ := op.(map[string]interface{})
+			if responses, _ := operation["responses"].(map[string]interface{}); len(responses) == 0 {
+				return fmt.Errorf("%s %s has no responses", strings.ToUpper(method), path)
+			}
+		}
+	}
+
+	var refs []string
+	collectOpenAPIRefs(spec, &refs)
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if !resolveOpenAPIRef(spec, ref) {
+			return fmt.Errorf("unresolved $ref %s", ref)
+		}
+	}
+	return nil
+}
+
+func collectOpenAPIRefs(node interface{}, refs *[]string) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				*refs = append(*refs, ref)
+				continue
+			}
+			collectOpenAPIRefs(child, refs)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectOpenAPIRefs(child, refs)
+		}
+	}
+}
+
+// resolveOpenAPIRef follows a local JSON pointer such as #/components/schemas/Refund
+func resolveOpenAPIRef(spec map[string]interface{}, ref string) bool {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return false
+	}
+	var node interface{} = spec
+	for _, part := range strings.Split(pointer, "/") {
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if node, ok = obj[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenAPIHandler handles GET /openapi.json
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Pulseberry API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// DocsHandler handles GET /docs, rendering the spec with Swagger UI
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
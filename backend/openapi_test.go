@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// undocumentedRoutes are registered but deliberately left out of openapi.json
+var undocumentedRoutes = map[string]bool{
+	"/ws":           true, // WebSocket upgrade, not a REST operation
+	"/openapi.json": true, // The spec itself
+	"/docs":         true, // Renders the spec
+}
+
+// recordingMux collects the patterns passed to HandleFunc
+type recordingMux struct {
+	patterns []string
+}
+
+func (m *recordingMux) HandleFunc(pattern string, _ func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+}
+
+func TestValidateOpenAPISpec(t *testing.T) {
+	if err := ValidateOpenAPISpec(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+
+	mux := &recordingMux{}
+	registerRoutes(mux)
+
+	registered := make(map[string]bool)
+	for _, pattern := range mux.patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		registered[path] = true
+		if undocumentedRoutes[path] {
+			continue
+		}
+
+		operations, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("route %s is not documented in openapi.json", pattern)
+			continue
+		}
+		if method != "" {
+			if _, ok := operations[strings.ToLower(method)]; !ok {
+				t.Errorf("route %s is documented without its %s operation", pattern, method)
+			}
+			continue
+		}
+		documented := false
+		for name := range operations {
+			documented = documented || openAPIMethods[name]
+		}
+		if !documented {
+			t.Errorf("route %s is documented without any operation", pattern)
+		}
+	}
+
+	var stale []string
+	for path := range spec.Paths {
+		if !registered[path] {
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+	for _, path := range stale {
+		t.Errorf("openapi.json documents %s, which no route serves", path)
+	}
+}
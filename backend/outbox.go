@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outbox event types
+const (
+	OutboxEventPaymentCompleted = "payment.completed"
+)
+
+// A failed delivery is retried with exponential backoff. Events still failing after
+// maxOutboxAttempts are dead-lettered: dead_at is set and the relay stops picking them up,
+// so a poison event can't hold up the rest of the batch forever
+const (
+	maxOutboxAttempts    = 10
+	outboxBaseRetryDelay = 5 * time.Second
+	outboxMaxRetryDelay  = 30 * time.Minute
+)
+
+// outboxRetryDelay is the wait before the next delivery of an event that has failed
+// attempts times
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := outboxBaseRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxRetryDelay {
+		delay = outboxMaxRetryDelay
+	}
+	return delay
+}
+
+// ErrOutboxEventNotDead is returned when redriving an event that is not dead-lettered
+var ErrOutboxEventNotDead = errors.New("outbox event is not dead-lettered")
+
+// OutboxEvent is a domain event written in the same transaction as the state it describes
+type OutboxEvent struct {
+	ID            int64      `json:"id"`
+	AggregateID   string     `json:"aggregate_id"`
+	EventType     string     `json:"event_type"`
+	Payload       string     `json:"payload"`
+	Attempts      int        `json:"attempts"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"`
+}
+
+// OutboxStore persists and relays outbox events
+type OutboxStore interface {
+	// StorePaymentWithEvent upserts the payment, its transition and the event in one transaction
+	StorePaymentWithEvent(record *PaymentRecord, fromStatus string, event *OutboxEvent) error
+	// RelayOutbox claims up to limit pending events that are due, hands each to publish
+	// and marks successfully published events delivered. Failed events are rescheduled
+	// or dead-lettered. It returns the number of events claimed
+	RelayOutbox(limit int, publish func(event OutboxEvent) error) (int, error)
+	// ListDeadOutboxEvents returns up to limit dead-lettered events
+	ListDeadOutboxEvents(limit int) ([]OutboxEvent, error)
+	// RedriveOutboxEvent makes a dead-lettered event due for delivery again with a fresh
+	// attempt count, or returns ErrOutboxEventNotDead
+	RedriveOutboxEvent(id int64) error
+}
+
+// OutboxRelay periodically publishes pending outbox events
+type OutboxRelay struct {
+	store     OutboxStore
+	interval  time.Duration
+	batchSize int
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewOutboxRelay creates a relay polling the store at the given interval
+func NewOutboxRelay(store OutboxStore, interval time.Duration, batchSize int) *OutboxRelay {
+	return &OutboxRelay{
+		store:     store,
+		interval:  interval,
+		batchSize: batchSize,
+		stopChan:  make(chan bool),
+	}
+}
+
+// Start launches the relay goroutine
+func (ob *OutboxRelay) Start() {
+	ob.mu.Lock()
+	if ob.isRunning {
+		ob.mu.Unlock()
+		return
+	}
+	ob.isRunning = true
+	ob.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ob.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ob.relayOnce()
+			case <-ob.stopChan:
+				log.Println("[OutboxRelay] Stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the relay goroutine
+func (ob *OutboxRelay) Stop() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.isRunning {
+		ob.stopChan <- true
+		ob.isRunning = false
+	}
+}
+
+// relayOnce drains due events until a batch comes back short. Failed events are
+// rescheduled, so they don't keep a batch full
+func (ob *OutboxRelay) relayOnce() {
+	for {
+		claimed, err := ob.store.RelayOutbox(ob.batchSize, publishOutboxEvent)
+		if err != nil {
+			log.Printf("[OutboxRelay] Relay failed: %v", err)
+			return
+		}
+		if claimed < ob.batchSize {
+			return
+		}
+	}
+}
+
+// publishOutboxEvent delivers an event to the Redis result cache and WebSocket clients
+func publishOutboxEvent(event OutboxEvent) error {
+	switch event.EventType {
+	case OutboxEventPaymentCompleted:
+		return publishPaymentResult(event.AggregateID, []byte(event.Payload))
+	default:
+		log.Printf("[OutboxRelay] Skipping unknown event type %s (id: %d)", event.EventType, event.ID)
+		return nil
+	}
+}
+
+// publishPaymentResult caches a payment result and notifies subscribed clients
+func publishPaymentResult(paymentID string, payload []byte) error {
+	if err := rdb.Set(ctx, paymentResultKey(paymentID), string(payload), 24*time.Hour).Err(); err != nil {
+		return err
+	}
+	wsManager.Notify(paymentID, json.RawMessage(payload))
+	return nil
+}
+
+// CompletePayment records a payment's final state and its result event atomically.
+// The outbox relay publishes the event; without a database the result is published directly.
+// The record is updated under the same WATCH as UpdatePaymentRecord, so concurrent
+// status or refund updates are not overwritten. It returns the updated record, or nil
+// if the result could not be encoded or the record could not be updated
+func CompletePayment(paymentID string, result interface{}, fn func(record *PaymentRecord)) *PaymentRecord {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to marshal payment result for %s: %v", paymentID, err)
+		return nil
+	}
+
+	record, fromStatus, err := updateCachedPaymentRecord(paymentID, fn)
+	if err != nil {
+		// Clients still get the result; the record is left as it was rather than guessed at
+		log.Printf("Failed to update payment record for %s: %v", paymentID, err)
+		if err := publishPaymentResult(paymentID, payload); err != nil {
+			log.Printf("Failed to publish payment result for %s: %v", paymentID, err)
+		}
+		return nil
+	}
+
+	if outboxStore, ok := dataStore.(OutboxStore); ok {
+		err := outboxStore.StorePaymentWithEvent(record, fromStatus, &OutboxEvent{
+			AggregateID: paymentID,
+			EventType:   OutboxEventPaymentCompleted,
+			Payload:     string(payload),
+		})
+		if err == nil {
+			return record
+		}
+		log.Printf("Failed to write outbox event for %s, publishing directly: %v", paymentID, err)
+	}
+
+	if err := publishPaymentResult(paymentID, payload); err != nil {
+		log.Printf("Failed to publish payment result for %s: %v", paymentID, err)
+	}
+	return record
+}
+
+// AdminOutboxDeadLettersHandler handles GET /admin/outbox/dead-letters?limit=
+func AdminOutboxDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	outboxStore, ok := dataStore.(OutboxStore)
+	if !ok {
+		http.Error(w, "Outbox not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, err := outboxStore.ListDeadOutboxEvents(limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch dead-lettered events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+// AdminOutboxRedriveHandler handles POST /admin/outbox/{event_id}/redrive, returning a
+// dead-lettered event to the relay once whatever made it fail has been fixed
+func AdminOutboxRedriveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	outboxStore, ok := dataStore.(OutboxStore)
+	if !ok {
+		http.Error(w, "Outbox not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	eventID := r.PathValue("event_id")
+	id, err := strconv.ParseInt(eventID, 10, 64)
+	if err != nil {
+		http.Error(w, "event_id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	if err := outboxStore.RedriveOutboxEvent(id); err != nil {
+		if errors.Is(err, ErrOutboxEventNotDead) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to redrive event", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "redrive_outbox_event", eventID, map[string]string{"state": "dead"}, map[string]string{"state": "pending"})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Outbox event " + eventID + " redriven",
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestOutboxRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, outboxBaseRetryDelay},
+		{2, 2 * outboxBaseRetryDelay},
+		{3, 4 * outboxBaseRetryDelay},
+		{5, 16 * outboxBaseRetryDelay},
+		{maxOutboxAttempts, outboxMaxRetryDelay},
+		{100, outboxMaxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := outboxRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("outboxRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func outboxRows(events ...OutboxEvent) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "aggregate_id", "event_type", "payload", "attempts", "created_at"})
+	for _, event := range events {
+		rows.AddRow(event.ID, event.AggregateID, event.EventType, event.Payload, event.Attempts, time.Now())
+	}
+	return rows
+}
+
+func TestRelayOutboxBacksOffAndDeadLetters(t *testing.T) {
+	store, mock := newMockStore(t)
+	retrying := OutboxEvent{ID: 1, AggregateID: "pay_1", EventType: "payment.completed", Payload: "{}", Attempts: 2}
+	dying := OutboxEvent{ID: 2, AggregateID: "pay_2", EventType: "payment.completed", Payload: "{}", Attempts: maxOutboxAttempts - 1}
+	delivered := OutboxEvent{ID: 3, AggregateID: "pay_3", EventType: "payment.completed", Payload: "{}"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FOR UPDATE SKIP LOCKED`)).WillReturnRows(outboxRows(retrying, dying, delivered))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET attempts = $1, next_attempt_at = $2 WHERE id = $3`)).
+		WithArgs(3, sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET attempts = $1, dead_at = $2 WHERE id = $3`)).
+		WithArgs(maxOutboxAttempts, sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET delivered_at = $1 WHERE id = $2`)).
+		WithArgs(sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := store.RelayOutbox(10, func(event OutboxEvent) error {
+		if event.ID == delivered.ID {
+			return nil
+		}
+		return errors.New("redis unavailable")
+	})
+	if err != nil {
+		t.Fatalf("RelayOutbox: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("relayed %d events, want 3", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelayOutboxSchedulesRetryAfterBackoff(t *testing.T) {
+	store, mock := newMockStore(t)
+	event := OutboxEvent{ID: 1, AggregateID: "pay_1", EventType: "payment.completed", Payload: "{}", Attempts: 3}
+
+	before := time.Now().UTC()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FOR UPDATE SKIP LOCKED`)).WillReturnRows(outboxRows(event))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET attempts = $1, next_attempt_at = $2 WHERE id = $3`)).
+		WithArgs(4, nextAttemptAfter{before.Add(outboxRetryDelay(4))}, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := store.RelayOutbox(10, func(OutboxEvent) error { return errors.New("redis unavailable") }); err != nil {
+		t.Fatalf("RelayOutbox: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// nextAttemptAfter matches a next_attempt_at no earlier than the expected backoff
+type nextAttemptAfter struct {
+	earliest time.Time
+}
+
+func (m nextAttemptAfter) Match(v driver.Value) bool {
+	next, ok := v.(time.Time)
+	return ok && !next.Before(m.earliest) && next.Before(m.earliest.Add(time.Minute))
+}
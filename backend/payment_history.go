@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PaymentHistoryEntry is one recorded state transition of a payment
+type PaymentHistoryEntry struct {
+	PaymentID string    `json:"payment_id"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// PaymentHistoryStore persists payment state transitions
+type PaymentHistoryStore interface {
+	RecordPaymentTransition(entry *PaymentHistoryEntry) error
+	// GetPaymentHistory returns a payment's transitions, oldest first
+	GetPaymentHistory(paymentID string) ([]PaymentHistoryEntry, error)
+}
+
+// paymentHistoryKey returns the Redis list holding a payment's transitions
+func paymentHistoryKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "payment_history:" + paymentID
+}
+
+// recordPaymentHistory is a StateStore observer saving every transition to Redis,
+// for as long as the payment record is kept, and to the database when there is one
+func recordPaymentHistory(t StateTransition) {
+	entry := &PaymentHistoryEntry{
+		PaymentID: t.PaymentID,
+		From:      t.FromString(),
+		To:        t.To.String(),
+		Reason:    t.Reason,
+		Actor:     t.Actor,
+		At:        t.At.UTC(),
+	}
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		key := paymentHistoryKey(t.PaymentID)
+		pipe := rdb.TxPipeline()
+		pipe.RPush(ctx, key, data)
+		pipe.Expire(ctx, key, paymentRecordTTL)
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		log.Printf("Failed to cache state transition for %s: %v", t.PaymentID, err)
+	}
+
+	if dataStore != nil {
+		if err := dataStore.RecordPaymentTransition(entry); err != nil {
+			log.Printf("Failed to persist state transition for %s: %v", t.PaymentID, err)
+		}
+	}
+}
+
+// GetPaymentHistory returns a payment's transitions, from the database when there is
+// one and from Redis otherwise
+func GetPaymentHistory(paymentID string) ([]PaymentHistoryEntry, error) {
+	if dataStore != nil {
+		return dataStore.GetPaymentHistory(paymentID)
+	}
+
+	items, err := rdb.LRange(ctx, paymentHistoryKey(paymentID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	history := make([]PaymentHistoryEntry, 0, len(items))
+	for _, item := range items {
+		var entry PaymentHistoryEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// PaymentHistoryHandler handles GET /payment/{payment_id}/history
+func PaymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("payment_id")
+	w.Header().Set("Content-Type", "application/json")
+
+	// Another merchant's payment is reported as missing rather than forbidden
+	record, err := loadPaymentRecord(paymentID)
+	if err == redis.Nil || (err == nil && !ownedByCaller(r.Context(), record.MerchantID)) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrPaymentNotFound,
+			"Payment not found",
+			"",
+			paymentID,
+		))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrInternalError,
+			"Failed to load payment",
+			"",
+			err.Error(),
+		))
+		return
+	}
+
+	history, err := GetPaymentHistory(paymentID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrInternalError,
+			"Failed to load payment history",
+			"",
+			err.Error(),
+		))
+		return
+	}
+	if len(history) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrPaymentNotFound,
+			"Payment not found",
+			"",
+			paymentID,
+		))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"payment_id": paymentID,
+		"history":    history,
+		"total":      len(history),
+	})
+}
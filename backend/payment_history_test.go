@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func paymentHistoryRequest(merchantID string) (*httptest.ResponseRecorder, *http.Request) {
+	req := httptest.NewRequest(http.MethodGet, "/payment/pay_1/history", nil)
+	req.SetPathValue("payment_id", "pay_1")
+	return httptest.NewRecorder(), req.WithContext(context.WithValue(req.Context(), "api_key", merchantID))
+}
+
+func TestPaymentHistoryHandlerHidesOtherMerchantsPayments(t *testing.T) {
+	useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", MerchantID: "merchant_a", Status: SUCCESS.String()}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	recordPaymentHistory(StateTransition{PaymentID: "pay_1", To: SUCCESS, At: time.Now()})
+
+	tests := []struct {
+		merchant string
+		want     int
+	}{
+		{"merchant_a", http.StatusOK},
+		{"merchant_b", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec, req := paymentHistoryRequest(tt.merchant)
+		PaymentHistoryHandler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.merchant, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestPaymentHistoryHandlerChecksOwnerOfExpiredRecord(t *testing.T) {
+	useTestRedis(t)
+	store, mock := newMockStore(t)
+	dataStore = store
+	t.Cleanup(func() { dataStore = nil })
+
+	// The Redis record has expired, so the owner comes from the payments table
+	now := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payments WHERE payment_id = $1`)).
+		WithArgs("pay_1").
+		WillReturnRows(sqlmock.NewRows([]string{"payment_id", "order_id", "amount", "currency", "user_id", "merchant_id", "provider",
+			"status", "latency_ms", "error_code", "error_message", "created_at", "updated_at"}).
+			AddRow("pay_1", "", 1000, "USD", "", "merchant_a", "stripe", SUCCESS.String(), 120, "", "", now, now))
+
+	rec, req := paymentHistoryRequest("merchant_b")
+	PaymentHistoryHandler(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// paymentRecordTTL is how long payment status records are kept in Redis
+const paymentRecordTTL = 24 * time.Hour
+
+// PaymentRecord is the pollable view of a payment's current state
+type PaymentRecord struct {
+	PaymentID      string         `json:"payment_id"`
+	OrderID        string         `json:"order_id,omitempty"`
+	Status         string         `json:"status"`
+	Amount         int64          `json:"amount"`
+	Currency       string         `json:"currency"`
+	UserID         string         `json:"user_id,omitempty"`
+	MerchantID     string         `json:"merchant_id,omitempty"`
+	Provider       string         `json:"provider,omitempty"`
+	RefundedAmount int64          `json:"refunded_amount,omitempty"` // Mirrors the refunded:{id} counter
+	Splits         []PaymentSplit `json:"splits,omitempty"`          // Recipients sharing the payment
+	LatencyMs      int64          `json:"latency_ms"`
+	ErrorCode      string         `json:"error_code,omitempty"`
+	ErrorMessage   string         `json:"error_message,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// paymentRecordKey returns the Redis key holding a payment's record
+func paymentRecordKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "payment_record:" + paymentID
+}
+
+// paymentResultKey returns the Redis key caching a payment's final result
+func paymentResultKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "payment_result:" + paymentID
+}
+
+// maxRecordUpdateAttempts bounds how often an update is retried on a record that keeps
+// changing under it
+const maxRecordUpdateAttempts = 5
+
+// stampPaymentRecord sets UpdatedAt, and CreatedAt on a new record
+func stampPaymentRecord(record *PaymentRecord) {
+	record.UpdatedAt = time.Now().UTC()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = record.UpdatedAt
+	}
+}
+
+// SavePaymentRecord stores a payment record, stamping UpdatedAt
+func SavePaymentRecord(record *PaymentRecord) error {
+	stampPaymentRecord(record)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, paymentRecordKey(record.PaymentID), data, paymentRecordTTL).Err()
+}
+
+// GetPaymentRecord loads a payment record, returning redis.Nil if it does not exist
+func GetPaymentRecord(paymentID string) (*PaymentRecord, error) {
+	data, err := rdb.Get(ctx, paymentRecordKey(paymentID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var record PaymentRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// loadPaymentRecord returns a payment's record, from the payments table once the
+// Redis record has expired. It returns redis.Nil when the payment is in neither
+func loadPaymentRecord(paymentID string) (*PaymentRecord, error) {
+	record, err := GetPaymentRecord(paymentID)
+	if err != redis.Nil || dataStore == nil {
+		return record, err
+	}
+	record, err = dataStore.GetPayment(paymentID)
+	if errors.Is(err, ErrPaymentRecordNotFound) {
+		return nil, redis.Nil
+	}
+	return record, err
+}
+
+// UpdatePaymentRecord applies fn to an existing record (or a fresh one if there is
+// none), saves it to Redis and persists it to the payments table. The record is
+// watched while fn runs, so when another update lands first fn is applied again to
+// the new record rather than overwriting it; fn may therefore run more than once
+func UpdatePaymentRecord(paymentID string, fn func(record *PaymentRecord)) error {
+	record, fromStatus, err := updateCachedPaymentRecord(paymentID, fn)
+	if err != nil {
+		return err
+	}
+	if err := StorePayment(record, fromStatus); err != nil {
+		log.Printf("Failed to persist payment %s: %v", paymentID, err)
+	}
+	return nil
+}
+
+// updateCachedPaymentRecord applies fn to the Redis record under WATCH, retrying when
+// the record changes underneath. Only a missing record (redis.Nil) starts a fresh one;
+// any other read error is returned so a transient failure can't replace the stored
+// record. It returns the saved record and the status it had before fn ran
+func updateCachedPaymentRecord(paymentID string, fn func(record *PaymentRecord)) (*PaymentRecord, string, error) {
+	key := paymentRecordKey(paymentID)
+	var record *PaymentRecord
+	var fromStatus string
+
+	update := func(tx *redis.Tx) error {
+		record = &PaymentRecord{PaymentID: paymentID}
+		data, err := tx.Get(ctx, key).Result()
+		switch {
+		case err == redis.Nil:
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal([]byte(data), record); err != nil {
+				return fmt.Errorf("corrupt payment record %s: %v", paymentID, err)
+			}
+		}
+		fromStatus = record.Status
+		fn(record)
+		stampPaymentRecord(record)
+
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, payload, paymentRecordTTL)
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < maxRecordUpdateAttempts; attempt++ {
+		err := rdb.Watch(ctx, update, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return record, fromStatus, nil
+	}
+	return nil, "", fmt.Errorf("payment record %s changed during %d update attempts", paymentID, maxRecordUpdateAttempts)
+}
+
+// PaymentStatusHandler handles GET /payment/{payment_id}
+func PaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("payment_id")
+	w.Header().Set("Content-Type", "application/json")
+
+	// Another merchant's payment is reported as missing rather than forbidden
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrPaymentNotFound,
+			"Payment not found",
+			"",
+			paymentID,
+		))
+	}
+
+	record, err := GetPaymentRecord(paymentID)
+	if err == redis.Nil {
+		// Fall back to the cached result for payments processed before records existed.
+		// Those predate merchants, so they belong to the default merchant
+		cachedResult, cacheErr := rdb.Get(ctx, paymentResultKey(paymentID)).Result()
+		if cacheErr != nil || cachedResult == "" || !ownedByCaller(r.Context(), "") {
+			notFound()
+			return
+		}
+		w.Write([]byte(cachedResult))
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(NewErrorResponse(
+			ErrInternalError,
+			"Failed to load payment status",
+			"",
+			err.Error(),
+		))
+		return
+	}
+	if !ownedByCaller(r.Context(), record.MerchantID) {
+		notFound()
+		return
+	}
+
+	json.NewEncoder(w).Encode(NewSuccessResponse(record.Status, record.PaymentID, record))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpdatePaymentRecordStartsFreshRecord(t *testing.T) {
+	useTestRedis(t)
+
+	if err := UpdatePaymentRecord("pay_1", func(record *PaymentRecord) {
+		record.Status = PROCESSING.String()
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	record, err := GetPaymentRecord("pay_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if record.PaymentID != "pay_1" || record.Status != PROCESSING.String() || record.CreatedAt.IsZero() {
+		t.Errorf("record = %+v, want a new PROCESSING record for pay_1", record)
+	}
+}
+
+func TestUpdatePaymentRecordRetriesOnConcurrentWrite(t *testing.T) {
+	mr := useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", Status: SUCCESS.String(), Amount: 1000}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	calls := 0
+	err := UpdatePaymentRecord("pay_1", func(record *PaymentRecord) {
+		calls++
+		if calls == 1 {
+			// Another instance records a refund while this update is in progress
+			concurrent := *record
+			concurrent.RefundedAmount = 400
+			data, _ := json.Marshal(&concurrent)
+			mr.Set(paymentRecordKey("pay_1"), string(data))
+		}
+		record.Provider = "stripe"
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("fn ran %d times, want 2", calls)
+	}
+
+	record, err := GetPaymentRecord("pay_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if record.RefundedAmount != 400 || record.Provider != "stripe" {
+		t.Errorf("record = %+v, want both the concurrent refund and the provider", record)
+	}
+}
+
+func TestCompletePaymentKeepsConcurrentUpdates(t *testing.T) {
+	useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", Status: PROCESSING.String(), Amount: 1000, RefundedAmount: 250}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	record := CompletePayment("pay_1", map[string]string{"status": SUCCESS.String()}, func(record *PaymentRecord) {
+		record.Status = SUCCESS.String()
+	})
+	if record == nil {
+		t.Fatal("CompletePayment returned nil")
+	}
+
+	stored, err := GetPaymentRecord("pay_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if stored.Status != SUCCESS.String() || stored.Amount != 1000 || stored.RefundedAmount != 250 {
+		t.Errorf("stored record = %+v, want SUCCESS with amount and refunds kept", stored)
+	}
+}
+
+func TestCompletePaymentLeavesRecordOnReadError(t *testing.T) {
+	mr := useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", Status: PROCESSING.String(), Amount: 1000}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	record := CompletePayment("pay_1", map[string]string{"status": SUCCESS.String()}, func(record *PaymentRecord) {
+		record.Status = SUCCESS.String()
+	})
+	mr.SetError("")
+	if record != nil {
+		t.Errorf("CompletePayment = %+v, want nil when the record can't be read", record)
+	}
+
+	stored, err := GetPaymentRecord("pay_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if stored.Amount != 1000 || stored.Status != PROCESSING.String() {
+		t.Errorf("stored record = %+v, want it untouched", stored)
+	}
+}
+
+func TestPaymentStatusHandlerHidesOtherMerchantsPayments(t *testing.T) {
+	useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", MerchantID: "merchant_a", Status: SUCCESS.String(), Amount: 1000}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	tests := []struct {
+		merchant string
+		want     int
+	}{
+		{"merchant_a", http.StatusOK},
+		{"merchant_b", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/payment/pay_1", nil)
+		req.SetPathValue("payment_id", "pay_1")
+		req = req.WithContext(context.WithValue(req.Context(), "api_key", tt.merchant))
+		rec := httptest.NewRecorder()
+		PaymentStatusHandler(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.merchant, rec.Code, tt.want)
+		}
+	}
+}
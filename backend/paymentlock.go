@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Payment state is kept per instance, so two instances receiving the same payment_id
+// at once could both move it to PROCESSING and route it. A payment is therefore only
+// processed under a Redis lock. Each acquisition also takes the next fencing token for
+// the payment; the token is sent to gateways and checked before the outcome is
+// recorded, so an instance whose lock expired mid-flight can't overwrite the result of
+// the one that took over
+const (
+	// paymentLockTTL is how long a lock outlives an instance that stopped refreshing it
+	paymentLockTTL = 30 * time.Second
+	// paymentLockRefresh is how often a held lock's TTL is extended
+	paymentLockRefresh = paymentLockTTL / 3
+	// paymentFenceTTL is how long a payment's fencing counter is kept after its last
+	// acquisition. It outlives the payment record, so tokens keep increasing for as long
+	// as the payment can be processed
+	paymentFenceTTL = paymentRecordTTL
+)
+
+// ErrPaymentLocked means another instance is processing the payment
+var ErrPaymentLocked = errors.New("payment is being processed by another instance")
+
+func paymentLockKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "payment_lock:" + paymentID
+}
+
+func paymentFenceKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "payment_fence:" + paymentID
+}
+
+// acquirePaymentLockScript takes the lock if it is free and returns the next fencing
+// token, or 0 if the lock is held. The fencing counter expires ARGV[3] milliseconds
+// after the last acquisition, long after any lock holder could still be running
+var acquirePaymentLockScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	local token = redis.call('INCR', KEYS[2])
+	redis.call('PEXPIRE', KEYS[2], ARGV[3])
+	return token
+end
+return 0
+`)
+
+// refreshPaymentLockScript extends the lock's TTL if it is still ours
+var refreshPaymentLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releasePaymentLockScript deletes the lock if it is still ours
+var releasePaymentLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// PaymentLock is a held payment lock. It is refreshed in the background until released
+type PaymentLock struct {
+	PaymentID string
+	// Token increases with every acquisition of the payment's lock
+	Token int64
+
+	value string // Identifies this acquisition, so only it can refresh or release the lock
+	lost  int32
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// acquirePaymentLock locks a payment for processing, failing with ErrPaymentLocked if
+// another instance holds it
+func acquirePaymentLock(ctx context.Context, paymentID string) (*PaymentLock, error) {
+	value := uuid.NewString()
+	token, err := acquirePaymentLockScript.Run(ctx, rdb,
+		[]string{paymentLockKey(paymentID), paymentFenceKey(paymentID)},
+		value, paymentLockTTL.Milliseconds(), paymentFenceTTL.Milliseconds()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("lock payment %s: %w", paymentID, err)
+	}
+	if token == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPaymentLocked, paymentID)
+	}
+
+	lock := &PaymentLock{PaymentID: paymentID, Token: token, value: value, stop: make(chan struct{})}
+	paymentsInFlight.Add(1)
+	go lock.keepAlive()
+	return lock, nil
+}
+
+// keepAlive refreshes the lock until it is released or found to be lost
+func (l *PaymentLock) keepAlive() {
+	ticker := time.NewTicker(paymentLockRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			refreshed, err := refreshPaymentLockScript.Run(ctx, rdb, []string{paymentLockKey(l.PaymentID)},
+				l.value, paymentLockTTL.Milliseconds()).Int64()
+			if err != nil {
+				// The lock is still ours until its TTL runs out; try again next tick
+				log.Printf("[PaymentLock] Failed to refresh lock on %s: %v", l.PaymentID, err)
+				continue
+			}
+			if refreshed == 0 {
+				atomic.StoreInt32(&l.lost, 1)
+				log.Printf("[PaymentLock] Lost lock on %s (fencing token %d)", l.PaymentID, l.Token)
+				return
+			}
+		}
+	}
+}
+
+// Held reports whether no other instance has locked the payment since this lock was
+// taken, i.e. whether its outcome may still be recorded. A nil lock is always held
+func (l *PaymentLock) Held(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	current, err := rdb.Get(ctx, paymentFenceKey(l.PaymentID)).Int64()
+	if err != nil {
+		// Redis can't be reached; go by the last refresh
+		return atomic.LoadInt32(&l.lost) == 0
+	}
+	return current == l.Token
+}
+
+// Release stops refreshing the lock and frees it for other instances. It is safe to
+// call more than once and on a nil lock
+func (l *PaymentLock) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.stop)
+		paymentsInFlight.Add(-1)
+		if err := releasePaymentLockScript.Run(ctx, rdb, []string{paymentLockKey(l.PaymentID)}, l.value).Err(); err != nil {
+			log.Printf("[PaymentLock] Failed to release lock on %s, it expires in %v: %v", l.PaymentID, paymentLockTTL, err)
+		}
+	})
+}
+
+// withFencingToken carries a payment lock's fencing token to the gateway requests
+func withFencingToken(ctx context.Context, lock *PaymentLock) context.Context {
+	if lock == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, "fencing_token", strconv.FormatInt(lock.Token, 10))
+}
+
+func fencingTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value("fencing_token").(string)
+	return token
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPaymentLockTokensIncreaseAcrossAcquisitions(t *testing.T) {
+	mr := useTestRedis(t)
+
+	first, err := acquirePaymentLock(ctx, "pay_1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := acquirePaymentLock(ctx, "pay_1"); !errors.Is(err, ErrPaymentLocked) {
+		t.Fatalf("second acquire while held: got %v, want ErrPaymentLocked", err)
+	}
+	first.Release()
+
+	second, err := acquirePaymentLock(ctx, "pay_1")
+	if err != nil {
+		t.Fatalf("re-acquire: %v", err)
+	}
+	defer second.Release()
+	if second.Token <= first.Token {
+		t.Errorf("token after re-acquire = %d, want more than %d", second.Token, first.Token)
+	}
+
+	if ttl := mr.TTL(paymentFenceKey("pay_1")); ttl <= 0 || ttl > paymentFenceTTL {
+		t.Errorf("fence key TTL = %v, want (0, %v]", ttl, paymentFenceTTL)
+	}
+}
+
+func TestPaymentLockHeldRejectsStaleToken(t *testing.T) {
+	mr := useTestRedis(t)
+
+	stale, err := acquirePaymentLock(ctx, "pay_1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer stale.Release()
+	if !stale.Held(ctx) {
+		t.Fatal("freshly acquired lock is not held")
+	}
+
+	// The lock expires while its holder is still running and another instance takes over
+	mr.Del(paymentLockKey("pay_1"))
+	current, err := acquirePaymentLock(ctx, "pay_1")
+	if err != nil {
+		t.Fatalf("take over: %v", err)
+	}
+	defer current.Release()
+
+	if stale.Held(ctx) {
+		t.Error("Held() accepted the stale token after another instance took the lock")
+	}
+	if !current.Held(ctx) {
+		t.Error("Held() rejected the current token")
+	}
+}
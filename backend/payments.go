@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PaymentFilter holds query parameters for listing payments
+type PaymentFilter struct {
+	MerchantID string // Only this merchant's payments; empty for all
+	Status     string
+	Provider   string
+	From       *time.Time
+	To         *time.Time
+	MinAmount  *int64
+	MaxAmount  *int64
+	Limit      int
+	Offset     int
+	TestMode   bool // Query test-mode payments instead of live ones
+}
+
+// ErrPaymentRecordNotFound is returned when a payment has no stored row
+var ErrPaymentRecordNotFound = errors.New("payment not found")
+
+// StorePayment upserts a payment row and records a transition when the status changed
+func StorePayment(record *PaymentRecord, fromStatus string) error {
+	if dataStore == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	return dataStore.StorePayment(record, fromStatus)
+}
+
+// QueryPayments returns payments matching the filter along with the total match count
+func QueryPayments(filter PaymentFilter) ([]PaymentRecord, int, error) {
+	if dataStore == nil {
+		return nil, 0, fmt.Errorf("database connection is nil")
+	}
+	return dataStore.QueryPayments(filter)
+}
+
+// parsePaymentFilter builds a PaymentFilter from query parameters
+func parsePaymentFilter(r *http.Request) (PaymentFilter, error) {
+	q := r.URL.Query()
+	filter := PaymentFilter{
+		MerchantID: scopedMerchantID(r),
+		Status:     strings.ToUpper(q.Get("status")),
+		Provider:   q.Get("provider"),
+		Limit:      50,
+		TestMode:   testModeEnabled(r.Context()),
+	}
+
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %v", err)
+		}
+		filter.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %v", err)
+		}
+		filter.To = &t
+	}
+	if v := q.Get("min_amount"); v != "" {
+		amount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid min_amount: %v", err)
+		}
+		filter.MinAmount = &amount
+	}
+	if v := q.Get("max_amount"); v != "" {
+		amount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid max_amount: %v", err)
+		}
+		filter.MaxAmount = &amount
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		if limit > 500 {
+			limit = 500
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", v)
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// PaymentsHandler handles GET /payments with filtering and pagination
+func PaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parsePaymentFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payments, total, err := QueryPayments(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch payments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"payments": payments,
+		"total":    total,
+		"limit":    filter.Limit,
+		"offset":   filter.Offset,
+	})
+}
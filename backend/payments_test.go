@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParsePaymentFilterScopesToCaller(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []Scope
+		want   string
+	}{
+		{"merchant key ignores merchant_id", []Scope{ScopePaymentsRead}, "merchant_a"},
+		{"admin key reads another merchant", []Scope{ScopeAdmin}, "merchant_b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/payments?merchant_id=merchant_b", nil)
+			c := context.WithValue(r.Context(), "api_key", "merchant_a")
+			c = context.WithValue(c, "scopes", tt.scopes)
+			filter, err := parsePaymentFilter(r.WithContext(c))
+			if err != nil {
+				t.Fatalf("parsePaymentFilter: %v", err)
+			}
+			if filter.MerchantID != tt.want {
+				t.Errorf("MerchantID = %q, want %q", filter.MerchantID, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPaymentsFiltersByMerchant(t *testing.T) {
+	store, mock := newMockStore(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM payments WHERE COALESCE(NULLIF(merchant_id, ''), 'default') = $1`)).
+		WithArgs("merchant_a").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE COALESCE(NULLIF(merchant_id, ''), 'default') = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`)).
+		WithArgs("merchant_a", 50, 0).
+		WillReturnRows(sqlmock.NewRows(nil))
+
+	if _, _, err := store.QueryPayments(PaymentFilter{MerchantID: "merchant_a", Limit: 50}); err != nil {
+		t.Fatalf("QueryPayments: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
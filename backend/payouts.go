@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payout states
+const (
+	PayoutPending    = "PENDING"
+	PayoutProcessing = "PROCESSING"
+	PayoutPaid       = "PAID"
+	PayoutFailed     = "FAILED"
+	PayoutRejected   = "REJECTED"
+)
+
+// PENDING -> PROCESSING,REJECTED
+// PROCESSING -> PAID,FAILED
+var payoutTransitions = map[string][]string{
+	PayoutPending:    {PayoutProcessing, PayoutRejected},
+	PayoutProcessing: {PayoutPaid, PayoutFailed},
+}
+
+var (
+	ErrPayoutNotFound          = errors.New("payout not found")
+	ErrInvalidPayoutTransition = errors.New("invalid payout state change")
+)
+
+// payoutTimeout bounds a single provider payout attempt
+const payoutTimeout = 10 * time.Second
+
+// Payout is a disbursement of merchant funds to an external destination
+type Payout struct {
+	ID              string    `json:"id"`
+	Reference       string    `json:"reference"`
+	MerchantID      string    `json:"merchant_id"`
+	UserID          string    `json:"user_id,omitempty"`
+	Amount          int64     `json:"amount"`
+	Currency        string    `json:"currency"`
+	Destination     string    `json:"destination"`
+	BeneficiaryName string    `json:"beneficiary_name,omitempty"`
+	Status          string    `json:"status"`
+	Provider        string    `json:"provider,omitempty"`
+	ProviderTxnID   string    `json:"provider_txn_id,omitempty"`
+	ErrorCode       string    `json:"error_code,omitempty"`
+	ErrorMessage    string    `json:"error_message,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// PayoutStore persists payouts
+type PayoutStore interface {
+	CreatePayout(p *Payout) error
+	GetPayout(id string) (*Payout, error)
+	GetPayoutByReference(merchantID, reference string) (*Payout, error)
+	// UpdatePayout saves p only if its stored status still equals fromStatus
+	UpdatePayout(p *Payout, fromStatus string) error
+	ListPayouts(status string) ([]Payout, error)
+}
+
+// TransitionPayout moves a payout to a new state
+func TransitionPayout(p *Payout, to string) error {
+	allowed := false
+	for _, next := range payoutTransitions[p.Status] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidPayoutTransition, p.Status, to)
+	}
+
+	from := p.Status
+	p.Status = to
+	p.UpdatedAt = time.Now().UTC()
+	if err := dataStore.UpdatePayout(p, from); err != nil {
+		p.Status = from
+		return err
+	}
+
+	log.Printf("[Payouts] %s moved %s -> %s", p.ID, from, to)
+	return nil
+}
+
+// screenPayout runs an AML check on payouts that trip the compliance rules and
+// screens the beneficiary against sanctions lists
+func screenPayout(ctx context.Context, p *Payout) error {
+	if p.UserID != "" && len(complianceRules.RequiresCheck(ctx, "", p.MerchantID, p.Currency, p.UserID, p.Amount)) > 0 {
+		resp, _, err := checkCompliance(ctx, "", &ComplianceCheckRequest{
+			UserID:         p.UserID,
+			CheckType:      ComplianceCheckAML,
+			IdempotencyKey: p.ID + "_aml",
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Status != ComplianceStatusApproved {
+			return fmt.Errorf("AML screening returned %s", resp.Status)
+		}
+	}
+
+	if sanctionsScreener != nil && sanctionsScreener.NeedsPayoutScreening(p.Amount) {
+		decision, err := sanctionsScreener.Screen(ctx, "payout", &ScreeningRequest{
+			Reference: p.ID,
+			Name:      p.BeneficiaryName,
+		})
+		if err != nil {
+			return fmt.Errorf("sanctions screening failed: %w", err)
+		}
+		// Payouts can't be pulled back, so potential matches are rejected too
+		if decision.Result.Outcome != ScreeningClear {
+			return errors.New(decision.Reason())
+		}
+	}
+	return nil
+}
+
+// processPayout routes a payout to payout-capable providers in priority order,
+// failing over to the next provider on retryable errors
+func processPayout(p *Payout, correlationID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in processPayout for %s: %v", p.ID, r)
+		}
+	}()
+
+	if err := TransitionPayout(p, PayoutProcessing); err != nil {
+		log.Printf("[Payouts] Failed to start %s: %v", p.ID, err)
+		return
+	}
+
+	req := &PayoutRequest{
+		ID:              p.ID,
+		Amount:          p.Amount,
+		Currency:        p.Currency,
+		Destination:     p.Destination,
+		BeneficiaryName: p.BeneficiaryName,
+		UserID:          p.UserID,
+		IdempotencyKey:  p.ID,
+	}
+
+	var lastErr error
+	providers, err := providerRegistry.GetEligiblePayoutProviders(req)
+	if err != nil {
+		lastErr = err
+	}
+
+	for _, config := range providers {
+		payoutProvider := config.Provider.(PayoutProvider)
+		name := config.Provider.Name()
+
+		var resp *PayoutResponse
+		ctx, cancel := context.WithTimeout(withTraceIDs(context.Background(), correlationID, p.ID), payoutTimeout)
+		err := config.Bulkhead.Execute(ctx, func() error {
+			return config.CircuitBreaker.Execute(ctx, func() error {
+				var err error
+				resp, err = payoutProvider.Payout(ctx, req)
+				return err
+			})
+		})
+		cancel()
+
+		if err == nil {
+			p.Provider = name
+			p.ProviderTxnID = resp.ProviderTxnID
+			p.ErrorCode = ""
+			p.ErrorMessage = ""
+			if err := TransitionPayout(p, PayoutPaid); err != nil {
+				log.Printf("[Payouts] Failed to mark %s paid: %v", p.ID, err)
+				return
+			}
+			if l := GetLedger(); l != nil {
+				if err := l.RecordPayout(p); err != nil {
+					log.Printf("[Ledger] Failed to record payout %s: %v", p.ID, err)
+				}
+			}
+			return
+		}
+
+		lastErr = err
+		p.Provider = name
+		log.Printf("[Payouts] %s failed on %s: %v", p.ID, name, err)
+
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && !providerErr.Retryable {
+			break
+		}
+	}
+
+	p.ErrorCode = string(ErrProviderError)
+	var providerErr *ProviderError
+	if errors.As(lastErr, &providerErr) {
+		p.ErrorCode = string(providerErr.CanonicalCode)
+	}
+	if lastErr != nil {
+		p.ErrorMessage = lastErr.Error()
+	}
+	if err := TransitionPayout(p, PayoutFailed); err != nil {
+		log.Printf("[Payouts] Failed to mark %s failed: %v", p.ID, err)
+	}
+}
+
+func writePayoutJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// PayoutsHandler handles POST /payouts and GET /payouts?status=
+func PayoutsHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Payouts not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req PayoutRequest
+		if err := decodeStrict(r.Body, &req); err != nil {
+			writeRequestError(w, PayoutRejected, err)
+			return
+		}
+		if req.Currency == "" {
+			req.Currency = "USD"
+		}
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = req.ID
+		}
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, PayoutRejected, err)
+			return
+		}
+		if req.BeneficiaryName == "" && sanctionsScreener != nil && sanctionsScreener.NeedsPayoutScreening(req.Amount) {
+			http.Error(w, "beneficiary_name is required for sanctions screening", http.StatusBadRequest)
+			return
+		}
+
+		merchantID := merchantIDFromContext(r.Context())
+		if existing, err := dataStore.GetPayoutByReference(merchantID, req.ID); err == nil {
+			w.Header().Set("X-Idempotent-Replay", "true")
+			writePayoutJSON(w, http.StatusOK, existing)
+			return
+		}
+
+		now := time.Now().UTC()
+		p := &Payout{
+			ID:              "po_" + uuid.NewString(),
+			Reference:       req.ID,
+			MerchantID:      merchantID,
+			UserID:          req.UserID,
+			Amount:          req.Amount,
+			Currency:        strings.ToUpper(req.Currency),
+			Destination:     req.Destination,
+			BeneficiaryName: req.BeneficiaryName,
+			Status:          PayoutPending,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		}
+		if err := dataStore.CreatePayout(p); err != nil {
+			http.Error(w, "Failed to create payout", http.StatusInternalServerError)
+			return
+		}
+
+		if err := screenPayout(withTraceIDs(r.Context(), "", p.ID), p); err != nil {
+			p.ErrorCode = string(ErrComplianceFailed)
+			p.ErrorMessage = err.Error()
+			if err := TransitionPayout(p, PayoutRejected); err != nil {
+				log.Printf("[Payouts] Failed to reject %s: %v", p.ID, err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrComplianceFailed,
+				"Payout failed compliance screening",
+				PayoutRejected,
+				err.Error(),
+			))
+			return
+		}
+
+		correlationID, _ := r.Context().Value("correlation_id").(string)
+		go processPayout(p, correlationID)
+
+		writePayoutJSON(w, http.StatusAccepted, p)
+
+	case http.MethodGet:
+		payouts, err := dataStore.ListPayouts(strings.ToUpper(r.URL.Query().Get("status")))
+		if err != nil {
+			http.Error(w, "Failed to fetch payouts", http.StatusInternalServerError)
+			return
+		}
+		writePayoutJSON(w, http.StatusOK, map[string]interface{}{
+			"payouts": payouts,
+			"total":   len(payouts),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PayoutHandler handles GET /payouts/{payout_id}
+func PayoutHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Payouts not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	p, err := dataStore.GetPayout(r.PathValue("payout_id"))
+	if err == nil && !ownedByCaller(r.Context(), p.MerchantID) {
+		// Another merchant's payout is reported as missing rather than forbidden
+		err = ErrPayoutNotFound
+	}
+	if errors.Is(err, ErrPayoutNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch payout", http.StatusInternalServerError)
+		return
+	}
+
+	writePayoutJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPayoutHandlerHidesOtherMerchantsPayouts(t *testing.T) {
+	tests := []struct {
+		merchant string
+		want     int
+	}{
+		{"merchant_a", http.StatusOK},
+		{"merchant_b", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.merchant, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			dataStore = store
+			t.Cleanup(func() { dataStore = nil })
+
+			now := time.Now()
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM payouts WHERE id = $1`)).
+				WithArgs("po_1").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "reference", "merchant_id", "user_id", "amount", "currency", "destination",
+					"beneficiary_name", "status", "provider", "provider_txn_id", "error_code", "error_message", "created_at", "updated_at"}).
+					AddRow("po_1", "ref_1", "merchant_a", "", 5000, "USD", "acct_1", "", "PAID", "stripe", "", "", "", now, now))
+
+			req := httptest.NewRequest(http.MethodGet, "/payouts/po_1", nil)
+			req.SetPathValue("payout_id", "po_1")
+			req = req.WithContext(context.WithValue(req.Context(), "api_key", tt.merchant))
+			rec := httptest.NewRecorder()
+			PayoutHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
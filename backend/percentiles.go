@@ -1,147 +1,231 @@
 package main
 
 import (
-	"sort"
+	"math/bits"
 	"sync"
 	"time"
 )
 
-// LatencyTracker tracks latency percentiles using a sliding window
+// Latencies are bucketed on a log-linear scale in microseconds, in the style of an HDR
+// histogram: values below latencySubBuckets get a bucket each, and every power of two
+// above that is split into latencySubBuckets/2 equal buckets. That bounds the relative
+// error of any percentile to under 2%, whatever the sample count
+const (
+	latencySubBucketBits = 6
+	latencySubBuckets    = 1 << latencySubBucketBits
+	latencyMaxBits       = 27
+	latencyMaxMicros     = 1<<latencyMaxBits - 1 // ~134s, longer samples are clamped
+	latencyBucketCount   = (latencyMaxBits-latencySubBucketBits)*latencySubBuckets/2 + latencySubBuckets
+)
+
+// defaultLatencyWindow is how far back percentiles look
+const defaultLatencyWindow = 5 * time.Minute
+
+// latencyBucketIndex maps a latency in microseconds to its histogram bucket
+func latencyBucketIndex(micros uint64) int {
+	if micros < latencySubBuckets {
+		return int(micros)
+	}
+	if micros > latencyMaxMicros {
+		micros = latencyMaxMicros
+	}
+	shift := bits.Len64(micros) - latencySubBucketBits
+	sub := micros >> shift // in [latencySubBuckets/2, latencySubBuckets)
+	return shift*latencySubBuckets/2 + int(sub)
+}
+
+// latencyBucketValue returns the midpoint of a bucket in microseconds
+func latencyBucketValue(index int) uint64 {
+	if index < latencySubBuckets {
+		return uint64(index)
+	}
+	half := latencySubBuckets / 2
+	shift := index/half - 1
+	sub := uint64(index%half + half)
+	return sub<<shift + (uint64(1)<<shift)/2
+}
+
+// latencyWindow holds one minute of samples
+type latencyWindow struct {
+	minute int64 // Unix minute the window covers
+	counts map[int]uint32
+	count  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// LatencyTracker tracks latency percentiles over a sliding time window. Samples land
+// in one-minute windows and are also added to a running histogram of the whole
+// window; when a minute ages out its counts are subtracted again. Reads walk that
+// fixed-size histogram, so their cost doesn't grow with traffic and memory stays
+// bounded however many samples arrive
 type LatencyTracker struct {
-	samples    []time.Duration
-	maxSamples int
-	mu         sync.RWMutex
+	windows []latencyWindow
+	total   []uint32 // histogram of every live window
+	count   int64
+	mu      sync.Mutex
 }
 
-// NewLatencyTracker creates a new latency tracker
-func NewLatencyTracker(maxSamples int) *LatencyTracker {
+// NewLatencyTracker creates a tracker covering the given window, rounded up to whole
+// minutes
+func NewLatencyTracker(window time.Duration) *LatencyTracker {
+	minutes := int((window + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
 	return &LatencyTracker{
-		samples:    make([]time.Duration, 0, maxSamples),
-		maxSamples: maxSamples,
+		windows: make([]latencyWindow, minutes),
+		total:   make([]uint32, latencyBucketCount),
 	}
 }
 
+// expire drops windows that have aged out as of now. Callers must hold lt.mu
+func (lt *LatencyTracker) expire(now time.Time) int64 {
+	minute := now.Unix() / 60
+	oldest := minute - int64(len(lt.windows)) + 1
+	for i := range lt.windows {
+		w := &lt.windows[i]
+		if w.count == 0 || w.minute >= oldest {
+			continue
+		}
+		for index, n := range w.counts {
+			lt.total[index] -= n
+		}
+		lt.count -= w.count
+		*w = latencyWindow{}
+	}
+	return minute
+}
+
 // AddSample records a new latency sample
 func (lt *LatencyTracker) AddSample(latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+
 	lt.mu.Lock()
 	defer lt.mu.Unlock()
 
-	lt.samples = append(lt.samples, latency)
+	minute := lt.expire(time.Now())
+	w := &lt.windows[minute%int64(len(lt.windows))]
+	if w.count == 0 {
+		*w = latencyWindow{minute: minute, counts: make(map[int]uint32), min: latency, max: latency}
+	}
 
-	// Keep only the most recent samples
-	if len(lt.samples) > lt.maxSamples {
-		// Remove oldest samples
-		excess := len(lt.samples) - lt.maxSamples
-		lt.samples = lt.samples[excess:]
+	index := latencyBucketIndex(uint64(latency.Microseconds()))
+	w.counts[index]++
+	w.count++
+	w.sum += latency
+	if latency < w.min {
+		w.min = latency
 	}
+	if latency > w.max {
+		w.max = latency
+	}
+	lt.total[index]++
+	lt.count++
 }
 
 // GetPercentiles calculates P50, P95, and P99 latencies
 func (lt *LatencyTracker) GetPercentiles() LatencyPercentiles {
-	lt.mu.RLock()
-	defer lt.mu.RUnlock()
-
-	if len(lt.samples) == 0 {
-		return LatencyPercentiles{
-			P50: 0,
-			P95: 0,
-			P99: 0,
-		}
-	}
-
-	// Create a sorted copy
-	sorted := make([]time.Duration, len(lt.samples))
-	copy(sorted, lt.samples)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i] < sorted[j]
-	})
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	return LatencyPercentiles{
-		P50: percentile(sorted, 50),
-		P95: percentile(sorted, 95),
-		P99: percentile(sorted, 99),
+	lt.expire(time.Now())
+	if lt.count == 0 {
+		return LatencyPercentiles{}
 	}
-}
 
-// percentile calculates the p-th percentile from sorted samples
-func percentile(sorted []time.Duration, p float64) time.Duration {
-	if len(sorted) == 0 {
-		return 0
+	targets := [3]float64{50, 95, 99}
+	var values [3]time.Duration
+	next := 0
+	var seen int64
+	for index, n := range lt.total {
+		if n == 0 {
+			continue
+		}
+		seen += int64(n)
+		for next < len(targets) && float64(seen) >= targets[next]/100*float64(lt.count) {
+			values[next] = time.Duration(latencyBucketValue(index)) * time.Microsecond
+			next++
+		}
+		if next == len(targets) {
+			break
+		}
 	}
 
-	if p < 0 {
-		p = 0
-	}
-	if p > 100 {
-		p = 100
+	// Bucket midpoints can overshoot the real extremes
+	min, max := lt.minMax()
+	for i := range values {
+		if values[i] < min {
+			values[i] = min
+		}
+		if values[i] > max {
+			values[i] = max
+		}
 	}
 
-	// Calculate index
-	index := (p / 100.0) * float64(len(sorted)-1)
-	lower := int(index)
-	upper := lower + 1
-
-	if upper >= len(sorted) {
-		return sorted[len(sorted)-1]
+	return LatencyPercentiles{
+		P50: values[0],
+		P95: values[1],
+		P99: values[2],
 	}
+}
 
-	// Linear interpolation
-	weight := index - float64(lower)
-	return time.Duration(float64(sorted[lower])*(1-weight) + float64(sorted[upper])*weight)
+// minMax returns the smallest and largest live samples. Callers must hold lt.mu
+func (lt *LatencyTracker) minMax() (time.Duration, time.Duration) {
+	var min, max time.Duration
+	first := true
+	for _, w := range lt.windows {
+		if w.count == 0 {
+			continue
+		}
+		if first || w.min < min {
+			min = w.min
+		}
+		if first || w.max > max {
+			max = w.max
+		}
+		first = false
+	}
+	return min, max
 }
 
 // GetAverage calculates average latency
 func (lt *LatencyTracker) GetAverage() time.Duration {
-	lt.mu.RLock()
-	defer lt.mu.RUnlock()
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	if len(lt.samples) == 0 {
+	lt.expire(time.Now())
+	if lt.count == 0 {
 		return 0
 	}
 
 	var total time.Duration
-	for _, sample := range lt.samples {
-		total += sample
+	for _, w := range lt.windows {
+		total += w.sum
 	}
-
-	return total / time.Duration(len(lt.samples))
+	return total / time.Duration(lt.count)
 }
 
 // GetMin returns minimum latency
 func (lt *LatencyTracker) GetMin() time.Duration {
-	lt.mu.RLock()
-	defer lt.mu.RUnlock()
-
-	if len(lt.samples) == 0 {
-		return 0
-	}
-
-	min := lt.samples[0]
-	for _, sample := range lt.samples {
-		if sample < min {
-			min = sample
-		}
-	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
+	lt.expire(time.Now())
+	min, _ := lt.minMax()
 	return min
 }
 
 // GetMax returns maximum latency
 func (lt *LatencyTracker) GetMax() time.Duration {
-	lt.mu.RLock()
-	defer lt.mu.RUnlock()
-
-	if len(lt.samples) == 0 {
-		return 0
-	}
-
-	max := lt.samples[0]
-	for _, sample := range lt.samples {
-		if sample > max {
-			max = sample
-		}
-	}
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
+	lt.expire(time.Now())
+	_, max := lt.minMax()
 	return max
 }
 
@@ -150,13 +234,18 @@ func (lt *LatencyTracker) Reset() {
 	lt.mu.Lock()
 	defer lt.mu.Unlock()
 
-	lt.samples = make([]time.Duration, 0, lt.maxSamples)
+	for i := range lt.windows {
+		lt.windows[i] = latencyWindow{}
+	}
+	lt.total = make([]uint32, latencyBucketCount)
+	lt.count = 0
 }
 
-// GetSampleCount returns the number of samples
+// GetSampleCount returns the number of samples in the window
 func (lt *LatencyTracker) GetSampleCount() int {
-	lt.mu.RLock()
-	defer lt.mu.RUnlock()
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
 
-	return len(lt.samples)
+	lt.expire(time.Now())
+	return int(lt.count)
 }
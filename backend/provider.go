@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"pulseberry/money"
 )
 
 // Provider defines the interface all payment provider adapters must implement
@@ -15,6 +23,26 @@ type Provider interface {
 	Capabilities() ProviderCapabilities
 }
 
+// PayoutProvider is implemented by providers that can send funds out.
+// Only providers whose capabilities declare SupportsPayouts are routed payouts
+type PayoutProvider interface {
+	Payout(ctx context.Context, req *PayoutRequest) (*PayoutResponse, error)
+}
+
+// BNPLProvider is implemented by providers offering Buy Now Pay Later.
+// Only providers whose capabilities declare SupportsBNPL are routed BNPL requests
+type BNPLProvider interface {
+	CreateBNPLSession(ctx context.Context, req *BNPLRequest) (*BNPLResponse, error)
+}
+
+// DescriptorProvider is implemented by providers that print a merchant-supplied
+// statement descriptor. FormatDescriptor checks a descriptor against the provider's
+// limits and returns the payload fields to send it in. Other providers print their
+// default descriptor
+type DescriptorProvider interface {
+	FormatDescriptor(d StatementDescriptor) (map[string]string, error)
+}
+
 // ProviderError wraps provider-specific errors with normalized codes
 type ProviderError struct {
 	CanonicalCode CanonicalErrorCode
@@ -66,6 +94,8 @@ func NewMockStripeProvider(baseURL string) *MockStripeProvider {
 			capabilities: ProviderCapabilities{
 				SupportsRefunds:     true,
 				SupportsBNPL:        false,
+				SupportsPayouts:     true,
+				SupportsIdempotency: true,
 				ComplianceReady:     true,
 				MaxAmountCents:      99999999, // $999,999.99
 				MinAmountCents:      50,       // $0.50
@@ -78,21 +108,32 @@ func NewMockStripeProvider(baseURL string) *MockStripeProvider {
 }
 
 func (p *MockStripeProvider) Charge(ctx context.Context, req *PaymentRequest) (*PaymentResponse, error) {
-	// Validate amount against capabilities
-	if req.Amount < p.capabilities.MinAmountCents {
+	// Validate amount against capabilities, scaled to the currency's minor unit
+	minAmount, maxAmount, err := money.ScaleLimits(req.Currency, p.capabilities.MinAmountCents, p.capabilities.MaxAmountCents)
+	if err != nil {
+		return nil, NewProviderError(
+			ErrCodeInvalidRequest,
+			"currency_not_supported",
+			fmt.Sprintf("Currency %s is not supported by this provider", req.Currency),
+			err,
+		)
+	}
+	currency, _ := money.Lookup(req.Currency)
+
+	if req.Amount < minAmount {
 		return nil, NewProviderError(
 			ErrCodeInvalidRequest,
 			"amount_too_small",
-			fmt.Sprintf("Amount must be at least %d cents", p.capabilities.MinAmountCents),
+			fmt.Sprintf("Amount must be at least %s", currency.Format(minAmount)),
 			nil,
 		)
 	}
 
-	if req.Amount > p.capabilities.MaxAmountCents {
+	if req.Amount > maxAmount {
 		return nil, NewProviderError(
 			ErrCodeInvalidRequest,
 			"amount_too_large",
-			fmt.Sprintf("Amount must not exceed %d cents", p.capabilities.MaxAmountCents),
+			fmt.Sprintf("Amount must not exceed %s", currency.Format(maxAmount)),
 			nil,
 		)
 	}
@@ -102,6 +143,34 @@ func (p *MockStripeProvider) Charge(ctx context.Context, req *PaymentRequest) (*
 	return nil, errors.New("not yet implemented - use legacy gateway")
 }
 
+// Stripe prints "DESCRIPTOR* SUFFIX" within 22 characters and rejects < > \ ' " *
+const stripeDescriptorForbidden = `<>\'"*`
+
+// FormatDescriptor sends the descriptor as statement_descriptor and the suffix as
+// statement_descriptor_suffix, shortening the suffix to fit next to the descriptor
+func (p *MockStripeProvider) FormatDescriptor(d StatementDescriptor) (map[string]string, error) {
+	if strings.ContainsAny(d.Descriptor+d.Suffix, stripeDescriptorForbidden) {
+		return nil, fmt.Errorf("descriptor must not contain any of %s", stripeDescriptorForbidden)
+	}
+	fields := make(map[string]string, 2)
+	suffix := d.Suffix
+	if d.Descriptor != "" {
+		if len(d.Descriptor) < minDescriptorLength || len(d.Descriptor) > maxDescriptorLength {
+			return nil, fmt.Errorf("statement_descriptor must be %d to %d characters", minDescriptorLength, maxDescriptorLength)
+		}
+		fields["statement_descriptor"] = d.Descriptor
+		// Room left after "DESCRIPTOR* "
+		room := maxDescriptorLength - len(d.Descriptor) - 2
+		if len(suffix) > room {
+			suffix = strings.TrimSpace(suffix[:max(room, 0)])
+		}
+	}
+	if suffix != "" {
+		fields["statement_descriptor_suffix"] = suffix
+	}
+	return fields, nil
+}
+
 func (p *MockStripeProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResponse, error) {
 	if !p.capabilities.SupportsRefunds {
 		return nil, NewProviderError(
@@ -112,15 +181,15 @@ func (p *MockStripeProvider) Refund(ctx context.Context, req *RefundRequest) (*R
 		)
 	}
 
-	// Mock implementation
-	return nil, errors.New("not yet implemented")
+	return sendGatewayRefund(ctx, p.name, p.baseURL+"/refunds", req)
 }
 
 func (p *MockStripeProvider) HealthCheck(ctx context.Context) (*HealthStatus, error) {
-	// In real implementation, ping Stripe health endpoint
-	return &HealthStatus{
-		Healthy: true,
-	}, nil
+	return checkHealthEndpoint(ctx, p.name, p.baseURL+"/health")
+}
+
+func (p *MockStripeProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResponse, error) {
+	return sendGatewayPayout(ctx, p.name, p.baseURL+"/payouts", req)
 }
 
 // MockRazorpayProvider simulates Razorpay payment provider
@@ -136,6 +205,8 @@ func NewMockRazorpayProvider(baseURL string) *MockRazorpayProvider {
 			capabilities: ProviderCapabilities{
 				SupportsRefunds:     true,
 				SupportsBNPL:        false,
+				SupportsPayouts:     true,
+				SupportsIdempotency: true,
 				ComplianceReady:     true,
 				MaxAmountCents:      10000000, // 1,00,000 INR
 				MinAmountCents:      100,      // 1 INR
@@ -169,14 +240,34 @@ func (p *MockRazorpayProvider) Charge(ctx context.Context, req *PaymentRequest)
 	return nil, errors.New("not yet implemented - use legacy gateway")
 }
 
+// razorpayDescriptorMax is the longest descriptor Razorpay prints
+const razorpayDescriptorMax = 20
+
+// FormatDescriptor sends the descriptor and suffix joined into Razorpay's single
+// descriptor field, which takes letters, digits and spaces only
+func (p *MockRazorpayProvider) FormatDescriptor(d StatementDescriptor) (map[string]string, error) {
+	descriptor := strings.TrimSpace(d.Descriptor + " " + d.Suffix)
+	for _, c := range descriptor {
+		if !(c == ' ' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return nil, fmt.Errorf("descriptor may only contain letters, digits and spaces, got %q", c)
+		}
+	}
+	if len(descriptor) > razorpayDescriptorMax {
+		descriptor = strings.TrimSpace(descriptor[:razorpayDescriptorMax])
+	}
+	return map[string]string{"descriptor": descriptor}, nil
+}
+
 func (p *MockRazorpayProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResponse, error) {
-	return nil, errors.New("not yet implemented")
+	return sendGatewayRefund(ctx, p.name, p.baseURL+"/refunds", req)
 }
 
 func (p *MockRazorpayProvider) HealthCheck(ctx context.Context) (*HealthStatus, error) {
-	return &HealthStatus{
-		Healthy: true,
-	}, nil
+	return checkHealthEndpoint(ctx, p.name, p.baseURL+"/health")
+}
+
+func (p *MockRazorpayProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResponse, error) {
+	return sendGatewayPayout(ctx, p.name, p.baseURL+"/payouts", req)
 }
 
 // MockKlarnaProvider simulates Klarna BNPL provider
@@ -192,6 +283,7 @@ func NewMockKlarnaProvider(baseURL string) *MockKlarnaProvider {
 			capabilities: ProviderCapabilities{
 				SupportsRefunds:     true,
 				SupportsBNPL:        true,
+				SupportsPayouts:     false,
 				ComplianceReady:     false,
 				MaxAmountCents:      1000000, // $10,000
 				MinAmountCents:      1000,    // $10
@@ -208,12 +300,207 @@ func (p *MockKlarnaProvider) Charge(ctx context.Context, req *PaymentRequest) (*
 }
 
 func (p *MockKlarnaProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResponse, error) {
-	return nil, errors.New("not yet implemented")
+	return sendGatewayRefund(ctx, p.name, p.baseURL+"/refunds", req)
+}
+
+// CreateBNPLSession opens a Klarna payment session and returns the customer approval URL
+func (p *MockKlarnaProvider) CreateBNPLSession(ctx context.Context, req *BNPLRequest) (*BNPLResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"purchase_amount":   req.Amount,
+		"purchase_currency": req.Currency,
+		"locale":            "en-US",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
+
+	resp, err := providerHTTPClient(p.name).Do(httpReq)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeNetworkError, "network_error", err.Error(), err)
+	}
+	defer resp.Body.Close()
+
+	var session struct {
+		SessionID string `json:"session_id"`
+		Error     string `json:"error"`
+		ErrorCode string `json:"error_code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "malformed_response", "Invalid JSON response", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || session.SessionID == "" {
+		code := ErrCodeProviderError
+		if resp.StatusCode == http.StatusTooManyRequests {
+			code = ErrCodeRateLimited
+		}
+		return nil, NewProviderError(code, session.ErrorCode, session.Error, nil)
+	}
+
+	return &BNPLResponse{
+		BNPLID:      session.SessionID,
+		Status:      "PENDING_APPROVAL",
+		Provider:    p.name,
+		ApprovalURL: fmt.Sprintf("%s/checkout?session_id=%s", p.baseURL, session.SessionID),
+		ProcessedAt: time.Now(),
+	}, nil
 }
 
 func (p *MockKlarnaProvider) HealthCheck(ctx context.Context) (*HealthStatus, error) {
-	return &HealthStatus{
-		Healthy: true,
+	return checkHealthEndpoint(ctx, p.name, p.baseURL+"/health")
+}
+
+// checkHealthEndpoint calls a gateway's health endpoint. Any 2xx response is healthy,
+// an error is returned only when the endpoint can't be reached
+func checkHealthEndpoint(ctx context.Context, provider, url string) (*HealthStatus, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := providerHTTPClient(provider).Do(httpReq)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeNetworkError, "network_error", err.Error(), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	status := &HealthStatus{
+		Healthy:   resp.StatusCode >= 200 && resp.StatusCode < 300,
+		Timestamp: time.Now(),
+		Latency:   time.Since(start).Milliseconds(),
+	}
+	if !status.Healthy {
+		status.Message = fmt.Sprintf("health endpoint returned %d", resp.StatusCode)
+	}
+	return status, nil
+}
+
+// sendGatewayPayout posts a payout to a simulated gateway and normalizes its response
+func sendGatewayPayout(ctx context.Context, provider, url string, req *PayoutRequest) (*PayoutResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":          req.ID,
+		"amount":      req.Amount,
+		"currency":    req.Currency,
+		"destination": req.Destination,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
+
+	resp, err := providerHTTPClient(provider).Do(httpReq)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeNetworkError, "network_error", err.Error(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "read_error", err.Error(), err)
+	}
+
+	var result struct {
+		Status    string `json:"status"`
+		ID        string `json:"id"`
+		Error     string `json:"error"`
+		ErrorCode string `json:"error_code"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "malformed_response", "Invalid JSON response", err)
+	}
+
+	if result.Status != "success" {
+		code := ErrCodeProviderError
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			code = ErrCodeRateLimited
+		case resp.StatusCode < 500 && result.ErrorCode != "":
+			code = CanonicalErrorCode(result.ErrorCode)
+		}
+		return nil, NewProviderError(code, result.ErrorCode, result.Error, nil)
+	}
+
+	return &PayoutResponse{
+		PayoutID:      req.ID,
+		Status:        "PAID",
+		ProviderTxnID: result.ID,
+		Provider:      provider,
+		ProcessedAt:   time.Now(),
+	}, nil
+}
+
+// sendGatewayRefund posts a refund to a simulated gateway and normalizes its response
+func sendGatewayRefund(ctx context.Context, provider, url string, req *RefundRequest) (*RefundResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         req.ID,
+		"payment_id": req.PaymentID,
+		"amount":     req.Amount,
+		"reason":     req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Idempotency-Key", req.IdempotencyKey)
+
+	resp, err := providerHTTPClient(provider).Do(httpReq)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeNetworkError, "network_error", err.Error(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "read_error", err.Error(), err)
+	}
+
+	var result struct {
+		Status    string `json:"status"`
+		ID        string `json:"id"`
+		Error     string `json:"error"`
+		ErrorCode string `json:"error_code"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "malformed_response", "Invalid JSON response", err)
+	}
+
+	// Stripe reports "succeeded", the other gateways "success"
+	if result.Status != "success" && result.Status != "succeeded" {
+		code := ErrCodeProviderError
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			code = ErrCodeRateLimited
+		case resp.StatusCode < 500 && result.ErrorCode != "":
+			code = CanonicalErrorCode(result.ErrorCode)
+		}
+		return nil, NewProviderError(code, result.ErrorCode, result.Error, nil)
+	}
+
+	return &RefundResponse{
+		RefundID:    result.ID,
+		Status:      RefundSucceeded,
+		Provider:    provider,
+		ProcessedAt: time.Now(),
 	}, nil
 }
 
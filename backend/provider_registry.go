@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"sync"
+
+	"pulseberry/money"
 )
 
 // ProviderPriority defines provider selection priority
@@ -15,6 +18,7 @@ const (
 	PriorityPrimary ProviderPriority = iota
 	PrioritySecondary
 	PriorityTertiary
+	PriorityDemoted // set by the SLA monitor, ranks below every configured priority
 )
 
 // ProviderConfig holds configuration for a registered provider
@@ -26,6 +30,11 @@ type ProviderConfig struct {
 	RateLimit      int // requests per second
 	CircuitBreaker *CircuitBreaker
 	SLA            SLAConfig
+	Fees           FeeSchedule
+	RetryPolicy    *RetryConfig // nil uses DefaultRetryConfig
+	MaxConcurrent  int          // in-flight request cap, 0 uses the bulkhead default
+	Bulkhead       *Bulkhead
+	Egress         EgressConfig // proxy and TLS settings for the provider's transport
 }
 
 // SLAConfig defines SLA parameters for a provider
@@ -34,6 +43,31 @@ type SLAConfig struct {
 	MinSuccessRate  float64 // Minimum acceptable success rate (0.0-1.0)
 }
 
+// Fee is a provider's charge for a single transaction
+type Fee struct {
+	Percentage float64 // Percentage of the amount, e.g. 2.9 for 2.9%
+	FixedCents int64   // Fixed charge in the currency's minor unit
+}
+
+// FeeSchedule defines provider pricing, optionally overridden per currency
+type FeeSchedule struct {
+	Default    Fee
+	ByCurrency map[string]Fee
+}
+
+// FeeFor returns the fee that applies to a currency
+func (fs FeeSchedule) FeeFor(currency string) Fee {
+	if fee, ok := fs.ByCurrency[currency]; ok {
+		return fee
+	}
+	return fs.Default
+}
+
+// Cost returns the fee for an amount in minor units, rounded up
+func (f Fee) Cost(amount int64) int64 {
+	return int64(math.Ceil(float64(amount)*f.Percentage/100)) + f.FixedCents
+}
+
 // ProviderRegistry manages all payment and compliance providers
 type ProviderRegistry struct {
 	paymentProviders    map[string]*ProviderConfig
@@ -75,6 +109,20 @@ func (pr *ProviderRegistry) RegisterPaymentProvider(config *ProviderConfig) erro
 		cbConfig := DefaultCircuitBreakerConfig()
 		config.CircuitBreaker = NewCircuitBreaker(name, cbConfig)
 	}
+	if !config.Egress.IsZero() {
+		if err := GetConnectionPoolManager().ConfigureEgress(name, config.Egress); err != nil {
+			return fmt.Errorf("egress config for %s: %w", name, err)
+		}
+	}
+
+	if config.Bulkhead == nil {
+		config.Bulkhead = NewBulkhead(name, config.MaxConcurrent, defaultBulkheadQueueTimeout)
+	}
+
+	// Share breaker decisions with the other backend instances
+	if rdb != nil {
+		config.CircuitBreaker.EnableSharedState(rdb)
+	}
 
 	pr.paymentProviders[name] = config
 	log.Printf("[ProviderRegistry] Registered payment provider: %s (priority: %d, enabled: %v)",
@@ -121,6 +169,87 @@ func (pr *ProviderRegistry) GetPaymentProvider(name string) (*ProviderConfig, er
 	return config, nil
 }
 
+// HasPaymentProvider reports whether a payment provider is registered, enabled or not
+func (pr *ProviderRegistry) HasPaymentProvider(name string) bool {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	_, exists := pr.paymentProviders[name]
+	return exists
+}
+
+// GetRetryPolicy returns a provider's retry policy, or the default if it has none
+func (pr *ProviderRegistry) GetRetryPolicy(name string) RetryConfig {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists || config.RetryPolicy == nil {
+		return DefaultRetryConfig()
+	}
+	return *config.RetryPolicy
+}
+
+// SetRetryPolicy replaces a provider's retry policy
+func (pr *ProviderRegistry) SetRetryPolicy(name string, policy RetryConfig) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists {
+		return fmt.Errorf("provider '%s' not found", name)
+	}
+
+	config.RetryPolicy = &policy
+	log.Printf("[ProviderRegistry] Updated retry policy for %s: max_attempts=%d base_delay=%v max_delay=%v",
+		name, policy.MaxAttempts, policy.BaseDelay, policy.MaxDelay)
+	return nil
+}
+
+// GetEgress returns a provider's egress settings
+func (pr *ProviderRegistry) GetEgress(name string) (EgressConfig, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists {
+		return EgressConfig{}, fmt.Errorf("provider '%s' not found", name)
+	}
+	return config.Egress, nil
+}
+
+// SetEgress applies new egress settings to a provider's transport. Invalid settings
+// leave the current transport in place
+func (pr *ProviderRegistry) SetEgress(name string, egress EgressConfig) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists {
+		return fmt.Errorf("provider '%s' not found", name)
+	}
+	if err := GetConnectionPoolManager().ConfigureEgress(name, egress); err != nil {
+		return err
+	}
+
+	config.Egress = egress
+	log.Printf("[ProviderRegistry] Updated egress for %s: proxy=%t ca_bundle=%t mtls=%t",
+		name, egress.ProxyURL != "", egress.CABundleFile != "", egress.ClientCertFile != "")
+	return nil
+}
+
+// GetBulkheadStats returns a snapshot of every payment provider's bulkhead
+func (pr *ProviderRegistry) GetBulkheadStats() []BulkheadStats {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	stats := make([]BulkheadStats, 0, len(pr.paymentProviders))
+	for _, config := range pr.paymentProviders {
+		stats = append(stats, config.Bulkhead.GetStats())
+	}
+	return stats
+}
+
 // GetComplianceProvider retrieves a compliance provider by name
 func (pr *ProviderRegistry) GetComplianceProvider(name string) (*ComplianceProviderConfig, error) {
 	pr.mu.RLock()
@@ -156,11 +285,21 @@ func (pr *ProviderRegistry) GetEligiblePaymentProviders(req *PaymentRequest) ([]
 			continue
 		}
 
+		if inMaintenance(config.Name) {
+			log.Printf("[ProviderRegistry] Skipping %s: in a maintenance window", config.Name)
+			continue
+		}
+
+		if req.Providers.Excludes(config.Name) {
+			log.Printf("[ProviderRegistry] Skipping %s: excluded by the merchant", config.Name)
+			continue
+		}
+
 		// Check capabilities
 		caps := config.Provider.Capabilities()
 
-		// Check amount limits
-		if req.Amount < caps.MinAmountCents || req.Amount > caps.MaxAmountCents {
+		// Check amount limits, scaled to the currency's minor unit
+		if !money.WithinLimits(req.Amount, req.Currency, caps.MinAmountCents, caps.MaxAmountCents) {
 			log.Printf("[ProviderRegistry] Skipping %s: amount %d outside limits [%d, %d]",
 				config.Name, req.Amount, caps.MinAmountCents, caps.MaxAmountCents)
 			continue
@@ -188,7 +327,118 @@ func (pr *ProviderRegistry) GetEligiblePaymentProviders(req *PaymentRequest) ([]
 		return nil, errors.New("no eligible providers found for this request")
 	}
 
-	// Sort by priority
+	// Sort by priority, then keep the merchant's preferred providers if any is eligible
+	pr.sortByPriority(eligible)
+	eligible = req.Providers.filterPreferred(eligible)
+
+	return eligible, nil
+}
+
+// GetEligiblePayoutProviders returns payout-capable providers matching requirements
+func (pr *ProviderRegistry) GetEligiblePayoutProviders(req *PayoutRequest) ([]*ProviderConfig, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	eligible := make([]*ProviderConfig, 0)
+
+	for name, config := range pr.paymentProviders {
+		if !config.Enabled {
+			continue
+		}
+
+		caps := config.Provider.Capabilities()
+		if !caps.SupportsPayouts {
+			continue
+		}
+		if _, ok := config.Provider.(PayoutProvider); !ok {
+			continue
+		}
+
+		if config.CircuitBreaker.GetState() == StateOpen {
+			log.Printf("[ProviderRegistry] Skipping %s for payout: circuit breaker is OPEN", name)
+			continue
+		}
+		if inMaintenance(name) {
+			continue
+		}
+
+		if !money.WithinLimits(req.Amount, req.Currency, caps.MinAmountCents, caps.MaxAmountCents) {
+			continue
+		}
+
+		currencySupported := false
+		for _, curr := range caps.SupportedCurrencies {
+			if curr == req.Currency {
+				currencySupported = true
+				break
+			}
+		}
+		if !currencySupported {
+			continue
+		}
+
+		eligible = append(eligible, config)
+	}
+
+	if len(eligible) == 0 {
+		return nil, errors.New("no eligible payout providers found for this request")
+	}
+
+	pr.sortByPriority(eligible)
+
+	return eligible, nil
+}
+
+// GetEligibleBNPLProviders returns BNPL-capable providers matching requirements
+func (pr *ProviderRegistry) GetEligibleBNPLProviders(req *BNPLRequest) ([]*ProviderConfig, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	eligible := make([]*ProviderConfig, 0)
+
+	for name, config := range pr.paymentProviders {
+		if !config.Enabled {
+			continue
+		}
+
+		caps := config.Provider.Capabilities()
+		if !caps.SupportsBNPL {
+			continue
+		}
+		if _, ok := config.Provider.(BNPLProvider); !ok {
+			continue
+		}
+
+		if config.CircuitBreaker.GetState() == StateOpen {
+			log.Printf("[ProviderRegistry] Skipping %s for BNPL: circuit breaker is OPEN", name)
+			continue
+		}
+		if inMaintenance(name) {
+			continue
+		}
+
+		if !money.WithinLimits(req.Amount, req.Currency, caps.MinAmountCents, caps.MaxAmountCents) {
+			continue
+		}
+
+		currencySupported := false
+		for _, curr := range caps.SupportedCurrencies {
+			if curr == req.Currency {
+				currencySupported = true
+				break
+			}
+		}
+		if !currencySupported {
+			continue
+		}
+
+		eligible = append(eligible, config)
+	}
+
+	if len(eligible) == 0 {
+		return nil, errors.New("no eligible BNPL providers found for this request")
+	}
+
 	pr.sortByPriority(eligible)
 
 	return eligible, nil
@@ -222,6 +472,18 @@ func (pr *ProviderRegistry) EnableProvider(name string) error {
 	return nil
 }
 
+// IsProviderEnabled reports whether a payment provider is enabled
+func (pr *ProviderRegistry) IsProviderEnabled(name string) (bool, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists {
+		return false, fmt.Errorf("provider '%s' not found", name)
+	}
+	return config.Enabled, nil
+}
+
 // DisableProvider disables a provider
 func (pr *ProviderRegistry) DisableProvider(name string) error {
 	pr.mu.Lock()
@@ -237,6 +499,52 @@ func (pr *ProviderRegistry) DisableProvider(name string) error {
 	return nil
 }
 
+// SetProviderPriority changes a provider's priority and returns the previous one
+func (pr *ProviderRegistry) SetProviderPriority(name string, priority ProviderPriority) (ProviderPriority, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	config, exists := pr.paymentProviders[name]
+	if !exists {
+		return 0, fmt.Errorf("provider '%s' not found", name)
+	}
+
+	previous := config.Priority
+	config.Priority = priority
+	log.Printf("[ProviderRegistry] Priority of %s changed from %d to %d", name, previous, priority)
+	return previous, nil
+}
+
+// CircuitState returns a provider's circuit breaker state, whether or not the
+// provider is enabled. Providers without a breaker report closed
+func (pr *ProviderRegistry) CircuitState(name string) (CircuitState, error) {
+	pr.mu.RLock()
+	config, exists := pr.paymentProviders[name]
+	pr.mu.RUnlock()
+
+	if !exists {
+		return StateClosed, fmt.Errorf("provider '%s' not found", name)
+	}
+	if config.CircuitBreaker == nil {
+		return StateClosed, nil
+	}
+	return config.CircuitBreaker.GetState(), nil
+}
+
+// SLATargets returns the SLA of every payment provider that has one configured
+func (pr *ProviderRegistry) SLATargets() map[string]SLAConfig {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	targets := make(map[string]SLAConfig)
+	for name, config := range pr.paymentProviders {
+		if config.SLA.MaxLatencyP95Ms > 0 || config.SLA.MinSuccessRate > 0 {
+			targets[name] = config.SLA
+		}
+	}
+	return targets
+}
+
 // GetAllProviderStatus returns status of all providers
 func (pr *ProviderRegistry) GetAllProviderStatus() map[string]interface{} {
 	pr.mu.RLock()
@@ -244,13 +552,25 @@ func (pr *ProviderRegistry) GetAllProviderStatus() map[string]interface{} {
 
 	paymentStatus := make([]map[string]interface{}, 0)
 	for name, config := range pr.paymentProviders {
+		state := "active"
+		if !config.Enabled {
+			state = "disabled"
+		}
+		window := providerMaintenance.Active(name)
+		if window != nil {
+			state = ProviderStatusMaintenance
+		}
 		status := map[string]interface{}{
 			"name":            name,
+			"status":          state,
 			"enabled":         config.Enabled,
 			"priority":        config.Priority,
 			"circuit_breaker": config.CircuitBreaker.GetStats(),
 			"capabilities":    config.Provider.Capabilities(),
 		}
+		if window != nil {
+			status["maintenance"] = window
+		}
 		paymentStatus = append(paymentStatus, status)
 	}
 
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// /livez says the process is up and serving, and is what a supervisor should restart
+// on. /readyz says whether the instance can take traffic right now: Redis and the
+// database reachable, at least one provider circuit closed, the load shedder not
+// saturated and the instance not draining. Until readiness first passes after
+// startup, every other request is refused with 503 so a load balancer never sees a
+// half-initialized instance
+
+// readinessCheckTimeout bounds each dependency check
+const readinessCheckTimeout = 2 * time.Second
+
+// readinessStartupPoll is how often readiness is checked while the server is starting
+const readinessStartupPoll = time.Second
+
+// processStarted is when the process started, for /livez
+var processStarted = time.Now()
+
+// DependencyCheck is one dependency's result in a readiness report
+type DependencyCheck struct {
+	OK        bool                   `json:"ok"`
+	Skipped   bool                   `json:"skipped,omitempty"` // Not configured, so it doesn't gate readiness
+	LatencyMs float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Detail    map[string]interface{} `json:"detail,omitempty"`
+}
+
+// ReadinessReport is the body of /readyz
+type ReadinessReport struct {
+	Ready     bool                       `json:"ready"`
+	Started   bool                       `json:"started"` // Readiness has passed at least once since startup
+	Checks    map[string]DependencyCheck `json:"checks"`
+	Timestamp string                     `json:"timestamp"`
+}
+
+// readinessChecks are the dependencies /readyz checks, by name
+var readinessChecks = map[string]func(ctx context.Context) DependencyCheck{
+	"redis":        checkRedisReady,
+	"database":     checkDatabaseReady,
+	"providers":    checkProvidersReady,
+	"load_shedder": checkLoadShedderReady,
+	"drain":        checkNotDraining,
+}
+
+// databaseConfigured is set once the database connection was opened at startup. The
+// mesh runs without a database, so the database only gates readiness when it is used
+var databaseConfigured atomic.Bool
+
+func checkRedisReady(ctx context.Context) DependencyCheck {
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return DependencyCheck{Error: err.Error()}
+	}
+	return DependencyCheck{OK: true}
+}
+
+func checkDatabaseReady(ctx context.Context) DependencyCheck {
+	if !databaseConfigured.Load() || Databaseconnection == nil {
+		return DependencyCheck{OK: true, Skipped: true}
+	}
+	if err := Databaseconnection.PingContext(ctx); err != nil {
+		return DependencyCheck{Error: err.Error()}
+	}
+	return DependencyCheck{OK: true}
+}
+
+func checkProvidersReady(ctx context.Context) DependencyCheck {
+	closed, open, halfOpen := 0, 0, 0
+	providerRegistry.mu.RLock()
+	for _, config := range providerRegistry.paymentProviders {
+		if !config.Enabled {
+			continue
+		}
+		state := StateClosed
+		if config.CircuitBreaker != nil {
+			state = config.CircuitBreaker.GetState()
+		}
+		switch state {
+		case StateClosed:
+			closed++
+		case StateOpen:
+			open++
+		default:
+			halfOpen++
+		}
+	}
+	providerRegistry.mu.RUnlock()
+
+	check := DependencyCheck{OK: closed > 0, Detail: map[string]interface{}{
+		"closed":    closed,
+		"open":      open,
+		"half_open": halfOpen,
+	}}
+	if !check.OK {
+		check.Error = "no enabled provider has a closed circuit"
+	}
+	return check
+}
+
+func checkLoadShedderReady(ctx context.Context) DependencyCheck {
+	ls := GetLoadShedder()
+	if ls == nil {
+		return DependencyCheck{OK: true, Skipped: true}
+	}
+	saturated, detail := ls.Saturation()
+	check := DependencyCheck{OK: !saturated, Detail: detail}
+	if saturated {
+		check.Error = "active request limit reached and queue full"
+	}
+	return check
+}
+
+func checkNotDraining(ctx context.Context) DependencyCheck {
+	status := drainController.Status()
+	check := DependencyCheck{OK: !status.Draining, Detail: map[string]interface{}{"in_flight": status.InFlight}}
+	if status.Draining {
+		check.Error = "draining for maintenance"
+	}
+	return check
+}
+
+// Readiness tracks whether the instance has become ready since startup
+type Readiness struct {
+	started atomic.Bool
+}
+
+var readiness = &Readiness{}
+
+// Check runs every dependency check concurrently and reports them with their latency
+func (rd *Readiness) Check(ctx context.Context) ReadinessReport {
+	report := ReadinessReport{Ready: true, Checks: make(map[string]DependencyCheck, len(readinessChecks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) DependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			result := check(checkCtx)
+			result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if !result.OK {
+				report.Ready = false
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if report.Ready && rd.started.CompareAndSwap(false, true) {
+		log.Println("Readiness passed, accepting traffic")
+	}
+	report.Started = rd.started.Load()
+	report.Timestamp = getCurrentTimeString()
+	return report
+}
+
+// Started reports whether readiness has passed since startup
+func (rd *Readiness) Started() bool {
+	return rd.started.Load()
+}
+
+// WaitStarted checks readiness until it passes, logging what is still failing, or
+// until ctx ends. It is run in the background at startup
+func (rd *Readiness) WaitStarted(ctx context.Context) {
+	ticker := time.NewTicker(readinessStartupPoll)
+	defer ticker.Stop()
+	for !rd.Started() {
+		report := rd.Check(ctx)
+		if report.Ready {
+			return
+		}
+		for name, check := range report.Checks {
+			if !check.OK {
+				log.Printf("Not ready yet: %s: %s", name, check.Error)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readinessExemptPaths stay reachable while the instance is starting
+var readinessExemptPaths = map[string]bool{"/livez": true, "/readyz": true, "/health": true}
+
+// ReadinessGateMiddleware refuses traffic with 503 until readiness has passed once
+func ReadinessGateMiddleware(rd *Readiness) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rd.Started() || readinessExemptPaths[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(readinessStartupPoll.Seconds())))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrServiceUnavailable,
+				"Service is starting",
+				"REJECTED",
+				"The instance has not passed its readiness checks yet, see /readyz",
+			))
+		})
+	}
+}
+
+// LivezHandler handles GET /livez. It only reports that the process is serving
+func LivezHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alive":          true,
+		"pid":            os.Getpid(),
+		"uptime_seconds": int64(time.Since(processStarted).Seconds()),
+		"timestamp":      getCurrentTimeString(),
+	})
+}
+
+// ReadyzHandler handles GET /readyz, responding 503 when any dependency check fails
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := readiness.Check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Refund states. A PENDING refund timed out or got no clear answer from the provider,
+// which may still have refunded it, so its amount stays reserved until an admin resolves
+// it through AdminRefundResolveHandler
+const (
+	RefundSucceeded = "SUCCEEDED"
+	RefundFailed    = "FAILED"
+	RefundPending   = "PENDING"
+)
+
+// refundTimeout bounds a single provider refund attempt
+const refundTimeout = 10 * time.Second
+
+// Refund returns part or all of a captured payment to the customer
+type Refund struct {
+	ID               string    `json:"id"`
+	PaymentID        string    `json:"payment_id"`
+	MerchantID       string    `json:"merchant_id"`
+	Provider         string    `json:"provider"`
+	ProviderRefundID string    `json:"provider_refund_id,omitempty"`
+	Amount           int64     `json:"amount"`
+	Currency         string    `json:"currency"`
+	Reason           string    `json:"reason,omitempty"`
+	Status           string    `json:"status"`
+	ErrorMessage     string    `json:"error_message,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ErrOverRefund is returned when a refund would exceed what is left to refund
+var ErrOverRefund = errors.New("refund exceeds the remaining refundable amount")
+
+// Errors returned when resolving a pending refund
+var (
+	ErrRefundNotFound   = errors.New("refund not found")
+	ErrRefundNotPending = errors.New("refund is not pending")
+)
+
+func refundedKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "refunded:" + paymentID
+}
+
+func refundsKey(paymentID string) string {
+	return paymentNamespace(paymentID) + "refunds:" + paymentID
+}
+
+// reserveRefundScript adds a refund to a payment's refunded-to-date total unless it
+// would take the total past the captured amount. It returns the new total, or -1.
+// The total expires with the payment record (ARGV[3] milliseconds)
+var reserveRefundScript = redis.NewScript(`
+local refunded = tonumber(redis.call('GET', KEYS[1]) or '0')
+local amount = tonumber(ARGV[1])
+if refunded + amount > tonumber(ARGV[2]) then
+	return -1
+end
+local total = redis.call('INCRBY', KEYS[1], amount)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return total
+`)
+
+// reserveRefund atomically claims amount out of a payment's refundable balance
+func reserveRefund(ctx context.Context, paymentID string, amount, captured int64) (int64, error) {
+	total, err := reserveRefundScript.Run(ctx, rdb, []string{refundedKey(paymentID)}, amount, captured, paymentRecordTTL.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if total < 0 {
+		return 0, ErrOverRefund
+	}
+	return total, nil
+}
+
+// releaseRefund returns a failed refund's amount to the refundable balance
+func releaseRefund(ctx context.Context, paymentID string, amount int64) (int64, error) {
+	return rdb.DecrBy(ctx, refundedKey(paymentID), amount).Result()
+}
+
+// refundedAmount returns how much of a payment has been refunded so far
+func refundedAmount(ctx context.Context, paymentID string) (int64, error) {
+	total, err := rdb.Get(ctx, refundedKey(paymentID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return total, err
+}
+
+// saveRefund stores a refund under its payment. The refunds expire with the payment record
+func saveRefund(ctx context.Context, refund *Refund) error {
+	data, err := json.Marshal(refund)
+	if err != nil {
+		return err
+	}
+	key := refundsKey(refund.PaymentID)
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, refund.ID, data)
+		pipe.Expire(ctx, key, paymentRecordTTL)
+		return nil
+	})
+	return err
+}
+
+// listRefunds returns a payment's refunds, oldest first
+func listRefunds(ctx context.Context, paymentID string) ([]Refund, error) {
+	items, err := rdb.HGetAll(ctx, refundsKey(paymentID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	refunds := make([]Refund, 0, len(items))
+	for _, item := range items {
+		var refund Refund
+		if err := json.Unmarshal([]byte(item), &refund); err != nil {
+			continue
+		}
+		refunds = append(refunds, refund)
+	}
+	sort.Slice(refunds, func(i, j int) bool {
+		return refunds[i].CreatedAt.Before(refunds[j].CreatedAt)
+	})
+	return refunds, nil
+}
+
+// sendRefund sends a refund to the provider that captured the payment: through the
+// registered provider when there is one, otherwise straight to its gateway. Test-mode
+// refunds only ever go straight to a simulator gateway
+func sendRefund(ctx context.Context, record *PaymentRecord, req *RefundRequest) (*RefundResponse, error) {
+	testMode := isTestPayment(record.PaymentID)
+	if config, err := providerRegistry.GetPaymentProvider(record.Provider); err == nil && !testMode {
+		return config.Provider.Refund(ctx, req)
+	}
+	server, err := serverPool.GetServerByGateway(record.Provider)
+	if err != nil || (testMode && !isSandboxGateway(server.ServerURL)) {
+		return nil, fmt.Errorf("gateway %s is not available for refunds", record.Provider)
+	}
+	return sendGatewayRefund(ctx, record.Provider, server.ServerURL+"/refunds", req)
+}
+
+// refundRejected reports whether a refund error means the provider definitely did not
+// refund: it turned the refund down, or it was never sent. Timeouts, transport errors
+// and server errors leave the outcome unknown
+func refundRejected(err error) bool {
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) {
+		return !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled)
+	}
+	switch providerErr.CanonicalCode {
+	case ErrCodeInvalidRequest, ErrCodeInsufficientFunds, ErrCodeCardDeclined,
+		ErrCodeAuthenticationFail, ErrCodeRateLimited, ErrCodeCircuitOpen, ErrCodeProviderDegraded:
+		return true
+	}
+	return false
+}
+
+// processRefund refunds amount of a captured payment. The amount is reserved against
+// the refundable balance before the provider is called and released again only if the
+// provider rejects the refund, so concurrent refunds can never exceed the captured
+// amount, nor can retrying a refund that may have gone through
+func processRefund(ctx context.Context, record *PaymentRecord, amount int64, reason, correlationID string) (*Refund, error) {
+	total, err := reserveRefund(ctx, record.PaymentID, amount, record.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := &Refund{
+		ID:         "re_" + uuid.NewString(),
+		PaymentID:  record.PaymentID,
+		MerchantID: record.MerchantID,
+		Provider:   record.Provider,
+		Amount:     amount,
+		Currency:   record.Currency,
+		Reason:     reason,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	refundCtx, cancel := context.WithTimeout(withTraceIDs(ctx, correlationID, record.PaymentID), refundTimeout)
+	defer cancel()
+	resp, err := sendRefund(refundCtx, record, &RefundRequest{
+		ID:             refund.ID,
+		PaymentID:      record.PaymentID,
+		Amount:         amount,
+		Reason:         reason,
+		IdempotencyKey: refund.ID,
+	})
+	switch {
+	case err != nil && refundRejected(err):
+		refund.Status = RefundFailed
+		refund.ErrorMessage = err.Error()
+		if total, err = releaseRefund(ctx, record.PaymentID, amount); err != nil {
+			log.Printf("[Refunds] Failed to release %d for %s: %v", amount, record.PaymentID, err)
+		}
+	case err != nil:
+		refund.Status = RefundPending
+		refund.ErrorMessage = err.Error()
+		log.Printf("[Refunds] Outcome of refund %s for %s is unknown, keeping %d reserved: %v", refund.ID, record.PaymentID, amount, err)
+	default:
+		refund.Status = RefundSucceeded
+		refund.ProviderRefundID = resp.RefundID
+		if l := GetLedger(); l != nil && !isTestPayment(record.PaymentID) {
+			if err := l.RecordRefund(record, amount); err != nil {
+				log.Printf("[Refunds] Failed to record refund %s in ledger: %v", refund.ID, err)
+			}
+		}
+	}
+
+	if err := saveRefund(ctx, refund); err != nil {
+		log.Printf("[Refunds] Failed to save refund %s: %v", refund.ID, err)
+	}
+	if err := UpdatePaymentRecord(record.PaymentID, func(r *PaymentRecord) {
+		r.RefundedAmount = total
+	}); err != nil {
+		log.Printf("[Refunds] Failed to update refunded amount for %s: %v", record.PaymentID, err)
+	}
+	record.RefundedAmount = total
+
+	appLogger.Info("Refund processed", map[string]interface{}{
+		"operation":      "refund",
+		"correlation_id": correlationID,
+		"payment_id":     record.PaymentID,
+		"refund_id":      refund.ID,
+		"amount":         amount,
+		"status":         refund.Status,
+		"refunded_total": total,
+	})
+	return refund, nil
+}
+
+// resolvePendingRefund settles a PENDING refund once its outcome at the provider is
+// known. A refund that went through is marked SUCCEEDED and recorded in the ledger; one
+// that didn't is marked FAILED and its amount released back to the refundable balance.
+// The refund is watched while it is updated, so a refund can only be resolved once
+func resolvePendingRefund(ctx context.Context, record *PaymentRecord, refundID string, succeeded bool, providerRefundID, message string) (*Refund, error) {
+	key := refundsKey(record.PaymentID)
+	var refund Refund
+
+	err := rdb.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, key, refundID).Result()
+		if err == redis.Nil {
+			return ErrRefundNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &refund); err != nil {
+			return fmt.Errorf("corrupt refund %s: %v", refundID, err)
+		}
+		if refund.Status != RefundPending {
+			return ErrRefundNotPending
+		}
+
+		if succeeded {
+			refund.Status = RefundSucceeded
+			refund.ProviderRefundID = providerRefundID
+			refund.ErrorMessage = ""
+		} else {
+			refund.Status = RefundFailed
+			if message != "" {
+				refund.ErrorMessage = message
+			}
+		}
+		payload, err := json.Marshal(&refund)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, refundID, payload)
+			if !succeeded {
+				pipe.DecrBy(ctx, refundedKey(record.PaymentID), refund.Amount)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return nil, fmt.Errorf("refund %s changed while it was being resolved", refundID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if succeeded {
+		if l := GetLedger(); l != nil && !isTestPayment(record.PaymentID) {
+			if err := l.RecordRefund(record, refund.Amount); err != nil {
+				log.Printf("[Refunds] Failed to record refund %s in ledger: %v", refund.ID, err)
+			}
+		}
+	}
+
+	total, err := refundedAmount(ctx, record.PaymentID)
+	if err != nil {
+		log.Printf("[Refunds] Failed to load refunded amount for %s: %v", record.PaymentID, err)
+		return &refund, nil
+	}
+	if err := UpdatePaymentRecord(record.PaymentID, func(r *PaymentRecord) {
+		r.RefundedAmount = total
+	}); err != nil {
+		log.Printf("[Refunds] Failed to update refunded amount for %s: %v", record.PaymentID, err)
+	}
+	record.RefundedAmount = total
+	return &refund, nil
+}
+
+// AdminRefundResolveHandler handles POST /admin/payments/{payment_id}/refunds/{refund_id}/resolve,
+// settling a PENDING refund after its outcome has been confirmed with the provider
+func AdminRefundResolveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	paymentID := r.PathValue("payment_id")
+	refundID := r.PathValue("refund_id")
+	var body struct {
+		Outcome          string `json:"outcome"` // succeeded or failed
+		ProviderRefundID string `json:"provider_refund_id"`
+		Reason           string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var succeeded bool
+	switch body.Outcome {
+	case "succeeded":
+		succeeded = true
+	case "failed":
+	default:
+		http.Error(w, "outcome must be succeeded or failed", http.StatusBadRequest)
+		return
+	}
+
+	record, err := GetPaymentRecord(paymentID)
+	if err != nil {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	message := "Resolved as failed by " + adminActor(r)
+	if body.Reason != "" {
+		message += ": " + body.Reason
+	}
+	refund, err := resolvePendingRefund(r.Context(), record, refundID, succeeded, body.ProviderRefundID, message)
+	switch {
+	case errors.Is(err, ErrRefundNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrRefundNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to resolve refund", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "resolve_refund", refundID, map[string]string{"status": RefundPending}, map[string]string{"status": refund.Status, "reason": body.Reason})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"refund":          refund,
+		"refunded_amount": record.RefundedAmount,
+		"refundable":      record.Amount - record.RefundedAmount,
+	})
+}
+
+// PaymentRefundsHandler handles /payment/{payment_id}/refunds:
+// POST refunds the given amount (the remaining balance when omitted), GET lists refunds
+func PaymentRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("payment_id")
+	w.Header().Set("Content-Type", "application/json")
+
+	// Another merchant's payment is reported as missing rather than forbidden
+	record, err := GetPaymentRecord(paymentID)
+	if err != nil || !ownedByCaller(r.Context(), record.MerchantID) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(NewErrorResponse(ErrPaymentNotFound, "Payment not found", "", paymentID))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		refunds, err := listRefunds(r.Context(), paymentID)
+		if err != nil {
+			http.Error(w, "Failed to fetch refunds", http.StatusInternalServerError)
+			return
+		}
+		refunded, _ := refundedAmount(r.Context(), paymentID)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"refunds":         refunds,
+			"total":           len(refunds),
+			"refunded_amount": refunded,
+			"refundable":      record.Amount - refunded,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Amount int64  `json:"amount" validate:"omitempty,gt=0"`
+			Reason string `json:"reason" validate:"max=255"`
+		}
+		if err := decodeStrict(r.Body, &req); err != nil {
+			writeRequestError(w, RefundFailed, err)
+			return
+		}
+		if err := validateStruct(&req); err != nil {
+			writeRequestError(w, RefundFailed, err)
+			return
+		}
+
+		if record.Status != SUCCESS.String() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrPaymentNotRefundable,
+				"Only successful payments can be refunded",
+				record.Status,
+				paymentID,
+			))
+			return
+		}
+
+		amount := req.Amount
+		if amount == 0 {
+			refunded, err := refundedAmount(r.Context(), paymentID)
+			if err != nil {
+				http.Error(w, "Failed to load refunded amount", http.StatusInternalServerError)
+				return
+			}
+			amount = record.Amount - refunded
+		}
+
+		correlationID, _ := r.Context().Value("correlation_id").(string)
+		var refund *Refund
+		if amount > 0 {
+			refund, err = processRefund(r.Context(), record, amount, req.Reason, correlationID)
+		} else {
+			err = ErrOverRefund
+		}
+		if errors.Is(err, ErrOverRefund) {
+			refunded, _ := refundedAmount(r.Context(), paymentID)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(NewErrorResponse(
+				ErrRefundExceedsBalance,
+				"Refund exceeds the remaining refundable amount",
+				RefundFailed,
+				fmt.Sprintf("requested %d, refundable %d of %d", amount, record.Amount-refunded, record.Amount),
+			))
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to process refund", http.StatusInternalServerError)
+			return
+		}
+
+		status := http.StatusCreated
+		switch refund.Status {
+		case RefundFailed:
+			status = http.StatusBadGateway
+		case RefundPending:
+			status = http.StatusAccepted
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"refund":          refund,
+			"refunded_amount": record.RefundedAmount,
+			"refundable":      record.Amount - record.RefundedAmount,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
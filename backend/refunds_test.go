@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReserveRefundAccumulatesUpToCapturedAmount(t *testing.T) {
+	mr := useTestRedis(t)
+
+	if total, err := reserveRefund(ctx, "pay_1", 600, 1000); err != nil || total != 600 {
+		t.Fatalf("first reserve = %d, %v; want 600, nil", total, err)
+	}
+	if total, err := reserveRefund(ctx, "pay_1", 400, 1000); err != nil || total != 1000 {
+		t.Fatalf("second reserve = %d, %v; want 1000, nil", total, err)
+	}
+	if _, err := reserveRefund(ctx, "pay_1", 1, 1000); !errors.Is(err, ErrOverRefund) {
+		t.Fatalf("over-refund err = %v, want ErrOverRefund", err)
+	}
+	if total, _ := refundedAmount(ctx, "pay_1"); total != 1000 {
+		t.Errorf("refunded after rejected reserve = %d, want 1000", total)
+	}
+
+	if ttl := mr.TTL(refundedKey("pay_1")); ttl <= 0 || ttl > paymentRecordTTL {
+		t.Errorf("refunded key TTL = %v, want (0, %v]", ttl, paymentRecordTTL)
+	}
+}
+
+func TestReleaseRefundReturnsAmount(t *testing.T) {
+	useTestRedis(t)
+
+	if _, err := reserveRefund(ctx, "pay_1", 700, 1000); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if total, err := releaseRefund(ctx, "pay_1", 700); err != nil || total != 0 {
+		t.Fatalf("release = %d, %v; want 0, nil", total, err)
+	}
+	if total, err := reserveRefund(ctx, "pay_1", 1000, 1000); err != nil || total != 1000 {
+		t.Errorf("reserve after release = %d, %v; want 1000, nil", total, err)
+	}
+}
+
+func TestRefundRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"card declined", &ProviderError{CanonicalCode: ErrCodeCardDeclined}, true},
+		{"invalid request", &ProviderError{CanonicalCode: ErrCodeInvalidRequest}, true},
+		{"circuit open", &ProviderError{CanonicalCode: ErrCodeCircuitOpen}, true},
+		{"rate limited", &ProviderError{CanonicalCode: ErrCodeRateLimited}, true},
+		{"wrapped decline", fmt.Errorf("refund: %w", &ProviderError{CanonicalCode: ErrCodeInsufficientFunds}), true},
+		{"provider timeout", &ProviderError{CanonicalCode: ErrCodeProviderTimeout}, false},
+		{"provider error", &ProviderError{CanonicalCode: ErrCodeProviderError}, false},
+		{"network error", &ProviderError{CanonicalCode: ErrCodeNetworkError}, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"canceled", fmt.Errorf("send: %w", context.Canceled), false},
+		{"not sent", errors.New("no provider configured"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundRejected(tt.err); got != tt.want {
+				t.Errorf("refundRejected(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentRefundsHandlerHidesOtherMerchantsPayments(t *testing.T) {
+	useTestRedis(t)
+	if err := SavePaymentRecord(&PaymentRecord{PaymentID: "pay_1", MerchantID: "merchant_a", Status: SUCCESS.String(), Amount: 1000}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/payment/pay_1/refunds", strings.NewReader(`{"amount":500}`))
+		req.SetPathValue("payment_id", "pay_1")
+		req = req.WithContext(context.WithValue(req.Context(), "api_key", "merchant_b"))
+		rec := httptest.NewRecorder()
+		PaymentRefundsHandler(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s by another merchant: status = %d, want %d", method, rec.Code, http.StatusNotFound)
+		}
+	}
+	if total, _ := refundedAmount(ctx, "pay_1"); total != 0 {
+		t.Errorf("refunded = %d after another merchant's refund, want 0", total)
+	}
+}
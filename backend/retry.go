@@ -47,6 +47,7 @@ type RetryDecision struct {
 // RetryStrategy determines retry behavior based on error and context
 type RetryStrategy struct {
 	config RetryConfig
+	budget *RetryBudget
 }
 
 // NewRetryStrategy creates a new retry strategy
@@ -56,6 +57,15 @@ func NewRetryStrategy(config RetryConfig) *RetryStrategy {
 	}
 }
 
+// NewBudgetedRetryStrategy creates a retry strategy whose retries also draw from a
+// provider's retry budget, so a degraded provider can't trigger a retry storm
+func NewBudgetedRetryStrategy(config RetryConfig, budget *RetryBudget) *RetryStrategy {
+	return &RetryStrategy{
+		config: config,
+		budget: budget,
+	}
+}
+
 // ShouldRetry determines if a request should be retried
 func (rs *RetryStrategy) ShouldRetry(
 	err error,
@@ -72,22 +82,31 @@ func (rs *RetryStrategy) ShouldRetry(
 		}
 	}
 
-	// Handle HTTP status codes
+	var decision RetryDecision
 	if statusCode > 0 {
-		return rs.handleHTTPStatus(statusCode, attempt, resp)
-	}
-
-	// Handle errors
-	if err != nil {
-		return rs.handleError(err, attempt)
+		// Handle HTTP status codes
+		decision = rs.handleHTTPStatus(statusCode, attempt, resp)
+	} else if err != nil {
+		// Handle errors
+		decision = rs.handleError(err, attempt)
+	} else {
+		// No error and no bad status - don't retry
+		return RetryDecision{
+			ShouldRetry: false,
+			Backoff:     0,
+			Reason:      "success",
+		}
 	}
 
-	// No error and no bad status - don't retry
-	return RetryDecision{
-		ShouldRetry: false,
-		Backoff:     0,
-		Reason:      "success",
+	// Retries must also fit in the provider's retry budget
+	if decision.ShouldRetry && rs.budget != nil && !rs.budget.TryRetry() {
+		return RetryDecision{
+			ShouldRetry: false,
+			Backoff:     0,
+			Reason:      "retry_budget_exhausted",
+		}
 	}
+	return decision
 }
 
 // handleHTTPStatus determines retry behavior for HTTP status codes
@@ -101,6 +120,15 @@ func (rs *RetryStrategy) handleHTTPStatus(statusCode int, attempt int, resp *htt
 		}
 	}
 
+	// Only statuses listed in the policy are retried
+	if statusCode >= 400 && len(rs.config.RetryableStatuses) > 0 && !rs.isRetryableStatus(statusCode) {
+		return RetryDecision{
+			ShouldRetry: false,
+			Backoff:     0,
+			Reason:      "non_retryable_status",
+		}
+	}
+
 	// 4xx Client Errors - generally don't retry
 	if statusCode >= 400 && statusCode < 500 {
 		// Except for specific retryable 4xx codes
@@ -117,7 +145,7 @@ func (rs *RetryStrategy) handleHTTPStatus(statusCode int, attempt int, resp *htt
 			// Use Retry-After header if available
 			backoff := rs.calculateBackoff(attempt)
 			if resp != nil {
-				if retryAfter := rs.parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+				if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
 					backoff = retryAfter
 				}
 			}
@@ -166,6 +194,16 @@ func (rs *RetryStrategy) handleHTTPStatus(statusCode int, attempt int, resp *htt
 	}
 }
 
+// isRetryableStatus reports whether the policy lists statusCode as retryable
+func (rs *RetryStrategy) isRetryableStatus(statusCode int) bool {
+	for _, status := range rs.config.RetryableStatuses {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
 // handleError determines retry behavior for errors
 func (rs *RetryStrategy) handleError(err error, attempt int) RetryDecision {
 	if err == nil {
@@ -239,7 +277,7 @@ func (rs *RetryStrategy) calculateBackoff(attempt int) time.Duration {
 }
 
 // parseRetryAfter parses the Retry-After header
-func (rs *RetryStrategy) parseRetryAfter(retryAfter string) time.Duration {
+func parseRetryAfter(retryAfter string) time.Duration {
 	if retryAfter == "" {
 		return 0
 	}
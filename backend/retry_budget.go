@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// Retry budget defaults: retries may be at most 20% of recent requests, with a
+// small reserve so a provider with little traffic can still be retried
+const (
+	defaultRetryBudgetRatio     = 0.2
+	defaultRetryBudgetMaxTokens = 10.0
+)
+
+// RetryBudget is a token bucket limiting retries to a share of request volume.
+// Every request deposits ratio tokens, every retry withdraws one. Deposits are
+// capped at maxTokens so only recent traffic earns retries
+type RetryBudget struct {
+	provider  string
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+
+	requests  int64
+	retries   int64
+	exhausted int64
+
+	mu sync.Mutex
+}
+
+// RetryBudgetStats is a snapshot of a retry budget
+type RetryBudgetStats struct {
+	Provider  string  `json:"provider"`
+	Tokens    float64 `json:"tokens"`
+	Ratio     float64 `json:"ratio"`
+	Requests  int64   `json:"requests"`
+	Retries   int64   `json:"retries"`
+	Exhausted int64   `json:"exhausted"`
+}
+
+// NewRetryBudget creates a full retry budget for a provider
+func NewRetryBudget(provider string, ratio, maxTokens float64) *RetryBudget {
+	return &RetryBudget{
+		provider:  provider,
+		ratio:     ratio,
+		maxTokens: maxTokens,
+		tokens:    maxTokens,
+	}
+}
+
+// RecordRequest deposits a request's share of retry tokens
+func (rb *RetryBudget) RecordRequest() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.requests++
+	rb.tokens += rb.ratio
+	if rb.tokens > rb.maxTokens {
+		rb.tokens = rb.maxTokens
+	}
+}
+
+// TryRetry withdraws a token for a retry, returning false when the budget is exhausted
+func (rb *RetryBudget) TryRetry() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.tokens < 1 {
+		rb.exhausted++
+		if rb.exhausted == 1 || rb.exhausted%100 == 0 {
+			log.Printf("[RetryBudget] %s: retry budget exhausted (%d retries denied)", rb.provider, rb.exhausted)
+		}
+		return false
+	}
+	rb.tokens--
+	rb.retries++
+	return true
+}
+
+// GetStats returns a snapshot of the budget
+func (rb *RetryBudget) GetStats() RetryBudgetStats {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	return RetryBudgetStats{
+		Provider:  rb.provider,
+		Tokens:    rb.tokens,
+		Ratio:     rb.ratio,
+		Requests:  rb.requests,
+		Retries:   rb.retries,
+		Exhausted: rb.exhausted,
+	}
+}
+
+// RetryBudgets holds one retry budget per provider
+type RetryBudgets struct {
+	budgets   map[string]*RetryBudget
+	ratio     float64
+	maxTokens float64
+	mu        sync.Mutex
+}
+
+var retryBudgets = NewRetryBudgets(defaultRetryBudgetRatio, defaultRetryBudgetMaxTokens)
+
+// NewRetryBudgets creates a set of per-provider budgets sharing ratio and maxTokens
+func NewRetryBudgets(ratio, maxTokens float64) *RetryBudgets {
+	return &RetryBudgets{
+		budgets:   make(map[string]*RetryBudget),
+		ratio:     ratio,
+		maxTokens: maxTokens,
+	}
+}
+
+// Get returns a provider's budget, creating it on first use
+func (rbs *RetryBudgets) Get(provider string) *RetryBudget {
+	rbs.mu.Lock()
+	defer rbs.mu.Unlock()
+
+	budget, exists := rbs.budgets[provider]
+	if !exists {
+		budget = NewRetryBudget(provider, rbs.ratio, rbs.maxTokens)
+		rbs.budgets[provider] = budget
+	}
+	return budget
+}
+
+// GetAllStats returns a snapshot of every provider's budget
+func (rbs *RetryBudgets) GetAllStats() []RetryBudgetStats {
+	rbs.mu.Lock()
+	budgets := make([]*RetryBudget, 0, len(rbs.budgets))
+	for _, budget := range rbs.budgets {
+		budgets = append(budgets, budget)
+	}
+	rbs.mu.Unlock()
+
+	stats := make([]RetryBudgetStats, 0, len(budgets))
+	for _, budget := range budgets {
+		stats = append(stats, budget.GetStats())
+	}
+	return stats
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RiskInput is what a RiskScorer knows about a payment
+type RiskInput struct {
+	PaymentID string
+	UserID    string
+	Amount    int64
+	Currency  string
+	Country   string
+	IPAddress string
+}
+
+// RiskFactor is one signal that contributed to a risk score
+type RiskFactor struct {
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RiskScore rates a payment from 0 (no risk) to 100
+type RiskScore struct {
+	Score   int          `json:"score"`
+	Factors []RiskFactor `json:"factors"`
+}
+
+// RiskScorer rates payments before authorization. Implementations may call out to an
+// external risk service
+type RiskScorer interface {
+	Score(ctx context.Context, input *RiskInput) (*RiskScore, error)
+}
+
+// RiskConfig holds the score bands that change how a payment is handled
+type RiskConfig struct {
+	StepUpScore int // Payments scoring at least this must be authenticated by the customer
+	ReviewScore int // Payments scoring at least this are held for review once authorized
+}
+
+// DefaultRiskConfig returns sensible defaults
+func DefaultRiskConfig() RiskConfig {
+	return RiskConfig{StepUpScore: 60, ReviewScore: 80}
+}
+
+// RiskConfigFromEnv builds the risk bands from RISK_* environment variables,
+// falling back to the defaults
+func RiskConfigFromEnv() (RiskConfig, error) {
+	config := DefaultRiskConfig()
+
+	if v := os.Getenv("RISK_STEP_UP_SCORE"); v != "" {
+		score, err := strconv.Atoi(v)
+		if err != nil || score < 0 || score > 100 {
+			return config, fmt.Errorf("RISK_STEP_UP_SCORE must be between 0 and 100, got %q", v)
+		}
+		config.StepUpScore = score
+	}
+	if v := os.Getenv("RISK_REVIEW_SCORE"); v != "" {
+		score, err := strconv.Atoi(v)
+		if err != nil || score < 0 || score > 100 {
+			return config, fmt.Errorf("RISK_REVIEW_SCORE must be between 0 and 100, got %q", v)
+		}
+		config.ReviewScore = score
+	}
+	if config.StepUpScore > config.ReviewScore {
+		return config, fmt.Errorf("RISK_STEP_UP_SCORE (%d) must not exceed RISK_REVIEW_SCORE (%d)", config.StepUpScore, config.ReviewScore)
+	}
+	return config, nil
+}
+
+// Risk outcomes
+const (
+	RiskActionAllow  = "allow"
+	RiskActionStepUp = "step_up"
+	RiskActionReview = "review"
+)
+
+// Action returns how a payment with the given score is handled
+func (rc RiskConfig) Action(score int) string {
+	switch {
+	case score >= rc.ReviewScore:
+		return RiskActionReview
+	case score >= rc.StepUpScore:
+		return RiskActionStepUp
+	default:
+		return RiskActionAllow
+	}
+}
+
+var (
+	riskScorer RiskScorer
+	riskConfig = DefaultRiskConfig()
+)
+
+// riskProfileTTL is how long a user's payment history is remembered after their last payment
+const riskProfileTTL = 90 * 24 * time.Hour
+
+// HeuristicRiskScorer scores payments from the user's history kept in Redis: how the
+// amount compares to what they usually pay, whether the country or IP address
+// changed, and how many payments they made in the last hour
+type HeuristicRiskScorer struct {
+	rdb *redis.Client
+}
+
+// NewHeuristicRiskScorer creates the built-in scorer
+func NewHeuristicRiskScorer(client *redis.Client) *HeuristicRiskScorer {
+	return &HeuristicRiskScorer{rdb: client}
+}
+
+// Risk history is namespaced like the payment, so test-mode payments never shape a
+// live user's profile
+func riskProfileKey(namespace, userID string) string {
+	return namespace + "risk_profile:" + userID
+}
+
+func riskVelocityKey(namespace, userID string) string {
+	return namespace + "risk_velocity:" + userID
+}
+
+// Score rates the payment, then folds it into the user's history
+func (hs *HeuristicRiskScorer) Score(ctx context.Context, input *RiskInput) (*RiskScore, error) {
+	score := &RiskScore{Factors: make([]RiskFactor, 0)}
+	add := func(name string, points int, detail string) {
+		score.Factors = append(score.Factors, RiskFactor{Name: name, Points: points, Detail: detail})
+		score.Score += points
+	}
+
+	if input.Amount >= 500000 {
+		add("high_amount", 15, fmt.Sprintf("amount %d", input.Amount))
+	}
+	if input.UserID == "" {
+		add("anonymous", 20, "no user ID")
+		return score, nil
+	}
+
+	namespace := paymentNamespace(input.PaymentID)
+	profile, err := hs.rdb.HGetAll(ctx, riskProfileKey(namespace, input.UserID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.ParseInt(profile["count"], 10, 64)
+	volume, _ := strconv.ParseInt(profile["volume"], 10, 64)
+
+	if count == 0 {
+		add("new_user", 10, "no previous payments")
+	} else {
+		average := volume / count
+		switch {
+		case average > 0 && input.Amount > 5*average:
+			add("amount_vs_history", 25, fmt.Sprintf("amount %d vs average %d", input.Amount, average))
+		case average > 0 && input.Amount > 2*average:
+			add("amount_vs_history", 10, fmt.Sprintf("amount %d vs average %d", input.Amount, average))
+		}
+		if last := profile["country"]; input.Country != "" && last != "" && !strings.EqualFold(last, input.Country) {
+			add("country_change", 20, fmt.Sprintf("%s, previously %s", input.Country, last))
+		}
+		if last := profile["ip"]; input.IPAddress != "" && last != "" && last != input.IPAddress {
+			add("ip_change", 10, fmt.Sprintf("%s, previously %s", input.IPAddress, last))
+		}
+	}
+
+	velocityKey := riskVelocityKey(namespace, input.UserID)
+	recent, err := hs.rdb.Incr(ctx, velocityKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if recent == 1 {
+		hs.rdb.Expire(ctx, velocityKey, time.Hour)
+	}
+	if recent > 3 {
+		add("velocity", min(int(recent-3)*5, 25), fmt.Sprintf("%d payments in the last hour", recent))
+	}
+
+	pipe := hs.rdb.TxPipeline()
+	profileKey := riskProfileKey(namespace, input.UserID)
+	pipe.HIncrBy(ctx, profileKey, "count", 1)
+	pipe.HIncrBy(ctx, profileKey, "volume", input.Amount)
+	if input.Country != "" {
+		pipe.HSet(ctx, profileKey, "country", strings.ToUpper(input.Country))
+	}
+	if input.IPAddress != "" {
+		pipe.HSet(ctx, profileKey, "ip", input.IPAddress)
+	}
+	pipe.Expire(ctx, profileKey, riskProfileTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[Risk] Failed to update profile for %s: %v", input.UserID, err)
+	}
+
+	score.Score = min(score.Score, 100)
+	return score, nil
+}
+
+// Reason summarizes a score and its factors for state transitions and error details
+func (rs *RiskScore) Reason() string {
+	names := make([]string, len(rs.Factors))
+	for i, factor := range rs.Factors {
+		names[i] = fmt.Sprintf("%s +%d", factor.Name, factor.Points)
+	}
+	return fmt.Sprintf("risk score %d (%s)", rs.Score, strings.Join(names, ", "))
+}
+
+// riskReviewReason reports whether a payment's risk score holds it for review once authorized
+func riskReviewReason(req *PaymentRequest) (bool, string) {
+	score, ok := req.Metadata["risk"].(*RiskScore)
+	if !ok || riskConfig.Action(score.Score) != RiskActionReview {
+		return false, ""
+	}
+	return true, score.Reason()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routeStats holds the counters for one route
+type routeStats struct {
+	requests    int64
+	serverErrs  int64
+	statusCodes map[int]int64
+	latency     *LatencyTracker
+}
+
+// RouteMetrics records request counts, status codes and latency for each of our own
+// endpoints. Routes are keyed by the mux pattern that matched, not the raw path, so
+// IDs in the URL don't create a new series per request
+type RouteMetrics struct {
+	routes map[string]*routeStats
+	mu     sync.Mutex
+}
+
+// NewRouteMetrics creates an empty route metrics registry
+func NewRouteMetrics() *RouteMetrics {
+	return &RouteMetrics{routes: make(map[string]*routeStats)}
+}
+
+// routeMetrics records every HTTP request the server handles
+var routeMetrics = NewRouteMetrics()
+
+// Record adds one completed request to a route's stats
+func (rm *RouteMetrics) Record(route string, status int, latency time.Duration) {
+	rm.mu.Lock()
+	stats, ok := rm.routes[route]
+	if !ok {
+		stats = &routeStats{
+			statusCodes: make(map[int]int64),
+			latency:     NewLatencyTracker(defaultLatencyWindow),
+		}
+		rm.routes[route] = stats
+	}
+	stats.requests++
+	stats.statusCodes[status]++
+	if status >= 500 {
+		stats.serverErrs++
+	}
+	rm.mu.Unlock()
+
+	stats.latency.AddSample(latency)
+}
+
+// GetStats returns per-route counts since startup and latency percentiles over the
+// latency window. error_rate is the share of 5xx responses
+func (rm *RouteMetrics) GetStats() map[string]interface{} {
+	rm.mu.Lock()
+	names := make([]string, 0, len(rm.routes))
+	for name := range rm.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type snapshot struct {
+		requests    int64
+		serverErrs  int64
+		statusCodes map[int]int64
+		latency     *LatencyTracker
+	}
+	snapshots := make([]snapshot, len(names))
+	for i, name := range names {
+		stats := rm.routes[name]
+		codes := make(map[int]int64, len(stats.statusCodes))
+		for code, count := range stats.statusCodes {
+			codes[code] = count
+		}
+		snapshots[i] = snapshot{stats.requests, stats.serverErrs, codes, stats.latency}
+	}
+	rm.mu.Unlock()
+
+	result := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		s := snapshots[i]
+		percentiles := s.latency.GetPercentiles()
+		result[name] = map[string]interface{}{
+			"requests":     s.requests,
+			"status_codes": s.statusCodes,
+			"error_rate":   float64(s.serverErrs) / float64(s.requests),
+			"p50_ms":       percentiles.P50.Milliseconds(),
+			"p95_ms":       percentiles.P95.Milliseconds(),
+			"p99_ms":       percentiles.P99.Milliseconds(),
+			"samples":      s.latency.GetSampleCount(),
+		}
+	}
+	return result
+}
+
+// routeLabel names the route a request matched, e.g. "GET /payouts/{payout_id}".
+// Patterns registered without a method get the request's method prepended
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return r.Method + " (unmatched)"
+	}
+	if strings.Contains(r.Pattern, " ") {
+		return r.Pattern
+	}
+	return r.Method + " " + r.Pattern
+}
+
+// statusRecorder captures the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// RouteMetricsMiddleware records each request against the route it matched. It must
+// wrap the mux directly: the mux fills in r.Pattern on the request it is handed
+func RouteMetricsMiddleware(metrics *RouteMetrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWebSocketUpgrade(r) || testModeEnabled(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			metrics.Record(routeLabel(r), recorder.status, time.Since(start))
+		})
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"pulseberry/money"
 )
 
 // RoutingStrategy defines how providers are selected
@@ -18,27 +22,104 @@ const (
 	RoutingStrategyHealthScore  RoutingStrategy = "health_score"  // Select based on composite health score
 	RoutingStrategyAffinity     RoutingStrategy = "affinity"      // Stick to same provider for user
 	RoutingStrategyRoundRobin   RoutingStrategy = "round_robin"   // Distribute evenly
+	RoutingStrategyLeastCost    RoutingStrategy = "least_cost"    // Select provider with lowest expected fee
 )
 
+// RoutingStrategies lists every strategy the selector can run
+var RoutingStrategies = []RoutingStrategy{
+	RoutingStrategyPriority,
+	RoutingStrategyLeastLatency,
+	RoutingStrategyHealthScore,
+	RoutingStrategyAffinity,
+	RoutingStrategyRoundRobin,
+	RoutingStrategyLeastCost,
+}
+
+// Health score weights
+const (
+	healthWeightSuccessRate  = 0.4
+	healthWeightLatency      = 0.3
+	healthWeightAvailability = 0.3
+)
+
+// minRoutingSamples is how many observed requests a provider needs before its
+// measured success rate and latency replace the SLA-based defaults
+const minRoutingSamples = 20
+
+// affinityTTL is how long a user stays pinned to a provider under the affinity strategy
+const affinityTTL = 24 * time.Hour
+
+// How long a rate-limited provider is deprioritized when it sends no Retry-After,
+// and the most a Retry-After can push it back
+const (
+	defaultRateLimitCooldown = 5 * time.Second
+	maxRateLimitCooldown     = 5 * time.Minute
+)
+
+// ParseRoutingStrategy validates a strategy name
+func ParseRoutingStrategy(name string) (RoutingStrategy, error) {
+	for _, strategy := range RoutingStrategies {
+		if string(strategy) == name {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown routing strategy %q", name)
+}
+
 // ProviderSelector handles intelligent provider selection
 type ProviderSelector struct {
 	registry *ProviderRegistry
 	strategy RoutingStrategy
 	rdb      *redis.Client
+	rules    *RoutingRuleEngine
+	pool     *ServerPool
+	// rateLimited holds, per provider, when its last 429 cooldown ends
+	rateLimited map[string]time.Time
+	mu          sync.RWMutex
 }
 
-// NewProviderSelector creates a new provider selector
-func NewProviderSelector(registry *ProviderRegistry, strategy RoutingStrategy, rdb *redis.Client) *ProviderSelector {
+// NewProviderSelector creates a new provider selector. rules may be nil. pool supplies
+// observed per-provider metrics; without it selection falls back to SLA defaults
+func NewProviderSelector(registry *ProviderRegistry, strategy RoutingStrategy, rdb *redis.Client, rules *RoutingRuleEngine, pool *ServerPool) *ProviderSelector {
 	return &ProviderSelector{
 		registry: registry,
 		strategy: strategy,
 		rdb:      rdb,
+		rules:    rules,
+		pool:     pool,
+
+		rateLimited: make(map[string]time.Time),
 	}
 }
 
-// SelectProvider selects the best provider for a payment request
+// SelectProvider selects the best provider for a payment request.
+// Routing rules are evaluated first, the strategy only applies when no rule matches
 func (ps *ProviderSelector) SelectProvider(ctx context.Context, req *PaymentRequest) (*ProviderConfig, error) {
-	switch ps.strategy {
+	config, err := ps.selectProvider(ctx, req)
+	if err != nil || !ps.IsRateLimited(config.Provider.Name()) {
+		return config, err
+	}
+
+	// Prefer the best ranked provider that isn't rate limited
+	ranked, _, rankErr := ps.RankProviders(ctx, req)
+	if rankErr == nil && len(ranked) > 0 && !ps.IsRateLimited(ranked[0].Provider.Name()) {
+		return ranked[0], nil
+	}
+	return config, nil
+}
+
+func (ps *ProviderSelector) selectProvider(ctx context.Context, req *PaymentRequest) (*ProviderConfig, error) {
+	if ps.rules != nil {
+		eligible, err := ps.registry.GetEligiblePaymentProviders(req)
+		if err != nil {
+			return nil, err
+		}
+		if _, config := ps.rules.Match(req, eligible); config != nil {
+			return config, nil
+		}
+	}
+
+	switch ps.Strategy() {
 	case RoutingStrategyLeastLatency:
 		return ps.selectByLeastLatency(req)
 	case RoutingStrategyHealthScore:
@@ -47,6 +128,8 @@ func (ps *ProviderSelector) SelectProvider(ctx context.Context, req *PaymentRequ
 		return ps.selectByAffinity(ctx, req)
 	case RoutingStrategyRoundRobin:
 		return ps.selectRoundRobin(req)
+	case RoutingStrategyLeastCost:
+		return ps.selectByLeastCost(req)
 	case RoutingStrategyPriority:
 		fallthrough
 	default:
@@ -54,6 +137,173 @@ func (ps *ProviderSelector) SelectProvider(ctx context.Context, req *PaymentRequ
 	}
 }
 
+// Strategy returns the active routing strategy
+func (ps *ProviderSelector) Strategy() RoutingStrategy {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.strategy
+}
+
+// SetStrategy switches the routing strategy at runtime
+func (ps *ProviderSelector) SetStrategy(strategy RoutingStrategy) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.strategy != strategy {
+		log.Printf("[Routing] Strategy changed: %s -> %s", ps.strategy, strategy)
+	}
+	ps.strategy = strategy
+}
+
+// Parameters describes the active strategy and the inputs it uses
+func (ps *ProviderSelector) Parameters() map[string]interface{} {
+	strategy := ps.Strategy()
+	params := map[string]interface{}{
+		"strategy":             strategy,
+		"available_strategies": RoutingStrategies,
+	}
+	if ps.rules != nil {
+		params["routing_rules"] = len(ps.rules.Rules())
+	}
+
+	switch strategy {
+	case RoutingStrategyHealthScore:
+		params["weights"] = map[string]float64{
+			"success_rate": healthWeightSuccessRate,
+			"latency":      healthWeightLatency,
+			"availability": healthWeightAvailability,
+		}
+	case RoutingStrategyAffinity:
+		params["affinity_ttl_seconds"] = int(affinityTTL.Seconds())
+		params["fallback"] = RoutingStrategyHealthScore
+	case RoutingStrategyRoundRobin:
+		params["hash_key"] = "idempotency_key"
+	case RoutingStrategyLeastCost:
+		params["tiebreaker"] = RoutingStrategyHealthScore
+	}
+	return params
+}
+
+// RankProviders orders eligible providers best first the way SelectProvider would
+// choose them, without side effects such as recording affinity. When a routing rule
+// matches, its provider is ranked first and the rule is returned
+func (ps *ProviderSelector) RankProviders(ctx context.Context, req *PaymentRequest) ([]*ProviderConfig, *RoutingRule, error) {
+	eligible, err := ps.registry.GetEligiblePaymentProviders(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ranked := ps.rankByStrategy(ctx, req, eligible)
+
+	var matched *RoutingRule
+	if ps.rules != nil {
+		if rule, config := ps.rules.Match(req, ranked); config != nil {
+			ranked = moveToFront(ranked, config)
+			matched = rule
+		}
+	}
+	return ps.deprioritizeRateLimited(ranked), matched, nil
+}
+
+// MarkRateLimited deprioritizes a provider until retryAfter has passed, or for a
+// default cooldown when the provider didn't say
+func (ps *ProviderSelector) MarkRateLimited(name string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = defaultRateLimitCooldown
+	}
+	if retryAfter > maxRateLimitCooldown {
+		retryAfter = maxRateLimitCooldown
+	}
+
+	ps.mu.Lock()
+	ps.rateLimited[name] = time.Now().Add(retryAfter)
+	ps.mu.Unlock()
+
+	log.Printf("[Routing] Provider %s rate limited, deprioritized for %v", name, retryAfter)
+}
+
+// IsRateLimited reports whether a provider is still in a rate-limit cooldown
+func (ps *ProviderSelector) IsRateLimited(name string) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	until, exists := ps.rateLimited[name]
+	return exists && time.Now().Before(until)
+}
+
+// deprioritizeRateLimited moves rate-limited providers to the end, keeping the
+// order within each group
+func (ps *ProviderSelector) deprioritizeRateLimited(providers []*ProviderConfig) []*ProviderConfig {
+	ordered := make([]*ProviderConfig, 0, len(providers))
+	limited := make([]*ProviderConfig, 0)
+	for _, config := range providers {
+		if ps.IsRateLimited(config.Provider.Name()) {
+			limited = append(limited, config)
+		} else {
+			ordered = append(ordered, config)
+		}
+	}
+	return append(ordered, limited...)
+}
+
+// rankByStrategy sorts providers according to the active strategy
+func (ps *ProviderSelector) rankByStrategy(ctx context.Context, req *PaymentRequest, eligible []*ProviderConfig) []*ProviderConfig {
+	ranked := make([]*ProviderConfig, len(eligible))
+	copy(ranked, eligible)
+
+	byHealth := func() {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ps.calculateHealthScore(ranked[i]) > ps.calculateHealthScore(ranked[j])
+		})
+	}
+
+	switch ps.Strategy() {
+	case RoutingStrategyLeastLatency:
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ps.getProviderLatencyP95(ranked[i]) < ps.getProviderLatencyP95(ranked[j])
+		})
+	case RoutingStrategyHealthScore:
+		byHealth()
+	case RoutingStrategyLeastCost:
+		sort.SliceStable(ranked, func(i, j int) bool {
+			costI, costJ := ps.ExpectedCost(ranked[i], req), ps.ExpectedCost(ranked[j], req)
+			if costI != costJ {
+				return costI < costJ
+			}
+			return ps.calculateHealthScore(ranked[i]) > ps.calculateHealthScore(ranked[j])
+		})
+	case RoutingStrategyRoundRobin:
+		index := int(hashString(req.IdempotencyKey)) % len(ranked)
+		ranked = append(ranked[index:], ranked[:index]...)
+	case RoutingStrategyAffinity:
+		byHealth()
+		if req.UserID != "" {
+			providerName, err := ps.rdb.Get(ctx, fmt.Sprintf("provider_affinity:%s", req.UserID)).Result()
+			if err == nil {
+				for _, config := range ranked {
+					if config.Provider.Name() == providerName {
+						ranked = moveToFront(ranked, config)
+						break
+					}
+				}
+			}
+		}
+	}
+	return ranked
+}
+
+// moveToFront returns providers with config moved to the first position
+func moveToFront(providers []*ProviderConfig, config *ProviderConfig) []*ProviderConfig {
+	ordered := make([]*ProviderConfig, 0, len(providers))
+	ordered = append(ordered, config)
+	for _, p := range providers {
+		if p != config {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
+}
+
 // selectByPriority selects provider based on priority (existing logic)
 func (ps *ProviderSelector) selectByPriority(req *PaymentRequest) (*ProviderConfig, error) {
 	eligible, err := ps.registry.GetEligiblePaymentProviders(req)
@@ -152,7 +402,7 @@ func (ps *ProviderSelector) selectByAffinity(ctx context.Context, req *PaymentRe
 	// Store affinity for next time
 	if req.UserID != "" {
 		affinityKey := fmt.Sprintf("provider_affinity:%s", req.UserID)
-		ps.rdb.Set(ctx, affinityKey, config.Provider.Name(), 24*time.Hour)
+		ps.rdb.Set(ctx, affinityKey, config.Provider.Name(), affinityTTL)
 	}
 
 	return config, nil
@@ -176,6 +426,48 @@ func (ps *ProviderSelector) selectRoundRobin(req *PaymentRequest) (*ProviderConf
 	return eligible[index], nil
 }
 
+// selectByLeastCost selects the provider with the lowest expected fee, using health score as a tiebreaker
+func (ps *ProviderSelector) selectByLeastCost(req *PaymentRequest) (*ProviderConfig, error) {
+	eligible, err := ps.registry.GetEligiblePaymentProviders(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(eligible) == 0 {
+		return nil, fmt.Errorf("no eligible providers for request")
+	}
+
+	type providerCost struct {
+		config *ProviderConfig
+		cost   int64
+		score  float64
+	}
+
+	costs := make([]providerCost, 0, len(eligible))
+	for _, config := range eligible {
+		costs = append(costs, providerCost{
+			config: config,
+			cost:   ps.ExpectedCost(config, req),
+			score:  ps.calculateHealthScore(config),
+		})
+	}
+
+	// Sort by cost (ascending), then by score (descending)
+	sort.SliceStable(costs, func(i, j int) bool {
+		if costs[i].cost != costs[j].cost {
+			return costs[i].cost < costs[j].cost
+		}
+		return costs[i].score > costs[j].score
+	})
+
+	return costs[0].config, nil
+}
+
+// ExpectedCost returns the fee a provider would charge for a request, in minor units
+func (ps *ProviderSelector) ExpectedCost(config *ProviderConfig, req *PaymentRequest) int64 {
+	return config.Fees.FeeFor(req.Currency).Cost(req.Amount)
+}
+
 // calculateHealthScore computes composite health score for a provider
 func (ps *ProviderSelector) calculateHealthScore(config *ProviderConfig) float64 {
 	// Get provider metrics (would come from ServerMetrics in real implementation)
@@ -185,17 +477,25 @@ func (ps *ProviderSelector) calculateHealthScore(config *ProviderConfig) float64
 
 	// Weighted composite score
 	// successRate: 40%, latencyScore: 30%, availabilityScore: 30%
-	healthScore := (successRate * 0.4) +
-		(latencyScore * 0.3) +
-		(availabilityScore * 0.3)
+	healthScore := (successRate * healthWeightSuccessRate) +
+		(latencyScore * healthWeightLatency) +
+		(availabilityScore * healthWeightAvailability)
 
 	return healthScore
 }
 
 // getProviderSuccessRate returns success rate for a provider (0.0 to 1.0)
 func (ps *ProviderSelector) getProviderSuccessRate(config *ProviderConfig) float64 {
-	// In real implementation, this would query ServerMetrics
-	// For now, return a default high score
+	if metrics := ps.providerMetrics(config); metrics != nil {
+		if rate, samples := metrics.RecentSuccessRate(); samples >= minRoutingSamples {
+			return rate
+		}
+	}
+
+	// Not enough traffic observed yet, assume the provider meets its SLA
+	if config.SLA.MinSuccessRate > 0 {
+		return config.SLA.MinSuccessRate
+	}
 	return 0.95
 }
 
@@ -237,24 +537,52 @@ func (ps *ProviderSelector) getProviderAvailabilityScore(config *ProviderConfig)
 
 // getProviderLatencyP95 returns P95 latency for a provider in milliseconds
 func (ps *ProviderSelector) getProviderLatencyP95(config *ProviderConfig) int64 {
-	// In real implementation, this would query ServerMetrics
-	// For now, return SLA threshold or default
+	if metrics := ps.providerMetrics(config); metrics != nil && metrics.LatencyTracker != nil {
+		if metrics.LatencyTracker.GetSampleCount() >= minRoutingSamples {
+			return metrics.LatencyTracker.GetPercentiles().P95.Milliseconds()
+		}
+	}
+
+	// Not enough traffic observed yet, fall back to the SLA threshold
 	if config.SLA.MaxLatencyP95Ms > 0 {
 		return int64(config.SLA.MaxLatencyP95Ms)
 	}
 	return 500 // Default 500ms
 }
 
+// providerMetrics returns the observed gateway metrics for a provider, if any
+func (ps *ProviderSelector) providerMetrics(config *ProviderConfig) *ServerMetrics {
+	if ps.pool == nil {
+		return nil
+	}
+	metrics, err := ps.pool.GetServerByGateway(config.Provider.Name())
+	if err != nil {
+		return nil
+	}
+	return metrics
+}
+
 // isProviderEligible checks if a provider can handle this request
 func (ps *ProviderSelector) isProviderEligible(config *ProviderConfig, req *PaymentRequest) bool {
 	if !config.Enabled {
 		return false
 	}
 
+	// Providers in a maintenance window are out of rotation
+	if inMaintenance(config.Name) {
+		return false
+	}
+
+	// So are providers the merchant excludes, and affinity mustn't pull a merchant with
+	// preferred providers away from them
+	if req.Providers.Excludes(config.Name) || req.Providers.prefersOther(config.Name) {
+		return false
+	}
+
 	caps := config.Provider.Capabilities()
 
-	// Check amount limits
-	if req.Amount < caps.MinAmountCents || req.Amount > caps.MaxAmountCents {
+	// Check amount limits, scaled to the currency's minor unit
+	if !money.WithinLimits(req.Amount, req.Currency, caps.MinAmountCents, caps.MaxAmountCents) {
 		return false
 	}
 
@@ -292,7 +620,13 @@ func hashString(s string) uint32 {
 
 // GetRoutingReason returns human-readable reason for routing decision
 func (ps *ProviderSelector) GetRoutingReason(config *ProviderConfig, req *PaymentRequest) string {
-	switch ps.strategy {
+	if ps.rules != nil {
+		if rule, matched := ps.rules.Match(req, []*ProviderConfig{config}); matched != nil {
+			return fmt.Sprintf("rule (%s)", rule.ID)
+		}
+	}
+
+	switch ps.Strategy() {
 	case RoutingStrategyLeastLatency:
 		p95 := ps.getProviderLatencyP95(config)
 		return fmt.Sprintf("least_latency (P95: %dms)", p95)
@@ -303,6 +637,8 @@ func (ps *ProviderSelector) GetRoutingReason(config *ProviderConfig, req *Paymen
 		return "user_affinity"
 	case RoutingStrategyRoundRobin:
 		return "round_robin"
+	case RoutingStrategyLeastCost:
+		return fmt.Sprintf("least_cost (fee: %d %s)", ps.ExpectedCost(config, req), req.Currency)
 	case RoutingStrategyPriority:
 		return fmt.Sprintf("priority_%d", config.Priority)
 	default:
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Fields a routing condition can match on
+const (
+	RuleFieldCurrency = "currency"
+	RuleFieldAmount   = "amount"
+	RuleFieldRegion   = "region"
+	RuleFieldBIN      = "bin"
+)
+
+// Operators supported per field. Amount compares numerically, the others as strings
+var ruleOperators = map[string][]string{
+	RuleFieldCurrency: {"==", "!=", "in"},
+	RuleFieldAmount:   {"==", "!=", ">", ">=", "<", "<="},
+	RuleFieldRegion:   {"==", "!=", "in"},
+	RuleFieldBIN:      {"==", "!=", "in", "prefix"},
+}
+
+var (
+	ErrRoutingRuleNotFound = errors.New("routing rule not found")
+	ErrInvalidRoutingRule  = errors.New("invalid routing rule")
+)
+
+// RoutingCondition is a single comparison such as currency == INR
+type RoutingCondition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// RoutingRule sends requests matching all of its conditions to a provider
+type RoutingRule struct {
+	ID         string             `json:"id"`
+	Name       string             `json:"name,omitempty"`
+	Conditions []RoutingCondition `json:"conditions"`
+	Provider   string             `json:"provider"`
+	Disabled   bool               `json:"disabled,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// RoutingRuleStore persists the ordered routing rule set
+type RoutingRuleStore interface {
+	ListRoutingRules() ([]RoutingRule, error)
+	// ReplaceRoutingRules atomically replaces all rules, keeping their order
+	ReplaceRoutingRules(rules []RoutingRule) error
+}
+
+// RoutingRuleEngine evaluates ordered rules ahead of strategy-based selection
+type RoutingRuleEngine struct {
+	store RoutingRuleStore
+	rules []RoutingRule
+	mu    sync.RWMutex
+}
+
+var routingRules *RoutingRuleEngine
+
+// NewRoutingRuleEngine creates an engine and loads persisted rules. A nil store keeps rules in memory only
+func NewRoutingRuleEngine(store RoutingRuleStore) *RoutingRuleEngine {
+	engine := &RoutingRuleEngine{
+		store: store,
+		rules: make([]RoutingRule, 0),
+	}
+
+	if store != nil {
+		rules, err := store.ListRoutingRules()
+		if err != nil {
+			log.Printf("[RoutingRules] Failed to load rules: %v", err)
+		} else {
+			engine.rules = rules
+			log.Printf("[RoutingRules] Loaded %d rules", len(rules))
+		}
+	}
+
+	return engine
+}
+
+// Rules returns a copy of the current rules in evaluation order
+func (re *RoutingRuleEngine) Rules() []RoutingRule {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+
+	rules := make([]RoutingRule, len(re.rules))
+	copy(rules, re.rules)
+	return rules
+}
+
+// Replace validates and installs a new rule set
+func (re *RoutingRuleEngine) Replace(rules []RoutingRule) error {
+	now := time.Now().UTC()
+	for i := range rules {
+		if err := validateRoutingRule(&rules[i]); err != nil {
+			return fmt.Errorf("%w: rule %d: %v", ErrInvalidRoutingRule, i, err)
+		}
+		if rules[i].ID == "" {
+			rules[i].ID = "rr_" + uuid.NewString()
+		}
+		if rules[i].CreatedAt.IsZero() {
+			rules[i].CreatedAt = now
+		}
+		rules[i].UpdatedAt = now
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if re.store != nil {
+		if err := re.store.ReplaceRoutingRules(rules); err != nil {
+			return err
+		}
+	}
+	re.rules = rules
+	return nil
+}
+
+// Add appends a rule, or inserts it at position when position is within range
+func (re *RoutingRuleEngine) Add(rule RoutingRule, position int) (*RoutingRule, error) {
+	rules := re.Rules()
+	if position < 0 || position > len(rules) {
+		position = len(rules)
+	}
+	rules = append(rules[:position], append([]RoutingRule{rule}, rules[position:]...)...)
+
+	if err := re.Replace(rules); err != nil {
+		return nil, err
+	}
+	return &rules[position], nil
+}
+
+// Remove deletes a rule by ID
+func (re *RoutingRuleEngine) Remove(id string) error {
+	rules := re.Rules()
+	for i, rule := range rules {
+		if rule.ID == id {
+			return re.Replace(append(rules[:i], rules[i+1:]...))
+		}
+	}
+	return ErrRoutingRuleNotFound
+}
+
+// Match returns the first rule that isn't disabled whose conditions all hold for the request
+// and whose provider is among the eligible providers
+func (re *RoutingRuleEngine) Match(req *PaymentRequest, eligible []*ProviderConfig) (*RoutingRule, *ProviderConfig) {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+
+	for i := range re.rules {
+		rule := &re.rules[i]
+		if rule.Disabled || !rule.matches(req) {
+			continue
+		}
+		for _, config := range eligible {
+			if config.Provider.Name() == rule.Provider {
+				matched := *rule
+				return &matched, config
+			}
+		}
+		log.Printf("[RoutingRules] Rule %s matched but provider %s is not eligible", rule.ID, rule.Provider)
+	}
+	return nil, nil
+}
+
+func (rule *RoutingRule) matches(req *PaymentRequest) bool {
+	for _, cond := range rule.Conditions {
+		if !cond.matches(req) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c RoutingCondition) matches(req *PaymentRequest) bool {
+	if c.Field == RuleFieldAmount {
+		value, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return false
+		}
+		switch c.Op {
+		case "==":
+			return req.Amount == value
+		case "!=":
+			return req.Amount != value
+		case ">":
+			return req.Amount > value
+		case ">=":
+			return req.Amount >= value
+		case "<":
+			return req.Amount < value
+		case "<=":
+			return req.Amount <= value
+		}
+		return false
+	}
+
+	var actual string
+	switch c.Field {
+	case RuleFieldCurrency:
+		actual = req.Currency
+	case RuleFieldRegion:
+		actual = req.Region
+	case RuleFieldBIN:
+		actual = req.BIN
+	}
+
+	switch c.Op {
+	case "==":
+		return strings.EqualFold(actual, c.Value)
+	case "!=":
+		return !strings.EqualFold(actual, c.Value)
+	case "in":
+		for _, v := range strings.Split(c.Value, ",") {
+			if strings.EqualFold(actual, strings.TrimSpace(v)) {
+				return true
+			}
+		}
+		return false
+	case "prefix":
+		return actual != "" && strings.HasPrefix(actual, c.Value)
+	}
+	return false
+}
+
+// validateRoutingRule checks fields, operators, values and the target provider
+func validateRoutingRule(rule *RoutingRule) error {
+	if rule.Provider == "" {
+		return errors.New("provider is required")
+	}
+	if providerRegistry != nil && !providerRegistry.HasPaymentProvider(rule.Provider) {
+		return fmt.Errorf("unknown provider %q", rule.Provider)
+	}
+	if len(rule.Conditions) == 0 {
+		return errors.New("at least one condition is required")
+	}
+
+	for _, cond := range rule.Conditions {
+		ops, ok := ruleOperators[cond.Field]
+		if !ok {
+			return fmt.Errorf("unknown field %q", cond.Field)
+		}
+		valid := false
+		for _, op := range ops {
+			if op == cond.Op {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("operator %q not supported for %s", cond.Op, cond.Field)
+		}
+		if cond.Field == RuleFieldAmount {
+			if _, err := strconv.ParseInt(cond.Value, 10, 64); err != nil {
+				return fmt.Errorf("amount value %q must be an integer", cond.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// routingRuleErrorStatus maps rule engine errors to HTTP status codes
+func routingRuleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRoutingRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidRoutingRule):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// AdminRoutingRulesHandler handles /admin/routing/rules:
+// GET lists rules, POST adds one (?position= to insert), PUT replaces the whole ordered set
+func AdminRoutingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules := routingRules.Rules()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rules": rules,
+			"total": len(rules),
+		})
+
+	case http.MethodPost:
+		var rule RoutingRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		position := -1
+		if p := r.URL.Query().Get("position"); p != "" {
+			var err error
+			if position, err = strconv.Atoi(p); err != nil {
+				http.Error(w, "position must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		created, err := routingRules.Add(rule, position)
+		if err != nil {
+			http.Error(w, err.Error(), routingRuleErrorStatus(err))
+			return
+		}
+
+		log.Printf("[RoutingRules] Added rule %s -> %s", created.ID, created.Provider)
+		recordAudit(r, "add_routing_rule", created.ID, nil, created)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+
+	case http.MethodPut:
+		var rules []RoutingRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		before := routingRules.Rules()
+		if err := routingRules.Replace(rules); err != nil {
+			http.Error(w, err.Error(), routingRuleErrorStatus(err))
+			return
+		}
+
+		log.Printf("[RoutingRules] Replaced rule set (%d rules)", len(rules))
+		recordAudit(r, "replace_routing_rules", "routing_rules", before, rules)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Routing rules updated",
+			"rules":   rules,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminRoutingRuleDeleteHandler handles DELETE /admin/routing/rules/{rule_id}
+func AdminRoutingRuleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("rule_id")
+	var before interface{}
+	for _, rule := range routingRules.Rules() {
+		if rule.ID == id {
+			before = rule
+			break
+		}
+	}
+	if err := routingRules.Remove(id); err != nil {
+		http.Error(w, err.Error(), routingRuleErrorStatus(err))
+		return
+	}
+
+	log.Printf("[RoutingRules] Removed rule %s", id)
+	recordAudit(r, "remove_routing_rule", id, before, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Routing rule removed",
+		"rule_id": id,
+	})
+}
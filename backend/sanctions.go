@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Screening outcomes
+const (
+	ScreeningClear          = "clear"
+	ScreeningPotentialMatch = "potential_match"
+	ScreeningMatch          = "match"
+)
+
+// ScreeningRequest describes a party to check against sanctions and PEP lists
+type ScreeningRequest struct {
+	Reference string `json:"reference"` // Payment or payout being screened
+	Name      string `json:"name,omitempty"`
+	Country   string `json:"country,omitempty"`
+}
+
+// ScreeningResult is a provider's answer, including the list version it screened against
+type ScreeningResult struct {
+	ScreeningID string    `json:"screening_id"`
+	Outcome     string    `json:"outcome"`
+	ListVersion string    `json:"list_version"`
+	Matches     []string  `json:"matches,omitempty"`
+	Provider    string    `json:"provider"`
+	ScreenedAt  time.Time `json:"screened_at"`
+}
+
+// ScreeningProvider checks parties against sanctions, PEP and embargo lists. Unlike
+// a ComplianceProvider it verifies who a party is, not whether their documents are
+type ScreeningProvider interface {
+	Name() string
+	Screen(ctx context.Context, req *ScreeningRequest) (*ScreeningResult, error)
+}
+
+// MockSanctionsProvider screens against the simulator's sanctions lists
+type MockSanctionsProvider struct {
+	name    string
+	baseURL string
+}
+
+func NewMockSanctionsProvider(baseURL string) *MockSanctionsProvider {
+	return &MockSanctionsProvider{
+		name:    "sanctions",
+		baseURL: baseURL,
+	}
+}
+
+func (p *MockSanctionsProvider) Name() string {
+	return p.name
+}
+
+func (p *MockSanctionsProvider) Screen(ctx context.Context, req *ScreeningRequest) (*ScreeningResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := providerHTTPClient(p.name).Do(httpReq)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeNetworkError, "network_error", err.Error(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "read_error", err.Error(), err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewProviderError(ErrCodeProviderError, "screening_failed", fmt.Sprintf("screening returned %d", resp.StatusCode), nil)
+	}
+
+	var result struct {
+		ID          string   `json:"id"`
+		Result      string   `json:"result"`
+		ListVersion string   `json:"list_version"`
+		Matches     []string `json:"matches"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, NewProviderError(ErrCodeProviderError, "malformed_response", "Invalid JSON response", err)
+	}
+
+	return &ScreeningResult{
+		ScreeningID: result.ID,
+		Outcome:     result.Result,
+		ListVersion: result.ListVersion,
+		Matches:     result.Matches,
+		Provider:    p.name,
+		ScreenedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// SanctionsConfig holds when transactions are screened. Amounts are in cents
+type SanctionsConfig struct {
+	PayoutThreshold      int64  // Screen payouts at or above this amount
+	CrossBorderThreshold int64  // Screen cross-border payments at or above this amount
+	HomeCountry          string // Payments from any other country are cross-border
+	Timeout              time.Duration
+}
+
+// DefaultSanctionsConfig returns sensible defaults: every payout is screened, and
+// cross-border payments from $1,000
+func DefaultSanctionsConfig() SanctionsConfig {
+	return SanctionsConfig{
+		PayoutThreshold:      0,
+		CrossBorderThreshold: 100000,
+		HomeCountry:          "US",
+		Timeout:              5 * time.Second,
+	}
+}
+
+// SanctionsConfigFromEnv builds the screening config from SANCTIONS_* environment
+// variables, falling back to the defaults
+func SanctionsConfigFromEnv() (SanctionsConfig, error) {
+	config := DefaultSanctionsConfig()
+
+	if v := os.Getenv("SANCTIONS_PAYOUT_THRESHOLD"); v != "" {
+		amount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || amount < 0 {
+			return config, fmt.Errorf("SANCTIONS_PAYOUT_THRESHOLD must be a non-negative integer, got %q", v)
+		}
+		config.PayoutThreshold = amount
+	}
+	if v := os.Getenv("SANCTIONS_CROSS_BORDER_THRESHOLD"); v != "" {
+		amount, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || amount < 0 {
+			return config, fmt.Errorf("SANCTIONS_CROSS_BORDER_THRESHOLD must be a non-negative integer, got %q", v)
+		}
+		config.CrossBorderThreshold = amount
+	}
+	if v := os.Getenv("SANCTIONS_HOME_COUNTRY"); v != "" {
+		if len(v) != 2 {
+			return config, fmt.Errorf("SANCTIONS_HOME_COUNTRY must be an ISO 3166 alpha-2 code, got %q", v)
+		}
+		config.HomeCountry = strings.ToUpper(v)
+	}
+	if v := os.Getenv("SANCTIONS_TIMEOUT_MS"); v != "" {
+		timeoutMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || timeoutMs <= 0 {
+			return config, fmt.Errorf("SANCTIONS_TIMEOUT_MS must be a positive integer, got %q", v)
+		}
+		config.Timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	return config, nil
+}
+
+// SanctionsDecision is what was decided for one screened transaction and why
+type SanctionsDecision struct {
+	Reference string           `json:"reference"`
+	Kind      string           `json:"kind"` // payment or payout
+	Result    *ScreeningResult `json:"result"`
+	DecidedAt time.Time        `json:"decided_at"`
+}
+
+// Blocked reports whether the transaction must not go through
+func (d *SanctionsDecision) Blocked() bool {
+	return d.Result.Outcome == ScreeningMatch
+}
+
+// Reason summarizes the decision for errors and state transitions
+func (d *SanctionsDecision) Reason() string {
+	reason := fmt.Sprintf("sanctions screening %s against list %s", d.Result.Outcome, d.Result.ListVersion)
+	if len(d.Result.Matches) > 0 {
+		reason += " (" + strings.Join(d.Result.Matches, ", ") + ")"
+	}
+	return reason
+}
+
+// sanctionsDecisionTTL is how long decisions are kept for audit lookups
+const sanctionsDecisionTTL = 90 * 24 * time.Hour
+
+func sanctionsDecisionKey(reference string) string {
+	return paymentNamespace(reference) + "sanctions_decision:" + reference
+}
+
+// SanctionsScreener decides which payouts and payments are screened and records
+// every decision together with the list version it was made against
+type SanctionsScreener struct {
+	provider ScreeningProvider
+	config   SanctionsConfig
+	rdb      *redis.Client
+}
+
+// sanctionsScreener is nil when no screening provider is configured
+var sanctionsScreener *SanctionsScreener
+
+// NewSanctionsScreener creates a screener backed by a provider
+func NewSanctionsScreener(provider ScreeningProvider, config SanctionsConfig, client *redis.Client) *SanctionsScreener {
+	return &SanctionsScreener{provider: provider, config: config, rdb: client}
+}
+
+// NeedsPayoutScreening reports whether a payout amount requires screening
+func (ss *SanctionsScreener) NeedsPayoutScreening(amount int64) bool {
+	return amount >= ss.config.PayoutThreshold
+}
+
+// NeedsPaymentScreening reports whether a payment is cross-border and large enough to screen
+func (ss *SanctionsScreener) NeedsPaymentScreening(country string, amount int64) bool {
+	return country != "" && !strings.EqualFold(country, ss.config.HomeCountry) && amount >= ss.config.CrossBorderThreshold
+}
+
+// Screen checks a party and records the decision. Errors mean no decision was made
+func (ss *SanctionsScreener) Screen(ctx context.Context, kind string, req *ScreeningRequest) (*SanctionsDecision, error) {
+	screenCtx, cancel := context.WithTimeout(ctx, ss.config.Timeout)
+	defer cancel()
+
+	result, err := ss.provider.Screen(screenCtx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &SanctionsDecision{
+		Reference: req.Reference,
+		Kind:      kind,
+		Result:    result,
+		DecidedAt: time.Now().UTC(),
+	}
+	if data, err := json.Marshal(decision); err == nil {
+		if err := ss.rdb.Set(ctx, sanctionsDecisionKey(req.Reference), data, sanctionsDecisionTTL).Err(); err != nil {
+			log.Printf("[Sanctions] Failed to record decision for %s: %v", req.Reference, err)
+		}
+	}
+	if result.Outcome != ScreeningClear {
+		appLogger.Warn("Sanctions screening flagged a transaction", map[string]interface{}{
+			"operation":    "sanctions_screening",
+			"reference":    req.Reference,
+			"kind":         kind,
+			"outcome":      result.Outcome,
+			"list_version": result.ListVersion,
+			"matches":      result.Matches,
+		})
+	}
+	return decision, nil
+}
+
+// sanctionsReviewReason reports whether a payment's screening holds it for review once authorized
+func sanctionsReviewReason(req *PaymentRequest) (bool, string) {
+	decision, ok := req.Metadata["sanctions"].(*SanctionsDecision)
+	if !ok || decision.Result.Outcome != ScreeningPotentialMatch {
+		return false, ""
+	}
+	return true, decision.Reason()
+}
+
+// AdminSanctionsDecisionHandler handles GET /admin/sanctions/{reference}, returning
+// the recorded screening decision for a payment or payout
+func AdminSanctionsDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	if sanctionsScreener == nil {
+		http.Error(w, "Sanctions screening is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	reference := r.PathValue("reference")
+	data, err := sanctionsScreener.rdb.Get(r.Context(), sanctionsDecisionKey(reference)).Result()
+	if err == redis.Nil {
+		http.Error(w, "No screening decision for "+reference, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch decision", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduled payment states
+const (
+	ScheduledPending    = "PENDING"
+	ScheduledDispatched = "DISPATCHED"
+	ScheduledCancelled  = "CANCELLED"
+)
+
+// scheduledPaymentStatus is the payment record status while a payment waits for its schedule_at
+const scheduledPaymentStatus = "SCHEDULED"
+
+// Errors returned when cancelling a scheduled payment
+var (
+	ErrScheduledPaymentNotFound   = errors.New("scheduled payment not found")
+	ErrScheduledPaymentNotPending = errors.New("scheduled payment is no longer pending")
+)
+
+// ScheduledPayment is a one-off payment to be submitted at a future time
+type ScheduledPayment struct {
+	PaymentID    string    `json:"payment_id"`
+	OrderID      string    `json:"order_id"`
+	Amount       int64     `json:"amount"`
+	Currency     string    `json:"currency"`
+	UserID       string    `json:"user_id,omitempty"`
+	MerchantID   string    `json:"merchant_id"`
+	PaymentToken string    `json:"payment_token,omitempty"`
+	ScheduleAt   time.Time `json:"schedule_at"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ScheduledPaymentStore persists scheduled payments
+type ScheduledPaymentStore interface {
+	CreateScheduledPayment(sp *ScheduledPayment) error
+	// ListScheduledPayments returns a merchant's scheduled payments, all statuses when status is empty
+	ListScheduledPayments(merchantID, status string) ([]ScheduledPayment, error)
+	// CancelScheduledPayment cancels one of a merchant's payments that is still pending. It
+	// returns ErrScheduledPaymentNotFound for another merchant's payment and
+	// ErrScheduledPaymentNotPending once the payment was dispatched or cancelled
+	CancelScheduledPayment(merchantID, paymentID string) error
+	// ClaimDueScheduledPayments marks up to limit pending payments due at now as dispatched and returns them
+	ClaimDueScheduledPayments(now time.Time, limit int) ([]ScheduledPayment, error)
+}
+
+// SchedulePayment persists a future-dated payment and records it as SCHEDULED
+func SchedulePayment(sp *ScheduledPayment) error {
+	if dataStore == nil {
+		return errors.New("database connection is nil")
+	}
+
+	now := time.Now().UTC()
+	sp.Status = ScheduledPending
+	sp.CreatedAt = now
+	sp.UpdatedAt = now
+	if err := dataStore.CreateScheduledPayment(sp); err != nil {
+		return err
+	}
+
+	if err := UpdatePaymentRecord(sp.PaymentID, func(record *PaymentRecord) {
+		record.OrderID = sp.OrderID
+		record.Status = scheduledPaymentStatus
+		record.Amount = sp.Amount
+		record.Currency = sp.Currency
+		record.UserID = sp.UserID
+		record.MerchantID = sp.MerchantID
+	}); err != nil {
+		log.Printf("Failed to save payment record for %s: %v", sp.PaymentID, err)
+	}
+	return nil
+}
+
+// ScheduledPaymentDispatcher submits scheduled payments once they come due
+type ScheduledPaymentDispatcher struct {
+	store     ScheduledPaymentStore
+	interval  time.Duration
+	maxJitter time.Duration
+	batchSize int
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewScheduledPaymentDispatcher creates a dispatcher polling at interval. Each due
+// payment is delayed by a random jitter up to maxJitter so payments scheduled for the
+// same instant don't hit the gateways as a single burst
+func NewScheduledPaymentDispatcher(store ScheduledPaymentStore, interval, maxJitter time.Duration, batchSize int) *ScheduledPaymentDispatcher {
+	return &ScheduledPaymentDispatcher{
+		store:     store,
+		interval:  interval,
+		maxJitter: maxJitter,
+		batchSize: batchSize,
+		stopChan:  make(chan bool),
+	}
+}
+
+// Start launches the dispatcher goroutine
+func (sd *ScheduledPaymentDispatcher) Start() {
+	sd.mu.Lock()
+	if sd.isRunning {
+		sd.mu.Unlock()
+		return
+	}
+	sd.isRunning = true
+	sd.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sd.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sd.dispatchDue()
+			case <-sd.stopChan:
+				log.Println("[ScheduledPayments] Dispatcher stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the dispatcher goroutine
+func (sd *ScheduledPaymentDispatcher) Stop() {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if sd.isRunning {
+		sd.stopChan <- true
+		sd.isRunning = false
+	}
+}
+
+func (sd *ScheduledPaymentDispatcher) dispatchDue() {
+	due, err := sd.store.ClaimDueScheduledPayments(time.Now().UTC(), sd.batchSize)
+	if err != nil {
+		log.Printf("[ScheduledPayments] Failed to claim due payments: %v", err)
+		return
+	}
+
+	for _, sp := range due {
+		var jitter time.Duration
+		if sd.maxJitter > 0 {
+			jitter = time.Duration(rand.Int63n(int64(sd.maxJitter)))
+		}
+
+		go func(sp ScheduledPayment) {
+			time.Sleep(jitter)
+			correlationID := generateCorrelationID()
+
+			appLogger.Info("Dispatching scheduled payment", map[string]interface{}{
+				"correlation_id": correlationID,
+				"payment_id":     sp.PaymentID,
+				"schedule_at":    sp.ScheduleAt,
+				"delay_ms":       time.Since(sp.ScheduleAt).Milliseconds(),
+			})
+
+			var card *CardData
+			if sp.PaymentToken != "" {
+				var err error
+				card, err = GetVault().Detokenize(sp.MerchantID, sp.PaymentToken)
+				if err != nil {
+					log.Printf("[ScheduledPayments] Failed to detokenize card for %s: %v", sp.PaymentID, err)
+					notifyClient(sp.PaymentID, FAILED, err)
+					return
+				}
+			}
+
+			lock, err := startPayment(sp.OrderID, int(sp.Amount), sp.PaymentID, sp.Currency, sp.UserID, sp.MerchantID, correlationID, false)
+			if err != nil {
+				log.Printf("[ScheduledPayments] Not dispatching %s: %v", sp.PaymentID, err)
+				return
+			}
+			processPaymentAsync(&PaymentRequest{
+				ID:            sp.OrderID,
+				Amount:        sp.Amount,
+				Currency:      sp.Currency,
+				UserID:        sp.UserID,
+				PaymentToken:  sp.PaymentToken,
+				Providers:     merchantProviderPreferences(sp.MerchantID),
+				DeclinePolicy: merchantDeclinePolicy(sp.MerchantID),
+			}, sp.PaymentID, correlationID, card, lock)
+		}(sp)
+	}
+}
+
+// ScheduledPaymentsHandler handles GET /payments/scheduled?status=
+func ScheduledPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Scheduled payments not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	payments, err := dataStore.ListScheduledPayments(merchantIDFromContext(r.Context()), strings.ToUpper(r.URL.Query().Get("status")))
+	if err != nil {
+		http.Error(w, "Failed to fetch scheduled payments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"payments": payments,
+		"total":    len(payments),
+	})
+}
+
+// CancelScheduledPaymentHandler handles DELETE /payments/scheduled/{payment_id}
+func CancelScheduledPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Scheduled payments not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	paymentID := r.PathValue("payment_id")
+	if err := dataStore.CancelScheduledPayment(merchantIDFromContext(r.Context()), paymentID); err != nil {
+		switch {
+		case errors.Is(err, ErrScheduledPaymentNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrScheduledPaymentNotPending):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to cancel scheduled payment", http.StatusInternalServerError)
+		return
+	}
+
+	if err := UpdatePaymentRecord(paymentID, func(record *PaymentRecord) {
+		record.Status = CANCELLED.String()
+	}); err != nil {
+		log.Printf("Failed to save payment record for %s: %v", paymentID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewSuccessResponse(CANCELLED.String(), paymentID, map[string]interface{}{
+		"message": "Scheduled payment cancelled",
+	}))
+}
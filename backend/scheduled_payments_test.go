@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCancelScheduledPaymentHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string // the caller's payment's status, empty when it isn't the caller's
+		want   int
+	}{
+		{"another merchant's payment", "", http.StatusNotFound},
+		{"already dispatched", ScheduledDispatched, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mock := newMockStore(t)
+			dataStore = store
+			t.Cleanup(func() { dataStore = nil })
+
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE scheduled_payments SET status = $1, updated_at = $2 WHERE merchant_id = $3 AND payment_id = $4 AND status = $5`)).
+				WithArgs(ScheduledCancelled, sqlmock.AnyArg(), "merchant_b", "pay_1", ScheduledPending).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			rows := sqlmock.NewRows([]string{"status"})
+			if tt.status != "" {
+				rows.AddRow(tt.status)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT status FROM scheduled_payments WHERE merchant_id = $1 AND payment_id = $2`)).
+				WithArgs("merchant_b", "pay_1").
+				WillReturnRows(rows)
+
+			req := httptest.NewRequest(http.MethodDelete, "/payments/scheduled/pay_1", nil)
+			req.SetPathValue("payment_id", "pay_1")
+			req = req.WithContext(context.WithValue(req.Context(), "api_key", "merchant_b"))
+			rec := httptest.NewRecorder()
+			CancelScheduledPaymentHandler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is a permission granted to an API client
+type Scope string
+
+const (
+	ScopePaymentsRead  Scope = "payments:read"
+	ScopePaymentsWrite Scope = "payments:write"
+	ScopeRefunds       Scope = "refunds"
+	ScopeAdmin         Scope = "admin"
+)
+
+// AllScopes lists every scope, in the order they are reported
+var AllScopes = []Scope{ScopePaymentsRead, ScopePaymentsWrite, ScopeRefunds, ScopeAdmin}
+
+// ParseScopes parses a space or comma separated scope list, rejecting unknown scopes.
+// Duplicates are dropped
+func ParseScopes(s string) ([]Scope, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' })
+	scopes := make([]Scope, 0, len(fields))
+	seen := make(map[Scope]bool, len(fields))
+	for _, field := range fields {
+		scope := Scope(field)
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q", field)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func isKnownScope(scope Scope) bool {
+	for _, known := range AllScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// hasScope reports whether scopes grant required. An empty requirement is always met
+func hasScope(scopes []Scope, required Scope) bool {
+	if required == "" {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// formatScopes renders scopes as the space separated list OAuth2 uses
+func formatScopes(scopes []Scope) string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, " ")
+}
+
+// paymentRoutePrefixes are the merchant-facing resources guarded by the payments scopes
+var paymentRoutePrefixes = []string{
+	"/payment", "/payments", "/paymentKey", "/tokens", "/ledger", "/disputes",
+	"/subscriptions", "/payouts", "/bnpl", "/customers",
+}
+
+// requiredScope returns the scope a request needs, empty for routes any
+// authenticated client may call
+func requiredScope(r *http.Request) Scope {
+	path := r.URL.Path
+	if path == "/admin" || strings.HasPrefix(path, "/admin/") {
+		return ScopeAdmin
+	}
+	if strings.Contains(path, "/refund") {
+		return ScopeRefunds
+	}
+	for _, prefix := range paymentRoutePrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return ScopePaymentsRead
+			}
+			return ScopePaymentsWrite
+		}
+	}
+	return ""
+}
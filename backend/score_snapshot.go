@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Snapshots older than the TTL are dropped by Redis, so a server that was down for a
+// long time starts fresh instead of inheriting a verdict nobody can vouch for anymore
+const (
+	serverSnapshotTTL     = time.Hour
+	serverSnapshotTimeout = 500 * time.Millisecond
+)
+
+func serverSnapshotKey(serverURL string) string {
+	return "server_metrics:" + serverURL
+}
+
+// serverSnapshot is the persisted part of a server's metrics
+type serverSnapshot struct {
+	Score           float64            `json:"score"`
+	TotalRequests   int64              `json:"total_requests"`
+	SuccessRequests int64              `json:"success_requests"`
+	FailedRequests  int64              `json:"failed_requests"`
+	TotalLatency    time.Duration      `json:"total_latency"`
+	MinLatency      time.Duration      `json:"min_latency"`
+	MaxLatency      time.Duration      `json:"max_latency"`
+	ShortEWMA       time.Duration      `json:"short_ewma"`
+	LongEWMA        time.Duration      `json:"long_ewma"`
+	Percentiles     LatencyPercentiles `json:"percentiles"`
+	GatewayErrors   []ErrorEvent       `json:"gateway_errors"`
+	BankErrors      []ErrorEvent       `json:"bank_errors"`
+	NetworkErrors   []ErrorEvent       `json:"network_errors"`
+	ClientErrors    []ErrorEvent       `json:"client_errors"`
+	TakenAt         time.Time          `json:"taken_at"`
+}
+
+// snapshot captures the server's score and metrics
+func (sm *ServerMetrics) snapshot() serverSnapshot {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return serverSnapshot{
+		Score:           sm.Score,
+		TotalRequests:   sm.TotalRequests,
+		SuccessRequests: sm.SuccessRequests,
+		FailedRequests:  sm.FailedRequests,
+		TotalLatency:    sm.TotalLatency,
+		MinLatency:      sm.MinLatency,
+		MaxLatency:      sm.MaxLatency,
+		ShortEWMA:       sm.ShortEWMALatency,
+		LongEWMA:        sm.LongEWMALatency,
+		Percentiles:     sm.LatencyPercentiles,
+		GatewayErrors:   sm.GatewayErrors,
+		BankErrors:      sm.BankErrors,
+		NetworkErrors:   sm.NetworkErrors,
+		ClientErrors:    sm.ClientErrors,
+		TakenAt:         time.Now(),
+	}
+}
+
+// restore loads a snapshot into freshly created metrics. Error events keep their
+// original timestamps, so they still decay on schedule
+func (sm *ServerMetrics) restore(snap serverSnapshot) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.Score = snap.Score
+	sm.TotalRequests = snap.TotalRequests
+	sm.SuccessRequests = snap.SuccessRequests
+	sm.FailedRequests = snap.FailedRequests
+	sm.TotalLatency = snap.TotalLatency
+	if sm.TotalRequests > 0 {
+		sm.AvgLatency = time.Duration(int64(sm.TotalLatency) / sm.TotalRequests)
+	}
+	sm.MinLatency = snap.MinLatency
+	sm.MaxLatency = snap.MaxLatency
+	sm.ShortEWMALatency = snap.ShortEWMA
+	sm.LongEWMALatency = snap.LongEWMA
+	sm.LatencyPercentiles = snap.Percentiles
+	sm.GatewayErrors = append(sm.GatewayErrors[:0], snap.GatewayErrors...)
+	sm.BankErrors = append(sm.BankErrors[:0], snap.BankErrors...)
+	sm.NetworkErrors = append(sm.NetworkErrors[:0], snap.NetworkErrors...)
+	sm.ClientErrors = append(sm.ClientErrors[:0], snap.ClientErrors...)
+	sm.LastUpdated = time.Now()
+}
+
+// EnablePersistence snapshots server metrics to Redis on every score update and
+// restores them when a server is added, so scores survive restarts
+func (sp *ServerPool) EnablePersistence(client *redis.Client) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.rdb = client
+}
+
+// loadSnapshot restores a server's metrics from Redis if a snapshot exists
+func (sp *ServerPool) loadSnapshot(server *ServerMetrics) {
+	if sp.rdb == nil {
+		return
+	}
+
+	rCtx, cancel := context.WithTimeout(context.Background(), serverSnapshotTimeout)
+	defer cancel()
+
+	data, err := sp.rdb.Get(rCtx, serverSnapshotKey(server.ServerURL)).Bytes()
+	if err == redis.Nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load metrics snapshot for %s: %v", server.ServerURL, err)
+		return
+	}
+
+	var snap serverSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Printf("Discarding corrupt metrics snapshot for %s: %v", server.ServerURL, err)
+		return
+	}
+	server.restore(snap)
+	log.Printf("Restored metrics for %s from %s (score: %.2f, requests: %d)",
+		server.ServerURL, snap.TakenAt.Format(time.RFC3339), snap.Score, snap.TotalRequests)
+}
+
+// saveSnapshots writes the given servers' snapshots to Redis in one pipeline
+func (sp *ServerPool) saveSnapshots(servers []*ServerMetrics) {
+	if sp.rdb == nil || len(servers) == 0 {
+		return
+	}
+
+	rCtx, cancel := context.WithTimeout(context.Background(), serverSnapshotTimeout)
+	defer cancel()
+
+	pipe := sp.rdb.Pipeline()
+	for _, server := range servers {
+		data, err := json.Marshal(server.snapshot())
+		if err != nil {
+			log.Printf("Failed to encode metrics snapshot for %s: %v", server.ServerURL, err)
+			continue
+		}
+		pipe.Set(rCtx, serverSnapshotKey(server.ServerURL), data, serverSnapshotTTL)
+	}
+	if _, err := pipe.Exec(rCtx); err != nil {
+		log.Printf("Failed to save metrics snapshots: %v", err)
+	}
+}
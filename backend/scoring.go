@@ -35,7 +35,9 @@ type ServerMetrics struct {
 
 	// Latency tracking
 	TotalLatency       time.Duration
-	AvgLatency         time.Duration
+	AvgLatency         time.Duration // lifetime average, reported only
+	ShortEWMALatency   time.Duration // fast-moving average, reacts to spikes
+	LongEWMALatency    time.Duration // slow-moving average, tracks sustained latency
 	MinLatency         time.Duration
 	MaxLatency         time.Duration
 	LatencyTracker     *LatencyTracker
@@ -47,15 +49,32 @@ type ServerMetrics struct {
 	NetworkErrors []ErrorEvent
 	ClientErrors  []ErrorEvent
 
+	// Outcomes of the most recent requests, oldest first
+	recentResults []bool
+
 	ActiveConnections int
 	QueueDepth        int
 
+	// Currencies and regions the server accepts, empty for any
+	Currencies []string
+	Regions    []string
+
+	// Set by the health prober after consecutive failed probes
+	unhealthy bool
+
+	// Slow-start: when the server joined or recovered, and successes since
+	warmupStart     time.Time
+	warmupSuccesses int64
+
 	LastUpdated time.Time
 	LastRequest time.Time
 
 	mu sync.RWMutex
 }
 
+// recentResultWindow is how many request outcomes feed RecentSuccessRate
+const recentResultWindow = 200
+
 type ErrorEvent struct {
 	Timestamp time.Time
 	Message   string
@@ -70,6 +89,10 @@ type ScoringConfig struct {
 	LatencyPenaltyMed    float64
 	LatencyPenaltyHigh   float64
 
+	// Weight of each new sample in the short and long latency EWMAs (0.0 to 1.0)
+	LatencyDecayShort float64
+	LatencyDecayLong  float64
+
 	GatewayErrorPenalty float64
 	BankErrorPenalty    float64
 	NetworkErrorPenalty float64
@@ -84,6 +107,17 @@ type ScoringConfig struct {
 	MinScore          float64
 	MaxScore          float64
 	ScoreUpdatePeriod time.Duration
+
+	// A new or recovered server's selection weight ramps from SlowStartMinWeight to
+	// full over SlowStartWindow or SlowStartRequests successes, whichever comes first
+	SlowStartWindow    time.Duration
+	SlowStartRequests  int
+	SlowStartMinWeight float64
+
+	// How SelectServer picks a server, and the effective score a server needs to be
+	// considered by the least-outstanding mode
+	SelectionMode     SelectionMode
+	MinSelectionScore float64
 }
 
 func DefaultScoringConfig() *ScoringConfig {
@@ -95,6 +129,8 @@ func DefaultScoringConfig() *ScoringConfig {
 		LatencyPenaltyLow:    2.5,
 		LatencyPenaltyMed:    7.5,
 		LatencyPenaltyHigh:   15.0,
+		LatencyDecayShort:    0.3,
+		LatencyDecayLong:     0.02,
 		GatewayErrorPenalty:  5.0,
 		BankErrorPenalty:     2.5,
 		NetworkErrorPenalty:  7.5,
@@ -106,6 +142,11 @@ func DefaultScoringConfig() *ScoringConfig {
 		MinScore:             0.0,
 		MaxScore:             100.0,
 		ScoreUpdatePeriod:    10 * time.Second,
+		SlowStartWindow:      2 * time.Minute,
+		SlowStartRequests:    50,
+		SlowStartMinWeight:   0.1,
+		SelectionMode:        SelectionWeightedScore,
+		MinSelectionScore:    50.0,
 	}
 }
 
@@ -114,7 +155,7 @@ func NewServerMetrics(serverURL string) *ServerMetrics {
 		ServerURL:      serverURL,
 		Score:          100.0,
 		MinLatency:     time.Duration(math.MaxInt64),
-		LatencyTracker: NewLatencyTracker(1000), // Keep 1000 samples
+		LatencyTracker: NewLatencyTracker(defaultLatencyWindow),
 		GatewayErrors:  make([]ErrorEvent, 0),
 		BankErrors:     make([]ErrorEvent, 0),
 		NetworkErrors:  make([]ErrorEvent, 0),
@@ -123,7 +164,7 @@ func NewServerMetrics(serverURL string) *ServerMetrics {
 	}
 }
 
-func (sm *ServerMetrics) RecordRequest(latency time.Duration, success bool) {
+func (sm *ServerMetrics) RecordRequest(latency time.Duration, success bool, config *ScoringConfig) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -132,12 +173,22 @@ func (sm *ServerMetrics) RecordRequest(latency time.Duration, success bool) {
 
 	if success {
 		sm.SuccessRequests++
+		if !sm.warmupStart.IsZero() {
+			sm.warmupSuccesses++
+		}
 	} else {
 		sm.FailedRequests++
 	}
 
+	sm.recentResults = append(sm.recentResults, success)
+	if len(sm.recentResults) > recentResultWindow {
+		sm.recentResults = sm.recentResults[len(sm.recentResults)-recentResultWindow:]
+	}
+
 	sm.TotalLatency += latency
 	sm.AvgLatency = time.Duration(int64(sm.TotalLatency) / sm.TotalRequests)
+	sm.ShortEWMALatency = ewma(sm.ShortEWMALatency, latency, config.LatencyDecayShort, sm.TotalRequests)
+	sm.LongEWMALatency = ewma(sm.LongEWMALatency, latency, config.LatencyDecayLong, sm.TotalRequests)
 
 	// Track latency for percentile calculation
 	if sm.LatencyTracker != nil {
@@ -153,6 +204,24 @@ func (sm *ServerMetrics) RecordRequest(latency time.Duration, success bool) {
 	}
 }
 
+// ewma folds a latency sample into a moving average; the first sample seeds it
+func ewma(avg, sample time.Duration, alpha float64, count int64) time.Duration {
+	if count <= 1 {
+		return sample
+	}
+	return time.Duration(alpha*float64(sample) + (1-alpha)*float64(avg))
+}
+
+// scoringLatency is the latency the score is penalized on: the higher of the short and
+// long EWMAs, so spikes count immediately and sustained slowness keeps counting until
+// the long average has decayed
+func (sm *ServerMetrics) scoringLatency() time.Duration {
+	if sm.ShortEWMALatency > sm.LongEWMALatency {
+		return sm.ShortEWMALatency
+	}
+	return sm.LongEWMALatency
+}
+
 func (sm *ServerMetrics) RecordError(errorType ErrorType, message string) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -174,6 +243,29 @@ func (sm *ServerMetrics) RecordError(errorType ErrorType, message string) {
 	}
 }
 
+// BeginRequest counts a request sent to the server as outstanding until EndRequest
+func (sm *ServerMetrics) BeginRequest() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.ActiveConnections++
+}
+
+// EndRequest releases a request counted by BeginRequest
+func (sm *ServerMetrics) EndRequest() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.ActiveConnections > 0 {
+		sm.ActiveConnections--
+	}
+}
+
+// Outstanding returns the number of requests in flight to the server
+func (sm *ServerMetrics) Outstanding() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.ActiveConnections
+}
+
 func (sm *ServerMetrics) UpdateActiveConnections(count int) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -186,6 +278,128 @@ func (sm *ServerMetrics) GetScore() float64 {
 	return sm.Score
 }
 
+// SetTags sets the currencies and regions the server accepts
+func (sm *ServerMetrics) SetTags(currencies, regions []string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.Currencies = currencies
+	sm.Regions = regions
+}
+
+// SupportsCurrency reports whether the server accepts the currency. An untagged server
+// or an empty currency always matches
+func (sm *ServerMetrics) SupportsCurrency(currency string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return matchesTag(sm.Currencies, currency)
+}
+
+// SupportsRegion reports whether the server serves the region, like SupportsCurrency
+func (sm *ServerMetrics) SupportsRegion(region string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return matchesTag(sm.Regions, region)
+}
+
+func matchesTag(tags []string, value string) bool {
+	if len(tags) == 0 || value == "" {
+		return true
+	}
+	for _, tag := range tags {
+		if strings.EqualFold(tag, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHealthy reports whether the server is passing its health probes
+func (sm *ServerMetrics) IsHealthy() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return !sm.unhealthy
+}
+
+// SetHealthy marks the server healthy or unhealthy and reports whether that changed.
+// A recovering server is warmed up again
+func (sm *ServerMetrics) SetHealthy(healthy bool) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	changed := sm.unhealthy == healthy
+	sm.unhealthy = !healthy
+	if changed && healthy {
+		sm.startWarmup()
+	}
+	return changed
+}
+
+// StartWarmup puts the server in slow-start
+func (sm *ServerMetrics) StartWarmup() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.startWarmup()
+}
+
+// startWarmup resets the slow-start ramp. Callers hold sm.mu
+func (sm *ServerMetrics) startWarmup() {
+	sm.warmupStart = time.Now()
+	sm.warmupSuccesses = 0
+}
+
+// warmupFactor is the share (SlowStartMinWeight to 1.0) of its score a warming up server
+// is selected with, growing with elapsed time or successes. Callers hold sm.mu
+func (sm *ServerMetrics) warmupFactor(config *ScoringConfig) float64 {
+	if sm.warmupStart.IsZero() {
+		return 1
+	}
+
+	progress := 1.0
+	if config.SlowStartWindow > 0 {
+		progress = float64(time.Since(sm.warmupStart)) / float64(config.SlowStartWindow)
+	}
+	if config.SlowStartRequests > 0 {
+		progress = math.Max(progress, float64(sm.warmupSuccesses)/float64(config.SlowStartRequests))
+	}
+	if progress >= 1 {
+		return 1
+	}
+	return config.SlowStartMinWeight + (1-config.SlowStartMinWeight)*progress
+}
+
+// EffectiveScore is the score used for selection: the score scaled down while the
+// server is warming up
+func (sm *ServerMetrics) EffectiveScore(config *ScoringConfig) float64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.Score * sm.warmupFactor(config)
+}
+
+// RequestCounts returns the lifetime total and successful request counts
+func (sm *ServerMetrics) RequestCounts() (total, success int64) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.TotalRequests, sm.SuccessRequests
+}
+
+// RecentSuccessRate returns the success rate (0.0-1.0) over the most recent requests
+// and the number of requests it is based on
+func (sm *ServerMetrics) RecentSuccessRate() (float64, int) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	if len(sm.recentResults) == 0 {
+		return 0, 0
+	}
+	successes := 0
+	for _, ok := range sm.recentResults {
+		if ok {
+			successes++
+		}
+	}
+	return float64(successes) / float64(len(sm.recentResults)), len(sm.recentResults)
+}
+
 func (sm *ServerMetrics) GetMetricsSummary() map[string]interface{} {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -201,9 +415,14 @@ func (sm *ServerMetrics) GetMetricsSummary() map[string]interface{} {
 		"name":               lastSlug,
 		"server_url":         sm.ServerURL,
 		"score":              sm.Score,
+		"healthy":            !sm.unhealthy,
+		"currencies":         sm.Currencies,
+		"regions":            sm.Regions,
 		"total_requests":     sm.TotalRequests,
 		"success_rate":       successRate,
 		"avg_latency_ms":     sm.AvgLatency.Milliseconds(),
+		"ewma_short_ms":      sm.ShortEWMALatency.Milliseconds(),
+		"ewma_long_ms":       sm.LongEWMALatency.Milliseconds(),
 		"p50_latency_ms":     sm.LatencyPercentiles.P50.Milliseconds(),
 		"p95_latency_ms":     sm.LatencyPercentiles.P95.Milliseconds(),
 		"p99_latency_ms":     sm.LatencyPercentiles.P99.Milliseconds(),
@@ -242,14 +461,18 @@ func (sm *ServerMetrics) CalculateScore(config *ScoringConfig) {
 	defer sm.mu.Unlock()
 
 	sm.cleanOldErrors(config.ErrorDecayWindow)
+	if !sm.warmupStart.IsZero() && sm.warmupFactor(config) >= 1 {
+		sm.warmupStart = time.Time{}
+	}
 
 	score := config.BaseScore
 
-	if sm.AvgLatency >= config.LatencyThresholdHigh {
+	latency := sm.scoringLatency()
+	if latency >= config.LatencyThresholdHigh {
 		score -= config.LatencyPenaltyHigh
-	} else if sm.AvgLatency >= config.LatencyThresholdMed {
+	} else if latency >= config.LatencyThresholdMed {
 		score -= config.LatencyPenaltyMed
-	} else if sm.AvgLatency >= config.LatencyThresholdLow {
+	} else if latency >= config.LatencyThresholdLow {
 		score -= config.LatencyPenaltyLow
 	}
 
@@ -0,0 +1,145 @@
+// Package signing implements the request signatures checked by the mesh's API key
+// authentication. Integrators use SignRequest to sign outgoing requests; the server
+// uses Verify.
+//
+// A signature is a hex HMAC-SHA256 under the API key's secret, sent in the
+// X-Signature header as "v<version>=<hex>" with the RFC 3339 request time in
+// X-Timestamp. The signed message depends on the version:
+//
+//	v1: METHOD|PATH|TIMESTAMP (legacy, does not cover the body)
+//	v2: METHOD|PATH|TIMESTAMP|hex(SHA-256(body))
+//
+// A bare hex signature without a version prefix is treated as v1.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// V1 signs method, path and timestamp only
+	V1 = 1
+	// V2 adds a SHA-256 digest of the body
+	V2 = 2
+	// Latest is the version SignRequest uses
+	Latest = V2
+)
+
+const (
+	HeaderAPIKey    = "X-API-Key"
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+)
+
+var (
+	ErrMalformedSignature  = errors.New("malformed signature")
+	ErrUnsupportedVersion  = errors.New("unsupported signature version")
+	ErrSignatureMismatch   = errors.New("signature mismatch")
+	ErrVersionNotPermitted = errors.New("signature version no longer accepted")
+)
+
+// BodyDigest returns the hex SHA-256 digest of a request body
+func BodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Compute returns the hex signature of a request under the given version
+func Compute(version int, secret, method, path, timestamp string, body []byte) (string, error) {
+	var parts []string
+	switch version {
+	case V1:
+		parts = []string{method, path, timestamp}
+	case V2:
+		parts = []string{method, path, timestamp, BodyDigest(body)}
+	default:
+		return "", fmt.Errorf("%w: v%d", ErrUnsupportedVersion, version)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Format renders a signature for the X-Signature header
+func Format(version int, signature string) string {
+	return fmt.Sprintf("v%d=%s", version, signature)
+}
+
+// Parse splits an X-Signature header into its version and hex signature
+func Parse(header string) (int, string, error) {
+	header = strings.TrimSpace(header)
+	prefix, signature, ok := strings.Cut(header, "=")
+	if !ok {
+		if header == "" {
+			return 0, "", ErrMalformedSignature
+		}
+		return V1, header, nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(prefix, "v"))
+	if err != nil || !strings.HasPrefix(prefix, "v") || signature == "" {
+		return 0, "", ErrMalformedSignature
+	}
+	return version, signature, nil
+}
+
+// Verify checks an X-Signature header against a request. Signatures older than
+// minVersion are rejected, so v1 can be retired once integrators have migrated
+func Verify(header, secret, method, path, timestamp string, body []byte, minVersion int) (int, error) {
+	version, signature, err := Parse(header)
+	if err != nil {
+		return 0, err
+	}
+	if version < minVersion {
+		return version, fmt.Errorf("%w: v%d, minimum is v%d", ErrVersionNotPermitted, version, minVersion)
+	}
+
+	expected, err := Compute(version, secret, method, path, timestamp, body)
+	if err != nil {
+		return version, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return version, ErrSignatureMismatch
+	}
+	return version, nil
+}
+
+// SignRequest sets the API key, timestamp and latest-version signature headers on an
+// outgoing request. The body is read and replaced so the request can still be sent
+func SignRequest(req *http.Request, apiKey, secret string) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	signature, err := Compute(Latest, secret, req.Method, req.URL.Path, timestamp, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HeaderAPIKey, apiKey)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Format(Latest, signature))
+	return nil
+}
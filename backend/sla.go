@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SLA breach actions
+const (
+	SLAActionDemote  = "demote"  // drop the provider to PriorityDemoted
+	SLAActionDisable = "disable" // take the provider out of routing entirely
+)
+
+// SLA event types
+const (
+	SLAEventBreach    = "sla.breach"
+	SLAEventRecovered = "sla.recovered"
+)
+
+// maxSLAEvents is how many SLA events are kept for the admin API
+const maxSLAEvents = 100
+
+// SLAMonitorConfig holds configuration for SLA enforcement
+type SLAMonitorConfig struct {
+	Interval            time.Duration // Time between evaluations
+	BreachEvaluations   int           // Consecutive breaching evaluations before acting
+	RecoveryEvaluations int           // Consecutive healthy evaluations before restoring
+	Action              string        // SLAActionDemote or SLAActionDisable
+}
+
+// DefaultSLAMonitorConfig returns sensible defaults. Recovery takes longer than a
+// breach so a provider hovering at its SLA doesn't flap in and out of routing
+func DefaultSLAMonitorConfig() SLAMonitorConfig {
+	return SLAMonitorConfig{
+		Interval:            15 * time.Second,
+		BreachEvaluations:   3,
+		RecoveryEvaluations: 8,
+		Action:              SLAActionDemote,
+	}
+}
+
+// SLAMonitorConfigFromEnv builds the monitor config from SLA_* environment variables,
+// falling back to the defaults
+func SLAMonitorConfigFromEnv() (SLAMonitorConfig, error) {
+	config := DefaultSLAMonitorConfig()
+
+	if v := os.Getenv("SLA_EVAL_INTERVAL_MS"); v != "" {
+		intervalMs, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || intervalMs <= 0 {
+			return config, fmt.Errorf("SLA_EVAL_INTERVAL_MS must be a positive integer, got %q", v)
+		}
+		config.Interval = time.Duration(intervalMs) * time.Millisecond
+	}
+	if v := os.Getenv("SLA_BREACH_EVALUATIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("SLA_BREACH_EVALUATIONS must be a positive integer, got %q", v)
+		}
+		config.BreachEvaluations = n
+	}
+	if v := os.Getenv("SLA_RECOVERY_EVALUATIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("SLA_RECOVERY_EVALUATIONS must be a positive integer, got %q", v)
+		}
+		config.RecoveryEvaluations = n
+	}
+	if v := os.Getenv("SLA_BREACH_ACTION"); v != "" {
+		if v != SLAActionDemote && v != SLAActionDisable {
+			return config, fmt.Errorf("SLA_BREACH_ACTION must be %s or %s, got %q", SLAActionDemote, SLAActionDisable, v)
+		}
+		config.Action = v
+	}
+	return config, nil
+}
+
+// SLAEvent records a provider breaching its SLA or recovering from a breach
+type SLAEvent struct {
+	Type        string    `json:"type"`
+	Provider    string    `json:"provider"`
+	Action      string    `json:"action"`
+	Reasons     []string  `json:"reasons,omitempty"`
+	SuccessRate float64   `json:"success_rate"`
+	P95Ms       int64     `json:"p95_ms"`
+	Samples     int64     `json:"samples"`
+	At          time.Time `json:"at"`
+}
+
+// requestCountSample is a provider's request counters at one evaluation
+type requestCountSample struct {
+	total   int64
+	success int64
+}
+
+// SLAProviderState is the monitor's view of one provider
+type SLAProviderState struct {
+	Provider       string     `json:"provider"`
+	SLA            SLAConfig  `json:"sla"`
+	SuccessRate    float64    `json:"success_rate"`
+	P95Ms          int64      `json:"p95_ms"`
+	Samples        int64      `json:"samples"`
+	Breaching      bool       `json:"breaching"`
+	BreachStreak   int        `json:"breach_streak"`
+	RecoveryStreak int        `json:"recovery_streak"`
+	Demoted        bool       `json:"demoted"`
+	Action         string     `json:"action,omitempty"`
+	DemotedAt      *time.Time `json:"demoted_at,omitempty"`
+
+	previousPriority ProviderPriority
+	counts           []requestCountSample // oldest first, spans the latency window
+}
+
+// SLAMonitor compares each provider's observed success rate and P95 latency against
+// its SLA. A provider that breaches for BreachEvaluations rounds in a row is demoted
+// or disabled and an alert is raised; it is restored after RecoveryEvaluations healthy
+// rounds. Both are measured over the latency window. A demoted provider that sees too
+// little traffic to judge counts as healthy while its circuit breaker stays closed,
+// so a disabled provider can come back and be judged on live traffic again
+type SLAMonitor struct {
+	pool      *ServerPool
+	registry  *ProviderRegistry
+	config    SLAMonitorConfig
+	states    map[string]*SLAProviderState
+	events    []SLAEvent
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// slaMonitor enforces provider SLAs
+var slaMonitor *SLAMonitor
+
+// NewSLAMonitor creates a monitor for the registry's providers
+func NewSLAMonitor(pool *ServerPool, registry *ProviderRegistry, config SLAMonitorConfig) *SLAMonitor {
+	return &SLAMonitor{
+		pool:     pool,
+		registry: registry,
+		config:   config,
+		states:   make(map[string]*SLAProviderState),
+		stopChan: make(chan bool),
+	}
+}
+
+// Start launches the evaluation goroutine
+func (sm *SLAMonitor) Start() {
+	sm.mu.Lock()
+	if sm.isRunning {
+		sm.mu.Unlock()
+		return
+	}
+	sm.isRunning = true
+	sm.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sm.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sm.evaluateAll()
+			case <-sm.stopChan:
+				log.Println("[SLAMonitor] Stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the evaluation goroutine
+func (sm *SLAMonitor) Stop() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.isRunning {
+		sm.stopChan <- true
+		sm.isRunning = false
+	}
+}
+
+// evaluateAll runs one evaluation round over every provider with an SLA
+func (sm *SLAMonitor) evaluateAll() {
+	for name, sla := range sm.registry.SLATargets() {
+		server, err := sm.pool.GetServerByGateway(name)
+		if err != nil {
+			continue
+		}
+		sm.evaluate(name, sla, server)
+	}
+}
+
+// evaluate judges one provider and demotes or restores it when a streak completes
+func (sm *SLAMonitor) evaluate(name string, sla SLAConfig, server *ServerMetrics) {
+	enabled, err := sm.registry.IsProviderEnabled(name)
+	if err != nil {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	state, exists := sm.states[name]
+	if !exists {
+		state = &SLAProviderState{Provider: name}
+		sm.states[name] = state
+	}
+	state.SLA = sla
+
+	// Leave providers an operator disabled alone
+	if !enabled && !(state.Demoted && state.Action == SLAActionDisable) {
+		state.BreachStreak = 0
+		state.counts = nil
+		return
+	}
+
+	total, success := server.RequestCounts()
+	state.counts = append(state.counts, requestCountSample{total: total, success: success})
+	if keep := int(defaultLatencyWindow/sm.config.Interval) + 1; len(state.counts) > keep {
+		state.counts = state.counts[len(state.counts)-keep:]
+	}
+	oldest := state.counts[0]
+	state.Samples = total - oldest.total
+	state.SuccessRate = 0
+	if state.Samples > 0 {
+		state.SuccessRate = float64(success-oldest.success) / float64(state.Samples)
+	}
+	state.P95Ms = 0
+	if server.LatencyTracker != nil && server.LatencyTracker.GetSampleCount() >= minRoutingSamples {
+		state.P95Ms = server.LatencyTracker.GetPercentiles().P95.Milliseconds()
+	}
+
+	judged := state.Samples >= minRoutingSamples
+	reasons := slaBreachReasons(sla, state)
+	state.Breaching = judged && len(reasons) > 0
+
+	if !state.Demoted {
+		if judged {
+			if state.Breaching {
+				state.BreachStreak++
+			} else {
+				state.BreachStreak = 0
+			}
+		}
+		if state.BreachStreak >= sm.config.BreachEvaluations {
+			sm.demote(state, reasons)
+		}
+		return
+	}
+
+	healthy := !state.Breaching
+	if !judged {
+		circuit, err := sm.registry.CircuitState(name)
+		healthy = err == nil && circuit != StateOpen
+	}
+	if healthy {
+		state.RecoveryStreak++
+	} else {
+		state.RecoveryStreak = 0
+	}
+	if state.RecoveryStreak >= sm.config.RecoveryEvaluations {
+		sm.restore(state)
+	}
+}
+
+// slaBreachReasons lists how the observed metrics miss the SLA
+func slaBreachReasons(sla SLAConfig, state *SLAProviderState) []string {
+	var reasons []string
+	if sla.MinSuccessRate > 0 && state.SuccessRate < sla.MinSuccessRate {
+		reasons = append(reasons, fmt.Sprintf("success rate %.3f below %.3f", state.SuccessRate, sla.MinSuccessRate))
+	}
+	if sla.MaxLatencyP95Ms > 0 && state.P95Ms > int64(sla.MaxLatencyP95Ms) {
+		reasons = append(reasons, fmt.Sprintf("p95 latency %dms above %dms", state.P95Ms, sla.MaxLatencyP95Ms))
+	}
+	return reasons
+}
+
+// demote applies the breach action. Callers must hold sm.mu
+func (sm *SLAMonitor) demote(state *SLAProviderState, reasons []string) {
+	switch sm.config.Action {
+	case SLAActionDisable:
+		if err := sm.registry.DisableProvider(state.Provider); err != nil {
+			log.Printf("[SLAMonitor] Failed to disable %s: %v", state.Provider, err)
+			return
+		}
+	default:
+		previous, err := sm.registry.SetProviderPriority(state.Provider, PriorityDemoted)
+		if err != nil {
+			log.Printf("[SLAMonitor] Failed to demote %s: %v", state.Provider, err)
+			return
+		}
+		state.previousPriority = previous
+	}
+
+	now := time.Now()
+	state.Demoted = true
+	state.Action = sm.config.Action
+	state.DemotedAt = &now
+	state.BreachStreak = 0
+	state.RecoveryStreak = 0
+	sm.raise(SLAEventBreach, state, reasons)
+}
+
+// restore undoes the breach action. Callers must hold sm.mu
+func (sm *SLAMonitor) restore(state *SLAProviderState) {
+	switch state.Action {
+	case SLAActionDisable:
+		if err := sm.registry.EnableProvider(state.Provider); err != nil {
+			log.Printf("[SLAMonitor] Failed to re-enable %s: %v", state.Provider, err)
+			return
+		}
+	default:
+		if _, err := sm.registry.SetProviderPriority(state.Provider, state.previousPriority); err != nil {
+			log.Printf("[SLAMonitor] Failed to restore %s: %v", state.Provider, err)
+			return
+		}
+	}
+
+	sm.raise(SLAEventRecovered, state, nil)
+	state.Demoted = false
+	state.Action = ""
+	state.DemotedAt = nil
+	state.RecoveryStreak = 0
+}
+
+// raise records an SLA event and logs it as an alert. Callers must hold sm.mu
+func (sm *SLAMonitor) raise(eventType string, state *SLAProviderState, reasons []string) {
+	event := SLAEvent{
+		Type:        eventType,
+		Provider:    state.Provider,
+		Action:      state.Action,
+		Reasons:     reasons,
+		SuccessRate: state.SuccessRate,
+		P95Ms:       state.P95Ms,
+		Samples:     state.Samples,
+		At:          time.Now().UTC(),
+	}
+	sm.events = append(sm.events, event)
+	if len(sm.events) > maxSLAEvents {
+		sm.events = sm.events[len(sm.events)-maxSLAEvents:]
+	}
+
+	fields := map[string]interface{}{
+		"operation":    eventType,
+		"provider":     state.Provider,
+		"action":       state.Action,
+		"success_rate": state.SuccessRate,
+		"p95_ms":       state.P95Ms,
+		"samples":      state.Samples,
+	}
+	if eventType == SLAEventBreach {
+		fields["reasons"] = reasons
+		log.Printf("[SLAMonitor] %s breached its SLA (%v), action: %s", state.Provider, reasons, state.Action)
+		appLogger.Error("Provider SLA breached", fields)
+	} else {
+		log.Printf("[SLAMonitor] %s is meeting its SLA again, undoing %s", state.Provider, state.Action)
+		appLogger.Warn("Provider SLA recovered", fields)
+	}
+}
+
+// GetStatus returns every monitored provider's state and the recent SLA events,
+// newest first
+func (sm *SLAMonitor) GetStatus() ([]SLAProviderState, []SLAEvent) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	states := make([]SLAProviderState, 0, len(sm.states))
+	for _, state := range sm.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Provider < states[j].Provider })
+
+	events := make([]SLAEvent, len(sm.events))
+	for i, event := range sm.events {
+		events[len(events)-1-i] = event
+	}
+	return states, events
+}
+
+// AdminSLAHandler handles GET /admin/sla
+func AdminSLAHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if slaMonitor == nil {
+		http.Error(w, "SLA monitoring not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	states, events := slaMonitor.GetStatus()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"providers": states,
+		"events":    events,
+		"action":    slaMonitor.config.Action,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// maxPaymentSplits bounds how many recipients one payment can be split between
+const maxPaymentSplits = 10
+
+// PaymentSplit assigns part of a payment to a recipient merchant, e.g. a marketplace
+// seller, with the platform keeping its own share as a split to itself
+type PaymentSplit struct {
+	Recipient   string `json:"recipient" validate:"required,max=64"`
+	Amount      int64  `json:"amount" validate:"required,gt=0"`
+	Description string `json:"description,omitempty" validate:"max=255"`
+}
+
+// SplitSettlement is what a recipient is settled for a split once fees are taken
+type SplitSettlement struct {
+	Recipient string `json:"recipient"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	Net       int64  `json:"net"`
+	Account   string `json:"account"`
+}
+
+// validateSplits checks each split and that together they add up to the payment
+// amount. Failures are reported as a *ValidationError
+func validateSplits(splits []PaymentSplit, amount int64) error {
+	if len(splits) == 0 {
+		return nil
+	}
+	if len(splits) > maxPaymentSplits {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   "splits",
+			Rule:    "max",
+			Message: fmt.Sprintf("splits must have at most %d entries", maxPaymentSplits),
+		}}}
+	}
+
+	var fields []FieldError
+	var total int64
+	seen := make(map[string]bool, len(splits))
+	for i, split := range splits {
+		if err := validateStruct(&split); err != nil {
+			for _, fe := range err.(*ValidationError).Fields {
+				fe.Field = fmt.Sprintf("splits[%d].%s", i, fe.Field)
+				fe.Message = fmt.Sprintf("splits[%d].%s", i, fe.Message)
+				fields = append(fields, fe)
+			}
+			continue
+		}
+		if seen[split.Recipient] {
+			fields = append(fields, FieldError{
+				Field:   fmt.Sprintf("splits[%d].recipient", i),
+				Rule:    "unique",
+				Message: fmt.Sprintf("splits[%d].recipient %s appears more than once", i, split.Recipient),
+			})
+		}
+		seen[split.Recipient] = true
+		total += split.Amount
+	}
+	if len(fields) == 0 && total != amount {
+		fields = append(fields, FieldError{
+			Field:   "splits",
+			Rule:    "sum",
+			Message: fmt.Sprintf("splits must add up to the amount %d, got %d", amount, total),
+		})
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// splitSettlements works out what each recipient is settled. Fees are charged per
+// split at the ledger's rate, or not at all when no ledger is configured
+func splitSettlements(splits []PaymentSplit) []SplitSettlement {
+	l := GetLedger()
+	settlements := make([]SplitSettlement, len(splits))
+	for i, split := range splits {
+		var fee int64
+		if l != nil {
+			fee = l.Fee(split.Amount)
+		}
+		settlements[i] = SplitSettlement{
+			Recipient: split.Recipient,
+			Amount:    split.Amount,
+			Fee:       fee,
+			Net:       split.Amount - fee,
+			Account:   merchantAccount(split.Recipient),
+		}
+	}
+	return settlements
+}
+
+// allocateSplitRefund divides a refund across splits in proportion to their amounts,
+// putting any rounding remainder on the last split. amount must not exceed total
+func allocateSplitRefund(splits []PaymentSplit, total, amount int64) []int64 {
+	shares := make([]int64, len(splits))
+	var allocated int64
+	for i, split := range splits {
+		if i == len(splits)-1 {
+			shares[i] = amount - allocated
+			break
+		}
+		// 128-bit multiply so large amounts can't overflow
+		hi, lo := bits.Mul64(uint64(amount), uint64(split.Amount))
+		share, _ := bits.Div64(hi, lo, uint64(total))
+		shares[i] = int64(share)
+		allocated += shares[i]
+	}
+	return shares
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 )
 
 type State int
@@ -12,6 +15,7 @@ const (
 	CANCELLED
 	SUCCESS
 	FAILED
+	REVIEW // authorized but held by compliance screening
 )
 
 func (s State) String() string {
@@ -26,6 +30,8 @@ func (s State) String() string {
 		return "SUCCESS"
 	case FAILED:
 		return "FAILED"
+	case REVIEW:
+		return "REVIEW"
 	default:
 		return "UNKNOWN"
 	}
@@ -34,49 +40,155 @@ func (s State) String() string {
 var INVALID_STATE_CHANGE_REQUEST = errors.New("invalid state change request")
 
 // INITIATED -> processing,CANCELLED
-// PROCESSING -> SUCCESS,CANCELLED,FAILED
+// PROCESSING -> SUCCESS,CANCELLED,FAILED,REVIEW
+// REVIEW -> SUCCESS,FAILED
 // FAILED -> PROCESSING
+var stateTransitions = map[State][]State{
+	INITIATED:  {PROCESSING, CANCELLED},
+	PROCESSING: {SUCCESS, CANCELLED, FAILED, REVIEW},
+	REVIEW:     {SUCCESS, FAILED},
+	FAILED:     {PROCESSING},
+}
 
-var status = make(map[string]int)
+// canTransition reports whether a payment may move from one state to another
+func canTransition(from, to State) bool {
+	for _, allowed := range stateTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
 
-func SetState(id string, changestate State) (bool, error) {
-	currentState := status[id]
-	if currentState == 0 && changestate == INITIATED {
-		status[id] = int(changestate)
-		return true, nil
+// StateTransition is a payment's move from one state to another. A payment's first
+// transition has no From. Actor is who caused it, usually the request's correlation ID
+type StateTransition struct {
+	PaymentID string
+	From      *State
+	To        State
+	At        time.Time
+	Reason    string
+	Actor     string
+}
+
+// FromString returns the previous state's name, empty for a new payment
+func (t StateTransition) FromString() string {
+	if t.From == nil {
+		return ""
 	}
+	return t.From.String()
+}
 
-	switch currentState {
-	case int(INITIATED):
-		switch changestate {
-		case PROCESSING, CANCELLED:
-			break
-		default:
-			return false, INVALID_STATE_CHANGE_REQUEST
-		}
-	case int(PROCESSING):
-		switch changestate {
-		case SUCCESS, CANCELLED, FAILED:
-			break
-		default:
-			return false, INVALID_STATE_CHANGE_REQUEST
-		}
-	case int(FAILED):
-		switch changestate {
-		case PROCESSING:
-			break
-		default:
-			return false, INVALID_STATE_CHANGE_REQUEST
-		}
-	case int(CANCELLED):
-		return false, INVALID_STATE_CHANGE_REQUEST
-	default:
-		return false, INVALID_STATE_CHANGE_REQUEST
+// StateObserver is notified after every transition, outside the store's lock
+type StateObserver func(transition StateTransition)
+
+// paymentState is a payment's current state and when it was entered
+type paymentState struct {
+	state     State
+	updatedAt time.Time
+}
+
+// StateStore holds the in-process state of every payment. Transitions are validated
+// against the state machine above and announced to the registered observers
+type StateStore struct {
+	states      map[string]paymentState
+	observers   []StateObserver
+	transitions map[string]int64 // counts by "FROM->TO"
+	rejected    int64
+	mu          sync.RWMutex
+}
+
+// NewStateStore creates an empty state store
+func NewStateStore() *StateStore {
+	return &StateStore{
+		states:      make(map[string]paymentState),
+		transitions: make(map[string]int64),
+	}
+}
+
+// Observe registers an observer for all future transitions
+func (ss *StateStore) Observe(observer StateObserver) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.observers = append(ss.observers, observer)
+}
+
+// Transition moves a payment to a new state. A new payment can only be INITIATED;
+// any other move the state machine doesn't allow fails with INVALID_STATE_CHANGE_REQUEST
+func (ss *StateStore) Transition(id string, to State, reason, actor string) (StateTransition, error) {
+	ss.mu.Lock()
+
+	transition := StateTransition{PaymentID: id, To: to, At: time.Now(), Reason: reason, Actor: actor}
+	current, exists := ss.states[id]
+	if exists {
+		from := current.state
+		transition.From = &from
+	}
+
+	if (!exists && to != INITIATED) || (exists && !canTransition(current.state, to)) {
+		ss.rejected++
+		ss.mu.Unlock()
+		return transition, fmt.Errorf("%w: %s -> %s for %s", INVALID_STATE_CHANGE_REQUEST, transition.FromString(), to, id)
+	}
+
+	ss.states[id] = paymentState{state: to, updatedAt: transition.At}
+	ss.transitions[transition.FromString()+"->"+to.String()]++
+	observers := ss.observers
+	ss.mu.Unlock()
+
+	for _, observer := range observers {
+		observer(transition)
+	}
+	return transition, nil
+}
+
+// Get returns a payment's state and when it was entered, and whether the payment is known
+func (ss *StateStore) Get(id string) (State, time.Time, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	current, exists := ss.states[id]
+	return current.state, current.updatedAt, exists
+}
+
+// GetStats returns the number of tracked payments and transition counts
+func (ss *StateStore) GetStats() map[string]interface{} {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	transitions := make(map[string]int64, len(ss.transitions))
+	for key, count := range ss.transitions {
+		transitions[key] = count
+	}
+	byState := make(map[string]int)
+	for _, current := range ss.states {
+		byState[current.state.String()]++
+	}
+
+	return map[string]interface{}{
+		"payments":    len(ss.states),
+		"by_state":    byState,
+		"transitions": transitions,
+		"rejected":    ss.rejected,
+	}
+}
+
+var paymentStates = NewStateStore()
+
+// SetState moves a payment to a new state, see StateStore.Transition
+func SetState(id string, changestate State) (bool, error) {
+	return SetStateWithReason(id, changestate, "", "")
+}
+
+// SetStateWithReason moves a payment to a new state, recording why and on whose behalf
+func SetStateWithReason(id string, changestate State, reason, actor string) (bool, error) {
+	if _, err := paymentStates.Transition(id, changestate, reason, actor); err != nil {
+		return false, err
 	}
-	status[id] = int(changestate)
 	return true, nil
 }
 
+// GetState returns a payment's state. Unknown payments report INITIATED
 func GetState(id string) State {
-	return State(status[id])
+	state, _, _ := paymentStates.Get(id)
+	return state
 }
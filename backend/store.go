@@ -0,0 +1,1551 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogStore persists per-request gateway metrics
+type LogStore interface {
+	LogRequestMetrics(paymentID, serverURL string, latencyMs int64, success bool, score float64, errorType, errorMessage string) error
+	QueryLogs(filter LogFilter) ([]LogItem, int, error)
+	SummarizeLogs(filter LogFilter) ([]LogSummary, error)
+}
+
+// UserStore persists dashboard users
+type UserStore interface {
+	CreateUser(name, passwordHash string) (int64, error)
+	GetUserByName(name string) (int64, string, error)
+}
+
+// PaymentStore persists payments and their state transitions
+type PaymentStore interface {
+	StorePayment(record *PaymentRecord, fromStatus string) error
+	QueryPayments(filter PaymentFilter) ([]PaymentRecord, int, error)
+	GetPayment(paymentID string) (*PaymentRecord, error)
+}
+
+// Store is the full persistence layer used by the backend
+type Store interface {
+	LogStore
+	UserStore
+	PaymentStore
+	OutboxStore
+	LedgerStore
+	DisputeStore
+	SubscriptionStore
+	ScheduledPaymentStore
+	PayoutStore
+	BNPLStore
+	VaultStore
+	CustomerStore
+	RoutingRuleStore
+	MaintenanceWindowStore
+	PaymentHistoryStore
+	AuditStore
+	CreateSchema() error
+	DB() *sql.DB
+}
+
+// Dialect captures the SQL differences between supported databases
+type Dialect interface {
+	Name() string
+	// SchemaStatements returns the DDL statements that create all tables
+	SchemaStatements() []string
+	// Rebind converts '?' placeholders to the dialect's placeholder syntax
+	Rebind(query string) string
+	// UpsertPaymentQuery returns an insert-or-update statement for a payments table
+	UpsertPaymentQuery(table string) string
+	// InsertUser creates a user and returns its generated ID
+	InsertUser(db *sql.DB, name, passwordHash string) (int64, error)
+	// HourBucket returns an expression truncating a timestamp column to the hour, as
+	// text in the form 2006-01-02T15:00:00
+	HourBucket(column string) string
+	// IsUniqueViolation reports whether err is a unique or primary key violation
+	IsUniqueViolation(err error) bool
+	// CurrentSchema returns the SQL expression naming the connection's schema, for
+	// information_schema lookups
+	CurrentSchema() string
+	// ColumnMigrations returns the columns added to tables after they were first created
+	ColumnMigrations() []ColumnMigration
+}
+
+// ColumnMigration is a column added to a table after the table was first released.
+// CREATE TABLE IF NOT EXISTS leaves tables created before then without it, so it is
+// added to them with ALTER TABLE
+type ColumnMigration struct {
+	Table      string
+	Column     string
+	Definition string
+	// Key, when set, is an index on the new column added in the same ALTER TABLE,
+	// e.g. "UNIQUE KEY name (column)", for dialects without CREATE INDEX IF NOT EXISTS
+	Key string
+}
+
+// SQLStore implements Store on top of database/sql for a given dialect
+type SQLStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLStore creates a store for an open database handle
+func NewSQLStore(db *sql.DB, dialect Dialect) *SQLStore {
+	return &SQLStore{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// DB returns the underlying database handle
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// CreateSchema adds missing columns to existing tables, then creates all tables and
+// indexes, continuing past individual failures. Columns go first so indexes on them
+// can be created in the same run
+func (s *SQLStore) CreateSchema() error {
+	var failures []string
+	for _, m := range s.dialect.ColumnMigrations() {
+		if err := s.migrateColumn(m); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	for _, stmt := range s.dialect.SchemaStatements() {
+		if _, err := s.db.Exec(stmt); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s schema creation failed: %s", s.dialect.Name(), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// migrateColumn adds a column to its table when the table exists without it. Tables
+// that don't exist yet are created with the column by SchemaStatements
+func (s *SQLStore) migrateColumn(m ColumnMigration) error {
+	var tables, columns int
+	err := s.queryRow(`SELECT
+			  (SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = `+s.dialect.CurrentSchema()+` AND table_name = ?),
+			  (SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = `+s.dialect.CurrentSchema()+` AND table_name = ? AND column_name = ?)`,
+		m.Table, m.Table, m.Column).Scan(&tables, &columns)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s.%s: %v", m.Table, m.Column, err)
+	}
+	if tables == 0 || columns > 0 {
+		return nil
+	}
+	stmt := "ALTER TABLE " + m.Table + " ADD COLUMN " + m.Column + " " + m.Definition
+	if m.Key != "" {
+		stmt += ", ADD " + m.Key
+	}
+	if _, err := s.exec(stmt); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %v", m.Table, m.Column, err)
+	}
+	return nil
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *SQLStore) execOn(e sqlExecer, query string, args ...interface{}) (sql.Result, error) {
+	return e.Exec(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.Rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.Rebind(query), args...)
+}
+
+// LogRequestMetrics records a single gateway request
+func (s *SQLStore) LogRequestMetrics(paymentID, serverURL string, latencyMs int64, success bool, score float64, errorType, errorMessage string) error {
+	query := `INSERT INTO log (payment_id, server_url, latency_ms, success, score, error_type, error_message)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := s.exec(query, paymentID, serverURL, latencyMs, success, score, errorType, errorMessage)
+	if err != nil {
+		return fmt.Errorf("failed to log request metrics: %v", err)
+	}
+
+	return nil
+}
+
+// logConditions builds the WHERE clause for a log filter
+func logConditions(filter LogFilter) (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if filter.PaymentID != "" {
+		conditions = append(conditions, "payment_id = ?")
+		args = append(args, filter.PaymentID)
+	}
+	if filter.Provider != "" {
+		conditions = append(conditions, "(server_url = ? OR server_url LIKE ?)")
+		args = append(args, filter.Provider, "%/"+filter.Provider)
+	}
+	if filter.Success != nil {
+		conditions = append(conditions, "success = ?")
+		args = append(args, *filter.Success)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.To)
+	}
+	if filter.MinLatencyMs != nil {
+		conditions = append(conditions, "latency_ms >= ?")
+		args = append(args, *filter.MinLatencyMs)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// QueryLogs returns gateway request logs matching the filter along with the total match count
+func (s *SQLStore) QueryLogs(filter LogFilter) ([]LogItem, int, error) {
+	where, args := logConditions(filter)
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, COALESCE(payment_id, ''), server_url, success, latency_ms, COALESCE(error_type, ''), COALESCE(error_message, ''), created_at
+			  FROM log` + where + ` ORDER BY ` + filter.SortColumn + ` ` + filter.SortOrder + `, id ` + filter.SortOrder + ` LIMIT ? OFFSET ?`
+	rows, err := s.query(query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	logs := make([]LogItem, 0)
+	for rows.Next() {
+		var item LogItem
+		var createdAt time.Time
+
+		err := rows.Scan(&item.TransactionID, &item.PaymentID, &item.Link, &item.Success, &item.Latency, &item.ErrorType, &item.ErrorMessage, &createdAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		item.Name = gatewayName(item.Link)
+		if item.Success {
+			item.Status = 1
+		} else {
+			item.Status = 0
+		}
+		item.CurrentTime = createdAt.Unix()
+
+		logs = append(logs, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return logs, total, nil
+}
+
+// SummarizeLogs returns request and error counts per provider per hour, newest hour first
+func (s *SQLStore) SummarizeLogs(filter LogFilter) ([]LogSummary, error) {
+	where, args := logConditions(filter)
+	hour := s.dialect.HourBucket("created_at")
+
+	query := `SELECT server_url, ` + hour + ` AS hour, COUNT(*), SUM(CASE WHEN success THEN 0 ELSE 1 END), AVG(latency_ms)
+			  FROM log` + where + ` GROUP BY server_url, hour ORDER BY hour DESC, server_url`
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Several server URLs can share a provider name, so rows are merged by name
+	summaries := make([]LogSummary, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		var serverURL, hour string
+		var requests, failures int64
+		var avgLatency float64
+		if err := rows.Scan(&serverURL, &hour, &requests, &failures, &avgLatency); err != nil {
+			return nil, err
+		}
+
+		provider := gatewayName(serverURL)
+		key := provider + "|" + hour
+		i, exists := index[key]
+		if !exists {
+			index[key] = len(summaries)
+			summaries = append(summaries, LogSummary{Provider: provider, Hour: hour})
+			i = len(summaries) - 1
+		}
+		summary := &summaries[i]
+		summary.AvgLatencyMs = (summary.AvgLatencyMs*float64(summary.Requests) + avgLatency*float64(requests)) / float64(summary.Requests+requests)
+		summary.Requests += requests
+		summary.Errors += failures
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range summaries {
+		summaries[i].ErrorRate = float64(summaries[i].Errors) / float64(summaries[i].Requests)
+	}
+	return summaries, nil
+}
+
+// CreateUser inserts a user and returns its ID
+func (s *SQLStore) CreateUser(name, passwordHash string) (int64, error) {
+	return s.dialect.InsertUser(s.db, name, passwordHash)
+}
+
+// GetUserByName returns a user's ID and password hash
+func (s *SQLStore) GetUserByName(name string) (int64, string, error) {
+	var userID int64
+	var passwordHash string
+	err := s.queryRow("SELECT id, password FROM users WHERE name = ?", name).Scan(&userID, &passwordHash)
+	return userID, passwordHash, err
+}
+
+// StorePayment upserts a payment row and records a transition when the status changed
+func (s *SQLStore) StorePayment(record *PaymentRecord, fromStatus string) error {
+	return s.storePayment(s.db, record, fromStatus)
+}
+
+// paymentTables returns the payments and transitions tables a payment is stored in
+func paymentTables(testMode bool) (string, string) {
+	if testMode {
+		return "test_payments", "test_payment_transitions"
+	}
+	return "payments", "payment_transitions"
+}
+
+// paymentHistoryTable returns the table a payment's state history is stored in
+func paymentHistoryTable(testMode bool) string {
+	if testMode {
+		return "test_payment_state_history"
+	}
+	return "payment_state_history"
+}
+
+func (s *SQLStore) storePayment(e sqlExecer, record *PaymentRecord, fromStatus string) error {
+	payments, transitions := paymentTables(isTestPayment(record.PaymentID))
+	_, err := s.execOn(e, s.dialect.UpsertPaymentQuery(payments), record.PaymentID, record.OrderID, record.Amount, record.Currency,
+		record.UserID, record.MerchantID, record.Provider, record.Status, record.LatencyMs, record.ErrorCode, record.ErrorMessage,
+		record.CreatedAt, record.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store payment: %v", err)
+	}
+
+	if fromStatus != record.Status {
+		_, err = s.execOn(e, `INSERT INTO `+transitions+` (payment_id, from_status, to_status) VALUES (?, ?, ?)`,
+			record.PaymentID, fromStatus, record.Status)
+		if err != nil {
+			return fmt.Errorf("failed to store payment transition: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// StorePaymentWithEvent upserts the payment, its transition and an outbox event in one transaction
+func (s *SQLStore) StorePaymentWithEvent(record *PaymentRecord, fromStatus string, event *OutboxEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.storePayment(tx, record, fromStatus); err != nil {
+		return err
+	}
+
+	_, err = s.execOn(tx, `INSERT INTO outbox (aggregate_id, event_type, payload) VALUES (?, ?, ?)`,
+		event.AggregateID, event.EventType, event.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to store outbox event: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// RelayOutbox claims pending events with SKIP LOCKED so concurrent relays on other
+// instances never publish the same event at the same time. Events waiting out a retry
+// delay and dead-lettered events are not claimed
+func (s *SQLStore) RelayOutbox(limit int, publish func(event OutboxEvent) error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	rows, err := tx.Query(s.dialect.Rebind(`SELECT id, aggregate_id, event_type, payload, attempts, created_at
+			  FROM outbox WHERE delivered_at IS NULL AND dead_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+			  ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`), now, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	events := make([]OutboxEvent, 0)
+	for rows.Next() {
+		var event OutboxEvent
+		if err := rows.Scan(&event.ID, &event.AggregateID, &event.EventType, &event.Payload, &event.Attempts, &event.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		if err := publish(event); err != nil {
+			attempts := event.Attempts + 1
+			if attempts >= maxOutboxAttempts {
+				_, err = s.execOn(tx, `UPDATE outbox SET attempts = ?, dead_at = ? WHERE id = ?`, attempts, now, event.ID)
+			} else {
+				_, err = s.execOn(tx, `UPDATE outbox SET attempts = ?, next_attempt_at = ? WHERE id = ?`,
+					attempts, now.Add(outboxRetryDelay(attempts)), event.ID)
+			}
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if _, err := s.execOn(tx, `UPDATE outbox SET delivered_at = ? WHERE id = ?`, now, event.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), tx.Commit()
+}
+
+// ListDeadOutboxEvents returns dead-lettered events, most recently dead-lettered first
+func (s *SQLStore) ListDeadOutboxEvents(limit int) ([]OutboxEvent, error) {
+	rows, err := s.query(`SELECT id, aggregate_id, event_type, payload, attempts, created_at, dead_at
+			  FROM outbox WHERE delivered_at IS NULL AND dead_at IS NOT NULL ORDER BY dead_at DESC, id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]OutboxEvent, 0)
+	for rows.Next() {
+		var event OutboxEvent
+		var deadAt time.Time
+		if err := rows.Scan(&event.ID, &event.AggregateID, &event.EventType, &event.Payload, &event.Attempts, &event.CreatedAt, &deadAt); err != nil {
+			return nil, err
+		}
+		event.DeadAt = &deadAt
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// RedriveOutboxEvent clears a dead-lettered event's dead_at and attempts, so the relay
+// delivers it again on its next pass
+func (s *SQLStore) RedriveOutboxEvent(id int64) error {
+	result, err := s.exec(`UPDATE outbox SET dead_at = NULL, attempts = 0, next_attempt_at = NULL
+			  WHERE id = ? AND delivered_at IS NULL AND dead_at IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to redrive outbox event: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %d", ErrOutboxEventNotDead, id)
+	}
+	return nil
+}
+
+const paymentColumns = `payment_id, COALESCE(order_id, ''), amount, currency, COALESCE(user_id, ''), COALESCE(merchant_id, ''), COALESCE(provider, ''),
+			  status, latency_ms, COALESCE(error_code, ''), COALESCE(error_message, ''), created_at, updated_at`
+
+func scanPayment(scan func(dest ...interface{}) error) (*PaymentRecord, error) {
+	var p PaymentRecord
+	err := scan(&p.PaymentID, &p.OrderID, &p.Amount, &p.Currency, &p.UserID, &p.MerchantID, &p.Provider,
+		&p.Status, &p.LatencyMs, &p.ErrorCode, &p.ErrorMessage, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPayment returns a payment's stored row
+func (s *SQLStore) GetPayment(paymentID string) (*PaymentRecord, error) {
+	table, _ := paymentTables(isTestPayment(paymentID))
+	return scanPayment(s.queryRow("SELECT "+paymentColumns+" FROM "+table+" WHERE payment_id = ?", paymentID).Scan)
+}
+
+// QueryPayments returns payments matching the filter along with the total match count
+func (s *SQLStore) QueryPayments(filter PaymentFilter) ([]PaymentRecord, int, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if filter.MerchantID != "" {
+		// Rows written before payments carried a merchant belong to the default merchant
+		conditions = append(conditions, "COALESCE(NULLIF(merchant_id, ''), 'default') = ?")
+		args = append(args, filter.MerchantID)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Provider != "" {
+		conditions = append(conditions, "provider = ?")
+		args = append(args, filter.Provider)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.To)
+	}
+	if filter.MinAmount != nil {
+		conditions = append(conditions, "amount >= ?")
+		args = append(args, *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		conditions = append(conditions, "amount <= ?")
+		args = append(args, *filter.MaxAmount)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	table, _ := paymentTables(filter.TestMode)
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM "+table+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + paymentColumns + " FROM " + table + where + " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	rows, err := s.query(query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	payments := make([]PaymentRecord, 0)
+	for rows.Next() {
+		p, err := scanPayment(rows.Scan)
+		if err != nil {
+			return nil, 0, err
+		}
+		payments = append(payments, *p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return payments, total, nil
+}
+
+// PostLedgerTransactions writes ledger transactions and their entries in one database
+// transaction, returning ErrLedgerAlreadyPosted when one of them was recorded before
+func (s *SQLStore) PostLedgerTransactions(ltxs ...*LedgerTransaction) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, ltx := range ltxs {
+		_, err = s.execOn(tx, `INSERT INTO ledger_transactions (id, reference, type, created_at) VALUES (?, ?, ?, ?)`,
+			ltx.ID, ltx.Reference, ltx.Type, ltx.CreatedAt)
+		if err != nil {
+			if s.dialect.IsUniqueViolation(err) {
+				return ErrLedgerAlreadyPosted
+			}
+			return fmt.Errorf("failed to store ledger transaction: %v", err)
+		}
+
+		for _, entry := range ltx.Entries {
+			_, err = s.execOn(tx, `INSERT INTO ledger_entries (transaction_id, account, direction, amount, currency, created_at)
+					  VALUES (?, ?, ?, ?, ?, ?)`,
+				ltx.ID, entry.Account, entry.Direction, entry.Amount, entry.Currency, entry.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to store ledger entry: %v", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// likePrefixEscaper escapes LIKE wildcards with '!', which both dialects accept as an
+// ESCAPE character without the backslash quoting differences between them
+var likePrefixEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// GetLedgerBalances sums credits minus debits per account and currency
+func (s *SQLStore) GetLedgerBalances(accountPrefix string) ([]LedgerBalance, error) {
+	query := `SELECT account, currency,
+			  SUM(CASE WHEN direction = 'CREDIT' THEN amount ELSE -amount END)
+			  FROM ledger_entries WHERE account LIKE ? ESCAPE '!' GROUP BY account, currency ORDER BY account, currency`
+	rows, err := s.query(query, likePrefixEscaper.Replace(accountPrefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make([]LedgerBalance, 0)
+	for rows.Next() {
+		var b LedgerBalance
+		if err := rows.Scan(&b.Account, &b.Currency, &b.Balance); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// GetLedgerTransactions returns the transactions and entries recorded for a reference,
+// limited to transactions with an entry on an account starting with accountPrefix
+func (s *SQLStore) GetLedgerTransactions(accountPrefix, reference string) ([]LedgerTransaction, error) {
+	query := `SELECT t.id, t.reference, t.type, t.created_at, e.id, e.account, e.direction, e.amount, e.currency, e.created_at
+			  FROM ledger_transactions t JOIN ledger_entries e ON e.transaction_id = t.id
+			  WHERE t.reference = ? AND EXISTS (
+				  SELECT 1 FROM ledger_entries own WHERE own.transaction_id = t.id AND own.account LIKE ? ESCAPE '!')
+			  ORDER BY t.created_at, e.id`
+	rows, err := s.query(query, reference, likePrefixEscaper.Replace(accountPrefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := make([]LedgerTransaction, 0)
+	for rows.Next() {
+		var t LedgerTransaction
+		var e LedgerEntry
+		if err := rows.Scan(&t.ID, &t.Reference, &t.Type, &t.CreatedAt,
+			&e.ID, &e.Account, &e.Direction, &e.Amount, &e.Currency, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.TransactionID = t.ID
+
+		if n := len(transactions); n > 0 && transactions[n-1].ID == t.ID {
+			transactions[n-1].Entries = append(transactions[n-1].Entries, e)
+			continue
+		}
+		t.Entries = []LedgerEntry{e}
+		transactions = append(transactions, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+const disputeColumns = `id, payment_id, merchant_id, provider, provider_dispute_id, amount, currency,
+			  COALESCE(reason, ''), status, COALESCE(evidence, ''), created_at, updated_at`
+
+// scanDispute reads a dispute row, mapping sql.ErrNoRows to ErrDisputeNotFound
+func scanDispute(scan func(dest ...interface{}) error) (*Dispute, error) {
+	var d Dispute
+	err := scan(&d.ID, &d.PaymentID, &d.MerchantID, &d.Provider, &d.ProviderDisputeID, &d.Amount, &d.Currency,
+		&d.Reason, &d.Status, &d.Evidence, &d.CreatedAt, &d.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDisputeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// CreateDisputeWithinLimit inserts a new dispute unless it would take the payment's
+// open and lost disputes past limit. The sum and the insert share one serializable
+// transaction, so of two concurrent notices only one can pass the check
+func (s *SQLStore) CreateDisputeWithinLimit(d *Dispute, limit int64) error {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var disputed int64
+	err = tx.QueryRow(s.dialect.Rebind(`SELECT COALESCE(SUM(amount), 0) FROM disputes WHERE payment_id = ? AND status <> ?`),
+		d.PaymentID, DisputeWon).Scan(&disputed)
+	if err != nil {
+		return fmt.Errorf("failed to sum disputes: %v", err)
+	}
+	if disputed+d.Amount > limit {
+		return fmt.Errorf("%w: payment %s has %d disputed, %d more would exceed %d", ErrDisputeExceedsAmount, d.PaymentID, disputed, d.Amount, limit)
+	}
+
+	_, err = s.execOn(tx, `INSERT INTO disputes (id, payment_id, merchant_id, provider, provider_dispute_id, amount, currency, reason, status, evidence, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.PaymentID, d.MerchantID, d.Provider, d.ProviderDisputeID, d.Amount, d.Currency, d.Reason, d.Status, d.Evidence,
+		d.CreatedAt, d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store dispute: %v", err)
+	}
+	return tx.Commit()
+}
+
+// GetDispute returns one of a merchant's disputes by ID
+func (s *SQLStore) GetDispute(merchantID, id string) (*Dispute, error) {
+	return scanDispute(s.queryRow("SELECT "+disputeColumns+" FROM disputes WHERE merchant_id = ? AND id = ?", merchantID, id).Scan)
+}
+
+// GetDisputeByProviderRef returns a dispute by the provider's own dispute ID
+func (s *SQLStore) GetDisputeByProviderRef(provider, providerDisputeID string) (*Dispute, error) {
+	return scanDispute(s.queryRow("SELECT "+disputeColumns+" FROM disputes WHERE provider = ? AND provider_dispute_id = ?",
+		provider, providerDisputeID).Scan)
+}
+
+// UpdateDispute saves a dispute's status and evidence, guarding against concurrent transitions
+func (s *SQLStore) UpdateDispute(d *Dispute, fromStatus string) error {
+	result, err := s.exec(`UPDATE disputes SET status = ?, evidence = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		d.Status, d.Evidence, d.UpdatedAt, d.ID, fromStatus)
+	if err != nil {
+		return fmt.Errorf("failed to update dispute: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s is no longer %s", ErrInvalidDisputeTransition, d.ID, fromStatus)
+	}
+	return nil
+}
+
+// ListDisputes returns a merchant's disputes, optionally filtered by payment and
+// status, newest first
+func (s *SQLStore) ListDisputes(merchantID, paymentID, status string) ([]Dispute, error) {
+	conditions := []string{"merchant_id = ?"}
+	args := []interface{}{merchantID}
+	if paymentID != "" {
+		conditions = append(conditions, "payment_id = ?")
+		args = append(args, paymentID)
+	}
+	if status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
+	}
+
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	rows, err := s.query("SELECT "+disputeColumns+" FROM disputes"+where+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := make([]Dispute, 0)
+	for rows.Next() {
+		d, err := scanDispute(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, *d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return disputes, nil
+}
+
+const subscriptionColumns = `id, merchant_id, COALESCE(user_id, ''), amount, currency, billing_interval, status, cycle,
+			  failed_attempts, next_run_at, COALESCE(last_payment_id, ''), COALESCE(last_payment_status, ''), created_at, updated_at`
+
+// scanSubscription reads a subscription row, mapping sql.ErrNoRows to ErrSubscriptionNotFound
+func scanSubscription(scan func(dest ...interface{}) error) (*Subscription, error) {
+	var sub Subscription
+	err := scan(&sub.ID, &sub.MerchantID, &sub.UserID, &sub.Amount, &sub.Currency, &sub.Interval, &sub.Status, &sub.Cycle,
+		&sub.FailedAttempts, &sub.NextRunAt, &sub.LastPaymentID, &sub.LastPaymentStatus, &sub.CreatedAt, &sub.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// CreateSubscription inserts a new subscription
+func (s *SQLStore) CreateSubscription(sub *Subscription) error {
+	_, err := s.exec(`INSERT INTO subscriptions (id, merchant_id, user_id, amount, currency, billing_interval, status, cycle,
+			  failed_attempts, next_run_at, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sub.ID, sub.MerchantID, sub.UserID, sub.Amount, sub.Currency, sub.Interval, sub.Status, sub.Cycle,
+		sub.FailedAttempts, sub.NextRunAt, sub.CreatedAt, sub.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store subscription: %v", err)
+	}
+	return nil
+}
+
+// GetSubscription returns a subscription by ID
+func (s *SQLStore) GetSubscription(id string) (*Subscription, error) {
+	return scanSubscription(s.queryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE id = ?", id).Scan)
+}
+
+// ListSubscriptions returns a merchant's subscriptions, optionally filtered by status,
+// newest first
+func (s *SQLStore) ListSubscriptions(merchantID, status string) ([]Subscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions WHERE merchant_id = ?"
+	args := []interface{}{merchantID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := s.query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]Subscription, 0)
+	for rows.Next() {
+		sub, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, *sub)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// UpdateSubscription saves a subscription's schedule and status. Cancelled
+// subscriptions are never overwritten, so a cycle finishing after a cancel can't revive it
+func (s *SQLStore) UpdateSubscription(sub *Subscription) error {
+	_, err := s.exec(`UPDATE subscriptions SET status = ?, cycle = ?, failed_attempts = ?, next_run_at = ?,
+			  last_payment_id = ?, last_payment_status = ?, updated_at = ?
+			  WHERE id = ? AND status <> ?`,
+		sub.Status, sub.Cycle, sub.FailedAttempts, sub.NextRunAt, sub.LastPaymentID, sub.LastPaymentStatus, sub.UpdatedAt,
+		sub.ID, SubscriptionCancelled)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription: %v", err)
+	}
+	return nil
+}
+
+// ClaimDueSubscriptions leases due subscriptions with SKIP LOCKED
+func (s *SQLStore) ClaimDueSubscriptions(now time.Time, lease time.Duration, limit int) ([]Subscription, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.dialect.Rebind("SELECT "+subscriptionColumns+` FROM subscriptions
+			  WHERE status IN (?, ?) AND next_run_at <= ? ORDER BY next_run_at LIMIT ? FOR UPDATE SKIP LOCKED`),
+		SubscriptionActive, SubscriptionPastDue, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	subs := make([]Subscription, 0)
+	for rows.Next() {
+		sub, err := scanSubscription(rows.Scan)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		subs = append(subs, *sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, sub := range subs {
+		if _, err := s.execOn(tx, `UPDATE subscriptions SET next_run_at = ? WHERE id = ?`, now.Add(lease), sub.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return subs, tx.Commit()
+}
+
+const scheduledPaymentColumns = `payment_id, COALESCE(order_id, ''), amount, currency, COALESCE(user_id, ''), merchant_id,
+			  COALESCE(payment_token, ''), schedule_at, status, created_at, updated_at`
+
+func scanScheduledPayments(rows *sql.Rows) ([]ScheduledPayment, error) {
+	payments := make([]ScheduledPayment, 0)
+	for rows.Next() {
+		var sp ScheduledPayment
+		err := rows.Scan(&sp.PaymentID, &sp.OrderID, &sp.Amount, &sp.Currency, &sp.UserID, &sp.MerchantID,
+			&sp.PaymentToken, &sp.ScheduleAt, &sp.Status, &sp.CreatedAt, &sp.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, sp)
+	}
+	return payments, rows.Err()
+}
+
+// CreateScheduledPayment inserts a pending scheduled payment
+func (s *SQLStore) CreateScheduledPayment(sp *ScheduledPayment) error {
+	_, err := s.exec(`INSERT INTO scheduled_payments (payment_id, order_id, amount, currency, user_id, merchant_id, payment_token, schedule_at, status, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sp.PaymentID, sp.OrderID, sp.Amount, sp.Currency, sp.UserID, sp.MerchantID, sp.PaymentToken, sp.ScheduleAt, sp.Status, sp.CreatedAt, sp.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store scheduled payment: %v", err)
+	}
+	return nil
+}
+
+// ListScheduledPayments returns a merchant's scheduled payments, optionally filtered by status, soonest first
+func (s *SQLStore) ListScheduledPayments(merchantID, status string) ([]ScheduledPayment, error) {
+	query := "SELECT " + scheduledPaymentColumns + " FROM scheduled_payments WHERE merchant_id = ?"
+	args := []interface{}{merchantID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := s.query(query+" ORDER BY schedule_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanScheduledPayments(rows)
+}
+
+// CancelScheduledPayment cancels one of a merchant's payments that has not been dispatched yet
+func (s *SQLStore) CancelScheduledPayment(merchantID, paymentID string) error {
+	result, err := s.exec(`UPDATE scheduled_payments SET status = ?, updated_at = ? WHERE merchant_id = ? AND payment_id = ? AND status = ?`,
+		ScheduledCancelled, time.Now().UTC(), merchantID, paymentID, ScheduledPending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled payment: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// Nothing was cancelled: tell a payment that is no longer pending from one that
+	// doesn't exist or belongs to another merchant
+	var status string
+	err = s.queryRow(`SELECT status FROM scheduled_payments WHERE merchant_id = ? AND payment_id = ?`, merchantID, paymentID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrScheduledPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrScheduledPaymentNotPending
+}
+
+// ClaimDueScheduledPayments marks due payments dispatched with SKIP LOCKED so each
+// payment is dispatched by exactly one instance
+func (s *SQLStore) ClaimDueScheduledPayments(now time.Time, limit int) ([]ScheduledPayment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.dialect.Rebind("SELECT "+scheduledPaymentColumns+` FROM scheduled_payments
+			  WHERE status = ? AND schedule_at <= ? ORDER BY schedule_at LIMIT ? FOR UPDATE SKIP LOCKED`),
+		ScheduledPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := scanScheduledPayments(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range payments {
+		payments[i].Status = ScheduledDispatched
+		payments[i].UpdatedAt = now
+		if _, err := s.execOn(tx, `UPDATE scheduled_payments SET status = ?, updated_at = ? WHERE payment_id = ?`,
+			ScheduledDispatched, now, payments[i].PaymentID); err != nil {
+			return nil, err
+		}
+	}
+
+	return payments, tx.Commit()
+}
+
+const payoutColumns = `id, reference, merchant_id, COALESCE(user_id, ''), amount, currency, destination,
+			  COALESCE(beneficiary_name, ''), status, COALESCE(provider, ''), COALESCE(provider_txn_id, ''),
+			  COALESCE(error_code, ''), COALESCE(error_message, ''), created_at, updated_at`
+
+// scanPayout reads a payout row, mapping sql.ErrNoRows to ErrPayoutNotFound
+func scanPayout(scan func(dest ...interface{}) error) (*Payout, error) {
+	var p Payout
+	err := scan(&p.ID, &p.Reference, &p.MerchantID, &p.UserID, &p.Amount, &p.Currency, &p.Destination,
+		&p.BeneficiaryName, &p.Status, &p.Provider, &p.ProviderTxnID, &p.ErrorCode, &p.ErrorMessage, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPayoutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreatePayout inserts a new payout
+func (s *SQLStore) CreatePayout(p *Payout) error {
+	_, err := s.exec(`INSERT INTO payouts (id, reference, merchant_id, user_id, amount, currency, destination, beneficiary_name,
+			  status, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Reference, p.MerchantID, p.UserID, p.Amount, p.Currency, p.Destination, p.BeneficiaryName,
+		p.Status, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store payout: %v", err)
+	}
+	return nil
+}
+
+// GetPayout returns a payout by ID
+func (s *SQLStore) GetPayout(id string) (*Payout, error) {
+	return scanPayout(s.queryRow("SELECT "+payoutColumns+" FROM payouts WHERE id = ?", id).Scan)
+}
+
+// GetPayoutByReference returns a merchant's payout by its own reference
+func (s *SQLStore) GetPayoutByReference(merchantID, reference string) (*Payout, error) {
+	return scanPayout(s.queryRow("SELECT "+payoutColumns+" FROM payouts WHERE merchant_id = ? AND reference = ?",
+		merchantID, reference).Scan)
+}
+
+// UpdatePayout saves a payout's status and outcome, guarding against concurrent transitions
+func (s *SQLStore) UpdatePayout(p *Payout, fromStatus string) error {
+	result, err := s.exec(`UPDATE payouts SET status = ?, provider = ?, provider_txn_id = ?, error_code = ?, error_message = ?, updated_at = ?
+			  WHERE id = ? AND status = ?`,
+		p.Status, p.Provider, p.ProviderTxnID, p.ErrorCode, p.ErrorMessage, p.UpdatedAt, p.ID, fromStatus)
+	if err != nil {
+		return fmt.Errorf("failed to update payout: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s is no longer %s", ErrInvalidPayoutTransition, p.ID, fromStatus)
+	}
+	return nil
+}
+
+// ListPayouts returns payouts, optionally filtered by status, newest first
+func (s *SQLStore) ListPayouts(status string) ([]Payout, error) {
+	query := "SELECT " + payoutColumns + " FROM payouts"
+	args := make([]interface{}, 0)
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := s.query(query+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := make([]Payout, 0)
+	for rows.Next() {
+		p, err := scanPayout(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, *p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
+
+const bnplColumns = `id, reference, merchant_id, idempotency_key, provider, provider_session_id, amount, currency,
+			  customer_email, term, status, COALESCE(approval_url, ''), installments, version, created_at, updated_at`
+
+// scanBNPLPlan reads a BNPL plan row, mapping sql.ErrNoRows to ErrBNPLPlanNotFound
+func scanBNPLPlan(scan func(dest ...interface{}) error) (*BNPLPlan, error) {
+	var plan BNPLPlan
+	var installments string
+	err := scan(&plan.ID, &plan.Reference, &plan.MerchantID, &plan.IdempotencyKey, &plan.Provider, &plan.ProviderSessionID,
+		&plan.Amount, &plan.Currency, &plan.CustomerEmail, &plan.Term, &plan.Status, &plan.ApprovalURL, &installments,
+		&plan.Version, &plan.CreatedAt, &plan.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBNPLPlanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(installments), &plan.Installments); err != nil {
+		return nil, fmt.Errorf("invalid installments for %s: %v", plan.ID, err)
+	}
+	return &plan, nil
+}
+
+// CreateBNPLPlan inserts a new BNPL plan
+func (s *SQLStore) CreateBNPLPlan(plan *BNPLPlan) error {
+	installments, err := json.Marshal(plan.Installments)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(`INSERT INTO bnpl_plans (id, reference, merchant_id, idempotency_key, provider, provider_session_id, amount, currency,
+			  customer_email, term, status, approval_url, installments, version, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		plan.ID, plan.Reference, plan.MerchantID, plan.IdempotencyKey, plan.Provider, plan.ProviderSessionID, plan.Amount, plan.Currency,
+		plan.CustomerEmail, plan.Term, plan.Status, plan.ApprovalURL, string(installments), plan.Version, plan.CreatedAt, plan.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store BNPL plan: %v", err)
+	}
+	return nil
+}
+
+// GetBNPLPlan returns a BNPL plan by ID
+func (s *SQLStore) GetBNPLPlan(id string) (*BNPLPlan, error) {
+	return scanBNPLPlan(s.queryRow("SELECT "+bnplColumns+" FROM bnpl_plans WHERE id = ?", id).Scan)
+}
+
+// GetBNPLPlanByIdempotencyKey returns a merchant's BNPL plan by the key it was created with
+func (s *SQLStore) GetBNPLPlanByIdempotencyKey(merchantID, key string) (*BNPLPlan, error) {
+	return scanBNPLPlan(s.queryRow("SELECT "+bnplColumns+" FROM bnpl_plans WHERE merchant_id = ? AND idempotency_key = ?",
+		merchantID, key).Scan)
+}
+
+// UpdateBNPLPlan saves a plan's status and installments using the version column for optimistic locking
+func (s *SQLStore) UpdateBNPLPlan(plan *BNPLPlan) error {
+	installments, err := json.Marshal(plan.Installments)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.exec(`UPDATE bnpl_plans SET status = ?, installments = ?, version = version + 1, updated_at = ?
+			  WHERE id = ? AND version = ?`,
+		plan.Status, string(installments), plan.UpdatedAt, plan.ID, plan.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update BNPL plan: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrBNPLConcurrentUpdate
+	}
+	plan.Version++
+	return nil
+}
+
+const vaultTokenColumns = `token, merchant_id, kek_id, wrapped_dek, ciphertext, bin, last4, brand, exp_month, exp_year, expires_at, created_at`
+
+func scanVaultToken(scan func(dest ...interface{}) error) (*VaultToken, error) {
+	var t VaultToken
+	err := scan(&t.Token, &t.MerchantID, &t.KEKID, &t.WrappedDEK, &t.Ciphertext, &t.BIN, &t.Last4, &t.Brand,
+		&t.ExpMonth, &t.ExpYear, &t.ExpiresAt, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveVaultToken inserts an encrypted vault token
+func (s *SQLStore) SaveVaultToken(t *VaultToken) error {
+	_, err := s.exec(`INSERT INTO vault_tokens (`+vaultTokenColumns+`)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Token, t.MerchantID, t.KEKID, t.WrappedDEK, t.Ciphertext, t.BIN, t.Last4, t.Brand,
+		t.ExpMonth, t.ExpYear, t.ExpiresAt, t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store vault token: %v", err)
+	}
+	return nil
+}
+
+// GetVaultToken returns a vault token by its token reference
+func (s *SQLStore) GetVaultToken(token string) (*VaultToken, error) {
+	return scanVaultToken(s.queryRow("SELECT "+vaultTokenColumns+" FROM vault_tokens WHERE token = ?", token).Scan)
+}
+
+// ListVaultTokensNotUnder returns tokens whose data key is wrapped by a KEK other than kekID
+func (s *SQLStore) ListVaultTokensNotUnder(kekID string, limit int) ([]VaultToken, error) {
+	rows, err := s.query("SELECT "+vaultTokenColumns+" FROM vault_tokens WHERE kek_id <> ? LIMIT ?", kekID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]VaultToken, 0)
+	for rows.Next() {
+		t, err := scanVaultToken(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// UpdateVaultTokenKey replaces a token's wrapped data key after KEK rotation
+func (s *SQLStore) UpdateVaultTokenKey(token, kekID string, wrappedDEK []byte) error {
+	_, err := s.exec("UPDATE vault_tokens SET kek_id = ?, wrapped_dek = ? WHERE token = ?", kekID, wrappedDEK, token)
+	if err != nil {
+		return fmt.Errorf("failed to update vault token key: %v", err)
+	}
+	return nil
+}
+
+const customerColumns = `id, merchant_id, COALESCE(email, ''), COALESCE(name, ''), COALESCE(user_id, ''), created_at, updated_at`
+
+// scanCustomer reads a customer row, mapping sql.ErrNoRows to ErrCustomerNotFound
+func scanCustomer(scan func(dest ...interface{}) error) (*Customer, error) {
+	var c Customer
+	err := scan(&c.ID, &c.MerchantID, &c.Email, &c.Name, &c.UserID, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCustomerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateCustomer inserts a new customer
+func (s *SQLStore) CreateCustomer(c *Customer) error {
+	_, err := s.exec(`INSERT INTO customers (`+customerColumns+`)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.MerchantID, c.Email, c.Name, c.UserID, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store customer: %v", err)
+	}
+	return nil
+}
+
+// GetCustomer returns a customer by ID
+func (s *SQLStore) GetCustomer(id string) (*Customer, error) {
+	return scanCustomer(s.queryRow("SELECT "+customerColumns+" FROM customers WHERE id = ?", id).Scan)
+}
+
+// ListCustomers returns a merchant's customers, newest first
+func (s *SQLStore) ListCustomers(merchantID string) ([]Customer, error) {
+	rows, err := s.query("SELECT "+customerColumns+" FROM customers WHERE merchant_id = ? ORDER BY created_at DESC", merchantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := make([]Customer, 0)
+	for rows.Next() {
+		c, err := scanCustomer(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, *c)
+	}
+	return customers, rows.Err()
+}
+
+const paymentMethodColumns = `id, customer_id, merchant_id, token, brand, last4, exp_month, exp_year, created_at`
+
+// scanPaymentMethod reads a payment method row, mapping sql.ErrNoRows to ErrPaymentMethodNotFound
+func scanPaymentMethod(scan func(dest ...interface{}) error) (*PaymentMethod, error) {
+	var pm PaymentMethod
+	err := scan(&pm.ID, &pm.CustomerID, &pm.MerchantID, &pm.Token, &pm.Brand, &pm.Last4, &pm.ExpMonth, &pm.ExpYear, &pm.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaymentMethodNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pm, nil
+}
+
+// AttachPaymentMethod saves a payment method to its customer, returning
+// ErrPaymentMethodExists when the token is already attached
+func (s *SQLStore) AttachPaymentMethod(pm *PaymentMethod) error {
+	var count int
+	if err := s.queryRow("SELECT COUNT(*) FROM customer_payment_methods WHERE customer_id = ? AND token = ?",
+		pm.CustomerID, pm.Token).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPaymentMethodExists
+	}
+
+	_, err := s.exec(`INSERT INTO customer_payment_methods (`+paymentMethodColumns+`)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		pm.ID, pm.CustomerID, pm.MerchantID, pm.Token, pm.Brand, pm.Last4, pm.ExpMonth, pm.ExpYear, pm.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store payment method: %v", err)
+	}
+	return nil
+}
+
+// GetPaymentMethod returns a payment method by ID
+func (s *SQLStore) GetPaymentMethod(id string) (*PaymentMethod, error) {
+	return scanPaymentMethod(s.queryRow("SELECT "+paymentMethodColumns+" FROM customer_payment_methods WHERE id = ?", id).Scan)
+}
+
+// ListPaymentMethods returns a customer's payment methods, oldest first
+func (s *SQLStore) ListPaymentMethods(customerID string) ([]PaymentMethod, error) {
+	rows, err := s.query("SELECT "+paymentMethodColumns+" FROM customer_payment_methods WHERE customer_id = ? ORDER BY created_at", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	methods := make([]PaymentMethod, 0)
+	for rows.Next() {
+		pm, err := scanPaymentMethod(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, *pm)
+	}
+	return methods, rows.Err()
+}
+
+// DeletePaymentMethod removes a payment method from its customer
+func (s *SQLStore) DeletePaymentMethod(customerID, id string) error {
+	result, err := s.exec("DELETE FROM customer_payment_methods WHERE id = ? AND customer_id = ?", id, customerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete payment method: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPaymentMethodNotFound
+	}
+	return nil
+}
+
+// ListRoutingRules returns routing rules in evaluation order
+func (s *SQLStore) ListRoutingRules() ([]RoutingRule, error) {
+	rows, err := s.query(`SELECT id, COALESCE(name, ''), conditions, provider, disabled, created_at, updated_at
+			  FROM routing_rules ORDER BY position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]RoutingRule, 0)
+	for rows.Next() {
+		var rule RoutingRule
+		var conditions string
+		if err := rows.Scan(&rule.ID, &rule.Name, &conditions, &rule.Provider, &rule.Disabled, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(conditions), &rule.Conditions); err != nil {
+			return nil, fmt.Errorf("invalid conditions for rule %s: %v", rule.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ReplaceRoutingRules swaps the whole rule set in one transaction
+func (s *SQLStore) ReplaceRoutingRules(rules []RoutingRule) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.execOn(tx, "DELETE FROM routing_rules"); err != nil {
+		return fmt.Errorf("failed to clear routing rules: %v", err)
+	}
+	for i, rule := range rules {
+		conditions, err := json.Marshal(rule.Conditions)
+		if err != nil {
+			return err
+		}
+		_, err = s.execOn(tx, `INSERT INTO routing_rules (id, position, name, conditions, provider, disabled, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.ID, i, rule.Name, string(conditions), rule.Provider, rule.Disabled, rule.CreatedAt, rule.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store routing rule: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListMaintenanceWindows returns every provider maintenance window
+func (s *SQLStore) ListMaintenanceWindows() ([]MaintenanceWindow, error) {
+	rows, err := s.query(`SELECT id, provider, starts_at, ends_at, COALESCE(recurrence, ''), COALESCE(reason, ''), created_at
+			  FROM provider_maintenance_windows ORDER BY provider, starts_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := make([]MaintenanceWindow, 0)
+	for rows.Next() {
+		var window MaintenanceWindow
+		if err := rows.Scan(&window.ID, &window.Provider, &window.Start, &window.End, &window.Recurrence, &window.Reason, &window.CreatedAt); err != nil {
+			return nil, err
+		}
+		window.Start, window.End = window.Start.UTC(), window.End.UTC()
+		windows = append(windows, window)
+	}
+	return windows, rows.Err()
+}
+
+// SaveMaintenanceWindow stores a new maintenance window
+func (s *SQLStore) SaveMaintenanceWindow(window *MaintenanceWindow) error {
+	_, err := s.exec(`INSERT INTO provider_maintenance_windows (id, provider, starts_at, ends_at, recurrence, reason, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		window.ID, window.Provider, window.Start, window.End, window.Recurrence, window.Reason, window.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store maintenance window: %v", err)
+	}
+	return nil
+}
+
+// DeleteMaintenanceWindow removes a maintenance window
+func (s *SQLStore) DeleteMaintenanceWindow(id string) error {
+	result, err := s.exec("DELETE FROM provider_maintenance_windows WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete maintenance window: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrMaintenanceWindowNotFound
+	}
+	return nil
+}
+
+// RecordPaymentTransition appends a payment state transition
+func (s *SQLStore) RecordPaymentTransition(entry *PaymentHistoryEntry) error {
+	_, err := s.exec(`INSERT INTO `+paymentHistoryTable(isTestPayment(entry.PaymentID))+` (payment_id, from_state, to_state, reason, actor, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.PaymentID, entry.From, entry.To, entry.Reason, entry.Actor, entry.At)
+	if err != nil {
+		return fmt.Errorf("failed to store payment state transition: %v", err)
+	}
+	return nil
+}
+
+// GetPaymentHistory returns a payment's state transitions in the order they happened
+func (s *SQLStore) GetPaymentHistory(paymentID string) ([]PaymentHistoryEntry, error) {
+	rows, err := s.query(`SELECT payment_id, COALESCE(from_state, ''), to_state, COALESCE(reason, ''), COALESCE(actor, ''), created_at
+			  FROM `+paymentHistoryTable(isTestPayment(paymentID))+` WHERE payment_id = ? ORDER BY id`, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]PaymentHistoryEntry, 0)
+	for rows.Next() {
+		var entry PaymentHistoryEntry
+		if err := rows.Scan(&entry.PaymentID, &entry.From, &entry.To, &entry.Reason, &entry.Actor, &entry.At); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// RecordAudit appends an admin audit log entry
+func (s *SQLStore) RecordAudit(entry *AuditEntry) error {
+	_, err := s.exec(`INSERT INTO admin_audit_log (actor, action, target, before_value, after_value, remote_addr, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor, entry.Action, entry.Target, nullableJSON(entry.Before), nullableJSON(entry.After), entry.RemoteAddr, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store audit entry: %v", err)
+	}
+	return nil
+}
+
+// QueryAudit returns audit entries matching the filter, newest first, with the total match count
+func (s *SQLStore) QueryAudit(filter AuditFilter) ([]AuditEntry, int, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, *filter.To)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM admin_audit_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(`SELECT id, actor, action, target, COALESCE(before_value, ''), COALESCE(after_value, ''), COALESCE(remote_addr, ''), created_at
+			  FROM admin_audit_log`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after string
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Target, &before, &after, &entry.RemoteAddr, &entry.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		if before != "" {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			entry.After = json.RawMessage(after)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// nullableJSON stores empty JSON values as NULL
+func nullableJSON(value json.RawMessage) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	return string(value)
+}
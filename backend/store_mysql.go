@@ -0,0 +1,328 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLDialect implements Dialect for MySQL
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string {
+	return "mysql"
+}
+
+// mysqlDSN builds the MySQL connection string from MYSQL_* environment variables
+func mysqlDSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+		os.Getenv("MYSQL_USER"),
+		os.Getenv("MYSQL_PASSWORD"),
+		os.Getenv("MYSQL_HOST"),
+		os.Getenv("MYSQL_PORT"),
+		os.Getenv("MYSQL_DATABASE"),
+	)
+}
+
+func (MySQLDialect) SchemaStatements() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS log(
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				payment_id VARCHAR(255),
+				server_url VARCHAR(255) NOT NULL,
+				latency_ms INT NOT NULL,
+				success BOOLEAN NOT NULL,
+				score FLOAT NOT NULL,
+				error_type VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_server_url (server_url),
+				INDEX idx_created_at (created_at)
+				);`,
+		`CREATE TABLE IF NOT EXISTS users (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				name VARCHAR(255) NOT NULL UNIQUE,
+				password TEXT NOT NULL,
+				email VARCHAR(255) DEFAULT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);`,
+		`CREATE TABLE IF NOT EXISTS payments(
+				payment_id VARCHAR(255) PRIMARY KEY,
+				order_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				user_id VARCHAR(255),
+				merchant_id VARCHAR(255),
+				provider VARCHAR(100),
+				status VARCHAR(20) NOT NULL,
+				latency_ms INT NOT NULL DEFAULT 0,
+				error_code VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+				INDEX idx_payments_status (status),
+				INDEX idx_payments_provider (provider),
+				INDEX idx_payments_created_at (created_at)
+				);`,
+		`CREATE TABLE IF NOT EXISTS payment_transitions(
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				from_status VARCHAR(20),
+				to_status VARCHAR(20) NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_transitions_payment_id (payment_id)
+				);`,
+		// Test-mode payments are kept apart from live ones, see testmode.go
+		`CREATE TABLE IF NOT EXISTS test_payments LIKE payments;`,
+		`CREATE TABLE IF NOT EXISTS test_payment_transitions LIKE payment_transitions;`,
+		`CREATE TABLE IF NOT EXISTS outbox(
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				aggregate_id VARCHAR(255) NOT NULL,
+				event_type VARCHAR(100) NOT NULL,
+				payload TEXT NOT NULL,
+				attempts INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				next_attempt_at TIMESTAMP NULL DEFAULT NULL,
+				delivered_at TIMESTAMP NULL DEFAULT NULL,
+				dead_at TIMESTAMP NULL DEFAULT NULL,
+				INDEX idx_outbox_pending (delivered_at, dead_at, id)
+				);`,
+		`CREATE TABLE IF NOT EXISTS ledger_transactions(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				type VARCHAR(20) NOT NULL,
+				capture_reference VARCHAR(255) AS (CASE WHEN type IN ('PAYMENT', 'FEE') THEN reference END) STORED,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_ledger_transactions_reference (reference),
+				UNIQUE KEY uq_ledger_transactions_capture (capture_reference, type)
+				);`,
+		`CREATE TABLE IF NOT EXISTS ledger_entries(
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				transaction_id VARCHAR(64) NOT NULL,
+				account VARCHAR(255) NOT NULL,
+				direction VARCHAR(6) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_ledger_entries_transaction_id (transaction_id),
+				INDEX idx_ledger_entries_account (account, currency)
+				);`,
+		`CREATE TABLE IF NOT EXISTS disputes(
+				id VARCHAR(64) PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				provider_dispute_id VARCHAR(255) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				reason VARCHAR(255),
+				status VARCHAR(20) NOT NULL,
+				evidence TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE KEY uq_disputes_provider_ref (provider, provider_dispute_id),
+				INDEX idx_disputes_payment_id (payment_id)
+				);`,
+		`CREATE TABLE IF NOT EXISTS subscriptions(
+				id VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				user_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				billing_interval VARCHAR(32) NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				cycle INT NOT NULL DEFAULT 1,
+				failed_attempts INT NOT NULL DEFAULT 0,
+				next_run_at TIMESTAMP NOT NULL,
+				last_payment_id VARCHAR(255),
+				last_payment_status VARCHAR(20),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_subscriptions_due (status, next_run_at)
+				);`,
+		`CREATE TABLE IF NOT EXISTS scheduled_payments(
+				payment_id VARCHAR(255) PRIMARY KEY,
+				order_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				user_id VARCHAR(255),
+				merchant_id VARCHAR(255) NOT NULL,
+				payment_token VARCHAR(64),
+				schedule_at TIMESTAMP NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_scheduled_payments_due (status, schedule_at)
+				);`,
+		`CREATE TABLE IF NOT EXISTS payouts(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				user_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				destination VARCHAR(255) NOT NULL,
+				beneficiary_name VARCHAR(255),
+				status VARCHAR(20) NOT NULL,
+				provider VARCHAR(100),
+				provider_txn_id VARCHAR(255),
+				error_code VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE KEY uq_payouts_reference (merchant_id, reference),
+				INDEX idx_payouts_status (status)
+				);`,
+		`CREATE TABLE IF NOT EXISTS bnpl_plans(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				idempotency_key VARCHAR(255) NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				provider_session_id VARCHAR(255) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				customer_email VARCHAR(255) NOT NULL,
+				term INT NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				approval_url TEXT,
+				installments TEXT NOT NULL,
+				version INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE KEY uq_bnpl_idempotency (merchant_id, idempotency_key)
+				);`,
+		`CREATE TABLE IF NOT EXISTS vault_tokens(
+				token VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				kek_id VARCHAR(64) NOT NULL,
+				wrapped_dek VARBINARY(128) NOT NULL,
+				ciphertext BLOB NOT NULL,
+				bin CHAR(6) NOT NULL,
+				last4 CHAR(4) NOT NULL,
+				brand VARCHAR(20) NOT NULL,
+				exp_month INT NOT NULL,
+				exp_year INT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_vault_tokens_kek (kek_id)
+				);`,
+		`CREATE TABLE IF NOT EXISTS customers(
+				id VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				email VARCHAR(255),
+				name VARCHAR(255),
+				user_id VARCHAR(255),
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				INDEX idx_customers_merchant (merchant_id)
+				);`,
+		`CREATE TABLE IF NOT EXISTS customer_payment_methods(
+				id VARCHAR(64) PRIMARY KEY,
+				customer_id VARCHAR(64) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				token VARCHAR(64) NOT NULL,
+				brand VARCHAR(20) NOT NULL,
+				last4 CHAR(4) NOT NULL,
+				exp_month INT NOT NULL,
+				exp_year INT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE KEY uq_payment_methods_token (customer_id, token)
+				);`,
+		`CREATE TABLE IF NOT EXISTS routing_rules(
+				id VARCHAR(64) PRIMARY KEY,
+				position INT NOT NULL,
+				name VARCHAR(255),
+				conditions TEXT NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				disabled BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+				);`,
+		`CREATE TABLE IF NOT EXISTS provider_maintenance_windows(
+				id VARCHAR(64) PRIMARY KEY,
+				provider VARCHAR(100) NOT NULL,
+				starts_at TIMESTAMP(6) NOT NULL,
+				ends_at TIMESTAMP(6) NOT NULL,
+				recurrence VARCHAR(20),
+				reason VARCHAR(255),
+				created_at TIMESTAMP(6) NOT NULL,
+				INDEX idx_maintenance_provider (provider)
+				);`,
+		`CREATE TABLE IF NOT EXISTS payment_state_history(
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				from_state VARCHAR(20),
+				to_state VARCHAR(20) NOT NULL,
+				reason VARCHAR(255),
+				actor VARCHAR(255),
+				created_at TIMESTAMP(6) NOT NULL,
+				INDEX idx_state_history_payment_id (payment_id, id)
+				);`,
+		`CREATE TABLE IF NOT EXISTS test_payment_state_history LIKE payment_state_history;`,
+		`CREATE TABLE IF NOT EXISTS admin_audit_log(
+				id BIGINT AUTO_INCREMENT PRIMARY KEY,
+				actor VARCHAR(255) NOT NULL,
+				action VARCHAR(100) NOT NULL,
+				target VARCHAR(255) NOT NULL,
+				before_value TEXT,
+				after_value TEXT,
+				remote_addr VARCHAR(100),
+				created_at TIMESTAMP(6) NOT NULL,
+				INDEX idx_audit_actor (actor),
+				INDEX idx_audit_action (action),
+				INDEX idx_audit_created_at (created_at)
+				);`,
+	}
+}
+
+func (MySQLDialect) CurrentSchema() string {
+	return "DATABASE()"
+}
+
+func (MySQLDialect) ColumnMigrations() []ColumnMigration {
+	return []ColumnMigration{
+		{Table: "outbox", Column: "next_attempt_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+		{Table: "outbox", Column: "dead_at", Definition: "TIMESTAMP NULL DEFAULT NULL"},
+		// Posts payments and fees once per payment. Adding the key fails while duplicate
+		// captures are recorded; those must be reversed before the schema can migrate
+		{
+			Table:      "ledger_transactions",
+			Column:     "capture_reference",
+			Definition: "VARCHAR(255) AS (CASE WHEN type IN ('PAYMENT', 'FEE') THEN reference END) STORED",
+			Key:        "UNIQUE KEY uq_ledger_transactions_capture (capture_reference, type)",
+		},
+	}
+}
+
+func (MySQLDialect) HourBucket(column string) string {
+	return "DATE_FORMAT(" + column + ", '%Y-%m-%dT%H:00:00')"
+}
+
+func (MySQLDialect) Rebind(query string) string {
+	return query
+}
+
+func (MySQLDialect) UpsertPaymentQuery(table string) string {
+	return `INSERT INTO ` + table + ` (payment_id, order_id, amount, currency, user_id, merchant_id, provider, status, latency_ms, error_code, error_message, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON DUPLICATE KEY UPDATE provider = VALUES(provider), status = VALUES(status), latency_ms = VALUES(latency_ms),
+			  error_code = VALUES(error_code), error_message = VALUES(error_message), updated_at = VALUES(updated_at)`
+}
+
+func (MySQLDialect) InsertUser(db *sql.DB, name, passwordHash string) (int64, error) {
+	result, err := db.Exec("INSERT INTO users (name, password) VALUES (?, ?)", name, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// IsUniqueViolation matches MySQL's duplicate entry error
+func (MySQLDialect) IsUniqueViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
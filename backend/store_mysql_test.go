@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMySQLMigrationAddsLedgerCaptureKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewSQLStore(db, MySQLDialect{})
+
+	var migration ColumnMigration
+	for _, m := range (MySQLDialect{}).ColumnMigrations() {
+		if m.Table == "ledger_transactions" && m.Column == "capture_reference" {
+			migration = m
+		}
+	}
+	if migration.Key == "" {
+		t.Fatal("no ledger_transactions.capture_reference migration with a key")
+	}
+
+	// An upgraded database has the table without the column
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM information_schema.tables`)).
+		WithArgs("ledger_transactions", "ledger_transactions", "capture_reference").
+		WillReturnRows(sqlmock.NewRows([]string{"tables", "columns"}).AddRow(1, 0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE ledger_transactions ADD COLUMN capture_reference VARCHAR(255) AS (CASE WHEN type IN ('PAYMENT', 'FEE') THEN reference END) STORED, ADD UNIQUE KEY uq_ledger_transactions_capture (capture_reference, type)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.migrateColumn(migration); err != nil {
+		t.Fatalf("migrateColumn: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// PostgresDialect implements Dialect for PostgreSQL
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string {
+	return "postgres"
+}
+
+// postgresDSN builds the PostgreSQL connection string from POSTGRES_* environment variables
+func postgresDSN() string {
+	sslMode := os.Getenv("POSTGRES_SSLMODE")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("POSTGRES_HOST"),
+		os.Getenv("POSTGRES_PORT"),
+		os.Getenv("POSTGRES_USER"),
+		os.Getenv("POSTGRES_PASSWORD"),
+		os.Getenv("POSTGRES_DATABASE"),
+		sslMode,
+	)
+}
+
+func (PostgresDialect) SchemaStatements() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS log(
+				id SERIAL PRIMARY KEY,
+				payment_id VARCHAR(255),
+				server_url VARCHAR(255) NOT NULL,
+				latency_ms INTEGER NOT NULL,
+				success BOOLEAN NOT NULL,
+				score DOUBLE PRECISION NOT NULL,
+				error_type VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_server_url ON log (server_url)`,
+		`CREATE INDEX IF NOT EXISTS idx_created_at ON log (created_at)`,
+		`CREATE TABLE IF NOT EXISTS users (
+				id SERIAL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL UNIQUE,
+				password TEXT NOT NULL,
+				email VARCHAR(255) DEFAULT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE TABLE IF NOT EXISTS payments(
+				payment_id VARCHAR(255) PRIMARY KEY,
+				order_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				user_id VARCHAR(255),
+				merchant_id VARCHAR(255),
+				provider VARCHAR(100),
+				status VARCHAR(20) NOT NULL,
+				latency_ms INTEGER NOT NULL DEFAULT 0,
+				error_code VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_status ON payments (status)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_provider ON payments (provider)`,
+		`CREATE INDEX IF NOT EXISTS idx_payments_created_at ON payments (created_at)`,
+		`CREATE TABLE IF NOT EXISTS payment_transitions(
+				id SERIAL PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				from_status VARCHAR(20),
+				to_status VARCHAR(20) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_transitions_payment_id ON payment_transitions (payment_id)`,
+		// Test-mode payments are kept apart from live ones, see testmode.go
+		`CREATE TABLE IF NOT EXISTS test_payments (LIKE payments INCLUDING ALL)`,
+		`CREATE TABLE IF NOT EXISTS test_payment_transitions (LIKE payment_transitions INCLUDING ALL)`,
+		`CREATE TABLE IF NOT EXISTS outbox(
+				id BIGSERIAL PRIMARY KEY,
+				aggregate_id VARCHAR(255) NOT NULL,
+				event_type VARCHAR(100) NOT NULL,
+				payload TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				next_attempt_at TIMESTAMPTZ DEFAULT NULL,
+				delivered_at TIMESTAMPTZ DEFAULT NULL,
+				dead_at TIMESTAMPTZ DEFAULT NULL
+				)`,
+		// idx_outbox_pending predated dead-lettering and only excluded delivered events
+		`DROP INDEX IF EXISTS idx_outbox_pending`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (id) WHERE delivered_at IS NULL AND dead_at IS NULL`,
+		`CREATE TABLE IF NOT EXISTS ledger_transactions(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				type VARCHAR(20) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_transactions_reference ON ledger_transactions (reference)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS uq_ledger_transactions_capture ON ledger_transactions (reference, type) WHERE type IN ('PAYMENT', 'FEE')`,
+		`CREATE TABLE IF NOT EXISTS ledger_entries(
+				id BIGSERIAL PRIMARY KEY,
+				transaction_id VARCHAR(64) NOT NULL,
+				account VARCHAR(255) NOT NULL,
+				direction VARCHAR(6) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id ON ledger_entries (transaction_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries (account, currency)`,
+		`CREATE TABLE IF NOT EXISTS disputes(
+				id VARCHAR(64) PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				provider_dispute_id VARCHAR(255) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				reason VARCHAR(255),
+				status VARCHAR(20) NOT NULL,
+				evidence TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (provider, provider_dispute_id)
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_disputes_payment_id ON disputes (payment_id)`,
+		`CREATE TABLE IF NOT EXISTS subscriptions(
+				id VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				user_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				billing_interval VARCHAR(32) NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				cycle INTEGER NOT NULL DEFAULT 1,
+				failed_attempts INTEGER NOT NULL DEFAULT 0,
+				next_run_at TIMESTAMPTZ NOT NULL,
+				last_payment_id VARCHAR(255),
+				last_payment_status VARCHAR(20),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_subscriptions_due ON subscriptions (status, next_run_at)`,
+		`CREATE TABLE IF NOT EXISTS scheduled_payments(
+				payment_id VARCHAR(255) PRIMARY KEY,
+				order_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				user_id VARCHAR(255),
+				merchant_id VARCHAR(255) NOT NULL,
+				payment_token VARCHAR(64),
+				schedule_at TIMESTAMPTZ NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_payments_due ON scheduled_payments (status, schedule_at)`,
+		`CREATE TABLE IF NOT EXISTS payouts(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				user_id VARCHAR(255),
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				destination VARCHAR(255) NOT NULL,
+				beneficiary_name VARCHAR(255),
+				status VARCHAR(20) NOT NULL,
+				provider VARCHAR(100),
+				provider_txn_id VARCHAR(255),
+				error_code VARCHAR(50),
+				error_message TEXT,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (merchant_id, reference)
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_payouts_status ON payouts (status)`,
+		`CREATE TABLE IF NOT EXISTS bnpl_plans(
+				id VARCHAR(64) PRIMARY KEY,
+				reference VARCHAR(255) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				idempotency_key VARCHAR(255) NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				provider_session_id VARCHAR(255) NOT NULL,
+				amount BIGINT NOT NULL,
+				currency CHAR(3) NOT NULL,
+				customer_email VARCHAR(255) NOT NULL,
+				term INTEGER NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				approval_url TEXT,
+				installments TEXT NOT NULL,
+				version INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (merchant_id, idempotency_key)
+				)`,
+		`CREATE TABLE IF NOT EXISTS vault_tokens(
+				token VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				kek_id VARCHAR(64) NOT NULL,
+				wrapped_dek BYTEA NOT NULL,
+				ciphertext BYTEA NOT NULL,
+				bin CHAR(6) NOT NULL,
+				last4 CHAR(4) NOT NULL,
+				brand VARCHAR(20) NOT NULL,
+				exp_month INTEGER NOT NULL,
+				exp_year INTEGER NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_vault_tokens_kek ON vault_tokens (kek_id)`,
+		`CREATE TABLE IF NOT EXISTS customers(
+				id VARCHAR(64) PRIMARY KEY,
+				merchant_id VARCHAR(255) NOT NULL,
+				email VARCHAR(255),
+				name VARCHAR(255),
+				user_id VARCHAR(255),
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_customers_merchant ON customers (merchant_id)`,
+		`CREATE TABLE IF NOT EXISTS customer_payment_methods(
+				id VARCHAR(64) PRIMARY KEY,
+				customer_id VARCHAR(64) NOT NULL,
+				merchant_id VARCHAR(255) NOT NULL,
+				token VARCHAR(64) NOT NULL,
+				brand VARCHAR(20) NOT NULL,
+				last4 CHAR(4) NOT NULL,
+				exp_month INTEGER NOT NULL,
+				exp_year INTEGER NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (customer_id, token)
+				)`,
+		`CREATE TABLE IF NOT EXISTS routing_rules(
+				id VARCHAR(64) PRIMARY KEY,
+				position INTEGER NOT NULL,
+				name VARCHAR(255),
+				conditions TEXT NOT NULL,
+				provider VARCHAR(100) NOT NULL,
+				disabled BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+				)`,
+		`CREATE TABLE IF NOT EXISTS provider_maintenance_windows(
+				id VARCHAR(64) PRIMARY KEY,
+				provider VARCHAR(100) NOT NULL,
+				starts_at TIMESTAMPTZ NOT NULL,
+				ends_at TIMESTAMPTZ NOT NULL,
+				recurrence VARCHAR(20),
+				reason VARCHAR(255),
+				created_at TIMESTAMPTZ NOT NULL
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_maintenance_provider ON provider_maintenance_windows (provider)`,
+		`CREATE TABLE IF NOT EXISTS payment_state_history(
+				id BIGSERIAL PRIMARY KEY,
+				payment_id VARCHAR(255) NOT NULL,
+				from_state VARCHAR(20),
+				to_state VARCHAR(20) NOT NULL,
+				reason VARCHAR(255),
+				actor VARCHAR(255),
+				created_at TIMESTAMPTZ NOT NULL
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_state_history_payment_id ON payment_state_history (payment_id, id)`,
+		`CREATE TABLE IF NOT EXISTS test_payment_state_history (LIKE payment_state_history INCLUDING ALL)`,
+		`CREATE TABLE IF NOT EXISTS admin_audit_log(
+				id BIGSERIAL PRIMARY KEY,
+				actor VARCHAR(255) NOT NULL,
+				action VARCHAR(100) NOT NULL,
+				target VARCHAR(255) NOT NULL,
+				before_value TEXT,
+				after_value TEXT,
+				remote_addr VARCHAR(100),
+				created_at TIMESTAMPTZ NOT NULL
+				)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_actor ON admin_audit_log (actor)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_action ON admin_audit_log (action)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_created_at ON admin_audit_log (created_at)`,
+	}
+}
+
+func (PostgresDialect) CurrentSchema() string {
+	return "current_schema()"
+}
+
+func (PostgresDialect) ColumnMigrations() []ColumnMigration {
+	return []ColumnMigration{
+		{Table: "outbox", Column: "next_attempt_at", Definition: "TIMESTAMPTZ DEFAULT NULL"},
+		{Table: "outbox", Column: "dead_at", Definition: "TIMESTAMPTZ DEFAULT NULL"},
+	}
+}
+
+func (PostgresDialect) HourBucket(column string) string {
+	return "to_char(date_trunc('hour', " + column + "), 'YYYY-MM-DD\"T\"HH24:00:00')"
+}
+
+// Rebind converts '?' placeholders to PostgreSQL's positional $n form
+func (PostgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (PostgresDialect) UpsertPaymentQuery(table string) string {
+	return `INSERT INTO ` + table + ` (payment_id, order_id, amount, currency, user_id, merchant_id, provider, status, latency_ms, error_code, error_message, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT (payment_id) DO UPDATE SET provider = EXCLUDED.provider, status = EXCLUDED.status, latency_ms = EXCLUDED.latency_ms,
+			  error_code = EXCLUDED.error_code, error_message = EXCLUDED.error_message, updated_at = EXCLUDED.updated_at`
+}
+
+// InsertUser uses RETURNING since lib/pq does not support LastInsertId
+func (PostgresDialect) InsertUser(db *sql.DB, name, passwordHash string) (int64, error) {
+	var id int64
+	err := db.QueryRow("INSERT INTO users (name, password) VALUES ($1, $2) RETURNING id", name, passwordHash).Scan(&id)
+	return id, err
+}
+
+// IsUniqueViolation matches Postgres' unique_violation error
+func (PostgresDialect) IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Subscription states
+const (
+	SubscriptionActive    = "ACTIVE"
+	SubscriptionPastDue   = "PAST_DUE"
+	SubscriptionUnpaid    = "UNPAID"
+	SubscriptionCancelled = "CANCELLED"
+)
+
+// dunningSchedule is the delay before each retry of a failed cycle; once exhausted
+// the subscription is marked UNPAID and no further cycles run
+var dunningSchedule = []time.Duration{
+	1 * time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// subscriptionLease is how long a claimed subscription stays hidden from other schedulers
+const subscriptionLease = 5 * time.Minute
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// Subscription is a recurring payment plan
+type Subscription struct {
+	ID                string    `json:"id"`
+	MerchantID        string    `json:"merchant_id"`
+	UserID            string    `json:"user_id,omitempty"`
+	Amount            int64     `json:"amount"`
+	Currency          string    `json:"currency"`
+	Interval          string    `json:"interval"`
+	Status            string    `json:"status"`
+	Cycle             int       `json:"cycle"`
+	FailedAttempts    int       `json:"failed_attempts"`
+	NextRunAt         time.Time `json:"next_run_at"`
+	LastPaymentID     string    `json:"last_payment_id,omitempty"`
+	LastPaymentStatus string    `json:"last_payment_status,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// SubscriptionStore persists subscriptions
+type SubscriptionStore interface {
+	CreateSubscription(sub *Subscription) error
+	GetSubscription(id string) (*Subscription, error)
+	ListSubscriptions(merchantID, status string) ([]Subscription, error)
+	UpdateSubscription(sub *Subscription) error
+	// ClaimDueSubscriptions leases up to limit active or past-due subscriptions due at now
+	// by pushing their next_run_at forward, so concurrent schedulers skip them
+	ClaimDueSubscriptions(now time.Time, lease time.Duration, limit int) ([]Subscription, error)
+}
+
+// nextInterval advances t by a subscription interval: daily, weekly, monthly,
+// yearly, or any Go duration of at least one minute
+func nextInterval(t time.Time, interval string) (time.Time, error) {
+	switch interval {
+	case "daily":
+		return t.AddDate(0, 0, 1), nil
+	case "weekly":
+		return t.AddDate(0, 0, 7), nil
+	case "monthly":
+		return t.AddDate(0, 1, 0), nil
+	case "yearly":
+		return t.AddDate(1, 0, 0), nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < time.Minute {
+		return t, fmt.Errorf("invalid interval %q", interval)
+	}
+	return t.Add(d), nil
+}
+
+// SubscriptionScheduler submits due subscription cycles through the normal payment path
+type SubscriptionScheduler struct {
+	store     SubscriptionStore
+	interval  time.Duration
+	batchSize int
+	stopChan  chan bool
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewSubscriptionScheduler creates a scheduler polling the store at the given interval
+func NewSubscriptionScheduler(store SubscriptionStore, interval time.Duration, batchSize int) *SubscriptionScheduler {
+	return &SubscriptionScheduler{
+		store:     store,
+		interval:  interval,
+		batchSize: batchSize,
+		stopChan:  make(chan bool),
+	}
+}
+
+// Start launches the scheduler goroutine
+func (ss *SubscriptionScheduler) Start() {
+	ss.mu.Lock()
+	if ss.isRunning {
+		ss.mu.Unlock()
+		return
+	}
+	ss.isRunning = true
+	ss.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ss.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ss.runDue()
+			case <-ss.stopChan:
+				log.Println("[Subscriptions] Scheduler stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the scheduler goroutine
+func (ss *SubscriptionScheduler) Stop() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.isRunning {
+		ss.stopChan <- true
+		ss.isRunning = false
+	}
+}
+
+func (ss *SubscriptionScheduler) runDue() {
+	subs, err := ss.store.ClaimDueSubscriptions(time.Now().UTC(), subscriptionLease, ss.batchSize)
+	if err != nil {
+		log.Printf("[Subscriptions] Failed to claim due subscriptions: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range subs {
+		wg.Add(1)
+		go func(sub *Subscription) {
+			defer wg.Done()
+			ss.runCycle(sub)
+		}(&subs[i])
+	}
+	wg.Wait()
+}
+
+// runCycle charges the subscription's current cycle and schedules the next run.
+// A retried cycle reuses its payment key, so the same payment ID is reprocessed
+func (ss *SubscriptionScheduler) runCycle(sub *Subscription) {
+	orderID := fmt.Sprintf("%s_cycle_%d", sub.ID, sub.Cycle)
+	correlationID := generateCorrelationID()
+
+	paymentID, err := issuePaymentKey(paymentKeyFields{
+		MerchantID: sub.MerchantID,
+		OrderID:    orderID,
+		Amount:     int(sub.Amount),
+		Currency:   sub.Currency,
+		UserID:     sub.UserID,
+	})
+	if err != nil {
+		log.Printf("[Subscriptions] Failed to issue payment key for %s: %v", orderID, err)
+		return
+	}
+
+	appLogger.Info("Charging subscription cycle", map[string]interface{}{
+		"correlation_id":  correlationID,
+		"subscription_id": sub.ID,
+		"cycle":           sub.Cycle,
+		"payment_id":      paymentID,
+		"attempt":         sub.FailedAttempts + 1,
+	})
+
+	lock, err := startPayment(orderID, int(sub.Amount), paymentID, sub.Currency, sub.UserID, sub.MerchantID, correlationID, true)
+	if err != nil {
+		log.Printf("[Subscriptions] Not charging %s, its lease will expire and it will be retried: %v", orderID, err)
+		return
+	}
+	processPaymentAsync(&PaymentRequest{
+		ID:            orderID,
+		Amount:        sub.Amount,
+		Currency:      sub.Currency,
+		UserID:        sub.UserID,
+		Providers:     merchantProviderPreferences(sub.MerchantID),
+		DeclinePolicy: merchantDeclinePolicy(sub.MerchantID),
+	}, paymentID, correlationID, nil, lock)
+
+	state := GetState(paymentID)
+	now := time.Now().UTC()
+	sub.LastPaymentID = paymentID
+	sub.LastPaymentStatus = state.String()
+	sub.UpdatedAt = now
+
+	if state == SUCCESS {
+		next, err := nextInterval(sub.NextRunAt, sub.Interval)
+		if err != nil {
+			next, _ = nextInterval(now, "daily")
+		}
+		// Don't replay cycles missed while the scheduler was down
+		for !next.After(now) {
+			next, _ = nextInterval(next, sub.Interval)
+		}
+		sub.Status = SubscriptionActive
+		sub.Cycle++
+		sub.FailedAttempts = 0
+		sub.NextRunAt = next
+	} else if sub.FailedAttempts < len(dunningSchedule) {
+		sub.Status = SubscriptionPastDue
+		sub.NextRunAt = now.Add(dunningSchedule[sub.FailedAttempts])
+		sub.FailedAttempts++
+	} else {
+		sub.Status = SubscriptionUnpaid
+		sub.FailedAttempts++
+	}
+
+	if err := ss.store.UpdateSubscription(sub); err != nil {
+		log.Printf("[Subscriptions] Failed to update %s: %v", sub.ID, err)
+		return
+	}
+	log.Printf("[Subscriptions] %s cycle %d: payment %s %s, status %s", sub.ID, sub.Cycle, paymentID, state, sub.Status)
+}
+
+func writeSubscriptionJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// SubscriptionsHandler handles POST /subscriptions and GET /subscriptions?status=,
+// listing the caller's subscriptions
+func SubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Subscriptions not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Amount   int64     `json:"amount"`
+			Currency string    `json:"currency"`
+			Interval string    `json:"interval"`
+			UserID   string    `json:"user_id"`
+			StartAt  time.Time `json:"start_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Amount <= 0 {
+			http.Error(w, "amount must be positive", http.StatusBadRequest)
+			return
+		}
+		if req.Currency == "" {
+			req.Currency = "USD"
+		}
+		req.Currency = strings.ToUpper(req.Currency)
+		// Checked once here, so a bad plan is refused instead of failing every cycle
+		if err := validateAmount(req.Amount, req.Currency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := nextInterval(time.Now(), req.Interval); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().UTC()
+		if req.StartAt.IsZero() || req.StartAt.Before(now) {
+			req.StartAt = now
+		}
+
+		sub := &Subscription{
+			ID:         "sub_" + uuid.NewString(),
+			MerchantID: merchantIDFromContext(r.Context()),
+			UserID:     req.UserID,
+			Amount:     req.Amount,
+			Currency:   req.Currency,
+			Interval:   req.Interval,
+			Status:     SubscriptionActive,
+			Cycle:      1,
+			NextRunAt:  req.StartAt.UTC(),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if err := dataStore.CreateSubscription(sub); err != nil {
+			http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+			return
+		}
+
+		writeSubscriptionJSON(w, http.StatusCreated, sub)
+
+	case http.MethodGet:
+		subs, err := dataStore.ListSubscriptions(merchantIDFromContext(r.Context()), strings.ToUpper(r.URL.Query().Get("status")))
+		if err != nil {
+			http.Error(w, "Failed to fetch subscriptions", http.StatusInternalServerError)
+			return
+		}
+		writeSubscriptionJSON(w, http.StatusOK, map[string]interface{}{
+			"subscriptions": subs,
+			"total":         len(subs),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SubscriptionHandler handles GET and DELETE (cancel) on /subscriptions/{subscription_id}.
+// Other merchants' subscriptions are not found
+func SubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	if dataStore == nil {
+		http.Error(w, "Subscriptions not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	sub, err := dataStore.GetSubscription(r.PathValue("subscription_id"))
+	if err == nil && sub.MerchantID != merchantIDFromContext(r.Context()) {
+		err = ErrSubscriptionNotFound
+	}
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch subscription", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeSubscriptionJSON(w, http.StatusOK, sub)
+
+	case http.MethodDelete:
+		if sub.Status == SubscriptionCancelled {
+			writeSubscriptionJSON(w, http.StatusOK, sub)
+			return
+		}
+		sub.Status = SubscriptionCancelled
+		sub.UpdatedAt = time.Now().UTC()
+		if err := dataStore.UpdateSubscription(sub); err != nil {
+			http.Error(w, "Failed to cancel subscription", http.StatusInternalServerError)
+			return
+		}
+		writeSubscriptionJSON(w, http.StatusOK, sub)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SystemSampler measures host CPU utilization from /proc/stat. CPU usage is the
+// share of non-idle time between two samples, so the first sample only primes it
+type SystemSampler struct {
+	prevIdle  uint64
+	prevTotal uint64
+	primed    bool
+}
+
+// NewSystemSampler creates a sampler and takes its first reading
+func NewSystemSampler() *SystemSampler {
+	s := &SystemSampler{}
+	s.CPUUsage()
+	return s
+}
+
+// CPUUsage returns CPU utilization (0.0 to 1.0) since the previous call. It reports
+// false when /proc/stat can't be read or there is no previous sample yet
+func (s *SystemSampler) CPUUsage() (float64, bool) {
+	idle, total, err := readCPUTimes()
+	if err != nil {
+		return 0, false
+	}
+
+	prevIdle, prevTotal, primed := s.prevIdle, s.prevTotal, s.primed
+	s.prevIdle, s.prevTotal, s.primed = idle, total, true
+
+	if !primed || total <= prevTotal {
+		return 0, false
+	}
+	idleDelta := float64(idle - prevIdle)
+	totalDelta := float64(total - prevTotal)
+	return 1 - idleDelta/totalDelta, true
+}
+
+// readCPUTimes returns idle and total jiffies from the aggregate cpu line of /proc/stat
+func readCPUTimes() (idle, total uint64, err error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal ...
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			// guest time is already counted in user and nice
+			if i >= 8 {
+				break
+			}
+			total += value
+			if i == 3 || i == 4 {
+				idle += value
+			}
+		}
+		return idle, total, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("no cpu line in /proc/stat")
+}
+
+// readMemoryUsage returns the share of memory in use (0.0 to 1.0) from /proc/meminfo,
+// counting reclaimable page cache as available
+func readMemoryUsage() (float64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	var memTotal, memAvailable uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			memTotal, _ = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			memAvailable, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	if memTotal == 0 || memAvailable > memTotal {
+		return 0, false
+	}
+	return 1 - float64(memAvailable)/float64(memTotal), true
+}
+
+// runtimeMetrics reports the process's own resource use for /metrics, so soak tests
+// can spot goroutines, memory or Redis keys that keep growing. Reading the memory
+// stats briefly stops the world, which is fine at scrape rates
+func runtimeMetrics() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	metrics := map[string]interface{}{
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_alloc_bytes": mem.HeapAlloc,
+		"heap_objects":     mem.HeapObjects,
+		"sys_bytes":        mem.Sys,
+		"num_gc":           mem.NumGC,
+	}
+	if rdb != nil {
+		redisCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if keys, err := rdb.DBSize(redisCtx).Result(); err == nil {
+			metrics["redis_keys"] = keys
+		}
+	}
+	return metrics
+}
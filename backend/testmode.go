@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Test-mode payments carry this ID prefix, which is what keeps their records apart
+const testPaymentPrefix = "pay_test_"
+
+// testRedisNamespace prefixes the Redis keys of test-mode payments
+const testRedisNamespace = "test:"
+
+// testModeEnabled reports whether the request was made with a test-mode API key
+func testModeEnabled(ctx context.Context) bool {
+	apiKey, ok := ctx.Value("api_key").(string)
+	if !ok || apiKey == "" || apiKeyStore == nil {
+		return false
+	}
+	key, err := apiKeyStore.GetKey(apiKey)
+	if err != nil {
+		return false
+	}
+	return key.TestMode
+}
+
+// withTestMode marks a context as processing a test-mode payment, so gateway calls
+// made under it stay out of the pool's metrics
+func withTestMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, "test_mode", true)
+}
+
+func isTestModeContext(ctx context.Context) bool {
+	testMode, _ := ctx.Value("test_mode").(bool)
+	return testMode
+}
+
+// isTestPayment reports whether a payment ID was issued to a test-mode API key
+func isTestPayment(paymentID string) bool {
+	return strings.HasPrefix(paymentID, testPaymentPrefix)
+}
+
+// paymentNamespace returns the Redis key prefix for a payment's data: empty for live
+// payments, testRedisNamespace for test-mode ones
+func paymentNamespace(paymentID string) string {
+	if isTestPayment(paymentID) {
+		return testRedisNamespace
+	}
+	return ""
+}
+
+// requestNamespace returns the Redis key prefix for data keyed by the caller rather than
+// by a payment: testRedisNamespace for test-mode API keys, empty otherwise
+func requestNamespace(ctx context.Context) string {
+	if testModeEnabled(ctx) {
+		return testRedisNamespace
+	}
+	return ""
+}
+
+// sandboxGateways are the URL prefixes of simulator gateways, the only ones test-mode
+// payments are routed to
+var sandboxGateways = []string{"http://localhost:3001/"}
+
+// SandboxGatewaysFromEnv reads SANDBOX_GATEWAYS, a comma separated list of simulator
+// URL prefixes, keeping the default when unset
+func SandboxGatewaysFromEnv() ([]string, error) {
+	v := os.Getenv("SANDBOX_GATEWAYS")
+	if v == "" {
+		return sandboxGateways, nil
+	}
+	prefixes := make([]string, 0)
+	for _, prefix := range strings.Split(v, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if u, err := url.Parse(prefix); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("SANDBOX_GATEWAYS entries must be absolute URLs, got %q", prefix)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// isSandboxGateway reports whether a gateway URL belongs to the simulator
+func isSandboxGateway(gatewayURL string) bool {
+	for _, prefix := range sandboxGateways {
+		if strings.HasPrefix(gatewayURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxCandidates returns the healthy simulator gateways for a test-mode payment by
+// score. Provider selection is skipped so test traffic doesn't touch routing state
+func sandboxCandidates(req *PaymentRequest) []*ServerMetrics {
+	candidates := make([]*ServerMetrics, 0)
+	for _, server := range serverPool.GetServersForSelection(req.Currency, req.Region) {
+		if isSandboxGateway(server.ServerURL) && server.IsHealthy() {
+			candidates = append(candidates, server)
+		}
+	}
+	return candidates
+}
@@ -3,9 +3,16 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"strings"
 )
 
 func SHA256Hash(data string) string {
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
+
+// gatewayName extracts the gateway name (last path segment) from a server URL
+func gatewayName(serverURL string) string {
+	parts := strings.Split(strings.TrimRight(serverURL, "/"), "/")
+	return parts[len(parts)-1]
+}
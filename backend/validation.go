@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"pulseberry/money"
+)
+
+// FieldError describes one field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field that failed validation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	messages := make([]string, len(ve.Fields))
+	for i, field := range ve.Fields {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// decodeStrict decodes a single JSON object into v, rejecting unknown fields. Unknown
+// fields and mistyped values are reported as a *ValidationError
+func decodeStrict(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &ValidationError{Fields: []FieldError{{
+				Field:   typeErr.Field,
+				Rule:    "type",
+				Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+			}}}
+		}
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			name = strings.Trim(name, `"`)
+			return &ValidationError{Fields: []FieldError{{
+				Field:   name,
+				Rule:    "unknown",
+				Message: fmt.Sprintf("%s is not a known field", name),
+			}}}
+		}
+		return err
+	}
+	if decoder.More() {
+		return errors.New("request body must contain a single JSON object")
+	}
+	return nil
+}
+
+// jsonTypeName describes a Go type the way a JSON client sees it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// validateStruct checks a struct's fields against their validate tags and returns a
+// *ValidationError listing every failure, or nil. Supported rules are required,
+// omitempty, gt, gte, lt, lte, len, min, max, oneof and email
+func validateStruct(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []FieldError
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ","); jsonName != "" && jsonName != "-" {
+			name = jsonName
+		}
+		if fe := validateField(name, value.Field(i), tag); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// validateField checks one field against its rules and reports the first failure
+func validateField(name string, field reflect.Value, tag string) *FieldError {
+	fail := func(rule, format string, args ...interface{}) *FieldError {
+		return &FieldError{Field: name, Rule: rule, Message: name + " " + fmt.Sprintf(format, args...)}
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule, param, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "omitempty":
+			if field.IsZero() {
+				return nil
+			}
+		case "required":
+			if field.IsZero() {
+				return fail(rule, "is required")
+			}
+		case "email":
+			if _, err := mail.ParseAddress(field.String()); err != nil {
+				return fail(rule, "must be a valid email address")
+			}
+		case "oneof":
+			if !slices.Contains(strings.Fields(param), fmt.Sprint(field.Interface())) {
+				return fail(rule, "must be one of %s", strings.Join(strings.Fields(param), ", "))
+			}
+		case "len":
+			n, _ := strconv.ParseFloat(param, 64)
+			if fieldSize(field) != n {
+				return fail(rule, "must have length %s", param)
+			}
+		case "gt", "gte", "lt", "lte", "min", "max":
+			n, _ := strconv.ParseFloat(param, 64)
+			if !compareSize(rule, fieldSize(field), n) {
+				return fail(rule, "must be %s %s", ruleDescriptions[rule], param)
+			}
+		}
+	}
+	return nil
+}
+
+var ruleDescriptions = map[string]string{
+	"gt":  "greater than",
+	"gte": "at least",
+	"min": "at least",
+	"lt":  "less than",
+	"lte": "at most",
+	"max": "at most",
+}
+
+// compareSize applies a comparison rule to a field's size
+func compareSize(rule string, size, n float64) bool {
+	switch rule {
+	case "gt":
+		return size > n
+	case "gte", "min":
+		return size >= n
+	case "lt":
+		return size < n
+	default: // lte, max
+		return size <= n
+	}
+}
+
+// fieldSize is a number's value, or the length of a string, slice or map
+func fieldSize(field reflect.Value) float64 {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(field.Uint())
+	case reflect.Float32, reflect.Float64:
+		return field.Float()
+	case reflect.String:
+		return float64(len([]rune(field.String())))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(field.Len())
+	}
+	return 0
+}
+
+// writeRequestError responds 400 with an ErrorResponse, listing field-level details
+// when err is a *ValidationError
+func writeRequestError(w http.ResponseWriter, status string, err error) {
+	resp := NewErrorResponse(ErrInvalidRequest, "Invalid request", status, err.Error())
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		resp.Message = "Request validation failed"
+		resp.Fields = ve.Fields
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validateAmount checks a payment amount in minor units against its ISO 4217 currency,
+// so that e.g. a 1-yen or fractional-forint payment is rejected before it is charged
+func validateAmount(amount int64, currency string) error {
+	err := money.Validate(amount, currency)
+	if err == nil {
+		return nil
+	}
+	field, rule := "amount", "money"
+	switch {
+	case errors.Is(err, money.ErrUnknownCurrency):
+		field, rule = "currency", "iso4217"
+	case errors.Is(err, money.ErrAmountTooSmall):
+		rule = "min_amount"
+	case errors.Is(err, money.ErrAmountTooLarge):
+		rule = "max_amount"
+	case errors.Is(err, money.ErrInvalidIncrement):
+		rule = "minor_unit"
+	}
+	return &ValidationError{Fields: []FieldError{{Field: field, Rule: rule, Message: err.Error()}}}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenTTL = 365 * 24 * time.Hour
+	maxTokenTTL     = 5 * 365 * 24 * time.Hour
+	// vaultRewrapBatch is how many tokens are re-wrapped per store round trip during KEK rotation
+	vaultRewrapBatch = 100
+)
+
+var (
+	ErrTokenNotFound = errors.New("payment token not found")
+	ErrTokenExpired  = errors.New("payment token expired")
+	ErrTokenScope    = errors.New("payment token belongs to another merchant")
+	ErrUnknownKEK    = errors.New("unknown key encryption key")
+)
+
+// CardData is the sensitive payload kept inside the vault. CVC is never stored
+type CardData struct {
+	Number         string `json:"number"`
+	ExpMonth       int    `json:"exp_month"`
+	ExpYear        int    `json:"exp_year"`
+	CardholderName string `json:"cardholder_name,omitempty"`
+}
+
+// VaultToken is a stored token. Card data is encrypted with a per-token data key
+// (DEK), and the DEK is wrapped by a key encryption key (KEK) identified by KEKID
+type VaultToken struct {
+	Token      string    `json:"token"`
+	MerchantID string    `json:"merchant_id"`
+	KEKID      string    `json:"-"`
+	WrappedDEK []byte    `json:"-"`
+	Ciphertext []byte    `json:"-"`
+	BIN        string    `json:"bin"`
+	Last4      string    `json:"last4"`
+	Brand      string    `json:"brand"`
+	ExpMonth   int       `json:"exp_month"`
+	ExpYear    int       `json:"exp_year"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// VaultStore persists encrypted tokens
+type VaultStore interface {
+	SaveVaultToken(t *VaultToken) error
+	GetVaultToken(token string) (*VaultToken, error)
+	// ListVaultTokensNotUnder returns up to limit tokens whose DEK is wrapped by a KEK other than kekID
+	ListVaultTokensNotUnder(kekID string, limit int) ([]VaultToken, error)
+	UpdateVaultTokenKey(token, kekID string, wrappedDEK []byte) error
+}
+
+// Vault tokenizes card data using envelope encryption with rotating KEKs
+type Vault struct {
+	store     VaultStore
+	keks      map[string][]byte
+	activeKEK string
+	mu        sync.RWMutex
+}
+
+var vault *Vault
+
+// InitVault initializes the global vault from VAULT_KEKS and VAULT_ACTIVE_KEK
+func InitVault(store VaultStore) error {
+	keks, active, err := loadKEKs(os.Getenv("VAULT_KEKS"), os.Getenv("VAULT_ACTIVE_KEK"))
+	if err != nil {
+		return err
+	}
+	vault = &Vault{
+		store:     store,
+		keks:      keks,
+		activeKEK: active,
+	}
+	return nil
+}
+
+// GetVault returns the global vault, or nil when no database is configured
+func GetVault() *Vault {
+	return vault
+}
+
+// loadKEKs parses "id:base64key,id:base64key". Without configuration an ephemeral
+// key is generated, so tokens don't survive a restart
+func loadKEKs(spec, active string) (map[string][]byte, string, error) {
+	keks := make(map[string][]byte)
+	last := ""
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, "", fmt.Errorf("invalid VAULT_KEKS entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, "", fmt.Errorf("KEK %s must be 32 bytes, base64 encoded", parts[0])
+		}
+		keks[parts[0]] = key
+		last = parts[0]
+	}
+
+	if len(keks) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, "", err
+		}
+		log.Println("[Vault] Warning: VAULT_KEKS not set, using an ephemeral key")
+		keks["ephemeral"] = key
+		last = "ephemeral"
+	}
+
+	if active == "" {
+		active = last
+	}
+	if _, ok := keks[active]; !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownKEK, active)
+	}
+	return keks, active, nil
+}
+
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// luhnValid reports whether a card number passes the Luhn checksum
+func luhnValid(number string) bool {
+	if len(number) < 12 || len(number) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// cardBrand guesses the card network from the leading digits
+func cardBrand(number string) string {
+	switch {
+	case strings.HasPrefix(number, "4"):
+		return "visa"
+	case len(number) >= 2 && number[0] == '5' && number[1] >= '1' && number[1] <= '5',
+		strings.HasPrefix(number, "2"):
+		return "mastercard"
+	case strings.HasPrefix(number, "34"), strings.HasPrefix(number, "37"):
+		return "amex"
+	case strings.HasPrefix(number, "6"):
+		return "discover"
+	default:
+		return "unknown"
+	}
+}
+
+// Tokenize encrypts card data and returns a merchant-scoped token
+func (v *Vault) Tokenize(merchantID string, card CardData, ttl time.Duration) (*VaultToken, error) {
+	plaintext, err := json.Marshal(card)
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	ciphertext, err := gcmSeal(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	kekID := v.activeKEK
+	wrapped, err := gcmSeal(v.keks[kekID], dek)
+	v.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	t := &VaultToken{
+		Token:      "tok_" + hex.EncodeToString(id),
+		MerchantID: merchantID,
+		KEKID:      kekID,
+		WrappedDEK: wrapped,
+		Ciphertext: ciphertext,
+		BIN:        card.Number[:6],
+		Last4:      card.Number[len(card.Number)-4:],
+		Brand:      cardBrand(card.Number),
+		ExpMonth:   card.ExpMonth,
+		ExpYear:    card.ExpYear,
+		ExpiresAt:  now.Add(ttl),
+		CreatedAt:  now,
+	}
+	if err := v.store.SaveVaultToken(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Lookup returns a token's metadata after checking merchant scope and expiry
+func (v *Vault) Lookup(merchantID, token string) (*VaultToken, error) {
+	t, err := v.store.GetVaultToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if t.MerchantID != merchantID {
+		return nil, ErrTokenScope
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return t, nil
+}
+
+// Detokenize decrypts the card data behind a token for the merchant that owns it
+func (v *Vault) Detokenize(merchantID, token string) (*CardData, error) {
+	t, err := v.Lookup(merchantID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	kek, ok := v.keks[t.KEKID]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKEK, t.KEKID)
+	}
+
+	dek, err := gcmOpen(kek, t.WrappedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	plaintext, err := gcmOpen(dek, t.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt card data: %v", err)
+	}
+
+	var card CardData
+	if err := json.Unmarshal(plaintext, &card); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// Rotate makes kekID the active KEK and re-wraps every data key under it.
+// Card ciphertext is untouched since only the DEKs are wrapped by the KEK
+func (v *Vault) Rotate(kekID string) (int, error) {
+	v.mu.Lock()
+	kek, ok := v.keks[kekID]
+	if !ok {
+		v.mu.Unlock()
+		return 0, fmt.Errorf("%w: %s", ErrUnknownKEK, kekID)
+	}
+	v.activeKEK = kekID
+	v.mu.Unlock()
+
+	rewrapped := 0
+	for {
+		tokens, err := v.store.ListVaultTokensNotUnder(kekID, vaultRewrapBatch)
+		if err != nil {
+			return rewrapped, err
+		}
+		if len(tokens) == 0 {
+			return rewrapped, nil
+		}
+
+		for _, t := range tokens {
+			v.mu.RLock()
+			oldKEK, ok := v.keks[t.KEKID]
+			v.mu.RUnlock()
+			if !ok {
+				return rewrapped, fmt.Errorf("%w: %s (token %s)", ErrUnknownKEK, t.KEKID, t.Token)
+			}
+
+			dek, err := gcmOpen(oldKEK, t.WrappedDEK)
+			if err != nil {
+				return rewrapped, fmt.Errorf("failed to unwrap data key for %s: %v", t.Token, err)
+			}
+			wrapped, err := gcmSeal(kek, dek)
+			if err != nil {
+				return rewrapped, err
+			}
+			if err := v.store.UpdateVaultTokenKey(t.Token, kekID, wrapped); err != nil {
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+	}
+}
+
+// ActiveKEK returns the ID of the KEK used for new tokens
+func (v *Vault) ActiveKEK() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.activeKEK
+}
+
+// vaultErrorStatus maps vault errors to HTTP status codes
+func vaultErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTokenNotFound), errors.Is(err, ErrTokenScope):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTokenExpired):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// TokensHandler handles POST /tokens, converting card data into a vault token
+func TokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := GetVault()
+	if v == nil {
+		http.Error(w, "Vault not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		CardNumber     string `json:"card_number"`
+		ExpMonth       int    `json:"exp_month"`
+		ExpYear        int    `json:"exp_year"`
+		CardholderName string `json:"cardholder_name"`
+		TTLSeconds     int64  `json:"ttl_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	number := strings.NewReplacer(" ", "", "-", "").Replace(req.CardNumber)
+	if !luhnValid(number) {
+		http.Error(w, "invalid card_number", http.StatusBadRequest)
+		return
+	}
+	if req.ExpMonth < 1 || req.ExpMonth > 12 {
+		http.Error(w, "invalid exp_month", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	// A card is valid through the last day of its expiry month
+	if time.Date(req.ExpYear, time.Month(req.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC).Before(now) {
+		http.Error(w, "card is expired", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultTokenTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl > maxTokenTTL {
+			ttl = maxTokenTTL
+		}
+	}
+
+	t, err := v.Tokenize(merchantIDFromContext(r.Context()), CardData{
+		Number:         number,
+		ExpMonth:       req.ExpMonth,
+		ExpYear:        req.ExpYear,
+		CardholderName: req.CardholderName,
+	}, ttl)
+	if err != nil {
+		log.Printf("[Vault] Tokenization failed: %v", err)
+		http.Error(w, "Failed to tokenize card", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// TokenHandler handles GET /tokens/{token}, returning non-sensitive token metadata
+func TokenHandler(w http.ResponseWriter, r *http.Request) {
+	v := GetVault()
+	if v == nil {
+		http.Error(w, "Vault not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	t, err := v.Lookup(merchantIDFromContext(r.Context()), r.PathValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), vaultErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// AdminVaultRotateHandler activates a KEK and re-wraps existing tokens under it
+func AdminVaultRotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v := GetVault()
+	if v == nil {
+		http.Error(w, "Vault not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	kekID := r.URL.Query().Get("kek_id")
+	if kekID == "" {
+		http.Error(w, "kek_id required", http.StatusBadRequest)
+		return
+	}
+
+	previous := v.ActiveKEK()
+	rewrapped, err := v.Rotate(kekID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUnknownKEK) && rewrapped == 0 {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("[Vault] Rotated to KEK %s, re-wrapped %d tokens", kekID, rewrapped)
+	recordAudit(r, "rotate_vault_kek", "vault", map[string]interface{}{"active_kek": previous},
+		map[string]interface{}{"active_kek": kekID, "rewrapped": rewrapped})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"active_kek": kekID,
+		"rewrapped":  rewrapped,
+	})
+}
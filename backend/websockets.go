@@ -8,7 +8,20 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// paymentEventsChannel is the Redis pub/sub channel used to fan payment
+// results out to every backend instance
+const paymentEventsChannel = "payment_events"
+
+const (
+	wsWriteWait      = 10 * time.Second      // Time allowed to write a message to a client
+	wsPongWait       = 60 * time.Second      // Time allowed to read the next pong from a client
+	wsPingPeriod     = (wsPongWait * 9) / 10 // Ping interval, must be shorter than wsPongWait
+	wsSendBufferSize = 16                    // Pending messages per client before it is evicted
 )
 
 var upgrader = websocket.Upgrader{
@@ -17,14 +30,61 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// wsClient is a single subscribed connection with its own outbound buffer
+type wsClient struct {
+	paymentID string
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
 type WSManager struct {
-	clients map[string][]*websocket.Conn
-	mu      sync.RWMutex
+	clients    map[string][]*wsClient
+	mu         sync.RWMutex
+	rdb        *redis.Client
+	instanceID string
+	pubsub     *redis.PubSub
+}
+
+// paymentEvent is the envelope published on paymentEventsChannel
+type paymentEvent struct {
+	PaymentID string          `json:"payment_id"`
+	Origin    string          `json:"origin"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 func NewWSManager() *WSManager {
 	return &WSManager{
-		clients: make(map[string][]*websocket.Conn),
+		clients:    make(map[string][]*wsClient),
+		instanceID: uuid.NewString(),
+	}
+}
+
+// EnableRedisFanout subscribes to the shared payment events channel so that
+// results published by any instance reach clients connected to this one
+func (m *WSManager) EnableRedisFanout(ctx context.Context, rdb *redis.Client) {
+	m.rdb = rdb
+	m.pubsub = rdb.Subscribe(ctx, paymentEventsChannel)
+
+	go func() {
+		for msg := range m.pubsub.Channel() {
+			var event paymentEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("[WSManager] Dropping malformed payment event: %v", err)
+				continue
+			}
+			m.deliver(event.PaymentID, event.Payload)
+		}
+	}()
+
+	log.Printf("[WSManager] Redis fan-out enabled (instance: %s, channel: %s)", m.instanceID, paymentEventsChannel)
+}
+
+// Close stops the Redis subscription
+func (m *WSManager) Close() {
+	if m.pubsub != nil {
+		m.pubsub.Close()
 	}
 }
 
@@ -41,67 +101,156 @@ func (m *WSManager) HandleWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	client := &wsClient{
+		paymentID: paymentID,
+		conn:      conn,
+		send:      make(chan []byte, wsSendBufferSize),
+		done:      make(chan struct{}),
+	}
+
 	rCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	if cached, err := rdb.Get(rCtx, "payment_result:"+paymentID).Result(); err == nil && cached != "" {
-		var result interface{}
-		if err := json.Unmarshal([]byte(cached), &result); err == nil {
-			conn.WriteJSON(result)
-			log.Printf("Pushed cached result to new WS client for: %s", paymentID)
-		}
+	if cached, err := rdb.Get(rCtx, paymentResultKey(paymentID)).Result(); err == nil && cached != "" {
+		client.send <- []byte(cached)
+		log.Printf("Pushed cached result to new WS client for: %s", paymentID)
 	}
 
 	m.mu.Lock()
-	m.clients[paymentID] = append(m.clients[paymentID], conn)
+	m.clients[paymentID] = append(m.clients[paymentID], client)
 	m.mu.Unlock()
 
 	log.Printf("New WebSocket client subscribed to payment: %s", paymentID)
 
-	go func() {
-		defer func() {
-			m.mu.Lock()
-			conns := m.clients[paymentID]
-			for i, c := range conns {
-				if c == conn {
-					m.clients[paymentID] = append(conns[:i], conns[i+1:]...)
-					break
-				}
+	go m.writePump(client)
+	go m.readPump(client)
+}
+
+// readPump consumes client frames so pong and close messages are processed,
+// and evicts the client once it stops answering pings
+func (m *WSManager) readPump(client *wsClient) {
+	defer m.evict(client)
+
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		if _, _, err := client.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// writePump drains the client's send buffer and pings it periodically.
+// Every write is bounded by wsWriteWait so a stalled client cannot block others
+func (m *WSManager) writePump(client *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		m.evict(client)
+	}()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case msg := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := client.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("Failed to send WebSocket message: %v", err)
+				return
 			}
-			if len(m.clients[paymentID]) == 0 {
-				delete(m.clients, paymentID)
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
 			}
-			m.mu.Unlock()
-			conn.Close()
-		}()
+		}
+	}
+}
 
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
+// evict removes a client from the registry and closes its connection.
+// It is safe to call more than once
+func (m *WSManager) evict(client *wsClient) {
+	client.closeOnce.Do(func() {
+		m.mu.Lock()
+		conns := m.clients[client.paymentID]
+		for i, c := range conns {
+			if c == client {
+				m.clients[client.paymentID] = append(conns[:i], conns[i+1:]...)
 				break
 			}
 		}
-	}()
+		if len(m.clients[client.paymentID]) == 0 {
+			delete(m.clients, client.paymentID)
+		}
+		m.mu.Unlock()
+
+		close(client.done)
+		client.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(wsWriteWait))
+		client.conn.Close()
+	})
 }
 
-func (m *WSManager) Notify(paymentID string, result interface{}) {
+// ConnectionCount returns the number of WebSocket clients on this instance
+func (m *WSManager) ConnectionCount() int {
 	m.mu.RLock()
-	conns, exists := m.clients[paymentID]
-	m.mu.RUnlock()
+	defer m.mu.RUnlock()
 
-	if !exists {
-		return
+	count := 0
+	for _, conns := range m.clients {
+		count += len(conns)
 	}
+	return count
+}
 
+// Notify publishes a payment result to all instances. When Redis fan-out is
+// not enabled or publishing fails, the result is delivered to local clients only
+func (m *WSManager) Notify(paymentID string, result interface{}) {
 	msg, err := json.Marshal(result)
 	if err != nil {
 		log.Printf("Failed to marshal notification: %v", err)
 		return
 	}
 
-	for _, conn := range conns {
-		err := conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Printf("Failed to send WebSocket message: %v", err)
+	if m.rdb != nil {
+		event, err := json.Marshal(paymentEvent{
+			PaymentID: paymentID,
+			Origin:    m.instanceID,
+			Payload:   msg,
+		})
+		if err == nil {
+			pCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err = m.rdb.Publish(pCtx, paymentEventsChannel, event).Err()
+			cancel()
+			if err == nil {
+				return
+			}
+		}
+		log.Printf("[WSManager] Failed to publish payment event, delivering locally: %v", err)
+	}
+
+	m.deliver(paymentID, msg)
+}
+
+// deliver queues a message for the clients connected to this instance.
+// Clients whose send buffer is full are considered dead and evicted
+func (m *WSManager) deliver(paymentID string, msg []byte) {
+	m.mu.RLock()
+	conns := make([]*wsClient, len(m.clients[paymentID]))
+	copy(conns, m.clients[paymentID])
+	m.mu.RUnlock()
+
+	for _, client := range conns {
+		select {
+		case <-client.done:
+		case client.send <- msg:
+		default:
+			log.Printf("[WSManager] Evicting slow WebSocket client for payment: %s", paymentID)
+			go m.evict(client)
 		}
 	}
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ArrivalProfile is the request rate of an open-model run over time. Unlike the
+// closed-loop scenarios, requests are sent on schedule whether or not earlier ones
+// have returned, so a slow backend shows up as latency instead of as fewer requests
+type ArrivalProfile struct {
+	Profile string  `yaml:"profile"` // constant (default), ramp, step or spike
+	RPS     float64 `yaml:"rps"`     // The rate for constant; the starting rate for ramp and step; the base rate for spike
+	// ramp: linear from RPS to EndRPS over the run
+	EndRPS float64 `yaml:"end_rps"`
+	// step: RPS, then StepRPS more every StepEvery
+	StepRPS   float64       `yaml:"step_rps"`
+	StepEvery time.Duration `yaml:"step_every"`
+	// spike: SpikeRPS from SpikeAt for SpikeFor, RPS otherwise
+	SpikeRPS float64       `yaml:"spike_rps"`
+	SpikeAt  time.Duration `yaml:"spike_at"`
+	SpikeFor time.Duration `yaml:"spike_for"`
+	// MaxInFlight caps outstanding requests; arrivals beyond it are dropped and counted.
+	// 0 means no cap
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+func (p ArrivalProfile) Validate() error {
+	if p.RPS <= 0 {
+		return fmt.Errorf("rps must be positive")
+	}
+	if p.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must not be negative")
+	}
+	switch p.Profile {
+	case "", "constant":
+	case "ramp":
+		if p.EndRPS < 0 {
+			return fmt.Errorf("ramp needs end_rps of 0 or more")
+		}
+	case "step":
+		if p.StepEvery <= 0 {
+			return fmt.Errorf("step needs a positive step_every")
+		}
+	case "spike":
+		if p.SpikeRPS <= 0 || p.SpikeFor <= 0 {
+			return fmt.Errorf("spike needs a positive spike_rps and spike_for")
+		}
+	default:
+		return fmt.Errorf("unknown profile %q (want constant, ramp, step or spike)", p.Profile)
+	}
+	return nil
+}
+
+// Rate is the target requests per second at elapsed into a run of length total
+func (p ArrivalProfile) Rate(elapsed, total time.Duration) float64 {
+	switch p.Profile {
+	case "ramp":
+		progress := float64(elapsed) / float64(total)
+		return p.RPS + (p.EndRPS-p.RPS)*progress
+	case "step":
+		return p.RPS + p.StepRPS*float64(elapsed/p.StepEvery)
+	case "spike":
+		if elapsed >= p.SpikeAt && elapsed < p.SpikeAt+p.SpikeFor {
+			return p.SpikeRPS
+		}
+		return p.RPS
+	default:
+		return p.RPS
+	}
+}
+
+// arrivalIdle is how far the schedule moves on while the target rate is zero
+const arrivalIdle = 10 * time.Millisecond
+
+// openModelScenario sends payments on the arrival profile's schedule for the run's
+// duration. Each arrival's due time is fixed in advance from the rate alone; if the
+// scheduler falls behind it sends immediately to catch up, and latency is measured
+// from the due time so the backlog is not hidden (coordinated omission)
+func openModelScenario(config LoadTestConfig) *LoadTestStats {
+	profile := config.Arrival
+	fmt.Println("\n📈 Starting Test Scenario: OPEN MODEL")
+	fmt.Printf("   Profile: %s | Start rate: %.1f/s | Duration: %v | Max in flight: %d\n",
+		profileName(profile), profile.RPS, config.Duration, profile.MaxInFlight)
+
+	stats := config.stats()
+	var wg sync.WaitGroup
+	var inFlight int64
+	var maxLag time.Duration
+
+	startTime := time.Now()
+	end := startTime.Add(config.Duration)
+	due := startTime
+	for n := 1; due.Before(end); {
+		rate := profile.Rate(due.Sub(startTime), config.Duration)
+		if rate <= 0 {
+			due = due.Add(arrivalIdle)
+			continue
+		}
+
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		} else if -wait > maxLag {
+			maxLag = -wait
+		}
+
+		if profile.MaxInFlight > 0 && atomic.LoadInt64(&inFlight) >= int64(profile.MaxInFlight) {
+			atomic.AddInt64(&stats.Dropped, 1)
+		} else {
+			atomic.AddInt64(&inFlight, 1)
+			wg.Add(1)
+			go func(reqNum int, scheduled time.Time) {
+				defer wg.Done()
+				defer atomic.AddInt64(&inFlight, -1)
+				sendPayment(config.BaseURL, config.RequestOffset+reqNum, stats, scheduled)
+			}(n, due)
+			n++
+		}
+		due = due.Add(time.Duration(float64(time.Second) / rate))
+	}
+
+	// Arrivals stop at the end of the run; the backlog still in flight is waited for
+	// so its latency is counted
+	if backlog := atomic.LoadInt64(&inFlight); backlog > 0 {
+		fmt.Printf("   Waiting for %d in-flight requests...\n", backlog)
+	}
+	wg.Wait()
+
+	duration := time.Since(startTime)
+	stats.Elapsed = duration
+	if maxLag > 10*time.Millisecond {
+		fmt.Printf("   ⚠️  Scheduler fell behind by up to %v; latencies include the wait\n", maxLag.Round(time.Millisecond))
+	}
+	stats.PrintStats(duration)
+	return stats
+}
+
+func profileName(p ArrivalProfile) string {
+	if p.Profile == "" {
+		return "constant"
+	}
+	return p.Profile
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Requests are signed the way the backend's AuthMiddleware checks them (see the
+// backend's signing package): an HMAC-SHA256 under the key's secret in X-Signature as
+// "v<version>=<hex>", over METHOD|PATH|TIMESTAMP for v1 and with |hex(SHA-256(body))
+// appended for v2, and the RFC 3339 request time in X-Timestamp
+const (
+	headerAPIKey    = "X-API-Key"
+	headerSignature = "X-Signature"
+	headerTimestamp = "X-Timestamp"
+)
+
+// Credential is an API key and its signing secret
+type Credential struct {
+	Key    string `yaml:"key"`
+	Secret string `yaml:"secret"`
+}
+
+// AuthConfig is how the load tester authenticates when the backend's auth middleware
+// is enabled. Requests are spread round-robin over the keys
+type AuthConfig struct {
+	Keys             []Credential `yaml:"keys"`
+	SignatureVersion int          `yaml:"signature_version"` // 2 (default), or 1 for backends that still accept it
+}
+
+func (a AuthConfig) Enabled() bool {
+	return len(a.Keys) > 0
+}
+
+func (a AuthConfig) Validate() error {
+	for i, key := range a.Keys {
+		if key.Key == "" || key.Secret == "" {
+			return fmt.Errorf("auth key %d needs a key and a secret", i+1)
+		}
+	}
+	if a.SignatureVersion != 0 && a.SignatureVersion != 1 && a.SignatureVersion != 2 {
+		return fmt.Errorf("unknown signature_version %d (want 1 or 2)", a.SignatureVersion)
+	}
+	return nil
+}
+
+func (a AuthConfig) version() int {
+	if a.SignatureVersion == 0 {
+		return 2
+	}
+	return a.SignatureVersion
+}
+
+// computeSignature is the hex signature of a request under the given version
+func computeSignature(version int, secret, method, path, timestamp string, body []byte) string {
+	parts := []string{method, path, timestamp}
+	if version >= 2 {
+		digest := sha256.Sum256(body)
+		parts = append(parts, hex.EncodeToString(digest[:]))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign sets the API key, timestamp and signature headers for a request
+func (c Credential) sign(header http.Header, version int, method, path string, body []byte) {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	header.Set(headerAPIKey, c.Key)
+	header.Set(headerTimestamp, timestamp)
+	header.Set(headerSignature, fmt.Sprintf("v%d=%s", version, computeSignature(version, c.Secret, method, path, timestamp, body)))
+}
+
+// signingTransport signs every request to the backend with the next key in turn.
+// Requests to other hosts, such as distributed workers, are passed through unsigned
+type signingTransport struct {
+	auth AuthConfig
+	host string
+	next http.RoundTripper
+	turn uint64
+}
+
+// nextKey picks the key for a request, round-robin
+func (t *signingTransport) nextKey() Credential {
+	turn := atomic.AddUint64(&t.turn, 1) - 1
+	return t.auth.Keys[turn%uint64(len(t.auth.Keys))]
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A RoundTripper must not modify the caller's request
+	signed := req.Clone(req.Context())
+	if req.Body != nil {
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.nextKey().sign(signed.Header, t.auth.version(), signed.Method, signed.URL.Path, body)
+	return t.next.RoundTrip(signed)
+}
+
+var (
+	authMu    sync.Mutex
+	authState *signingTransport
+)
+
+// useAuth signs requests to the backend at baseURL from now on, or stops signing when
+// auth has no keys. It applies to the default HTTP client, which every scenario uses
+func useAuth(baseURL string, auth AuthConfig) error {
+	authMu.Lock()
+	defer authMu.Unlock()
+
+	if !auth.Enabled() {
+		authState = nil
+		http.DefaultClient.Transport = nil
+		return nil
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("base URL: %w", err)
+	}
+	authState = &signingTransport{auth: auth, host: parsed.Host, next: http.DefaultTransport}
+	http.DefaultClient.Transport = authState
+	return nil
+}
+
+// authHeader is the signed header for a request made outside the default client,
+// such as a WebSocket upgrade, or nil when auth is off. The backend accepts each
+// signature only once, so bodyless requests like these can only be signed once a
+// second per key
+func authHeader(method, path string) http.Header {
+	authMu.Lock()
+	transport := authState
+	authMu.Unlock()
+	if transport == nil {
+		return nil
+	}
+	header := http.Header{}
+	transport.nextKey().sign(header, transport.auth.version(), method, path, nil)
+	return header
+}
+
+// keyLimitResult is what one key saw in the per-key rate limit scenario
+type keyLimitResult struct {
+	sent, allowed, limited, other int64
+	limit                         string // X-RateLimit-Limit as reported by the backend
+	firstLimited                  int    // Request number of the first 429, 0 if none
+}
+
+// keyRateLimitScenario sends requests from every key at once, each as fast as it can,
+// to check that each key gets its own quota and that one key being limited leaves the
+// others alone
+func keyRateLimitScenario(config LoadTestConfig) *LoadTestStats {
+	fmt.Println("\n🔑 Starting Test Scenario: PER-KEY RATE LIMIT")
+	if config.Duration > 0 {
+		fmt.Printf("   Keys: %d | Duration: %v\n", len(config.Auth.Keys), config.Duration)
+	} else {
+		fmt.Printf("   Keys: %d | Requests per key: %d\n", len(config.Auth.Keys), config.TotalRequests)
+	}
+
+	parsed, _ := url.Parse(config.BaseURL)
+	stats := config.stats()
+	results := make([]keyLimitResult, len(config.Auth.Keys))
+	startTime := time.Now()
+	var deadline time.Time
+	if config.Duration > 0 {
+		deadline = startTime.Add(config.Duration)
+	}
+
+	var wg sync.WaitGroup
+	for k, key := range config.Auth.Keys {
+		wg.Add(1)
+		go func(k int, key Credential) {
+			defer wg.Done()
+			client := &http.Client{Transport: &signingTransport{
+				auth: AuthConfig{Keys: []Credential{key}, SignatureVersion: config.Auth.SignatureVersion},
+				host: parsed.Host,
+				next: http.DefaultTransport,
+			}}
+			result := &results[k]
+			for i := 1; config.TotalRequests == 0 || i <= config.TotalRequests; i++ {
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					break
+				}
+				body := fmt.Sprintf(`{"id":"ratelimit_%d_%d","amount":%d,"currency":"USD"}`, k, config.RequestOffset+i, 1000+i)
+				reqStart := time.Now()
+				resp, err := client.Post(config.BaseURL+"/paymentKey", "application/json", strings.NewReader(body))
+				latency := time.Since(reqStart)
+				result.sent++
+				if err != nil {
+					stats.RecordRequest(0, latency)
+					result.other++
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				stats.RecordRequest(resp.StatusCode, latency)
+
+				if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "" {
+					result.limit = limit
+				}
+				switch {
+				case resp.StatusCode == http.StatusTooManyRequests:
+					result.limited++
+					if result.firstLimited == 0 {
+						result.firstLimited = i
+					}
+				case resp.StatusCode >= 200 && resp.StatusCode < 300:
+					result.allowed++
+				default:
+					result.other++
+				}
+			}
+		}(k, key)
+	}
+	wg.Wait()
+
+	duration := time.Since(startTime)
+	stats.Elapsed = duration
+	stats.PrintStats(duration)
+
+	fmt.Fprintln(reportOutput, "\n   Per-key rate limits:")
+	for k, result := range results {
+		firstLimited := "-"
+		if result.firstLimited > 0 {
+			firstLimited = fmt.Sprintf("#%d", result.firstLimited)
+		}
+		limit := result.limit
+		if limit == "" {
+			limit = "?"
+		}
+		fmt.Fprintf(reportOutput, "     %-12s sent %-6d allowed %-6d limited %-6d other %-5d limit %-5s first 429 %s\n",
+			maskKey(config.Auth.Keys[k].Key), result.sent, result.allowed, result.limited, result.other, limit, firstLimited)
+	}
+	return stats
+}
+
+// maskKey shortens a key for reports so results can be shared without it
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return key
+	}
+	return key[:8] + "…"
+}
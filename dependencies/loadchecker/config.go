@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScenarioSpec is one scenario to run and its load settings. Zero values keep the
+// scenario's defaults
+type ScenarioSpec struct {
+	Name        string        `yaml:"name"`
+	Requests    int           `yaml:"requests"`
+	Concurrency int           `yaml:"concurrency"`
+	Duration    time.Duration `yaml:"duration"` // Run for this long instead of a fixed request count
+	RampUp      time.Duration `yaml:"ramp_up"`
+	// Arrival is the rate schedule of the open scenario
+	Arrival ArrivalProfile `yaml:"arrival"`
+	// CompletionTimeout is how long the e2e scenario waits for a final status
+	CompletionTimeout time.Duration `yaml:"completion_timeout"`
+	// SampleEvery is how often the soak scenario samples the backend's /metrics
+	SampleEvery time.Duration `yaml:"sample_every"`
+	// Thresholds apply to this scenario on top of the plan's
+	Thresholds []string `yaml:"thresholds"`
+
+	thresholds []Threshold
+}
+
+// RunPlan is a scriptable load test run, read from a YAML file with --config:
+//
+//	base_url: http://localhost:3000
+//	output: results.json
+//	format: json
+//	history: history.jsonl
+//	workers: [load-1:7070, load-2:7070]
+//	auth:
+//	  keys: [{key: pk_load_1, secret: sk_...}, {key: pk_load_2, secret: sk_...}]
+//	pause: 5s
+//	thresholds: ["error_rate < 1%"]
+//	scenarios:
+//	  - name: normal
+//	    requests: 5000
+//	    concurrency: 200
+//	    ramp_up: 10s
+//	    thresholds: ["p95 < 800ms"]
+//	  - name: open
+//	    duration: 5m
+//	    arrival: {profile: ramp, rps: 50, end_rps: 500, max_in_flight: 2000}
+//	  - name: e2e
+//	    requests: 500
+//	    completion_timeout: 1m
+//	    thresholds: ["completion_p95 < 5s", "stuck < 1"]
+//	  - name: rate-limit
+//	  - name: key-rate-limit
+//	    requests: 200
+//	  - name: soak
+//	    duration: 4h
+//	    arrival: {rps: 20}
+//	    sample_every: 1m
+//	    thresholds: ["leaks < 1"]
+type RunPlan struct {
+	BaseURL    string         `yaml:"base_url"`
+	Output     string         `yaml:"output"`
+	Format     string         `yaml:"format"`     // text (default), json or csv
+	History    string         `yaml:"history"`    // Results are appended here as JSON lines
+	TimeSeries string         `yaml:"timeseries"` // Soak samples are written here as CSV
+	Pause      time.Duration  `yaml:"pause"`      // Between scenarios
+	Thresholds []string       `yaml:"thresholds"`
+	Scenarios  []ScenarioSpec `yaml:"scenarios"`
+	// Auth signs every request with the backend's API key scheme
+	Auth AuthConfig `yaml:"auth"`
+	// Workers spread each scenario's load over other loadchecker processes, given as
+	// host:port
+	Workers []string `yaml:"workers"`
+
+	// WorkerAddr, set by --worker, runs this process as a worker instead of a plan
+	WorkerAddr string `yaml:"-"`
+}
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// scenarioRunners are the scenarios the load tester can run, by name. A scenario
+// without load stats returns nil and is left out of reports
+var scenarioRunners = map[string]func(LoadTestConfig) *LoadTestStats{
+	"normal":          normalLoadScenario,
+	"circuit-breaker": circuitBreakerTest,
+	"rate-limit":      rateLimitTest,
+	"open":            openModelScenario,
+	"e2e":             e2eScenario,
+	"key-rate-limit":  keyRateLimitScenario,
+	"soak":            soakScenario,
+	"compliance": func(config LoadTestConfig) *LoadTestStats {
+		complianceTest(config)
+		return nil
+	},
+}
+
+// scenarioOrder is the order "all" runs them in. The open scenario is left out as it
+// needs an arrival rate, e2e as it holds a WebSocket open per payment, key-rate-limit
+// as it needs API keys and soak as it runs for hours
+var scenarioOrder = []string{"normal", "circuit-breaker", "rate-limit", "compliance"}
+
+// scenarioNames lists every scenario for help and error messages
+var scenarioNames = strings.Join(scenarioOrder, ", ") + ", open, e2e, key-rate-limit, soak"
+
+func loadRunPlan(path string) (RunPlan, error) {
+	var plan RunPlan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&plan); err != nil && err != io.EOF {
+		return plan, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return plan, nil
+}
+
+// parseRunPlan builds the run from the command line. A --config file supplies the
+// scenario list; otherwise --scenario names a single one. Flags that are set override
+// the file, and the load flags apply to every scenario in it
+func parseRunPlan(args []string) (RunPlan, error) {
+	fs := flag.NewFlagSet("loadchecker", flag.ExitOnError)
+	configPath := fs.String("config", "", "YAML scenario file")
+	scenario := fs.String("scenario", "normal", "scenario to run: "+scenarioNames+" or all")
+	requests := fs.Int("requests", 1000, "requests to send")
+	concurrency := fs.Int("concurrency", 100, "requests in flight at once")
+	duration := fs.Duration("duration", 0, "run for this long instead of a fixed request count, e.g. 5m")
+	baseURL := fs.String("base-url", "http://localhost:3000", "backend base URL")
+	output := fs.String("output", "", "write results to this file")
+	format := fs.String("format", "text", "result format: text, json or csv")
+	history := fs.String("history", "", "append results to this JSON lines file")
+	profile := fs.String("profile", "constant", "open scenario arrival profile: constant, ramp, step or spike")
+	rps := fs.Float64("rps", 0, "open scenario request rate (starting rate for ramp)")
+	endRPS := fs.Float64("end-rps", 0, "open scenario rate at the end of a ramp")
+	maxInFlight := fs.Int("max-in-flight", 0, "open scenario cap on outstanding requests; 0 for none")
+	sampleEvery := fs.Duration("sample-every", 0, "soak scenario interval between backend samples (default 30s, shorter for short runs)")
+	timeSeries := fs.String("timeseries", "", "write soak samples to this CSV file")
+	completionTimeout := fs.Duration("completion-timeout", 30*time.Second, "e2e scenario wait for a final status before a payment counts as stuck")
+	workers := fs.String("workers", "", "comma-separated workers (host:port) to spread the load over")
+	workerAddr := fs.String("worker", "", "run as a worker listening on this address, e.g. :7070")
+	apiKey := fs.String("api-key", "", "sign requests with this API key")
+	apiSecret := fs.String("api-secret", os.Getenv("LOADCHECKER_API_SECRET"), "the API key's signing secret (default $LOADCHECKER_API_SECRET)")
+	signatureVersion := fs.Int("signature-version", 0, "request signature version, 1 or 2 (default 2)")
+	var thresholds stringList
+	fs.Var(&thresholds, "assert", `threshold every scenario must meet, e.g. "p95 < 800ms" (repeatable)`)
+	fs.Parse(args)
+
+	if *workerAddr != "" {
+		return RunPlan{WorkerAddr: *workerAddr}, nil
+	}
+
+	plan := RunPlan{
+		BaseURL:   *baseURL,
+		Pause:     2 * time.Second,
+		Scenarios: []ScenarioSpec{{Name: *scenario, Requests: *requests, Concurrency: *concurrency, Arrival: ArrivalProfile{Profile: *profile}}},
+	}
+	if *configPath != "" {
+		var err error
+		if plan, err = loadRunPlan(*configPath); err != nil {
+			return plan, err
+		}
+		if plan.BaseURL == "" {
+			plan.BaseURL = *baseURL
+		}
+		if plan.Pause == 0 {
+			plan.Pause = 2 * time.Second
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["base-url"] {
+		plan.BaseURL = *baseURL
+	}
+	if set["output"] {
+		plan.Output = *output
+	}
+	if set["format"] || plan.Format == "" {
+		plan.Format = *format
+	}
+	if set["history"] {
+		plan.History = *history
+	}
+	if set["timeseries"] {
+		plan.TimeSeries = *timeSeries
+	}
+	// --api-key replaces the file's keys; --signature-version applies to either
+	if set["api-key"] {
+		plan.Auth.Keys = []Credential{{Key: *apiKey, Secret: *apiSecret}}
+	}
+	if set["signature-version"] {
+		plan.Auth.SignatureVersion = *signatureVersion
+	}
+	if set["workers"] {
+		plan.Workers = nil
+		for _, worker := range strings.Split(*workers, ",") {
+			if worker = strings.TrimSpace(worker); worker != "" {
+				plan.Workers = append(plan.Workers, worker)
+			}
+		}
+	}
+	plan.Thresholds = append(plan.Thresholds, thresholds...)
+	for i := range plan.Scenarios {
+		if set["requests"] {
+			plan.Scenarios[i].Requests = *requests
+		}
+		if set["concurrency"] {
+			plan.Scenarios[i].Concurrency = *concurrency
+		}
+		if set["profile"] {
+			plan.Scenarios[i].Arrival.Profile = *profile
+		}
+		if set["rps"] {
+			plan.Scenarios[i].Arrival.RPS = *rps
+		}
+		if set["end-rps"] {
+			plan.Scenarios[i].Arrival.EndRPS = *endRPS
+		}
+		if set["max-in-flight"] {
+			plan.Scenarios[i].Arrival.MaxInFlight = *maxInFlight
+		}
+		if set["completion-timeout"] {
+			plan.Scenarios[i].CompletionTimeout = *completionTimeout
+		}
+		if set["sample-every"] {
+			plan.Scenarios[i].SampleEvery = *sampleEvery
+		}
+		if set["duration"] {
+			plan.Scenarios[i].Duration = *duration
+			// A duration alone runs until time is up rather than stopping at the default count
+			if !set["requests"] {
+				plan.Scenarios[i].Requests = 0
+			}
+		}
+	}
+
+	// "all" expands to every scenario with the same settings
+	var expanded []ScenarioSpec
+	for _, spec := range plan.Scenarios {
+		if spec.Name != "all" {
+			expanded = append(expanded, spec)
+			continue
+		}
+		for _, name := range scenarioOrder {
+			spec.Name = name
+			expanded = append(expanded, spec)
+		}
+	}
+	plan.Scenarios = expanded
+
+	if len(plan.Scenarios) == 0 {
+		return plan, fmt.Errorf("no scenarios to run")
+	}
+	if err := plan.Auth.Validate(); err != nil {
+		return plan, err
+	}
+	if plan.Format != "text" && plan.Format != "json" && plan.Format != "csv" {
+		return plan, fmt.Errorf("unknown format %q (want text, json or csv)", plan.Format)
+	}
+	var shared []Threshold
+	for _, text := range plan.Thresholds {
+		threshold, err := ParseThreshold(text)
+		if err != nil {
+			return plan, err
+		}
+		shared = append(shared, threshold)
+	}
+	for i := range plan.Scenarios {
+		spec := &plan.Scenarios[i]
+		spec.thresholds = append([]Threshold(nil), shared...)
+		for _, text := range spec.Thresholds {
+			threshold, err := ParseThreshold(text)
+			if err != nil {
+				return plan, fmt.Errorf("scenario %s: %w", spec.Name, err)
+			}
+			spec.thresholds = append(spec.thresholds, threshold)
+		}
+		if _, ok := scenarioRunners[spec.Name]; !ok {
+			return plan, fmt.Errorf("unknown scenario %q (want %s or all)", spec.Name, scenarioNames)
+		}
+		if len(plan.Workers) > 0 && !distributedScenarios[spec.Name] {
+			return plan, fmt.Errorf("scenario %s can't be spread over workers (want normal, open or e2e)", spec.Name)
+		}
+		if spec.Name == "key-rate-limit" && !plan.Auth.Enabled() {
+			return plan, fmt.Errorf("scenario key-rate-limit: needs API keys, from --api-key or auth.keys")
+		}
+		if spec.Name == "open" {
+			if spec.Duration <= 0 {
+				return plan, fmt.Errorf("scenario open: duration is required")
+			}
+			if err := spec.Arrival.Validate(); err != nil {
+				return plan, fmt.Errorf("scenario open: %w", err)
+			}
+		}
+		if spec.Name == "soak" {
+			if spec.Duration <= 0 {
+				return plan, fmt.Errorf("scenario soak: duration is required")
+			}
+			if spec.Arrival.RPS < 0 || spec.Arrival.MaxInFlight < 0 {
+				return plan, fmt.Errorf("scenario soak: rps and max_in_flight must not be negative")
+			}
+		}
+		if spec.Requests < 0 || spec.Concurrency < 0 || spec.Duration < 0 || spec.RampUp < 0 || spec.CompletionTimeout < 0 || spec.SampleEvery < 0 {
+			return plan, fmt.Errorf("scenario %s: requests, concurrency, duration, ramp_up, completion_timeout and sample_every must not be negative", spec.Name)
+		}
+	}
+	return plan, nil
+}
+
+// config turns a scenario spec into the settings the scenario functions take
+func (spec ScenarioSpec) config(baseURL string) LoadTestConfig {
+	config := LoadTestConfig{
+		BaseURL:           baseURL,
+		TotalRequests:     spec.Requests,
+		Concurrency:       spec.Concurrency,
+		Duration:          spec.Duration,
+		RampUpDurationSec: int(spec.RampUp.Seconds()),
+		TestScenario:      spec.Name,
+		Arrival:           spec.Arrival,
+		CompletionTimeout: spec.CompletionTimeout,
+		SampleEvery:       spec.SampleEvery,
+	}
+	if config.TotalRequests == 0 && config.Duration == 0 {
+		config.TotalRequests = 1000
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = 100
+	}
+	if config.CompletionTimeout == 0 {
+		config.CompletionTimeout = 30 * time.Second
+	}
+	return config
+}
+
+// runPlan runs every scenario in order and checks its thresholds. Text reports go to
+// stdout and the output file; with json or csv the boxes stay on stdout and the
+// machine-readable report goes to the output file, or stdout without one
+func runPlan(plan RunPlan) (RunReport, error) {
+	report := RunReport{StartedAt: time.Now().UTC(), Passed: true}
+	if err := useAuth(plan.BaseURL, plan.Auth); err != nil {
+		return report, err
+	}
+
+	var out io.Writer = os.Stdout
+	if plan.Output != "" {
+		file, err := os.Create(plan.Output)
+		if err != nil {
+			return report, err
+		}
+		defer file.Close()
+		out = file
+		if plan.Format == "text" {
+			reportOutput = io.MultiWriter(os.Stdout, file)
+		}
+	}
+
+	for i, spec := range plan.Scenarios {
+		if i > 0 {
+			time.Sleep(plan.Pause)
+		}
+		startedAt := time.Now()
+		config := spec.config(plan.BaseURL)
+		config.Auth = plan.Auth
+		var stats *LoadTestStats
+		if len(plan.Workers) > 0 {
+			var err error
+			if stats, err = runDistributed(plan.Workers, config); err != nil {
+				return report, err
+			}
+		} else {
+			stats = scenarioRunners[spec.Name](config)
+		}
+		if stats == nil {
+			continue
+		}
+
+		result := stats.Result(spec.Name, plan.BaseURL, startedAt)
+		for _, threshold := range spec.thresholds {
+			checked := threshold.Check(result)
+			result.Thresholds = append(result.Thresholds, checked)
+			result.Passed = result.Passed && checked.Passed
+		}
+		printThresholds(reportOutput, result)
+		report.Passed = report.Passed && result.Passed
+		report.Scenarios = append(report.Scenarios, result)
+	}
+
+	var err error
+	switch plan.Format {
+	case "json":
+		err = writeJSONReport(out, report)
+	case "csv":
+		err = writeCSVReport(out, report)
+	}
+	if err != nil {
+		return report, err
+	}
+	if plan.History != "" {
+		if err := appendHistory(plan.History, report.Scenarios); err != nil {
+			return report, fmt.Errorf("append history: %w", err)
+		}
+	}
+	if plan.TimeSeries != "" {
+		if err := writeTimeSeries(plan.TimeSeries, report.Scenarios); err != nil {
+			return report, fmt.Errorf("write time series: %w", err)
+		}
+	}
+	return report, nil
+}
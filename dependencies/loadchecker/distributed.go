@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Distributed mode spreads one scenario over several loadchecker processes when a
+// single host can't generate enough load. Each worker runs `loadchecker --worker :7070`;
+// the coordinator is started with --workers and splits the scenario's load between
+// them, sends each its share over HTTP and merges the stats they stream back
+
+// distributedScenarios can be split between workers. The others drive the simulator
+// or check individual responses, and would interfere with each other if run in parallel
+var distributedScenarios = map[string]bool{"normal": true, "open": true, "e2e": true}
+
+// workerOrderSpacing keeps each worker's order numbers apart from the others'
+const workerOrderSpacing = 1_000_000_000
+
+// workerProgressInterval is how often workers send partial stats
+const workerProgressInterval = time.Second
+
+// StatsSnapshot is a LoadTestStats at a point in time, as streamed by a worker
+type StatsSnapshot struct {
+	TotalRequests int64               `json:"total_requests"`
+	SuccessCount  int64               `json:"success_count"`
+	FailureCount  int64               `json:"failure_count"`
+	Dropped       int64               `json:"dropped"`
+	ElapsedMs     int64               `json:"elapsed_ms"`
+	StatusCodes   map[int]int64       `json:"status_codes"`
+	Latency       HistogramSnapshot   `json:"latency"`
+	Completion    *CompletionSnapshot `json:"completion,omitempty"`
+}
+
+// CompletionSnapshot is a CompletionStats at a point in time
+type CompletionSnapshot struct {
+	Latency  HistogramSnapshot `json:"latency"`
+	Outcomes map[string]int64  `json:"outcomes"`
+	Stuck    int64             `json:"stuck"`
+	WSErrors int64             `json:"ws_errors"`
+}
+
+func (s *LoadTestStats) Snapshot(elapsed time.Duration) StatsSnapshot {
+	snapshot := StatsSnapshot{
+		TotalRequests: atomic.LoadInt64(&s.TotalRequests),
+		SuccessCount:  atomic.LoadInt64(&s.SuccessCount),
+		FailureCount:  atomic.LoadInt64(&s.FailureCount),
+		Dropped:       atomic.LoadInt64(&s.Dropped),
+		ElapsedMs:     elapsed.Milliseconds(),
+		StatusCodes:   s.StatusCodes(),
+		Latency:       s.Latency.Snapshot(),
+	}
+	if s.Completion != nil {
+		snapshot.Completion = s.Completion.Snapshot()
+	}
+	return snapshot
+}
+
+// Merge adds a snapshot's counts to the stats. The run's length is the longest of the
+// merged ones
+func (s *LoadTestStats) Merge(snapshot StatsSnapshot) {
+	atomic.AddInt64(&s.TotalRequests, snapshot.TotalRequests)
+	atomic.AddInt64(&s.SuccessCount, snapshot.SuccessCount)
+	atomic.AddInt64(&s.FailureCount, snapshot.FailureCount)
+	atomic.AddInt64(&s.Dropped, snapshot.Dropped)
+	for code, count := range snapshot.StatusCodes {
+		if code < 0 || code >= len(s.statusCodes) {
+			code = 0
+		}
+		atomic.AddInt64(&s.statusCodes[code], count)
+	}
+	s.Latency.Merge(snapshot.Latency)
+	if elapsed := time.Duration(snapshot.ElapsedMs) * time.Millisecond; elapsed > s.Elapsed {
+		s.Elapsed = elapsed
+	}
+	if snapshot.Completion != nil {
+		if s.Completion == nil {
+			s.Completion = NewCompletionStats()
+		}
+		s.Completion.Merge(*snapshot.Completion)
+	}
+}
+
+func (c *CompletionStats) Snapshot() *CompletionSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := &CompletionSnapshot{
+		Latency:  c.latency.Snapshot(),
+		Outcomes: make(map[string]int64, len(c.outcomes)),
+		Stuck:    c.stuck,
+		WSErrors: c.wsErrors,
+	}
+	for status, count := range c.outcomes {
+		snapshot.Outcomes[status] = count
+	}
+	return snapshot
+}
+
+func (c *CompletionStats) Merge(snapshot CompletionSnapshot) {
+	c.latency.Merge(snapshot.Latency)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for status, count := range snapshot.Outcomes {
+		c.outcomes[status] += count
+	}
+	c.stuck += snapshot.Stuck
+	c.wsErrors += snapshot.WSErrors
+}
+
+// workerUpdate is one line of a worker's response stream. Updates are sent every
+// workerProgressInterval and once more when the scenario finishes
+type workerUpdate struct {
+	Final bool          `json:"final"`
+	Error string        `json:"error,omitempty"`
+	Stats StatsSnapshot `json:"stats"`
+}
+
+// split divides the config's load between n workers. Request counts, concurrency and
+// arrival rates are shared out; durations and timeouts are the same for every worker
+func (c LoadTestConfig) split(n int) []LoadTestConfig {
+	share := func(total, i int) int {
+		part := total / n
+		if i < total%n {
+			part++
+		}
+		return part
+	}
+	configs := make([]LoadTestConfig, n)
+	for i := range configs {
+		part := c
+		part.TotalRequests = share(c.TotalRequests, i)
+		part.Concurrency = max(share(c.Concurrency, i), 1)
+		part.RequestOffset = c.RequestOffset + i*workerOrderSpacing
+		part.Arrival.RPS /= float64(n)
+		part.Arrival.EndRPS /= float64(n)
+		part.Arrival.StepRPS /= float64(n)
+		part.Arrival.SpikeRPS /= float64(n)
+		part.Arrival.MaxInFlight = share(c.Arrival.MaxInFlight, i)
+		if c.Arrival.MaxInFlight > 0 {
+			part.Arrival.MaxInFlight = max(part.Arrival.MaxInFlight, 1)
+		}
+		configs[i] = part
+	}
+	return configs
+}
+
+// runDistributed runs a scenario across the workers and returns the merged stats.
+// Progress is printed as workers report it; a worker that fails mid-run is reported
+// and its last partial stats are kept
+func runDistributed(workers []string, config LoadTestConfig) (*LoadTestStats, error) {
+	fmt.Printf("\n🌐 Distributing %s across %d workers\n", config.TestScenario, len(workers))
+
+	latest := make([]StatsSnapshot, len(workers))
+	errs := make([]error, len(workers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, part := range config.split(len(workers)) {
+		wg.Add(1)
+		go func(i int, part LoadTestConfig) {
+			defer wg.Done()
+			errs[i] = streamWorker(workers[i], part, func(update workerUpdate) {
+				mu.Lock()
+				latest[i] = update.Stats
+				mu.Unlock()
+			})
+		}(i, part)
+	}
+
+	merged := func() *LoadTestStats {
+		mu.Lock()
+		defer mu.Unlock()
+		stats := NewLoadTestStats()
+		for _, snapshot := range latest {
+			stats.Merge(snapshot)
+		}
+		return stats
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(2 * workerProgressInterval)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ticker.C:
+			progress := merged()
+			latency := progress.Latencies()
+			fmt.Printf("   [%v] %d requests | %d failed | p95 %dms\n",
+				progress.Elapsed.Round(time.Second), progress.TotalRequests, progress.FailureCount, latency.P95)
+		}
+	}
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			fmt.Printf("   ⚠️  Worker %s: %v\n", workers[i], err)
+		}
+	}
+	if failed == len(workers) {
+		return nil, fmt.Errorf("scenario %s: every worker failed", config.TestScenario)
+	}
+
+	stats := merged()
+	stats.PrintStats(stats.Elapsed)
+	if stats.Completion != nil {
+		stats.Completion.Print()
+	}
+	return stats, nil
+}
+
+// streamWorker sends a worker its share of the scenario and passes on each update it
+// streams back until the final one
+func streamWorker(worker string, config LoadTestConfig, onUpdate func(workerUpdate)) error {
+	body, _ := json.Marshal(config)
+	resp, err := http.Post(workerURL(worker)+"/run", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var update workerUpdate
+		if err := decoder.Decode(&update); err != nil {
+			return fmt.Errorf("stream ended before the scenario finished: %w", err)
+		}
+		onUpdate(update)
+		if update.Error != "" {
+			return fmt.Errorf("%s", update.Error)
+		}
+		if update.Final {
+			return nil
+		}
+	}
+}
+
+// workerURL accepts a worker as host:port or a full URL
+func workerURL(worker string) string {
+	if strings.Contains(worker, "://") {
+		return strings.TrimSuffix(worker, "/")
+	}
+	return "http://" + worker
+}
+
+// loadWorker runs the scenarios a coordinator sends, one at a time
+type loadWorker struct {
+	busy int32
+}
+
+// serveWorker runs loadchecker as a worker listening on addr until it is stopped
+func serveWorker(addr string) error {
+	worker := &loadWorker{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", worker.handleRun)
+	fmt.Printf("🛠️  Load worker listening on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// handleRun runs the posted LoadTestConfig and streams a workerUpdate as JSON per line
+// until it finishes
+func (w *loadWorker) handleRun(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var config LoadTestConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(rw, "invalid scenario: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !distributedScenarios[config.TestScenario] {
+		http.Error(rw, fmt.Sprintf("scenario %q can't run on a worker", config.TestScenario), http.StatusBadRequest)
+		return
+	}
+	if config.Concurrency <= 0 || config.TotalRequests <= 0 && config.Duration <= 0 {
+		http.Error(rw, "scenario needs a concurrency and a request count or duration", http.StatusBadRequest)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&w.busy, 0, 1) {
+		http.Error(rw, "worker is already running a scenario", http.StatusConflict)
+		return
+	}
+	defer atomic.StoreInt32(&w.busy, 0)
+	if err := useAuth(config.BaseURL, config.Auth); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The stats are created here so they can be read while the scenario runs
+	stats := NewLoadTestStats()
+	if config.TestScenario == "e2e" {
+		stats.Completion = NewCompletionStats()
+	}
+	config.Stats = stats
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	flusher, _ := rw.(http.Flusher)
+	encoder := json.NewEncoder(rw)
+	send := func(update workerUpdate) {
+		// A coordinator that went away stops getting updates; the scenario still finishes
+		if encoder.Encode(update) == nil && flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	startTime := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scenarioRunners[config.TestScenario](config)
+	}()
+
+	ticker := time.NewTicker(workerProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			send(workerUpdate{Final: true, Stats: stats.Snapshot(time.Since(startTime))})
+			return
+		case <-ticker.C:
+			send(workerUpdate{Stats: stats.Snapshot(time.Since(startTime))})
+		}
+	}
+}
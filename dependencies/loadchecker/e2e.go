@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// finalStatuses are the payment states that end a payment's processing. REVIEW is a
+// compliance hold, final as far as the payment flow is concerned
+var finalStatuses = map[string]bool{
+	"SUCCESS":   true,
+	"FAILED":    true,
+	"CANCELLED": true,
+	"REVIEW":    true,
+}
+
+// CompletionStats tracks how long payments take to reach a final status after they
+// are submitted, as seen by a WebSocket subscriber
+type CompletionStats struct {
+	mu       sync.Mutex
+	latency  *Histogram
+	outcomes map[string]int64
+	stuck    int64 // No final status within the completion timeout
+	wsErrors int64 // Subscription failed or dropped before a final status
+}
+
+func NewCompletionStats() *CompletionStats {
+	return &CompletionStats{latency: NewHistogram(), outcomes: make(map[string]int64)}
+}
+
+func (c *CompletionStats) Record(status string, latency time.Duration) {
+	c.latency.Record(latency)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outcomes[status]++
+}
+
+func (c *CompletionStats) RecordStuck() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stuck++
+}
+
+func (c *CompletionStats) RecordWSError() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wsErrors++
+}
+
+// CompletionSummary is the machine-readable form of CompletionStats
+type CompletionSummary struct {
+	Completed int64            `json:"completed"`
+	Stuck     int64            `json:"stuck"`
+	WSErrors  int64            `json:"ws_errors"`
+	Outcomes  map[string]int64 `json:"outcomes"`
+	LatencyMs LatencySummary   `json:"latency_ms"`
+}
+
+func (c *CompletionStats) Summary() CompletionSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	summary := CompletionSummary{
+		Completed: c.latency.Count(),
+		Stuck:     c.stuck,
+		WSErrors:  c.wsErrors,
+		Outcomes:  make(map[string]int64, len(c.outcomes)),
+		LatencyMs: summarize(c.latency),
+	}
+	for status, count := range c.outcomes {
+		summary.Outcomes[status] = count
+	}
+	return summary
+}
+
+func (c *CompletionStats) Print() {
+	summary := c.Summary()
+	fmt.Fprintln(reportOutput, "\n╔═══════════════════════════════════════════════════════╗")
+	fmt.Fprintln(reportOutput, "║          END-TO-END COMPLETION                        ║")
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintf(reportOutput, "║ Completed:         %-30d ║\n", summary.Completed)
+	fmt.Fprintf(reportOutput, "║ Stuck:             %-30d ║\n", summary.Stuck)
+	fmt.Fprintf(reportOutput, "║ WebSocket errors:  %-30d ║\n", summary.WSErrors)
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintf(reportOutput, "║ Time to final status (ms):                            ║\n")
+	fmt.Fprintf(reportOutput, "║   P50:             %-30d ║\n", summary.LatencyMs.P50)
+	fmt.Fprintf(reportOutput, "║   P95:             %-30d ║\n", summary.LatencyMs.P95)
+	fmt.Fprintf(reportOutput, "║   P99:             %-30d ║\n", summary.LatencyMs.P99)
+	fmt.Fprintf(reportOutput, "║   Max:             %-30d ║\n", summary.LatencyMs.Max)
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintln(reportOutput, "║ Final Status Distribution:                            ║")
+	for status, count := range summary.Outcomes {
+		fmt.Fprintf(reportOutput, "║   %-10s %-38d ║\n", status+":", count)
+	}
+	fmt.Fprintln(reportOutput, "╚═══════════════════════════════════════════════════════╝")
+}
+
+// e2eScenario submits payments like the normal scenario, but also subscribes to each
+// one on /ws and waits for its final status. The submit latency is the usual report;
+// time to final status and payments that never finish are reported alongside it
+func e2eScenario(config LoadTestConfig) *LoadTestStats {
+	fmt.Println("\n🔁 Starting Test Scenario: END-TO-END (WebSocket)")
+	if config.Duration > 0 {
+		fmt.Printf("   Duration: %v | Concurrency: %d | Completion timeout: %v\n", config.Duration, config.Concurrency, config.CompletionTimeout)
+	} else {
+		fmt.Printf("   Requests: %d | Concurrency: %d | Completion timeout: %v\n", config.TotalRequests, config.Concurrency, config.CompletionTimeout)
+	}
+
+	stats := config.stats()
+	if stats.Completion == nil {
+		stats.Completion = NewCompletionStats()
+	}
+	startTime := time.Now()
+	var deadline time.Time
+	if config.Duration > 0 {
+		deadline = startTime.Add(config.Duration)
+	}
+
+	sem := make(chan struct{}, config.Concurrency)
+	var wg sync.WaitGroup
+	for i := 1; config.TotalRequests == 0 || i <= config.TotalRequests; i++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(reqNum int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			trackPayment(config, config.RequestOffset+reqNum, stats)
+		}(i)
+	}
+	wg.Wait()
+
+	duration := time.Since(startTime)
+	stats.Elapsed = duration
+	stats.PrintStats(duration)
+	stats.Completion.Print()
+	return stats
+}
+
+// wsURL turns the backend's base URL into the /ws subscription URL for a payment
+func wsURL(baseURL, paymentID string) string {
+	base := strings.Replace(strings.Replace(baseURL, "https://", "wss://", 1), "http://", "ws://", 1)
+	return base + "/ws?payment_id=" + url.QueryEscape(paymentID)
+}
+
+// trackPayment runs one payment end to end. The subscription is opened before the
+// payment is submitted so no update can be missed
+func trackPayment(config LoadTestConfig, requestNum int, stats *LoadTestStats) {
+	paymentID, statusCode := requestPaymentKey(config.BaseURL, requestNum)
+	if paymentID == "" {
+		stats.RecordRequest(statusCode, 0)
+		return
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(config.BaseURL, paymentID), authHeader(http.MethodGet, "/ws"))
+	if err != nil {
+		stats.Completion.RecordWSError()
+	} else {
+		defer conn.Close()
+	}
+
+	startTime := time.Now()
+	resp, err := http.Post(config.BaseURL+"/payment", "application/json", bytes.NewReader(paymentBody(requestNum, paymentID)))
+	latency := time.Since(startTime)
+	if err != nil {
+		stats.RecordRequest(0, latency)
+		return
+	}
+	resp.Body.Close()
+	stats.RecordRequest(resp.StatusCode, latency)
+
+	// A rejected submission never gets a final status to wait for
+	if conn == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return
+	}
+
+	conn.SetReadDeadline(startTime.Add(config.CompletionTimeout))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				stats.Completion.RecordStuck()
+			} else {
+				stats.Completion.RecordWSError()
+			}
+			return
+		}
+
+		// Final results carry "status"; state_changed events carry "to"
+		var update struct {
+			Event  string `json:"event"`
+			Status string `json:"status"`
+			To     string `json:"to"`
+		}
+		if json.Unmarshal(msg, &update) != nil {
+			continue
+		}
+		status := update.Status
+		if update.Event == "state_changed" {
+			status = update.To
+		}
+		if finalStatuses[status] {
+			stats.Completion.Record(status, time.Since(startTime))
+			return
+		}
+	}
+}
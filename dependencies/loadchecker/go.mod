@@ -1,3 +1,7 @@
 module pulseberry
 
 go 1.25.6
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/gorilla/websocket v1.5.3
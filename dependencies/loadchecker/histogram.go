@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is an HDR (high dynamic range) latency histogram. Values are counted in
+// buckets whose width grows with the value, so memory is fixed no matter how many
+// requests are recorded while every value keeps three significant digits. Recording
+// is a few atomic adds, so any number of goroutines can record without a lock.
+//
+// Values are microseconds from 1µs to histogramMax; longer latencies are counted as
+// histogramMax
+type Histogram struct {
+	counts []int64
+	total  int64
+	sum    int64 // Microseconds
+	min    int64 // Microseconds; math.MaxInt64 until the first value
+	max    int64
+}
+
+const (
+	// subBucketHalfMagnitude sets the precision: 2^11 sub-buckets per power of two keeps
+	// the error under 0.1%
+	subBucketHalfMagnitude = 10
+	subBucketHalfCount     = 1 << subBucketHalfMagnitude
+	subBucketMask          = 2*subBucketHalfCount - 1
+
+	histogramMax = int64(time.Hour / time.Microsecond)
+)
+
+// histogramSize is the number of counters needed to reach histogramMax
+var histogramSize = countsIndex(histogramMax) + 1
+
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]int64, histogramSize), min: math.MaxInt64}
+}
+
+// countsIndex is the counter a value falls in. Values below 2*subBucketHalfCount get
+// a counter each; above that each power of two is split into subBucketHalfCount
+// counters
+func countsIndex(value int64) int {
+	bucket := 63 - bits.LeadingZeros64(uint64(value)|subBucketMask) - subBucketHalfMagnitude
+	subBucket := int(value >> uint(bucket))
+	return bucket<<subBucketHalfMagnitude + subBucket
+}
+
+// valueAt is the largest value that falls in the counter at index
+func valueAt(index int) int64 {
+	bucket := index>>subBucketHalfMagnitude - 1
+	subBucket := int64(index&(subBucketHalfCount-1)) + subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= subBucketHalfCount
+		bucket = 0
+	}
+	return (subBucket+1)<<uint(bucket) - 1
+}
+
+// Record counts one latency
+func (h *Histogram) Record(latency time.Duration) {
+	value := latency.Microseconds()
+	if value < 0 {
+		value = 0
+	}
+	if value > histogramMax {
+		value = histogramMax
+	}
+	atomic.AddInt64(&h.counts[countsIndex(value)], 1)
+	atomic.AddInt64(&h.total, 1)
+	atomic.AddInt64(&h.sum, value)
+
+	for {
+		old := atomic.LoadInt64(&h.min)
+		if value >= old || atomic.CompareAndSwapInt64(&h.min, old, value) {
+			break
+		}
+	}
+	for {
+		old := atomic.LoadInt64(&h.max)
+		if value <= old || atomic.CompareAndSwapInt64(&h.max, old, value) {
+			break
+		}
+	}
+}
+
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+
+func (h *Histogram) Min() time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.min)) * time.Microsecond
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max)) * time.Microsecond
+}
+
+func (h *Histogram) Mean() time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum)/total) * time.Microsecond
+}
+
+// Percentile is the latency at or below which percentile percent of the values fall,
+// e.g. Percentile(99) for p99
+func (h *Histogram) Percentile(percentile float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen int64
+	for i := range h.counts {
+		seen += atomic.LoadInt64(&h.counts[i])
+		if seen >= target {
+			return time.Duration(min(valueAt(i), atomic.LoadInt64(&h.max))) * time.Microsecond
+		}
+	}
+	return h.Max()
+}
+
+// HistogramSnapshot is a histogram's counts in a form that can be sent between
+// processes. Only non-empty counters are included
+type HistogramSnapshot struct {
+	Counts map[int]int64 `json:"counts"` // By counter index
+	Sum    int64         `json:"sum"`
+	Min    int64         `json:"min"`
+	Max    int64         `json:"max"`
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Counts: make(map[int]int64),
+		Sum:    atomic.LoadInt64(&h.sum),
+		Min:    atomic.LoadInt64(&h.min),
+		Max:    atomic.LoadInt64(&h.max),
+	}
+	for i := range h.counts {
+		if count := atomic.LoadInt64(&h.counts[i]); count > 0 {
+			snapshot.Counts[i] = count
+		}
+	}
+	return snapshot
+}
+
+// Merge adds a snapshot's values to the histogram, e.g. to combine the results of
+// several workers
+func (h *Histogram) Merge(snapshot HistogramSnapshot) {
+	var total int64
+	for index, count := range snapshot.Counts {
+		if index < 0 || index >= len(h.counts) {
+			continue
+		}
+		atomic.AddInt64(&h.counts[index], count)
+		total += count
+	}
+	if total == 0 {
+		return
+	}
+	atomic.AddInt64(&h.total, total)
+	atomic.AddInt64(&h.sum, snapshot.Sum)
+	for {
+		old := atomic.LoadInt64(&h.min)
+		if snapshot.Min >= old || atomic.CompareAndSwapInt64(&h.min, old, snapshot.Min) {
+			break
+		}
+	}
+	for {
+		old := atomic.LoadInt64(&h.max)
+		if snapshot.Max <= old || atomic.CompareAndSwapInt64(&h.max, old, snapshot.Max) {
+			break
+		}
+	}
+}
+
+// Since is a histogram of the values recorded between prev and this snapshot, e.g. the
+// latencies of the last interval of a long run. Its minimum isn't known and reads 0
+func (s HistogramSnapshot) Since(prev HistogramSnapshot) *Histogram {
+	h := NewHistogram()
+	for index, count := range s.Counts {
+		if delta := count - prev.Counts[index]; delta > 0 && index < len(h.counts) {
+			h.counts[index] = delta
+			h.total += delta
+		}
+	}
+	h.sum = s.Sum - prev.Sum
+	h.min = 0
+	h.max = s.Max
+	return h
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,149 +21,156 @@ type LoadTestConfig struct {
 	Concurrency       int
 	RampUpDurationSec int
 	TestScenario      string
+	// Duration bounds the run by time instead of by TotalRequests when set
+	Duration time.Duration
+	// Arrival drives the open-model scenario
+	Arrival ArrivalProfile
+	// CompletionTimeout is how long the e2e scenario waits for a final status
+	CompletionTimeout time.Duration
+	// SampleEvery is how often the soak scenario samples the backend
+	SampleEvery time.Duration
+	// Auth signs requests for backends with the auth middleware on
+	Auth AuthConfig
+	// RequestOffset is added to order numbers so distributed workers don't send the
+	// same orders
+	RequestOffset int
+	// Stats, when set, is recorded into instead of fresh stats, so a worker can report
+	// progress while the scenario runs
+	Stats *LoadTestStats `json:"-"`
 }
 
+// stats is what a scenario records into
+func (c LoadTestConfig) stats() *LoadTestStats {
+	if c.Stats != nil {
+		return c.Stats
+	}
+	return NewLoadTestStats()
+}
+
+// reportOutput is where result reports are written; --output adds a file to it
+var reportOutput io.Writer = os.Stdout
+
+// LoadTestStats is safe to record into from any number of goroutines without locking,
+// so recording does not slow the load it measures
 type LoadTestStats struct {
 	TotalRequests int64
 	SuccessCount  int64
 	FailureCount  int64
-	TotalLatency  int64
-	MinLatency    int64
-	MaxLatency    int64
-	StatusCodes   map[int]int64
-	Latencies     []int64
-	mu            sync.Mutex
+	Latency       *Histogram
+	statusCodes   [600]int64       // Counts by HTTP status; 0 is a request that got no response
+	Elapsed       time.Duration    // Wall-clock length of the run, set when it finishes
+	Dropped       int64            // Open-model arrivals not sent because max in-flight was reached
+	Completion    *CompletionStats // Time to final status, tracked by the e2e scenario only
+	Soak          *SoakReport      // Time series and resource trends of the soak scenario
+}
+
+func NewLoadTestStats() *LoadTestStats {
+	return &LoadTestStats{Latency: NewHistogram()}
 }
 
 func (s *LoadTestStats) RecordRequest(statusCode int, latency time.Duration) {
 	atomic.AddInt64(&s.TotalRequests, 1)
-	latencyMs := latency.Milliseconds()
-	atomic.AddInt64(&s.TotalLatency, latencyMs)
+	s.Latency.Record(latency)
 
 	if statusCode >= 200 && statusCode < 300 {
 		atomic.AddInt64(&s.SuccessCount, 1)
 	} else {
 		atomic.AddInt64(&s.FailureCount, 1)
 	}
-
-	// Update min/max
-	for {
-		oldMin := atomic.LoadInt64(&s.MinLatency)
-		if oldMin == 0 || latencyMs < oldMin {
-			if atomic.CompareAndSwapInt64(&s.MinLatency, oldMin, latencyMs) {
-				break
-			}
-		} else {
-			break
-		}
+	if statusCode < 0 || statusCode >= len(s.statusCodes) {
+		statusCode = 0
 	}
-
-	for {
-		oldMax := atomic.LoadInt64(&s.MaxLatency)
-		if latencyMs > oldMax {
-			if atomic.CompareAndSwapInt64(&s.MaxLatency, oldMax, latencyMs) {
-				break
-			}
-		} else {
-			break
-		}
-	}
-
-	// Track latencies for percentile calculation
-	s.mu.Lock()
-	s.Latencies = append(s.Latencies, latencyMs)
-	s.StatusCodes[statusCode]++
-	s.mu.Unlock()
+	atomic.AddInt64(&s.statusCodes[statusCode], 1)
 }
 
-func (s *LoadTestStats) CalculatePercentiles() (p50, p95, p99 int64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if len(s.Latencies) == 0 {
-		return 0, 0, 0
-	}
-
-	// Simple percentile calculation (not perfectly accurate but good enough)
-	sorted := make([]int64, len(s.Latencies))
-	copy(sorted, s.Latencies)
-
-	// Bubble sort (fine for test data)
-	for i := 0; i < len(sorted); i++ {
-		for j := i + 1; j < len(sorted); j++ {
-			if sorted[i] > sorted[j] {
-				sorted[i], sorted[j] = sorted[j], sorted[i]
-			}
+// StatusCodes is the number of responses with each status code seen so far
+func (s *LoadTestStats) StatusCodes() map[int]int64 {
+	codes := make(map[int]int64)
+	for code := range s.statusCodes {
+		if count := atomic.LoadInt64(&s.statusCodes[code]); count > 0 {
+			codes[code] = count
 		}
 	}
+	return codes
+}
 
-	p50 = sorted[len(sorted)/2]
-	p95 = sorted[int(float64(len(sorted))*0.95)]
-	p99 = sorted[int(float64(len(sorted))*0.99)]
-	return
+// Latencies summarizes the recorded latencies in milliseconds
+func (s *LoadTestStats) Latencies() LatencySummary {
+	return summarize(s.Latency)
 }
 
 func (s *LoadTestStats) PrintStats(duration time.Duration) {
 	total := atomic.LoadInt64(&s.TotalRequests)
 	success := atomic.LoadInt64(&s.SuccessCount)
 	failure := atomic.LoadInt64(&s.FailureCount)
-	totalLatency := atomic.LoadInt64(&s.TotalLatency)
-	minLatency := atomic.LoadInt64(&s.MinLatency)
-	maxLatency := atomic.LoadInt64(&s.MaxLatency)
-
-	p50, p95, p99 := s.CalculatePercentiles()
-
-	fmt.Println("\n╔═══════════════════════════════════════════════════════╗")
-	fmt.Println("║          LOAD TEST RESULTS                            ║")
-	fmt.Println("╠═══════════════════════════════════════════════════════╣")
-	fmt.Printf("║ Total Requests:    %-30d ║\n", total)
-	fmt.Printf("║ Successful:        %-15d (%.2f%%)      ║\n", success, float64(success)/float64(total)*100)
-	fmt.Printf("║ Failed:            %-15d (%.2f%%)      ║\n", failure, float64(failure)/float64(total)*100)
-	fmt.Println("╠═══════════════════════════════════════════════════════╣")
-	fmt.Printf("║ Latency (ms):                                         ║\n")
-	fmt.Printf("║   Min:             %-30d ║\n", minLatency)
-	fmt.Printf("║   P50:             %-30d ║\n", p50)
-	fmt.Printf("║   P95:             %-30d ║\n", p95)
-	fmt.Printf("║   P99:             %-30d ║\n", p99)
-	fmt.Printf("║   Max:             %-30d ║\n", maxLatency)
+	latency := s.Latencies()
+
+	fmt.Fprintln(reportOutput, "\n╔═══════════════════════════════════════════════════════╗")
+	fmt.Fprintln(reportOutput, "║          LOAD TEST RESULTS                            ║")
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintf(reportOutput, "║ Total Requests:    %-30d ║\n", total)
+	fmt.Fprintf(reportOutput, "║ Successful:        %-15d (%.2f%%)      ║\n", success, float64(success)/float64(total)*100)
+	fmt.Fprintf(reportOutput, "║ Failed:            %-15d (%.2f%%)      ║\n", failure, float64(failure)/float64(total)*100)
+	if dropped := atomic.LoadInt64(&s.Dropped); dropped > 0 {
+		fmt.Fprintf(reportOutput, "║ Dropped:           %-30d ║\n", dropped)
+	}
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintf(reportOutput, "║ Latency (ms):                                         ║\n")
+	fmt.Fprintf(reportOutput, "║   Min:             %-30d ║\n", latency.Min)
+	fmt.Fprintf(reportOutput, "║   P50:             %-30d ║\n", latency.P50)
+	fmt.Fprintf(reportOutput, "║   P95:             %-30d ║\n", latency.P95)
+	fmt.Fprintf(reportOutput, "║   P99:             %-30d ║\n", latency.P99)
+	fmt.Fprintf(reportOutput, "║   Max:             %-30d ║\n", latency.Max)
 	if total > 0 {
-		fmt.Printf("║   Average:         %-30d ║\n", totalLatency/total)
+		fmt.Fprintf(reportOutput, "║   Average:         %-30d ║\n", latency.Avg)
 	}
-	fmt.Println("╠═══════════════════════════════════════════════════════╣")
-	fmt.Println("║ Status Code Distribution:                            ║")
-	s.mu.Lock()
-	for code, count := range s.StatusCodes {
-		fmt.Printf("║   %d: %-15d (%.2f%%)                   ║\n", code, count, float64(count)/float64(total)*100)
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintln(reportOutput, "║ Status Code Distribution:                            ║")
+	for code, count := range s.StatusCodes() {
+		fmt.Fprintf(reportOutput, "║   %d: %-15d (%.2f%%)                   ║\n", code, count, float64(count)/float64(total)*100)
 	}
-	s.mu.Unlock()
-	fmt.Println("╠═══════════════════════════════════════════════════════╣")
-	fmt.Printf("║ Total Duration:    %-30v ║\n", duration)
-	fmt.Printf("║ Requests/sec:      %-30.2f ║\n", float64(total)/duration.Seconds())
-	fmt.Println("╚═══════════════════════════════════════════════════════╝")
+	fmt.Fprintln(reportOutput, "╠═══════════════════════════════════════════════════════╣")
+	fmt.Fprintf(reportOutput, "║ Total Duration:    %-30v ║\n", duration)
+	fmt.Fprintf(reportOutput, "║ Requests/sec:      %-30.2f ║\n", float64(total)/duration.Seconds())
+	fmt.Fprintln(reportOutput, "╚═══════════════════════════════════════════════════════╝")
 }
 
 // Test Scenario 1: Normal Load
 func normalLoadScenario(config LoadTestConfig) *LoadTestStats {
 	fmt.Println("\n🔥 Starting Test Scenario: NORMAL LOAD")
-	fmt.Printf("   Requests: %d | Concurrency: %d\n", config.TotalRequests, config.Concurrency)
+	if config.Duration > 0 {
+		fmt.Printf("   Duration: %v | Concurrency: %d\n", config.Duration, config.Concurrency)
+	} else {
+		fmt.Printf("   Requests: %d | Concurrency: %d\n", config.TotalRequests, config.Concurrency)
+	}
 
-	stats := &LoadTestStats{StatusCodes: make(map[int]int64)}
+	stats := config.stats()
 	startTime := time.Now()
 
 	sem := make(chan struct{}, config.Concurrency)
 	var wg sync.WaitGroup
 
-	for i := 1; i <= config.TotalRequests; i++ {
+	var deadline time.Time
+	if config.Duration > 0 {
+		deadline = startTime.Add(config.Duration)
+	}
+	rampUp := time.Duration(config.RampUpDurationSec) * time.Second
+
+	for i := 1; config.TotalRequests == 0 || i <= config.TotalRequests; i++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
 		wg.Add(1)
 		sem <- struct{}{}
 
 		go func(reqNum int) {
 			defer func() { <-sem }()
-			sendPaymentRequest(config.BaseURL, reqNum, stats, &wg)
+			sendPaymentRequest(config.BaseURL, config.RequestOffset+reqNum, stats, &wg)
 		}(i)
 
-		// Gradual ramp-up
-		if i <= config.TotalRequests/10 {
+		// Gradual ramp-up: over the ramp-up period when one is set, otherwise over the
+		// first tenth of the requests
+		if rampUp > 0 && time.Since(startTime) < rampUp || rampUp == 0 && i <= config.TotalRequests/10 {
 			time.Sleep(100 * time.Millisecond)
 		} else {
 			time.Sleep(5000 * time.Microsecond)
@@ -171,12 +179,13 @@ func normalLoadScenario(config LoadTestConfig) *LoadTestStats {
 
 	wg.Wait()
 	duration := time.Since(startTime)
+	stats.Elapsed = duration
 	stats.PrintStats(duration)
 	return stats
 }
 
 // Test Scenario 2: Circuit Breaker Test
-func circuitBreakerTest(config LoadTestConfig) {
+func circuitBreakerTest(config LoadTestConfig) *LoadTestStats {
 	fmt.Println("\n🔌 Starting Test Scenario: CIRCUIT BREAKER TRIGGER")
 
 	// Step 1: Configure gateway to fail
@@ -185,7 +194,8 @@ func circuitBreakerTest(config LoadTestConfig) {
 
 	// Step 2: Send requests to trigger circuit breaker
 	fmt.Println("   Sending 15 requests to trigger circuit breaker...")
-	stats := &LoadTestStats{StatusCodes: make(map[int]int64)}
+	stats := NewLoadTestStats()
+	startTime := time.Now()
 	var wg sync.WaitGroup
 
 	for i := 1; i <= 15; i++ {
@@ -195,13 +205,14 @@ func circuitBreakerTest(config LoadTestConfig) {
 	}
 
 	wg.Wait()
+	stats.Elapsed = time.Since(startTime)
 
 	// Step 3: Check circuit breaker state
 	fmt.Println("   Checking circuit breaker state...")
 	resp, err := http.Get(config.BaseURL + "/metrics")
 	if err != nil {
 		fmt.Printf("   ⚠️  Failed to get metrics: %v\n", err)
-		return
+		return stats
 	}
 	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
@@ -209,7 +220,7 @@ func circuitBreakerTest(config LoadTestConfig) {
 	var metrics map[string]interface{}
 	if err := json.Unmarshal(body, &metrics); err != nil {
 		fmt.Printf("   ⚠️  Failed to parse metrics: %v\n", err)
-		return
+		return stats
 	}
 
 	fmt.Println("\n   Circuit Breaker Status:")
@@ -236,14 +247,15 @@ func circuitBreakerTest(config LoadTestConfig) {
 	// Step 4: Reset gateway
 	fmt.Println("\n   Resetting gateway to normal...")
 	configGateway(config.BaseURL, "test1", 100, 0.1, "Normal operation", 200, "json")
+	return stats
 }
 
 // Test Scenario 3: Rate Limiting Test
-func rateLimitTest(config LoadTestConfig) {
+func rateLimitTest(config LoadTestConfig) *LoadTestStats {
 	fmt.Println("\n🚦 Starting Test Scenario: RATE LIMIT TEST")
 	fmt.Println("   Sending 150 requests (quota: 100/min)")
 
-	stats := &LoadTestStats{StatusCodes: make(map[int]int64)}
+	stats := NewLoadTestStats()
 	startTime := time.Now()
 
 	for i := 1; i <= 150; i++ {
@@ -269,7 +281,9 @@ func rateLimitTest(config LoadTestConfig) {
 	}
 
 	duration := time.Since(startTime)
+	stats.Elapsed = duration
 	stats.PrintStats(duration)
+	return stats
 }
 
 // Test Scenario 4: High-Value Compliance Test
@@ -294,40 +308,31 @@ func complianceTest(config LoadTestConfig) {
 
 func sendPaymentRequest(baseURL string, requestNum int, stats *LoadTestStats, wg *sync.WaitGroup) {
 	defer wg.Done()
+	sendPayment(baseURL, requestNum, stats, time.Time{})
+}
 
-	// Get payment key
-	keyReq := map[string]interface{}{
-		"id":     fmt.Sprintf("order_%d", requestNum),
-		"amount": 1000 + requestNum,
-	}
-	reqBody, _ := json.Marshal(keyReq)
-	resp, err := http.Post(baseURL+"/paymentKey", "application/json", bytes.NewBuffer(reqBody))
-	if err != nil {
-		stats.RecordRequest(0, 0)
-		return
+// sendPayment gets a payment key and submits the payment. Latency is that of the
+// payment call, or with a scheduled time, everything since the request was due, so
+// open-model runs count time spent waiting behind a slow backend
+func sendPayment(baseURL string, requestNum int, stats *LoadTestStats, scheduled time.Time) {
+	sinceScheduled := func() time.Duration {
+		if scheduled.IsZero() {
+			return 0
+		}
+		return time.Since(scheduled)
 	}
 
-	var keyResp map[string]string
-	json.NewDecoder(resp.Body).Decode(&keyResp)
-	resp.Body.Close()
-
-	paymentID, ok := keyResp["payment_id"]
-	if !ok {
-		stats.RecordRequest(resp.StatusCode, 0)
+	paymentID, statusCode := requestPaymentKey(baseURL, requestNum)
+	if paymentID == "" {
+		stats.RecordRequest(statusCode, sinceScheduled())
 		return
 	}
 
-	// Send payment
-	paymentReq := map[string]interface{}{
-		"id":         fmt.Sprintf("order_%d", requestNum),
-		"amount":     1000 + requestNum,
-		"payment_id": paymentID,
-		"currency":   "USD",
-	}
-	reqBody, _ = json.Marshal(paymentReq)
-
 	startTime := time.Now()
-	resp, err = http.Post(baseURL+"/payment", "application/json", bytes.NewBuffer(reqBody))
+	if !scheduled.IsZero() {
+		startTime = scheduled
+	}
+	resp, err := http.Post(baseURL+"/payment", "application/json", bytes.NewBuffer(paymentBody(requestNum, paymentID)))
 	latency := time.Since(startTime)
 
 	if err != nil {
@@ -340,11 +345,48 @@ func sendPaymentRequest(baseURL string, requestNum int, stats *LoadTestStats, wg
 	stats.RecordRequest(resp.StatusCode, latency)
 }
 
+// requestPaymentKey gets the payment ID for a load test order. On failure the ID is
+// empty and the status code is the response's, or 0 when the request did not complete
+func requestPaymentKey(baseURL string, requestNum int) (string, int) {
+	keyReq := map[string]interface{}{
+		"id":       fmt.Sprintf("order_%d", requestNum),
+		"amount":   1000 + requestNum,
+		"currency": "USD",
+	}
+	reqBody, _ := json.Marshal(keyReq)
+	resp, err := http.Post(baseURL+"/paymentKey", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", 0
+	}
+	defer resp.Body.Close()
+
+	var keyResp map[string]string
+	json.NewDecoder(resp.Body).Decode(&keyResp)
+	return keyResp["payment_id"], resp.StatusCode
+}
+
+func paymentBody(requestNum int, paymentID string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":         fmt.Sprintf("order_%d", requestNum),
+		"amount":     1000 + requestNum,
+		"payment_id": paymentID,
+		"currency":   "USD",
+	})
+	return body
+}
+
 func sendSinglePayment(baseURL, orderID string, amount int64, userID string) {
 	// Get key
-	keyReq := map[string]interface{}{"id": orderID, "amount": amount}
+	keyReq := map[string]interface{}{"id": orderID, "amount": amount, "currency": "USD"}
+	if userID != "" {
+		keyReq["user_id"] = userID
+	}
 	reqBody, _ := json.Marshal(keyReq)
-	resp, _ := http.Post(baseURL+"/paymentKey", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(baseURL+"/paymentKey", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("   ⚠️  Failed to get payment key: %v\n", err)
+		return
+	}
 	var keyResp map[string]string
 	json.NewDecoder(resp.Body).Decode(&keyResp)
 	resp.Body.Close()
@@ -361,7 +403,11 @@ func sendSinglePayment(baseURL, orderID string, amount int64, userID string) {
 	}
 
 	reqBody, _ = json.Marshal(paymentReq)
-	resp, _ = http.Post(baseURL+"/payment", "application/json", bytes.NewBuffer(reqBody))
+	resp, err = http.Post(baseURL+"/payment", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("   ⚠️  Payment request failed: %v\n", err)
+		return
+	}
 	body, _ := io.ReadAll(resp.Body)
 	resp.Body.Close()
 
@@ -375,6 +421,33 @@ func configGateway(baseURL, gateway string, latency int, failureRate float64, er
 }
 
 func main() {
+	// Flags or a scenario file make the run scriptable; without them the menu is shown
+	if len(os.Args) > 1 {
+		plan, err := parseRunPlan(os.Args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadchecker: %v\n", err)
+			os.Exit(2)
+		}
+		if plan.WorkerAddr != "" {
+			if err := serveWorker(plan.WorkerAddr); err != nil {
+				fmt.Fprintf(os.Stderr, "loadchecker: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		report, err := runPlan(plan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loadchecker: %v\n", err)
+			os.Exit(1)
+		}
+		// A violated threshold fails the run, e.g. in CI
+		if !report.Passed {
+			fmt.Fprintln(os.Stderr, "loadchecker: thresholds violated")
+			os.Exit(1)
+		}
+		return
+	}
+
 	baseURL := "http://localhost:3000"
 
 	fmt.Println("╔═══════════════════════════════════════════════════════════╗")
@@ -409,7 +482,7 @@ func main() {
 	case 4:
 		complianceTest(config)
 	case 5:
-		fmt.Println("\n🚀 Running full test suite...\n")
+		fmt.Print("\n🚀 Running full test suite...\n\n")
 		normalLoadScenario(config)
 		time.Sleep(2 * time.Second)
 
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LatencySummary is a run's latency distribution in milliseconds
+type LatencySummary struct {
+	Min int64 `json:"min"`
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+	Avg int64 `json:"avg"`
+}
+
+// summarize is a histogram's latency distribution in milliseconds
+func summarize(h *Histogram) LatencySummary {
+	return LatencySummary{
+		Min: h.Min().Milliseconds(),
+		P50: h.Percentile(50).Milliseconds(),
+		P95: h.Percentile(95).Milliseconds(),
+		P99: h.Percentile(99).Milliseconds(),
+		Max: h.Max().Milliseconds(),
+		Avg: h.Mean().Milliseconds(),
+	}
+}
+
+// ScenarioResult is the machine-readable outcome of one scenario
+type ScenarioResult struct {
+	Scenario       string             `json:"scenario"`
+	BaseURL        string             `json:"base_url"`
+	StartedAt      time.Time          `json:"started_at"`
+	DurationMs     int64              `json:"duration_ms"`
+	TotalRequests  int64              `json:"total_requests"`
+	Successful     int64              `json:"successful"`
+	Failed         int64              `json:"failed"`
+	Dropped        int64              `json:"dropped"`
+	ErrorRate      float64            `json:"error_rate"` // Fraction of requests that failed
+	RequestsPerSec float64            `json:"requests_per_sec"`
+	LatencyMs      LatencySummary     `json:"latency_ms"`
+	StatusCodes    map[string]int64   `json:"status_codes"`
+	Completion     *CompletionSummary `json:"completion,omitempty"` // Time to final status, e2e scenario only
+	Soak           *SoakReport        `json:"soak,omitempty"`
+	Thresholds     []ThresholdResult  `json:"thresholds,omitempty"`
+	Passed         bool               `json:"passed"`
+}
+
+// Result summarizes the stats of a finished run
+func (s *LoadTestStats) Result(scenario, baseURL string, startedAt time.Time) ScenarioResult {
+	total := atomic.LoadInt64(&s.TotalRequests)
+	result := ScenarioResult{
+		Scenario:      scenario,
+		BaseURL:       baseURL,
+		StartedAt:     startedAt.UTC(),
+		DurationMs:    s.Elapsed.Milliseconds(),
+		TotalRequests: total,
+		Successful:    atomic.LoadInt64(&s.SuccessCount),
+		Failed:        atomic.LoadInt64(&s.FailureCount),
+		Dropped:       atomic.LoadInt64(&s.Dropped),
+		LatencyMs:     s.Latencies(),
+		StatusCodes:   make(map[string]int64),
+		Passed:        true,
+	}
+	if total > 0 {
+		result.ErrorRate = float64(result.Failed) / float64(total)
+	}
+	if s.Elapsed > 0 {
+		result.RequestsPerSec = float64(total) / s.Elapsed.Seconds()
+	}
+
+	for code, count := range s.StatusCodes() {
+		result.StatusCodes[strconv.Itoa(code)] = count
+	}
+
+	if s.Completion != nil {
+		completion := s.Completion.Summary()
+		result.Completion = &completion
+	}
+	result.Soak = s.Soak
+	return result
+}
+
+// Threshold is a pass/fail assertion on a result, written like "p95 < 800ms" or
+// "error_rate < 1%"
+type Threshold struct {
+	Metric string
+	Op     string
+	Value  float64 // Milliseconds for latencies, a fraction for error_rate
+	Source string
+}
+
+// ThresholdResult is a threshold checked against a scenario's result
+type ThresholdResult struct {
+	Threshold string  `json:"threshold"`
+	Actual    float64 `json:"actual"`
+	Passed    bool    `json:"passed"`
+}
+
+var latencyMetrics = map[string]func(LatencySummary) int64{
+	"min": func(l LatencySummary) int64 { return l.Min },
+	"p50": func(l LatencySummary) int64 { return l.P50 },
+	"p95": func(l LatencySummary) int64 { return l.P95 },
+	"p99": func(l LatencySummary) int64 { return l.P99 },
+	"max": func(l LatencySummary) int64 { return l.Max },
+	"avg": func(l LatencySummary) int64 { return l.Avg },
+}
+
+// completionMetric is the prefix of latency metrics measured to final status instead
+// of to the submit response, e.g. "completion_p95"
+const completionMetric = "completion_"
+
+// thresholdOps are tried longest first so "<=" is not read as "<"
+var thresholdOps = []string{"<=", ">=", "<", ">"}
+
+// ParseThreshold reads a threshold. Latencies take a duration ("800ms", "2s") or plain
+// milliseconds; error_rate takes a percentage ("1%") or a fraction; rps and requests
+// take plain numbers
+func ParseThreshold(text string) (Threshold, error) {
+	t := Threshold{Source: strings.TrimSpace(text)}
+	for _, op := range thresholdOps {
+		metric, value, ok := strings.Cut(t.Source, op)
+		if !ok {
+			continue
+		}
+		t.Metric = strings.ToLower(strings.TrimSpace(metric))
+		t.Op = op
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch {
+		case latencyMetrics[strings.TrimPrefix(t.Metric, completionMetric)] != nil:
+			if d, derr := time.ParseDuration(value); derr == nil {
+				t.Value = float64(d.Milliseconds())
+			} else {
+				t.Value, err = strconv.ParseFloat(value, 64)
+			}
+		case t.Metric == "error_rate":
+			if percent, ok := strings.CutSuffix(value, "%"); ok {
+				t.Value, err = strconv.ParseFloat(strings.TrimSpace(percent), 64)
+				t.Value /= 100
+			} else {
+				t.Value, err = strconv.ParseFloat(value, 64)
+			}
+		case t.Metric == "rps" || t.Metric == "requests" || t.Metric == "stuck" || t.Metric == "leaks":
+			t.Value, err = strconv.ParseFloat(value, 64)
+		default:
+			return t, fmt.Errorf("threshold %q: unknown metric %q (want p50, p95, p99, min, max, avg, error_rate, rps, requests, stuck, leaks or completion_p50 and the like)", text, t.Metric)
+		}
+		if err != nil {
+			return t, fmt.Errorf("threshold %q: invalid value %q", text, value)
+		}
+		return t, nil
+	}
+	return t, fmt.Errorf("threshold %q: expected <metric> <op> <value>, e.g. \"p95 < 800ms\"", text)
+}
+
+// actual is the measured value of the threshold's metric. Completion metrics read 0
+// for scenarios that do not track completion
+func (t Threshold) actual(result ScenarioResult) float64 {
+	if metric, ok := latencyMetrics[t.Metric]; ok {
+		return float64(metric(result.LatencyMs))
+	}
+	if name, ok := strings.CutPrefix(t.Metric, completionMetric); ok {
+		if result.Completion == nil {
+			return 0
+		}
+		return float64(latencyMetrics[name](result.Completion.LatencyMs))
+	}
+	switch t.Metric {
+	case "stuck":
+		if result.Completion == nil {
+			return 0
+		}
+		return float64(result.Completion.Stuck)
+	case "leaks":
+		if result.Soak == nil {
+			return 0
+		}
+		return float64(result.Soak.Leaks)
+	case "error_rate":
+		return result.ErrorRate
+	case "rps":
+		return result.RequestsPerSec
+	default:
+		return float64(result.TotalRequests)
+	}
+}
+
+// Check evaluates the threshold against a result
+func (t Threshold) Check(result ScenarioResult) ThresholdResult {
+	actual := t.actual(result)
+	var passed bool
+	switch t.Op {
+	case "<":
+		passed = actual < t.Value
+	case "<=":
+		passed = actual <= t.Value
+	case ">":
+		passed = actual > t.Value
+	case ">=":
+		passed = actual >= t.Value
+	}
+	return ThresholdResult{Threshold: t.Source, Actual: actual, Passed: passed}
+}
+
+// RunReport is every scenario result of a run
+type RunReport struct {
+	StartedAt time.Time        `json:"started_at"`
+	Scenarios []ScenarioResult `json:"scenarios"`
+	Passed    bool             `json:"passed"`
+}
+
+func writeJSONReport(w io.Writer, report RunReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+var csvHeader = []string{
+	"scenario", "started_at", "duration_ms", "total_requests", "successful", "failed",
+	"dropped", "error_rate", "requests_per_sec", "min_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms",
+	"avg_ms", "status_codes", "passed",
+}
+
+// writeCSVReport writes one row per scenario. Status codes are packed into one column
+// as "200:950;503:50"
+func writeCSVReport(w io.Writer, report RunReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	for _, r := range report.Scenarios {
+		codes := make([]string, 0, len(r.StatusCodes))
+		for code, count := range r.StatusCodes {
+			codes = append(codes, fmt.Sprintf("%s:%d", code, count))
+		}
+		sort.Strings(codes)
+		writer.Write([]string{
+			r.Scenario,
+			r.StartedAt.Format(time.RFC3339),
+			strconv.FormatInt(r.DurationMs, 10),
+			strconv.FormatInt(r.TotalRequests, 10),
+			strconv.FormatInt(r.Successful, 10),
+			strconv.FormatInt(r.Failed, 10),
+			strconv.FormatInt(r.Dropped, 10),
+			strconv.FormatFloat(r.ErrorRate, 'f', 4, 64),
+			strconv.FormatFloat(r.RequestsPerSec, 'f', 2, 64),
+			strconv.FormatInt(r.LatencyMs.Min, 10),
+			strconv.FormatInt(r.LatencyMs.P50, 10),
+			strconv.FormatInt(r.LatencyMs.P95, 10),
+			strconv.FormatInt(r.LatencyMs.P99, 10),
+			strconv.FormatInt(r.LatencyMs.Max, 10),
+			strconv.FormatInt(r.LatencyMs.Avg, 10),
+			strings.Join(codes, ";"),
+			strconv.FormatBool(r.Passed),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// appendHistory adds each result as a JSON line to the history file, so runs can be
+// compared over time
+func appendHistory(path string, results []ScenarioResult) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printThresholds reports each checked threshold of a scenario
+func printThresholds(w io.Writer, result ScenarioResult) {
+	if len(result.Thresholds) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n   Thresholds (%s):\n", result.Scenario)
+	for _, t := range result.Thresholds {
+		mark := "✅"
+		if !t.Passed {
+			mark = "❌"
+		}
+		fmt.Fprintf(w, "     %s %-24s actual %.4g\n", mark, t.Threshold, t.Actual)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The soak scenario holds a moderate constant load for hours and samples the
+// backend's /metrics as it goes, to find resources that grow with time rather than
+// with load: goroutines, heap, Redis keys and WebSocket connections
+
+const (
+	// defaultSoakRPS is the soak rate when none is given
+	defaultSoakRPS = 10
+	// defaultSampleEvery is the longest default gap between samples; short runs sample
+	// more often so there are enough points to judge a trend
+	defaultSampleEvery = 30 * time.Second
+	// soakWarmupShare of the run is left out of leak detection, as caches and pools
+	// fill up at the start
+	soakWarmupShare = 0.1
+	// minTrendSamples is the fewest samples after warmup a trend is judged on
+	minTrendSamples = 8
+	// leakMinGrowth is the growth from the first to the last quarter of the run, as a
+	// fraction, below which a metric is considered flat
+	leakMinGrowth = 0.1
+)
+
+// SoakSample is one point of the soak time series. Requests, failures and p95 cover
+// the interval since the previous sample
+type SoakSample struct {
+	At         time.Time          `json:"at"`
+	ElapsedSec float64            `json:"elapsed_sec"`
+	Requests   int64              `json:"requests"`
+	Failed     int64              `json:"failed"`
+	P95Ms      int64              `json:"p95_ms"`
+	Backend    map[string]float64 `json:"backend,omitempty"` // Missing when /metrics could not be read
+}
+
+// Trend is how one backend metric moved over the run after warmup
+type Trend struct {
+	Metric    string  `json:"metric"`
+	First     float64 `json:"first"` // Mean of the first quarter
+	Last      float64 `json:"last"`  // Mean of the last quarter
+	GrowthPct float64 `json:"growth_pct"`
+	PerHour   float64 `json:"per_hour"` // Least-squares slope
+	Rising    float64 `json:"rising"`   // Share of intervals in which it grew
+	Leak      bool    `json:"leak"`
+}
+
+// SoakReport is the soak scenario's time series and the trends found in it
+type SoakReport struct {
+	SampleEverySec float64      `json:"sample_every_sec"`
+	Samples        []SoakSample `json:"samples"`
+	Trends         []Trend      `json:"trends"`
+	Leaks          int          `json:"leaks"`
+}
+
+// backendMetrics reads the resource metrics of the backend's /metrics. The runtime
+// section is flattened; websocket_clients is top-level
+func backendMetrics(baseURL string) (map[string]float64, error) {
+	resp, err := http.Get(baseURL + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/metrics returned %s", resp.Status)
+	}
+
+	var body struct {
+		WebsocketClients *float64           `json:"websocket_clients"`
+		Runtime          map[string]float64 `json:"runtime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parse /metrics: %w", err)
+	}
+	metrics := make(map[string]float64, len(body.Runtime)+1)
+	for name, value := range body.Runtime {
+		// num_gc only ever counts up
+		if name != "num_gc" {
+			metrics[name] = value
+		}
+	}
+	if body.WebsocketClients != nil {
+		metrics["websocket_clients"] = *body.WebsocketClients
+	}
+	return metrics, nil
+}
+
+// soakScenario runs constant-rate load for the run's duration, sampling as it goes,
+// then reports the time series and flags backend metrics that kept growing
+func soakScenario(config LoadTestConfig) *LoadTestStats {
+	rps := config.Arrival.RPS
+	if rps <= 0 {
+		rps = defaultSoakRPS
+	}
+	every := config.SampleEvery
+	if every <= 0 {
+		every = min(defaultSampleEvery, max(config.Duration/20, time.Second))
+	}
+	fmt.Println("\n🕰️  Starting Test Scenario: SOAK")
+	fmt.Printf("   Rate: %.1f/s | Duration: %v | Sample every: %v\n", rps, config.Duration, every)
+
+	stats := config.stats()
+	load := config
+	load.Stats = stats
+	load.Arrival = ArrivalProfile{RPS: rps, MaxInFlight: config.Arrival.MaxInFlight}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		openModelScenario(load)
+	}()
+
+	report := &SoakReport{SampleEverySec: every.Seconds()}
+	startTime := time.Now()
+	prevLatency := stats.Latency.Snapshot()
+	var prevTotal, prevFailed int64
+	scrapeFailed := false
+	sample := func() {
+		latency := stats.Latency.Snapshot()
+		total := atomic.LoadInt64(&stats.TotalRequests)
+		failed := atomic.LoadInt64(&stats.FailureCount)
+		s := SoakSample{
+			At:         time.Now().UTC(),
+			ElapsedSec: time.Since(startTime).Seconds(),
+			Requests:   total - prevTotal,
+			Failed:     failed - prevFailed,
+			P95Ms:      latency.Since(prevLatency).Percentile(95).Milliseconds(),
+		}
+		prevLatency, prevTotal, prevFailed = latency, total, failed
+
+		metrics, err := backendMetrics(config.BaseURL)
+		if err != nil && !scrapeFailed {
+			fmt.Printf("   ⚠️  Can't sample backend metrics: %v\n", err)
+		}
+		scrapeFailed = err != nil
+		s.Backend = metrics
+		report.Samples = append(report.Samples, s)
+
+		fmt.Printf("   [%v] %d req | %d failed | p95 %dms%s\n",
+			time.Duration(s.ElapsedSec*float64(time.Second)).Round(time.Second), s.Requests, s.Failed, s.P95Ms, formatBackend(metrics))
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ticker.C:
+			sample()
+		}
+	}
+	sample()
+
+	report.Trends = soakTrends(report.Samples, config.Duration)
+	for _, trend := range report.Trends {
+		if trend.Leak {
+			report.Leaks++
+		}
+	}
+	stats.Soak = report
+	printSoakReport(report)
+	return stats
+}
+
+// formatBackend is the progress line's summary of the backend's resource metrics
+func formatBackend(metrics map[string]float64) string {
+	var out string
+	if v, ok := metrics["goroutines"]; ok {
+		out += fmt.Sprintf(" | goroutines %.0f", v)
+	}
+	if v, ok := metrics["heap_alloc_bytes"]; ok {
+		out += fmt.Sprintf(" | heap %.1fMB", v/(1<<20))
+	}
+	if v, ok := metrics["redis_keys"]; ok {
+		out += fmt.Sprintf(" | redis keys %.0f", v)
+	}
+	if v, ok := metrics["websocket_clients"]; ok {
+		out += fmt.Sprintf(" | ws %.0f", v)
+	}
+	return out
+}
+
+// soakTrends judges each backend metric over the samples after warmup. A metric leaks
+// when it grew by leakMinGrowth or more and everything in the last quarter of the run
+// is above everything in the first, so a sawtooth like the GC heap isn't flagged
+func soakTrends(samples []SoakSample, duration time.Duration) []Trend {
+	warmup := duration.Seconds() * soakWarmupShare
+	series := make(map[string][][2]float64)
+	for _, s := range samples {
+		if s.ElapsedSec < warmup {
+			continue
+		}
+		for name, value := range s.Backend {
+			series[name] = append(series[name], [2]float64{s.ElapsedSec, value})
+		}
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var trends []Trend
+	for _, name := range names {
+		points := series[name]
+		if len(points) < minTrendSamples {
+			continue
+		}
+		quarter := len(points) / 4
+		first, last := points[:quarter], points[len(points)-quarter:]
+
+		trend := Trend{Metric: name, First: meanOf(first), Last: meanOf(last), PerHour: slopeOf(points) * 3600}
+		trend.GrowthPct = (trend.Last - trend.First) / max(trend.First, 1) * 100
+		var rising int
+		for i := 1; i < len(points); i++ {
+			if points[i][1] > points[i-1][1] {
+				rising++
+			}
+		}
+		trend.Rising = float64(rising) / float64(len(points)-1)
+		trend.Leak = trend.GrowthPct >= leakMinGrowth*100 && minOf(last) > maxOf(first)
+		trends = append(trends, trend)
+	}
+	return trends
+}
+
+func meanOf(points [][2]float64) float64 {
+	var sum float64
+	for _, p := range points {
+		sum += p[1]
+	}
+	return sum / float64(len(points))
+}
+
+func minOf(points [][2]float64) float64 {
+	lowest := points[0][1]
+	for _, p := range points {
+		lowest = min(lowest, p[1])
+	}
+	return lowest
+}
+
+func maxOf(points [][2]float64) float64 {
+	highest := points[0][1]
+	for _, p := range points {
+		highest = max(highest, p[1])
+	}
+	return highest
+}
+
+// slopeOf is the least-squares slope of the points, per second
+func slopeOf(points [][2]float64) float64 {
+	n := float64(len(points))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		sumX += p[0]
+		sumY += p[1]
+		sumXY += p[0] * p[1]
+		sumXX += p[0] * p[0]
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+func printSoakReport(report *SoakReport) {
+	fmt.Fprintln(reportOutput, "\n   Resource trends (after warmup):")
+	if len(report.Trends) == 0 {
+		fmt.Fprintf(reportOutput, "     Not enough backend samples to judge trends (need %d after warmup)\n", minTrendSamples)
+		return
+	}
+	for _, trend := range report.Trends {
+		mark := "✅"
+		verdict := ""
+		if trend.Leak {
+			mark = "❌"
+			verdict = "  possible leak"
+		}
+		fmt.Fprintf(reportOutput, "     %s %-18s %12.0f → %-12.0f %+7.1f%%  %+.1f/h  rising %.0f%%%s\n",
+			mark, trend.Metric, trend.First, trend.Last, trend.GrowthPct, trend.PerHour, trend.Rising*100, verdict)
+	}
+}
+
+// writeTimeSeries writes the soak samples of every scenario to a CSV file, one row per
+// sample with a column per backend metric
+func writeTimeSeries(path string, results []ScenarioResult) error {
+	metricSet := make(map[string]bool)
+	for _, r := range results {
+		if r.Soak == nil {
+			continue
+		}
+		for _, s := range r.Soak.Samples {
+			for name := range s.Backend {
+				metricSet[name] = true
+			}
+		}
+	}
+	metrics := make([]string, 0, len(metricSet))
+	for name := range metricSet {
+		metrics = append(metrics, name)
+	}
+	sort.Strings(metrics)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write(append([]string{"scenario", "at", "elapsed_sec", "requests", "failed", "p95_ms"}, metrics...))
+	for _, r := range results {
+		if r.Soak == nil {
+			continue
+		}
+		for _, s := range r.Soak.Samples {
+			row := []string{
+				r.Scenario,
+				s.At.Format(time.RFC3339),
+				strconv.FormatFloat(s.ElapsedSec, 'f', 1, 64),
+				strconv.FormatInt(s.Requests, 10),
+				strconv.FormatInt(s.Failed, 10),
+				strconv.FormatInt(s.P95Ms, 10),
+			}
+			for _, name := range metrics {
+				value, ok := s.Backend[name]
+				if !ok {
+					row = append(row, "")
+					continue
+				}
+				row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+			}
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
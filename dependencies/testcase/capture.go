@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// CAPTURE & REPLAY
+// ============================================================================
+
+// Exchange is one recorded gateway request and the response the simulator gave it.
+// Connection resets and panics have no response and are recorded as such so replay
+// reproduces them too
+type Exchange struct {
+	Gateway         string              `json:"gateway"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body"`
+	StatusCode      int                 `json:"status_code"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body"`
+	ConnectionReset bool                `json:"connection_reset,omitempty"`
+	Panicked        bool                `json:"panicked,omitempty"`
+	LatencyMs       int64               `json:"latency_ms"`
+	RecordedAt      time.Time           `json:"recorded_at"`
+}
+
+const (
+	RecordingOff    = "off"
+	RecordingRecord = "record"
+	RecordingReplay = "replay"
+)
+
+// recorder captures gateway traffic to a directory, one JSON file per exchange, and
+// serves it back in replay mode. Replay first looks for an unused exchange with the
+// same method, path and body, then falls back to the next unused exchange for the
+// method and path in recorded order, so a backend whose payloads carry fresh IDs on
+// every run still gets the same sequence of responses
+type recorder struct {
+	mu            sync.Mutex
+	mode          string
+	dir           string
+	replayLatency bool
+	seq           int
+	recorded      int
+	exchanges     []*Exchange
+	used          []bool
+	replayed      int
+	misses        int
+}
+
+var sessionRecorder = &recorder{mode: RecordingOff}
+
+// unrecordedRoutes are simulator endpoints rather than gateway traffic
+var unrecordedRoutes = map[string]bool{"control": true, "health": true}
+
+// recordHeaderSkip are headers that must not be written to disk or that replay sets
+// from the live request instead
+var recordHeaderSkip = map[string]bool{
+	"Authorization":    true,
+	"Date":             true,
+	"Content-Length":   true,
+	"X-Correlation-Id": true,
+	"X-Payment-Id":     true,
+}
+
+func copyRecordHeaders(h http.Header) map[string][]string {
+	headers := make(map[string][]string)
+	for k, v := range h {
+		if !recordHeaderSkip[k] {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+// Configure switches modes. Record mode creates the directory and appends after any
+// exchanges already in it; replay mode loads every exchange in it, sorted by file name
+func (rec *recorder) Configure(mode, dir string, replayLatency bool) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	switch mode {
+	case RecordingOff:
+	case RecordingRecord, RecordingReplay:
+		if dir == "" {
+			return fmt.Errorf("dir is required for %s mode", mode)
+		}
+	default:
+		return fmt.Errorf("mode must be one of off, record, replay")
+	}
+
+	var exchanges []*Exchange
+	seq := 0
+	if mode != RecordingOff {
+		if mode == RecordingRecord {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("create %s: %w", dir, err)
+			}
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+		seq = len(files)
+		if mode == RecordingReplay {
+			if len(files) == 0 {
+				return fmt.Errorf("no recordings in %s", dir)
+			}
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				var exchange Exchange
+				if err := json.Unmarshal(data, &exchange); err != nil {
+					return fmt.Errorf("parse %s: %w", file, err)
+				}
+				exchanges = append(exchanges, &exchange)
+			}
+		}
+	}
+
+	rec.mode = mode
+	rec.dir = dir
+	rec.replayLatency = replayLatency
+	rec.seq = seq
+	rec.recorded = 0
+	rec.exchanges = exchanges
+	rec.used = make([]bool, len(exchanges))
+	rec.replayed = 0
+	rec.misses = 0
+	return nil
+}
+
+func (rec *recorder) Mode() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.mode
+}
+
+func (rec *recorder) Status() map[string]interface{} {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return map[string]interface{}{
+		"mode":           rec.mode,
+		"dir":            rec.dir,
+		"replay_latency": rec.replayLatency,
+		"recorded":       rec.recorded,
+		"loaded":         len(rec.exchanges),
+		"replayed":       rec.replayed,
+		"misses":         rec.misses,
+	}
+}
+
+// Save writes an exchange to the next numbered file in the recording directory
+func (rec *recorder) Save(exchange *Exchange) {
+	rec.mu.Lock()
+	if rec.mode != RecordingRecord {
+		rec.mu.Unlock()
+		return
+	}
+	rec.seq++
+	file := filepath.Join(rec.dir, fmt.Sprintf("%06d-%s.json", rec.seq, exchange.Gateway))
+	rec.recorded++
+	rec.mu.Unlock()
+
+	data, _ := json.MarshalIndent(exchange, "", "  ")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		log.Printf("[RECORD] Failed to write %s: %v", file, err)
+	}
+}
+
+// Match claims the recorded exchange to replay for a request
+func (rec *recorder) Match(method, path, body string) (*Exchange, bool, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	fallback := -1
+	for i, exchange := range rec.exchanges {
+		if rec.used[i] || exchange.Method != method || exchange.Path != path {
+			continue
+		}
+		if exchange.RequestBody == body {
+			fallback = i
+			break
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	if fallback < 0 {
+		rec.misses++
+		return nil, false, false
+	}
+	rec.used[fallback] = true
+	rec.replayed++
+	return rec.exchanges[fallback], rec.replayLatency, true
+}
+
+// recordingWriter tees the response into a buffer. It passes hijacking and flushing
+// through so connection-reset and slow-response simulation still work
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	hijacked bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("webserver doesn't support hijacking")
+	}
+	rw.hijacked = true
+	return hj.Hijack()
+}
+
+// recordingHandler records or replays gateway traffic according to the recorder's mode
+func recordingHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mode := sessionRecorder.Mode()
+		gateway, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if mode == RecordingOff || unrecordedRoutes[gateway] {
+			next(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+
+		if mode == RecordingReplay {
+			replayExchange(w, r, string(body))
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rw := &recordingWriter{ResponseWriter: w}
+		exchange := &Exchange{
+			Gateway:        gateway,
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          r.URL.RawQuery,
+			RequestHeaders: copyRecordHeaders(r.Header),
+			RequestBody:    string(body),
+			RecordedAt:     time.Now().UTC(),
+		}
+		start := time.Now()
+
+		// A simulated panic is recorded before net/http recovers it
+		defer func() {
+			if p := recover(); p != nil {
+				exchange.Panicked = true
+				exchange.LatencyMs = time.Since(start).Milliseconds()
+				sessionRecorder.Save(exchange)
+				panic(p)
+			}
+		}()
+
+		next(rw, r)
+
+		exchange.LatencyMs = time.Since(start).Milliseconds()
+		exchange.ConnectionReset = rw.hijacked
+		exchange.StatusCode = rw.status
+		exchange.ResponseHeaders = copyRecordHeaders(w.Header())
+		exchange.ResponseBody = rw.body.String()
+		sessionRecorder.Save(exchange)
+	}
+}
+
+// replayExchange answers a request from the recording instead of the live handlers
+func replayExchange(w http.ResponseWriter, r *http.Request, body string) {
+	exchange, withLatency, ok := sessionRecorder.Match(r.Method, r.URL.Path, body)
+	if !ok {
+		log.Printf("[REPLAY] No recording left for %s %s", r.Method, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "failed",
+			"error":  fmt.Sprintf("no recording for %s %s", r.Method, r.URL.Path),
+		})
+		return
+	}
+
+	if withLatency {
+		time.Sleep(time.Duration(exchange.LatencyMs) * time.Millisecond)
+	}
+
+	switch {
+	case exchange.Panicked:
+		panic(fmt.Sprintf("Replayed panic in %s", exchange.Gateway))
+	case exchange.ConnectionReset:
+		simulateError(w, ErrConnectionReset, 0, "REPLAY")
+		return
+	}
+
+	for k, v := range exchange.ResponseHeaders {
+		w.Header()[k] = v
+	}
+	if exchange.StatusCode != 0 {
+		w.WriteHeader(exchange.StatusCode)
+	}
+	w.Write([]byte(exchange.ResponseBody))
+}
+
+// recordingControlHandler handles /control/recording: POST switches mode, GET reports it
+func recordingControlHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Mode          string `json:"mode"`
+			Dir           string `json:"dir"`
+			ReplayLatency bool   `json:"replay_latency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if err := sessionRecorder.Configure(req.Mode, req.Dir, req.ReplayLatency); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[RECORD] Mode set to %s (dir=%s)", req.Mode, req.Dir)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":   true,
+			"recording": sessionRecorder.Status(),
+		})
+
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"recording": sessionRecorder.Status()})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// CHAOS SCENARIOS
+// ============================================================================
+
+// ScenarioStep overrides a gateway's settings for a window of a scenario. Omitted
+// settings keep the gateway's value from when the scenario started
+type ScenarioStep struct {
+	AtSeconds       float64              `json:"at_seconds"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Gateway         string               `json:"gateway"`
+	LatencyMs       *int                 `json:"latency_ms,omitempty"`
+	LatencyDist     *LatencyDistribution `json:"latency_distribution,omitempty"`
+	ErrorRate       *float64             `json:"error_rate,omitempty"`
+	RateLimit       *int                 `json:"rate_limit,omitempty"`
+	ErrorType       ErrorCode            `json:"error_type,omitempty"`
+	StatusCode      *int                 `json:"status_code,omitempty"`
+}
+
+// Scenario is a timed chaos script, e.g. 50% 503s on stripe for two minutes, then
+// connection resets, then recovery. Steps may overlap; later steps win
+type Scenario struct {
+	Name  string         `json:"name"`
+	Loop  bool           `json:"loop"` // Restart from the beginning once the last step ends
+	Steps []ScenarioStep `json:"steps"`
+}
+
+// gatewaySettings are the tunable parts of a GatewayConfig
+type gatewaySettings struct {
+	LatencyMs   int
+	LatencyDist LatencyDistribution
+	ErrorRate   float64
+	RateLimit   int
+	ErrorType   ErrorCode
+	StatusCode  int
+}
+
+func (gc *GatewayConfig) settings() gatewaySettings {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+	return gatewaySettings{gc.LatencyMs, gc.LatencyDist, gc.ErrorRate, gc.RateLimit, gc.ErrorType, gc.StatusCode}
+}
+
+func (gc *GatewayConfig) applySettings(settings gatewaySettings) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.LatencyMs = settings.LatencyMs
+	gc.LatencyDist = settings.LatencyDist
+	gc.ErrorRate = settings.ErrorRate
+	gc.RateLimit = settings.RateLimit
+	gc.ErrorType = settings.ErrorType
+	gc.StatusCode = settings.StatusCode
+}
+
+// scenarioTick is how often the running scenario re-evaluates its steps
+const scenarioTick = 100 * time.Millisecond
+
+// scenarioRun is the scenario currently executing. baseline holds the settings of
+// every gateway the scenario touches, restored when a step ends and when it stops
+type scenarioRun struct {
+	scenario  Scenario
+	startedAt time.Time
+	length    time.Duration
+	baseline  map[string]gatewaySettings
+	active    []int
+	stop      chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+var (
+	currentScenario *scenarioRun
+	scenarioMu      sync.Mutex
+)
+
+func validateScenario(scenario *Scenario) error {
+	if len(scenario.Steps) == 0 {
+		return fmt.Errorf("scenario has no steps")
+	}
+	gatewaysMu.RLock()
+	defer gatewaysMu.RUnlock()
+	for i, step := range scenario.Steps {
+		if _, exists := gateways[step.Gateway]; !exists {
+			return fmt.Errorf("step %d: unknown gateway %q", i, step.Gateway)
+		}
+		if step.AtSeconds < 0 || step.DurationSeconds <= 0 {
+			return fmt.Errorf("step %d: at_seconds must be >= 0 and duration_seconds > 0", i)
+		}
+		if step.ErrorRate != nil && (*step.ErrorRate < 0 || *step.ErrorRate > 1) {
+			return fmt.Errorf("step %d: error_rate must be between 0 and 1", i)
+		}
+		if step.LatencyDist != nil {
+			if err := step.LatencyDist.Validate(); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// startScenario stops any running scenario, then runs this one from t=0
+func startScenario(scenario Scenario) *scenarioRun {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+	if currentScenario != nil {
+		currentScenario.halt()
+	}
+
+	run := &scenarioRun{
+		scenario:  scenario,
+		startedAt: time.Now(),
+		baseline:  make(map[string]gatewaySettings),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	gatewaysMu.RLock()
+	for _, step := range scenario.Steps {
+		end := time.Duration((step.AtSeconds + step.DurationSeconds) * float64(time.Second))
+		if end > run.length {
+			run.length = end
+		}
+		if _, seen := run.baseline[step.Gateway]; !seen {
+			run.baseline[step.Gateway] = gateways[step.Gateway].settings()
+		}
+	}
+	gatewaysMu.RUnlock()
+
+	currentScenario = run
+	go run.execute()
+	log.Printf("[SCENARIO] Started %q: %d steps over %s", scenario.Name, len(scenario.Steps), run.length)
+	return run
+}
+
+// execute applies the steps active at each tick until the scenario ends or is stopped,
+// then restores the baseline
+func (run *scenarioRun) execute() {
+	defer close(run.done)
+	ticker := time.NewTicker(scenarioTick)
+	defer ticker.Stop()
+
+	applied := make(map[string]gatewaySettings)
+	for {
+		elapsed := time.Since(run.startedAt)
+		if run.scenario.Loop && run.length > 0 {
+			elapsed %= run.length
+		} else if elapsed >= run.length {
+			break
+		}
+
+		desired := make(map[string]gatewaySettings, len(run.baseline))
+		for name, settings := range run.baseline {
+			desired[name] = settings
+		}
+		active := make([]int, 0)
+		for i, step := range run.scenario.Steps {
+			start := time.Duration(step.AtSeconds * float64(time.Second))
+			end := start + time.Duration(step.DurationSeconds*float64(time.Second))
+			if elapsed < start || elapsed >= end {
+				continue
+			}
+			active = append(active, i)
+			settings := desired[step.Gateway]
+			if step.LatencyMs != nil {
+				settings.LatencyMs = *step.LatencyMs
+			}
+			if step.LatencyDist != nil {
+				settings.LatencyDist = *step.LatencyDist
+			}
+			if step.ErrorRate != nil {
+				settings.ErrorRate = *step.ErrorRate
+			}
+			if step.RateLimit != nil {
+				settings.RateLimit = *step.RateLimit
+			}
+			if step.ErrorType != "" {
+				settings.ErrorType = step.ErrorType
+			}
+			if step.StatusCode != nil {
+				settings.StatusCode = *step.StatusCode
+			}
+			desired[step.Gateway] = settings
+		}
+
+		run.apply(desired, applied)
+		scenarioMu.Lock()
+		run.active = active
+		scenarioMu.Unlock()
+
+		select {
+		case <-run.stop:
+			run.apply(run.baseline, applied)
+			log.Printf("[SCENARIO] Stopped %q, gateways restored", run.scenario.Name)
+			return
+		case <-ticker.C:
+		}
+	}
+
+	run.apply(run.baseline, applied)
+	scenarioMu.Lock()
+	run.active = nil
+	scenarioMu.Unlock()
+	log.Printf("[SCENARIO] Finished %q, gateways restored", run.scenario.Name)
+}
+
+// apply sets each gateway whose desired settings differ from what was last applied
+func (run *scenarioRun) apply(desired, applied map[string]gatewaySettings) {
+	gatewaysMu.RLock()
+	defer gatewaysMu.RUnlock()
+	for name, settings := range desired {
+		if current, ok := applied[name]; ok && current == settings {
+			continue
+		}
+		gateways[name].applySettings(settings)
+		applied[name] = settings
+		log.Printf("[SCENARIO] %s: latency=%dms, error_rate=%.2f%%, error_type=%s, status_code=%d",
+			name, settings.LatencyMs, settings.ErrorRate*100, settings.ErrorType, settings.StatusCode)
+	}
+}
+
+// halt stops the run and waits for its gateways to be restored. Callers hold scenarioMu,
+// which execute also takes, so it is released while waiting
+func (run *scenarioRun) halt() {
+	select {
+	case <-run.done:
+		return
+	default:
+	}
+	run.stopOnce.Do(func() { close(run.stop) })
+	scenarioMu.Unlock()
+	<-run.done
+	scenarioMu.Lock()
+}
+
+func (run *scenarioRun) status() map[string]interface{} {
+	running := true
+	select {
+	case <-run.done:
+		running = false
+	default:
+	}
+	return map[string]interface{}{
+		"name":            run.scenario.Name,
+		"running":         running,
+		"loop":            run.scenario.Loop,
+		"started_at":      run.startedAt.Format(time.RFC3339),
+		"elapsed_seconds": time.Since(run.startedAt).Seconds(),
+		"length_seconds":  run.length.Seconds(),
+		"active_steps":    run.active,
+		"steps":           run.scenario.Steps,
+	}
+}
+
+// scenarioHandler serves /control/scenario: POST loads and starts a scenario, GET
+// reports its progress and DELETE stops it. Gateways are restored to their settings
+// from before the scenario when it ends. /control changes made meanwhile to a gateway
+// the scenario touches are overwritten
+func scenarioHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var scenario Scenario
+		if err := json.NewDecoder(r.Body).Decode(&scenario); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		if err := validateScenario(&scenario); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		run := startScenario(scenario)
+		scenarioMu.Lock()
+		status := run.status()
+		scenarioMu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"message":  "Scenario started",
+			"scenario": status,
+		})
+
+	case http.MethodGet:
+		scenarioMu.Lock()
+		defer scenarioMu.Unlock()
+		if currentScenario == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"scenario": nil})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"scenario": currentScenario.status()})
+
+	case http.MethodDelete:
+		scenarioMu.Lock()
+		defer scenarioMu.Unlock()
+		if currentScenario == nil {
+			http.Error(w, "No scenario loaded", http.StatusNotFound)
+			return
+		}
+		currentScenario.halt()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"message":  "Scenario stopped",
+			"scenario": currentScenario.status(),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ============================================================================
+// SERVER CONFIGURATION
+// ============================================================================
+
+// GatewayOverrides replaces a gateway's built-in defaults. Omitted fields keep them
+type GatewayOverrides struct {
+	LatencyMs           *int                 `json:"latency_ms,omitempty"`
+	LatencyDistribution *LatencyDistribution `json:"latency_distribution,omitempty"`
+	ErrorRate           *float64             `json:"error_rate,omitempty"`
+	RateLimit           *int                 `json:"rate_limit,omitempty"`
+	ErrorType           ErrorCode            `json:"error_type,omitempty"`
+	StatusCode          *int                 `json:"status_code,omitempty"`
+	BandwidthBps        *int                 `json:"bandwidth_bps,omitempty"`
+	WebhookURL          *string              `json:"webhook_url,omitempty"`
+	WebhookSecret       *string              `json:"webhook_secret,omitempty"`
+	Async               *bool                `json:"async,omitempty"`
+}
+
+func (o GatewayOverrides) Validate() error {
+	if o.LatencyMs != nil && *o.LatencyMs < 0 {
+		return fmt.Errorf("latency_ms must not be negative")
+	}
+	if o.ErrorRate != nil && (*o.ErrorRate < 0 || *o.ErrorRate > 1) {
+		return fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if o.RateLimit != nil && *o.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	if o.BandwidthBps != nil && *o.BandwidthBps < 0 {
+		return fmt.Errorf("bandwidth_bps must not be negative")
+	}
+	if o.LatencyDistribution != nil {
+		return o.LatencyDistribution.Validate()
+	}
+	return nil
+}
+
+func (gc *GatewayConfig) applyOverrides(o GatewayOverrides) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	if o.LatencyMs != nil {
+		gc.LatencyMs = *o.LatencyMs
+	}
+	if o.LatencyDistribution != nil {
+		gc.LatencyDist = *o.LatencyDistribution
+	}
+	if o.ErrorRate != nil {
+		gc.ErrorRate = *o.ErrorRate
+	}
+	if o.RateLimit != nil {
+		gc.RateLimit = *o.RateLimit
+	}
+	if o.ErrorType != "" {
+		gc.ErrorType = o.ErrorType
+	}
+	if o.StatusCode != nil {
+		gc.StatusCode = *o.StatusCode
+	}
+	if o.BandwidthBps != nil {
+		gc.BandwidthBps = *o.BandwidthBps
+	}
+	if o.WebhookURL != nil {
+		gc.Webhook.URL = *o.WebhookURL
+	}
+	if o.WebhookSecret != nil {
+		gc.Webhook.Secret = *o.WebhookSecret
+	}
+	if o.Async != nil {
+		gc.Webhook.Async = *o.Async
+	}
+}
+
+// ServerConfig is the simulator's startup configuration, read from the JSON file
+// given with -config. Defaults apply to every enabled gateway, then each gateway's own
+// entry in Gateways on top; command-line flags override the file
+type ServerConfig struct {
+	Port      int                         `json:"port"`
+	Providers []string                    `json:"providers"` // Gateways to serve; all when empty
+	TLSCert   string                      `json:"tls_cert"`
+	TLSKey    string                      `json:"tls_key"`
+	Defaults  GatewayOverrides            `json:"defaults"`
+	Gateways  map[string]GatewayOverrides `json:"gateways"`
+}
+
+// publicURL is the simulator's own base URL, used in links it hands out
+var publicURL = "http://localhost:3001"
+
+func loadServerConfig(path string) (ServerConfig, error) {
+	config := ServerConfig{Port: 3001}
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// parseServerConfig reads the config file and applies the flags that were set over it
+func parseServerConfig(args []string) (ServerConfig, error) {
+	fs := flag.NewFlagSet("gateway_simulator", flag.ExitOnError)
+	configPath := fs.String("config", "", "JSON config file")
+	port := fs.Int("port", 3001, "port to listen on")
+	providers := fs.String("providers", "", "comma-separated gateways to enable (default all)")
+	latencyMs := fs.Int("latency-ms", 0, "latency for every enabled gateway")
+	errorRate := fs.Float64("error-rate", 0, "error rate for every enabled gateway, 0 to 1")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file; serves HTTPS with -tls-key")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	fs.Parse(args)
+
+	config, err := loadServerConfig(*configPath)
+	if err != nil {
+		return config, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Port = *port
+		case "providers":
+			config.Providers = nil
+			for _, name := range strings.Split(*providers, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					config.Providers = append(config.Providers, name)
+				}
+			}
+		case "latency-ms":
+			config.Defaults.LatencyMs = latencyMs
+		case "error-rate":
+			config.Defaults.ErrorRate = errorRate
+		case "tls-cert":
+			config.TLSCert = *tlsCert
+		case "tls-key":
+			config.TLSKey = *tlsKey
+		}
+	})
+	if config.Port <= 0 || config.Port > 65535 {
+		return config, fmt.Errorf("port %d out of range", config.Port)
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return config, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if err := config.Defaults.Validate(); err != nil {
+		return config, fmt.Errorf("defaults: %w", err)
+	}
+	for name, overrides := range config.Gateways {
+		if _, ok := gateways[name]; !ok {
+			return config, fmt.Errorf("gateways: unknown gateway %q", name)
+		}
+		if err := overrides.Validate(); err != nil {
+			return config, fmt.Errorf("gateways.%s: %w", name, err)
+		}
+	}
+	for _, name := range config.Providers {
+		if _, ok := gateways[name]; !ok {
+			return config, fmt.Errorf("providers: unknown gateway %q", name)
+		}
+	}
+	return config, nil
+}
+
+// applyServerConfig drops the gateways that are not enabled and applies the defaults
+// and per-gateway overrides to the rest. It runs before the server starts
+func applyServerConfig(config ServerConfig) {
+	if len(config.Providers) > 0 {
+		enabled := make(map[string]bool)
+		for _, name := range config.Providers {
+			enabled[name] = true
+		}
+		for name := range gateways {
+			if !enabled[name] {
+				delete(gateways, name)
+			}
+		}
+	}
+	for name, gc := range gateways {
+		gc.applyOverrides(config.Defaults)
+		if overrides, ok := config.Gateways[name]; ok {
+			gc.applyOverrides(overrides)
+		}
+	}
+
+	scheme := "http"
+	if config.TLSCert != "" {
+		scheme = "https"
+	}
+	publicURL = fmt.Sprintf("%s://localhost:%d", scheme, config.Port)
+}
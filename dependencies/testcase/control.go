@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// CONTROL API - Error Manipulation
+// ============================================================================
+
+func controlHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		gatewaysMu.RLock()
+		configs := make(map[string]interface{})
+		for name, config := range gateways {
+			config.mu.RLock()
+			entry := map[string]interface{}{
+				"name":                 config.Name,
+				"type":                 config.Type,
+				"latency_ms":           config.LatencyMs,
+				"latency_distribution": config.LatencyDist,
+				"error_rate":           config.ErrorRate,
+				"rate_limit":           config.RateLimit,
+				"error_type":           config.ErrorType,
+				"status_code":          config.StatusCode,
+				"bandwidth_bps":        config.BandwidthBps,
+				"idempotency":          idempotency.Stats(name),
+			}
+			if config.Name == "stripe" || config.Name == "razorpay" {
+				entry["webhook"] = map[string]interface{}{
+					"url":        config.Webhook.URL,
+					"delay_ms":   config.Webhook.DelayMs,
+					"duplicates": config.Webhook.Duplicates,
+					"async":      config.Webhook.Async,
+					"signed":     config.Webhook.Secret != "",
+				}
+			}
+			configs[name] = entry
+			config.mu.RUnlock()
+		}
+		gatewaysMu.RUnlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"gateways": configs,
+			"available_error_types": []string{
+				string(ErrInvalidRequest),
+				string(ErrInsufficientFunds),
+				string(ErrCardDeclined),
+				string(ErrAuthFailed),
+				string(ErrGatewayTimeout),
+				string(ErrProviderError),
+				string(ErrProviderDown),
+				string(ErrConnectionReset),
+				string(ErrConnectionTimeout),
+				string(ErrMalformedResponse),
+				string(ErrEmptyResponse),
+				string(ErrSlowResponse),
+				string(ErrInvalidJSON),
+				string(ErrRateLimited),
+				string(ErrInternalError),
+				string(ErrPanic),
+				string(ErrComplianceFailed),
+				string(ErrKYCRequired),
+				string(ErrTruncatedResponse),
+				string(ErrChunkedResponse),
+				string(ErrConnectionDrop),
+			},
+		})
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			Gateway    string    `json:"gateway"`
+			LatencyMs  int       `json:"latency_ms"`
+			ErrorRate  float64   `json:"error_rate"`
+			RateLimit  int       `json:"rate_limit"`
+			ErrorType  ErrorCode `json:"error_type"`
+			StatusCode int       `json:"status_code"`
+			// Webhook settings (stripe, razorpay); omitted fields are left unchanged
+			WebhookURL        *string `json:"webhook_url"`
+			WebhookSecret     *string `json:"webhook_secret"`
+			WebhookDelayMs    *int    `json:"webhook_delay_ms"`
+			WebhookDuplicates *int    `json:"webhook_duplicates"`
+			Async             *bool   `json:"async"`
+			// LatencyDistribution replaces the gateway's distribution when set
+			LatencyDistribution *LatencyDistribution `json:"latency_distribution"`
+			// BandwidthBps caps the gateway's response byte rate when set; 0 removes the cap
+			BandwidthBps *int `json:"bandwidth_bps"`
+			// ResetIdempotency forgets the gateway's idempotency keys and duplicate counts
+			ResetIdempotency bool `json:"reset_idempotency"`
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		defer r.Body.Close()
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		gatewaysMu.RLock()
+		config, exists := gateways[req.Gateway]
+		available := make([]string, 0, len(gateways))
+		for name := range gateways {
+			available = append(available, name)
+		}
+		gatewaysMu.RUnlock()
+
+		if !exists {
+			sort.Strings(available)
+			http.Error(w, fmt.Sprintf("Gateway '%s' not found. Available: %s", req.Gateway, strings.Join(available, ", ")), http.StatusBadRequest)
+			return
+		}
+
+		if req.LatencyDistribution != nil {
+			if err := req.LatencyDistribution.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			config.UpdateLatencyDistribution(*req.LatencyDistribution)
+		}
+		config.UpdateConfig(req.LatencyMs, req.ErrorRate, req.RateLimit, req.ErrorType, req.StatusCode)
+		config.UpdateWebhook(req.WebhookURL, req.WebhookSecret, req.WebhookDelayMs, req.WebhookDuplicates, req.Async)
+		if req.BandwidthBps != nil {
+			if *req.BandwidthBps < 0 {
+				http.Error(w, "bandwidth_bps must not be negative", http.StatusBadRequest)
+				return
+			}
+			config.UpdateBandwidth(*req.BandwidthBps)
+		}
+		if req.ResetIdempotency {
+			idempotency.Reset(req.Gateway)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Gateway configuration updated",
+			"gateway": req.Gateway,
+			"config": map[string]interface{}{
+				"latency_ms":           config.LatencyMs,
+				"latency_distribution": config.LatencyDist,
+				"error_rate":           config.ErrorRate,
+				"rate_limit":           config.RateLimit,
+				"error_type":           config.ErrorType,
+				"status_code":          config.StatusCode,
+				"bandwidth_bps":        config.BandwidthBps,
+			},
+		})
+		log.Printf("Updated %s: latency=%dms, error_rate=%.2f%%, rate_limit=%d/s, error_type=%s",
+			req.Gateway, req.LatencyMs, req.ErrorRate*100, req.RateLimit, req.ErrorType)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
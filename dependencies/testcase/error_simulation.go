@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// ERROR SIMULATION
+// ============================================================================
+
+func simulateError(w http.ResponseWriter, errorType ErrorCode, statusCode int, gatewayName string) {
+	switch errorType {
+	case ErrConnectionReset:
+		log.Printf("[%s] SIMULATING CONNECTION RESET", gatewayName)
+		dropConnection(w)
+
+	case ErrTruncatedResponse:
+		// Content-Length promises the whole body, then the connection closes short of it
+		resp := failedBody(errorType)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp[:1+rand.Intn(len(resp)-1)])
+		dropConnection(w)
+		log.Printf("[%s] FAILED (Truncated Response)", gatewayName)
+
+	case ErrChunkedResponse:
+		// The full body arrives, but in small chunks with uneven pauses between them
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		resp := failedBody(errorType)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		for len(resp) > 0 {
+			n := min(1+rand.Intn(16), len(resp))
+			w.Write(resp[:n])
+			resp = resp[n:]
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			time.Sleep(time.Duration(20+rand.Intn(300)) * time.Millisecond)
+		}
+		log.Printf("[%s] FAILED (Chunked Response)", gatewayName)
+
+	case ErrConnectionDrop:
+		// Headers and part of a chunked body go out, then the connection dies mid-stream
+		resp := failedBody(errorType)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp[:1+rand.Intn(len(resp)-1)])
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		time.Sleep(time.Duration(50+rand.Intn(500)) * time.Millisecond)
+		dropConnection(w)
+		log.Printf("[%s] FAILED (Connection Dropped Mid-Body)", gatewayName)
+
+	case ErrPanic:
+		log.Printf("[%s] SIMULATING PANIC", gatewayName)
+		panic(fmt.Sprintf("Simulated panic in %s", gatewayName))
+
+	case ErrMalformedResponse, ErrInvalidJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "failed", "error": "malformed`))
+		log.Printf("[%s] FAILED (Malformed JSON)", gatewayName)
+
+	case ErrEmptyResponse:
+		w.WriteHeader(http.StatusOK)
+		log.Printf("[%s] FAILED (Empty Response)", gatewayName)
+
+	case ErrSlowResponse:
+		w.Header().Set("Content-Type", "application/json")
+		resp := fmt.Sprintf(`{"status": "failed", "error_code": "%s"}`, errorType)
+		for i := 0; i < len(resp); i++ {
+			w.Write([]byte{resp[i]})
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		log.Printf("[%s] FAILED (Slow Response)", gatewayName)
+
+	case ErrRateLimited:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":      "failed",
+			"error":       string(errorType),
+			"message":     "Rate limit exceeded",
+			"retry_after": 60,
+		})
+		log.Printf("[%s] FAILED (Rate Limited)", gatewayName)
+
+	default:
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "failed",
+			"error":   string(errorType),
+			"message": getErrorMessage(errorType),
+		})
+		log.Printf("[%s] FAILED (%s) - Status %d", gatewayName, errorType, statusCode)
+	}
+}
+
+// dropConnection closes the client connection without finishing the response. Anything
+// already written is flushed first, so a partial body reaches the client
+func dropConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn.Close()
+}
+
+// failedBody is the standard failure body, used where the response is mangled in transit
+func failedBody(errorType ErrorCode) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"status":  "failed",
+		"error":   string(errorType),
+		"message": getErrorMessage(errorType),
+	})
+	return body
+}
+
+func getErrorMessage(errorType ErrorCode) string {
+	messages := map[ErrorCode]string{
+		ErrInsufficientFunds: "Insufficient funds in account",
+		ErrCardDeclined:      "Card was declined",
+		ErrAuthFailed:        "Authentication failed",
+		ErrGatewayTimeout:    "Gateway timeout",
+		ErrProviderError:     "Provider error occurred",
+		ErrProviderDown:      "Provider is currently down",
+		ErrConnectionTimeout: "Connection timeout",
+		ErrInternalError:     "Internal server error",
+		ErrComplianceFailed:  "Compliance check failed",
+		ErrKYCRequired:       "KYC verification required",
+		ErrTruncatedResponse: "Response truncated in transit",
+		ErrChunkedResponse:   "Response delivered in delayed chunks",
+		ErrConnectionDrop:    "Connection dropped mid-response",
+	}
+
+	if msg, ok := messages[errorType]; ok {
+		return msg
+	}
+	return string(errorType)
+}
+
+// generateDigits returns a numeric reference such as Adyen's 16-digit pspReference
+func generateDigits(length int) string {
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = byte('0' + rand.Intn(10))
+	}
+	return string(result)
+}
+
+func generateID(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = charset[rand.Intn(len(charset))]
+	}
+	return string(result)
+}
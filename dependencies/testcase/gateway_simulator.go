@@ -1,13 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
-	"strings"
+	"os"
 	"sync"
 	"time"
 )
@@ -35,6 +34,11 @@ const (
 	ErrPanic             ErrorCode = "PANIC"
 	ErrComplianceFailed  ErrorCode = "COMPLIANCE_FAILED"
 	ErrKYCRequired       ErrorCode = "KYC_REQUIRED"
+	// Network pathologies: a body shorter than its Content-Length, a body dribbled out
+	// in irregular chunks, and a connection dropped partway through the body
+	ErrTruncatedResponse ErrorCode = "TRUNCATED_RESPONSE"
+	ErrChunkedResponse   ErrorCode = "CHUNKED_RESPONSE"
+	ErrConnectionDrop    ErrorCode = "CONNECTION_DROP"
 )
 
 // GatewayConfig holds configuration for each gateway/provider
@@ -42,10 +46,13 @@ type GatewayConfig struct {
 	Name         string
 	Type         string // "provider" or "test"
 	LatencyMs    int
+	LatencyDist  LatencyDistribution
 	ErrorRate    float64
 	RateLimit    int
 	ErrorType    ErrorCode
 	StatusCode   int
+	BandwidthBps int // Caps every response to this many bytes per second; 0 is unlimited
+	Webhook      WebhookConfig
 	mu           sync.RWMutex
 	requestCount int
 	lastReset    time.Time
@@ -84,6 +91,36 @@ var (
 			StatusCode: 401,
 			lastReset:  time.Now(),
 		},
+		"paypal": {
+			Name:       "paypal",
+			Type:       "provider",
+			LatencyMs:  250,
+			ErrorRate:  0.04,
+			RateLimit:  60,
+			ErrorType:  ErrCardDeclined,
+			StatusCode: 422,
+			lastReset:  time.Now(),
+		},
+		"adyen": {
+			Name:       "adyen",
+			Type:       "provider",
+			LatencyMs:  90,
+			ErrorRate:  0.03,
+			RateLimit:  150,
+			ErrorType:  ErrInsufficientFunds,
+			StatusCode: 200,
+			lastReset:  time.Now(),
+		},
+		"braintree": {
+			Name:       "braintree",
+			Type:       "provider",
+			LatencyMs:  180,
+			ErrorRate:  0.05,
+			RateLimit:  80,
+			ErrorType:  ErrProviderError,
+			StatusCode: 503,
+			lastReset:  time.Now(),
+		},
 		"onfido": {
 			Name:       "onfido",
 			Type:       "provider",
@@ -94,6 +131,16 @@ var (
 			StatusCode: 422,
 			lastReset:  time.Now(),
 		},
+		"sanctions": {
+			Name:       "sanctions",
+			Type:       "provider",
+			LatencyMs:  150,
+			ErrorRate:  0.01,
+			RateLimit:  50,
+			ErrorType:  ErrProviderError,
+			StatusCode: 503,
+			lastReset:  time.Now(),
+		},
 		// Simple Test Gateways (like original test1, test2, test3)
 		"test1": {
 			Name:       "test1",
@@ -151,684 +198,215 @@ func (gc *GatewayConfig) UpdateConfig(latencyMs int, errorRate float64, rateLimi
 	}
 }
 
-// CheckRateLimit checks if rate limit is exceeded
-func (gc *GatewayConfig) CheckRateLimit() bool {
-	gc.mu.Lock()
-	defer gc.mu.Unlock()
-
-	if gc.RateLimit == 0 {
-		return false
-	}
-
-	now := time.Now()
-	if now.Sub(gc.lastReset) >= time.Second {
-		gc.requestCount = 0
-		gc.lastReset = now
-	}
-
-	gc.requestCount++
-	return gc.requestCount > gc.RateLimit
-}
-
-// ============================================================================
-// STRIPE PROVIDER
-// ============================================================================
-
-type StripeChargeRequest struct {
-	Amount      int64  `json:"amount"`
-	Currency    string `json:"currency"`
-	Source      string `json:"source"`
-	Description string `json:"description"`
+// LatencyDistribution shapes a gateway's latency around its LatencyMs, so percentile
+// based routing sees realistic spreads instead of one fixed value
+type LatencyDistribution struct {
+	Type     string  `json:"type"`                // fixed (default), normal, lognormal or bimodal
+	StdDevMs float64 `json:"stddev_ms,omitempty"` // normal and bimodal: spread of each mode
+	Sigma    float64 `json:"sigma,omitempty"`     // lognormal: shape, with LatencyMs as the median
+	TailMs   int     `json:"tail_ms,omitempty"`   // bimodal: center of the slow mode
+	TailRate float64 `json:"tail_rate,omitempty"` // bimodal: share of requests in the slow mode
 }
 
-type StripeChargeResponse struct {
-	ID             string `json:"id"`
-	Object         string `json:"object"`
-	Amount         int64  `json:"amount"`
-	Currency       string `json:"currency"`
-	Status         string `json:"status"`
-	Paid           bool   `json:"paid"`
-	Created        int64  `json:"created"`
-	FailureCode    string `json:"failure_code,omitempty"`
-	FailureMessage string `json:"failure_message,omitempty"`
-}
-
-type StripeRefundResponse struct {
-	ID     string `json:"id"`
-	Object string `json:"object"`
-	Amount int64  `json:"amount"`
-	Status string `json:"status"`
-}
-
-func stripeChargeHandler(w http.ResponseWriter, r *http.Request) {
-	gatewaysMu.RLock()
-	config := gateways["stripe"]
-	gatewaysMu.RUnlock()
-
-	if config.CheckRateLimit() {
-		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "STRIPE")
-		return
-	}
-
-	config.mu.RLock()
-	latency := config.LatencyMs
-	errorRate := config.ErrorRate
-	errorType := config.ErrorType
-	statusCode := config.StatusCode
-	config.mu.RUnlock()
-
-	time.Sleep(time.Duration(latency) * time.Millisecond)
-
-	body, _ := io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	var req StripeChargeRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "invalid_request_error",
-			"message": "Invalid JSON body",
-		})
-		return
-	}
-
-	if rand.Float64() < errorRate {
-		simulateError(w, errorType, statusCode, "STRIPE")
-		return
+// Validate rejects unknown types and out of range parameters
+func (d LatencyDistribution) Validate() error {
+	switch d.Type {
+	case "", "fixed", "normal", "lognormal", "bimodal":
+	default:
+		return fmt.Errorf("unknown latency distribution %q, use fixed, normal, lognormal or bimodal", d.Type)
 	}
-
-	resp := StripeChargeResponse{
-		ID:       "ch_" + generateID(24),
-		Object:   "charge",
-		Amount:   req.Amount,
-		Currency: req.Currency,
-		Status:   "succeeded",
-		Paid:     true,
-		Created:  time.Now().Unix(),
+	if d.StdDevMs < 0 || d.Sigma < 0 || d.TailMs < 0 {
+		return fmt.Errorf("latency distribution parameters must not be negative")
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-	log.Printf("[STRIPE] SUCCESS: Charged %d %s", req.Amount, req.Currency)
-}
-
-func stripeRefundHandler(w http.ResponseWriter, r *http.Request) {
-	time.Sleep(time.Duration(40+rand.Intn(80)) * time.Millisecond)
-
-	resp := StripeRefundResponse{
-		ID:     "re_" + generateID(24),
-		Object: "refund",
-		Amount: 5000,
-		Status: "succeeded",
+	if d.TailRate < 0 || d.TailRate > 1 {
+		return fmt.Errorf("tail_rate must be between 0 and 1")
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-	log.Println("[STRIPE] Refund processed")
+	return nil
 }
 
-// ============================================================================
-// RAZORPAY PROVIDER
-// ============================================================================
-
-type RazorpayChargeRequest struct {
-	Amount   int64  `json:"amount"`
-	Currency string `json:"currency"`
-	Email    string `json:"email"`
-	Contact  string `json:"contact"`
-}
-
-type RazorpayChargeResponse struct {
-	ID          string `json:"id"`
-	Entity      string `json:"entity"`
-	Amount      int64  `json:"amount"`
-	Currency    string `json:"currency"`
-	Status      string `json:"status"`
-	Method      string `json:"method"`
-	Description string `json:"description"`
-	Captured    bool   `json:"captured"`
-	CreatedAt   int64  `json:"created_at"`
-}
-
-func razorpayChargeHandler(w http.ResponseWriter, r *http.Request) {
-	gatewaysMu.RLock()
-	config := gateways["razorpay"]
-	gatewaysMu.RUnlock()
-
-	if config.CheckRateLimit() {
-		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "RAZORPAY")
-		return
-	}
-
-	config.mu.RLock()
-	latency := config.LatencyMs
-	errorRate := config.ErrorRate
-	errorType := config.ErrorType
-	statusCode := config.StatusCode
-	config.mu.RUnlock()
-
-	time.Sleep(time.Duration(latency) * time.Millisecond)
-
-	body, _ := io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	var req RazorpayChargeRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":       "BAD_REQUEST_ERROR",
-			"description": "Invalid JSON",
-		})
-		return
+// Sample draws one latency for a gateway whose base latency is baseMs. Samples are
+// never negative
+func (d LatencyDistribution) Sample(baseMs int) time.Duration {
+	ms := float64(baseMs)
+	switch d.Type {
+	case "normal":
+		ms += rand.NormFloat64() * d.StdDevMs
+	case "lognormal":
+		ms *= math.Exp(rand.NormFloat64() * d.Sigma)
+	case "bimodal":
+		if rand.Float64() < d.TailRate {
+			ms = float64(d.TailMs)
+		}
+		ms += rand.NormFloat64() * d.StdDevMs
 	}
-
-	if rand.Float64() < errorRate {
-		simulateError(w, errorType, statusCode, "RAZORPAY")
-		return
+	if ms < 0 {
+		ms = 0
 	}
-
-	resp := RazorpayChargeResponse{
-		ID:          "pay_" + generateID(14),
-		Entity:      "payment",
-		Amount:      req.Amount,
-		Currency:    req.Currency,
-		Status:      "captured",
-		Method:      "card",
-		Description: "Payment",
-		Captured:    true,
-		CreatedAt:   time.Now().Unix(),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-	log.Printf("[RAZORPAY] SUCCESS: Charged %d %s", req.Amount, req.Currency)
+	return time.Duration(ms * float64(time.Millisecond))
 }
 
-// ============================================================================
-// KLARNA PROVIDER
-// ============================================================================
-
-type KlarnaSessionRequest struct {
-	PurchaseAmount   int64  `json:"purchase_amount"`
-	PurchaseCurrency string `json:"purchase_currency"`
-	Locale           string `json:"locale"`
+// UpdateLatencyDistribution replaces a gateway's latency distribution
+// UpdateBandwidth sets the response byte rate; 0 removes the limit
+func (gc *GatewayConfig) UpdateBandwidth(bytesPerSecond int) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.BandwidthBps = bytesPerSecond
 }
 
-type KlarnaSessionResponse struct {
-	SessionID      string   `json:"session_id"`
-	ClientToken    string   `json:"client_token"`
-	PaymentMethods []string `json:"payment_method_categories"`
+func (gc *GatewayConfig) UpdateLatencyDistribution(dist LatencyDistribution) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.LatencyDist = dist
 }
 
-func klarnaSessionHandler(w http.ResponseWriter, r *http.Request) {
-	gatewaysMu.RLock()
-	config := gateways["klarna"]
-	gatewaysMu.RUnlock()
-
-	if config.CheckRateLimit() {
-		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "KLARNA")
-		return
-	}
-
-	config.mu.RLock()
-	latency := config.LatencyMs
-	errorRate := config.ErrorRate
-	errorType := config.ErrorType
-	statusCode := config.StatusCode
-	config.mu.RUnlock()
-
-	time.Sleep(time.Duration(latency) * time.Millisecond)
-
-	body, _ := io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	var req KlarnaSessionRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error_code":     "BAD_VALUE",
-			"error_messages": "Invalid request",
-		})
-		return
-	}
-
-	if rand.Float64() < errorRate {
-		simulateError(w, errorType, statusCode, "KLARNA")
-		return
-	}
+// UpdateWebhook applies the webhook settings that are set, leaving the others unchanged
+func (gc *GatewayConfig) UpdateWebhook(url, secret *string, delayMs, duplicates *int, async *bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
 
-	resp := KlarnaSessionResponse{
-		SessionID:      "klarna_" + generateID(32),
-		ClientToken:    "token_" + generateID(64),
-		PaymentMethods: []string{"pay_later", "pay_over_time", "pay_now"},
+	if url != nil {
+		gc.Webhook.URL = *url
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-	log.Println("[KLARNA] Session created")
-}
-
-// ============================================================================
-// ONFIDO PROVIDER (KYC/Compliance)
-// ============================================================================
-
-type OnfidoCheckRequest struct {
-	ApplicantID string   `json:"applicant_id"`
-	ReportNames []string `json:"report_names"`
-}
-
-type OnfidoCheckResponse struct {
-	ID        string `json:"id"`
-	Status    string `json:"status"`
-	Result    string `json:"result"`
-	SubResult string `json:"sub_result"`
-	CreatedAt string `json:"created_at"`
-}
-
-func onfidoCheckHandler(w http.ResponseWriter, r *http.Request) {
-	gatewaysMu.RLock()
-	config := gateways["onfido"]
-	gatewaysMu.RUnlock()
-
-	if config.CheckRateLimit() {
-		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "ONFIDO")
-		return
+	if secret != nil {
+		gc.Webhook.Secret = *secret
 	}
-
-	config.mu.RLock()
-	latency := config.LatencyMs
-	errorRate := config.ErrorRate
-	errorType := config.ErrorType
-	statusCode := config.StatusCode
-	config.mu.RUnlock()
-
-	time.Sleep(time.Duration(latency) * time.Millisecond)
-
-	body, _ := io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	var req OnfidoCheckRequest
-	if err := json.Unmarshal(body, &req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error":   "validation_error",
-			"message": "Invalid request body",
-		})
-		return
+	if delayMs != nil && *delayMs >= 0 {
+		gc.Webhook.DelayMs = *delayMs
 	}
-
-	if rand.Float64() < errorRate {
-		simulateError(w, errorType, statusCode, "ONFIDO")
-		return
+	if duplicates != nil && *duplicates >= 0 {
+		gc.Webhook.Duplicates = *duplicates
 	}
-
-	resp := OnfidoCheckResponse{
-		ID:        "check_" + generateID(32),
-		Status:    "complete",
-		Result:    "clear",
-		SubResult: "clear",
-		CreatedAt: time.Now().Format(time.RFC3339),
+	if async != nil {
+		gc.Webhook.Async = *async
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-	log.Println("[ONFIDO] KYC APPROVED")
 }
 
-// ============================================================================
-// TEST GATEWAYS (test1, test2, test3) - Simple Generic Responses
-// ============================================================================
-
-func testGatewayHandler(w http.ResponseWriter, r *http.Request, gatewayName string) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	gatewaysMu.RLock()
-	config, exists := gateways[gatewayName]
-	gatewaysMu.RUnlock()
+// CheckRateLimit checks if rate limit is exceeded
+func (gc *GatewayConfig) CheckRateLimit() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
 
-	if !exists {
-		http.Error(w, fmt.Sprintf("Gateway '%s' not found", gatewayName), http.StatusNotFound)
-		return
+	if gc.RateLimit == 0 {
+		return false
 	}
 
-	// Read and discard request body
-	io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	// Check rate limit
-	if config.CheckRateLimit() {
-		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, strings.ToUpper(gatewayName))
-		return
+	now := time.Now()
+	if now.Sub(gc.lastReset) >= time.Second {
+		gc.requestCount = 0
+		gc.lastReset = now
 	}
 
-	// Get current config values
-	config.mu.RLock()
-	latency := config.LatencyMs
-	errorRate := config.ErrorRate
-	errorType := config.ErrorType
-	statusCode := config.StatusCode
-	config.mu.RUnlock()
-
-	// Simulate latency
-	time.Sleep(time.Duration(latency) * time.Millisecond)
-
-	// Simulate errors based on error rate
-	if rand.Float64() < errorRate {
-		simulateError(w, errorType, statusCode, strings.ToUpper(gatewayName))
-	} else {
-		// Success response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  "success",
-			"message": "Payment processed successfully",
-		})
-		log.Printf("[%s] SUCCESS", strings.ToUpper(gatewayName))
-	}
+	gc.requestCount++
+	return gc.requestCount > gc.RateLimit
 }
 
 // ============================================================================
-// ERROR SIMULATION
+// MAIN
 // ============================================================================
 
-func simulateError(w http.ResponseWriter, errorType ErrorCode, statusCode int, gatewayName string) {
-	switch errorType {
-	case ErrConnectionReset:
-		log.Printf("[%s] SIMULATING CONNECTION RESET", gatewayName)
-		hj, ok := w.(http.Hijacker)
-		if !ok {
-			http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
-			return
-		}
-		conn, _, err := hj.Hijack()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		conn.Close()
-
-	case ErrPanic:
-		log.Printf("[%s] SIMULATING PANIC", gatewayName)
-		panic(fmt.Sprintf("Simulated panic in %s", gatewayName))
-
-	case ErrMalformedResponse, ErrInvalidJSON:
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"status": "failed", "error": "malformed`))
-		log.Printf("[%s] FAILED (Malformed JSON)", gatewayName)
-
-	case ErrEmptyResponse:
-		w.WriteHeader(http.StatusOK)
-		log.Printf("[%s] FAILED (Empty Response)", gatewayName)
-
-	case ErrSlowResponse:
-		w.Header().Set("Content-Type", "application/json")
-		resp := fmt.Sprintf(`{"status": "failed", "error_code": "%s"}`, errorType)
-		for i := 0; i < len(resp); i++ {
-			w.Write([]byte{resp[i]})
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
-			time.Sleep(100 * time.Millisecond)
-		}
-		log.Printf("[%s] FAILED (Slow Response)", gatewayName)
-
-	case ErrRateLimited:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusTooManyRequests)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":      "failed",
-			"error":       string(errorType),
-			"message":     "Rate limit exceeded",
-			"retry_after": 60,
-		})
-		log.Printf("[%s] FAILED (Rate Limited)", gatewayName)
-
-	default:
-		if statusCode == 0 {
-			statusCode = http.StatusInternalServerError
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(statusCode)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  "failed",
-			"error":   string(errorType),
-			"message": getErrorMessage(errorType),
-		})
-		log.Printf("[%s] FAILED (%s) - Status %d", gatewayName, errorType, statusCode)
-	}
+// simulatorEndpoint is a gateway's entry in the startup banner
+type simulatorEndpoint struct {
+	gateway string
+	label   string
+	path    string
 }
 
-func getErrorMessage(errorType ErrorCode) string {
-	messages := map[ErrorCode]string{
-		ErrInsufficientFunds: "Insufficient funds in account",
-		ErrCardDeclined:      "Card was declined",
-		ErrAuthFailed:        "Authentication failed",
-		ErrGatewayTimeout:    "Gateway timeout",
-		ErrProviderError:     "Provider error occurred",
-		ErrProviderDown:      "Provider is currently down",
-		ErrConnectionTimeout: "Connection timeout",
-		ErrInternalError:     "Internal server error",
-		ErrComplianceFailed:  "Compliance check failed",
-		ErrKYCRequired:       "KYC verification required",
-	}
-
-	if msg, ok := messages[errorType]; ok {
-		return msg
-	}
-	return string(errorType)
+var providerEndpoints = []simulatorEndpoint{
+	{"stripe", "Stripe", "/stripe  (/refunds, /payouts)"},
+	{"razorpay", "Razorpay", "/razorpay  (/payouts)"},
+	{"klarna", "Klarna", "/klarna"},
+	{"paypal", "PayPal", "/paypal/v2/checkout/orders"},
+	{"adyen", "Adyen", "/adyen/v71/payments"},
+	{"braintree", "Braintree", "/braintree/graphql"},
+	{"onfido", "Onfido", "/onfido"},
+	{"sanctions", "Sanctions", "/sanctions"},
 }
 
-func generateID(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(result)
+var testEndpoints = []simulatorEndpoint{
+	{"test1", "Test1", "/test1"},
+	{"test2", "Test2", "/test2"},
+	{"test3", "Test3", "/test3"},
 }
 
-// ============================================================================
-// CONTROL API - Error Manipulation
-// ============================================================================
-
-func controlHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method == http.MethodGet {
-		gatewaysMu.RLock()
-		configs := make(map[string]interface{})
-		for name, config := range gateways {
-			config.mu.RLock()
-			configs[name] = map[string]interface{}{
-				"name":        config.Name,
-				"type":        config.Type,
-				"latency_ms":  config.LatencyMs,
-				"error_rate":  config.ErrorRate,
-				"rate_limit":  config.RateLimit,
-				"error_type":  config.ErrorType,
-				"status_code": config.StatusCode,
-			}
-			config.mu.RUnlock()
+// logEndpoints lists the enabled gateways of one group
+func logEndpoints(title string, endpoints []simulatorEndpoint) {
+	var enabled []simulatorEndpoint
+	for _, endpoint := range endpoints {
+		if _, ok := gateways[endpoint.gateway]; ok {
+			enabled = append(enabled, endpoint)
 		}
-		gatewaysMu.RUnlock()
-
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"gateways": configs,
-			"available_error_types": []string{
-				string(ErrInvalidRequest),
-				string(ErrInsufficientFunds),
-				string(ErrCardDeclined),
-				string(ErrAuthFailed),
-				string(ErrGatewayTimeout),
-				string(ErrProviderError),
-				string(ErrProviderDown),
-				string(ErrConnectionReset),
-				string(ErrConnectionTimeout),
-				string(ErrMalformedResponse),
-				string(ErrEmptyResponse),
-				string(ErrSlowResponse),
-				string(ErrInvalidJSON),
-				string(ErrRateLimited),
-				string(ErrInternalError),
-				string(ErrPanic),
-				string(ErrComplianceFailed),
-				string(ErrKYCRequired),
-			},
-		})
-		return
 	}
-
-	if r.Method == http.MethodPost {
-		var req struct {
-			Gateway    string    `json:"gateway"`
-			LatencyMs  int       `json:"latency_ms"`
-			ErrorRate  float64   `json:"error_rate"`
-			RateLimit  int       `json:"rate_limit"`
-			ErrorType  ErrorCode `json:"error_type"`
-			StatusCode int       `json:"status_code"`
-		}
-
-		body, _ := io.ReadAll(r.Body)
-		defer r.Body.Close()
-
-		if err := json.Unmarshal(body, &req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
-
-		gatewaysMu.RLock()
-		config, exists := gateways[req.Gateway]
-		gatewaysMu.RUnlock()
-
-		if !exists {
-			http.Error(w, fmt.Sprintf("Gateway '%s' not found. Available: stripe, razorpay, klarna, onfido, test1, test2, test3", req.Gateway), http.StatusBadRequest)
-			return
-		}
-
-		config.UpdateConfig(req.LatencyMs, req.ErrorRate, req.RateLimit, req.ErrorType, req.StatusCode)
-
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"message": "Gateway configuration updated",
-			"gateway": req.Gateway,
-			"config": map[string]interface{}{
-				"latency_ms":  config.LatencyMs,
-				"error_rate":  config.ErrorRate,
-				"rate_limit":  config.RateLimit,
-				"error_type":  config.ErrorType,
-				"status_code": config.StatusCode,
-			},
-		})
-		log.Printf("Updated %s: latency=%dms, error_rate=%.2f%%, rate_limit=%d/s, error_type=%s",
-			req.Gateway, req.LatencyMs, req.ErrorRate*100, req.RateLimit, req.ErrorType)
+	if len(enabled) == 0 {
 		return
 	}
-
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-}
-
-// ============================================================================
-// ROUTER
-// ============================================================================
-
-func routeHandler(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/")
-	parts := strings.Split(path, "/")
-
-	if len(parts) == 0 || parts[0] == "" {
-		http.Error(w, "Gateway/Provider name required", http.StatusBadRequest)
-		return
-	}
-
-	gateway := parts[0]
-
-	switch gateway {
-	case "stripe":
-		if len(parts) > 1 {
-			switch parts[1] {
-			case "charges":
-				stripeChargeHandler(w, r)
-			case "refunds":
-				stripeRefundHandler(w, r)
-			default:
-				http.NotFound(w, r)
-			}
-		} else {
-			stripeChargeHandler(w, r)
+	log.Println(title)
+	for i, endpoint := range enabled {
+		branch := "├─"
+		if i == len(enabled)-1 {
+			branch = "└─"
 		}
-	case "razorpay":
-		razorpayChargeHandler(w, r)
-	case "klarna":
-		klarnaSessionHandler(w, r)
-	case "onfido":
-		onfidoCheckHandler(w, r)
-	case "test1", "test2", "test3":
-		testGatewayHandler(w, r, gateway)
-	case "control":
-		controlHandler(w, r)
-	case "health":
-		healthHandler(w, r)
-	default:
-		http.Error(w, fmt.Sprintf("Unknown gateway/provider: %s", gateway), http.StatusNotFound)
+		log.Printf("  %s %-10s %s%s", branch, endpoint.label+":", publicURL, endpoint.path)
 	}
+	log.Println("")
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	gatewaysMu.RLock()
-	statuses := make(map[string]string)
-	for name := range gateways {
-		statuses[name] = "operational"
+func main() {
+	config, err := parseServerConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	gatewaysMu.RUnlock()
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":    "healthy",
-		"gateways":  statuses,
-		"timestamp": time.Now().Unix(),
-	})
-}
-
-// ============================================================================
-// MAIN
-// ============================================================================
+	applyServerConfig(config)
 
-func main() {
-	http.HandleFunc("/", routeHandler)
+	http.HandleFunc("/", traceHandler(recordingHandler(idempotencyHandler(bandwidthHandler(routeHandler)))))
 
 	log.Println("╔════════════════════════════════════════════════════════════════╗")
 	log.Println("║        UNIFIED GATEWAY & PROVIDER SIMULATION SERVER           ║")
 	log.Println("╚════════════════════════════════════════════════════════════════╝")
 	log.Println("")
-	log.Println("🌐 Server running on: http://localhost:3001")
-	log.Println("")
-	log.Println("📦 REALISTIC PROVIDER APIs:")
-	log.Println("  ├─ Stripe:   http://localhost:3001/stripe")
-	log.Println("  ├─ Razorpay: http://localhost:3001/razorpay")
-	log.Println("  ├─ Klarna:   http://localhost:3001/klarna")
-	log.Println("  └─ Onfido:   http://localhost:3001/onfido")
-	log.Println("")
-	log.Println("🧪 TEST GATEWAYS (Simple APIs):")
-	log.Println("  ├─ Test1:    http://localhost:3001/test1")
-	log.Println("  ├─ Test2:    http://localhost:3001/test2")
-	log.Println("  └─ Test3:    http://localhost:3001/test3")
+	log.Printf("🌐 Server running on: %s", publicURL)
 	log.Println("")
+	logEndpoints("📦 REALISTIC PROVIDER APIs:", providerEndpoints)
+	logEndpoints("🧪 TEST GATEWAYS (Simple APIs):", testEndpoints)
 	log.Println("⚙️  CONTROL ENDPOINTS:")
 	log.Println("  ├─ GET  /control  → View all configurations")
 	log.Println("  ├─ POST /control  → Update gateway config")
-	log.Println("  └─ GET  /health   → Health check")
+	log.Println("  ├─ POST /control/scenario → Run a timed chaos scenario (GET status, DELETE stop)")
+	log.Println("  ├─ POST /control/recording → Record traffic to disk or replay it (GET status)")
+	log.Println("  ├─ GET  /health   → Health check")
+	log.Println("  ├─ GET  /{gateway}/health → Gateway health probe")
+	log.Println("  ├─ GET  /{gateway}/charges[/{id}] → Charges the gateway accepted (?payment_id=)")
+	log.Println("  ├─ Idempotency-Key on any gateway POST → Original response replayed; repeats counted in GET /control")
+	log.Println("  └─ POST /{gateway}/refunds → Refund a payment")
+	log.Println("")
+	log.Println("📝 Example: Confirm stripe charges by webhook, delivered twice after 2s")
+	log.Printf(`  curl -X POST %s/control \`, publicURL)
+	log.Println(`    -H "Content-Type: application/json" \`)
+	log.Println(`    -d '{"gateway":"stripe","latency_ms":100,"error_rate":0.05,"async":true,`)
+	log.Println(`         "webhook_url":"http://localhost:3000/callbacks/stripe","webhook_delay_ms":2000,"webhook_duplicates":1}'`)
+	log.Println("")
+	log.Println("📝 Example: Record gateway traffic, then replay it deterministically")
+	log.Printf(`  curl -X POST %s/control/recording -d '{"mode":"record","dir":"./recordings"}'`, publicURL)
+	log.Printf(`  curl -X POST %s/control/recording -d '{"mode":"replay","dir":"./recordings"}'`, publicURL)
 	log.Println("")
 	log.Println("📝 Example: Update test1 error rate to 50%")
-	log.Println(`  curl -X POST http://localhost:3001/control \`)
+	log.Printf(`  curl -X POST %s/control \`, publicURL)
 	log.Println(`    -H "Content-Type: application/json" \`)
 	log.Println(`    -d '{"gateway":"test1","error_rate":0.5,"latency_ms":300}'`)
 	log.Println("")
+	log.Println("📝 Example: Give test2 a lognormal latency with a heavy tail")
+	log.Printf(`  curl -X POST %s/control \`, publicURL)
+	log.Println(`    -H "Content-Type: application/json" \`)
+	log.Println(`    -d '{"gateway":"test2","error_rate":0.2,"latency_ms":150,"latency_distribution":{"type":"lognormal","sigma":0.6}}'`)
+	log.Println("")
 	log.Println("═══════════════════════════════════════════════════════════════")
 
-	if err := http.ListenAndServe(":3001", nil); err != nil {
+	addr := fmt.Sprintf(":%d", config.Port)
+	if config.TLSCert != "" {
+		err = http.ListenAndServeTLS(addr, config.TLSCert, config.TLSKey, nil)
+	} else {
+		err = http.ListenAndServe(addr, nil)
+	}
+	if err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// IDEMPOTENCY
+// ============================================================================
+
+// idempotencyTTL is how long a provider remembers a key, as Stripe's 24 hours
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyHeaders are the headers each provider reads its key from, first match
+// wins. Every gateway also accepts the plain Idempotency-Key the backend sends
+var idempotencyHeaders = map[string][]string{
+	"paypal": {"PayPal-Request-Id"},
+	"klarna": {"Klarna-Idempotency-Key"},
+}
+
+// idempotencyEntry is a key's first request and, once it has finished, its response
+type idempotencyEntry struct {
+	requestHash string
+	complete    bool
+	status      int
+	header      http.Header
+	body        []byte
+	createdAt   time.Time
+}
+
+// IdempotencyStats counts how often a gateway saw a key again. Every one of these is a
+// request the caller sent twice, so a non-zero count is worth explaining
+type IdempotencyStats struct {
+	Keys       int `json:"keys"`
+	Duplicates int `json:"duplicates"` // Replayed + InFlight + Mismatched
+	Replayed   int `json:"replayed"`
+	InFlight   int `json:"in_flight"`  // Repeated while the first was still running
+	Mismatched int `json:"mismatched"` // Same key, different request
+}
+
+// idempotencyStore dedupes POSTs per gateway the way providers do: a repeated key
+// gets the original response, a key still in flight is a 409, and a key reused for a
+// different request is a 400. Server errors and dropped connections release the key
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry // by gateway and key
+	stats   map[string]*IdempotencyStats
+}
+
+var idempotency = &idempotencyStore{
+	entries: make(map[string]*idempotencyEntry),
+	stats:   make(map[string]*IdempotencyStats),
+}
+
+func (s *idempotencyStore) statsFor(gateway string) *IdempotencyStats {
+	stats, ok := s.stats[gateway]
+	if !ok {
+		stats = &IdempotencyStats{}
+		s.stats[gateway] = stats
+	}
+	return stats
+}
+
+// Stats returns a copy of a gateway's counters
+func (s *idempotencyStore) Stats(gateway string) IdempotencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.statsFor(gateway)
+}
+
+// Reset forgets a gateway's keys and zeroes its counters
+func (s *idempotencyStore) Reset(gateway string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if strings.HasPrefix(key, gateway+":") {
+			delete(s.entries, key)
+		}
+	}
+	delete(s.stats, gateway)
+}
+
+// claim registers the first request for a key. When the key is already known it
+// returns the existing entry instead, copied so it can be read without the lock
+func (s *idempotencyStore) claim(gateway, key, requestHash string) (idempotencyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.statsFor(gateway)
+	entry, ok := s.entries[gateway+":"+key]
+	if ok && time.Since(entry.createdAt) < idempotencyTTL {
+		stats.Duplicates++
+		switch {
+		case entry.requestHash != requestHash:
+			stats.Mismatched++
+		case !entry.complete:
+			stats.InFlight++
+		default:
+			stats.Replayed++
+		}
+		return *entry, false
+	}
+	if !ok {
+		stats.Keys++
+	}
+	s.entries[gateway+":"+key] = &idempotencyEntry{requestHash: requestHash, createdAt: time.Now()}
+	return idempotencyEntry{}, true
+}
+
+func (s *idempotencyStore) complete(gateway, key string, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[gateway+":"+key]; ok {
+		entry.complete = true
+		entry.status = status
+		entry.header = header
+		entry.body = body
+	}
+}
+
+func (s *idempotencyStore) release(gateway, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, gateway+":"+key)
+	s.statsFor(gateway).Keys--
+}
+
+func idempotencyKeyFor(gateway string, r *http.Request) string {
+	for _, header := range append(idempotencyHeaders[gateway], "Idempotency-Key") {
+		if key := r.Header.Get(header); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+func writeIdempotencyError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "failed",
+		"error":   "idempotency_error",
+		"message": message,
+	})
+}
+
+// idempotencyHandler applies the gateway's idempotency rules to POSTs carrying a key.
+// The method and path are part of the request hash, so a key reused on another
+// endpoint of the same gateway counts as a mismatch
+func idempotencyHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gateway, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		gatewaysMu.RLock()
+		_, isGateway := gateways[gateway]
+		gatewaysMu.RUnlock()
+
+		key := idempotencyKeyFor(gateway, r)
+		if !isGateway || key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+		requestHash := hex.EncodeToString(hash[:])
+
+		entry, claimed := idempotency.claim(gateway, key, requestHash)
+		if !claimed {
+			switch {
+			case entry.requestHash != requestHash:
+				log.Printf("[%s] DUPLICATE: key %s reused for a different request", strings.ToUpper(gateway), key)
+				writeIdempotencyError(w, http.StatusBadRequest, "Keys for idempotent requests can only be used with the same parameters they were first used with")
+			case !entry.complete:
+				log.Printf("[%s] DUPLICATE: key %s is still in flight", strings.ToUpper(gateway), key)
+				writeIdempotencyError(w, http.StatusConflict, "There is currently another in-progress request using this idempotency key")
+			default:
+				log.Printf("[%s] DUPLICATE: replaying response for key %s", strings.ToUpper(gateway), key)
+				for k, v := range entry.header {
+					if !recordHeaderSkip[k] {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				idempotency.release(gateway, key)
+				panic(p)
+			}
+		}()
+		next(rw, r)
+
+		if rw.hijacked || rw.status == 0 || rw.status >= 500 {
+			idempotency.release(gateway, key)
+			return
+		}
+		idempotency.complete(gateway, key, rw.status, w.Header().Clone(), rw.body.Bytes())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PAYOUTS (stripe, razorpay)
+// ============================================================================
+
+type PayoutRequest struct {
+	ID          string `json:"id"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Destination string `json:"destination"`
+}
+
+// payoutHandler simulates a disbursement using the gateway's configured latency and error profile
+func payoutHandler(w http.ResponseWriter, r *http.Request, gatewayName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gatewaysMu.RLock()
+	config := gateways[gatewayName]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, strings.ToUpper(gatewayName))
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req PayoutRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Destination == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":     "failed",
+			"error_code": string(ErrInvalidRequest),
+			"error":      "Invalid payout request",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, strings.ToUpper(gatewayName))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"id":     "po_" + generateID(24),
+		"amount": req.Amount,
+	})
+	log.Printf("[%s] PAYOUT SUCCESS: %d %s to %s", strings.ToUpper(gatewayName), req.Amount, req.Currency, req.Destination)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// KLARNA PROVIDER
+// ============================================================================
+
+type KlarnaSessionRequest struct {
+	PurchaseAmount   int64  `json:"purchase_amount"`
+	PurchaseCurrency string `json:"purchase_currency"`
+	Locale           string `json:"locale"`
+}
+
+type KlarnaSessionResponse struct {
+	SessionID      string   `json:"session_id"`
+	ClientToken    string   `json:"client_token"`
+	PaymentMethods []string `json:"payment_method_categories"`
+}
+
+func klarnaSessionHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["klarna"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "KLARNA")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req KlarnaSessionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error_code":     "BAD_VALUE",
+			"error_messages": "Invalid request",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, "KLARNA")
+		return
+	}
+
+	resp := KlarnaSessionResponse{
+		SessionID:      "klarna_" + generateID(32),
+		ClientToken:    "token_" + generateID(64),
+		PaymentMethods: []string{"pay_later", "pay_over_time", "pay_now"},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	log.Println("[KLARNA] Session created")
+}
+
+// ============================================================================
+// ONFIDO PROVIDER (KYC/Compliance)
+// ============================================================================
+
+type OnfidoCheckRequest struct {
+	ApplicantID string   `json:"applicant_id"`
+	ReportNames []string `json:"report_names"`
+}
+
+type OnfidoCheckResponse struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Result    string `json:"result"`
+	SubResult string `json:"sub_result"`
+	CreatedAt string `json:"created_at"`
+}
+
+func onfidoCheckHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["onfido"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "ONFIDO")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req OnfidoCheckRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "validation_error",
+			"message": "Invalid request body",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, "ONFIDO")
+		return
+	}
+
+	resp := OnfidoCheckResponse{
+		ID:        "check_" + generateID(32),
+		Status:    "complete",
+		Result:    "clear",
+		SubResult: "clear",
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	log.Println("[ONFIDO] KYC APPROVED")
+}
+
+// ============================================================================
+// SANCTIONS SCREENING PROVIDER (sanctions/PEP lists)
+// ============================================================================
+
+// sanctionsListVersion identifies the list snapshot every screening is run against
+const sanctionsListVersion = "2026.10.01"
+
+var (
+	// sanctionedNames are exact matches on the simulated sanctions list
+	sanctionedNames = map[string]bool{
+		"viktor blockov":    true,
+		"sanctioned trader": true,
+		"acme arms export":  true,
+	}
+	// pepNames are politically exposed persons, returned as potential matches
+	pepNames = map[string]bool{
+		"maria ministerova": true,
+		"john senator":      true,
+	}
+	// embargoedCountries match any party located in them
+	embargoedCountries = map[string]bool{"KP": true, "IR": true, "SY": true, "CU": true}
+)
+
+type SanctionsScreenRequest struct {
+	Reference string `json:"reference"`
+	Name      string `json:"name"`
+	Country   string `json:"country"`
+}
+
+type SanctionsScreenResponse struct {
+	ID          string   `json:"id"`
+	Result      string   `json:"result"` // clear, potential_match or match
+	ListVersion string   `json:"list_version"`
+	Matches     []string `json:"matches,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// sanctionsScreenHandler screens a party against the simulated sanctions, PEP and
+// embargo lists
+func sanctionsScreenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gatewaysMu.RLock()
+	config := gateways["sanctions"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "SANCTIONS")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req SanctionsScreenRequest
+	if err := json.Unmarshal(body, &req); err != nil || (req.Name == "" && req.Country == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "validation_error",
+			"message": "name or country is required",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, "SANCTIONS")
+		return
+	}
+
+	resp := SanctionsScreenResponse{
+		ID:          "scr_" + generateID(24),
+		Result:      "clear",
+		ListVersion: sanctionsListVersion,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+	name := strings.Join(strings.Fields(strings.ToLower(req.Name)), " ")
+	country := strings.ToUpper(req.Country)
+	switch {
+	case sanctionedNames[name]:
+		resp.Result = "match"
+		resp.Matches = append(resp.Matches, "sanctions:"+name)
+	case embargoedCountries[country]:
+		resp.Result = "match"
+		resp.Matches = append(resp.Matches, "embargo:"+country)
+	case pepNames[name]:
+		resp.Result = "potential_match"
+		resp.Matches = append(resp.Matches, "pep:"+name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+	log.Printf("[SANCTIONS] %s screened: %s (list %s)", req.Reference, resp.Result, sanctionsListVersion)
+}
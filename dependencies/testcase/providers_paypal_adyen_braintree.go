@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PAYPAL PROVIDER (Orders v2)
+// ============================================================================
+
+type PayPalMoney struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type PayPalCapture struct {
+	ID           string      `json:"id"`
+	Status       string      `json:"status"`
+	Amount       PayPalMoney `json:"amount"`
+	FinalCapture bool        `json:"final_capture"`
+	CreateTime   string      `json:"create_time"`
+}
+
+type PayPalPurchaseUnit struct {
+	ReferenceID string      `json:"reference_id,omitempty"`
+	Amount      PayPalMoney `json:"amount"`
+	Payments    *struct {
+		Captures []PayPalCapture `json:"captures"`
+	} `json:"payments,omitempty"`
+}
+
+type PayPalOrderRequest struct {
+	Intent        string               `json:"intent"`
+	PurchaseUnits []PayPalPurchaseUnit `json:"purchase_units"`
+}
+
+type PayPalLink struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method"`
+}
+
+type PayPalOrderResponse struct {
+	ID            string               `json:"id"`
+	Intent        string               `json:"intent"`
+	Status        string               `json:"status"`
+	PurchaseUnits []PayPalPurchaseUnit `json:"purchase_units"`
+	CreateTime    string               `json:"create_time"`
+	Links         []PayPalLink         `json:"links"`
+}
+
+type PayPalErrorDetail struct {
+	Field       string `json:"field,omitempty"`
+	Issue       string `json:"issue"`
+	Description string `json:"description"`
+}
+
+type PayPalError struct {
+	Name    string              `json:"name"`
+	Message string              `json:"message"`
+	DebugID string              `json:"debug_id"`
+	Details []PayPalErrorDetail `json:"details,omitempty"`
+}
+
+// paypalError answers in PayPal's error format: declines are 422 INSTRUMENT_DECLINED,
+// bad credentials an OAuth error, anything else the configured status
+func paypalError(w http.ResponseWriter, errorType ErrorCode, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	debugID := strings.ToLower(generateID(13))
+
+	switch {
+	case isDecline(errorType):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(PayPalError{
+			Name:    "UNPROCESSABLE_ENTITY",
+			Message: "The requested action could not be performed, semantically incorrect, or failed business validation.",
+			DebugID: debugID,
+			Details: []PayPalErrorDetail{{
+				Issue:       "INSTRUMENT_DECLINED",
+				Description: "The instrument presented was either declined by the processor or bank, or it can't be used for this payment.",
+			}},
+		})
+	case errorType == ErrAuthFailed:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_client",
+			"error_description": "Client Authentication failed",
+		})
+	default:
+		if statusCode < 500 {
+			statusCode = http.StatusInternalServerError
+		}
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(PayPalError{
+			Name:    "INTERNAL_SERVER_ERROR",
+			Message: "An internal server error has occurred.",
+			DebugID: debugID,
+		})
+	}
+	log.Printf("[PAYPAL] FAILED (%s)", errorType)
+}
+
+// paypalOrderHandler creates an order with intent CAPTURE and captures it at once, as
+// PayPal does for card payments that need no buyer approval
+func paypalOrderHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["paypal"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "PAYPAL")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req PayPalOrderRequest
+	var amount int64
+	err := json.Unmarshal(body, &req)
+	if err == nil && len(req.PurchaseUnits) > 0 {
+		amount, err = parseDecimalAmount(req.PurchaseUnits[0].Amount.Value)
+	}
+	if err != nil || len(req.PurchaseUnits) == 0 || req.Intent != "CAPTURE" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PayPalError{
+			Name:    "INVALID_REQUEST",
+			Message: "Request is not well-formed, syntactically incorrect, or violates schema.",
+			DebugID: strings.ToLower(generateID(13)),
+			Details: []PayPalErrorDetail{{
+				Field:       "/purchase_units/0/amount/value",
+				Issue:       "INVALID_PARAMETER_VALUE",
+				Description: "intent must be CAPTURE and purchase_units[0].amount a positive decimal value",
+			}},
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		if isTransportError(errorType) {
+			simulateError(w, errorType, statusCode, "PAYPAL")
+		} else {
+			paypalError(w, errorType, statusCode)
+		}
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	orderID := strings.ToUpper(generateID(17))
+	unit := req.PurchaseUnits[0]
+	unit.Payments = &struct {
+		Captures []PayPalCapture `json:"captures"`
+	}{Captures: []PayPalCapture{{
+		ID:           strings.ToUpper(generateID(17)),
+		Status:       "COMPLETED",
+		Amount:       unit.Amount,
+		FinalCapture: true,
+		CreateTime:   now,
+	}}}
+	resp := PayPalOrderResponse{
+		ID:            orderID,
+		Intent:        req.Intent,
+		Status:        "COMPLETED",
+		PurchaseUnits: []PayPalPurchaseUnit{unit},
+		CreateTime:    now,
+		Links: []PayPalLink{{
+			Href:   publicURL + "/paypal/v2/checkout/orders/" + orderID,
+			Rel:    "self",
+			Method: "GET",
+		}},
+	}
+
+	respBody, _ := json.Marshal(resp)
+	charges.Record(&SimCharge{
+		ID:        orderID,
+		Gateway:   "paypal",
+		PaymentID: r.Header.Get("X-Payment-ID"),
+		Amount:    amount,
+		Currency:  unit.Amount.CurrencyCode,
+		Status:    resp.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(respBody)
+	log.Printf("[PAYPAL] SUCCESS: Captured %s %s", unit.Amount.Value, unit.Amount.CurrencyCode)
+}
+
+// ============================================================================
+// ADYEN PROVIDER (Checkout API)
+// ============================================================================
+
+type AdyenAmount struct {
+	Currency string `json:"currency"`
+	Value    int64  `json:"value"`
+}
+
+type AdyenPaymentRequest struct {
+	Amount          AdyenAmount            `json:"amount"`
+	Reference       string                 `json:"reference"`
+	MerchantAccount string                 `json:"merchantAccount"`
+	PaymentMethod   map[string]interface{} `json:"paymentMethod"`
+}
+
+type AdyenPaymentResponse struct {
+	PspReference      string       `json:"pspReference"`
+	ResultCode        string       `json:"resultCode"`
+	Amount            *AdyenAmount `json:"amount,omitempty"`
+	MerchantReference string       `json:"merchantReference"`
+	RefusalReason     string       `json:"refusalReason,omitempty"`
+	RefusalReasonCode string       `json:"refusalReasonCode,omitempty"`
+}
+
+type AdyenError struct {
+	Status       int    `json:"status"`
+	ErrorCode    string `json:"errorCode"`
+	Message      string `json:"message"`
+	ErrorType    string `json:"errorType"`
+	PspReference string `json:"pspReference,omitempty"`
+}
+
+// adyenRefusals are the refusal reasons Adyen reports for declines, by error type
+var adyenRefusals = map[ErrorCode][2]string{
+	ErrInsufficientFunds: {"Not enough balance", "12"},
+	ErrCardDeclined:      {"Refused", "2"},
+}
+
+// adyenError answers in Adyen's error format. Declines are not errors for Adyen: they
+// are a 200 with resultCode Refused
+func adyenError(w http.ResponseWriter, errorType ErrorCode, statusCode int, req AdyenPaymentRequest) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case isDecline(errorType):
+		refusal := adyenRefusals[errorType]
+		json.NewEncoder(w).Encode(AdyenPaymentResponse{
+			PspReference:      generateDigits(16),
+			ResultCode:        "Refused",
+			MerchantReference: req.Reference,
+			RefusalReason:     refusal[0],
+			RefusalReasonCode: refusal[1],
+		})
+	case errorType == ErrAuthFailed:
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AdyenError{
+			Status:    http.StatusUnauthorized,
+			ErrorCode: "000",
+			Message:   "HTTP Status Response - Unauthorized",
+			ErrorType: "security",
+		})
+	default:
+		if statusCode < 500 {
+			statusCode = http.StatusInternalServerError
+		}
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(AdyenError{
+			Status:       statusCode,
+			ErrorCode:    "901",
+			Message:      getErrorMessage(errorType),
+			ErrorType:    "internal",
+			PspReference: generateDigits(16),
+		})
+	}
+	log.Printf("[ADYEN] FAILED (%s)", errorType)
+}
+
+func adyenPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["adyen"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "ADYEN")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req AdyenPaymentRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AdyenError{
+			Status:    http.StatusBadRequest,
+			ErrorCode: "702",
+			Message:   "Structure of PaymentRequest contains the following unknown fields or is not valid JSON",
+			ErrorType: "validation",
+		})
+		return
+	}
+	if req.MerchantAccount == "" || req.Amount.Value <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(AdyenError{
+			Status:    http.StatusUnprocessableEntity,
+			ErrorCode: "14_0412",
+			Message:   "Required fields 'merchantAccount' and a positive 'amount.value' are not provided",
+			ErrorType: "validation",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		if isTransportError(errorType) {
+			simulateError(w, errorType, statusCode, "ADYEN")
+		} else {
+			adyenError(w, errorType, statusCode, req)
+		}
+		return
+	}
+
+	resp := AdyenPaymentResponse{
+		PspReference:      generateDigits(16),
+		ResultCode:        "Authorised",
+		Amount:            &req.Amount,
+		MerchantReference: req.Reference,
+	}
+
+	respBody, _ := json.Marshal(resp)
+	charges.Record(&SimCharge{
+		ID:        resp.PspReference,
+		Gateway:   "adyen",
+		PaymentID: r.Header.Get("X-Payment-ID"),
+		Amount:    req.Amount.Value,
+		Currency:  req.Amount.Currency,
+		Status:    resp.ResultCode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respBody)
+	log.Printf("[ADYEN] SUCCESS: Authorised %d %s", req.Amount.Value, req.Amount.Currency)
+}
+
+// ============================================================================
+// BRAINTREE PROVIDER (GraphQL chargePaymentMethod)
+// ============================================================================
+
+type BraintreeChargeRequest struct {
+	Query     string `json:"query"`
+	Variables struct {
+		Input struct {
+			PaymentMethodID string `json:"paymentMethodId"`
+			Transaction     struct {
+				Amount            string `json:"amount"`
+				OrderID           string `json:"orderId"`
+				MerchantAccountID string `json:"merchantAccountId"`
+			} `json:"transaction"`
+		} `json:"input"`
+	} `json:"variables"`
+}
+
+type BraintreeMoney struct {
+	Value        string `json:"value"`
+	CurrencyCode string `json:"currencyCode"`
+}
+
+type BraintreeProcessorResponse struct {
+	LegacyCode string `json:"legacyCode"`
+	Message    string `json:"message"`
+}
+
+type BraintreeTransaction struct {
+	ID                string                     `json:"id"`
+	LegacyID          string                     `json:"legacyId"`
+	Status            string                     `json:"status"`
+	Amount            BraintreeMoney             `json:"amount"`
+	OrderID           string                     `json:"orderId,omitempty"`
+	CreatedAt         string                     `json:"createdAt"`
+	ProcessorResponse BraintreeProcessorResponse `json:"processorResponse"`
+}
+
+type BraintreeGraphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		ErrorClass string   `json:"errorClass"`
+		LegacyCode string   `json:"legacyCode,omitempty"`
+		InputPath  []string `json:"inputPath,omitempty"`
+	} `json:"extensions"`
+}
+
+// braintreeDeclines are the processor responses Braintree reports for declines
+var braintreeDeclines = map[ErrorCode]BraintreeProcessorResponse{
+	ErrInsufficientFunds: {LegacyCode: "2001", Message: "Insufficient Funds"},
+	ErrCardDeclined:      {LegacyCode: "2000", Message: "Do Not Honor"},
+}
+
+// braintreeResponse wraps a chargePaymentMethod result in the GraphQL envelope
+func braintreeResponse(transaction *BraintreeTransaction, errors []BraintreeGraphQLError) map[string]interface{} {
+	resp := map[string]interface{}{
+		"extensions": map[string]string{"requestId": strings.ToLower(generateID(32))},
+	}
+	if transaction != nil {
+		resp["data"] = map[string]interface{}{
+			"chargePaymentMethod": map[string]interface{}{"transaction": transaction},
+		}
+	} else {
+		resp["data"] = map[string]interface{}{"chargePaymentMethod": nil}
+	}
+	if len(errors) > 0 {
+		resp["errors"] = errors
+	}
+	return resp
+}
+
+func newBraintreeTransaction(req BraintreeChargeRequest, status string, processor BraintreeProcessorResponse) *BraintreeTransaction {
+	legacyID := strings.ToLower(generateID(8))
+	return &BraintreeTransaction{
+		ID:                base64.StdEncoding.EncodeToString([]byte("transaction_" + legacyID)),
+		LegacyID:          legacyID,
+		Status:            status,
+		Amount:            BraintreeMoney{Value: req.Variables.Input.Transaction.Amount, CurrencyCode: "USD"},
+		OrderID:           req.Variables.Input.Transaction.OrderID,
+		CreatedAt:         time.Now().UTC().Format(time.RFC3339),
+		ProcessorResponse: processor,
+	}
+}
+
+// braintreeError answers in Braintree's GraphQL format. Like all GraphQL errors these
+// are HTTP 200 except authentication and server failures; declines are a transaction
+// with status PROCESSOR_DECLINED
+func braintreeError(w http.ResponseWriter, errorType ErrorCode, statusCode int, req BraintreeChargeRequest) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var gqlErr BraintreeGraphQLError
+	switch {
+	case isDecline(errorType):
+		transaction := newBraintreeTransaction(req, "PROCESSOR_DECLINED", braintreeDeclines[errorType])
+		json.NewEncoder(w).Encode(braintreeResponse(transaction, nil))
+		log.Printf("[BRAINTREE] DECLINED (%s)", errorType)
+		return
+	case errorType == ErrAuthFailed:
+		w.WriteHeader(http.StatusUnauthorized)
+		gqlErr.Message = "Authentication credentials are invalid"
+		gqlErr.Extensions.ErrorClass = "AUTHENTICATION"
+	default:
+		if statusCode < 500 {
+			statusCode = http.StatusInternalServerError
+		}
+		w.WriteHeader(statusCode)
+		gqlErr.Message = "An unexpected error occurred"
+		gqlErr.Extensions.ErrorClass = "INTERNAL"
+	}
+	json.NewEncoder(w).Encode(braintreeResponse(nil, []BraintreeGraphQLError{gqlErr}))
+	log.Printf("[BRAINTREE] FAILED (%s)", errorType)
+}
+
+func braintreeChargeHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["braintree"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "BRAINTREE")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req BraintreeChargeRequest
+	var amount int64
+	err := json.Unmarshal(body, &req)
+	if err == nil {
+		amount, err = parseDecimalAmount(req.Variables.Input.Transaction.Amount)
+	}
+	if err != nil || req.Variables.Input.PaymentMethodID == "" {
+		var gqlErr BraintreeGraphQLError
+		gqlErr.Message = "Variable 'input' has an invalid value: paymentMethodId and a positive transaction.amount are required"
+		gqlErr.Extensions.ErrorClass = "VALIDATION"
+		gqlErr.Extensions.LegacyCode = "81503"
+		gqlErr.Extensions.InputPath = []string{"input", "transaction", "amount"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(braintreeResponse(nil, []BraintreeGraphQLError{gqlErr}))
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		if isTransportError(errorType) {
+			simulateError(w, errorType, statusCode, "BRAINTREE")
+		} else {
+			braintreeError(w, errorType, statusCode, req)
+		}
+		return
+	}
+
+	transaction := newBraintreeTransaction(req, "SUBMITTED_FOR_SETTLEMENT", BraintreeProcessorResponse{LegacyCode: "1000", Message: "Approved"})
+
+	respBody, _ := json.Marshal(braintreeResponse(transaction, nil))
+	charges.Record(&SimCharge{
+		ID:        transaction.ID,
+		Gateway:   "braintree",
+		PaymentID: r.Header.Get("X-Payment-ID"),
+		Amount:    amount,
+		Currency:  transaction.Amount.CurrencyCode,
+		Status:    transaction.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respBody)
+	log.Printf("[BRAINTREE] SUCCESS: Charged %s", transaction.Amount.Value)
+}
+
+// parseDecimalAmount converts a major-unit decimal string such as "10.50", as PayPal
+// and Braintree send amounts, to minor units
+func parseDecimalAmount(value string) (int64, error) {
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount <= 0 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return int64(math.Round(amount * 100)), nil
+}
+
+// isDecline reports whether an error type is a card decline, which providers report in
+// their payment result rather than as a generic failure
+func isDecline(errorType ErrorCode) bool {
+	return errorType == ErrInsufficientFunds || errorType == ErrCardDeclined
+}
+
+// isTransportError reports whether an error type is simulated at the HTTP level the
+// same way for every provider, rather than in the provider's error format
+func isTransportError(errorType ErrorCode) bool {
+	switch errorType {
+	case ErrConnectionReset, ErrPanic, ErrMalformedResponse, ErrInvalidJSON, ErrEmptyResponse, ErrSlowResponse, ErrRateLimited,
+		ErrTruncatedResponse, ErrChunkedResponse, ErrConnectionDrop:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// STRIPE PROVIDER
+// ============================================================================
+
+type StripeChargeRequest struct {
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Source      string `json:"source"`
+	Description string `json:"description"`
+}
+
+type StripeChargeResponse struct {
+	ID             string            `json:"id"`
+	Object         string            `json:"object"`
+	Amount         int64             `json:"amount"`
+	Currency       string            `json:"currency"`
+	Status         string            `json:"status"`
+	Paid           bool              `json:"paid"`
+	Created        int64             `json:"created"`
+	FailureCode    string            `json:"failure_code,omitempty"`
+	FailureMessage string            `json:"failure_message,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+type StripeRefundResponse struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	Amount int64  `json:"amount"`
+	Status string `json:"status"`
+}
+
+func stripeChargeHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["stripe"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "STRIPE")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	webhook := config.Webhook
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req StripeChargeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "invalid_request_error",
+			"message": "Invalid JSON body",
+		})
+		return
+	}
+
+	// In async mode a declined charge is still accepted, and the decline only arrives
+	// as a charge.failed webhook
+	failed := rand.Float64() < errorRate
+	if failed && !webhook.Async {
+		simulateError(w, errorType, statusCode, "STRIPE")
+		return
+	}
+
+	paymentID := r.Header.Get("X-Payment-ID")
+	resp := StripeChargeResponse{
+		ID:       "ch_" + generateID(24),
+		Object:   "charge",
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Status:   "succeeded",
+		Paid:     true,
+		Created:  time.Now().Unix(),
+		Metadata: map[string]string{"payment_id": paymentID},
+	}
+	charge := resp
+	eventType := "charge.succeeded"
+	if failed {
+		charge.Status = "failed"
+		charge.Paid = false
+		charge.FailureCode = strings.ToLower(string(errorType))
+		charge.FailureMessage = getErrorMessage(errorType)
+		eventType = "charge.failed"
+	}
+	if webhook.Async {
+		resp.Status = "pending"
+		resp.Paid = false
+	}
+
+	respBody, _ := json.Marshal(resp)
+	charges.Record(&SimCharge{
+		ID:        charge.ID,
+		Gateway:   "stripe",
+		PaymentID: paymentID,
+		Amount:    charge.Amount,
+		Currency:  charge.Currency,
+		Status:    charge.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respBody)
+	log.Printf("[STRIPE] %s: Charged %d %s", strings.ToUpper(resp.Status), req.Amount, req.Currency)
+	emitWebhook("stripe", webhook, eventType, paymentID, charge)
+}
+
+func stripeRefundHandler(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(time.Duration(40+rand.Intn(80)) * time.Millisecond)
+
+	var req RefundRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	respBody, err := charges.Refund("stripe", req.PaymentID, req.Amount, func(refund SimRefund, _ *SimCharge) []byte {
+		body, _ := json.Marshal(StripeRefundResponse{
+			ID:     refund.ID,
+			Object: "refund",
+			Amount: refund.Amount,
+			Status: "succeeded",
+		})
+		return body
+	})
+	if err != nil {
+		w.WriteHeader(refundErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":     "failed",
+			"error_code": string(ErrInvalidRequest),
+			"error":      err.Error(),
+		})
+		log.Printf("[STRIPE] Refund rejected for %s: %v", req.PaymentID, err)
+		return
+	}
+
+	w.Write(respBody)
+	log.Printf("[STRIPE] Refund processed: %d for %s", req.Amount, req.PaymentID)
+}
+
+// ============================================================================
+// RAZORPAY PROVIDER
+// ============================================================================
+
+type RazorpayChargeRequest struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Email    string `json:"email"`
+	Contact  string `json:"contact"`
+}
+
+type RazorpayChargeResponse struct {
+	ID               string            `json:"id"`
+	Entity           string            `json:"entity"`
+	Amount           int64             `json:"amount"`
+	Currency         string            `json:"currency"`
+	Status           string            `json:"status"`
+	Method           string            `json:"method"`
+	Description      string            `json:"description"`
+	Captured         bool              `json:"captured"`
+	CreatedAt        int64             `json:"created_at"`
+	Notes            map[string]string `json:"notes,omitempty"`
+	ErrorCode        string            `json:"error_code,omitempty"`
+	ErrorDescription string            `json:"error_description,omitempty"`
+}
+
+func razorpayChargeHandler(w http.ResponseWriter, r *http.Request) {
+	gatewaysMu.RLock()
+	config := gateways["razorpay"]
+	gatewaysMu.RUnlock()
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, "RAZORPAY")
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	webhook := config.Webhook
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req RazorpayChargeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":       "BAD_REQUEST_ERROR",
+			"description": "Invalid JSON",
+		})
+		return
+	}
+
+	failed := rand.Float64() < errorRate
+	if failed && !webhook.Async {
+		simulateError(w, errorType, statusCode, "RAZORPAY")
+		return
+	}
+
+	paymentID := r.Header.Get("X-Payment-ID")
+	resp := RazorpayChargeResponse{
+		ID:          "pay_" + generateID(14),
+		Entity:      "payment",
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Status:      "captured",
+		Method:      "card",
+		Description: "Payment",
+		Captured:    true,
+		CreatedAt:   time.Now().Unix(),
+		Notes:       map[string]string{"payment_id": paymentID},
+	}
+	payment := resp
+	eventType := "payment.captured"
+	if failed {
+		payment.Status = "failed"
+		payment.Captured = false
+		payment.ErrorCode = string(errorType)
+		payment.ErrorDescription = getErrorMessage(errorType)
+		eventType = "payment.failed"
+	}
+	if webhook.Async {
+		// The router only recognises "pending"; real Razorpay would answer "authorized"
+		resp.Status = "pending"
+		resp.Captured = false
+	}
+
+	respBody, _ := json.Marshal(resp)
+	charges.Record(&SimCharge{
+		ID:        payment.ID,
+		Gateway:   "razorpay",
+		PaymentID: paymentID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Status:    payment.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(respBody)
+	log.Printf("[RAZORPAY] %s: Charged %d %s", strings.ToUpper(resp.Status), req.Amount, req.Currency)
+	emitWebhook("razorpay", webhook, eventType, paymentID, payment)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// REFUNDS (razorpay, klarna, paypal, adyen, braintree, test gateways)
+// ============================================================================
+
+type RefundRequest struct {
+	ID        string `json:"id"`
+	PaymentID string `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+}
+
+// refundHandler simulates a refund using the gateway's configured latency and error profile
+func refundHandler(w http.ResponseWriter, r *http.Request, gatewayName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gatewaysMu.RLock()
+	config, exists := gateways[gatewayName]
+	gatewaysMu.RUnlock()
+
+	if !exists {
+		http.Error(w, fmt.Sprintf("Gateway '%s' not found", gatewayName), http.StatusNotFound)
+		return
+	}
+
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, strings.ToUpper(gatewayName))
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req RefundRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Amount <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":     "failed",
+			"error_code": string(ErrInvalidRequest),
+			"error":      "Invalid refund request",
+		})
+		return
+	}
+
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, strings.ToUpper(gatewayName))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBody, err := charges.Refund(gatewayName, req.PaymentID, req.Amount, func(refund SimRefund, charge *SimCharge) []byte {
+		body, _ := json.Marshal(map[string]interface{}{
+			"status":          "success",
+			"id":              refund.ID,
+			"amount":          refund.Amount,
+			"amount_refunded": charge.AmountRefunded,
+		})
+		return body
+	})
+	if err != nil {
+		w.WriteHeader(refundErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":     "failed",
+			"error_code": string(ErrInvalidRequest),
+			"error":      err.Error(),
+		})
+		log.Printf("[%s] REFUND REJECTED for %s: %v", strings.ToUpper(gatewayName), req.PaymentID, err)
+		return
+	}
+
+	w.Write(respBody)
+	log.Printf("[%s] REFUND SUCCESS: %d for %s", strings.ToUpper(gatewayName), req.Amount, req.PaymentID)
+}
+
+// ============================================================================
+// CHARGE LEDGER
+// ============================================================================
+
+// SimCharge is a charge a simulated gateway accepted, kept so status lookups and
+// refunds can be checked against it
+type SimCharge struct {
+	ID             string      `json:"id"`
+	Gateway        string      `json:"gateway"`
+	PaymentID      string      `json:"payment_id,omitempty"` // From the caller's X-Payment-ID header
+	Amount         int64       `json:"amount"`
+	Currency       string      `json:"currency"`
+	Status         string      `json:"status"` // In the gateway's own vocabulary
+	AmountRefunded int64       `json:"amount_refunded"`
+	Refunds        []SimRefund `json:"refunds"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// SimRefund is a refund applied to a SimCharge
+type SimRefund struct {
+	ID        string    `json:"id"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	errChargeNotFound      = fmt.Errorf("no charge found for payment")
+	errChargeNotRefundable = fmt.Errorf("charge did not succeed and cannot be refunded")
+	errRefundExceedsCharge = fmt.Errorf("refund amount exceeds the charge's remaining balance")
+	errInvalidRefundAmount = fmt.Errorf("refund amount must be positive")
+)
+
+// refundableStatuses are the charge statuses, in each provider's own vocabulary, that
+// mean the money was taken and can be refunded
+var refundableStatuses = map[string]bool{
+	"succeeded":                true,
+	"captured":                 true,
+	"success":                  true,
+	"COMPLETED":                true,
+	"Authorised":               true,
+	"SUBMITTED_FOR_SETTLEMENT": true,
+}
+
+// chargeLedger holds every charge in memory. Charges are found by ID and by the
+// caller's payment ID, scoped to the gateway. Repeated requests never reach it: the
+// idempotency layer answers them with the original response
+type chargeLedger struct {
+	mu        sync.Mutex
+	charges   map[string]*SimCharge
+	byPayment map[string]*SimCharge
+}
+
+var charges = &chargeLedger{
+	charges:   make(map[string]*SimCharge),
+	byPayment: make(map[string]*SimCharge),
+}
+
+// Record stores a charge
+func (l *chargeLedger) Record(charge *SimCharge) {
+	charge.CreatedAt = time.Now().UTC()
+	charge.Refunds = []SimRefund{}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.charges[charge.ID] = charge
+	if charge.PaymentID != "" {
+		l.byPayment[charge.Gateway+":"+charge.PaymentID] = charge
+	}
+}
+
+// Get returns a copy of a gateway's charge
+func (l *chargeLedger) Get(gateway, id string) (SimCharge, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	charge, ok := l.charges[id]
+	if !ok || charge.Gateway != gateway {
+		return SimCharge{}, false
+	}
+	return l.copyOf(charge), true
+}
+
+// List returns a gateway's charges, only those for paymentID when it is set
+func (l *chargeLedger) List(gateway, paymentID string) []SimCharge {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]SimCharge, 0)
+	for _, charge := range l.charges {
+		if charge.Gateway == gateway && (paymentID == "" || charge.PaymentID == paymentID) {
+			list = append(list, l.copyOf(charge))
+		}
+	}
+	return list
+}
+
+func (l *chargeLedger) copyOf(charge *SimCharge) SimCharge {
+	c := *charge
+	c.Refunds = append([]SimRefund{}, charge.Refunds...)
+	return c
+}
+
+// Refund applies a refund to the charge behind a payment, keeping the total refunded
+// within the charged amount. build renders the gateway's response for the refund
+func (l *chargeLedger) Refund(gateway, paymentID string, amount int64, build func(refund SimRefund, charge *SimCharge) []byte) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if amount <= 0 {
+		return nil, errInvalidRefundAmount
+	}
+	charge, ok := l.byPayment[gateway+":"+paymentID]
+	if !ok {
+		return nil, errChargeNotFound
+	}
+	if !refundableStatuses[charge.Status] {
+		return nil, errChargeNotRefundable
+	}
+	if amount > charge.Amount-charge.AmountRefunded {
+		return nil, errRefundExceedsCharge
+	}
+
+	refund := SimRefund{ID: "rf_" + generateID(24), Amount: amount, CreatedAt: time.Now().UTC()}
+	if gateway == "stripe" {
+		refund.ID = "re_" + generateID(24)
+	}
+	charge.AmountRefunded += amount
+	charge.Refunds = append(charge.Refunds, refund)
+	return build(refund, charge), nil
+}
+
+// refundErrorStatus maps ledger refund errors to HTTP status codes
+func refundErrorStatus(err error) int {
+	if err == errChargeNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// chargesHandler serves GET /{gateway}/charges?payment_id= and GET /{gateway}/charges/{id}
+func chargesHandler(w http.ResponseWriter, r *http.Request, gatewayName, chargeID string) {
+	w.Header().Set("Content-Type", "application/json")
+	if chargeID == "" {
+		list := charges.List(gatewayName, r.URL.Query().Get("payment_id"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"charges": list,
+			"total":   len(list),
+		})
+		return
+	}
+
+	charge, ok := charges.Get(gatewayName, chargeID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error_code": string(ErrInvalidRequest),
+			"error":      fmt.Sprintf("Charge '%s' not found", chargeID),
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(charge)
+}
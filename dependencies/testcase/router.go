@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ROUTER
+// ============================================================================
+
+// throttledWriter paces a response to a byte rate, flushing as it goes so the client
+// sees a slow link rather than one late burst
+type throttledWriter struct {
+	http.ResponseWriter
+	bytesPerSecond int
+}
+
+// throttleChunk is how many bytes go out between pauses
+const throttleChunk = 64
+
+func (tw *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := min(written+throttleChunk, len(b))
+		n, err := tw.ResponseWriter.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		tw.Flush()
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(tw.bytesPerSecond))
+	}
+	return written, nil
+}
+
+func (tw *throttledWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (tw *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := tw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("webserver doesn't support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// bandwidthHandler throttles every response of a gateway with a bandwidth limit set
+func bandwidthHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gateway, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		gatewaysMu.RLock()
+		config, ok := gateways[gateway]
+		gatewaysMu.RUnlock()
+
+		bandwidth := 0
+		if ok {
+			config.mu.RLock()
+			bandwidth = config.BandwidthBps
+			config.mu.RUnlock()
+		}
+		if bandwidth <= 0 {
+			next(w, r)
+			return
+		}
+		next(&throttledWriter{ResponseWriter: w, bytesPerSecond: bandwidth}, r)
+	}
+}
+
+// traceHandler echoes the caller's X-Correlation-ID and X-Payment-ID back on the
+// response and logs them, so simulator logs can be matched to backend traces. The
+// writer is passed through untouched since connection-reset simulation hijacks it
+func traceHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		correlationID := r.Header.Get("X-Correlation-ID")
+		paymentID := r.Header.Get("X-Payment-ID")
+		if correlationID != "" {
+			w.Header().Set("X-Correlation-ID", correlationID)
+		}
+		if paymentID != "" {
+			w.Header().Set("X-Payment-ID", paymentID)
+		}
+		if correlationID != "" || paymentID != "" {
+			log.Printf("[TRACE] %s %s correlation_id=%s payment_id=%s", r.Method, r.URL.Path, correlationID, paymentID)
+		}
+		next(w, r)
+	}
+}
+
+func routeHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	if len(parts) == 0 || parts[0] == "" {
+		http.Error(w, "Gateway/Provider name required", http.StatusBadRequest)
+		return
+	}
+
+	gateway := parts[0]
+
+	// Gateways left out by -providers are not served at all
+	if gateway != "control" && gateway != "health" {
+		gatewaysMu.RLock()
+		_, enabled := gateways[gateway]
+		gatewaysMu.RUnlock()
+		if !enabled {
+			http.Error(w, fmt.Sprintf("Unknown gateway/provider: %s", gateway), http.StatusNotFound)
+			return
+		}
+	}
+
+	if len(parts) > 1 && parts[1] == "health" {
+		gatewayHealthHandler(w, r, gateway)
+		return
+	}
+
+	if len(parts) > 1 && parts[1] == "charges" && r.Method == http.MethodGet {
+		chargeID := ""
+		if len(parts) > 2 {
+			chargeID = parts[2]
+		}
+		chargesHandler(w, r, gateway, chargeID)
+		return
+	}
+
+	if len(parts) > 1 && parts[1] == "refunds" && gateway != "stripe" {
+		refundHandler(w, r, gateway)
+		return
+	}
+
+	switch gateway {
+	case "stripe":
+		if len(parts) > 1 {
+			switch parts[1] {
+			case "charges":
+				stripeChargeHandler(w, r)
+			case "refunds":
+				stripeRefundHandler(w, r)
+			case "payouts":
+				payoutHandler(w, r, "stripe")
+			default:
+				http.NotFound(w, r)
+			}
+		} else {
+			stripeChargeHandler(w, r)
+		}
+	case "razorpay":
+		if len(parts) > 1 && parts[1] == "payouts" {
+			payoutHandler(w, r, "razorpay")
+		} else {
+			razorpayChargeHandler(w, r)
+		}
+	case "klarna":
+		klarnaSessionHandler(w, r)
+	case "paypal":
+		paypalOrderHandler(w, r)
+	case "adyen":
+		adyenPaymentHandler(w, r)
+	case "braintree":
+		braintreeChargeHandler(w, r)
+	case "onfido":
+		onfidoCheckHandler(w, r)
+	case "sanctions":
+		sanctionsScreenHandler(w, r)
+	case "test1", "test2", "test3":
+		testGatewayHandler(w, r, gateway)
+	case "control":
+		if len(parts) > 1 && parts[1] == "scenario" {
+			scenarioHandler(w, r)
+		} else if len(parts) > 1 && parts[1] == "recording" {
+			recordingControlHandler(w, r)
+		} else {
+			controlHandler(w, r)
+		}
+	case "health":
+		healthHandler(w, r)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown gateway/provider: %s", gateway), http.StatusNotFound)
+	}
+}
+
+// gatewayHealthHandler answers a single gateway's health probe. It fails at the
+// gateway's error rate when its configured errors are server-side (5xx), so outages
+// show up in probes while card declines don't
+func gatewayHealthHandler(w http.ResponseWriter, r *http.Request, gatewayName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gatewaysMu.RLock()
+	config, exists := gateways[gatewayName]
+	gatewaysMu.RUnlock()
+
+	if !exists {
+		http.Error(w, fmt.Sprintf("Gateway '%s' not found", gatewayName), http.StatusNotFound)
+		return
+	}
+
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	time.Sleep(latency)
+
+	w.Header().Set("Content-Type", "application/json")
+	if statusCode >= 500 && rand.Float64() < errorRate {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "unhealthy",
+			"gateway": gatewayName,
+		})
+		log.Printf("[%s] HEALTH CHECK FAILED", strings.ToUpper(gatewayName))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "healthy",
+		"gateway": gatewayName,
+	})
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	gatewaysMu.RLock()
+	statuses := make(map[string]string)
+	for name := range gateways {
+		statuses[name] = "operational"
+	}
+	gatewaysMu.RUnlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "healthy",
+		"gateways":  statuses,
+		"timestamp": time.Now().Unix(),
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// TEST GATEWAYS (test1, test2, test3) - Simple Generic Responses
+// ============================================================================
+
+func testGatewayHandler(w http.ResponseWriter, r *http.Request, gatewayName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gatewaysMu.RLock()
+	config, exists := gateways[gatewayName]
+	gatewaysMu.RUnlock()
+
+	if !exists {
+		http.Error(w, fmt.Sprintf("Gateway '%s' not found", gatewayName), http.StatusNotFound)
+		return
+	}
+
+	// Only the amount and currency are kept, for the charge ledger
+	var req struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	json.Unmarshal(body, &req)
+
+	// Check rate limit
+	if config.CheckRateLimit() {
+		simulateError(w, ErrRateLimited, http.StatusTooManyRequests, strings.ToUpper(gatewayName))
+		return
+	}
+
+	// Get current config values
+	config.mu.RLock()
+	latency := config.LatencyDist.Sample(config.LatencyMs)
+	errorRate := config.ErrorRate
+	errorType := config.ErrorType
+	statusCode := config.StatusCode
+	config.mu.RUnlock()
+
+	// Simulate latency
+	time.Sleep(latency)
+
+	// Simulate errors based on error rate
+	if rand.Float64() < errorRate {
+		simulateError(w, errorType, statusCode, strings.ToUpper(gatewayName))
+	} else {
+		// Success response
+		chargeID := "ch_" + generateID(24)
+		respBody, _ := json.Marshal(map[string]interface{}{
+			"status":  "success",
+			"id":      chargeID,
+			"message": "Payment processed successfully",
+		})
+		charges.Record(&SimCharge{
+			ID:        chargeID,
+			Gateway:   gatewayName,
+			PaymentID: r.Header.Get("X-Payment-ID"),
+			Amount:    req.Amount,
+			Currency:  req.Currency,
+			Status:    "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(respBody)
+		log.Printf("[%s] SUCCESS", strings.ToUpper(gatewayName))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// WEBHOOKS (stripe, razorpay)
+// ============================================================================
+
+// WebhookConfig controls the asynchronous callbacks a provider sends for its charges
+type WebhookConfig struct {
+	URL        string // Callback URL, e.g. http://localhost:3000/callbacks/stripe. Empty disables webhooks
+	Secret     string // Signing secret, the receiver's <PROVIDER>_WEBHOOK_SECRET
+	DelayMs    int    // Wait before the first delivery
+	Duplicates int    // Extra deliveries of every event, to exercise replay protection
+	Async      bool   // Answer charges as pending and report the outcome only by webhook
+}
+
+// webhookMaxAttempts is how often a delivery is tried before it is dropped
+const webhookMaxAttempts = 3
+
+// emitWebhook sends a provider event for a charge in the provider's own envelope and
+// signing scheme. Deliveries run in the background after the configured delay
+func emitWebhook(provider string, webhook WebhookConfig, eventType, paymentID string, object interface{}) {
+	if webhook.URL == "" {
+		return
+	}
+
+	var eventID string
+	var envelope map[string]interface{}
+	switch provider {
+	case "stripe":
+		eventID = "evt_" + generateID(24)
+		envelope = map[string]interface{}{
+			"id":      eventID,
+			"object":  "event",
+			"type":    eventType,
+			"created": time.Now().Unix(),
+			"data":    map[string]interface{}{"object": object},
+		}
+	case "razorpay":
+		eventID = "evt_" + generateID(14)
+		envelope = map[string]interface{}{
+			"entity":     "event",
+			"account_id": "acc_simulator",
+			"event":      eventType,
+			"contains":   []string{"payment"},
+			"payload": map[string]interface{}{
+				"payment": map[string]interface{}{"entity": object},
+			},
+			"created_at": time.Now().Unix(),
+		}
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("[%s] WEBHOOK: failed to encode %s: %v", strings.ToUpper(provider), eventType, err)
+		return
+	}
+
+	go func() {
+		time.Sleep(time.Duration(webhook.DelayMs) * time.Millisecond)
+		for delivery := 0; delivery <= webhook.Duplicates; delivery++ {
+			deliverWebhook(provider, webhook, eventID, eventType, paymentID, body)
+		}
+	}()
+}
+
+// deliverWebhook posts one delivery of an event, retrying with backoff until the
+// receiver answers 2xx. Redeliveries are re-signed, as providers do
+func deliverWebhook(provider string, webhook WebhookConfig, eventID, eventType, paymentID string, body []byte) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	backoff := 500 * time.Millisecond
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[%s] WEBHOOK: invalid URL %s: %v", strings.ToUpper(provider), webhook.URL, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		signWebhook(req, provider, webhook.Secret, eventID, body)
+
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				log.Printf("[%s] WEBHOOK: %s %s for %s delivered (%d)", strings.ToUpper(provider), eventType, eventID, paymentID, resp.StatusCode)
+				return
+			}
+			err = fmt.Errorf("receiver answered %d", resp.StatusCode)
+		}
+		log.Printf("[%s] WEBHOOK: %s %s attempt %d failed: %v", strings.ToUpper(provider), eventType, eventID, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// signWebhook adds the provider's signature headers: Stripe signs "<timestamp>.<body>"
+// in Stripe-Signature, Razorpay signs the body in X-Razorpay-Signature and sends the
+// event ID in X-Razorpay-Event-Id
+func signWebhook(req *http.Request, provider, secret, eventID string, body []byte) {
+	switch provider {
+	case "stripe":
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("Stripe-Signature", "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	case "razorpay":
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Razorpay-Signature", hex.EncodeToString(mac.Sum(nil)))
+		req.Header.Set("X-Razorpay-Event-Id", eventID)
+	}
+}
@@ -1,17 +1,30 @@
 import { adminUrls } from "../services/urls";
 
-const postOptions: RequestInit = {
-  method: "POST",
-  headers: { "Content-Type": "application/json" },
-  body: "{}",
-};
+/**
+ * /admin/* requires an admin JWT (see ADMIN_ROLES on the backend). Enabling a
+ * provider needs the operator role, disabling one needs admin.
+ */
+const adminToken = import.meta.env.VITE_ADMIN_TOKEN as string | undefined;
+
+function postOptions(): RequestInit {
+  const headers: Record<string, string> = { "Content-Type": "application/json" };
+  if (adminToken) headers.Authorization = `Bearer ${adminToken}`;
+  return { method: "POST", headers, body: "{}" };
+}
+
+function adminError(action: string, res: Response): Error {
+  if (res.status === 401 || res.status === 403) {
+    return new Error(`${action} failed: ${res.status}, set VITE_ADMIN_TOKEN to an admin JWT with the required role`);
+  }
+  return new Error(`${action} failed: ${res.status}`);
+}
 
 export async function enableProvider(provider: string): Promise<void> {
-  const res = await fetch(adminUrls.enable(provider), postOptions);
-  if (!res.ok) throw new Error(`Enable failed: ${res.status}`);
+  const res = await fetch(adminUrls.enable(provider), postOptions());
+  if (!res.ok) throw adminError("Enable", res);
 }
 
 export async function disableProvider(provider: string): Promise<void> {
-  const res = await fetch(adminUrls.disable(provider), postOptions);
-  if (!res.ok) throw new Error(`Disable failed: ${res.status}`);
+  const res = await fetch(adminUrls.disable(provider), postOptions());
+  if (!res.ok) throw adminError("Disable", res);
 }
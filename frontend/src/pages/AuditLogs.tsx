@@ -1,5 +1,5 @@
 import { useQuery } from "@tanstack/react-query";
-import type { AuditLogEntry } from "../types/auditLog";
+import type { AuditLogEntry, AuditLogsApiPage } from "../types/auditLog";
 import { mapAuditLogFromApi } from "../types/auditLog";
 import { metricsGateway } from "../services/apiGateway";
 import { logsUrl } from "../services/urls";
@@ -36,8 +36,8 @@ const AuditLogs: React.FC = () => {
   const { data: logs, isLoading, isError, error } = useQuery({
     queryKey: ["audit-logs"],
     queryFn: async (): Promise<AuditLogEntry[]> => {
-      const { data } = await metricsGateway.get<Parameters<typeof mapAuditLogFromApi>[0][]>(logsUrl);
-      return Array.isArray(data) ? data.map(mapAuditLogFromApi) : [];
+      const { data } = await metricsGateway.get<AuditLogsApiPage>(logsUrl, { params: { limit: 500 } });
+      return Array.isArray(data?.logs) ? data.logs.map(mapAuditLogFromApi) : [];
     },
   });
 
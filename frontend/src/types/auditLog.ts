@@ -16,6 +16,14 @@ export interface AuditLogApiRow {
   current_time: number;
 }
 
+/** Paginated response from the /logs API */
+export interface AuditLogsApiPage {
+  logs: AuditLogApiRow[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
 export function mapAuditLogFromApi(row: AuditLogApiRow): AuditLogEntry {
   const status: AuditLogEntry["status"] = row.status === 0 ? "Success" : "Failed";
   const date = new Date(row.current_time * 1000);